@@ -57,6 +57,7 @@ type GenericOptions func(i interface{})
 
 type GenericContext struct {
 	*http.Server
+	mux           *http.ServeMux
 	httpHandler   *process.HTTPHandler
 	healthChecker *HealthzChecker
 
@@ -184,6 +185,7 @@ func NewGenericContext(
 	}
 
 	c := &GenericContext{
+		mux:         mux,
 		httpHandler: httpHandler,
 		Server: &http.Server{
 			Handler: httpHandler.WithHandleChain(mux),
@@ -232,6 +234,12 @@ func (c *GenericContext) Run(ctx context.Context) {
 	}()
 }
 
+// RegisterDebugHandler registers handler with the given path (under debug prefix)
+// on generic endpoint, so that components can expose their internal states for debugging.
+func (c *GenericContext) RegisterDebugHandler(path string, handler http.Handler) {
+	c.mux.Handle(debugPrefix+path, handler)
+}
+
 // StartInformer starts the shared informer factories;
 // informer is reentrant, so it's no need to check if context has been started
 func (c *GenericContext) StartInformer(ctx context.Context) {
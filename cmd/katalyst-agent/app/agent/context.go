@@ -63,6 +63,10 @@ var _ Component = ComponentStub{}
 
 type PluginWrapper struct {
 	skeleton.GenericPlugin
+
+	// ShutdownFunc is optional, it's called once the agent is exiting (i.e. ctx is done) before
+	// the plugin is stopped; unlike Stop, which is also called to restart the plugin, it's only called once.
+	ShutdownFunc func() error
 }
 
 func (p *PluginWrapper) Run(ctx context.Context) {
@@ -72,6 +76,12 @@ func (p *PluginWrapper) Run(ctx context.Context) {
 
 	klog.Infof("plugin wrapper %s started", p.Name())
 	<-ctx.Done()
+	if p.ShutdownFunc != nil {
+		if err := p.ShutdownFunc(); err != nil {
+			klog.Errorf("shutdown %v failed: %v", p.Name(), err)
+		}
+	}
+
 	if err := p.Stop(); err != nil {
 		klog.Errorf("stop %v failed: %v", p.Name(), err)
 	}
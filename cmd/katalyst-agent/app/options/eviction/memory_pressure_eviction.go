@@ -18,6 +18,7 @@ package eviction
 
 import (
 	"fmt"
+	"time"
 
 	"k8s.io/apimachinery/pkg/labels"
 	cliflag "k8s.io/component-base/cli/flag"
@@ -30,6 +31,11 @@ type MemoryPressureEvictionOptions struct {
 	RSSOveruseEvictionFilter     string
 	SystemPressureSyncPeriod     int
 	SystemPressureCoolDownPeriod int
+
+	EnableMemoryBandwidthEviction            bool
+	MemoryBandwidthEvictionThreshold         float64
+	MemoryBandwidthEvictionSustainedDuration time.Duration
+	MemoryBandwidthEvictionMetricMaxAge      time.Duration
 }
 
 // NewMemoryPressureEvictionOptions returns a new MemoryPressureEvictionOptions
@@ -38,7 +44,10 @@ func NewMemoryPressureEvictionOptions() *MemoryPressureEvictionOptions {
 		SystemPressureSyncPeriod: 30,
 		// make sure the cool down period is greater than sync period in case it triggers many times eviction between
 		// two rounds of sync
-		SystemPressureCoolDownPeriod: 35,
+		SystemPressureCoolDownPeriod:             35,
+		MemoryBandwidthEvictionThreshold:         0.8,
+		MemoryBandwidthEvictionSustainedDuration: 60 * time.Second,
+		MemoryBandwidthEvictionMetricMaxAge:      2 * time.Minute,
 	}
 }
 
@@ -52,6 +61,14 @@ func (o *MemoryPressureEvictionOptions) AddFlags(fss *cliflag.NamedFlagSets) {
 		"system pressure plugin detection interval")
 	fs.IntVar(&o.SystemPressureCoolDownPeriod, "eviction-system-pressure-cool-down-period", o.SystemPressureCoolDownPeriod,
 		"the cool down time between system pressure plugin executes every two eviction")
+	fs.BoolVar(&o.EnableMemoryBandwidthEviction, "eviction-memory-bandwidth-enable", o.EnableMemoryBandwidthEviction,
+		"whether to evict reclaimed_cores pods on NUMAs suffering memory bandwidth contention")
+	fs.Float64Var(&o.MemoryBandwidthEvictionThreshold, "eviction-memory-bandwidth-threshold", o.MemoryBandwidthEvictionThreshold,
+		"the ratio of NUMA aggregate memory bandwidth to its theoretical bandwidth, above which the NUMA is considered under contention")
+	fs.DurationVar(&o.MemoryBandwidthEvictionSustainedDuration, "eviction-memory-bandwidth-sustained-duration", o.MemoryBandwidthEvictionSustainedDuration,
+		"the duration that memory bandwidth contention must last before eviction is triggered")
+	fs.DurationVar(&o.MemoryBandwidthEvictionMetricMaxAge, "eviction-memory-bandwidth-metric-max-age", o.MemoryBandwidthEvictionMetricMaxAge,
+		"the max age of memory bandwidth metrics, NUMAs with older metrics are skipped as unknown; non-positive value means disabled")
 }
 
 // ApplyTo applies MemoryPressureEvictionOptions to MemoryPressureEvictionConfiguration
@@ -65,5 +82,9 @@ func (o *MemoryPressureEvictionOptions) ApplyTo(c *eviction.MemoryPressureEvicti
 	}
 	c.SystemPressureSyncPeriod = o.SystemPressureSyncPeriod
 	c.SystemPressureCoolDownPeriod = o.SystemPressureCoolDownPeriod
+	c.EnableMemoryBandwidthEviction = o.EnableMemoryBandwidthEviction
+	c.MemoryBandwidthEvictionThreshold = o.MemoryBandwidthEvictionThreshold
+	c.MemoryBandwidthEvictionSustainedDuration = o.MemoryBandwidthEvictionSustainedDuration
+	c.MemoryBandwidthEvictionMetricMaxAge = o.MemoryBandwidthEvictionMetricMaxAge
 	return nil
 }
@@ -17,6 +17,10 @@ limitations under the License.
 package qrm
 
 import (
+	"fmt"
+	"strconv"
+	"time"
+
 	cliflag "k8s.io/component-base/cli/flag"
 
 	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
@@ -27,6 +31,7 @@ import (
 type CPUOptions struct {
 	PolicyName             string
 	ReservedCPUCores       int
+	ReserveCPUsByFullCores bool
 	SkipCPUStateCorruption bool
 
 	CPUDynamicPolicyOptions
@@ -34,13 +39,52 @@ type CPUOptions struct {
 }
 
 type CPUDynamicPolicyOptions struct {
-	EnableCPUAdvisor              bool
-	EnableCPUPressureEviction     bool
-	LoadPressureEvictionSkipPools []string
-	EnableSyncingCPUIdle          bool
-	EnableCPUIdle                 bool
-	CPUNUMAHintPreferPolicy       string
-	CPUNUMAHintPreferLowThreshold float64
+	EnableCPUAdvisor                    bool
+	EnableCPUPressureEviction           bool
+	LoadPressureEvictionSkipPools       []string
+	EnableSyncingCPUIdle                bool
+	EnableCPUIdle                       bool
+	CPUNUMAHintPreferPolicy             string
+	CPUNUMAHintPreferLowThreshold       float64
+	EnableCPUMemoryCoAllocation         bool
+	OfflineCPUsFileAbsPath              string
+	MaxNUMAsPerAllocation               int
+	EnableCPUSetDriftRepair             bool
+	ReclaimedNUMAOvercommitRatio        float64
+	CPUResourceNameAliases              []string
+	CPUNUMAHintPreferTieBreak           string
+	CPUNUMAHintFallbackOrder            string
+	EnableCPUStateRecovery              bool
+	CPUNUMADistancePenaltyCurve         map[string]string
+	CPUPlacementAdvisorSocketAbsPath    string
+	CPUPlacementAdvisorTimeout          time.Duration
+	CPUNUMAAffinityResourceName         string
+	CPUQoSReservedPools                 map[string]string
+	CPUMissingCPUsAction                string
+	EnableCPUWeightedShares             bool
+	CPUReuseCoolDown                    time.Duration
+	EnableCPUHintScores                 bool
+	MaxReclaimedEvictionsToFreeNUMA     int
+	EnableDedicatedFullPhysicalCPUsOnly bool
+	EnableCPUPlacementAnnotations       bool
+	CPUNUMAMinFreeCPUs                  int
+	CPUHintsAuditFileAbsPath            string
+	ReclaimedNUMACPUQuota               map[string]string
+	CPUNUMAPinRulesFileAbsPath          string
+	EnableCPUPrometheusMetrics          bool
+	MaxSharedDisplacementsToFreeNUMA    int
+	TrimInvalidReservedCPUs             bool
+	CPUNUMAThrottleRatioThreshold       float64
+	CPUNUMATemperatureThreshold         float64
+	CPUPreviousNUMAStickyTTL            time.Duration
+	CPUUnclassifiedPodPolicy            string
+	CPUSidecarNUMABindingMismatchPolicy string
+	CPUUnknownContainerTypePolicy       string
+	CPUNUMANamespaceReservations        map[string]string
+	CPUPreferMemoryAllocatedNUMAs       bool
+	MaxNUMABindingSharedPodsPerNUMA     int
+	EnableCPUMachineStateDiffLog        bool
+	CPUSocketAffinityFallback           bool
 }
 
 type CPUNativePolicyOptions struct {
@@ -52,13 +96,21 @@ func NewCPUOptions() *CPUOptions {
 	return &CPUOptions{
 		PolicyName:             "dynamic",
 		ReservedCPUCores:       0,
+		ReserveCPUsByFullCores: false,
 		SkipCPUStateCorruption: false,
 		CPUDynamicPolicyOptions: CPUDynamicPolicyOptions{
-			EnableCPUAdvisor:          false,
-			EnableCPUPressureEviction: false,
-			EnableSyncingCPUIdle:      false,
-			EnableCPUIdle:             false,
-			CPUNUMAHintPreferPolicy:   cpuconsts.CPUNUMAHintPreferPolicySpreading,
+			EnableCPUAdvisor:                    false,
+			EnableCPUPressureEviction:           false,
+			EnableSyncingCPUIdle:                false,
+			EnableCPUIdle:                       false,
+			CPUNUMAHintPreferPolicy:             cpuconsts.CPUNUMAHintPreferPolicySpreading,
+			CPUNUMAHintPreferTieBreak:           cpuconsts.CPUNUMAHintPreferTieBreakLowestNUMAID,
+			CPUNUMAHintFallbackOrder:            cpuconsts.CPUNUMAHintFallbackOrderNone,
+			CPUMissingCPUsAction:                cpuconsts.MissingCPUsActionTrim,
+			CPUUnclassifiedPodPolicy:            cpuconsts.UnclassifiedPodPolicySharedCores,
+			CPUSidecarNUMABindingMismatchPolicy: cpuconsts.SidecarNUMABindingMismatchPolicyFollowMain,
+			CPUUnknownContainerTypePolicy:       cpuconsts.UnknownContainerTypePolicyMain,
+			CPUPlacementAdvisorTimeout:          100 * time.Millisecond,
 			LoadPressureEvictionSkipPools: []string{
 				state.PoolNameReclaim,
 				state.PoolNameDedicated,
@@ -82,6 +134,8 @@ func (o *CPUOptions) AddFlags(fss *cliflag.NamedFlagSets) {
 		o.EnableCPUAdvisor, "Whether cpu resource plugin should enable sys-advisor")
 	fs.IntVar(&o.ReservedCPUCores, "cpu-resource-plugin-reserved",
 		o.ReservedCPUCores, "The total cores cpu resource plugin should reserve")
+	fs.BoolVar(&o.ReserveCPUsByFullCores, "cpu-resource-plugin-reserve-by-full-cores",
+		o.ReserveCPUsByFullCores, "if set true, reserved cpus are rounded up and taken by whole physical cores balanced among NUMAs")
 	fs.BoolVar(&o.SkipCPUStateCorruption, "skip-cpu-state-corruption",
 		o.SkipCPUStateCorruption, "if set true, we will skip cpu state corruption")
 	fs.BoolVar(&o.EnableCPUPressureEviction, "enable-cpu-pressure-eviction", o.EnableCPUPressureEviction,
@@ -97,6 +151,103 @@ func (o *CPUOptions) AddFlags(fss *cliflag.NamedFlagSets) {
 		"it decides hint preference calculation strategy")
 	fs.Float64Var(&o.CPUNUMAHintPreferLowThreshold, "cpu-numa-hint-prefer-low-threshold", o.CPUNUMAHintPreferLowThreshold,
 		"it indicates threshold to apply CPUNUMAHintPreferPolicy dynamically, and it's working when CPUNUMAHintPreferPolicy is set to dynamic_packing")
+	fs.BoolVar(&o.EnableCPUMemoryCoAllocation, "enable-cpu-memory-co-allocation", o.EnableCPUMemoryCoAllocation,
+		"if set true, we will demote preferred cpu hints for numa_binding pods on NUMAs without enough free memory")
+	fs.StringVar(&o.OfflineCPUsFileAbsPath, "cpu-offline-cpus-file", o.OfflineCPUsFileAbsPath,
+		"the file storing cpus (in cpuset format) that should be excluded from allocation at runtime")
+	fs.IntVar(&o.MaxNUMAsPerAllocation, "cpu-max-numas-per-allocation", o.MaxNUMAsPerAllocation,
+		"the max count of NUMA nodes a single allocation can span, non-positive value means no limit")
+	fs.BoolVar(&o.EnableCPUSetDriftRepair, "enable-cpuset-drift-repair", o.EnableCPUSetDriftRepair,
+		"if set true, we will re-apply the recorded cpuset to containers whose actual cpuset drifts from it, otherwise drift is only reported")
+	fs.Float64Var(&o.ReclaimedNUMAOvercommitRatio, "reclaimed-numa-overcommit-ratio", o.ReclaimedNUMAOvercommitRatio,
+		"if set positive, reclaimed_cores containers are admitted to a NUMA only if its allocatable * ratio minus reclaimed allocated quantity fits the request")
+	fs.StringSliceVar(&o.CPUResourceNameAliases, "cpu-resource-name-aliases", o.CPUResourceNameAliases,
+		"extended resource names treated as cpu, quantities of them are summed up when parsing requests")
+	fs.StringVar(&o.CPUNUMAHintPreferTieBreak, "cpu-numa-hint-prefer-tie-break", o.CPUNUMAHintPreferTieBreak,
+		"it decides which NUMA is preferred among NUMAs with equal score, options: lowest_numa_id, highest_numa_id, none")
+	fs.StringVar(&o.CPUNUMAHintFallbackOrder, "cpu-numa-hint-fallback-order", o.CPUNUMAHintFallbackOrder,
+		"it decides the order of non-preferred hints returned when no NUMA is eligible for preference, options: none, lowest_numa_id, most_available")
+	fs.BoolVar(&o.EnableCPUStateRecovery, "enable-cpu-state-recovery", o.EnableCPUStateRecovery,
+		"if set true, we will reconstruct cpu state by running pods and their cgroup cpusets when the checkpoint fails to be parsed")
+	fs.StringToStringVar(&o.CPUNUMADistancePenaltyCurve, "cpu-numa-distance-penalty-curve", o.CPUNUMADistancePenaltyCurve,
+		"the map from NUMA distance to the ratio in (0, 1] applied to cpu quota of shared_cores containers spanning NUMAs, "+
+			"e.g. 12=0.9,32=0.7; empty map means the penalty is disabled")
+	fs.StringVar(&o.CPUPlacementAdvisorSocketAbsPath, "cpu-placement-advisor-sock-abs-path", o.CPUPlacementAdvisorSocketAbsPath,
+		"absolute path of socket file for the external cpu placement advisor, disabled if empty")
+	fs.DurationVar(&o.CPUPlacementAdvisorTimeout, "cpu-placement-advisor-timeout", o.CPUPlacementAdvisorTimeout,
+		"timeout of each request to the external cpu placement advisor")
+	fs.StringVar(&o.CPUNUMAAffinityResourceName, "cpu-numa-affinity-resource-name", o.CPUNUMAAffinityResourceName,
+		"the additional resource name that cpu topology hints are mirrored to, disabled if empty")
+	fs.StringToStringVar(&o.CPUQoSReservedPools, "cpu-qos-reserved-pools", o.CPUQoSReservedPools,
+		"the map from QoS level (e.g. system_cores) to cpus (in cpuset format) reserved for it separately from the global reserved cpus")
+	fs.StringVar(&o.CPUMissingCPUsAction, "cpu-missing-cpus-action", o.CPUMissingCPUsAction,
+		"the action taken on allocations referencing cpus missing from the current topology, trim: trim the allocation to valid cpus; evict: trim the allocation and flag the pod for eviction")
+	fs.BoolVar(&o.EnableCPUWeightedShares, "enable-cpu-weighted-shares", o.EnableCPUWeightedShares,
+		"if set true, cpu shares of numa_binding shared_cores containers on the same NUMA are weighted by their requests and weight annotations")
+	fs.DurationVar(&o.CPUReuseCoolDown, "cpu-reuse-cool-down", o.CPUReuseCoolDown,
+		"the duration that cpus freed from numa_binding allocations are avoided by new dedicated_cores allocations "+
+			"unless no other cpu fits, non-positive value means disabled")
+	fs.BoolVar(&o.EnableCPUHintScores, "enable-cpu-hint-scores", o.EnableCPUHintScores,
+		"if set true, scores of cpu hints ranked by the NUMA hint prefer policy are attached to annotations of hints response")
+	fs.IntVar(&o.MaxReclaimedEvictionsToFreeNUMA, "cpu-max-reclaimed-evictions-to-free-numa", o.MaxReclaimedEvictionsToFreeNUMA,
+		"if set positive, at most this count of lower-priority reclaimed_cores pods are evicted to free a NUMA for a dedicated_cores "+
+			"with numa_binding pod that can't fit otherwise; it requires positive reclaimed-numa-overcommit-ratio")
+	fs.BoolVar(&o.EnableDedicatedFullPhysicalCPUsOnly, "enable-dedicated-full-pcpus-only", o.EnableDedicatedFullPhysicalCPUsOnly,
+		"if set true, requests of dedicated_cores with numa_binding containers are rounded up to whole physical cores, "+
+			"and only NUMAs with enough free full cores are hinted")
+	fs.BoolVar(&o.EnableCPUPlacementAnnotations, "enable-cpu-placement-annotations", o.EnableCPUPlacementAnnotations,
+		"if set true, NUMAs, cpuset and effective prefer policy of numa_binding containers are written back into annotations of their pods")
+	fs.IntVar(&o.CPUNUMAMinFreeCPUs, "cpu-numa-min-free-cpus", o.CPUNUMAMinFreeCPUs,
+		"the count of cpus kept free in each NUMA, it's subtracted from available cpus of each NUMA in hints calculation, "+
+			"non-positive value means disabled")
+	fs.StringVar(&o.CPUHintsAuditFileAbsPath, "cpu-hints-audit-file", o.CPUHintsAuditFileAbsPath,
+		"the file that a record of hints calculation result is appended to for each admission, disabled if empty")
+	fs.StringToStringVar(&o.ReclaimedNUMACPUQuota, "reclaimed-numa-cpu-quota", o.ReclaimedNUMACPUQuota,
+		"the map from NUMA id to the max quantity requested by reclaimed_cores containers admitted to it, "+
+			"e.g. 0=8,1=4; NUMAs absent from the map have no quota")
+	fs.StringVar(&o.CPUNUMAPinRulesFileAbsPath, "cpu-numa-pin-rules-file", o.CPUNUMAPinRulesFileAbsPath,
+		"the file (in json list format) of rules pinning numa_binding containers of pods matching label selectors to specific NUMAs, "+
+			"it's reloaded periodically; disabled if empty")
+	fs.BoolVar(&o.EnableCPUPrometheusMetrics, "enable-cpu-prometheus-metrics", o.EnableCPUPrometheusMetrics,
+		"if set true, metrics emitted by cpu plugin are exposed in prometheus exposition format on the debug endpoint as well")
+	fs.IntVar(&o.MaxSharedDisplacementsToFreeNUMA, "cpu-max-shared-displacements-to-free-numa", o.MaxSharedDisplacementsToFreeNUMA,
+		"the max count of numa_binding shared_cores pods whose cpusets can be reassigned to other NUMAs to free a NUMA "+
+			"for dedicated_cores with numa_binding, non-positive value means disabled")
+	fs.BoolVar(&o.TrimInvalidReservedCPUs, "cpu-trim-invalid-reserved-cpus", o.TrimInvalidReservedCPUs,
+		"if set true, reserved cpus absent from the machine topology are trimmed with a warning, "+
+			"otherwise the plugin fails to start with them")
+	fs.Float64Var(&o.CPUNUMAThrottleRatioThreshold, "cpu-numa-throttle-ratio-threshold", o.CPUNUMAThrottleRatioThreshold,
+		"the ratio of time that cpus of a NUMA run at throttled frequency, above which hints containing the NUMA "+
+			"are demoted to non-preferred, non-positive value means disabled")
+	fs.Float64Var(&o.CPUNUMATemperatureThreshold, "cpu-numa-temperature-threshold", o.CPUNUMATemperatureThreshold,
+		"the temperature (in celsius) of a NUMA, above which hints containing the NUMA are demoted to non-preferred, "+
+			"non-positive value means disabled")
+	fs.DurationVar(&o.CPUPreviousNUMAStickyTTL, "cpu-previous-numa-sticky-ttl", o.CPUPreviousNUMAStickyTTL,
+		"the duration that NUMAs of a container whose allocation is cleared are preferred when it's admitted again "+
+			"(e.g. after it restarts) to keep its cache warm, non-positive value means disabled")
+	fs.StringVar(&o.CPUUnclassifiedPodPolicy, "cpu-unclassified-pod-policy", o.CPUUnclassifiedPodPolicy,
+		"how pods without katalyst QoS annotations are admitted by cpu plugin, shared_cores: admit them as shared_cores; "+
+			"reclaimed_cores: admit them as reclaimed_cores; reject: reject them")
+	fs.StringVar(&o.CPUSidecarNUMABindingMismatchPolicy, "cpu-sidecar-numa-binding-mismatch-policy",
+		o.CPUSidecarNUMABindingMismatchPolicy, "how sidecars whose numa_binding annotations mismatch their main containers "+
+			"are admitted by cpu plugin, follow_main: align them with main containers with a warning; reject: reject them")
+	fs.StringVar(&o.CPUUnknownContainerTypePolicy, "cpu-unknown-container-type-policy", o.CPUUnknownContainerTypePolicy,
+		"how containers of types unknown to cpu plugin are admitted, main: admit them as main containers with a warning; "+
+			"reject: reject them")
+	fs.StringToStringVar(&o.CPUNUMANamespaceReservations, "cpu-numa-namespace-reservations", o.CPUNUMANamespaceReservations,
+		"the map from NUMA id to the namespace it's reserved for, e.g. 0=tenant-a; numa_binding containers of other namespaces "+
+			"are kept off the NUMA, and the ones of the namespace prefer it")
+	fs.BoolVar(&o.CPUPreferMemoryAllocatedNUMAs, "cpu-prefer-memory-allocated-numas", o.CPUPreferMemoryAllocatedNUMAs,
+		"if set true, numa_binding containers prefer NUMAs that memory of their pods is already allocated on "+
+			"by memory plugin (read from its checkpoint)")
+	fs.IntVar(&o.MaxNUMABindingSharedPodsPerNUMA, "cpu-max-numa-binding-shared-pods-per-numa", o.MaxNUMABindingSharedPodsPerNUMA,
+		"the max count of numa_binding shared_cores pods on each NUMA, NUMAs at the cap are excluded from candidates "+
+			"of new ones, non-positive value means no cap")
+	fs.BoolVar(&o.EnableCPUMachineStateDiffLog, "enable-cpu-machine-state-diff-log", o.EnableCPUMachineStateDiffLog,
+		"if set true, the diff of allocations in each NUMA is logged when machine state is regenerated, it's for debugging")
+	fs.BoolVar(&o.CPUSocketAffinityFallback, "cpu-socket-affinity-fallback", o.CPUSocketAffinityFallback,
+		"if set true, containers with socket_affinity enhancement fall back to NUMAs of all sockets if none of "+
+			"the NUMAs on the socket fits, otherwise their admission fails")
 	fs.StringVar(&o.CPUAllocationOption, "cpu-allocation-option",
 		o.CPUAllocationOption, "The allocation option of cpu (packed/distributed). The default value is packed."+
 			"in cases where more than one NUMA node is required to satisfy the allocation.")
@@ -109,6 +260,7 @@ func (o *CPUOptions) ApplyTo(conf *qrmconfig.CPUQRMPluginConfig) error {
 	conf.PolicyName = o.PolicyName
 	conf.EnableCPUAdvisor = o.EnableCPUAdvisor
 	conf.ReservedCPUCores = o.ReservedCPUCores
+	conf.ReserveCPUsByFullCores = o.ReserveCPUsByFullCores
 	conf.SkipCPUStateCorruption = o.SkipCPUStateCorruption
 	conf.EnableCPUPressureEviction = o.EnableCPUPressureEviction
 	conf.LoadPressureEvictionSkipPools = o.LoadPressureEvictionSkipPools
@@ -118,5 +270,81 @@ func (o *CPUOptions) ApplyTo(conf *qrmconfig.CPUQRMPluginConfig) error {
 	conf.CPUAllocationOption = o.CPUAllocationOption
 	conf.CPUNUMAHintPreferPolicy = o.CPUNUMAHintPreferPolicy
 	conf.CPUNUMAHintPreferLowThreshold = o.CPUNUMAHintPreferLowThreshold
+	conf.EnableCPUMemoryCoAllocation = o.EnableCPUMemoryCoAllocation
+	conf.OfflineCPUsFileAbsPath = o.OfflineCPUsFileAbsPath
+	conf.MaxNUMAsPerAllocation = o.MaxNUMAsPerAllocation
+	conf.EnableCPUSetDriftRepair = o.EnableCPUSetDriftRepair
+	conf.ReclaimedNUMAOvercommitRatio = o.ReclaimedNUMAOvercommitRatio
+	conf.CPUResourceNameAliases = o.CPUResourceNameAliases
+	conf.CPUNUMAHintPreferTieBreak = o.CPUNUMAHintPreferTieBreak
+	conf.CPUNUMAHintFallbackOrder = o.CPUNUMAHintFallbackOrder
+	conf.EnableCPUStateRecovery = o.EnableCPUStateRecovery
+
+	conf.CPUNUMADistancePenaltyCurve = make(map[int]float64, len(o.CPUNUMADistancePenaltyCurve))
+	for distanceStr, ratioStr := range o.CPUNUMADistancePenaltyCurve {
+		distance, err := strconv.Atoi(distanceStr)
+		if err != nil {
+			return fmt.Errorf("parse numa distance: %s failed with error: %v", distanceStr, err)
+		}
+
+		ratio, err := strconv.ParseFloat(ratioStr, 64)
+		if err != nil {
+			return fmt.Errorf("parse penalty ratio: %s failed with error: %v", ratioStr, err)
+		}
+
+		conf.CPUNUMADistancePenaltyCurve[distance] = ratio
+	}
+	conf.CPUPlacementAdvisorSocketAbsPath = o.CPUPlacementAdvisorSocketAbsPath
+	conf.CPUPlacementAdvisorTimeout = o.CPUPlacementAdvisorTimeout
+	conf.CPUNUMAAffinityResourceName = o.CPUNUMAAffinityResourceName
+	conf.CPUQoSReservedPools = o.CPUQoSReservedPools
+	conf.CPUMissingCPUsAction = o.CPUMissingCPUsAction
+	conf.EnableCPUWeightedShares = o.EnableCPUWeightedShares
+	conf.CPUReuseCoolDown = o.CPUReuseCoolDown
+	conf.EnableCPUHintScores = o.EnableCPUHintScores
+	conf.MaxReclaimedEvictionsToFreeNUMA = o.MaxReclaimedEvictionsToFreeNUMA
+	conf.EnableDedicatedFullPhysicalCPUsOnly = o.EnableDedicatedFullPhysicalCPUsOnly
+	conf.EnableCPUPlacementAnnotations = o.EnableCPUPlacementAnnotations
+	conf.CPUNUMAMinFreeCPUs = o.CPUNUMAMinFreeCPUs
+	conf.CPUHintsAuditFileAbsPath = o.CPUHintsAuditFileAbsPath
+
+	conf.ReclaimedNUMACPUQuota = make(map[int]float64, len(o.ReclaimedNUMACPUQuota))
+	for numaStr, quotaStr := range o.ReclaimedNUMACPUQuota {
+		numaID, err := strconv.Atoi(numaStr)
+		if err != nil {
+			return fmt.Errorf("parse numa id: %s failed with error: %v", numaStr, err)
+		}
+
+		quota, err := strconv.ParseFloat(quotaStr, 64)
+		if err != nil {
+			return fmt.Errorf("parse reclaimed cpu quota: %s failed with error: %v", quotaStr, err)
+		}
+
+		conf.ReclaimedNUMACPUQuota[numaID] = quota
+	}
+	conf.CPUNUMAPinRulesFileAbsPath = o.CPUNUMAPinRulesFileAbsPath
+	conf.EnableCPUPrometheusMetrics = o.EnableCPUPrometheusMetrics
+	conf.MaxSharedDisplacementsToFreeNUMA = o.MaxSharedDisplacementsToFreeNUMA
+	conf.TrimInvalidReservedCPUs = o.TrimInvalidReservedCPUs
+	conf.CPUNUMAThrottleRatioThreshold = o.CPUNUMAThrottleRatioThreshold
+	conf.CPUNUMATemperatureThreshold = o.CPUNUMATemperatureThreshold
+	conf.CPUPreviousNUMAStickyTTL = o.CPUPreviousNUMAStickyTTL
+	conf.CPUUnclassifiedPodPolicy = o.CPUUnclassifiedPodPolicy
+	conf.CPUSidecarNUMABindingMismatchPolicy = o.CPUSidecarNUMABindingMismatchPolicy
+	conf.CPUUnknownContainerTypePolicy = o.CPUUnknownContainerTypePolicy
+
+	conf.CPUNUMANamespaceReservations = make(map[int]string, len(o.CPUNUMANamespaceReservations))
+	for numaStr, namespace := range o.CPUNUMANamespaceReservations {
+		numaID, err := strconv.Atoi(numaStr)
+		if err != nil {
+			return fmt.Errorf("parse numa id: %s failed with error: %v", numaStr, err)
+		}
+
+		conf.CPUNUMANamespaceReservations[numaID] = namespace
+	}
+	conf.CPUPreferMemoryAllocatedNUMAs = o.CPUPreferMemoryAllocatedNUMAs
+	conf.MaxNUMABindingSharedPodsPerNUMA = o.MaxNUMABindingSharedPodsPerNUMA
+	conf.EnableCPUMachineStateDiffLog = o.EnableCPUMachineStateDiffLog
+	conf.CPUSocketAffinityFallback = o.CPUSocketAffinityFallback
 	return nil
 }
@@ -17,24 +17,30 @@ limitations under the License.
 package qrm
 
 import (
+	"time"
+
 	cliflag "k8s.io/component-base/cli/flag"
 
 	qrmconfig "github.com/kubewharf/katalyst-core/pkg/config/agent/qrm"
 )
 
 type GenericQRMPluginOptions struct {
-	QRMPluginSocketDirs      []string
-	StateFileDirectory       string
-	ExtraStateFileAbsPath    string
-	PodDebugAnnoKeys         []string
-	UseKubeletReservedConfig bool
+	QRMPluginSocketDirs       []string
+	StateFileDirectory        string
+	ExtraStateFileAbsPath     string
+	ExtraStateFileAbsPaths    []string
+	ExtraStateFileReadTimeout time.Duration
+	PodDebugAnnoKeys          []string
+	UseKubeletReservedConfig  bool
 }
 
 func NewGenericQRMPluginOptions() *GenericQRMPluginOptions {
 	return &GenericQRMPluginOptions{
-		QRMPluginSocketDirs: []string{"/var/lib/kubelet/plugins_registry"},
-		StateFileDirectory:  "/var/lib/katalyst/qrm_advisor",
-		PodDebugAnnoKeys:    []string{},
+		QRMPluginSocketDirs:       []string{"/var/lib/kubelet/plugins_registry"},
+		StateFileDirectory:        "/var/lib/katalyst/qrm_advisor",
+		ExtraStateFileReadTimeout: time.Second,
+		ExtraStateFileAbsPaths:    []string{},
+		PodDebugAnnoKeys:          []string{},
 	}
 }
 
@@ -45,6 +51,11 @@ func (o *GenericQRMPluginOptions) AddFlags(fss *cliflag.NamedFlagSets) {
 		o.QRMPluginSocketDirs, "socket file directories that qrm plugins communicate witch other components")
 	fs.StringVar(&o.StateFileDirectory, "qrm-state-dir", o.StateFileDirectory, "Directory that qrm plugins are using")
 	fs.StringVar(&o.ExtraStateFileAbsPath, "qrm-extra-state-file", o.ExtraStateFileAbsPath, "The absolute path to an extra state file to specify cpuset.mems for specific pods")
+	fs.StringSliceVar(&o.ExtraStateFileAbsPaths, "qrm-extra-state-files", o.ExtraStateFileAbsPaths,
+		"The absolute paths to extra state files in precedence order, entries in earlier files win on conflict; "+
+			"the one specified by qrm-extra-state-file takes precedence over all of them")
+	fs.DurationVar(&o.ExtraStateFileReadTimeout, "qrm-extra-state-file-read-timeout", o.ExtraStateFileReadTimeout,
+		"Timeout for reading the extra state file in admission, hints will be calculated normally if it's exceeded; non-positive value means no timeout")
 	fs.StringSliceVar(&o.PodDebugAnnoKeys, "qrm-pod-debug-anno-keys",
 		o.PodDebugAnnoKeys, "pod annotations keys to identify the pod is a debug pod, and qrm plugins will apply specific strategy to it")
 	fs.BoolVar(&o.UseKubeletReservedConfig, "use-kubelet-reserved-config",
@@ -55,6 +66,16 @@ func (o *GenericQRMPluginOptions) ApplyTo(conf *qrmconfig.GenericQRMPluginConfig
 	conf.QRMPluginSocketDirs = o.QRMPluginSocketDirs
 	conf.StateFileDirectory = o.StateFileDirectory
 	conf.ExtraStateFileAbsPath = o.ExtraStateFileAbsPath
+	conf.ExtraStateFileAbsPaths = make([]string, 0, len(o.ExtraStateFileAbsPaths)+1)
+	if o.ExtraStateFileAbsPath != "" {
+		conf.ExtraStateFileAbsPaths = append(conf.ExtraStateFileAbsPaths, o.ExtraStateFileAbsPath)
+	}
+	for _, path := range o.ExtraStateFileAbsPaths {
+		if path != "" && path != o.ExtraStateFileAbsPath {
+			conf.ExtraStateFileAbsPaths = append(conf.ExtraStateFileAbsPaths, path)
+		}
+	}
+	conf.ExtraStateFileReadTimeout = o.ExtraStateFileReadTimeout
 	conf.PodDebugAnnoKeys = o.PodDebugAnnoKeys
 	conf.UseKubeletReservedConfig = o.UseKubeletReservedConfig
 	return nil
@@ -0,0 +1,305 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/events"
+
+	pluginapi "github.com/kubewharf/katalyst-api/pkg/protocol/evictionplugin/v1alpha1"
+	"github.com/kubewharf/katalyst-core/pkg/agent/evictionmanager/plugin"
+	"github.com/kubewharf/katalyst-core/pkg/client"
+	"github.com/kubewharf/katalyst-core/pkg/config"
+	"github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic"
+	evictionconfig "github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic/adminqos/eviction"
+	"github.com/kubewharf/katalyst-core/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/metric/helper"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/native"
+	"github.com/kubewharf/katalyst-core/pkg/util/process"
+)
+
+const (
+	EvictionPluginNameMemoryBandwidthPressure = "memory-bandwidth-pressure-eviction-plugin"
+	EvictionScopeMemoryBandwidth              = "MemoryBandwidth"
+
+	metricsTagValueNumaMemoryBandwidthRatio = "numa_memory_bandwidth_ratio"
+)
+
+// memoryBandwidthEvictionRankingMetrics ranks reclaimed_cores pods on the contended NUMA,
+// pods with lower priority and then higher bandwidth are evicted first
+var memoryBandwidthEvictionRankingMetrics = []string{
+	evictionconfig.FakeMetricPriority,
+	consts.MetricMemBandwidthReadContainer,
+	consts.MetricMemBandwidthWriteContainer,
+}
+
+// NewMemoryBandwidthPressureEvictionPlugin returns a new MemoryBandwidthPressurePlugin
+func NewMemoryBandwidthPressureEvictionPlugin(_ *client.GenericClientSet, _ events.EventRecorder,
+	metaServer *metaserver.MetaServer, emitter metrics.MetricEmitter, conf *config.Configuration,
+) plugin.EvictionPlugin {
+	return &MemoryBandwidthPressurePlugin{
+		pluginName:             EvictionPluginNameMemoryBandwidthPressure,
+		emitter:                emitter,
+		StopControl:            process.NewStopControl(time.Time{}),
+		metaServer:             metaServer,
+		dynamicConfig:          conf.DynamicAgentConfiguration,
+		enabled:                conf.EnableMemoryBandwidthEviction,
+		threshold:              conf.MemoryBandwidthEvictionThreshold,
+		sustainedDuration:      conf.MemoryBandwidthEvictionSustainedDuration,
+		metricMaxAge:           conf.MemoryBandwidthEvictionMetricMaxAge,
+		numaExceedStartTimeMap: make(map[int]time.Time),
+		numaUnderPressureMap:   make(map[int]bool),
+		evictionHelper:         NewEvictionHelper(emitter, metaServer, conf),
+		reclaimedPodFilter:     conf.CheckReclaimedQoSForPod,
+	}
+}
+
+// MemoryBandwidthPressurePlugin implements the EvictionPlugin interface
+// It triggers eviction of reclaimed_cores pods on NUMAs whose aggregate memory bandwidth
+// exceeds the threshold for a sustained duration
+type MemoryBandwidthPressurePlugin struct {
+	*process.StopControl
+
+	emitter            metrics.MetricEmitter
+	reclaimedPodFilter func(pod *v1.Pod) (bool, error)
+	pluginName         string
+	metaServer         *metaserver.MetaServer
+	evictionHelper     *EvictionHelper
+
+	dynamicConfig     *dynamic.DynamicAgentConfiguration
+	enabled           bool
+	threshold         float64
+	sustainedDuration time.Duration
+	metricMaxAge      time.Duration
+
+	numaExceedStartTimeMap   map[int]time.Time
+	numaUnderPressureMap     map[int]bool
+	isUnderBandwidthPressure bool
+}
+
+func (m *MemoryBandwidthPressurePlugin) Start() {
+	general.RegisterHeartbeatCheck(EvictionPluginNameMemoryBandwidthPressure, healthCheckTimeout, general.HealthzCheckStateNotReady, healthCheckTimeout)
+	return
+}
+
+func (m *MemoryBandwidthPressurePlugin) Name() string {
+	if m == nil {
+		return ""
+	}
+
+	return m.pluginName
+}
+
+func (m *MemoryBandwidthPressurePlugin) ThresholdMet(_ context.Context) (*pluginapi.ThresholdMetResponse, error) {
+	var err error
+	defer func() {
+		_ = general.UpdateHealthzStateByError(EvictionPluginNameMemoryBandwidthPressure, err)
+	}()
+
+	resp := &pluginapi.ThresholdMetResponse{
+		MetType: pluginapi.ThresholdMetType_NOT_MET,
+	}
+
+	if !m.enabled {
+		return resp, nil
+	}
+
+	err = m.detectBandwidthPressures()
+	if m.isUnderBandwidthPressure {
+		resp = &pluginapi.ThresholdMetResponse{
+			MetType:       pluginapi.ThresholdMetType_HARD_MET,
+			EvictionScope: EvictionScopeMemoryBandwidth,
+		}
+	}
+
+	return resp, nil
+}
+
+func (m *MemoryBandwidthPressurePlugin) detectBandwidthPressures() error {
+	var errList []error
+	m.isUnderBandwidthPressure = false
+	for _, numaID := range m.metaServer.CPUDetails.NUMANodes().ToSliceNoSortInt() {
+		m.numaUnderPressureMap[numaID] = false
+
+		if err := m.detectNumaBandwidthPressure(numaID); err != nil {
+			errList = append(errList, err)
+			continue
+		}
+	}
+
+	return errors.NewAggregate(errList)
+}
+
+func (m *MemoryBandwidthPressurePlugin) detectNumaBandwidthPressure(numaID int) error {
+	bandwidth, stale, err := helper.GetNumaMetricWithStaleness(m.metaServer.MetricsFetcher, m.emitter,
+		consts.MetricMemBandwidthNuma, numaID, m.metricMaxAge)
+	if err != nil {
+		_ = m.emitter.StoreInt64(metricsNameFetchMetricError, 1, metrics.MetricTypeNameCount,
+			metrics.ConvertMapToTags(map[string]string{
+				metricsTagKeyNumaID: strconv.Itoa(numaID),
+			})...)
+		delete(m.numaExceedStartTimeMap, numaID)
+		return err
+	} else if stale {
+		// pressure of the NUMA is unknown with stale metric, skip it rather than guess
+		_ = m.emitter.StoreInt64(metricsNameFetchMetricError, 1, metrics.MetricTypeNameCount,
+			metrics.ConvertMapToTags(map[string]string{
+				metricsTagKeyNumaID: strconv.Itoa(numaID),
+			})...)
+		delete(m.numaExceedStartTimeMap, numaID)
+		return fmt.Errorf("memory bandwidth metric of numa: %d collected at: %v is older than max age: %v",
+			numaID, *bandwidth.Time, m.metricMaxAge)
+	}
+
+	theoryBandwidth, err := helper.GetNumaMetric(m.metaServer.MetricsFetcher, m.emitter, consts.MetricMemBandwidthTheoryNuma, numaID)
+	if err != nil {
+		_ = m.emitter.StoreInt64(metricsNameFetchMetricError, 1, metrics.MetricTypeNameCount,
+			metrics.ConvertMapToTags(map[string]string{
+				metricsTagKeyNumaID: strconv.Itoa(numaID),
+			})...)
+		delete(m.numaExceedStartTimeMap, numaID)
+		return err
+	} else if theoryBandwidth <= 0 {
+		delete(m.numaExceedStartTimeMap, numaID)
+		return fmt.Errorf("invalid theoretical memory bandwidth: %v of numa: %d", theoryBandwidth, numaID)
+	}
+
+	// use the timestamp of metric to judge the sustained duration,
+	// since it reflects when the bandwidth was actually observed
+	now := time.Now()
+	if bandwidth.Time != nil {
+		now = *bandwidth.Time
+	}
+
+	ratio := bandwidth.Value / theoryBandwidth
+	_ = m.emitter.StoreFloat64(metricsNameNumaMetric, ratio, metrics.MetricTypeNameRaw,
+		metrics.ConvertMapToTags(map[string]string{
+			metricsTagKeyNumaID:     strconv.Itoa(numaID),
+			metricsTagKeyMetricName: metricsTagValueNumaMemoryBandwidthRatio,
+		})...)
+
+	if ratio < m.threshold {
+		delete(m.numaExceedStartTimeMap, numaID)
+		return nil
+	}
+
+	startTime, ok := m.numaExceedStartTimeMap[numaID]
+	if !ok {
+		startTime = now
+		m.numaExceedStartTimeMap[numaID] = startTime
+	}
+
+	general.Infof("numa: %d memory bandwidth ratio: %.2f exceeds threshold: %.2f since: %v, sustainedDuration: %v",
+		numaID, ratio, m.threshold, startTime, m.sustainedDuration)
+
+	if now.Sub(startTime) >= m.sustainedDuration {
+		m.isUnderBandwidthPressure = true
+		m.numaUnderPressureMap[numaID] = true
+
+		_ = m.emitter.StoreInt64(metricsNameThresholdMet, 1, metrics.MetricTypeNameCount,
+			metrics.ConvertMapToTags(map[string]string{
+				metricsTagKeyEvictionScope:  EvictionScopeMemoryBandwidth,
+				metricsTagKeyDetectionLevel: metricsTagValueDetectionLevelNuma,
+				metricsTagKeyNumaID:         strconv.Itoa(numaID),
+				metricsTagKeyAction:         metricsTagValueActionReclaimedEviction,
+			})...)
+	}
+
+	return nil
+}
+
+func (m *MemoryBandwidthPressurePlugin) GetTopEvictionPods(_ context.Context, request *pluginapi.GetTopEvictionPodsRequest) (*pluginapi.GetTopEvictionPodsResponse, error) {
+	if request == nil {
+		return nil, fmt.Errorf("GetTopEvictionPods got nil request")
+	}
+
+	if len(request.ActivePods) == 0 {
+		general.Warningf("GetTopEvictionPods got empty active pods list")
+		return &pluginapi.GetTopEvictionPodsResponse{}, nil
+	}
+
+	targetPods := make([]*v1.Pod, 0, len(request.ActivePods))
+	podToEvictMap := make(map[string]*v1.Pod)
+
+	general.Infof("GetTopEvictionPods condition, isUnderBandwidthPressure: %+v, numaUnderPressureMap: %+v",
+		m.isUnderBandwidthPressure, m.numaUnderPressureMap)
+
+	if m.enabled && m.isUnderBandwidthPressure {
+		for numaID, underPressure := range m.numaUnderPressureMap {
+			if !underPressure {
+				continue
+			}
+
+			// bandwidth of containers is collected at container level, so rank them without NUMA
+			m.evictionHelper.selectTopNPodsToEvictByMetrics(m.getCandidates(request.ActivePods, numaID), request.TopN,
+				nonExistNumaID, actionReclaimedEviction, memoryBandwidthEvictionRankingMetrics, podToEvictMap)
+		}
+	}
+
+	for uid := range podToEvictMap {
+		targetPods = append(targetPods, podToEvictMap[uid])
+	}
+
+	_ = m.emitter.StoreInt64(metricsNameNumberOfTargetPods, int64(len(targetPods)), metrics.MetricTypeNameRaw)
+	general.Infof("[memory-bandwidth-pressure-eviction-plugin] GetTopEvictionPods result, targetPods: %+v", native.GetNamespacedNameListFromSlice(targetPods))
+
+	resp := &pluginapi.GetTopEvictionPodsResponse{
+		TargetPods: targetPods,
+	}
+	if gracePeriod := m.dynamicConfig.GetDynamicConfiguration().MemoryPressureEvictionConfiguration.GracePeriod; gracePeriod > 0 {
+		resp.DeletionOptions = &pluginapi.DeletionOptions{
+			GracePeriodSeconds: gracePeriod,
+		}
+	}
+
+	return resp, nil
+}
+
+// getCandidates returns pods which use memory on the given NUMA,
+// pods without stats are also returned since we can't judge them.
+func (m *MemoryBandwidthPressurePlugin) getCandidates(pods []*v1.Pod, numaID int) []*v1.Pod {
+	result := make([]*v1.Pod, 0, len(pods))
+	for i := range pods {
+		pod := pods[i]
+		usedMem, err := helper.GetPodMetric(m.metaServer.MetricsFetcher, m.emitter, pod,
+			consts.MetricsMemTotalPerNumaContainer, numaID)
+		if err == nil && usedMem <= 0 {
+			continue
+		}
+
+		result = append(result, pod)
+	}
+
+	return result
+}
+
+func (m *MemoryBandwidthPressurePlugin) GetEvictPods(_ context.Context, request *pluginapi.GetEvictPodsRequest) (*pluginapi.GetEvictPodsResponse, error) {
+	if request == nil {
+		return nil, fmt.Errorf("GetEvictPods got nil request")
+	}
+
+	return &pluginapi.GetEvictPodsResponse{}, nil
+}
@@ -0,0 +1,278 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	apiconsts "github.com/kubewharf/katalyst-api/pkg/consts"
+	pluginapi "github.com/kubewharf/katalyst-api/pkg/protocol/evictionplugin/v1alpha1"
+	"github.com/kubewharf/katalyst-core/pkg/config"
+	"github.com/kubewharf/katalyst-core/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/metric"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+	utilMetric "github.com/kubewharf/katalyst-core/pkg/util/metric"
+)
+
+var (
+	memoryBandwidthEvictionThreshold         = 0.8
+	memoryBandwidthEvictionSustainedDuration = 60 * time.Second
+	numaTheoryBandwidth                      = 100.0
+)
+
+func makeMemoryBandwidthPressureEvictionPlugin(conf *config.Configuration) (*MemoryBandwidthPressurePlugin, error) {
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	metaServer := makeMetaServer()
+	metaServer.KatalystMachineInfo = &machine.KatalystMachineInfo{
+		CPUTopology: cpuTopology,
+	}
+	metaServer.MetricsFetcher = metric.NewFakeMetricsFetcher(metrics.DummyMetrics{})
+
+	conf.EnableMemoryBandwidthEviction = true
+	conf.MemoryBandwidthEvictionThreshold = memoryBandwidthEvictionThreshold
+	conf.MemoryBandwidthEvictionSustainedDuration = memoryBandwidthEvictionSustainedDuration
+
+	plugin := NewMemoryBandwidthPressureEvictionPlugin(nil, nil, metaServer, metrics.DummyMetrics{}, conf)
+	res := plugin.(*MemoryBandwidthPressurePlugin)
+
+	return res, nil
+}
+
+func TestMemoryBandwidthPressurePlugin_ThresholdMet(t *testing.T) {
+	t.Parallel()
+
+	plugin, err := makeMemoryBandwidthPressureEvictionPlugin(makeConf())
+	assert.NoError(t, err)
+	assert.NotNil(t, plugin)
+
+	fakeMetricsFetcher := plugin.metaServer.MetricsFetcher.(*metric.FakeMetricsFetcher)
+	assert.NotNil(t, fakeMetricsFetcher)
+
+	start := time.Now()
+	for numaID := 0; numaID < 4; numaID++ {
+		fakeMetricsFetcher.SetNumaMetric(numaID, consts.MetricMemBandwidthTheoryNuma,
+			utilMetric.MetricData{Value: numaTheoryBandwidth, Time: &start})
+	}
+
+	// the time series is fed in order, since contention must be sustained to be met
+	steps := []struct {
+		name                  string
+		elapsed               time.Duration
+		numaBandwidth         map[int]float64
+		wantMetType           pluginapi.ThresholdMetType
+		wantNumaUnderPressure map[int]bool
+	}{
+		{
+			name:    "all numas below threshold",
+			elapsed: 0,
+			numaBandwidth: map[int]float64{
+				0: 50, 1: 10, 2: 10, 3: 10,
+			},
+			wantMetType:           pluginapi.ThresholdMetType_NOT_MET,
+			wantNumaUnderPressure: map[int]bool{0: false, 1: false, 2: false, 3: false},
+		},
+		{
+			name:    "numa0 starts exceeding threshold",
+			elapsed: 10 * time.Second,
+			numaBandwidth: map[int]float64{
+				0: 90, 1: 10, 2: 10, 3: 10,
+			},
+			wantMetType:           pluginapi.ThresholdMetType_NOT_MET,
+			wantNumaUnderPressure: map[int]bool{0: false, 1: false, 2: false, 3: false},
+		},
+		{
+			name:    "numa0 exceeds threshold shorter than sustained duration",
+			elapsed: 40 * time.Second,
+			numaBandwidth: map[int]float64{
+				0: 95, 1: 10, 2: 10, 3: 10,
+			},
+			wantMetType:           pluginapi.ThresholdMetType_NOT_MET,
+			wantNumaUnderPressure: map[int]bool{0: false, 1: false, 2: false, 3: false},
+		},
+		{
+			name:    "numa0 exceeds threshold for sustained duration",
+			elapsed: 70 * time.Second,
+			numaBandwidth: map[int]float64{
+				0: 95, 1: 10, 2: 10, 3: 10,
+			},
+			wantMetType:           pluginapi.ThresholdMetType_HARD_MET,
+			wantNumaUnderPressure: map[int]bool{0: true, 1: false, 2: false, 3: false},
+		},
+		{
+			name:    "numa0 contention is cleared",
+			elapsed: 80 * time.Second,
+			numaBandwidth: map[int]float64{
+				0: 30, 1: 10, 2: 10, 3: 10,
+			},
+			wantMetType:           pluginapi.ThresholdMetType_NOT_MET,
+			wantNumaUnderPressure: map[int]bool{0: false, 1: false, 2: false, 3: false},
+		},
+		{
+			name:    "numa0 exceeds threshold again, and duration is recounted",
+			elapsed: 90 * time.Second,
+			numaBandwidth: map[int]float64{
+				0: 95, 1: 10, 2: 10, 3: 10,
+			},
+			wantMetType:           pluginapi.ThresholdMetType_NOT_MET,
+			wantNumaUnderPressure: map[int]bool{0: false, 1: false, 2: false, 3: false},
+		},
+	}
+
+	for _, step := range steps {
+		now := start.Add(step.elapsed)
+		for numaID, bandwidth := range step.numaBandwidth {
+			fakeMetricsFetcher.SetNumaMetric(numaID, consts.MetricMemBandwidthNuma,
+				utilMetric.MetricData{Value: bandwidth, Time: &now})
+		}
+
+		resp, err := plugin.ThresholdMet(context.TODO())
+		assert.NoError(t, err, step.name)
+		assert.Equal(t, step.wantMetType, resp.MetType, step.name)
+		if step.wantMetType == pluginapi.ThresholdMetType_HARD_MET {
+			assert.Equal(t, EvictionScopeMemoryBandwidth, resp.EvictionScope, step.name)
+		}
+		assert.Equal(t, step.wantNumaUnderPressure, plugin.numaUnderPressureMap, step.name)
+	}
+}
+
+func TestMemoryBandwidthPressurePlugin_ThresholdMetWithStaleMetric(t *testing.T) {
+	t.Parallel()
+
+	conf := makeConf()
+	conf.MemoryBandwidthEvictionMetricMaxAge = 10 * time.Second
+	plugin, err := makeMemoryBandwidthPressureEvictionPlugin(conf)
+	assert.NoError(t, err)
+	assert.NotNil(t, plugin)
+
+	fakeMetricsFetcher := plugin.metaServer.MetricsFetcher.(*metric.FakeMetricsFetcher)
+	assert.NotNil(t, fakeMetricsFetcher)
+
+	now := time.Now()
+	stale := now.Add(-30 * time.Second)
+	for numaID := 0; numaID < 4; numaID++ {
+		fakeMetricsFetcher.SetNumaMetric(numaID, consts.MetricMemBandwidthTheoryNuma,
+			utilMetric.MetricData{Value: numaTheoryBandwidth, Time: &now})
+	}
+
+	// numa 0 has been over threshold long enough, but its bandwidth is collected too long ago
+	plugin.numaExceedStartTimeMap[0] = now.Add(-2 * memoryBandwidthEvictionSustainedDuration)
+	fakeMetricsFetcher.SetNumaMetric(0, consts.MetricMemBandwidthNuma, utilMetric.MetricData{Value: 95, Time: &stale})
+	for numaID := 1; numaID < 4; numaID++ {
+		fakeMetricsFetcher.SetNumaMetric(numaID, consts.MetricMemBandwidthNuma, utilMetric.MetricData{Value: 10, Time: &now})
+	}
+
+	resp, err := plugin.ThresholdMet(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, pluginapi.ThresholdMetType_NOT_MET, resp.MetType)
+	assert.Equal(t, map[int]bool{0: false, 1: false, 2: false, 3: false}, plugin.numaUnderPressureMap)
+	_, tracked := plugin.numaExceedStartTimeMap[0]
+	assert.False(t, tracked)
+
+	// pressure is detected again once fresh metric arrives
+	plugin.numaExceedStartTimeMap[0] = now.Add(-2 * memoryBandwidthEvictionSustainedDuration)
+	fakeMetricsFetcher.SetNumaMetric(0, consts.MetricMemBandwidthNuma, utilMetric.MetricData{Value: 95, Time: &now})
+
+	resp, err = plugin.ThresholdMet(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, pluginapi.ThresholdMetType_HARD_MET, resp.MetType)
+	assert.Equal(t, map[int]bool{0: true, 1: false, 2: false, 3: false}, plugin.numaUnderPressureMap)
+}
+
+func TestMemoryBandwidthPressurePlugin_GetTopEvictionPods(t *testing.T) {
+	t.Parallel()
+
+	plugin, err := makeMemoryBandwidthPressureEvictionPlugin(makeConf())
+	assert.NoError(t, err)
+	assert.NotNil(t, plugin)
+
+	fakeMetricsFetcher := plugin.metaServer.MetricsFetcher.(*metric.FakeMetricsFetcher)
+	assert.NotNil(t, fakeMetricsFetcher)
+
+	makePod := func(uid, name string, qosLevel string, priority int32) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				UID:  types.UID(uid),
+				Name: name,
+				Annotations: map[string]string{
+					apiconsts.PodAnnotationQoSLevelKey: qosLevel,
+				},
+			},
+			Spec: v1.PodSpec{
+				Priority: &priority,
+				Containers: []v1.Container{
+					{
+						Name: "c",
+					},
+				},
+			},
+		}
+	}
+
+	reclaimedLowPriorityPod := makePod("000001", "pod-1", apiconsts.PodAnnotationQoSLevelReclaimedCores, lowPriority)
+	reclaimedHighPriorityPod := makePod("000002", "pod-2", apiconsts.PodAnnotationQoSLevelReclaimedCores, highPriority)
+	reclaimedOtherNumaPod := makePod("000003", "pod-3", apiconsts.PodAnnotationQoSLevelReclaimedCores, lowPriority)
+	sharedPod := makePod("000004", "pod-4", apiconsts.PodAnnotationQoSLevelSharedCores, lowPriority)
+	activePods := []*v1.Pod{reclaimedLowPriorityPod, reclaimedHighPriorityPod, reclaimedOtherNumaPod, sharedPod}
+
+	now := time.Now()
+	for _, pod := range activePods {
+		memOnNuma0 := 1024.0
+		if pod == reclaimedOtherNumaPod {
+			memOnNuma0 = 0
+		}
+		fakeMetricsFetcher.SetContainerNumaMetric(string(pod.UID), "c", "0",
+			consts.MetricsMemTotalPerNumaContainer, utilMetric.MetricData{Value: memOnNuma0, Time: &now})
+	}
+
+	// no pods are evicted without contention
+	resp, err := plugin.GetTopEvictionPods(context.TODO(), &pluginapi.GetTopEvictionPodsRequest{
+		ActivePods: activePods,
+		TopN:       1,
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.TargetPods)
+
+	plugin.isUnderBandwidthPressure = true
+	plugin.numaUnderPressureMap = map[int]bool{0: true, 1: false, 2: false, 3: false}
+
+	resp, err = plugin.GetTopEvictionPods(context.TODO(), &pluginapi.GetTopEvictionPodsRequest{
+		ActivePods: activePods,
+		TopN:       1,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []*v1.Pod{reclaimedLowPriorityPod}, resp.TargetPods)
+	assert.Nil(t, resp.DeletionOptions)
+
+	resp, err = plugin.GetTopEvictionPods(context.TODO(), &pluginapi.GetTopEvictionPodsRequest{
+		ActivePods: activePods,
+		TopN:       10,
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []*v1.Pod{reclaimedLowPriorityPod, reclaimedHighPriorityPod}, resp.TargetPods)
+}
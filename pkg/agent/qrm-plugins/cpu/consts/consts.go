@@ -31,6 +31,12 @@ const (
 	ClearResidualState         = CPUPluginDynamicPolicyName + "_clear_residual_state"
 	CheckCPUSet                = CPUPluginDynamicPolicyName + "_check_cpuset"
 	SyncCPUIdle                = CPUPluginDynamicPolicyName + "_sync_cpu_idle"
+	SyncOfflineCPUs            = CPUPluginDynamicPolicyName + "_sync_offline_cpus"
+	ReconcileCPUSet            = CPUPluginDynamicPolicyName + "_reconcile_cpuset"
+	SyncWeightedShares         = CPUPluginDynamicPolicyName + "_sync_weighted_shares"
+	SyncNUMADistancePenalty    = CPUPluginDynamicPolicyName + "_sync_numa_distance_penalty"
+	SyncPlacementAnnotations   = CPUPluginDynamicPolicyName + "_sync_placement_annotations"
+	SyncNUMAPinRules           = CPUPluginDynamicPolicyName + "_sync_numa_pin_rules"
 	CommunicateWithAdvisor     = CPUPluginDynamicPolicyName + "_communicate_with_advisor"
 )
 
@@ -38,6 +44,180 @@ const (
 	// CPUStateAnnotationKeyNUMAHint is the key stored in allocationInfo.Annotations
 	// to indicate NUMA hint for the entry
 	CPUStateAnnotationKeyNUMAHint = "numa_hint"
+
+	// CPUStateAnnotationKeyMissingCPUs is the key stored in allocationInfo.Annotations
+	// to indicate cpus missing from the current topology that the entry was allocated with,
+	// entries with it are flagged to be evicted.
+	CPUStateAnnotationKeyMissingCPUs = "missing_cpus"
+
+	// CPUStateAnnotationKeyFreeNUMAEviction is the key stored in allocationInfo.Annotations
+	// to indicate the NUMA that the reclaimed_cores entry is evicted from to make room for
+	// a dedicated_cores with numa_binding pod, entries with it are flagged to be evicted.
+	CPUStateAnnotationKeyFreeNUMAEviction = "free_numa_eviction"
+
+	// CPUStateAnnotationKeyPreferPolicy is the key stored in allocationInfo.Annotations to record the
+	// NUMA hint prefer policy that took effect when the numa_binding shared_cores entry is admitted.
+	CPUStateAnnotationKeyPreferPolicy = "prefer_policy"
+)
+
+const (
+	// TopologyAwareQuantityAnnotationKeyNUMAOccupancy is the key in annotations of allocatable
+	// topology aware quantities to report occupancy status of each NUMA.
+	TopologyAwareQuantityAnnotationKeyNUMAOccupancy = "katalyst.kubewharf.io/numa_occupancy"
+
+	// NUMAOccupancyFree means no container is running on the NUMA.
+	NUMAOccupancyFree = "free"
+	// NUMAOccupancyShared means the NUMA is shared by containers without locking it.
+	NUMAOccupancyShared = "shared"
+	// NUMAOccupancyExclusive means the NUMA is locked by dedicated_cores containers with numa_binding.
+	NUMAOccupancyExclusive = "exclusive"
+)
+
+const (
+	// PodAnnotationCPUPlacementNUMAs is the key in pod annotations written back by the cpu plugin
+	// to record NUMAs (e.g. 0-1) that the main container of a numa_binding pod is allocated to.
+	PodAnnotationCPUPlacementNUMAs = "katalyst.kubewharf.io/cpu_placement_numas"
+	// PodAnnotationCPUPlacementCPUSet is the key in pod annotations written back by the cpu plugin
+	// to record cpuset that the main container of a numa_binding pod is allocated with.
+	PodAnnotationCPUPlacementCPUSet = "katalyst.kubewharf.io/cpu_placement_cpuset"
+	// PodAnnotationCPUPlacementPreferPolicy is the key in pod annotations written back by the cpu plugin
+	// to record the NUMA hint prefer policy that took effect when the numa_binding shared_cores pod is admitted.
+	PodAnnotationCPUPlacementPreferPolicy = "katalyst.kubewharf.io/cpu_placement_prefer_policy"
+)
+
+const (
+	// ResourceHintsAnnotationKeyCPUHintScores is the key in annotations of hints response to carry
+	// scores (in json, eg. {"0": 80, "1-2": 35}) of cpu hints keyed by their NUMA nodes, hints with
+	// higher scores are better according to the NUMA hint prefer policy.
+	ResourceHintsAnnotationKeyCPUHintScores = "katalyst.kubewharf.io/cpu_hint_scores"
+)
+
+const (
+	// MissingCPUsActionTrim trims allocations referencing missing cpus to valid cpus only.
+	MissingCPUsActionTrim = "trim"
+	// MissingCPUsActionEvict trims allocations referencing missing cpus and flags the pods to be evicted.
+	MissingCPUsActionEvict = "evict"
+)
+
+const (
+	// UnclassifiedPodPolicySharedCores admits pods without katalyst QoS annotations as shared_cores.
+	UnclassifiedPodPolicySharedCores = "shared_cores"
+	// UnclassifiedPodPolicyReclaimedCores admits pods without katalyst QoS annotations as reclaimed_cores.
+	UnclassifiedPodPolicyReclaimedCores = "reclaimed_cores"
+	// UnclassifiedPodPolicyReject rejects pods without katalyst QoS annotations.
+	UnclassifiedPodPolicyReject = "reject"
+)
+
+const (
+	// SidecarNUMABindingMismatchPolicyFollowMain aligns numa_binding (and numa_exclusive) annotations of sidecars
+	// with their main containers, i.e. the sidecar annotation is ignored unless the main container is NUMA bound,
+	// and sidecars of NUMA bound main containers are NUMA bound as well.
+	SidecarNUMABindingMismatchPolicyFollowMain = "follow_main"
+	// SidecarNUMABindingMismatchPolicyReject rejects sidecars whose numa_binding annotations mismatch their main containers.
+	SidecarNUMABindingMismatchPolicyReject = "reject"
+)
+
+const (
+	// UnknownContainerTypePolicyMain handles containers of types unknown to the policy as main containers.
+	UnknownContainerTypePolicyMain = "main"
+	// UnknownContainerTypePolicyReject rejects containers of types unknown to the policy.
+	UnknownContainerTypePolicyReject = "reject"
+)
+
+const (
+	// PodAnnotationCPUNUMABindingPreferredNUMA is the key in cpu enhancement annotation (katalyst.kubewharf.io/cpu_enhancement)
+	// to force the only preferred NUMA for numa_binding shared_cores containers regardless of the NUMA hint prefer policy,
+	// it's mainly used to debug or pin specific latency-critical pods.
+	PodAnnotationCPUNUMABindingPreferredNUMA = "numa_binding_preferred_numa"
+
+	// PodAnnotationCPUNUMAConstraint is the key in cpu enhancement annotation (katalyst.kubewharf.io/cpu_enhancement)
+	// to carry NUMA nodes (in cpuset format, eg. "0-1") that the pod has already been constrained to
+	// by other resources, cpu hints will only be generated within them; it takes effect only
+	// if the request itself carries no hint.
+	PodAnnotationCPUNUMAConstraint = "numa_constraint"
+
+	// PodAnnotationCPUNUMABindingGrowthFactor is the key in cpu enhancement annotation (katalyst.kubewharf.io/cpu_enhancement)
+	// to carry the factor (eg. "2.0") that numa_binding shared_cores containers are expected to scale up by,
+	// NUMAs leaving enough headroom for the scaled request are preferred, so that a later in-place resize
+	// doesn't require migration.
+	PodAnnotationCPUNUMABindingGrowthFactor = "numa_binding_growth_factor"
+
+	// PodAnnotationCPUFullPhysicalCores is the key in cpu enhancement annotation (katalyst.kubewharf.io/cpu_enhancement)
+	// (eg. "true") for dedicated_cores with numa_binding containers to be allocated with whole physical cores,
+	// the request is rounded up to the multiple of cpus per core, and only NUMAs with enough free full cores are hinted.
+	PodAnnotationCPUFullPhysicalCores       = "full_physical_cores"
+	PodAnnotationCPUFullPhysicalCoresEnable = "true"
+
+	// PodAnnotationCPUSpreadCores is the key in cpu enhancement annotation (katalyst.kubewharf.io/cpu_enhancement)
+	// (eg. "true") for dedicated_cores with numa_binding containers to be allocated with cpus spread across
+	// physical cores rather than packed, it doesn't take effect along with full_physical_cores.
+	PodAnnotationCPUSpreadCores       = "spread_cores"
+	PodAnnotationCPUSpreadCoresEnable = "true"
+
+	// PodAnnotationCPUL3CachePacking is the key in cpu enhancement annotation (katalyst.kubewharf.io/cpu_enhancement)
+	// (eg. "true") for dedicated_cores with numa_binding containers to be allocated with cpus packed within
+	// the fewest L3 cache domains, it doesn't take effect for containers taking up whole NUMAs.
+	PodAnnotationCPUL3CachePacking       = "l3_cache_packing"
+	PodAnnotationCPUL3CachePackingEnable = "true"
+
+	// PodAnnotationCPUNUMABindingSoft is the key in cpu enhancement annotation (katalyst.kubewharf.io/cpu_enhancement)
+	// (eg. "true") for numa_binding shared_cores containers to prefer single-NUMA placement softly, the container
+	// degrades to be without NUMA binding rather than being rejected if it can't fit into any single NUMA.
+	PodAnnotationCPUNUMABindingSoft       = "numa_binding_soft"
+	PodAnnotationCPUNUMABindingSoftEnable = "true"
+
+	// PodAnnotationCPUSharesWeight is the key in cpu enhancement annotation (katalyst.kubewharf.io/cpu_enhancement)
+	// to carry the weight (eg. "2.0") of numa_binding shared_cores containers, cpu shares of a NUMA are split among
+	// containers on it in proportion to their requests multiplied by weights; it's 1 if not set.
+	PodAnnotationCPUSharesWeight = "shares_weight"
+
+	// PodAnnotationCPUSidecarCPUSetMode is the key in cpu enhancement annotation (katalyst.kubewharf.io/cpu_enhancement)
+	// to decide cpuset of sidecars in dedicated_cores with numa_binding pods, see SidecarCPUSetMode* for the values;
+	// it's SidecarCPUSetModeShared if not set.
+	PodAnnotationCPUSidecarCPUSetMode = "sidecar_cpuset_mode"
+
+	// PodAnnotationCPUNUMAExclusiveMode is the key in cpu enhancement annotation (katalyst.kubewharf.io/cpu_enhancement)
+	// to decide the scope of cpu exclusivity for dedicated_cores with numa_binding and numa_exclusive containers,
+	// see NUMAExclusiveMode* for the values; it's NUMAExclusiveModeNode if not set.
+	PodAnnotationCPUNUMAExclusiveMode = "numa_exclusive_mode"
+
+	// PodAnnotationCPUSidecarReservedCPU is the key in cpu enhancement annotation (katalyst.kubewharf.io/cpu_enhancement)
+	// to declare cpu quantity (e.g. 0.5) of sidecars injected after the numa_binding shared_cores main container
+	// is admitted (e.g. by service mesh), it's reserved in the NUMA of the main container until sidecars consume it.
+	PodAnnotationCPUSidecarReservedCPU = "sidecar_reserved_cpu"
+
+	// PodAnnotationCPUCoreClass is the key in cpu enhancement annotation (katalyst.kubewharf.io/cpu_enhancement)
+	// for dedicated_cores with numa_binding containers to be allocated with cpus of the core class only
+	// (e.g. "performance" or "efficiency") on hybrid machines, it doesn't take effect for containers taking up
+	// whole NUMAs, and containers are rejected if the machine has no cpu of the class.
+	PodAnnotationCPUCoreClass = "core_class"
+
+	// PodAnnotationCPUNUMASpreadKey is the key in cpu enhancement annotation (katalyst.kubewharf.io/cpu_enhancement)
+	// for numa_binding containers to be spread across NUMAs with replicas of the same workload, its value is
+	// the label key (e.g. "app") whose value identifies the workload, and NUMAs hosting more replicas are demoted.
+	PodAnnotationCPUNUMASpreadKey = "numa_spread_key"
+
+	// PodAnnotationCPUSocketAffinity is the key in cpu enhancement annotation (katalyst.kubewharf.io/cpu_enhancement)
+	// to carry the socket id (eg. "1") that candidate NUMAs of the container are constrained to, e.g. the socket
+	// nearest the NIC of networking pods; it's combined with numa_constraint if both are set.
+	PodAnnotationCPUSocketAffinity = "socket_affinity"
+)
+
+const (
+	// NUMAExclusiveModeNode means the container takes up whole NUMAs, and only empty NUMAs are hinted.
+	NUMAExclusiveModeNode = "node"
+	// NUMAExclusiveModeCore means the container takes up whole physical cores exclusively,
+	// while the rest of its NUMAs can still be shared by other numa_binding containers.
+	NUMAExclusiveModeCore = "core"
+)
+
+const (
+	// SidecarCPUSetModeShared means sidecars share the cpuset of the main container.
+	SidecarCPUSetModeShared = "shared"
+	// SidecarCPUSetModeIsolated means sidecars get their own small cpusets carved from available cpus (excluding
+	// reserved ones) in NUMAs of the main container, e.g. for security sidecars; sidecars requesting no cpus
+	// still share the cpuset of the main container.
+	SidecarCPUSetModeIsolated = "isolated"
 )
 
 const (
@@ -59,3 +239,23 @@ const (
 	// if all nodes hit configurable threshold, use spreading policy instead.
 	CPUNUMAHintPreferPolicyDynamicPacking = "dynamic_packing"
 )
+
+const (
+	// lowest_numa_id: only the NUMA with the lowest id is preferred among NUMAs with equal score.
+	CPUNUMAHintPreferTieBreakLowestNUMAID = "lowest_numa_id"
+	// highest_numa_id: only the NUMA with the highest id is preferred among NUMAs with equal score.
+	CPUNUMAHintPreferTieBreakHighestNUMAID = "highest_numa_id"
+	// none: all NUMAs with equal score are preferred, and the topology manager makes the choice.
+	CPUNUMAHintPreferTieBreakNone = "none"
+)
+
+const (
+	// none: no hint is returned if no NUMA is eligible for preference.
+	CPUNUMAHintFallbackOrderNone = "none"
+	// lowest_numa_id: if no NUMA is eligible for preference, all candidate NUMAs are returned as
+	// non-preferred hints in ascending order of NUMA id.
+	CPUNUMAHintFallbackOrderLowestNUMAID = "lowest_numa_id"
+	// most_available: if no NUMA is eligible for preference, all candidate NUMAs are returned as
+	// non-preferred hints in descending order of available cpu quantity.
+	CPUNUMAHintFallbackOrderMostAvailable = "most_available"
+)
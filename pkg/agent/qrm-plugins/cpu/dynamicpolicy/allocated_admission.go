@@ -0,0 +1,167 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+	maputil "k8s.io/kubernetes/pkg/util/maps"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// allocateAllocatedWithRLock admits the container under the read-lock if it's already allocated and the
+// allocation meets the requirement, e.g. kubelet admits all running containers again after it restarts.
+// such admissions don't change machine state or pod entries, so they don't serialize with each other
+// on the write-lock. false is returned if the container must be admitted by allocateWithLock, i.e. it's
+// not allocated yet, or bookkeeping done under the write-lock isn't up to date for it.
+// sys-advisor is informed of the container after the read-lock is released.
+//
+// allocations of new containers still take the write-lock, since pools of all NUMAs are regenerated
+// and the whole checkpoint is written by each of them (see allocateWithNUMALocks).
+func (p *DynamicPolicy) allocateAllocatedWithRLock(ctx context.Context,
+	allocationReq *allocationRequest,
+) (*pluginapi.ResourceAllocationResponse, bool) {
+	req := allocationReq.req
+	allocationInfo, ok := p.getAllocatedWithRLock(allocationReq)
+	if !ok {
+		return nil, false
+	}
+
+	ctx = withAdmissionLogger(ctx, req)
+	if p.enableCPUAdvisor {
+		// the container is removed if it still fails after being admitted by allocateWithLock
+		if err := p.addContainerToAdvisor(ctx, allocationReq); err != nil {
+			general.LoggerFromContext(ctx).Warningf("add container to qos aware server failed with error: %v, "+
+				"retry with the write-lock", err)
+			return nil, false
+		}
+	}
+
+	p.lastSuccessfulAdmissionTimeNano.Store(time.Now().UnixNano())
+	p.clearPreviousNUMAs(req.PodUid, req.ContainerName)
+	return allocatedResponse(req, allocationReq.reqInt, allocationInfo), true
+}
+
+// getAllocatedWithRLock returns the allocation of the container if it can be admitted without the write-lock
+func (p *DynamicPolicy) getAllocatedWithRLock(allocationReq *allocationRequest) (*state.AllocationInfo, bool) {
+	req := allocationReq.req
+
+	p.RLock()
+	defer p.RUnlock()
+
+	if p.isShuttingDown() {
+		return nil, false
+	}
+
+	allocationInfo := p.state.GetAllocationInfo(req.PodUid, req.ContainerName)
+	if allocationInfo == nil || allocationInfo.OriginalAllocationResult.Size() < allocationReq.reqInt {
+		return nil, false
+	} else if !p.isNUMAMigrationUpToDate(allocationInfo) {
+		return nil, false
+	} else if p.isReclaimedNUMAEvictionEnabled() && state.CheckDedicatedNUMABinding(allocationInfo) {
+		return nil, false
+	}
+	return allocationInfo, true
+}
+
+// addAllocatedContainerToAdvisor informs sys-advisor of the container admitted by allocateWithLock, it's called
+// without the policy lock since it's a remote call. the container is removed if it fails, as if it's not admitted.
+func (p *DynamicPolicy) addAllocatedContainerToAdvisor(ctx context.Context, allocationReq *allocationRequest) error {
+	if !p.enableCPUAdvisor {
+		return nil
+	}
+
+	err := p.addContainerToAdvisor(ctx, allocationReq)
+	if err == nil {
+		return nil
+	}
+
+	req := allocationReq.req
+	p.Lock()
+	allocationInfo := p.state.GetAllocationInfo(req.PodUid, req.ContainerName)
+	_ = p.removeContainer(req.PodUid, req.ContainerName)
+	p.Unlock()
+
+	p.notifyAllocationChange(allocationInfo, nil)
+	_ = p.emitter.StoreInt64(util.MetricNameAllocateFailed, 1, metrics.MetricTypeNameRaw)
+	return fmt.Errorf("add container to qos aware server failed with error: %v", err)
+}
+
+// addContainerToAdvisor informs sys-advisor of the latest container
+func (p *DynamicPolicy) addContainerToAdvisor(ctx context.Context, allocationReq *allocationRequest) error {
+	req := allocationReq.req
+	_, err := p.advisorClient.AddContainer(ctx, &advisorsvc.ContainerMetadata{
+		PodUid:          req.PodUid,
+		PodNamespace:    req.PodNamespace,
+		PodName:         req.PodName,
+		ContainerName:   req.ContainerName,
+		ContainerType:   req.ContainerType,
+		ContainerIndex:  req.ContainerIndex,
+		Labels:          maputil.CopySS(req.Labels),
+		Annotations:     maputil.CopySS(req.Annotations),
+		QosLevel:        allocationReq.qosLevel,
+		RequestQuantity: uint64(allocationReq.reqInt),
+	})
+	return err
+}
+
+// allocatedResponse returns the response for the container which is already allocated and meets the requirement
+func allocatedResponse(req *pluginapi.ResourceRequest, reqInt int,
+	allocationInfo *state.AllocationInfo,
+) *pluginapi.ResourceAllocationResponse {
+	general.InfoS("already allocated and meet requirement",
+		"podNamespace", req.PodNamespace,
+		"podName", req.PodName,
+		"containerName", req.ContainerName,
+		"numCPUs", reqInt,
+		"originalAllocationResult", allocationInfo.OriginalAllocationResult.String(),
+		"currentResult", allocationInfo.AllocationResult.String())
+
+	return &pluginapi.ResourceAllocationResponse{
+		PodUid:         req.PodUid,
+		PodNamespace:   req.PodNamespace,
+		PodName:        req.PodName,
+		ContainerName:  req.ContainerName,
+		ContainerType:  req.ContainerType,
+		ContainerIndex: req.ContainerIndex,
+		PodRole:        req.PodRole,
+		PodType:        req.PodType,
+		ResourceName:   string(v1.ResourceCPU),
+		AllocationResult: &pluginapi.ResourceAllocation{
+			ResourceAllocation: map[string]*pluginapi.ResourceAllocationInfo{
+				string(v1.ResourceCPU): {
+					OciPropertyName:   util.OCIPropertyNameCPUSetCPUs,
+					IsNodeResource:    false,
+					IsScalarResource:  true,
+					AllocatedQuantity: float64(allocationInfo.AllocationResult.Size()),
+					AllocationResult:  allocationInfo.AllocationResult.String(),
+				},
+			},
+		},
+		Labels:      general.DeepCopyMap(req.Labels),
+		Annotations: general.DeepCopyMap(req.Annotations),
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"time"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+const defaultAllocationWatchBufferSize = 100
+
+// AllocationEventType is the type of change to the allocation of a container
+type AllocationEventType string
+
+const (
+	AllocationEventTypeAllocate AllocationEventType = "Allocate"
+	AllocationEventTypeResize   AllocationEventType = "Resize"
+	AllocationEventTypeRelease  AllocationEventType = "Release"
+)
+
+// AllocationEvent describes a change to the cpuset allocated to a container,
+// OldCPUSet and OldNUMAs are empty for Allocate, while NewCPUSet and NewNUMAs are empty for Release.
+type AllocationEvent struct {
+	Type          AllocationEventType
+	PodUID        string
+	PodNamespace  string
+	PodName       string
+	ContainerName string
+	OldCPUSet     machine.CPUSet
+	NewCPUSet     machine.CPUSet
+	OldNUMAs      machine.CPUSet
+	NewNUMAs      machine.CPUSet
+	Timestamp     time.Time
+}
+
+type allocationWatcher struct {
+	events chan *AllocationEvent
+}
+
+// WatchAllocations subscribes to allocation change events with a buffer of the given size
+// (defaultAllocationWatchBufferSize if it's not positive). events are sent without blocking,
+// and they're dropped for a subscriber whose buffer is full, so that a slow subscriber
+// can't stall admissions. the returned cancel function unsubscribes and closes the channel.
+func (p *DynamicPolicy) WatchAllocations(bufferSize int) (<-chan *AllocationEvent, func()) {
+	if bufferSize <= 0 {
+		bufferSize = defaultAllocationWatchBufferSize
+	}
+
+	watcher := &allocationWatcher{
+		events: make(chan *AllocationEvent, bufferSize),
+	}
+
+	p.allocationWatchersMutex.Lock()
+	if p.allocationWatchers == nil {
+		p.allocationWatchers = make(map[*allocationWatcher]struct{})
+	}
+	p.allocationWatchers[watcher] = struct{}{}
+	p.allocationWatchersMutex.Unlock()
+
+	cancel := func() {
+		p.allocationWatchersMutex.Lock()
+		defer p.allocationWatchersMutex.Unlock()
+
+		if _, ok := p.allocationWatchers[watcher]; !ok {
+			return
+		}
+		delete(p.allocationWatchers, watcher)
+		close(watcher.events)
+	}
+	return watcher.events, cancel
+}
+
+// notifyAllocationChange sends the change from oldAllocationInfo to newAllocationInfo to all subscribers,
+// it does nothing if there is no subscriber or the cpuset isn't changed.
+func (p *DynamicPolicy) notifyAllocationChange(oldAllocationInfo, newAllocationInfo *state.AllocationInfo) {
+	p.allocationWatchersMutex.RLock()
+	defer p.allocationWatchersMutex.RUnlock()
+
+	if len(p.allocationWatchers) == 0 {
+		return
+	}
+
+	event := newAllocationEvent(oldAllocationInfo, newAllocationInfo)
+	if event == nil {
+		return
+	}
+
+	for watcher := range p.allocationWatchers {
+		select {
+		case watcher.events <- event:
+		default:
+			general.Warningf("drop allocation event: %s of pod: %s/%s, container: %s since buffer of the watcher is full",
+				event.Type, event.PodNamespace, event.PodName, event.ContainerName)
+			_ = p.emitter.StoreInt64(util.MetricNameAllocationEventDropped, 1, metrics.MetricTypeNameRaw,
+				metrics.MetricTag{Key: "type", Val: string(event.Type)})
+		}
+	}
+}
+
+// newAllocationEvent returns nil if both allocations are nil or the cpuset isn't changed
+func newAllocationEvent(oldAllocationInfo, newAllocationInfo *state.AllocationInfo) *AllocationEvent {
+	var eventType AllocationEventType
+	var meta *state.AllocationInfo
+	switch {
+	case oldAllocationInfo == nil && newAllocationInfo == nil:
+		return nil
+	case oldAllocationInfo == nil:
+		eventType, meta = AllocationEventTypeAllocate, newAllocationInfo
+	case newAllocationInfo == nil:
+		eventType, meta = AllocationEventTypeRelease, oldAllocationInfo
+	default:
+		eventType, meta = AllocationEventTypeResize, newAllocationInfo
+	}
+
+	event := &AllocationEvent{
+		Type:          eventType,
+		PodUID:        meta.PodUid,
+		PodNamespace:  meta.PodNamespace,
+		PodName:       meta.PodName,
+		ContainerName: meta.ContainerName,
+		OldCPUSet:     machine.NewCPUSet(),
+		NewCPUSet:     machine.NewCPUSet(),
+		OldNUMAs:      machine.NewCPUSet(),
+		NewNUMAs:      machine.NewCPUSet(),
+		Timestamp:     time.Now(),
+	}
+	if oldAllocationInfo != nil {
+		event.OldCPUSet = oldAllocationInfo.AllocationResult.Clone()
+		event.OldNUMAs = oldAllocationInfo.GetAllocationResultNUMASet()
+	}
+	if newAllocationInfo != nil {
+		event.NewCPUSet = newAllocationInfo.AllocationResult.Clone()
+		event.NewNUMAs = newAllocationInfo.GetAllocationResultNUMASet()
+	}
+
+	if eventType == AllocationEventTypeResize && event.OldCPUSet.Equals(event.NewCPUSet) {
+		return nil
+	}
+	return event
+}
@@ -300,6 +300,40 @@ successful:
 	return acc.result.Clone(), availableCPUs.Difference(acc.result), nil
 }
 
+// TakeFullCoresByNUMABalance takes whole physical cores spread on different NUMAs in a round-robin way,
+// the cpu requirement is rounded up to the multiple of cpus per core, so that the taken cpus never share
+// a physical core with the cpus left; it's mainly used to pick reserved cpus by count.
+func TakeFullCoresByNUMABalance(info *machine.KatalystMachineInfo, availableCPUs machine.CPUSet,
+	cpuRequirement int,
+) (machine.CPUSet, machine.CPUSet, error) {
+	if cpusPerCore := info.CPUsPerCore(); cpusPerCore > 1 {
+		cpuRequirement = (cpuRequirement + cpusPerCore - 1) / cpusPerCore * cpusPerCore
+	}
+
+	acc := newCPUAccumulator(info, availableCPUs, cpuRequirement)
+	for !acc.isSatisfied() {
+		taken := false
+		for _, numaID := range info.CPUDetails.NUMANodes().ToSliceInt() {
+			freeCores := acc.freeCoresInNUMANode(numaID)
+			if len(freeCores) == 0 {
+				continue
+			}
+
+			acc.take(acc.getDetails().CPUsInCores(freeCores[0]))
+			taken = true
+			if acc.isSatisfied() {
+				break
+			}
+		}
+
+		if !taken {
+			return availableCPUs, availableCPUs, fmt.Errorf("not enough free cores available to satisfy request: %d",
+				cpuRequirement)
+		}
+	}
+	return acc.result.Clone(), availableCPUs.Difference(acc.result), nil
+}
+
 // TakeHTByNUMABalance tries to make the allocated cpu spread on different
 // sockets, and it uses cpu HT as the basic allocation unit
 func TakeHTByNUMABalance(info *machine.KatalystMachineInfo, availableCPUs machine.CPUSet,
@@ -0,0 +1,60 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// knownContainerTypes are container types that hint and allocation handlers are aware of
+var knownContainerTypes = map[pluginapi.ContainerType]bool{
+	pluginapi.ContainerType_INIT:    true,
+	pluginapi.ContainerType_MAIN:    true,
+	pluginapi.ContainerType_SIDECAR: true,
+}
+
+// normalizeContainerType handles requests whose container type isn't set to any type known by the policy,
+// instead of letting them fall through handlers implicitly. by default, they are handled as main containers
+// with a warning, and they're rejected if unknownContainerTypePolicy is reject.
+// notice that the zero value of ContainerType is INIT in the API, so a request without container type
+// is handled as an init container, which has no NUMA preference and takes no cpu from the state.
+func (p *DynamicPolicy) normalizeContainerType(req *pluginapi.ResourceRequest) error {
+	if knownContainerTypes[req.ContainerType] {
+		return nil
+	}
+
+	_ = p.emitter.StoreInt64(util.MetricNameUnknownContainerType, 1, metrics.MetricTypeNameRaw,
+		metrics.MetricTag{Key: "containerType", Val: req.ContainerType.String()},
+		metrics.MetricTag{Key: "policy", Val: p.unknownContainerTypePolicy})
+
+	if p.unknownContainerTypePolicy == cpuconsts.UnknownContainerTypePolicyReject {
+		return fmt.Errorf("pod: %s/%s, container: %s has unknown container type: %s",
+			req.PodNamespace, req.PodName, req.ContainerName, req.ContainerType.String())
+	}
+
+	general.Warningf("pod: %s/%s, container: %s has unknown container type: %s, handle it as main container",
+		req.PodNamespace, req.PodName, req.ContainerName, req.ContainerType.String())
+	req.ContainerType = pluginapi.ContainerType_MAIN
+	return nil
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"time"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/calculator"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+// setMachineState sets machine state and records cpus released by it, all machine states
+// regenerated by the policy should be set by it to keep release timestamps up-to-date;
+// it must be called with the policy lock held.
+func (p *DynamicPolicy) setMachineState(machineState state.NUMANodeMap) {
+	p.recordReleasedCPUs(p.state.GetMachineState(), machineState)
+	p.state.SetMachineState(machineState)
+}
+
+// setPodEntriesAndMachineState is the same as setMachineState, except that pod entries are updated
+// together with machine state and the state is stored only once
+func (p *DynamicPolicy) setPodEntriesAndMachineState(podEntries state.PodEntries, machineState state.NUMANodeMap) {
+	p.recordReleasedCPUs(p.state.GetMachineState(), machineState)
+	p.state.SetPodEntriesAndMachineState(podEntries, machineState)
+}
+
+// recordReleasedCPUs records the release timestamps of cpus that are allocated in the previous machine state
+// but not in the current one, so that they are avoided by new allocations until cpuReuseCoolDown elapses;
+// records whose cool-down has elapsed are cleaned at the same time.
+func (p *DynamicPolicy) recordReleasedCPUs(prevMachineState, curMachineState state.NUMANodeMap) {
+	if p.cpuReuseCoolDown <= 0 {
+		return
+	}
+
+	if p.cpuReleaseTimestamps == nil {
+		p.cpuReleaseTimestamps = make(map[int]time.Time)
+	}
+
+	now := time.Now()
+	for cpu, releaseTime := range p.cpuReleaseTimestamps {
+		if now.Sub(releaseTime) >= p.cpuReuseCoolDown {
+			delete(p.cpuReleaseTimestamps, cpu)
+		}
+	}
+
+	releasedCPUs := machine.NewCPUSet()
+	for numaID, prevNUMAState := range prevMachineState {
+		if prevNUMAState == nil {
+			continue
+		}
+
+		curAllocatedCPUs := machine.NewCPUSet()
+		if curNUMAState := curMachineState[numaID]; curNUMAState != nil {
+			curAllocatedCPUs = curNUMAState.AllocatedCPUSet
+		}
+		releasedCPUs = releasedCPUs.Union(prevNUMAState.AllocatedCPUSet.Difference(curAllocatedCPUs))
+	}
+
+	if releasedCPUs.IsEmpty() {
+		return
+	}
+
+	for _, cpu := range releasedCPUs.ToSliceInt() {
+		p.cpuReleaseTimestamps[cpu] = now
+	}
+	general.Infof("cpus: %s are released, they won't be reused in cool-down: %v",
+		releasedCPUs.String(), p.cpuReuseCoolDown)
+}
+
+// getCoolingDownCPUs returns cpus released within cpuReuseCoolDown
+func (p *DynamicPolicy) getCoolingDownCPUs() machine.CPUSet {
+	coolingDownCPUs := machine.NewCPUSet()
+	if p.cpuReuseCoolDown <= 0 {
+		return coolingDownCPUs
+	}
+
+	now := time.Now()
+	for cpu, releaseTime := range p.cpuReleaseTimestamps {
+		if now.Sub(releaseTime) < p.cpuReuseCoolDown {
+			coolingDownCPUs.Add(cpu)
+		}
+	}
+	return coolingDownCPUs
+}
+
+// takeByTopology takes cpus packed into as few sockets and cores as possible
+func (p *DynamicPolicy) takeByTopology(availableCPUs machine.CPUSet, numCPUs int) (machine.CPUSet, error) {
+	return calculator.TakeByTopology(p.machineInfo, availableCPUs, numCPUs)
+}
+
+// takeSpreadByTopology takes cpus spread across cores as much as possible
+func (p *DynamicPolicy) takeSpreadByTopology(availableCPUs machine.CPUSet, numCPUs int) (machine.CPUSet, error) {
+	return availableCPUs.TakeSpreadByTopology(numCPUs, p.machineInfo.CPUTopology)
+}
+
+// takeInFewestL3CacheDomains wraps takeCPUs to take cpus only from the fewest L3 cache domains
+// that have enough available cpus, so that cpus of the container share L3 caches as much as possible
+func (p *DynamicPolicy) takeInFewestL3CacheDomains(
+	takeCPUs func(availableCPUs machine.CPUSet, numCPUs int) (machine.CPUSet, error),
+) func(availableCPUs machine.CPUSet, numCPUs int) (machine.CPUSet, error) {
+	return func(availableCPUs machine.CPUSet, numCPUs int) (machine.CPUSet, error) {
+		candidateCPUs, err := availableCPUs.FewestL3CacheDomainsFor(numCPUs, p.machineInfo.CPUTopology)
+		if err != nil {
+			return machine.NewCPUSet(), err
+		}
+		return takeCPUs(candidateCPUs, numCPUs)
+	}
+}
+
+// takeAvoidingCoolingDownCPUs takes cpus from availableCPUs by the given function, and cpus in cool-down
+// are only taken if the request can't be satisfied by other cpus
+func (p *DynamicPolicy) takeAvoidingCoolingDownCPUs(availableCPUs machine.CPUSet, numCPUs int,
+	takeCPUs func(availableCPUs machine.CPUSet, numCPUs int) (machine.CPUSet, error),
+) (machine.CPUSet, error) {
+	coolingDownCPUs := p.getCoolingDownCPUs().Intersection(availableCPUs)
+	if !coolingDownCPUs.IsEmpty() && availableCPUs.Size()-coolingDownCPUs.Size() >= numCPUs {
+		cpus, err := takeCPUs(availableCPUs.Difference(coolingDownCPUs), numCPUs)
+		if err == nil {
+			return cpus, nil
+		}
+
+		general.Warningf("take %d cpus from: %s avoiding cpus in cool-down: %s failed with error: %v, fallback to all available cpus",
+			numCPUs, availableCPUs.String(), coolingDownCPUs.String(), err)
+	}
+
+	return takeCPUs(availableCPUs, numCPUs)
+}
@@ -35,6 +35,8 @@ import (
 func init() {
 	RegisterCPUEvictionInitializer(strategy.EvictionNameLoad, strategy.NewCPUPressureLoadEviction)
 	RegisterCPUEvictionInitializer(strategy.EvictionNameSuppression, strategy.NewCPUPressureSuppressionEviction)
+	RegisterCPUEvictionInitializer(strategy.EvictionNameMissingCPUs, strategy.NewCPUMissingCPUsEviction)
+	RegisterCPUEvictionInitializer(strategy.EvictionNameFreeNUMA, strategy.NewCPUFreeNUMAEviction)
 }
 
 var cpuEvictionInitializers sync.Map
@@ -0,0 +1,90 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+
+	apiconsts "github.com/kubewharf/katalyst-api/pkg/consts"
+	evictionpluginapi "github.com/kubewharf/katalyst-api/pkg/protocol/evictionplugin/v1alpha1"
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	qrmstate "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/config"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+func TestCPUFreeNUMAEviction_GetEvictPods(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+	stateImpl, err := makeState(cpuTopology)
+	as.Nil(err)
+
+	plugin, err := NewCPUFreeNUMAEviction(metrics.DummyMetrics{}, nil, config.NewConfiguration(), stateImpl)
+	as.Nil(err)
+	as.Equal(EvictionNameFreeNUMA, plugin.Name())
+
+	flaggedPodUID := string(uuid.NewUUID())
+	normalPodUID := string(uuid.NewUUID())
+	for podUID, annotations := range map[string]map[string]string{
+		flaggedPodUID: {
+			apiconsts.PodAnnotationQoSLevelKey:              apiconsts.PodAnnotationQoSLevelReclaimedCores,
+			cpuconsts.CPUStateAnnotationKeyFreeNUMAEviction: "1",
+		},
+		normalPodUID: {
+			apiconsts.PodAnnotationQoSLevelKey: apiconsts.PodAnnotationQoSLevelSharedCores,
+		},
+	} {
+		stateImpl.SetAllocationInfo(podUID, "c", &qrmstate.AllocationInfo{
+			PodUid:           podUID,
+			PodNamespace:     "n",
+			PodName:          podUID,
+			ContainerName:    "c",
+			AllocationResult: machine.NewCPUSet(2, 3),
+			Annotations:      annotations,
+		})
+	}
+
+	pods := []*v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "n", Name: flaggedPodUID, UID: types.UID(flaggedPodUID)}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "n", Name: normalPodUID, UID: types.UID(normalPodUID)}},
+	}
+
+	resp, err := plugin.GetEvictPods(context.TODO(), &evictionpluginapi.GetEvictPodsRequest{ActivePods: pods})
+	as.Nil(err)
+	as.Len(resp.EvictPods, 1)
+	as.Equal(types.UID(flaggedPodUID), resp.EvictPods[0].Pod.UID)
+
+	// flagged pods which aren't active any more are skipped
+	resp, err = plugin.GetEvictPods(context.TODO(), &evictionpluginapi.GetEvictPodsRequest{ActivePods: pods[1:]})
+	as.Nil(err)
+	as.Len(resp.EvictPods, 0)
+
+	_, err = plugin.GetEvictPods(context.TODO(), nil)
+	as.NotNil(err)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"context"
+	"fmt"
+
+	pluginapi "github.com/kubewharf/katalyst-api/pkg/protocol/evictionplugin/v1alpha1"
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/config"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/native"
+)
+
+const EvictionNameMissingCPUs = "cpu-missing-cpus-plugin"
+
+// CPUMissingCPUsEviction evicts pods whose allocations referenced cpus missing from the current topology,
+// they are flagged by the dynamic policy when regenerating state after topology changes.
+type CPUMissingCPUsEviction struct {
+	state state.ReadonlyState
+}
+
+func NewCPUMissingCPUsEviction(_ metrics.MetricEmitter, _ *metaserver.MetaServer,
+	_ *config.Configuration, state state.ReadonlyState,
+) (CPUPressureEviction, error) {
+	return &CPUMissingCPUsEviction{
+		state: state,
+	}, nil
+}
+
+func (p *CPUMissingCPUsEviction) Start(context.Context) error { return nil }
+func (p *CPUMissingCPUsEviction) Name() string                { return EvictionNameMissingCPUs }
+func (p *CPUMissingCPUsEviction) ThresholdMet(_ context.Context, _ *pluginapi.Empty) (*pluginapi.ThresholdMetResponse, error) {
+	return &pluginapi.ThresholdMetResponse{}, nil
+}
+
+func (p *CPUMissingCPUsEviction) GetTopEvictionPods(_ context.Context, _ *pluginapi.GetTopEvictionPodsRequest) (*pluginapi.GetTopEvictionPodsResponse, error) {
+	return &pluginapi.GetTopEvictionPodsResponse{}, nil
+}
+
+func (p *CPUMissingCPUsEviction) GetEvictPods(_ context.Context, request *pluginapi.GetEvictPodsRequest) (*pluginapi.GetEvictPodsResponse, error) {
+	if request == nil {
+		return nil, fmt.Errorf("GetEvictPods got nil request")
+	}
+
+	podEntries := p.state.GetPodEntries()
+
+	var evictPods []*pluginapi.EvictPod
+	for _, pod := range request.ActivePods {
+		if pod == nil {
+			continue
+		}
+
+		for containerName, allocationInfo := range podEntries[string(pod.UID)] {
+			if allocationInfo == nil {
+				continue
+			}
+
+			missingCPUs, ok := allocationInfo.Annotations[cpuconsts.CPUStateAnnotationKeyMissingCPUs]
+			if !ok {
+				continue
+			}
+
+			general.Infof("pod: %s container: %s is flagged to be evicted for missing cpus: %s",
+				native.GenerateUniqObjectNameKey(pod), containerName, missingCPUs)
+			evictPods = append(evictPods, &pluginapi.EvictPod{
+				Pod:    pod,
+				Reason: fmt.Sprintf("container: %s was allocated with cpus: %s missing from the current topology", containerName, missingCPUs),
+			})
+			break
+		}
+	}
+
+	return &pluginapi.GetEvictPodsResponse{EvictPods: evictPods}, nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"encoding/json"
+	"math"
+
+	v1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+// maxHintScore is the score of the best possible hint
+const maxHintScore = 100
+
+// getNUMADistanceFactor returns the ratio of the local distance to the max distance between NUMAs in the mask,
+// so that hints spanning remote NUMAs are scored lower; 1 is returned if the mask contains a single NUMA
+// or distances are unknown.
+func getNUMADistanceFactor(numaNodes []int, numaDistanceMap map[int][]machine.NumaDistanceInfo) float64 {
+	if len(numaNodes) <= 1 {
+		return 1
+	}
+
+	localDistance, maxDistance := 0, 0
+	for _, numaNode := range numaNodes {
+		for _, distanceInfo := range numaDistanceMap[numaNode] {
+			if distanceInfo.NumaID == numaNode {
+				localDistance = general.Max(localDistance, distanceInfo.Distance)
+				continue
+			}
+
+			for _, peer := range numaNodes {
+				if distanceInfo.NumaID == peer && distanceInfo.Distance > maxDistance {
+					maxDistance = distanceInfo.Distance
+				}
+			}
+		}
+	}
+
+	if localDistance <= 0 || maxDistance <= localDistance {
+		return 1
+	}
+	return float64(localDistance) / float64(maxDistance)
+}
+
+// getHintScore scores the hint by the cpu quantity left in its NUMAs after the request is allocated (i.e. curLeft
+// used by the prefer policy) relative to their allocatable quantity, more left is better for spreading while
+// less left is better for packing (and dynamic_packing); the score is then discounted by NUMA distance.
+func (p *DynamicPolicy) getHintScore(hint *pluginapi.TopologyHint, sharedNUMAPools state.SharedNUMAPools,
+	unavailableCPUs machine.CPUSet, reqInt int,
+) int {
+	numaNodes := make([]int, 0, len(hint.Nodes))
+	availableQuantity, allocatableQuantity := 0, 0
+	for _, nodeID := range hint.Nodes {
+		numaNodes = append(numaNodes, int(nodeID))
+		availableQuantity += p.getNUMAAvailableCPUQuantity(sharedNUMAPools, int(nodeID), unavailableCPUs)
+		allocatableQuantity += p.getNUMAAllocatableCPUQuantity(int(nodeID))
+	}
+
+	if allocatableQuantity <= 0 {
+		return 0
+	}
+
+	curLeft := general.Max(availableQuantity-reqInt, 0)
+	leftRatio := math.Min(float64(curLeft)/float64(allocatableQuantity), 1)
+	switch p.cpuNUMAHintPreferPolicy {
+	case cpuconsts.CPUNUMAHintPreferPolicyPacking, cpuconsts.CPUNUMAHintPreferPolicyDynamicPacking:
+		leftRatio = 1 - leftRatio
+	}
+
+	var numaDistanceMap map[int][]machine.NumaDistanceInfo
+	if p.machineInfo != nil && p.machineInfo.ExtraTopologyInfo != nil {
+		numaDistanceMap = p.machineInfo.NumaDistanceMap
+	}
+
+	distanceFactor := getNUMADistanceFactor(numaNodes, numaDistanceMap)
+	return int(math.Round(maxHintScore * leftRatio * distanceFactor))
+}
+
+// attachHintScores attaches scores of cpu hints in the response to its annotations,
+// the boolean preferred of hints is kept as it is for topology manager.
+func (p *DynamicPolicy) attachHintScores(resp *pluginapi.ResourceHintsResponse, reqInt int) {
+	if resp == nil || resp.ResourceHints[string(v1.ResourceCPU)] == nil {
+		return
+	}
+
+	sharedNUMAPools := p.state.GetSharedNUMAPools()
+	unavailableCPUs := p.getUnavailableCPUs()
+
+	scores := make(map[string]int, len(resp.ResourceHints[string(v1.ResourceCPU)].Hints))
+	for _, hint := range resp.ResourceHints[string(v1.ResourceCPU)].Hints {
+		if hint == nil || len(hint.Nodes) == 0 {
+			continue
+		}
+
+		numaSet, err := machine.NewCPUSetUint64(hint.Nodes...)
+		if err != nil {
+			general.Errorf("parse NUMAs of hint: %v failed with error: %v", hint.Nodes, err)
+			continue
+		}
+		scores[numaSet.String()] = p.getHintScore(hint, sharedNUMAPools, unavailableCPUs, reqInt)
+	}
+
+	scoresBytes, err := json.Marshal(scores)
+	if err != nil {
+		general.Errorf("marshal hint scores failed with error: %v", err)
+		return
+	}
+
+	if resp.Annotations == nil {
+		resp.Annotations = make(map[string]string)
+	}
+	resp.Annotations[cpuconsts.ResourceHintsAnnotationKeyCPUHintScores] = string(scoresBytes)
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+const (
+	hintsAuditReasonHinted       = "hinted"
+	hintsAuditReasonNoPreference = "no NUMA preference"
+	hintsAuditReasonNoFit        = "no NUMA fits the request"
+)
+
+// HintsAuditRecord is the record of a single placement decision made by hints calculation
+type HintsAuditRecord struct {
+	Timestamp      time.Time                 `json:"timestamp"`
+	PodUID         string                    `json:"podUID"`
+	PodNamespace   string                    `json:"podNamespace"`
+	PodName        string                    `json:"podName"`
+	ContainerName  string                    `json:"containerName"`
+	QoSLevel       string                    `json:"qosLevel"`
+	Request        float64                   `json:"request"`
+	CandidateNUMAs []int                     `json:"candidateNUMAs"`
+	Hints          []*pluginapi.TopologyHint `json:"hints"`
+	PreferredHints []*pluginapi.TopologyHint `json:"preferredHints"`
+	Reason         string                    `json:"reason"`
+}
+
+// hintsAuditSink receives a record per admission, it must be safe for concurrent use
+// since hints are calculated under the read lock.
+type hintsAuditSink interface {
+	Record(record *HintsAuditRecord) error
+}
+
+// fileHintsAuditSink appends records to a file in json lines, the file is opened for each record
+// so that it can be rotated without restarting the agent.
+type fileHintsAuditSink struct {
+	mutex       sync.Mutex
+	fileAbsPath string
+}
+
+func newFileHintsAuditSink(fileAbsPath string) *fileHintsAuditSink {
+	return &fileHintsAuditSink{fileAbsPath: fileAbsPath}
+}
+
+func (s *fileHintsAuditSink) Record(record *HintsAuditRecord) error {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshal record failed with error: %v", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, err := os.OpenFile(s.fileAbsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open file: %s failed with error: %v", s.fileAbsPath, err)
+	}
+
+	_, err = file.Write(append(recordBytes, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// auditHints reports the hints calculation result of the request to the audit sink if it's set,
+// failures of the sink are only logged and admission is never affected.
+func (p *DynamicPolicy) auditHints(req *pluginapi.ResourceRequest, qosLevel string, reqFloat64 float64,
+	resp *pluginapi.ResourceHintsResponse, hintsErr error,
+) {
+	if p.hintsAuditSink == nil || req == nil {
+		return
+	}
+
+	record := &HintsAuditRecord{
+		Timestamp:      time.Now(),
+		PodUID:         req.PodUid,
+		PodNamespace:   req.PodNamespace,
+		PodName:        req.PodName,
+		ContainerName:  req.ContainerName,
+		QoSLevel:       qosLevel,
+		Request:        reqFloat64,
+		CandidateNUMAs: []int{},
+		Hints:          []*pluginapi.TopologyHint{},
+		PreferredHints: []*pluginapi.TopologyHint{},
+	}
+
+	var hints *pluginapi.ListOfTopologyHints
+	if resp != nil {
+		hints = resp.ResourceHints[string(v1.ResourceCPU)]
+	}
+
+	switch {
+	case hintsErr != nil:
+		record.Reason = hintsErr.Error()
+	case hints == nil:
+		record.Reason = hintsAuditReasonNoPreference
+	case len(hints.Hints) == 0:
+		record.Reason = hintsAuditReasonNoFit
+	default:
+		record.Reason = hintsAuditReasonHinted
+		candidateNUMAs := make(map[int]bool)
+		for _, hint := range hints.Hints {
+			if hint == nil {
+				continue
+			}
+
+			record.Hints = append(record.Hints, hint)
+			if hint.Preferred {
+				record.PreferredHints = append(record.PreferredHints, hint)
+			}
+			for _, nodeID := range hint.Nodes {
+				candidateNUMAs[int(nodeID)] = true
+			}
+		}
+
+		for nodeID := range candidateNUMAs {
+			record.CandidateNUMAs = append(record.CandidateNUMAs, nodeID)
+		}
+		sort.Ints(record.CandidateNUMAs)
+	}
+
+	if err := p.hintsAuditSink.Record(record); err != nil {
+		general.Errorf("pod: %s/%s, container: %s record hints audit failed with error: %v",
+			req.PodNamespace, req.PodName, req.ContainerName, err)
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	"github.com/kubewharf/katalyst-api/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
+)
+
+// hintsTrace records reasons of decisions made on each NUMA during hints calculation,
+// it's nil-safe so that filter functions can record unconditionally, and nothing is
+// recorded for nil trace in normal admission.
+type hintsTrace map[int][]string
+
+func (t hintsTrace) record(nodeID int, format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	t[nodeID] = append(t[nodeID], fmt.Sprintf(format, args...))
+}
+
+// recordExcluded records the reason for NUMAs in before but not in after
+func (t hintsTrace) recordExcluded(before, after machine.CPUSet, reason string) {
+	if t == nil {
+		return
+	}
+
+	for _, nodeID := range before.Difference(after).ToSliceInt() {
+		t[nodeID] = append(t[nodeID], reason)
+	}
+}
+
+// TopologyHintsTrace is the result of hints calculation along with per-NUMA decision trace
+type TopologyHintsTrace struct {
+	Hints       []*pluginapi.TopologyHint `json:"hints"`
+	NUMAReasons map[int][]string          `json:"numaReasons"`
+	// Error is set if hints calculation fails, and trace recorded before the failure is still returned
+	Error string `json:"error,omitempty"`
+}
+
+// GetTopologyHintsTrace runs hints calculation for numa_binding shared_cores request without admission,
+// and returns the per-NUMA decision trace explaining why each NUMA is filtered or kept.
+func (p *DynamicPolicy) GetTopologyHintsTrace(req *pluginapi.ResourceRequest) (*TopologyHintsTrace, error) {
+	if req == nil {
+		return nil, fmt.Errorf("GetTopologyHintsTrace got nil req")
+	}
+
+	// numa pin rules are matched before labels of the request are filtered
+	ctx := p.withNUMAPinRule(withAdmissionLogger(context.Background(), req), req)
+	ctx = p.withNamespaceExcludedNUMAs(ctx, req)
+	qosLevel, err := p.getKatalystQoSLevelFromResourceReq(req)
+	if err != nil {
+		return nil, fmt.Errorf("GetKatalystQoSLevelFromResourceReq for pod: %s/%s, container: %s failed with error: %v",
+			req.PodNamespace, req.PodName, req.ContainerName, err)
+	} else if qosLevel != consts.PodAnnotationQoSLevelSharedCores || !qosutil.AnnotationsIndicateNUMABinding(req.Annotations) {
+		return nil, fmt.Errorf("hints trace is only supported for numa_binding shared_cores, got QoS level: %s", qosLevel)
+	}
+
+	reqInt, _, err := util.GetQuantityFromResourceReq(req)
+	if err != nil {
+		return nil, fmt.Errorf("getReqQuantityFromResourceReq failed with error: %v", err)
+	}
+
+	p.RLock()
+	defer p.RUnlock()
+
+	trace := hintsTrace{}
+	hints, err := p.calculateHintsForNUMABindingSharedCores(ctx, reqInt, p.state.GetPodEntries(), p.state.GetMachineState(),
+		p.state.GetSharedNUMAPools(), req.Annotations, p.getNUMAConstraint(ctx, req.Hint, req.Annotations), trace)
+
+	result := &TopologyHintsTrace{
+		Hints:       []*pluginapi.TopologyHint{},
+		NUMAReasons: trace,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	} else if hints[string(v1.ResourceCPU)] != nil {
+		result.Hints = hints[string(v1.ResourceCPU)].Hints
+	}
+	return result, nil
+}
+
+// ExplainNUMAExclusion re-runs hints calculation for the admitted numa_binding shared_cores container as if it's
+// admitted again (i.e. without its own allocation), and returns a human-readable reason why the NUMA is excluded
+// from its hints, or why it's kept otherwise. containers not admitted yet should be explained by
+// GetTopologyHintsTrace with their requests instead.
+func (p *DynamicPolicy) ExplainNUMAExclusion(podUID, containerName string, numaID int) (string, error) {
+	if !p.machineInfo.CPUDetails.NUMANodes().Contains(numaID) {
+		return "", fmt.Errorf("NUMA: %d isn't found in topology", numaID)
+	}
+
+	p.RLock()
+	defer p.RUnlock()
+
+	allocationInfo := p.state.GetAllocationInfo(podUID, containerName)
+	if allocationInfo == nil {
+		return "", fmt.Errorf("pod: %s, container: %s isn't found in state", podUID, containerName)
+	} else if !state.CheckSharedNUMABinding(allocationInfo) {
+		return "", fmt.Errorf("NUMA exclusion is only explained for numa_binding shared_cores, got QoS level: %s",
+			allocationInfo.QoSLevel)
+	}
+
+	// pod entries got from state is a copy, so it's safe to apply operations on it
+	podEntries, err := applyProjectedOperations(p.state.GetPodEntries(), []*ProjectedOperation{
+		{Type: ProjectedOperationRemove, PodUID: podUID, ContainerName: containerName},
+	})
+	if err != nil {
+		return "", fmt.Errorf("applyProjectedOperations failed with error: %v", err)
+	}
+
+	machineState, err := p.generateMachineStateWithRetry(podEntries)
+	if err != nil {
+		return "", fmt.Errorf("GenerateMachineStateFromPodEntries failed with error: %v", err)
+	}
+
+	ctx := context.Background()
+	trace := hintsTrace{}
+	hints, err := p.calculateHintsForNUMABindingSharedCores(ctx, int(math.Ceil(allocationInfo.RequestQuantity)),
+		podEntries, machineState, state.NewSharedNUMAPools(podEntries), allocationInfo.Annotations,
+		p.getNUMAConstraint(ctx, nil, allocationInfo.Annotations), trace)
+
+	reasons := trace[numaID]
+	if err != nil {
+		reasons = append(reasons, fmt.Sprintf("hints calculation failed: %v", err))
+	} else if hints[string(v1.ResourceCPU)] != nil {
+		for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+			if len(hint.Nodes) == 1 && hint.Nodes[0] == uint64(numaID) {
+				return fmt.Sprintf("NUMA: %d isn't excluded: %s", numaID, strings.Join(reasons, "; ")), nil
+			}
+		}
+	}
+
+	if len(reasons) == 0 {
+		return fmt.Sprintf("NUMA: %d is excluded without recorded reason", numaID), nil
+	}
+	return fmt.Sprintf("NUMA: %d is excluded: %s", numaID, strings.Join(reasons, "; ")), nil
+}
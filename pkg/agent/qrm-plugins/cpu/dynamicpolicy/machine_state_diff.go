@@ -0,0 +1,122 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+// numaStateDiff is how allocations in a NUMA are changed from one machine state to another
+type numaStateDiff struct {
+	NUMAID          int    `json:"numaID"`
+	AllocatedBefore string `json:"allocatedBefore"`
+	AllocatedAfter  string `json:"allocatedAfter"`
+	// Added and Removed are allocations (formatted as "podUID/containerName: cpuset") of containers that only
+	// exist after and before respectively, and Changed are formatted as "podUID/containerName: before -> after"
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+}
+
+// diffMachineState returns diffs of NUMAs whose allocated cpusets or allocations of containers are changed
+// from before to after, in the order of NUMA id.
+func diffMachineState(before, after state.NUMANodeMap) []*numaStateDiff {
+	numaIDs := machine.NewCPUSet()
+	for numaID := range before {
+		numaIDs.Add(numaID)
+	}
+	for numaID := range after {
+		numaIDs.Add(numaID)
+	}
+
+	var diffs []*numaStateDiff
+	for _, numaID := range numaIDs.ToSliceInt() {
+		beforeAllocations := getNUMAAllocations(before[numaID])
+		afterAllocations := getNUMAAllocations(after[numaID])
+		diff := &numaStateDiff{NUMAID: numaID}
+
+		for key, cpuset := range beforeAllocations {
+			if afterCPUSet, ok := afterAllocations[key]; !ok {
+				diff.Removed = append(diff.Removed, fmt.Sprintf("%s: %s", key, cpuset.String()))
+			} else if !afterCPUSet.Equals(cpuset) {
+				diff.Changed = append(diff.Changed, fmt.Sprintf("%s: %s -> %s", key, cpuset.String(), afterCPUSet.String()))
+			}
+		}
+		for key, cpuset := range afterAllocations {
+			if _, ok := beforeAllocations[key]; !ok {
+				diff.Added = append(diff.Added, fmt.Sprintf("%s: %s", key, cpuset.String()))
+			}
+		}
+
+		allocatedBefore, allocatedAfter := getNUMAAllocatedCPUSet(before[numaID]), getNUMAAllocatedCPUSet(after[numaID])
+		if allocatedBefore.Equals(allocatedAfter) && len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0 {
+			continue
+		}
+
+		diff.AllocatedBefore, diff.AllocatedAfter = allocatedBefore.String(), allocatedAfter.String()
+		sort.Strings(diff.Added)
+		sort.Strings(diff.Removed)
+		sort.Strings(diff.Changed)
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+// getNUMAAllocations returns cpusets (within the NUMA) of containers in the NUMA keyed by "podUID/containerName"
+func getNUMAAllocations(numaState *state.NUMANodeState) map[string]machine.CPUSet {
+	allocations := make(map[string]machine.CPUSet)
+	if numaState == nil {
+		return allocations
+	}
+
+	for podUID, containerEntries := range numaState.PodEntries {
+		for containerName, allocationInfo := range containerEntries {
+			if allocationInfo != nil {
+				allocations[fmt.Sprintf("%s/%s", podUID, containerName)] = allocationInfo.AllocationResult.Clone()
+			}
+		}
+	}
+	return allocations
+}
+
+// getNUMAAllocatedCPUSet returns the allocated cpuset of the NUMA, i.e. cpus of numa_binding containers
+func getNUMAAllocatedCPUSet(numaState *state.NUMANodeState) machine.CPUSet {
+	if numaState == nil {
+		return machine.NewCPUSet()
+	}
+	return numaState.AllocatedCPUSet.Clone()
+}
+
+// logMachineStateDiff logs diffs of machine state in json, nothing is logged if machine state isn't changed
+func logMachineStateDiff(diffs []*numaStateDiff) {
+	if len(diffs) == 0 {
+		return
+	}
+
+	diffBytes, err := json.Marshal(diffs)
+	if err != nil {
+		general.Errorf("marshal machine state diff failed with error: %v", err)
+		return
+	}
+	general.Infof("machine state is regenerated with diff: %s", string(diffBytes))
+}
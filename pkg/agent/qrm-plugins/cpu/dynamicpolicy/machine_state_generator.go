@@ -0,0 +1,109 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+// generateMachineStateBackoff bounds retries of generating machine state in handlers,
+// it's kept short since handlers are called with the policy lock held.
+var generateMachineStateBackoff = wait.Backoff{
+	Duration: 10 * time.Millisecond,
+	Factor:   2.0,
+	Steps:    4,
+}
+
+// machineStateGenerator generates machine state from pod entries based on the given topology
+type machineStateGenerator func(topology *machine.CPUTopology, podEntries state.PodEntries) (state.NUMANodeMap, error)
+
+// transientError indicates a failure that is expected to recover by retrying, e.g. failing to read topology;
+// other failures (e.g. caused by bad pod entries) are regarded as permanent.
+type transientError struct {
+	err error
+}
+
+func newTransientError(err error) error {
+	return &transientError{err: err}
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+func isTransientError(err error) bool {
+	var te *transientError
+	return errors.As(err, &te)
+}
+
+// generateMachineStateFromPodEntries is the default machineStateGenerator
+func generateMachineStateFromPodEntries(topology *machine.CPUTopology, podEntries state.PodEntries) (state.NUMANodeMap, error) {
+	if topology == nil {
+		return nil, newTransientError(fmt.Errorf("nil cpu topology"))
+	}
+	return state.GenerateMachineStateFromPodEntries(topology, podEntries, cpuconsts.CPUResourcePluginPolicyNameDynamic)
+}
+
+// generateMachineStateWithRetry generates machine state from pod entries, and retries with backoff
+// if the failure is transient; error is returned once retries are exhausted or the failure is permanent.
+func (p *DynamicPolicy) generateMachineStateWithRetry(podEntries state.PodEntries) (state.NUMANodeMap, error) {
+	var (
+		machineState state.NUMANodeMap
+		lastErr      error
+		retries      int
+	)
+
+	err := wait.ExponentialBackoff(generateMachineStateBackoff, func() (bool, error) {
+		machineState, lastErr = p.machineStateGenerator(p.machineInfo.CPUTopology, podEntries)
+		if lastErr == nil {
+			return true, nil
+		} else if !isTransientError(lastErr) {
+			return false, lastErr
+		}
+
+		retries++
+		general.Warningf("generate machine state failed with transient error: %v, retry: %d", lastErr, retries)
+		_ = p.emitter.StoreInt64(util.MetricNameGenerateMachineStateRetry, 1, metrics.MetricTypeNameCount,
+			metrics.MetricTag{Key: "retry", Val: fmt.Sprintf("%d", retries)})
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil, fmt.Errorf("generate machine state failed after %d retries with error: %v", retries, lastErr)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if p.enableMachineStateDiffLog {
+		logMachineStateDiff(diffMachineState(p.state.GetMachineState(), machineState))
+	}
+	return machineState, nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	v1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+	"k8s.io/kubernetes/pkg/kubelet/checkpointmanager"
+	"k8s.io/kubernetes/pkg/kubelet/checkpointmanager/errors"
+
+	memconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/memory/consts"
+	memorystate "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/memory/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/config"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
+)
+
+// memoryNUMAsGetter returns NUMAs that memory of the pod is allocated on by memory plugin,
+// false is returned if memory of the pod isn't allocated with numa_binding yet.
+type memoryNUMAsGetter interface {
+	GetMemoryNUMAs(podUID string) (machine.CPUSet, bool, error)
+}
+
+// checkpointMemoryNUMAsGetter reads allocations of memory plugin from its checkpoint in the state file
+// directory shared by qrm plugins, the checkpoint is verified by checksum and never written here.
+type checkpointMemoryNUMAsGetter struct {
+	stateFileDirectory string
+}
+
+// newMemoryNUMAsGetter returns the getter reading checkpoint of memory plugin, it's used to prefer NUMAs of memory
+// allocated for numa_binding pods (if enabled), and to keep pods with memory bound to a NUMA from being displaced
+func newMemoryNUMAsGetter(conf *config.Configuration) memoryNUMAsGetter {
+	return &checkpointMemoryNUMAsGetter{stateFileDirectory: conf.GenericQRMPluginConfiguration.StateFileDirectory}
+}
+
+func (g *checkpointMemoryNUMAsGetter) GetMemoryNUMAs(podUID string) (machine.CPUSet, bool, error) {
+	checkpointManager, err := checkpointmanager.NewCheckpointManager(g.stateFileDirectory)
+	if err != nil {
+		return machine.NewCPUSet(), false, fmt.Errorf("new checkpoint manager failed with error: %v", err)
+	}
+
+	checkpoint := memorystate.NewMemoryPluginCheckpoint()
+	if err = checkpointManager.GetCheckpoint(memconsts.MemoryPluginStateFileName, checkpoint); err == errors.ErrCheckpointNotFound {
+		return machine.NewCPUSet(), false, nil
+	} else if err != nil {
+		return machine.NewCPUSet(), false, fmt.Errorf("get checkpoint of memory plugin failed with error: %v", err)
+	}
+
+	allocationInfo, ok := checkpoint.PodResourceEntries[v1.ResourceMemory].GetMainContainerAllocation(podUID)
+	if !ok || !allocationInfo.CheckNumaBinding() || allocationInfo.NumaAllocationResult.IsEmpty() {
+		return machine.NewCPUSet(), false, nil
+	}
+	return allocationInfo.NumaAllocationResult.Clone(), true, nil
+}
+
+// preferMemoryAllocatedNUMAs prefers the hint on NUMAs that memory of the pod is already allocated on by
+// memory plugin (e.g. memory of the main container is allocated before cpu of sidecars, or by memory plugin
+// surviving the restart of cpu plugin), so that cpus and memory of numa_binding pods stay on the same NUMAs.
+// all hints are feasible as long as any of them is preferred, so the hint is preferred instead of others with
+// the same number of NUMAs; it takes no effect if there is no such hint, memory of the pod isn't allocated
+// yet, or the preferred NUMA is forced by annotation.
+func (p *DynamicPolicy) preferMemoryAllocatedNUMAs(ctx context.Context, req *pluginapi.ResourceRequest,
+	hints map[string]*pluginapi.ListOfTopologyHints,
+) {
+	logger := general.LoggerFromContext(ctx)
+	if !p.preferMemoryNUMAs || p.memoryNUMAsGetter == nil || !qosutil.AnnotationsIndicateNUMABinding(req.Annotations) {
+		return
+	} else if hints[string(v1.ResourceCPU)] == nil || !hasPreferredHint(hints[string(v1.ResourceCPU)].Hints) {
+		return
+	} else if _, ok := p.getPreferredNUMAOverride(ctx, req.Annotations); ok {
+		return
+	}
+
+	memoryNUMAs, ok, err := p.memoryNUMAsGetter.GetMemoryNUMAs(req.PodUid)
+	if err != nil {
+		logger.Errorf("pod: %s/%s, container: %s get NUMAs of allocated memory failed with error: %v",
+			req.PodNamespace, req.PodName, req.ContainerName, err)
+		return
+	} else if !ok {
+		return
+	}
+
+	cpuHints := hints[string(v1.ResourceCPU)].Hints
+	minNUMAs := math.MaxInt
+	for _, hint := range cpuHints {
+		if hint.Preferred && len(hint.Nodes) < minNUMAs {
+			minNUMAs = len(hint.Nodes)
+		}
+	}
+
+	var memoryHint *pluginapi.TopologyHint
+	for _, hint := range cpuHints {
+		if len(hint.Nodes) == minNUMAs && machine.NewCPUSet(util.HintToIntArray(hint)...).Equals(memoryNUMAs) {
+			memoryHint = hint
+			break
+		}
+	}
+
+	if memoryHint == nil {
+		logger.Infof("pod: %s/%s, container: %s NUMAs: %s of allocated memory aren't feasible with the fewest NUMAs",
+			req.PodNamespace, req.PodName, req.ContainerName, memoryNUMAs.String())
+		return
+	}
+
+	logger.Infof("pod: %s/%s, container: %s prefer NUMAs: %s of allocated memory",
+		req.PodNamespace, req.PodName, req.ContainerName, memoryNUMAs.String())
+	for _, hint := range cpuHints {
+		hint.Preferred = hint == memoryHint
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"errors"
+
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	utilmetric "github.com/kubewharf/katalyst-core/pkg/util/metric"
+)
+
+// errMetricStoreUnavailable is returned by getNUMAMetric if the metric store of metaServer is unavailable
+var errMetricStoreUnavailable = errors.New("metric store is unavailable")
+
+// isMetricStoreAvailable returns false if metaServer has no metric store, or the store hasn't synced,
+// e.g. metric collection is down. it's logged once when the store becomes unavailable and once when
+// it recovers, rather than for each admission.
+func (p *DynamicPolicy) isMetricStoreAvailable() bool {
+	available := p.metaServer != nil && p.metaServer.MetricsFetcher != nil && p.metaServer.MetricsFetcher.HasSynced()
+	if !available {
+		if !p.metricStoreUnavailable.Swap(true) {
+			general.Warningf("metric store is unavailable, metric-aware hints adjustment falls back to cpu only")
+		}
+	} else if p.metricStoreUnavailable.Swap(false) {
+		general.Infof("metric store recovers, metric-aware hints adjustment is resumed")
+	}
+	return available
+}
+
+// getNUMAMetric is the guarded accessor of NUMA metrics in the metric store, errMetricStoreUnavailable
+// is returned if the store is unavailable, so that it can be told apart from a single missing metric.
+func (p *DynamicPolicy) getNUMAMetric(numaID int, metricName string) (utilmetric.MetricData, error) {
+	if !p.isMetricStoreAvailable() {
+		return utilmetric.MetricData{}, errMetricStoreUnavailable
+	}
+	return p.metaServer.GetNumaMetric(numaID, metricName)
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+// handleAllocationsWithMissingCPUs detects allocations referencing cpus missing from the current topology
+// (e.g. the checkpoint was generated before cpu hot-unplug), and trims them to valid cpus; containers
+// are also flagged to be evicted if the action is evict or no valid cpu is left in their allocations.
+// NUMA assignments of all allocations are refreshed by the current topology, and state is persisted
+// if any allocation is trimmed or its NUMA assignments are changed.
+func (p *DynamicPolicy) handleAllocationsWithMissingCPUs() error {
+	switch p.missingCPUsAction {
+	case cpuconsts.MissingCPUsActionTrim, cpuconsts.MissingCPUsActionEvict:
+	default:
+		return fmt.Errorf("unsupported missing cpus action: %s", p.missingCPUsAction)
+	}
+
+	allCPUs := p.machineInfo.CPUDetails.CPUs()
+	podEntries := p.state.GetPodEntries()
+	changed := false
+
+	for podUID, entries := range podEntries {
+		for containerName, allocationInfo := range entries {
+			if allocationInfo == nil {
+				continue
+			}
+
+			missingCPUs := allocationInfo.AllocationResult.Union(allocationInfo.OriginalAllocationResult).Difference(allCPUs)
+			allocationInfo.AllocationResult = allocationInfo.AllocationResult.Intersection(allCPUs)
+			allocationInfo.OriginalAllocationResult = allocationInfo.OriginalAllocationResult.Intersection(allCPUs)
+
+			// NUMA assignments are refreshed for all entries, since cpus may be re-numbered across NUMAs
+			// when the topology changes, even for allocations without missing cpus
+			topologyAwareAssignments, err := machine.GetNumaAwareAssignments(p.machineInfo.CPUTopology, allocationInfo.AllocationResult)
+			if err != nil {
+				return fmt.Errorf("GetNumaAwareAssignments for pod: %s, container: %s failed with error: %v",
+					podUID, containerName, err)
+			}
+			originalTopologyAwareAssignments, err := machine.GetNumaAwareAssignments(p.machineInfo.CPUTopology, allocationInfo.OriginalAllocationResult)
+			if err != nil {
+				return fmt.Errorf("GetNumaAwareAssignments for pod: %s, container: %s failed with error: %v",
+					podUID, containerName, err)
+			}
+			if !state.CPUAssignmentEquals(allocationInfo.TopologyAwareAssignments, topologyAwareAssignments) ||
+				!state.CPUAssignmentEquals(allocationInfo.OriginalTopologyAwareAssignments, originalTopologyAwareAssignments) {
+				general.Infof("pod: %s/%s, container: %s NUMA assignments are refreshed from %v to %v",
+					allocationInfo.PodNamespace, allocationInfo.PodName, containerName,
+					allocationInfo.TopologyAwareAssignments, topologyAwareAssignments)
+				changed = true
+			}
+			allocationInfo.TopologyAwareAssignments = topologyAwareAssignments
+			allocationInfo.OriginalTopologyAwareAssignments = originalTopologyAwareAssignments
+
+			if missingCPUs.IsEmpty() {
+				continue
+			}
+			changed = true
+
+			action := p.missingCPUsAction
+			// pools are regenerated from the remaining cpus, so only containers are flagged to be evicted
+			if !entries.IsPoolEntry() && (action == cpuconsts.MissingCPUsActionEvict || allocationInfo.AllocationResult.IsEmpty()) {
+				action = cpuconsts.MissingCPUsActionEvict
+				if allocationInfo.Annotations == nil {
+					allocationInfo.Annotations = make(map[string]string)
+				}
+				allocationInfo.Annotations[cpuconsts.CPUStateAnnotationKeyMissingCPUs] = missingCPUs.String()
+			} else {
+				action = cpuconsts.MissingCPUsActionTrim
+			}
+
+			general.Warningf("pod: %s/%s, container: %s allocation references missing cpus: %s, "+
+				"action: %s, allocation result after trimming: %s",
+				allocationInfo.PodNamespace, allocationInfo.PodName, containerName, missingCPUs.String(),
+				action, allocationInfo.AllocationResult.String())
+			_ = p.emitter.StoreInt64(util.MetricNameAllocationWithMissingCPUs, 1, metrics.MetricTypeNameRaw,
+				metrics.MetricTag{Key: "action", Val: action})
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	machineState, err := p.generateMachineStateWithRetry(podEntries)
+	if err != nil {
+		return fmt.Errorf("calculate machineState by podEntries failed with error: %v", err)
+	}
+
+	p.state.SetPodEntries(podEntries)
+	p.setMachineState(machineState)
+	return nil
+}
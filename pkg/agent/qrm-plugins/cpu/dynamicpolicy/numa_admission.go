@@ -0,0 +1,184 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	apiconsts "github.com/kubewharf/katalyst-api/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+// cpus of a new numa_binding dedicated_cores container are taken only from NUMAs of its hint, so they're
+// planned under the read-lock with those NUMAs locked, i.e. admissions to different NUMAs plan their cpus
+// concurrently, while admissions to the same NUMA are serialized so that they never plan the same cpus.
+// the plan is committed under the write-lock (pod entries, pools and the checkpoint are still updated
+// as a whole) only if cpus available on the hinted NUMAs are unchanged since it's made; otherwise cpus
+// are taken again under the write-lock as before.
+
+// numaBindingCPUsPlan is the cpus planned for a new numa_binding dedicated_cores container
+type numaBindingCPUsPlan struct {
+	// availableCPUs are cpus available on NUMAs of the hint when the plan is made
+	availableCPUs machine.CPUSet
+	cpus          machine.CPUSet
+}
+
+type numaBindingCPUsPlanContextKey struct{}
+
+// withNUMABindingCPUsPlan returns a context carrying the given plan for allocation handlers
+func withNUMABindingCPUsPlan(ctx context.Context, plan *numaBindingCPUsPlan) context.Context {
+	if plan == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, numaBindingCPUsPlanContextKey{}, plan)
+}
+
+// getNUMABindingCPUsPlan returns the plan carried by the context, if any
+func getNUMABindingCPUsPlan(ctx context.Context) (*numaBindingCPUsPlan, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+
+	plan, ok := ctx.Value(numaBindingCPUsPlanContextKey{}).(*numaBindingCPUsPlan)
+	return plan, ok && plan != nil
+}
+
+// newNUMAAdmissionMutexes returns a mutex for each NUMA of the machine, they're created once
+// and never changed, so they're accessed without any lock
+func newNUMAAdmissionMutexes(numaNodes machine.CPUSet) map[int]*sync.Mutex {
+	mutexes := make(map[int]*sync.Mutex, numaNodes.Size())
+	for _, numaID := range numaNodes.ToSliceNoSortInt() {
+		mutexes[numaID] = &sync.Mutex{}
+	}
+	return mutexes
+}
+
+// lockNUMAs locks the given NUMAs in ascending order to avoid deadlocks, and returns the function to unlock them;
+// false is returned (and nothing is locked) if any of them is unknown.
+// it must be called without the policy lock held, since the policy lock is taken with NUMAs locked.
+func (p *DynamicPolicy) lockNUMAs(numaNodes []uint64) (func(), bool) {
+	numaIDs := make([]int, 0, len(numaNodes))
+	for _, numaNode := range numaNodes {
+		if p.numaAdmissionMutexes[int(numaNode)] == nil {
+			return nil, false
+		}
+		numaIDs = append(numaIDs, int(numaNode))
+	}
+	sort.Ints(numaIDs)
+
+	for _, numaID := range numaIDs {
+		p.numaAdmissionMutexes[numaID].Lock()
+	}
+	return func() {
+		for i := len(numaIDs) - 1; i >= 0; i-- {
+			p.numaAdmissionMutexes[numaIDs[i]].Unlock()
+		}
+	}, true
+}
+
+// needNUMABindingCPUsPlan returns true if cpus of the container can be planned before the write-lock,
+// i.e. it's the main container of a numa_binding dedicated_cores pod admitted with a hint
+func needNUMABindingCPUsPlan(allocationReq *allocationRequest) bool {
+	req := allocationReq.req
+	return allocationReq.qosLevel == apiconsts.PodAnnotationQoSLevelDedicatedCores &&
+		req.Annotations[apiconsts.PodAnnotationMemoryEnhancementNumaBinding] == apiconsts.PodAnnotationMemoryEnhancementNumaBindingEnable &&
+		req.ContainerType == pluginapi.ContainerType_MAIN &&
+		req.Hint != nil && len(req.Hint.Nodes) > 0
+}
+
+// allocateWithNUMALocks allocates the container under the write-lock, and cpus of new numa_binding dedicated_cores
+// containers are planned beforehand with NUMAs of their hints locked (see planNUMABindingCPUs)
+func (p *DynamicPolicy) allocateWithNUMALocks(ctx context.Context,
+	allocationReq *allocationRequest,
+) (*pluginapi.ResourceAllocationResponse, error) {
+	if needNUMABindingCPUsPlan(allocationReq) {
+		if unlockNUMAs, ok := p.lockNUMAs(allocationReq.req.Hint.Nodes); ok {
+			defer unlockNUMAs()
+			ctx = withNUMABindingCPUsPlan(ctx, p.planNUMABindingCPUs(ctx, allocationReq))
+		}
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	return p.allocateWithLock(ctx, allocationReq)
+}
+
+// planNUMABindingCPUs takes cpus for the new container under the read-lock in the same way as
+// dedicatedCoresWithNUMABindingAllocationHandler, nil is returned if it's not a new container, shared_cores
+// pods must be displaced for it, or cpus can't be taken; all of them are handled under the write-lock.
+func (p *DynamicPolicy) planNUMABindingCPUs(ctx context.Context, allocationReq *allocationRequest) *numaBindingCPUsPlan {
+	req, reqInt := allocationReq.req, allocationReq.reqInt
+
+	p.RLock()
+	defer p.RUnlock()
+
+	if p.isShuttingDown() || p.state.GetAllocationInfo(req.PodUid, req.ContainerName) != nil {
+		return nil
+	}
+
+	machineState := p.state.GetMachineState()
+	if _, ok := p.needSharedDisplacement(reqInt, req.Hint, machineState, req.Annotations); ok {
+		return nil
+	}
+
+	cpus, err := p.allocateNumaBindingCPUs(reqInt, req.Hint, machineState, req.Annotations)
+	if err != nil {
+		general.LoggerFromContext(ctx).Warningf("plan cpus on NUMAs: %v failed with error: %v, "+
+			"take them under the write-lock", req.Hint.Nodes, err)
+		return nil
+	}
+
+	return &numaBindingCPUsPlan{
+		availableCPUs: p.getHintAvailableCPUs(req.Hint, machineState),
+		cpus:          cpus,
+	}
+}
+
+// getHintAvailableCPUs returns cpus available on NUMAs of the hint
+func (p *DynamicPolicy) getHintAvailableCPUs(hint *pluginapi.TopologyHint, machineState state.NUMANodeMap) machine.CPUSet {
+	unavailableCPUs := p.getUnavailableCPUs()
+	availableCPUs := machine.NewCPUSet()
+	for _, numaNode := range hint.Nodes {
+		availableCPUs = availableCPUs.Union(machineState[int(numaNode)].GetAvailableCPUSet(unavailableCPUs))
+	}
+	return availableCPUs
+}
+
+// takePlannedNUMABindingCPUs returns cpus planned for the container if cpus available on NUMAs of the hint are
+// unchanged since the plan is made, i.e. cpus taken now would be the same; it must be called with the write-lock.
+func (p *DynamicPolicy) takePlannedNUMABindingCPUs(ctx context.Context, hint *pluginapi.TopologyHint,
+	machineState state.NUMANodeMap,
+) (machine.CPUSet, bool) {
+	plan, ok := getNUMABindingCPUsPlan(ctx)
+	if !ok || hint == nil {
+		return machine.NewCPUSet(), false
+	}
+
+	if availableCPUs := p.getHintAvailableCPUs(hint, machineState); !availableCPUs.Equals(plan.availableCPUs) {
+		general.LoggerFromContext(ctx).Infof("available cpus on NUMAs: %v changed from %s to %s since cpus are planned, "+
+			"take them again", hint.Nodes, plan.availableCPUs.String(), availableCPUs.String())
+		return machine.NewCPUSet(), false
+	}
+	return plan.cpus.Clone(), true
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/errors"
+
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	coreconfig "github.com/kubewharf/katalyst-core/pkg/config"
+	dynamicconfig "github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+// defaultCPUCFSPeriod is the cfs period (in microseconds) used when applying penalized cpu quota
+const defaultCPUCFSPeriod uint64 = 100000
+
+// getNUMADistancePenaltyRatio returns the ratio applied to cpu quota for the given NUMA mask,
+// the max distance between NUMAs in the mask picks the curve entry with the largest distance
+// not exceeding it; 1 is returned if the mask contains a single NUMA or no entry matches.
+func getNUMADistancePenaltyRatio(numaNodes []int, numaDistanceMap map[int][]machine.NumaDistanceInfo,
+	curve map[int]float64,
+) float64 {
+	if len(numaNodes) <= 1 || len(curve) == 0 {
+		return 1
+	}
+
+	maxDistance := 0
+	for _, numaNode := range numaNodes {
+		for _, distanceInfo := range numaDistanceMap[numaNode] {
+			if distanceInfo.NumaID == numaNode {
+				continue
+			}
+
+			for _, peer := range numaNodes {
+				if distanceInfo.NumaID == peer && distanceInfo.Distance > maxDistance {
+					maxDistance = distanceInfo.Distance
+				}
+			}
+		}
+	}
+
+	distances := make([]int, 0, len(curve))
+	for distance := range curve {
+		distances = append(distances, distance)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(distances)))
+
+	for _, distance := range distances {
+		if distance <= maxDistance {
+			return curve[distance]
+		}
+	}
+	return 1
+}
+
+// getNUMADistancePenalizedCPUQuota returns the cpu quota (in microseconds per defaultCPUCFSPeriod)
+// of the cpu limit penalized by the given ratio
+func getNUMADistancePenalizedCPUQuota(cpuLimitMilli int64, ratio float64) int64 {
+	return int64(math.Ceil(float64(cpuLimitMilli) * ratio * float64(defaultCPUCFSPeriod) / 1000))
+}
+
+// applyNUMADistancePenalty adjusts cpu quota of the container by the NUMA distance penalty curve
+// according to NUMAs of its allocation result; containers without cpu limit are skipped.
+func (p *DynamicPolicy) applyNUMADistancePenalty(allocationInfo *state.AllocationInfo) error {
+	if allocationInfo == nil || len(p.numaDistancePenaltyCurve) == 0 {
+		return nil
+	} else if p.metaServer == nil || p.machineInfo == nil || p.machineInfo.ExtraTopologyInfo == nil {
+		return fmt.Errorf("nil metaServer or machineInfo")
+	}
+
+	container, err := p.metaServer.GetContainerSpec(allocationInfo.PodUid, allocationInfo.ContainerName)
+	if err != nil {
+		return fmt.Errorf("get container spec failed with error: %v", err)
+	}
+
+	cpuLimit, ok := container.Resources.Limits[v1.ResourceCPU]
+	if !ok || cpuLimit.IsZero() {
+		return nil
+	}
+
+	numaNodes := make([]int, 0, len(allocationInfo.TopologyAwareAssignments))
+	for numaNode, cset := range allocationInfo.TopologyAwareAssignments {
+		if cset.Size() > 0 {
+			numaNodes = append(numaNodes, numaNode)
+		}
+	}
+	sort.Ints(numaNodes)
+
+	ratio := getNUMADistancePenaltyRatio(numaNodes, p.machineInfo.NumaDistanceMap, p.numaDistancePenaltyCurve)
+	cpuQuota := getNUMADistancePenalizedCPUQuota(cpuLimit.MilliValue(), ratio)
+
+	containerID, err := p.metaServer.GetContainerID(allocationInfo.PodUid, allocationInfo.ContainerName)
+	if err != nil {
+		return fmt.Errorf("get container id failed with error: %v", err)
+	}
+
+	err = p.cpuQuotaApplier.ApplyCPUQuota(allocationInfo.PodUid, containerID, cpuQuota, defaultCPUCFSPeriod)
+	if err != nil {
+		return fmt.Errorf("apply cpu quota: %d failed with error: %v", cpuQuota, err)
+	}
+
+	general.Infof("pod: %s/%s, container: %s with numaNodes: %v applied cpu quota: %d (ratio: %.2f)",
+		allocationInfo.PodNamespace, allocationInfo.PodName, allocationInfo.ContainerName, numaNodes, cpuQuota, ratio)
+	return nil
+}
+
+// syncNUMADistancePenalty periodically applies NUMA distance penalty to shared_cores containers,
+// so that containers not running during admission and containers whose pools are moved are covered;
+// cgroups are written without holding the policy lock.
+func (p *DynamicPolicy) syncNUMADistancePenalty(_ *coreconfig.Configuration,
+	_ interface{},
+	_ *dynamicconfig.DynamicAgentConfiguration,
+	_ metrics.MetricEmitter,
+	_ *metaserver.MetaServer,
+) {
+	general.Infof("exec syncNUMADistancePenalty")
+	var errList []error
+	defer func() {
+		_ = general.UpdateHealthzStateByError(cpuconsts.SyncNUMADistancePenalty, errors.NewAggregate(errList))
+	}()
+
+	if p.metaServer == nil {
+		errList = append(errList, fmt.Errorf("nil metaServer"))
+		return
+	}
+
+	p.RLock()
+	podEntries := p.state.GetPodEntries()
+	p.RUnlock()
+
+	for _, entries := range podEntries {
+		if entries.IsPoolEntry() {
+			continue
+		}
+
+		for _, allocationInfo := range entries {
+			if !state.CheckShared(allocationInfo) {
+				continue
+			}
+
+			// containers not running yet will be handled in the next round
+			if _, err := p.metaServer.GetContainerID(allocationInfo.PodUid, allocationInfo.ContainerName); err != nil {
+				general.Warningf("get container id of pod: %s/%s, container: %s failed with error: %v, skip applying numa distance penalty",
+					allocationInfo.PodNamespace, allocationInfo.PodName, allocationInfo.ContainerName, err)
+				continue
+			}
+
+			if err := p.applyNUMADistancePenalty(allocationInfo); err != nil {
+				general.Errorf("apply numa distance penalty for pod: %s/%s, container: %s failed with error: %v",
+					allocationInfo.PodNamespace, allocationInfo.PodName, allocationInfo.ContainerName, err)
+				errList = append(errList, err)
+			}
+		}
+	}
+}
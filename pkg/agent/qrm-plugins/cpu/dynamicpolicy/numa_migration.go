@@ -0,0 +1,112 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+// numaMigrationRecord is the NUMAs that the main container of a numa_binding pod is allocated on,
+// and how many times the pod has been moved to different NUMAs since it's admitted
+type numaMigrationRecord struct {
+	PodNamespace string         `json:"podNamespace"`
+	PodName      string         `json:"podName"`
+	NUMANodes    machine.CPUSet `json:"numaNodes"`
+	Count        int            `json:"count"`
+}
+
+// updateNUMAMigration records NUMAs of the main container of a numa_binding pod, and counts a migration
+// if they're different from NUMAs recorded before. It must be called with the policy lock held.
+func (p *DynamicPolicy) updateNUMAMigration(allocationInfo *state.AllocationInfo) {
+	if allocationInfo == nil || !allocationInfo.CheckMainContainer() || !state.CheckNUMABinding(allocationInfo) {
+		return
+	}
+
+	numaNodes := allocationInfo.GetAllocationResultNUMASet()
+	if numaNodes.IsEmpty() {
+		return
+	}
+
+	if p.numaMigrations == nil {
+		p.numaMigrations = make(map[string]*numaMigrationRecord)
+	}
+
+	record := p.numaMigrations[allocationInfo.PodUid]
+	if record == nil {
+		p.numaMigrations[allocationInfo.PodUid] = &numaMigrationRecord{
+			PodNamespace: allocationInfo.PodNamespace,
+			PodName:      allocationInfo.PodName,
+			NUMANodes:    numaNodes,
+		}
+		return
+	} else if record.NUMANodes.Equals(numaNodes) {
+		return
+	}
+
+	record.Count++
+	general.Infof("pod: %s/%s is migrated from NUMAs: %s to NUMAs: %s, migrations: %d",
+		allocationInfo.PodNamespace, allocationInfo.PodName, record.NUMANodes.String(), numaNodes.String(), record.Count)
+	_ = p.emitter.StoreInt64(util.MetricNamePodNUMAMigration, int64(record.Count), metrics.MetricTypeNameRaw,
+		metrics.MetricTag{Key: "podNamespace", Val: allocationInfo.PodNamespace},
+		metrics.MetricTag{Key: "podName", Val: allocationInfo.PodName},
+		metrics.MetricTag{Key: "fromNUMAs", Val: record.NUMANodes.String()},
+		metrics.MetricTag{Key: "toNUMAs", Val: numaNodes.String()})
+	record.NUMANodes = numaNodes
+}
+
+// isNUMAMigrationUpToDate returns true if updateNUMAMigration does nothing for the allocation,
+// it must be called with the policy lock (either read or write) held.
+func (p *DynamicPolicy) isNUMAMigrationUpToDate(allocationInfo *state.AllocationInfo) bool {
+	if allocationInfo == nil || !allocationInfo.CheckMainContainer() || !state.CheckNUMABinding(allocationInfo) {
+		return true
+	}
+
+	numaNodes := allocationInfo.GetAllocationResultNUMASet()
+	if numaNodes.IsEmpty() {
+		return true
+	}
+
+	record := p.numaMigrations[allocationInfo.PodUid]
+	return record != nil && record.NUMANodes.Equals(numaNodes)
+}
+
+// clearNUMAMigration drops the record of the pod when it's removed, so that the count starts
+// from zero if a pod with the same uid is admitted again
+func (p *DynamicPolicy) clearNUMAMigration(podUID string) {
+	delete(p.numaMigrations, podUID)
+}
+
+// getNUMAMigrations returns a copy of migration records keyed by pod uid
+func (p *DynamicPolicy) getNUMAMigrations() map[string]*numaMigrationRecord {
+	p.RLock()
+	defer p.RUnlock()
+
+	records := make(map[string]*numaMigrationRecord, len(p.numaMigrations))
+	for podUID, record := range p.numaMigrations {
+		records[podUID] = &numaMigrationRecord{
+			PodNamespace: record.PodNamespace,
+			PodName:      record.PodName,
+			NUMANodes:    record.NUMANodes.Clone(),
+			Count:        record.Count,
+		}
+	}
+	return records
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"math"
+
+	v1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+// getNamespaceReservedNUMAs returns NUMAs reserved for the namespace, and NUMAs reserved for other namespaces
+func (p *DynamicPolicy) getNamespaceReservedNUMAs(namespace string) (owned, excluded machine.CPUSet) {
+	owned, excluded = machine.NewCPUSet(), machine.NewCPUSet()
+	for numaID, reservedNamespace := range p.numaNamespaceReservations {
+		if reservedNamespace == namespace {
+			owned.Add(numaID)
+		} else {
+			excluded.Add(numaID)
+		}
+	}
+	return owned, excluded
+}
+
+// namespaceExcludedNUMAsContextKey is the key of NUMAs reserved for namespaces other than the one of the request
+type namespaceExcludedNUMAsContextKey struct{}
+
+// withNamespaceExcludedNUMAs carries NUMAs reserved for namespaces other than the one of the request
+// in the returned context, so that they're excluded from candidates in hints calculation
+func (p *DynamicPolicy) withNamespaceExcludedNUMAs(ctx context.Context, req *pluginapi.ResourceRequest) context.Context {
+	_, excluded := p.getNamespaceReservedNUMAs(req.PodNamespace)
+	if excluded.IsEmpty() {
+		return ctx
+	}
+	return context.WithValue(ctx, namespaceExcludedNUMAsContextKey{}, excluded)
+}
+
+// getNamespaceExcludedNUMAsFromContext returns NUMAs reserved for other namespaces carried in context
+func getNamespaceExcludedNUMAsFromContext(ctx context.Context) machine.CPUSet {
+	if ctx == nil {
+		return machine.NewCPUSet()
+	}
+
+	excluded, ok := ctx.Value(namespaceExcludedNUMAsContextKey{}).(machine.CPUSet)
+	if !ok {
+		return machine.NewCPUSet()
+	}
+	return excluded
+}
+
+// filterNamespaceExcludedNUMANodes drops NUMA nodes reserved for namespaces other than the one of the request,
+// it takes no effect if there is no such NUMA carried in context.
+func filterNamespaceExcludedNUMANodes(ctx context.Context, numaNodes []int) []int {
+	excluded := getNamespaceExcludedNUMAsFromContext(ctx)
+	if excluded.IsEmpty() {
+		return numaNodes
+	}
+
+	filteredNUMANodes := make([]int, 0, len(numaNodes))
+	for _, numaNode := range numaNodes {
+		if excluded.Contains(numaNode) {
+			general.InfofV(4, "skip NUMA: %d reserved for other namespaces", numaNode)
+			continue
+		}
+		filteredNUMANodes = append(filteredNUMANodes, numaNode)
+	}
+	return filteredNUMANodes
+}
+
+// preferNamespaceReservedNUMAs prefers hints within NUMAs reserved for the namespace of the request, i.e.
+// if any of them has the same number of NUMAs as preferred hints, only they are preferred. all hints are
+// feasible as long as any of them is preferred, and it takes no effect if the preferred NUMA is forced by annotation.
+func (p *DynamicPolicy) preferNamespaceReservedNUMAs(ctx context.Context, req *pluginapi.ResourceRequest,
+	hints map[string]*pluginapi.ListOfTopologyHints,
+) {
+	if hints[string(v1.ResourceCPU)] == nil || !hasPreferredHint(hints[string(v1.ResourceCPU)].Hints) {
+		return
+	} else if _, ok := p.getPreferredNUMAOverride(ctx, req.Annotations); ok {
+		return
+	}
+
+	owned, _ := p.getNamespaceReservedNUMAs(req.PodNamespace)
+	if owned.IsEmpty() {
+		return
+	}
+
+	cpuHints := hints[string(v1.ResourceCPU)].Hints
+	minNUMAs := math.MaxInt
+	for _, hint := range cpuHints {
+		if hint.Preferred && len(hint.Nodes) < minNUMAs {
+			minNUMAs = len(hint.Nodes)
+		}
+	}
+
+	withinOwned := func(hint *pluginapi.TopologyHint) bool {
+		if len(hint.Nodes) != minNUMAs {
+			return false
+		}
+
+		for _, nodeID := range hint.Nodes {
+			if !owned.Contains(int(nodeID)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	found := false
+	for _, hint := range cpuHints {
+		if withinOwned(hint) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return
+	}
+
+	general.LoggerFromContext(ctx).Infof("pod: %s/%s, container: %s prefer NUMAs: %s reserved for its namespace",
+		req.PodNamespace, req.PodName, req.ContainerName, owned.String())
+	for _, hint := range cpuHints {
+		hint.Preferred = withinOwned(hint)
+	}
+}
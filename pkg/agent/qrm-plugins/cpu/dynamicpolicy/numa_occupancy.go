@@ -0,0 +1,66 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+)
+
+// getNUMAOccupancy returns occupancy status of each NUMA derived from containers in machine state,
+// a NUMA is exclusive if any dedicated_cores container with numa_binding is allocated on it,
+// shared if it's only allocated with other containers, and free if no container is allocated on it;
+// pools are ignored since they don't lock NUMAs by themselves.
+func getNUMAOccupancy(machineState state.NUMANodeMap) map[int]string {
+	occupancy := make(map[int]string, len(machineState))
+	for numaID, numaState := range machineState {
+		occupancy[numaID] = cpuconsts.NUMAOccupancyFree
+		if numaState == nil {
+			continue
+		}
+
+		for _, containerEntries := range numaState.PodEntries {
+			if containerEntries.IsPoolEntry() {
+				continue
+			}
+
+			for _, allocationInfo := range containerEntries {
+				if allocationInfo == nil {
+					continue
+				} else if state.CheckDedicatedNUMABinding(allocationInfo) {
+					occupancy[numaID] = cpuconsts.NUMAOccupancyExclusive
+					break
+				}
+				occupancy[numaID] = cpuconsts.NUMAOccupancyShared
+			}
+
+			if occupancy[numaID] == cpuconsts.NUMAOccupancyExclusive {
+				break
+			}
+		}
+	}
+	return occupancy
+}
+
+// GetNUMAOccupancy returns occupancy status (free/shared/exclusive) of each NUMA,
+// it's also reported in annotations of allocatable topology aware quantities.
+func (p *DynamicPolicy) GetNUMAOccupancy() map[int]string {
+	p.RLock()
+	defer p.RUnlock()
+
+	return getNUMAOccupancy(p.state.GetMachineState())
+}
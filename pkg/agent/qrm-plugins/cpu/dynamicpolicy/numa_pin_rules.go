@@ -0,0 +1,224 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	coreconfig "github.com/kubewharf/katalyst-core/pkg/config"
+	dynamicconfig "github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+const syncNUMAPinRulesPeriod = 10 * time.Second
+
+// NUMAPinRule pins numa_binding containers of pods matching the selector to the given NUMAs,
+// rules are loaded from a file (usually mounted from a ConfigMap) in json list format.
+type NUMAPinRule struct {
+	Name string `json:"name"`
+	// Namespaces restricts the rule to pods in these namespaces, empty means all namespaces
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Selector is matched against labels of pods, it's required and empty selector matches all pods
+	Selector *metav1.LabelSelector `json:"selector"`
+	// NUMANodes is the NUMAs (in cpuset format, eg. "0-1") that matched pods are pinned to
+	NUMANodes string `json:"numaNodes"`
+	// Fallback indicates whether matched pods fall back to all NUMAs if none of the pinned NUMAs fits,
+	// otherwise their admission fails
+	Fallback bool `json:"fallback,omitempty"`
+}
+
+// numaPinRule is the parsed form of NUMAPinRule
+type numaPinRule struct {
+	name       string
+	namespaces sets.String
+	selector   labels.Selector
+	numaNodes  machine.CPUSet
+	fallback   bool
+}
+
+func (r *numaPinRule) matches(req *pluginapi.ResourceRequest) bool {
+	if r.namespaces.Len() > 0 && !r.namespaces.Has(req.PodNamespace) {
+		return false
+	}
+	return r.selector.Matches(labels.Set(req.Labels))
+}
+
+// loadNUMAPinRules parses rules from the file, NUMAs of rules must be among the given ones;
+// there is no rule if the file doesn't exist.
+func loadNUMAPinRules(fileAbsPath string, numaNodes machine.CPUSet) ([]*numaPinRule, error) {
+	content, err := ioutil.ReadFile(fileAbsPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read file failed with error: %v", err)
+	}
+
+	var pinRules []*NUMAPinRule
+	if err = json.Unmarshal(content, &pinRules); err != nil {
+		return nil, fmt.Errorf("unmarshal numa pin rules failed with error: %v", err)
+	}
+
+	rules := make([]*numaPinRule, 0, len(pinRules))
+	for i, pinRule := range pinRules {
+		if pinRule == nil {
+			return nil, fmt.Errorf("rule %d is nil", i)
+		} else if pinRule.Name == "" {
+			return nil, fmt.Errorf("rule %d has empty name", i)
+		} else if pinRule.Selector == nil {
+			return nil, fmt.Errorf("rule: %s has nil selector", pinRule.Name)
+		}
+
+		selector, err := metav1.LabelSelectorAsSelector(pinRule.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("parse selector of rule: %s failed with error: %v", pinRule.Name, err)
+		}
+
+		pinnedNUMAs, err := machine.Parse(pinRule.NUMANodes)
+		if err != nil {
+			return nil, fmt.Errorf("parse numa nodes of rule: %s failed with error: %v", pinRule.Name, err)
+		} else if pinnedNUMAs.IsEmpty() {
+			return nil, fmt.Errorf("rule: %s has empty numa nodes", pinRule.Name)
+		} else if !pinnedNUMAs.IsSubsetOf(numaNodes) {
+			return nil, fmt.Errorf("numa nodes: %s of rule: %s aren't subset of numa nodes: %s",
+				pinnedNUMAs.String(), pinRule.Name, numaNodes.String())
+		}
+
+		rules = append(rules, &numaPinRule{
+			name:       pinRule.Name,
+			namespaces: sets.NewString(pinRule.Namespaces...),
+			selector:   selector,
+			numaNodes:  pinnedNUMAs,
+			fallback:   pinRule.Fallback,
+		})
+	}
+
+	return rules, nil
+}
+
+// syncNUMAPinRules is used to reload numa pin rules from the file configured,
+// rules in effect are kept if the file fails to be parsed.
+func (p *DynamicPolicy) syncNUMAPinRules(_ *coreconfig.Configuration,
+	_ interface{},
+	_ *dynamicconfig.DynamicAgentConfiguration,
+	_ metrics.MetricEmitter,
+	_ *metaserver.MetaServer,
+) {
+	general.Infof("exec syncNUMAPinRules")
+	var err error
+	defer func() {
+		_ = general.UpdateHealthzStateByError(cpuconsts.SyncNUMAPinRules, err)
+	}()
+
+	rules, err := loadNUMAPinRules(p.numaPinRulesFileAbsPath, p.machineInfo.CPUDetails.NUMANodes())
+	if err != nil {
+		general.Errorf("loadNUMAPinRules from %s failed with error: %v", p.numaPinRulesFileAbsPath, err)
+		return
+	}
+
+	p.setNUMAPinRules(rules)
+}
+
+func (p *DynamicPolicy) setNUMAPinRules(rules []*numaPinRule) {
+	p.numaPinRulesMutex.Lock()
+	defer p.numaPinRulesMutex.Unlock()
+
+	if len(rules) != len(p.numaPinRules) {
+		general.Infof("numa pin rules count transforms from %d to %d", len(p.numaPinRules), len(rules))
+	}
+	p.numaPinRules = rules
+}
+
+// matchNUMAPinRule returns the first rule matching the request in order, rules matched later with
+// different NUMAs are conflicting and ignored. it must be called before labels of the request are filtered.
+func (p *DynamicPolicy) matchNUMAPinRule(ctx context.Context, req *pluginapi.ResourceRequest) *numaPinRule {
+	p.numaPinRulesMutex.RLock()
+	defer p.numaPinRulesMutex.RUnlock()
+
+	var matchedRule *numaPinRule
+	for _, rule := range p.numaPinRules {
+		if !rule.matches(req) {
+			continue
+		}
+
+		if matchedRule == nil {
+			matchedRule = rule
+		} else if !matchedRule.numaNodes.Equals(rule.numaNodes) {
+			general.LoggerFromContext(ctx).Warningf("numa pin rule: %s with NUMAs: %s conflicts with rule: %s with NUMAs: %s, ignore it",
+				rule.name, rule.numaNodes.String(), matchedRule.name, matchedRule.numaNodes.String())
+		}
+	}
+	return matchedRule
+}
+
+// numaPinRuleContextKey is the key of the numa pin rule matched by the request in request context
+type numaPinRuleContextKey struct{}
+
+// withNUMAPinRule carries the numa pin rule matched by the request in the returned context
+func (p *DynamicPolicy) withNUMAPinRule(ctx context.Context, req *pluginapi.ResourceRequest) context.Context {
+	rule := p.matchNUMAPinRule(ctx, req)
+	if rule == nil {
+		return ctx
+	}
+
+	general.LoggerFromContext(ctx).Infof("pod matches numa pin rule: %s, pinned to NUMAs: %s",
+		rule.name, rule.numaNodes.String())
+	return context.WithValue(ctx, numaPinRuleContextKey{}, rule)
+}
+
+// withoutNUMAPinRule drops the numa pin rule carried in context
+func withoutNUMAPinRule(ctx context.Context) context.Context {
+	return context.WithValue(ctx, numaPinRuleContextKey{}, (*numaPinRule)(nil))
+}
+
+// getNUMAPinRuleFromContext returns the numa pin rule carried in context
+func getNUMAPinRuleFromContext(ctx context.Context) (*numaPinRule, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+
+	rule, ok := ctx.Value(numaPinRuleContextKey{}).(*numaPinRule)
+	return rule, ok && rule != nil
+}
+
+// shouldFallbackFromNUMAPinRule returns true if the pod is pinned by a fallback rule but no preferred hint
+// is found within the pinned NUMAs, so that hints should be re-calculated without the rule.
+func shouldFallbackFromNUMAPinRule(ctx context.Context, resp *pluginapi.ResourceHintsResponse, hintsErr error) bool {
+	rule, ok := getNUMAPinRuleFromContext(ctx)
+	if !ok || !rule.fallback {
+		return false
+	} else if hintsErr != nil || resp == nil {
+		return true
+	}
+
+	hints := resp.ResourceHints[string(v1.ResourceCPU)]
+	return hints != nil && !hasPreferredHint(hints.Hints)
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// countNUMABindingSharedPods returns the number of numa_binding shared_cores pods on each NUMA,
+// a pod is counted once on a NUMA no matter how many containers it has there.
+func countNUMABindingSharedPods(podEntries state.PodEntries) map[int]int {
+	pods := make(map[int]int)
+	for _, entries := range podEntries {
+		if entries.IsPoolEntry() {
+			continue
+		}
+
+		for _, numaID := range getSharedNUMABindingNUMAsOfPod(entries) {
+			pods[numaID]++
+		}
+	}
+	return pods
+}
+
+// filterNUMANodesAtSharedPodsCap excludes NUMAs that already host maxNUMABindingSharedPods numa_binding
+// shared_cores pods, so that new ones don't contend with too many others on the same NUMA.
+// it takes no effect if maxNUMABindingSharedPods is non-positive.
+func (p *DynamicPolicy) filterNUMANodesAtSharedPodsCap(ctx context.Context, podEntries state.PodEntries,
+	numaNodes []int,
+) []int {
+	if p.maxNUMABindingSharedPods <= 0 {
+		return numaNodes
+	}
+
+	pods := countNUMABindingSharedPods(podEntries)
+	filteredNUMANodes := make([]int, 0, len(numaNodes))
+	for _, numaNode := range numaNodes {
+		if pods[numaNode] >= p.maxNUMABindingSharedPods {
+			general.LoggerFromContext(ctx).Infof("skip NUMA: %d with %d numa_binding shared_cores pods at the cap: %d",
+				numaNode, pods[numaNode], p.maxNUMABindingSharedPods)
+			continue
+		}
+		filteredNUMANodes = append(filteredNUMANodes, numaNode)
+	}
+	return filteredNUMANodes
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"math"
+
+	v1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
+)
+
+// numaSpreadLabel is the label identifying replicas of the workload that a container is spread with
+type numaSpreadLabel struct {
+	key   string
+	value string
+}
+
+// getNUMASpreadLabel returns the label (keyed by numa_spread_key enhancement) identifying replicas of the workload
+// of the request, or nil if it's not set. since labels are filtered by GetKatalystQoSLevelFromResourceReq,
+// it must be called before that.
+func (p *DynamicPolicy) getNUMASpreadLabel(req *pluginapi.ResourceRequest) *numaSpreadLabel {
+	labelKey, found := qosutil.GetNUMASpreadKey(p.qosConfig.FilterQoSAndEnhancementMap(req.Annotations))
+	if !found {
+		return nil
+	}
+
+	value, ok := req.Labels[labelKey]
+	if !ok {
+		general.Warningf("pod: %s/%s, container: %s has no label: %s to be spread across NUMAs by",
+			req.PodNamespace, req.PodName, req.ContainerName, labelKey)
+		return nil
+	}
+	return &numaSpreadLabel{key: labelKey, value: value}
+}
+
+// keepNUMASpreadLabel puts the label back into (filtered) labels of the request,
+// so that it's kept in allocation info and replicas can be counted from pod entries.
+func keepNUMASpreadLabel(req *pluginapi.ResourceRequest, label *numaSpreadLabel) {
+	if label == nil {
+		return
+	}
+
+	if req.Labels == nil {
+		req.Labels = make(map[string]string)
+	}
+	req.Labels[label.key] = label.value
+}
+
+// countNUMASpreadReplicas returns the number of numa_binding replicas of the workload of the request
+// on each NUMA, the pod of the request itself isn't counted. nil is returned if the request isn't spread.
+func countNUMASpreadReplicas(req *pluginapi.ResourceRequest, podEntries state.PodEntries) map[int]int {
+	labelKey, found := qosutil.GetNUMASpreadKey(req.Annotations)
+	if !found {
+		return nil
+	}
+
+	value, ok := req.Labels[labelKey]
+	if !ok {
+		return nil
+	}
+
+	replicas := make(map[int]int)
+	for podUID, entries := range podEntries {
+		if podUID == req.PodUid || entries.IsPoolEntry() {
+			continue
+		}
+
+		mainContainerEntry := entries.GetMainContainerEntry()
+		if !state.CheckNUMABinding(mainContainerEntry) || mainContainerEntry.Labels[labelKey] != value {
+			continue
+		}
+
+		for _, numaID := range mainContainerEntry.GetAllocationResultNUMASet().ToSliceNoSortInt() {
+			replicas[numaID]++
+		}
+	}
+	return replicas
+}
+
+// spreadHintsByReplicas steers numa_binding containers of a workload (by numa_spread_key enhancement)
+// away from NUMAs already hosting its replicas, i.e. if preferred hints host more replicas than other
+// hints with the same number of NUMAs, the former are demoted and the latter are preferred instead.
+// all hints are feasible as long as any of them is preferred, so fallback hints are kept as they are,
+// and it takes no effect if the preferred NUMA is forced by annotation.
+func (p *DynamicPolicy) spreadHintsByReplicas(ctx context.Context, req *pluginapi.ResourceRequest,
+	podEntries state.PodEntries, hints map[string]*pluginapi.ListOfTopologyHints,
+) {
+	logger := general.LoggerFromContext(ctx)
+	if hints[string(v1.ResourceCPU)] == nil || !hasPreferredHint(hints[string(v1.ResourceCPU)].Hints) {
+		return
+	} else if _, ok := p.getPreferredNUMAOverride(ctx, req.Annotations); ok {
+		return
+	}
+
+	replicas := countNUMASpreadReplicas(req, podEntries)
+	if len(replicas) == 0 {
+		return
+	}
+
+	cpuHints := hints[string(v1.ResourceCPU)].Hints
+	hintReplicas := make([]int, len(cpuHints))
+	minNUMAs := math.MaxInt
+	for i, hint := range cpuHints {
+		for _, nodeID := range hint.Nodes {
+			hintReplicas[i] += replicas[int(nodeID)]
+		}
+
+		if hint.Preferred && len(hint.Nodes) < minNUMAs {
+			minNUMAs = len(hint.Nodes)
+		}
+	}
+
+	minReplicas := math.MaxInt
+	for i, hint := range cpuHints {
+		if len(hint.Nodes) == minNUMAs && hintReplicas[i] < minReplicas {
+			minReplicas = hintReplicas[i]
+		}
+	}
+
+	crowded := false
+	for i, hint := range cpuHints {
+		if hint.Preferred && hintReplicas[i] > minReplicas {
+			crowded = true
+			break
+		}
+	}
+	if !crowded {
+		return
+	}
+
+	for i, hint := range cpuHints {
+		preferred := len(hint.Nodes) == minNUMAs && hintReplicas[i] == minReplicas
+		if hint.Preferred != preferred {
+			logger.Infof("pod: %s/%s, container: %s set preferred of hint: %v to %v for it hosts %d replicas",
+				req.PodNamespace, req.PodName, req.ContainerName, hint.Nodes, preferred, hintReplicas[i])
+		}
+		hint.Preferred = preferred
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	coreconsts "github.com/kubewharf/katalyst-core/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+func (p *DynamicPolicy) isNUMAThrottleAwarenessEnabled() bool {
+	return p.numaThrottleRatioThreshold > 0 || p.numaTemperatureThreshold > 0
+}
+
+// isNUMAThrottled returns true if the throttle ratio or temperature of the NUMA exceeds its threshold,
+// missing metrics are treated as neutral, i.e. the NUMA isn't throttled.
+func (p *DynamicPolicy) isNUMAThrottled(ctx context.Context, numaID int) bool {
+	logger := general.LoggerFromContext(ctx)
+	thresholds := []struct {
+		metricName string
+		threshold  float64
+	}{
+		{metricName: coreconsts.MetricCPUThrottleRatioNuma, threshold: p.numaThrottleRatioThreshold},
+		{metricName: coreconsts.MetricCPUTemperatureNuma, threshold: p.numaTemperatureThreshold},
+	}
+
+	for _, t := range thresholds {
+		if t.threshold <= 0 {
+			continue
+		}
+
+		data, err := p.getNUMAMetric(numaID, t.metricName)
+		if err != nil {
+			logger.InfofV(4, "get metric: %s of NUMA: %d failed with error: %v, treat it as neutral",
+				t.metricName, numaID, err)
+			continue
+		}
+
+		if data.Value > t.threshold {
+			logger.Infof("NUMA: %d is throttled with metric: %s value: %.2f exceeding threshold: %.2f",
+				numaID, t.metricName, data.Value, t.threshold)
+			return true
+		}
+	}
+	return false
+}
+
+// demoteHintsByThrottledNUMAs marks preferred hints as non-preferred if NUMA nodes in them are throttled
+// by frequency or temperature, to steer new work away from them. hints are kept as they are if all
+// preferred ones would be demoted, since throttling alone shouldn't fail the admission.
+// it takes no effect if neither threshold is set or the metric store is unavailable, i.e. hints
+// fall back to be decided by cpus only.
+func (p *DynamicPolicy) demoteHintsByThrottledNUMAs(ctx context.Context, req *pluginapi.ResourceRequest,
+	hints map[string]*pluginapi.ListOfTopologyHints,
+) {
+	logger := general.LoggerFromContext(ctx)
+	if !p.isNUMAThrottleAwarenessEnabled() || hints[string(v1.ResourceCPU)] == nil || !p.isMetricStoreAvailable() {
+		return
+	}
+
+	throttledNUMAs := make(map[int]bool)
+	var demotedHints, keptHints []*pluginapi.TopologyHint
+	for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+		if hint == nil || !hint.Preferred {
+			continue
+		}
+
+		throttled := false
+		for _, nodeID := range hint.Nodes {
+			numaID := int(nodeID)
+			if _, ok := throttledNUMAs[numaID]; !ok {
+				throttledNUMAs[numaID] = p.isNUMAThrottled(ctx, numaID)
+			}
+			throttled = throttled || throttledNUMAs[numaID]
+		}
+
+		if throttled {
+			demotedHints = append(demotedHints, hint)
+		} else {
+			keptHints = append(keptHints, hint)
+		}
+	}
+
+	if len(demotedHints) == 0 {
+		return
+	} else if len(keptHints) == 0 {
+		logger.Warningf("pod: %s/%s, container: %s all preferred hints contain throttled NUMAs, keep them",
+			req.PodNamespace, req.PodName, req.ContainerName)
+		return
+	}
+
+	for _, hint := range demotedHints {
+		logger.Infof("pod: %s/%s, container: %s demote hint: %v since it contains throttled NUMAs",
+			req.PodNamespace, req.PodName, req.ContainerName, hint.Nodes)
+		hint.Preferred = false
+	}
+}
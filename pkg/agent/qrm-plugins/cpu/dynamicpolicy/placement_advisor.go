@@ -0,0 +1,202 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	"github.com/kubewharf/katalyst-api/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/placementadvisor"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/process"
+	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
+)
+
+const (
+	placementAdvisorDialTimeout   = 5 * time.Second
+	placementAdvisorRetryInterval = 10 * time.Second
+)
+
+// initPlacementAdvisorClientConn initializes the connection to the external placement advisor
+func (p *DynamicPolicy) initPlacementAdvisorClientConn() error {
+	conn, err := process.Dial(p.placementAdvisorSocketAbsPath, placementAdvisorDialTimeout)
+	if err != nil {
+		return fmt.Errorf("get placement advisor connection with socket: %s failed with error: %v",
+			p.placementAdvisorSocketAbsPath, err)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.placementAdvisorClient = placementadvisor.NewPlacementAdvisorClient(conn)
+	p.placementAdvisorConn = conn
+	return nil
+}
+
+// placementAdviceContextKey is the key of the NUMA advised by the external placement advisor in request context
+type placementAdviceContextKey struct{}
+
+// withPlacementAdvice asks the external placement advisor for the preferred NUMA of numa_binding shared_cores
+// main containers and carries the advice in the returned context; NUMA states are snapshotted under read lock,
+// but the remote call is made without holding the policy lock, so it must be called before locking.
+func (p *DynamicPolicy) withPlacementAdvice(ctx context.Context, req *pluginapi.ResourceRequest,
+	qosLevel string, reqInt int,
+) context.Context {
+	if qosLevel != consts.PodAnnotationQoSLevelSharedCores ||
+		!qosutil.AnnotationsIndicateNUMABinding(req.Annotations) ||
+		req.ContainerType != pluginapi.ContainerType_MAIN {
+		return ctx
+	} else if _, ok := p.getPreferredNUMAOverride(ctx, req.Annotations); ok {
+		return ctx
+	}
+
+	p.RLock()
+	client := p.placementAdvisorClient
+	var numaStates []*placementadvisor.NUMAState
+	if client != nil {
+		numaStates = p.getPlacementAdvisorNUMAStates()
+	}
+	p.RUnlock()
+
+	if client == nil {
+		return ctx
+	}
+
+	advisedNUMA, ok := p.getPlacementAdvisorPreferredNUMA(ctx, client, req, reqInt, numaStates)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, placementAdviceContextKey{}, advisedNUMA)
+}
+
+// getPlacementAdviceFromContext returns the NUMA advised by the external placement advisor carried in context
+func getPlacementAdviceFromContext(ctx context.Context) (int, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+
+	advisedNUMA, ok := ctx.Value(placementAdviceContextKey{}).(int)
+	return advisedNUMA, ok
+}
+
+// getPlacementAdvisorNUMAStates returns the current NUMA states reported to the external placement advisor,
+// it must be called with the policy lock held.
+func (p *DynamicPolicy) getPlacementAdvisorNUMAStates() []*placementadvisor.NUMAState {
+	machineState := p.state.GetMachineState()
+	sharedNUMAPools := p.state.GetSharedNUMAPools()
+	unavailableCPUs := p.getUnavailableCPUs()
+
+	numaStates := make([]*placementadvisor.NUMAState, 0, len(machineState))
+	for _, numaID := range p.machineInfo.CPUDetails.NUMANodes().ToSliceInt() {
+		if machineState[numaID] == nil {
+			continue
+		}
+
+		availableQuantity := p.getNUMAAvailableCPUQuantity(sharedNUMAPools, numaID, unavailableCPUs)
+		if availableQuantity < 0 {
+			availableQuantity = 0
+		}
+
+		numaStates = append(numaStates, &placementadvisor.NUMAState{
+			NumaId:              uint64(numaID),
+			AllocatableQuantity: uint64(p.getNUMAAllocatableCPUQuantity(numaID)),
+			AvailableQuantity:   uint64(availableQuantity),
+			NumaBinding:         machineState[numaID].ExistMatchedAllocationInfo(state.CheckNUMABinding),
+		})
+	}
+	return numaStates
+}
+
+// getPlacementAdvisorPreferredNUMA asks the external placement advisor for the preferred NUMA of the request
+// within placementAdvisorTimeout; false is returned if the advisor fails or has no preference.
+func (p *DynamicPolicy) getPlacementAdvisorPreferredNUMA(ctx context.Context, client placementadvisor.PlacementAdvisorClient,
+	req *pluginapi.ResourceRequest, reqInt int, numaStates []*placementadvisor.NUMAState,
+) (int, bool) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	logger := general.LoggerFromContext(ctx)
+	ctx, cancel := context.WithTimeout(ctx, p.placementAdvisorTimeout)
+	defer cancel()
+
+	resp, err := client.GetPreferredNUMA(ctx, &placementadvisor.GetPreferredNUMARequest{
+		PodUid:          req.PodUid,
+		PodNamespace:    req.PodNamespace,
+		PodName:         req.PodName,
+		ContainerName:   req.ContainerName,
+		QosLevel:        req.Annotations[consts.PodAnnotationQoSLevelKey],
+		Labels:          req.Labels,
+		Annotations:     req.Annotations,
+		RequestQuantity: uint64(reqInt),
+		NumaStates:      numaStates,
+	})
+	if err != nil {
+		logger.Warningf("pod: %s/%s, container: %s get preferred NUMA from placement advisor failed with error: %v",
+			req.PodNamespace, req.PodName, req.ContainerName, err)
+		return 0, false
+	} else if !resp.HasPreference {
+		return 0, false
+	}
+	return int(resp.PreferredNuma), true
+}
+
+// preferPlacementAdvisorNUMA marks the single-NUMA hint advised by the external placement advisor
+// (fetched by withPlacementAdvice in advance) as the only preferred one; built-in preference is kept
+// if the advised NUMA isn't a valid candidate any more.
+// it takes no effect if preferred NUMA is forced by annotation.
+func (p *DynamicPolicy) preferPlacementAdvisorNUMA(ctx context.Context, req *pluginapi.ResourceRequest, reqInt int,
+	machineState state.NUMANodeMap, sharedNUMAPools state.SharedNUMAPools, hints map[string]*pluginapi.ListOfTopologyHints,
+) {
+	logger := general.LoggerFromContext(ctx)
+	if hints[string(v1.ResourceCPU)] == nil {
+		return
+	} else if _, ok := p.getPreferredNUMAOverride(ctx, req.Annotations); ok {
+		return
+	}
+
+	advisedNUMA, ok := getPlacementAdviceFromContext(ctx)
+	if !ok {
+		return
+	}
+
+	found := false
+	for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+		if len(hint.Nodes) == 1 && hint.Nodes[0] == uint64(advisedNUMA) {
+			found = true
+			break
+		}
+	}
+
+	if !found || machineState[advisedNUMA] == nil ||
+		p.getNUMAAvailableCPUQuantity(sharedNUMAPools, advisedNUMA, p.getUnavailableCPUs()) < reqInt {
+		logger.Warningf("pod: %s/%s, container: %s NUMA: %d advised by placement advisor isn't a valid candidate",
+			req.PodNamespace, req.PodName, req.ContainerName, advisedNUMA)
+		return
+	}
+
+	logger.Infof("pod: %s/%s, container: %s prefer NUMA: %d advised by placement advisor",
+		req.PodNamespace, req.PodName, req.ContainerName, advisedNUMA)
+	for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+		hint.Preferred = len(hint.Nodes) == 1 && hint.Nodes[0] == uint64(advisedNUMA)
+	}
+}
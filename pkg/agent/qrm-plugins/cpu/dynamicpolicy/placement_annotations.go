@@ -0,0 +1,179 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	"github.com/kubewharf/katalyst-api/pkg/consts"
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	coreconfig "github.com/kubewharf/katalyst-core/pkg/config"
+	dynamicconfig "github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
+)
+
+const (
+	syncPlacementAnnotationsPeriod = 10 * time.Second
+
+	patchPlacementAnnotationsTimeout = 5 * time.Second
+)
+
+// getEffectivePreferPolicy returns the NUMA hint prefer policy that takes effect on the numa_binding shared_cores
+// main container against current state, empty string is returned for other containers. it must be called with
+// the policy lock held before the container is allocated.
+func (p *DynamicPolicy) getEffectivePreferPolicy(ctx context.Context, req *pluginapi.ResourceRequest, qosLevel string, reqInt int) string {
+	if qosLevel != consts.PodAnnotationQoSLevelSharedCores ||
+		req.ContainerType != pluginapi.ContainerType_MAIN ||
+		!qosutil.AnnotationsIndicateNUMABinding(req.Annotations) {
+		return ""
+	}
+
+	_, preferPolicy, err := p.filterNUMANodesForNUMABindingSharedCores(ctx, reqInt, p.state.GetPodEntries(),
+		p.state.GetMachineState(), p.state.GetSharedNUMAPools(), req.Annotations,
+		p.getNUMAConstraint(ctx, req.Hint, req.Annotations), nil)
+	if err != nil {
+		general.LoggerFromContext(ctx).Warningf("pod: %s/%s, container: %s get effective prefer policy failed with error: %v",
+			req.PodNamespace, req.PodName, req.ContainerName, err)
+		return ""
+	}
+	return preferPolicy
+}
+
+// recordPreferPolicy stores the effective prefer policy in the allocation of the container,
+// it must be called with the policy lock held after the container is allocated.
+func (p *DynamicPolicy) recordPreferPolicy(podUID, containerName, preferPolicy string) {
+	allocationInfo := p.state.GetAllocationInfo(podUID, containerName)
+	if allocationInfo == nil || preferPolicy == "" {
+		return
+	}
+
+	allocationInfo.Annotations = general.MergeMap(allocationInfo.Annotations, map[string]string{
+		cpuconsts.CPUStateAnnotationKeyPreferPolicy: preferPolicy,
+	})
+	p.state.SetAllocationInfo(podUID, containerName, allocationInfo)
+}
+
+// getPlacementAnnotations returns annotations recording placement of the main container of each numa_binding
+// pod, keyed by pod uid. it must be called with the policy lock held.
+func (p *DynamicPolicy) getPlacementAnnotations() map[string]map[string]string {
+	placements := make(map[string]map[string]string)
+	for podUID, containerEntries := range p.state.GetPodEntries() {
+		if containerEntries.IsPoolEntry() {
+			continue
+		}
+
+		for _, allocationInfo := range containerEntries {
+			if !allocationInfo.CheckMainContainer() || !state.CheckNUMABinding(allocationInfo) ||
+				allocationInfo.AllocationResult.IsEmpty() {
+				continue
+			}
+
+			annotations := map[string]string{
+				cpuconsts.PodAnnotationCPUPlacementNUMAs:  allocationInfo.GetAllocationResultNUMASet().String(),
+				cpuconsts.PodAnnotationCPUPlacementCPUSet: allocationInfo.AllocationResult.String(),
+			}
+			if preferPolicy := allocationInfo.Annotations[cpuconsts.CPUStateAnnotationKeyPreferPolicy]; preferPolicy != "" {
+				annotations[cpuconsts.PodAnnotationCPUPlacementPreferPolicy] = preferPolicy
+			}
+			placements[podUID] = annotations
+		}
+	}
+	return placements
+}
+
+// syncPlacementAnnotations writes back placement of numa_binding pods into their annotations, so that operators
+// can tell where pods land without reading node state. pods that fail to be patched (e.g. the agent isn't allowed
+// to write pod objects) are only logged and retried in the next round, admission is never affected.
+func (p *DynamicPolicy) syncPlacementAnnotations(_ *coreconfig.Configuration,
+	_ interface{},
+	_ *dynamicconfig.DynamicAgentConfiguration,
+	_ metrics.MetricEmitter,
+	_ *metaserver.MetaServer,
+) {
+	if p.metaServer == nil || p.podUpdater == nil {
+		general.Errorf("sync placement annotations with nil metaServer or podUpdater")
+		return
+	}
+
+	p.RLock()
+	placements := p.getPlacementAnnotations()
+	p.RUnlock()
+
+	if p.patchedPlacements == nil {
+		p.patchedPlacements = make(map[string]map[string]string)
+	}
+	for podUID := range p.patchedPlacements {
+		if _, ok := placements[podUID]; !ok {
+			delete(p.patchedPlacements, podUID)
+		}
+	}
+
+	for podUID, annotations := range placements {
+		if general.CheckMapEqual(p.patchedPlacements[podUID], annotations) {
+			continue
+		}
+
+		if err := p.patchPlacementAnnotations(podUID, annotations); err != nil {
+			general.Warningf("patch placement annotations of pod: %s failed with error: %v", podUID, err)
+			_ = p.emitter.StoreInt64(util.MetricNamePatchPlacementFailed, 1, metrics.MetricTypeNameRaw)
+			continue
+		}
+		p.patchedPlacements[podUID] = annotations
+	}
+}
+
+// patchPlacementAnnotations patches the given annotations onto the pod if they differ from the current ones
+func (p *DynamicPolicy) patchPlacementAnnotations(podUID string, annotations map[string]string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), patchPlacementAnnotationsTimeout)
+	defer cancel()
+
+	pod, err := p.metaServer.GetPod(ctx, podUID)
+	if err != nil {
+		return err
+	} else if pod == nil {
+		return fmt.Errorf("got nil pod")
+	}
+
+	changed := false
+	for key, value := range annotations {
+		if pod.Annotations[key] != value {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	newPod := pod.DeepCopy()
+	newPod.Annotations = general.MergeMap(newPod.Annotations, annotations)
+	if err := p.podUpdater.PatchPod(ctx, pod, newPod); err != nil {
+		return err
+	}
+
+	general.Infof("pod: %s/%s placement annotations are patched: %v", pod.Namespace, pod.Name, annotations)
+	return nil
+}
@@ -0,0 +1,1610 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/ // Code generated by protoc-gen-gogo. DO NOT EDIT.
+// source: placement_advisor.proto
+
+package placementadvisor
+
+import (
+	context "context"
+	fmt "fmt"
+	_ "github.com/gogo/protobuf/gogoproto"
+	proto "github.com/gogo/protobuf/proto"
+	github_com_gogo_protobuf_sortkeys "github.com/gogo/protobuf/sortkeys"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	io "io"
+	math "math"
+	math_bits "math/bits"
+	reflect "reflect"
+	strings "strings"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.GoGoProtoPackageIsVersion3 // please upgrade the proto package
+
+type NUMAState struct {
+	NumaId               uint64   `protobuf:"varint,1,opt,name=numa_id,json=numaId,proto3" json:"numa_id,omitempty"`
+	AllocatableQuantity  uint64   `protobuf:"varint,2,opt,name=allocatable_quantity,json=allocatableQuantity,proto3" json:"allocatable_quantity,omitempty"`
+	AvailableQuantity    uint64   `protobuf:"varint,3,opt,name=available_quantity,json=availableQuantity,proto3" json:"available_quantity,omitempty"`
+	NumaBinding          bool     `protobuf:"varint,4,opt,name=numa_binding,json=numaBinding,proto3" json:"numa_binding,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NUMAState) Reset()      { *m = NUMAState{} }
+func (*NUMAState) ProtoMessage() {}
+func (*NUMAState) Descriptor() ([]byte, []int) {
+	return fileDescriptor_4eb87c24c7b2f83d, []int{0}
+}
+func (m *NUMAState) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *NUMAState) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_NUMAState.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *NUMAState) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NUMAState.Merge(m, src)
+}
+func (m *NUMAState) XXX_Size() int {
+	return m.Size()
+}
+func (m *NUMAState) XXX_DiscardUnknown() {
+	xxx_messageInfo_NUMAState.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NUMAState proto.InternalMessageInfo
+
+func (m *NUMAState) GetNumaId() uint64 {
+	if m != nil {
+		return m.NumaId
+	}
+	return 0
+}
+
+func (m *NUMAState) GetAllocatableQuantity() uint64 {
+	if m != nil {
+		return m.AllocatableQuantity
+	}
+	return 0
+}
+
+func (m *NUMAState) GetAvailableQuantity() uint64 {
+	if m != nil {
+		return m.AvailableQuantity
+	}
+	return 0
+}
+
+func (m *NUMAState) GetNumaBinding() bool {
+	if m != nil {
+		return m.NumaBinding
+	}
+	return false
+}
+
+type GetPreferredNUMARequest struct {
+	PodUid               string            `protobuf:"bytes,1,opt,name=pod_uid,json=podUid,proto3" json:"pod_uid,omitempty"`
+	PodNamespace         string            `protobuf:"bytes,2,opt,name=pod_namespace,json=podNamespace,proto3" json:"pod_namespace,omitempty"`
+	PodName              string            `protobuf:"bytes,3,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`
+	ContainerName        string            `protobuf:"bytes,4,opt,name=container_name,json=containerName,proto3" json:"container_name,omitempty"`
+	QosLevel             string            `protobuf:"bytes,5,opt,name=qos_level,json=qosLevel,proto3" json:"qos_level,omitempty"`
+	Labels               map[string]string `protobuf:"bytes,6,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Annotations          map[string]string `protobuf:"bytes,7,rep,name=annotations,proto3" json:"annotations,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	RequestQuantity      uint64            `protobuf:"varint,8,opt,name=request_quantity,json=requestQuantity,proto3" json:"request_quantity,omitempty"`
+	NumaStates           []*NUMAState      `protobuf:"bytes,9,rep,name=numa_states,json=numaStates,proto3" json:"numa_states,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *GetPreferredNUMARequest) Reset()      { *m = GetPreferredNUMARequest{} }
+func (*GetPreferredNUMARequest) ProtoMessage() {}
+func (*GetPreferredNUMARequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_4eb87c24c7b2f83d, []int{1}
+}
+func (m *GetPreferredNUMARequest) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *GetPreferredNUMARequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_GetPreferredNUMARequest.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *GetPreferredNUMARequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetPreferredNUMARequest.Merge(m, src)
+}
+func (m *GetPreferredNUMARequest) XXX_Size() int {
+	return m.Size()
+}
+func (m *GetPreferredNUMARequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetPreferredNUMARequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetPreferredNUMARequest proto.InternalMessageInfo
+
+func (m *GetPreferredNUMARequest) GetPodUid() string {
+	if m != nil {
+		return m.PodUid
+	}
+	return ""
+}
+
+func (m *GetPreferredNUMARequest) GetPodNamespace() string {
+	if m != nil {
+		return m.PodNamespace
+	}
+	return ""
+}
+
+func (m *GetPreferredNUMARequest) GetPodName() string {
+	if m != nil {
+		return m.PodName
+	}
+	return ""
+}
+
+func (m *GetPreferredNUMARequest) GetContainerName() string {
+	if m != nil {
+		return m.ContainerName
+	}
+	return ""
+}
+
+func (m *GetPreferredNUMARequest) GetQosLevel() string {
+	if m != nil {
+		return m.QosLevel
+	}
+	return ""
+}
+
+func (m *GetPreferredNUMARequest) GetLabels() map[string]string {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
+func (m *GetPreferredNUMARequest) GetAnnotations() map[string]string {
+	if m != nil {
+		return m.Annotations
+	}
+	return nil
+}
+
+func (m *GetPreferredNUMARequest) GetRequestQuantity() uint64 {
+	if m != nil {
+		return m.RequestQuantity
+	}
+	return 0
+}
+
+func (m *GetPreferredNUMARequest) GetNumaStates() []*NUMAState {
+	if m != nil {
+		return m.NumaStates
+	}
+	return nil
+}
+
+type GetPreferredNUMAResponse struct {
+	HasPreference        bool     `protobuf:"varint,1,opt,name=has_preference,json=hasPreference,proto3" json:"has_preference,omitempty"`
+	PreferredNuma        uint64   `protobuf:"varint,2,opt,name=preferred_numa,json=preferredNuma,proto3" json:"preferred_numa,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetPreferredNUMAResponse) Reset()      { *m = GetPreferredNUMAResponse{} }
+func (*GetPreferredNUMAResponse) ProtoMessage() {}
+func (*GetPreferredNUMAResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_4eb87c24c7b2f83d, []int{2}
+}
+func (m *GetPreferredNUMAResponse) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *GetPreferredNUMAResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_GetPreferredNUMAResponse.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *GetPreferredNUMAResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetPreferredNUMAResponse.Merge(m, src)
+}
+func (m *GetPreferredNUMAResponse) XXX_Size() int {
+	return m.Size()
+}
+func (m *GetPreferredNUMAResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetPreferredNUMAResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetPreferredNUMAResponse proto.InternalMessageInfo
+
+func (m *GetPreferredNUMAResponse) GetHasPreference() bool {
+	if m != nil {
+		return m.HasPreference
+	}
+	return false
+}
+
+func (m *GetPreferredNUMAResponse) GetPreferredNuma() uint64 {
+	if m != nil {
+		return m.PreferredNuma
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterType((*NUMAState)(nil), "placementadvisor.NUMAState")
+	proto.RegisterType((*GetPreferredNUMARequest)(nil), "placementadvisor.GetPreferredNUMARequest")
+	proto.RegisterMapType((map[string]string)(nil), "placementadvisor.GetPreferredNUMARequest.AnnotationsEntry")
+	proto.RegisterMapType((map[string]string)(nil), "placementadvisor.GetPreferredNUMARequest.LabelsEntry")
+	proto.RegisterType((*GetPreferredNUMAResponse)(nil), "placementadvisor.GetPreferredNUMAResponse")
+}
+
+func init() { proto.RegisterFile("placement_advisor.proto", fileDescriptor_4eb87c24c7b2f83d) }
+
+var fileDescriptor_4eb87c24c7b2f83d = []byte{
+	// 619 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x54, 0x61, 0x6b, 0xd3, 0x40,
+	0x18, 0x5e, 0xb6, 0xae, 0x6b, 0xaf, 0xab, 0xd6, 0x73, 0xb0, 0xd8, 0x41, 0x98, 0x15, 0x61, 0x13,
+	0xda, 0xe0, 0x44, 0xd0, 0x21, 0xc2, 0x06, 0x22, 0xc2, 0x36, 0x66, 0x64, 0x5f, 0x54, 0x08, 0x6f,
+	0x92, 0x5b, 0x1a, 0x7a, 0xb9, 0xbb, 0xe6, 0x2e, 0x95, 0x7c, 0xd2, 0x9f, 0xe0, 0xdf, 0xf0, 0x97,
+	0xb8, 0x8f, 0x7e, 0xf4, 0xa3, 0xab, 0x7f, 0x44, 0x72, 0x49, 0xb3, 0xda, 0x21, 0xcc, 0x6f, 0xf7,
+	0x3e, 0xef, 0xf3, 0x3e, 0x79, 0xde, 0x7b, 0xda, 0x43, 0x9b, 0x82, 0x82, 0x4f, 0x62, 0xc2, 0x94,
+	0x0b, 0xc1, 0x24, 0x92, 0x3c, 0x19, 0x88, 0x84, 0x2b, 0x8e, 0x3b, 0x55, 0xa3, 0xc4, 0xbb, 0xfd,
+	0x30, 0x52, 0xc3, 0xd4, 0x1b, 0xf8, 0x3c, 0xb6, 0x43, 0x1e, 0x72, 0x5b, 0x13, 0xbd, 0xf4, 0x5c,
+	0x57, 0xba, 0xd0, 0xa7, 0x42, 0xa0, 0xf7, 0xcd, 0x40, 0xcd, 0x93, 0xb3, 0xe3, 0x83, 0x77, 0x0a,
+	0x14, 0xc1, 0x9b, 0x68, 0x8d, 0xa5, 0x31, 0xb8, 0x51, 0x60, 0x1a, 0xdb, 0xc6, 0x4e, 0xcd, 0xa9,
+	0xe7, 0xe5, 0x9b, 0x00, 0x3f, 0x46, 0x1b, 0x40, 0x29, 0xf7, 0x41, 0x81, 0x47, 0x89, 0x3b, 0x4e,
+	0x81, 0xa9, 0x48, 0x65, 0xe6, 0xb2, 0x66, 0xdd, 0x9d, 0xeb, 0xbd, 0x2d, 0x5b, 0xb8, 0x8f, 0x30,
+	0x4c, 0x20, 0xa2, 0x7f, 0x0f, 0xac, 0xe8, 0x81, 0x3b, 0x55, 0xa7, 0xa2, 0xdf, 0x47, 0xeb, 0xfa,
+	0xd3, 0x5e, 0xc4, 0x82, 0x88, 0x85, 0x66, 0x6d, 0xdb, 0xd8, 0x69, 0x38, 0xad, 0x1c, 0x3b, 0x2c,
+	0xa0, 0xde, 0xf7, 0x1a, 0xda, 0x7c, 0x4d, 0xd4, 0x69, 0x42, 0xce, 0x49, 0x92, 0x90, 0x20, 0xf7,
+	0xed, 0x90, 0x71, 0x4a, 0xa4, 0xca, 0x9d, 0x0b, 0x1e, 0xb8, 0x69, 0xe9, 0xbc, 0xe9, 0xd4, 0x05,
+	0x0f, 0xce, 0xa2, 0x00, 0x3f, 0x40, 0xed, 0xbc, 0xc1, 0x20, 0x26, 0x52, 0x80, 0x4f, 0xb4, 0xe5,
+	0xa6, 0xb3, 0x2e, 0x78, 0x70, 0x32, 0xc3, 0xf0, 0x3d, 0xd4, 0x98, 0x91, 0xb4, 0xc3, 0xa6, 0xb3,
+	0x56, 0xf6, 0xf1, 0x43, 0x74, 0xcb, 0xe7, 0x4c, 0x41, 0xc4, 0x48, 0x52, 0x10, 0x6a, 0x9a, 0xd0,
+	0xae, 0x50, 0x4d, 0xdb, 0x42, 0xcd, 0x31, 0x97, 0x2e, 0x25, 0x13, 0x42, 0xcd, 0x55, 0xcd, 0x68,
+	0x8c, 0xb9, 0x3c, 0xca, 0x6b, 0x7c, 0x8c, 0xea, 0x14, 0x3c, 0x42, 0xa5, 0x59, 0xdf, 0x5e, 0xd9,
+	0x69, 0xed, 0x3d, 0x1d, 0x2c, 0xc6, 0x36, 0xf8, 0xc7, 0x5e, 0x83, 0x23, 0x3d, 0xf7, 0x8a, 0xa9,
+	0x24, 0x73, 0x4a, 0x11, 0xfc, 0x11, 0xb5, 0x80, 0x31, 0xae, 0x40, 0x45, 0x9c, 0x49, 0x73, 0x4d,
+	0x6b, 0xee, 0xdf, 0x5c, 0xf3, 0xe0, 0x6a, 0xb8, 0x10, 0x9e, 0x97, 0xc3, 0xbb, 0xa8, 0x93, 0x14,
+	0xc4, 0xab, 0xd4, 0x1a, 0x3a, 0xb5, 0xdb, 0x25, 0x5e, 0x65, 0xf6, 0x02, 0xe9, 0x7c, 0x5c, 0xa9,
+	0x40, 0x11, 0x69, 0x36, 0xb5, 0x91, 0xad, 0xeb, 0x46, 0xaa, 0x1f, 0x98, 0x83, 0x72, 0xbe, 0x3e,
+	0xca, 0xee, 0x73, 0xd4, 0x9a, 0xdb, 0x0e, 0x77, 0xd0, 0xca, 0x88, 0x64, 0x65, 0x7a, 0xf9, 0x11,
+	0x6f, 0xa0, 0xd5, 0x09, 0xd0, 0x74, 0x16, 0x59, 0x51, 0xec, 0x2f, 0x3f, 0x33, 0xba, 0x2f, 0x51,
+	0x67, 0x71, 0x89, 0xff, 0x99, 0xef, 0x0d, 0x91, 0x79, 0xfd, 0x72, 0xa4, 0xe0, 0x4c, 0xea, 0xc0,
+	0x87, 0x20, 0x5d, 0xa1, 0x9b, 0x84, 0xf9, 0x44, 0x4b, 0x36, 0x9c, 0xf6, 0x10, 0xe4, 0x69, 0x05,
+	0xe6, 0x34, 0x31, 0x9b, 0x77, 0xf3, 0xad, 0xca, 0xff, 0x42, 0xbb, 0x42, 0x4f, 0xd2, 0x18, 0xf6,
+	0x3e, 0xa3, 0xce, 0xe9, 0xec, 0x3a, 0x0e, 0x8a, 0xeb, 0xc0, 0x23, 0xd4, 0x59, 0xfc, 0x3a, 0xde,
+	0xbd, 0x71, 0x7c, 0xdd, 0x47, 0x37, 0xa1, 0x16, 0xcb, 0xf4, 0x96, 0x0e, 0xb3, 0x8b, 0x4b, 0xcb,
+	0xf8, 0x79, 0x69, 0x2d, 0x7d, 0x99, 0x5a, 0xc6, 0xc5, 0xd4, 0x32, 0x7e, 0x4c, 0x2d, 0xe3, 0xd7,
+	0xd4, 0x32, 0xbe, 0xfe, 0xb6, 0x96, 0xde, 0x7f, 0x98, 0x7b, 0x2d, 0x46, 0xa9, 0x47, 0x3e, 0x0d,
+	0x21, 0x39, 0xb7, 0x47, 0xa0, 0x80, 0x66, 0x52, 0xf5, 0x7d, 0x9e, 0x10, 0x5b, 0x8c, 0x42, 0x1b,
+	0x42, 0xc2, 0x94, 0x3d, 0x4e, 0xe2, 0xbe, 0xa0, 0x69, 0x18, 0x31, 0x69, 0xfb, 0x22, 0xb5, 0x83,
+	0x8c, 0x41, 0x1c, 0xf9, 0x82, 0xd3, 0xc8, 0xcf, 0xec, 0x45, 0x57, 0x5e, 0x5d, 0x3f, 0x31, 0x4f,
+	0xfe, 0x0c, 0x00, 0x41, 0x74, 0x09, 0xe2, 0xbe, 0x04, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// PlacementAdvisorClient is the client API for PlacementAdvisor service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type PlacementAdvisorClient interface {
+	GetPreferredNUMA(ctx context.Context, in *GetPreferredNUMARequest, opts ...grpc.CallOption) (*GetPreferredNUMAResponse, error)
+}
+
+type placementAdvisorClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewPlacementAdvisorClient(cc *grpc.ClientConn) PlacementAdvisorClient {
+	return &placementAdvisorClient{cc}
+}
+
+func (c *placementAdvisorClient) GetPreferredNUMA(ctx context.Context, in *GetPreferredNUMARequest, opts ...grpc.CallOption) (*GetPreferredNUMAResponse, error) {
+	out := new(GetPreferredNUMAResponse)
+	err := c.cc.Invoke(ctx, "/placementadvisor.PlacementAdvisor/GetPreferredNUMA", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PlacementAdvisorServer is the server API for PlacementAdvisor service.
+type PlacementAdvisorServer interface {
+	GetPreferredNUMA(context.Context, *GetPreferredNUMARequest) (*GetPreferredNUMAResponse, error)
+}
+
+// UnimplementedPlacementAdvisorServer can be embedded to have forward compatible implementations.
+type UnimplementedPlacementAdvisorServer struct {
+}
+
+func (*UnimplementedPlacementAdvisorServer) GetPreferredNUMA(ctx context.Context, req *GetPreferredNUMARequest) (*GetPreferredNUMAResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPreferredNUMA not implemented")
+}
+
+func RegisterPlacementAdvisorServer(s *grpc.Server, srv PlacementAdvisorServer) {
+	s.RegisterService(&_PlacementAdvisor_serviceDesc, srv)
+}
+
+func _PlacementAdvisor_GetPreferredNUMA_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPreferredNUMARequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PlacementAdvisorServer).GetPreferredNUMA(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/placementadvisor.PlacementAdvisor/GetPreferredNUMA",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PlacementAdvisorServer).GetPreferredNUMA(ctx, req.(*GetPreferredNUMARequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _PlacementAdvisor_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "placementadvisor.PlacementAdvisor",
+	HandlerType: (*PlacementAdvisorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetPreferredNUMA",
+			Handler:    _PlacementAdvisor_GetPreferredNUMA_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "placement_advisor.proto",
+}
+
+func (m *NUMAState) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *NUMAState) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *NUMAState) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.NumaBinding {
+		i--
+		if m.NumaBinding {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x20
+	}
+	if m.AvailableQuantity != 0 {
+		i = encodeVarintPlacementAdvisor(dAtA, i, uint64(m.AvailableQuantity))
+		i--
+		dAtA[i] = 0x18
+	}
+	if m.AllocatableQuantity != 0 {
+		i = encodeVarintPlacementAdvisor(dAtA, i, uint64(m.AllocatableQuantity))
+		i--
+		dAtA[i] = 0x10
+	}
+	if m.NumaId != 0 {
+		i = encodeVarintPlacementAdvisor(dAtA, i, uint64(m.NumaId))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *GetPreferredNUMARequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *GetPreferredNUMARequest) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *GetPreferredNUMARequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if len(m.NumaStates) > 0 {
+		for iNdEx := len(m.NumaStates) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.NumaStates[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintPlacementAdvisor(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x4a
+		}
+	}
+	if m.RequestQuantity != 0 {
+		i = encodeVarintPlacementAdvisor(dAtA, i, uint64(m.RequestQuantity))
+		i--
+		dAtA[i] = 0x40
+	}
+	if len(m.Annotations) > 0 {
+		for k := range m.Annotations {
+			v := m.Annotations[k]
+			baseI := i
+			i -= len(v)
+			copy(dAtA[i:], v)
+			i = encodeVarintPlacementAdvisor(dAtA, i, uint64(len(v)))
+			i--
+			dAtA[i] = 0x12
+			i -= len(k)
+			copy(dAtA[i:], k)
+			i = encodeVarintPlacementAdvisor(dAtA, i, uint64(len(k)))
+			i--
+			dAtA[i] = 0xa
+			i = encodeVarintPlacementAdvisor(dAtA, i, uint64(baseI-i))
+			i--
+			dAtA[i] = 0x3a
+		}
+	}
+	if len(m.Labels) > 0 {
+		for k := range m.Labels {
+			v := m.Labels[k]
+			baseI := i
+			i -= len(v)
+			copy(dAtA[i:], v)
+			i = encodeVarintPlacementAdvisor(dAtA, i, uint64(len(v)))
+			i--
+			dAtA[i] = 0x12
+			i -= len(k)
+			copy(dAtA[i:], k)
+			i = encodeVarintPlacementAdvisor(dAtA, i, uint64(len(k)))
+			i--
+			dAtA[i] = 0xa
+			i = encodeVarintPlacementAdvisor(dAtA, i, uint64(baseI-i))
+			i--
+			dAtA[i] = 0x32
+		}
+	}
+	if len(m.QosLevel) > 0 {
+		i -= len(m.QosLevel)
+		copy(dAtA[i:], m.QosLevel)
+		i = encodeVarintPlacementAdvisor(dAtA, i, uint64(len(m.QosLevel)))
+		i--
+		dAtA[i] = 0x2a
+	}
+	if len(m.ContainerName) > 0 {
+		i -= len(m.ContainerName)
+		copy(dAtA[i:], m.ContainerName)
+		i = encodeVarintPlacementAdvisor(dAtA, i, uint64(len(m.ContainerName)))
+		i--
+		dAtA[i] = 0x22
+	}
+	if len(m.PodName) > 0 {
+		i -= len(m.PodName)
+		copy(dAtA[i:], m.PodName)
+		i = encodeVarintPlacementAdvisor(dAtA, i, uint64(len(m.PodName)))
+		i--
+		dAtA[i] = 0x1a
+	}
+	if len(m.PodNamespace) > 0 {
+		i -= len(m.PodNamespace)
+		copy(dAtA[i:], m.PodNamespace)
+		i = encodeVarintPlacementAdvisor(dAtA, i, uint64(len(m.PodNamespace)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if len(m.PodUid) > 0 {
+		i -= len(m.PodUid)
+		copy(dAtA[i:], m.PodUid)
+		i = encodeVarintPlacementAdvisor(dAtA, i, uint64(len(m.PodUid)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *GetPreferredNUMAResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *GetPreferredNUMAResponse) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *GetPreferredNUMAResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.PreferredNuma != 0 {
+		i = encodeVarintPlacementAdvisor(dAtA, i, uint64(m.PreferredNuma))
+		i--
+		dAtA[i] = 0x10
+	}
+	if m.HasPreference {
+		i--
+		if m.HasPreference {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func encodeVarintPlacementAdvisor(dAtA []byte, offset int, v uint64) int {
+	offset -= sovPlacementAdvisor(v)
+	base := offset
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return base
+}
+func (m *NUMAState) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.NumaId != 0 {
+		n += 1 + sovPlacementAdvisor(uint64(m.NumaId))
+	}
+	if m.AllocatableQuantity != 0 {
+		n += 1 + sovPlacementAdvisor(uint64(m.AllocatableQuantity))
+	}
+	if m.AvailableQuantity != 0 {
+		n += 1 + sovPlacementAdvisor(uint64(m.AvailableQuantity))
+	}
+	if m.NumaBinding {
+		n += 2
+	}
+	return n
+}
+
+func (m *GetPreferredNUMARequest) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.PodUid)
+	if l > 0 {
+		n += 1 + l + sovPlacementAdvisor(uint64(l))
+	}
+	l = len(m.PodNamespace)
+	if l > 0 {
+		n += 1 + l + sovPlacementAdvisor(uint64(l))
+	}
+	l = len(m.PodName)
+	if l > 0 {
+		n += 1 + l + sovPlacementAdvisor(uint64(l))
+	}
+	l = len(m.ContainerName)
+	if l > 0 {
+		n += 1 + l + sovPlacementAdvisor(uint64(l))
+	}
+	l = len(m.QosLevel)
+	if l > 0 {
+		n += 1 + l + sovPlacementAdvisor(uint64(l))
+	}
+	if len(m.Labels) > 0 {
+		for k, v := range m.Labels {
+			_ = k
+			_ = v
+			mapEntrySize := 1 + len(k) + sovPlacementAdvisor(uint64(len(k))) + 1 + len(v) + sovPlacementAdvisor(uint64(len(v)))
+			n += mapEntrySize + 1 + sovPlacementAdvisor(uint64(mapEntrySize))
+		}
+	}
+	if len(m.Annotations) > 0 {
+		for k, v := range m.Annotations {
+			_ = k
+			_ = v
+			mapEntrySize := 1 + len(k) + sovPlacementAdvisor(uint64(len(k))) + 1 + len(v) + sovPlacementAdvisor(uint64(len(v)))
+			n += mapEntrySize + 1 + sovPlacementAdvisor(uint64(mapEntrySize))
+		}
+	}
+	if m.RequestQuantity != 0 {
+		n += 1 + sovPlacementAdvisor(uint64(m.RequestQuantity))
+	}
+	if len(m.NumaStates) > 0 {
+		for _, e := range m.NumaStates {
+			l = e.Size()
+			n += 1 + l + sovPlacementAdvisor(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *GetPreferredNUMAResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.HasPreference {
+		n += 2
+	}
+	if m.PreferredNuma != 0 {
+		n += 1 + sovPlacementAdvisor(uint64(m.PreferredNuma))
+	}
+	return n
+}
+
+func sovPlacementAdvisor(x uint64) (n int) {
+	return (math_bits.Len64(x|1) + 6) / 7
+}
+func sozPlacementAdvisor(x uint64) (n int) {
+	return sovPlacementAdvisor(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (this *NUMAState) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&NUMAState{`,
+		`NumaId:` + fmt.Sprintf("%v", this.NumaId) + `,`,
+		`AllocatableQuantity:` + fmt.Sprintf("%v", this.AllocatableQuantity) + `,`,
+		`AvailableQuantity:` + fmt.Sprintf("%v", this.AvailableQuantity) + `,`,
+		`NumaBinding:` + fmt.Sprintf("%v", this.NumaBinding) + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func (this *GetPreferredNUMARequest) String() string {
+	if this == nil {
+		return "nil"
+	}
+	repeatedStringForNumaStates := "[]*NUMAState{"
+	for _, f := range this.NumaStates {
+		repeatedStringForNumaStates += strings.Replace(f.String(), "NUMAState", "NUMAState", 1) + ","
+	}
+	repeatedStringForNumaStates += "}"
+	keysForLabels := make([]string, 0, len(this.Labels))
+	for k, _ := range this.Labels {
+		keysForLabels = append(keysForLabels, k)
+	}
+	github_com_gogo_protobuf_sortkeys.Strings(keysForLabels)
+	mapStringForLabels := "map[string]string{"
+	for _, k := range keysForLabels {
+		mapStringForLabels += fmt.Sprintf("%v: %v,", k, this.Labels[k])
+	}
+	mapStringForLabels += "}"
+	keysForAnnotations := make([]string, 0, len(this.Annotations))
+	for k, _ := range this.Annotations {
+		keysForAnnotations = append(keysForAnnotations, k)
+	}
+	github_com_gogo_protobuf_sortkeys.Strings(keysForAnnotations)
+	mapStringForAnnotations := "map[string]string{"
+	for _, k := range keysForAnnotations {
+		mapStringForAnnotations += fmt.Sprintf("%v: %v,", k, this.Annotations[k])
+	}
+	mapStringForAnnotations += "}"
+	s := strings.Join([]string{`&GetPreferredNUMARequest{`,
+		`PodUid:` + fmt.Sprintf("%v", this.PodUid) + `,`,
+		`PodNamespace:` + fmt.Sprintf("%v", this.PodNamespace) + `,`,
+		`PodName:` + fmt.Sprintf("%v", this.PodName) + `,`,
+		`ContainerName:` + fmt.Sprintf("%v", this.ContainerName) + `,`,
+		`QosLevel:` + fmt.Sprintf("%v", this.QosLevel) + `,`,
+		`Labels:` + mapStringForLabels + `,`,
+		`Annotations:` + mapStringForAnnotations + `,`,
+		`RequestQuantity:` + fmt.Sprintf("%v", this.RequestQuantity) + `,`,
+		`NumaStates:` + repeatedStringForNumaStates + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func (this *GetPreferredNUMAResponse) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&GetPreferredNUMAResponse{`,
+		`HasPreference:` + fmt.Sprintf("%v", this.HasPreference) + `,`,
+		`PreferredNuma:` + fmt.Sprintf("%v", this.PreferredNuma) + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func valueToStringPlacementAdvisor(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	if rv.IsNil() {
+		return "nil"
+	}
+	pv := reflect.Indirect(rv).Interface()
+	return fmt.Sprintf("*%v", pv)
+}
+func (m *NUMAState) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowPlacementAdvisor
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: NUMAState: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: NUMAState: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NumaId", wireType)
+			}
+			m.NumaId = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPlacementAdvisor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.NumaId |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field AllocatableQuantity", wireType)
+			}
+			m.AllocatableQuantity = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPlacementAdvisor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.AllocatableQuantity |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field AvailableQuantity", wireType)
+			}
+			m.AvailableQuantity = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPlacementAdvisor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.AvailableQuantity |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NumaBinding", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPlacementAdvisor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.NumaBinding = bool(v != 0)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipPlacementAdvisor(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *GetPreferredNUMARequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowPlacementAdvisor
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: GetPreferredNUMARequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: GetPreferredNUMARequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PodUid", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPlacementAdvisor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.PodUid = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PodNamespace", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPlacementAdvisor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.PodNamespace = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PodName", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPlacementAdvisor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.PodName = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ContainerName", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPlacementAdvisor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ContainerName = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field QosLevel", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPlacementAdvisor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.QosLevel = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Labels", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPlacementAdvisor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Labels == nil {
+				m.Labels = make(map[string]string)
+			}
+			var mapkey string
+			var mapvalue string
+			for iNdEx < postIndex {
+				entryPreIndex := iNdEx
+				var wire uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowPlacementAdvisor
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					wire |= uint64(b&0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				fieldNum := int32(wire >> 3)
+				if fieldNum == 1 {
+					var stringLenmapkey uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowPlacementAdvisor
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapkey |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapkey := int(stringLenmapkey)
+					if intStringLenmapkey < 0 {
+						return ErrInvalidLengthPlacementAdvisor
+					}
+					postStringIndexmapkey := iNdEx + intStringLenmapkey
+					if postStringIndexmapkey < 0 {
+						return ErrInvalidLengthPlacementAdvisor
+					}
+					if postStringIndexmapkey > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapkey = string(dAtA[iNdEx:postStringIndexmapkey])
+					iNdEx = postStringIndexmapkey
+				} else if fieldNum == 2 {
+					var stringLenmapvalue uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowPlacementAdvisor
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapvalue |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapvalue := int(stringLenmapvalue)
+					if intStringLenmapvalue < 0 {
+						return ErrInvalidLengthPlacementAdvisor
+					}
+					postStringIndexmapvalue := iNdEx + intStringLenmapvalue
+					if postStringIndexmapvalue < 0 {
+						return ErrInvalidLengthPlacementAdvisor
+					}
+					if postStringIndexmapvalue > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapvalue = string(dAtA[iNdEx:postStringIndexmapvalue])
+					iNdEx = postStringIndexmapvalue
+				} else {
+					iNdEx = entryPreIndex
+					skippy, err := skipPlacementAdvisor(dAtA[iNdEx:])
+					if err != nil {
+						return err
+					}
+					if (skippy < 0) || (iNdEx+skippy) < 0 {
+						return ErrInvalidLengthPlacementAdvisor
+					}
+					if (iNdEx + skippy) > postIndex {
+						return io.ErrUnexpectedEOF
+					}
+					iNdEx += skippy
+				}
+			}
+			m.Labels[mapkey] = mapvalue
+			iNdEx = postIndex
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Annotations", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPlacementAdvisor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Annotations == nil {
+				m.Annotations = make(map[string]string)
+			}
+			var mapkey string
+			var mapvalue string
+			for iNdEx < postIndex {
+				entryPreIndex := iNdEx
+				var wire uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowPlacementAdvisor
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					wire |= uint64(b&0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				fieldNum := int32(wire >> 3)
+				if fieldNum == 1 {
+					var stringLenmapkey uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowPlacementAdvisor
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapkey |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapkey := int(stringLenmapkey)
+					if intStringLenmapkey < 0 {
+						return ErrInvalidLengthPlacementAdvisor
+					}
+					postStringIndexmapkey := iNdEx + intStringLenmapkey
+					if postStringIndexmapkey < 0 {
+						return ErrInvalidLengthPlacementAdvisor
+					}
+					if postStringIndexmapkey > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapkey = string(dAtA[iNdEx:postStringIndexmapkey])
+					iNdEx = postStringIndexmapkey
+				} else if fieldNum == 2 {
+					var stringLenmapvalue uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowPlacementAdvisor
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapvalue |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapvalue := int(stringLenmapvalue)
+					if intStringLenmapvalue < 0 {
+						return ErrInvalidLengthPlacementAdvisor
+					}
+					postStringIndexmapvalue := iNdEx + intStringLenmapvalue
+					if postStringIndexmapvalue < 0 {
+						return ErrInvalidLengthPlacementAdvisor
+					}
+					if postStringIndexmapvalue > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapvalue = string(dAtA[iNdEx:postStringIndexmapvalue])
+					iNdEx = postStringIndexmapvalue
+				} else {
+					iNdEx = entryPreIndex
+					skippy, err := skipPlacementAdvisor(dAtA[iNdEx:])
+					if err != nil {
+						return err
+					}
+					if (skippy < 0) || (iNdEx+skippy) < 0 {
+						return ErrInvalidLengthPlacementAdvisor
+					}
+					if (iNdEx + skippy) > postIndex {
+						return io.ErrUnexpectedEOF
+					}
+					iNdEx += skippy
+				}
+			}
+			m.Annotations[mapkey] = mapvalue
+			iNdEx = postIndex
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RequestQuantity", wireType)
+			}
+			m.RequestQuantity = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPlacementAdvisor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.RequestQuantity |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 9:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NumaStates", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPlacementAdvisor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.NumaStates = append(m.NumaStates, &NUMAState{})
+			if err := m.NumaStates[len(m.NumaStates)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipPlacementAdvisor(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *GetPreferredNUMAResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowPlacementAdvisor
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: GetPreferredNUMAResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: GetPreferredNUMAResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field HasPreference", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPlacementAdvisor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.HasPreference = bool(v != 0)
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PreferredNuma", wireType)
+			}
+			m.PreferredNuma = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPlacementAdvisor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.PreferredNuma |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipPlacementAdvisor(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthPlacementAdvisor
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func skipPlacementAdvisor(dAtA []byte) (n int, err error) {
+	l := len(dAtA)
+	iNdEx := 0
+	depth := 0
+	for iNdEx < l {
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return 0, ErrIntOverflowPlacementAdvisor
+			}
+			if iNdEx >= l {
+				return 0, io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		wireType := int(wire & 0x7)
+		switch wireType {
+		case 0:
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowPlacementAdvisor
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				iNdEx++
+				if dAtA[iNdEx-1] < 0x80 {
+					break
+				}
+			}
+		case 1:
+			iNdEx += 8
+		case 2:
+			var length int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowPlacementAdvisor
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				length |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if length < 0 {
+				return 0, ErrInvalidLengthPlacementAdvisor
+			}
+			iNdEx += length
+		case 3:
+			depth++
+		case 4:
+			if depth == 0 {
+				return 0, ErrUnexpectedEndOfGroupPlacementAdvisor
+			}
+			depth--
+		case 5:
+			iNdEx += 4
+		default:
+			return 0, fmt.Errorf("proto: illegal wireType %d", wireType)
+		}
+		if iNdEx < 0 {
+			return 0, ErrInvalidLengthPlacementAdvisor
+		}
+		if depth == 0 {
+			return iNdEx, nil
+		}
+	}
+	return 0, io.ErrUnexpectedEOF
+}
+
+var (
+	ErrInvalidLengthPlacementAdvisor        = fmt.Errorf("proto: negative length found during unmarshaling")
+	ErrIntOverflowPlacementAdvisor          = fmt.Errorf("proto: integer overflow")
+	ErrUnexpectedEndOfGroupPlacementAdvisor = fmt.Errorf("proto: unexpected end of group")
+)
@@ -19,15 +19,17 @@ package dynamicpolicy
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"sort"
 	"sync"
 	"time"
 
+	"go.uber.org/atomic"
 	"google.golang.org/grpc"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
-	maputil "k8s.io/kubernetes/pkg/util/maps"
 	"k8s.io/utils/clock"
 
 	"github.com/kubewharf/katalyst-api/pkg/consts"
@@ -39,11 +41,13 @@ import (
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/calculator"
 	advisorapi "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpuadvisor"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpueviction"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/placementadvisor"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/validator"
 	cpuutil "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/util"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
 	"github.com/kubewharf/katalyst-core/pkg/agent/utilcomponent/periodicalhandler"
+	"github.com/kubewharf/katalyst-core/pkg/client/control"
 	"github.com/kubewharf/katalyst-core/pkg/config"
 	dynamicconfig "github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic"
 	"github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic/crd"
@@ -67,6 +71,14 @@ const (
 	maxResidualTime   = 5 * time.Minute
 	syncCPUIdlePeriod = 30 * time.Second
 
+	syncOfflineCPUsPeriod = 10 * time.Second
+
+	reconcileCPUSetPeriod = 30 * time.Second
+
+	syncWeightedSharesPeriod = 30 * time.Second
+
+	syncNUMADistancePenaltyPeriod = 30 * time.Second
+
 	healthCheckTolerationTimes = 3
 )
 
@@ -106,6 +118,9 @@ type DynamicPolicy struct {
 	advisorapi.UnimplementedCPUPluginServer
 	advisorMonitor *timemonitor.TimeMonitor
 
+	placementAdvisorClient placementadvisor.PlacementAdvisorClient
+	placementAdvisorConn   *grpc.ClientConn
+
 	state              state.State
 	residualHitMap     map[string]int64
 	allocationHandlers map[string]util.AllocationHandler
@@ -118,9 +133,51 @@ type DynamicPolicy struct {
 	// todo if we want to use dynamic configuration, we'd better not use self-defined conf
 	enableCPUAdvisor              bool
 	reservedCPUs                  machine.CPUSet
+	offlineCPUsFileAbsPath        string
+	maxNUMAsPerAllocation         int
+	enableCPUSetDriftRepair       bool
+	cpusetManager                 containerCPUSetManager
+	reclaimedNUMAOvercommitRatio  float64
+	cpuNUMAHintPreferTieBreak     string
+	cpuNUMAHintFallbackOrder      string
+	numaDistancePenaltyCurve      map[int]float64
+	cpuQuotaApplier               containerCPUQuotaApplier
+	cpuSharesApplier              containerCPUSharesApplier
+	machineStateGenerator         machineStateGenerator
+	placementAdvisorSocketAbsPath string
+	placementAdvisorTimeout       time.Duration
+	numaAffinityResourceName      string
+	missingCPUsAction             string
+	enableWeightedShares          bool
+	cpuReuseCoolDown              time.Duration
+	enableHintScores              bool
+	maxReclaimedEvictionsFreeNUMA int
+	dedicatedFullPhysicalCPUsOnly bool
+	enablePlacementAnnotations    bool
+	numaMinFreeCPUs               int
+	hintsAuditSink                hintsAuditSink
+	reclaimedNUMACPUQuota         map[int]float64
+	numaPinRulesFileAbsPath       string
+	maxSharedDisplacements        int
+	trimInvalidReservedCPUs       bool
+	numaThrottleRatioThreshold    float64
+	numaTemperatureThreshold      float64
+	previousNUMAStickyTTL         time.Duration
+	unclassifiedPodPolicy         string
+	sidecarBindingMismatchPolicy  string
+	unknownContainerTypePolicy    string
+	numaNamespaceReservations     map[int]string
+	memoryNUMAsGetter             memoryNUMAsGetter
+	preferMemoryNUMAs             bool
+	maxNUMABindingSharedPods      int
+	enableMachineStateDiffLog     bool
+	socketAffinityFallback        bool
+	podUpdater                    control.PodUpdater
 	cpuAdvisorSocketAbsPath       string
 	cpuPluginSocketAbsPath        string
-	extraStateFileAbsPath         string
+	extraStateFileAbsPaths        []string
+	extraStateFileReader          util.ExtraStateFileReader
+	extraStateFileReadTimeout     time.Duration
 	enableCPUIdle                 bool
 	enableSyncingCPUIdle          bool
 	reclaimRelativeRootCgroupPath string
@@ -130,19 +187,96 @@ type DynamicPolicy struct {
 	transitionPeriod              time.Duration
 	cpuNUMAHintPreferPolicy       string
 	cpuNUMAHintPreferLowThreshold float64
+	enableCPUMemoryCoAllocation   bool
+
+	// singleNUMAFastPath is enabled for machines with only one NUMA node, hints are calculated
+	// on them without iterating NUMA combinations since there is nothing to choose among
+	singleNUMAFastPath bool
+	// offlineCPUs are excluded from allocation as reservedCPUs,
+	// but they can be changed at runtime
+	offlineCPUs machine.CPUSet
+	// cordonedNUMAs are excluded from candidates of new allocations (e.g. for maintenance),
+	// while existing allocations on them remain until the pods are evicted
+	cordonedNUMAs machine.CPUSet
+	// qosReservedPools are cpus reserved for QoS levels (e.g. system_cores) separately from reservedCPUs,
+	// they are unavailable for all QoS levels admitted by dynamic policy
+	qosReservedPools map[string]machine.CPUSet
+	// lastSuccessfulAdmissionTimeNano is the time (in unix nanoseconds) when a container was admitted
+	// successfully at last, it's updated atomically since containers already allocated are admitted
+	// under the read-lock
+	lastSuccessfulAdmissionTimeNano atomic.Int64
+	// cpuReleaseTimestamps records when cpus were freed from numa_binding allocations, they are kept
+	// in memory only, so cpus freed before restart are available immediately after it
+	cpuReleaseTimestamps map[int]time.Time
+	// patchedPlacements records placement annotations patched onto each pod, it's only
+	// accessed by syncPlacementAnnotations to avoid patching pods repeatedly
+	patchedPlacements map[string]map[string]string
+	// numaPinRules are reloaded from numaPinRulesFileAbsPath at runtime, they're matched before
+	// the policy lock is held, so they're guarded by a separate lock
+	numaPinRulesMutex sync.RWMutex
+	numaPinRules      []*numaPinRule
+	// previousNUMAs records NUMAs of containers whose allocations are cleared while their pods remain,
+	// they're recorded during hints calculation under the read lock, so they're guarded by a separate lock
+	previousNUMAsMutex sync.Mutex
+	previousNUMAs      map[string]map[string]*previousNUMARecord
+	// allocationWatchers subscribe to allocation change events, they're added and removed
+	// without the policy lock, so they're guarded by a separate lock
+	allocationWatchersMutex sync.RWMutex
+	allocationWatchers      map[*allocationWatcher]struct{}
+	// numaAdmissionMutexes serialize planning cpus of new numa_binding dedicated_cores containers per NUMA,
+	// they're taken before the policy lock (see allocateWithNUMALocks)
+	numaAdmissionMutexes map[int]*sync.Mutex
+	// numaMigrations counts how many times each numa_binding pod is moved to different NUMAs, they're
+	// updated under the policy lock and kept in memory only, so counts restart from zero after restart
+	numaMigrations map[string]*numaMigrationRecord
+
+	// shuttingDown is set by Shutdown to refuse new admissions, it's accessed without the policy lock
+	// since Shutdown may wait for the lock held by in-flight admissions
+	shuttingDown atomic.Bool
+	shutdownOnce sync.Once
+	shutdownErr  error
+
+	// metricStoreUnavailable records whether the metric store was found unavailable last time,
+	// so that the transition is logged only once
+	metricStoreUnavailable atomic.Bool
 }
 
 func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration,
 	_ interface{}, agentName string,
 ) (bool, agent.Component, error) {
-	reservedCPUs, reserveErr := cpuutil.GetCoresReservedForSystem(conf, agentCtx.MetaServer, agentCtx.KatalystMachineInfo, agentCtx.CPUDetails.CPUs().Clone())
+	if err := conf.CPUQRMPluginConfig.Validate(); err != nil {
+		return false, agent.ComponentStub{}, fmt.Errorf("invalid cpu plugin config: %v", err)
+	}
+
+	qosReservedPools, err := parseQoSReservedPools(conf.CPUQoSReservedPools, agentCtx.CPUDetails.CPUs())
+	if err != nil {
+		return false, agent.ComponentStub{}, fmt.Errorf("parseQoSReservedPools failed with error: %v", err)
+	}
+
+	// the global reserved cpus are picked out of cpus not in reserved pools of QoS levels
+	reservedCPUs, reserveErr := cpuutil.GetCoresReservedForSystem(conf, agentCtx.MetaServer, agentCtx.KatalystMachineInfo,
+		agentCtx.CPUDetails.CPUs().Difference(unionQoSReservedPools(qosReservedPools)))
 	if reserveErr != nil {
 		return false, agent.ComponentStub{}, fmt.Errorf("GetCoresReservedForSystem for reservedCPUsNum: %d failed with error: %v",
 			conf.ReservedCPUCores, reserveErr)
 	}
 
-	stateImpl, stateErr := state.NewCheckpointState(conf.GenericQRMPluginConfiguration.StateFileDirectory, cpuPluginStateFileName,
-		cpuconsts.CPUResourcePluginPolicyNameDynamic, agentCtx.CPUTopology, conf.SkipCPUStateCorruption)
+	reservedCPUs, err = validateReservedCPUs(reservedCPUs, agentCtx.CPUDetails.CPUs(), conf.TrimInvalidReservedCPUs)
+	if err != nil {
+		return false, agent.ComponentStub{}, fmt.Errorf("validateReservedCPUs failed with error: %v", err)
+	}
+
+	var podEntriesRecoverer state.PodEntriesRecoverer
+	if conf.EnableCPUStateRecovery && agentCtx.MetaServer != nil {
+		general.Warningf("cpu state recovery is enabled, state will be reconstructed by running pods and " +
+			"their cgroup cpusets if checkpoint fails to be parsed")
+		podEntriesRecoverer = newPodEntriesRecoverer(agentCtx.MetaServer.PodFetcher, cgroupCPUSetManager{},
+			conf.QoSConfiguration, agentCtx.CPUTopology)
+	}
+
+	stateImpl, stateErr := state.NewCheckpointStateWithRecoverer(conf.GenericQRMPluginConfiguration.StateFileDirectory,
+		cpuPluginStateFileName, cpuconsts.CPUResourcePluginPolicyNameDynamic, agentCtx.CPUTopology,
+		conf.SkipCPUStateCorruption, podEntriesRecoverer)
 	if stateErr != nil {
 		return false, agent.ComponentStub{}, fmt.Errorf("NewCheckpointState failed with error: %v", stateErr)
 	}
@@ -151,15 +285,20 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 	readonlyState = stateImpl
 	readonlyStateLock.Unlock()
 
-	wrappedEmitter := agentCtx.EmitterPool.GetDefaultMetricsEmitter().WithTags(agentName, metrics.MetricTag{
+	defaultEmitter := agentCtx.EmitterPool.GetDefaultMetricsEmitter()
+	// metrics are kept in a prometheus registry as well as emitted, common tags are added before both
+	var prometheusEmitter *prometheusMetricsEmitter
+	if conf.EnableCPUPrometheusMetrics {
+		prometheusEmitter = newPrometheusMetricsEmitter(defaultEmitter)
+		defaultEmitter = prometheusEmitter
+	}
+
+	wrappedEmitter := defaultEmitter.WithTags(agentName, metrics.MetricTag{
 		Key: util.QRMPluginPolicyTagName,
 		Val: cpuconsts.CPUResourcePluginPolicyNameDynamic,
 	})
 
-	var (
-		cpuPressureEviction agent.Component
-		err                 error
-	)
+	var cpuPressureEviction agent.Component
 	if conf.EnableCPUPressureEviction {
 		cpuPressureEviction, err = cpueviction.NewCPUPressureEviction(
 			agentCtx.EmitterPool.GetDefaultMetricsEmitter(), agentCtx.MetaServer, conf, stateImpl)
@@ -176,9 +315,10 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 		name:   fmt.Sprintf("%s_%s", agentName, cpuconsts.CPUResourcePluginPolicyNameDynamic),
 		stopCh: make(chan struct{}),
 
-		machineInfo: agentCtx.KatalystMachineInfo,
-		emitter:     wrappedEmitter,
-		metaServer:  agentCtx.MetaServer,
+		machineInfo:        agentCtx.KatalystMachineInfo,
+		singleNUMAFastPath: agentCtx.KatalystMachineInfo.NumNUMANodes == 1,
+		emitter:            wrappedEmitter,
+		metaServer:         agentCtx.MetaServer,
 
 		state:          stateImpl,
 		residualHitMap: make(map[string]int64),
@@ -189,13 +329,63 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 
 		qosConfig:                     conf.QoSConfiguration,
 		dynamicConfig:                 conf.DynamicAgentConfiguration,
+		maxNUMAsPerAllocation:         conf.MaxNUMAsPerAllocation,
+		enableCPUSetDriftRepair:       conf.EnableCPUSetDriftRepair,
+		cpusetManager:                 cgroupCPUSetManager{},
+		reclaimedNUMAOvercommitRatio:  conf.ReclaimedNUMAOvercommitRatio,
+		cpuNUMAHintPreferTieBreak:     conf.CPUNUMAHintPreferTieBreak,
+		cpuNUMAHintFallbackOrder:      conf.CPUNUMAHintFallbackOrder,
+		numaDistancePenaltyCurve:      conf.CPUNUMADistancePenaltyCurve,
+		cpuQuotaApplier:               cgroupCPUQuotaApplier{},
+		cpuSharesApplier:              cgroupCPUSharesApplier{},
+		machineStateGenerator:         generateMachineStateFromPodEntries,
+		placementAdvisorSocketAbsPath: conf.CPUPlacementAdvisorSocketAbsPath,
+		placementAdvisorTimeout:       conf.CPUPlacementAdvisorTimeout,
+		numaAffinityResourceName:      conf.CPUNUMAAffinityResourceName,
+		missingCPUsAction:             conf.CPUMissingCPUsAction,
+		enableWeightedShares:          conf.EnableCPUWeightedShares,
+		cpuReuseCoolDown:              conf.CPUReuseCoolDown,
+		enableHintScores:              conf.EnableCPUHintScores,
+		maxReclaimedEvictionsFreeNUMA: conf.MaxReclaimedEvictionsToFreeNUMA,
+		dedicatedFullPhysicalCPUsOnly: conf.EnableDedicatedFullPhysicalCPUsOnly,
+		enablePlacementAnnotations:    conf.EnableCPUPlacementAnnotations,
+		numaMinFreeCPUs:               general.Max(conf.CPUNUMAMinFreeCPUs, 0),
+		reclaimedNUMACPUQuota:         conf.ReclaimedNUMACPUQuota,
+		numaPinRulesFileAbsPath:       conf.CPUNUMAPinRulesFileAbsPath,
+		maxSharedDisplacements:        conf.MaxSharedDisplacementsToFreeNUMA,
+		trimInvalidReservedCPUs:       conf.TrimInvalidReservedCPUs,
+		numaThrottleRatioThreshold:    conf.CPUNUMAThrottleRatioThreshold,
+		numaTemperatureThreshold:      conf.CPUNUMATemperatureThreshold,
+		previousNUMAStickyTTL:         conf.CPUPreviousNUMAStickyTTL,
+		unclassifiedPodPolicy:         conf.CPUUnclassifiedPodPolicy,
+		sidecarBindingMismatchPolicy:  conf.CPUSidecarNUMABindingMismatchPolicy,
+		unknownContainerTypePolicy:    conf.CPUUnknownContainerTypePolicy,
+		numaNamespaceReservations:     conf.CPUNUMANamespaceReservations,
+		memoryNUMAsGetter:             newMemoryNUMAsGetter(conf),
+		preferMemoryNUMAs:             conf.CPUPreferMemoryAllocatedNUMAs,
+		maxNUMABindingSharedPods:      conf.MaxNUMABindingSharedPodsPerNUMA,
+		enableMachineStateDiffLog:     conf.EnableCPUMachineStateDiffLog,
+		socketAffinityFallback:        conf.CPUSocketAffinityFallback,
+		previousNUMAs:                 make(map[string]map[string]*previousNUMARecord),
+		allocationWatchers:            make(map[*allocationWatcher]struct{}),
+		numaMigrations:                make(map[string]*numaMigrationRecord),
+		numaAdmissionMutexes:          newNUMAAdmissionMutexes(agentCtx.CPUDetails.NUMANodes()),
+		podUpdater:                    &control.DummyPodUpdater{},
+		cpuReleaseTimestamps:          make(map[int]time.Time),
 		cpuAdvisorSocketAbsPath:       conf.CPUAdvisorSocketAbsPath,
 		cpuPluginSocketAbsPath:        conf.CPUPluginSocketAbsPath,
 		enableCPUAdvisor:              conf.CPUQRMPluginConfig.EnableCPUAdvisor,
 		cpuNUMAHintPreferPolicy:       conf.CPUQRMPluginConfig.CPUNUMAHintPreferPolicy,
 		cpuNUMAHintPreferLowThreshold: conf.CPUQRMPluginConfig.CPUNUMAHintPreferLowThreshold,
+		enableCPUMemoryCoAllocation:   conf.CPUQRMPluginConfig.EnableCPUMemoryCoAllocation,
 		reservedCPUs:                  reservedCPUs,
-		extraStateFileAbsPath:         conf.ExtraStateFileAbsPath,
+		qosReservedPools:              qosReservedPools,
+		offlineCPUsFileAbsPath:        conf.OfflineCPUsFileAbsPath,
+		offlineCPUs:                   machine.NewCPUSet(),
+		cordonedNUMAs:                 machine.NewCPUSet(),
+		extraStateFileAbsPaths:        conf.ExtraStateFileAbsPaths,
+		extraStateFileReader:          util.DefaultExtraStateFileReader,
+		extraStateFileReadTimeout:     conf.ExtraStateFileReadTimeout,
 		enableSyncingCPUIdle:          conf.CPUQRMPluginConfig.EnableSyncingCPUIdle,
 		enableCPUIdle:                 conf.CPUQRMPluginConfig.EnableCPUIdle,
 		reclaimRelativeRootCgroupPath: conf.ReclaimRelativeRootCgroupPath,
@@ -203,6 +393,15 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 		transitionPeriod:              30 * time.Second,
 	}
 
+	if conf.EnableCPUPlacementAnnotations && agentCtx.GenericContext != nil &&
+		agentCtx.Client != nil && agentCtx.Client.KubeClient != nil {
+		policyImplement.podUpdater = control.NewRealPodUpdater(agentCtx.Client.KubeClient)
+	}
+
+	if conf.CPUHintsAuditFileAbsPath != "" {
+		policyImplement.hintsAuditSink = newFileHintsAuditSink(conf.CPUHintsAuditFileAbsPath)
+	}
+
 	// register allocation behaviors for pods with different QoS level
 	policyImplement.allocationHandlers = map[string]util.AllocationHandler{
 		consts.PodAnnotationQoSLevelSharedCores:    policyImplement.sharedCoresAllocationHandler,
@@ -218,6 +417,11 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 	}
 
 	state.SetContainerRequestedCores(policyImplement.getContainerRequestedCores)
+	util.SetCPUResourceNameAliases(conf.CPUResourceNameAliases)
+
+	if err := policyImplement.handleAllocationsWithMissingCPUs(); err != nil {
+		return false, agent.ComponentStub{}, fmt.Errorf("handleAllocationsWithMissingCPUs failed with error: %v", err)
+	}
 
 	if err := policyImplement.cleanPools(); err != nil {
 		return false, agent.ComponentStub{}, fmt.Errorf("cleanPools failed with error: %v", err)
@@ -236,6 +440,19 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 		return false, nil, err
 	}
 
+	if agentCtx.GenericContext != nil {
+		agentCtx.RegisterDebugHandler(allocationStateDebugPath, http.HandlerFunc(policyImplement.serveAllocationState))
+		agentCtx.RegisterDebugHandler(hintsTraceDebugPath, http.HandlerFunc(policyImplement.serveHintsTrace))
+		agentCtx.RegisterDebugHandler(projectedHintsDebugPath, http.HandlerFunc(policyImplement.serveProjectedHints))
+		agentCtx.RegisterDebugHandler(numaExclusionDebugPath, http.HandlerFunc(policyImplement.serveNUMAExclusion))
+		agentCtx.RegisterDebugHandler(healthDebugPath, http.HandlerFunc(policyImplement.serveHealth))
+		agentCtx.RegisterDebugHandler(numaMigrationsDebugPath, http.HandlerFunc(policyImplement.serveNUMAMigrations))
+		agentCtx.RegisterDebugHandler(topologyDebugPath, http.HandlerFunc(policyImplement.serveTopology))
+		if prometheusEmitter != nil {
+			agentCtx.RegisterDebugHandler(prometheusMetricsDebugPath, prometheusEmitter.Handler())
+		}
+	}
+
 	pluginWrapper, err := skeleton.NewRegistrationPluginWrapper(policyImplement, conf.QRMPluginSocketDirs, func(key string, value int64) {
 		_ = wrappedEmitter.StoreInt64(key, value, metrics.MetricTypeNameRaw)
 	})
@@ -243,7 +460,13 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 		return false, agent.ComponentStub{}, fmt.Errorf("dynamic policy new plugin wrapper failed with error: %v", err)
 	}
 
-	return true, &agent.PluginWrapper{GenericPlugin: pluginWrapper}, nil
+	return true, &agent.PluginWrapper{
+		GenericPlugin: pluginWrapper,
+		// checkpoint final state before the agent exits
+		ShutdownFunc: func() error {
+			return policyImplement.Shutdown(shutdownTimeout)
+		},
+	}, nil
 }
 
 func (p *DynamicPolicy) Name() string {
@@ -306,6 +529,64 @@ func (p *DynamicPolicy) Start() (err error) {
 		}
 	}
 
+	// start offline cpus syncing if needed
+	if p.offlineCPUsFileAbsPath != "" {
+		general.Infof("syncOfflineCPUs enabled")
+
+		err = periodicalhandler.RegisterPeriodicalHandlerWithHealthz(cpuconsts.SyncOfflineCPUs, general.HealthzCheckStateNotReady,
+			qrm.QRMCPUPluginPeriodicalHandlerGroupName, p.syncOfflineCPUs, syncOfflineCPUsPeriod, healthCheckTolerationTimes)
+		if err != nil {
+			general.Errorf("start %v failed,err:%v", cpuconsts.SyncOfflineCPUs, err)
+		}
+	}
+
+	// start numa pin rules syncing if needed
+	if p.numaPinRulesFileAbsPath != "" {
+		general.Infof("syncNUMAPinRules enabled")
+
+		err = periodicalhandler.RegisterPeriodicalHandlerWithHealthz(cpuconsts.SyncNUMAPinRules, general.HealthzCheckStateNotReady,
+			qrm.QRMCPUPluginPeriodicalHandlerGroupName, p.syncNUMAPinRules, syncNUMAPinRulesPeriod, healthCheckTolerationTimes)
+		if err != nil {
+			general.Errorf("start %v failed,err:%v", cpuconsts.SyncNUMAPinRules, err)
+		}
+	}
+
+	// start weighted cpu shares syncing if needed
+	if p.enableWeightedShares {
+		general.Infof("syncWeightedShares enabled")
+
+		err = periodicalhandler.RegisterPeriodicalHandlerWithHealthz(cpuconsts.SyncWeightedShares, general.HealthzCheckStateNotReady,
+			qrm.QRMCPUPluginPeriodicalHandlerGroupName, p.syncWeightedShares, syncWeightedSharesPeriod, healthCheckTolerationTimes)
+		if err != nil {
+			general.Errorf("start %v failed,err:%v", cpuconsts.SyncWeightedShares, err)
+		}
+	}
+
+	// start numa distance penalty syncing if needed
+	if len(p.numaDistancePenaltyCurve) > 0 {
+		general.Infof("syncNUMADistancePenalty enabled")
+
+		err = periodicalhandler.RegisterPeriodicalHandlerWithHealthz(cpuconsts.SyncNUMADistancePenalty, general.HealthzCheckStateNotReady,
+			qrm.QRMCPUPluginPeriodicalHandlerGroupName, p.syncNUMADistancePenalty, syncNUMADistancePenaltyPeriod, healthCheckTolerationTimes)
+		if err != nil {
+			general.Errorf("start %v failed,err:%v", cpuconsts.SyncNUMADistancePenalty, err)
+		}
+	}
+
+	if p.enablePlacementAnnotations {
+		err = periodicalhandler.RegisterPeriodicalHandler(qrm.QRMCPUPluginPeriodicalHandlerGroupName,
+			cpuconsts.SyncPlacementAnnotations, p.syncPlacementAnnotations, syncPlacementAnnotationsPeriod)
+		if err != nil {
+			general.Errorf("start %v failed,err:%v", cpuconsts.SyncPlacementAnnotations, err)
+		}
+	}
+
+	err = periodicalhandler.RegisterPeriodicalHandlerWithHealthz(cpuconsts.ReconcileCPUSet, general.HealthzCheckStateNotReady,
+		qrm.QRMCPUPluginPeriodicalHandlerGroupName, p.reconcileCPUSet, reconcileCPUSetPeriod, healthCheckTolerationTimes)
+	if err != nil {
+		general.Errorf("start %v failed,err:%v", cpuconsts.ReconcileCPUSet, err)
+	}
+
 	// start cpu-pressure eviction plugin if needed
 	if p.cpuPressureEviction != nil {
 		var ctx context.Context
@@ -317,6 +598,20 @@ func (p *DynamicPolicy) Start() (err error) {
 		periodicalhandler.ReadyToStartHandlersByGroup(qrm.QRMCPUPluginPeriodicalHandlerGroupName)
 	}, 5*time.Second, p.stopCh)
 
+	// placement advisor is optional, so keep retrying in background without blocking start
+	if p.placementAdvisorSocketAbsPath != "" {
+		go func() {
+			_ = wait.PollImmediateUntil(placementAdvisorRetryInterval, func() (bool, error) {
+				if err := p.initPlacementAdvisorClientConn(); err != nil {
+					general.Errorf("initPlacementAdvisorClientConn failed with error: %v", err)
+					return false, nil
+				}
+				general.Infof("placement advisor connected at socket: %s", p.placementAdvisorSocketAbsPath)
+				return true, nil
+			}, p.stopCh)
+		}()
+	}
+
 	// pre-check necessary dirs if sys-advisor is enabled
 	if !p.enableCPUAdvisor {
 		general.Infof("start dynamic policy cpu plugin without sys-advisor")
@@ -398,6 +693,12 @@ func (p *DynamicPolicy) Stop() error {
 
 	periodicalhandler.StopHandlersByGroup(qrm.QRMCPUPluginPeriodicalHandlerGroupName)
 
+	if p.placementAdvisorConn != nil {
+		if err := p.placementAdvisorConn.Close(); err != nil {
+			general.Errorf("close placement advisor connection failed with error: %v", err)
+		}
+	}
+
 	if p.advisorConn != nil {
 		return p.advisorConn.Close()
 	}
@@ -421,7 +722,7 @@ func (p *DynamicPolicy) GetResourcesAllocation(_ context.Context,
 	machineState := p.state.GetMachineState()
 
 	// pooledCPUs is the total available cpu cores minus those that are reserved
-	pooledCPUs := machineState.GetFilteredAvailableCPUSet(p.reservedCPUs,
+	pooledCPUs := machineState.GetFilteredAvailableCPUSet(p.getAllReservedCPUs(),
 		func(ai *state.AllocationInfo) bool {
 			return state.CheckDedicated(ai) || state.CheckNUMABinding(ai)
 		},
@@ -455,13 +756,11 @@ func (p *DynamicPolicy) GetResourcesAllocation(_ context.Context,
 					general.Errorf("pod: %s/%s, container: %s init timestamp parsed failed with error: %v, re-ramp-up it",
 						allocationInfo.PodNamespace, allocationInfo.PodName, allocationInfo.ContainerName, tsErr)
 
-					clonedPooledCPUs := pooledCPUs.Clone()
-					clonedPooledCPUsTopologyAwareAssignments := machine.DeepcopyCPUAssignment(pooledCPUsTopologyAwareAssignments)
-
-					allocationInfo.AllocationResult = clonedPooledCPUs
-					allocationInfo.OriginalAllocationResult = clonedPooledCPUs
-					allocationInfo.TopologyAwareAssignments = clonedPooledCPUsTopologyAwareAssignments
-					allocationInfo.OriginalTopologyAwareAssignments = clonedPooledCPUsTopologyAwareAssignments
+					// current and original results are cloned separately, since they mustn't alias each other
+					allocationInfo.AllocationResult = pooledCPUs.Clone()
+					allocationInfo.OriginalAllocationResult = pooledCPUs.Clone()
+					allocationInfo.TopologyAwareAssignments = machine.DeepcopyCPUAssignment(pooledCPUsTopologyAwareAssignments)
+					allocationInfo.OriginalTopologyAwareAssignments = machine.DeepcopyCPUAssignment(pooledCPUsTopologyAwareAssignments)
 					// fill OwnerPoolName with empty string when ramping up
 					allocationInfo.OwnerPoolName = state.EmptyOwnerPoolName
 					allocationInfo.RampUp = true
@@ -567,18 +866,29 @@ func (p *DynamicPolicy) GetTopologyAwareAllocatableResources(_ context.Context,
 ) (*pluginapi.GetTopologyAwareAllocatableResourcesResponse, error) {
 	general.Infof("is called")
 
+	// allocatable is calculated by current reserved and offline cpus,
+	// so that changes of them at runtime are reported as well;
+	// NUMA occupancy is derived from the latest machine state in the same way
+	p.RLock()
+	unavailableCPUs := p.getUnavailableCPUs()
+	numaAllocatable := p.getNUMAAllocatable()
+	numaOccupancy := getNUMAOccupancy(p.state.GetMachineState())
+	p.RUnlock()
+
 	numaNodes := p.machineInfo.CPUDetails.NUMANodes().ToSliceInt()
 	topologyAwareAllocatableQuantityList := make([]*pluginapi.TopologyAwareQuantity, 0, len(numaNodes))
 	topologyAwareCapacityQuantityList := make([]*pluginapi.TopologyAwareQuantity, 0, len(numaNodes))
 
 	for _, numaNode := range numaNodes {
-		numaNodeCPUs := p.machineInfo.CPUDetails.CPUsInNUMANodes(numaNode).Clone()
 		topologyAwareAllocatableQuantityList = append(topologyAwareAllocatableQuantityList, &pluginapi.TopologyAwareQuantity{
-			ResourceValue: float64(numaNodeCPUs.Difference(p.reservedCPUs).Size()),
+			ResourceValue: float64(numaAllocatable[numaNode]),
 			Node:          uint64(numaNode),
+			Annotations: map[string]string{
+				cpuconsts.TopologyAwareQuantityAnnotationKeyNUMAOccupancy: numaOccupancy[numaNode],
+			},
 		})
 		topologyAwareCapacityQuantityList = append(topologyAwareCapacityQuantityList, &pluginapi.TopologyAwareQuantity{
-			ResourceValue: float64(numaNodeCPUs.Size()),
+			ResourceValue: float64(p.machineInfo.CPUDetails.CPUsInNUMANodes(numaNode).Size()),
 			Node:          uint64(numaNode),
 		})
 	}
@@ -588,7 +898,7 @@ func (p *DynamicPolicy) GetTopologyAwareAllocatableResources(_ context.Context,
 			string(v1.ResourceCPU): {
 				IsNodeResource:                       false,
 				IsScalarResource:                     true,
-				AggregatedAllocatableQuantity:        float64(p.machineInfo.NumCPUs - p.reservedCPUs.Size()),
+				AggregatedAllocatableQuantity:        float64(p.machineInfo.NumCPUs - unavailableCPUs.Size()),
 				TopologyAwareAllocatableQuantityList: topologyAwareAllocatableQuantityList,
 				AggregatedCapacityQuantity:           float64(p.machineInfo.NumCPUs),
 				TopologyAwareCapacityQuantityList:    topologyAwareCapacityQuantityList,
@@ -603,28 +913,47 @@ func (p *DynamicPolicy) GetTopologyHints(ctx context.Context,
 ) (resp *pluginapi.ResourceHintsResponse, err error) {
 	if req == nil {
 		return nil, fmt.Errorf("GetTopologyHints got nil req")
+	} else if p.isShuttingDown() {
+		return nil, errShuttingDown
 	}
 
+	ctx = withAdmissionLogger(ctx, req)
+	logger := general.LoggerFromContext(ctx)
+
 	// identify if the pod is a debug pod,
 	// if so, apply specific strategy to it.
 	// since GetKatalystQoSLevelFromResourceReq function will filter annotations,
 	// we should do it before GetKatalystQoSLevelFromResourceReq.
 	isDebugPod := util.IsDebugPod(req.Annotations, p.podDebugAnnoKeys)
+	// labels are filtered by GetKatalystQoSLevelFromResourceReq as well, so numa pin rules are matched
+	// and the label to spread replicas by is kept before it
+	ctx = p.withNUMAPinRule(ctx, req)
+	ctx = p.withNamespaceExcludedNUMAs(ctx, req)
+	numaSpreadLabel := p.getNUMASpreadLabel(req)
 
-	qosLevel, err := util.GetKatalystQoSLevelFromResourceReq(p.qosConfig, req)
+	qosLevel, err := p.getKatalystQoSLevelFromResourceReq(req)
 	if err != nil {
 		err = fmt.Errorf("GetKatalystQoSLevelFromResourceReq for pod: %s/%s, container: %s failed with error: %v",
 			req.PodNamespace, req.PodName, req.ContainerName, err)
-		general.Errorf("%s", err.Error())
+		logger.Errorf("%s", err.Error())
+		return nil, err
+	}
+
+	err = normalizeEnhancementAnnotations(req.Annotations)
+	if err != nil {
+		err = fmt.Errorf("normalizeEnhancementAnnotations for pod: %s/%s, container: %s failed with error: %v",
+			req.PodNamespace, req.PodName, req.ContainerName, err)
+		logger.Errorf("%s", err.Error())
 		return nil, err
 	}
+	keepNUMASpreadLabel(req, numaSpreadLabel)
 
 	reqInt, reqFloat64, err := util.GetQuantityFromResourceReq(req)
 	if err != nil {
 		return nil, fmt.Errorf("getReqQuantityFromResourceReq failed with error: %v", err)
 	}
 
-	general.InfoS("called",
+	logger.InfoS("called",
 		"podNamespace", req.PodNamespace,
 		"podName", req.PodName,
 		"containerName", req.ContainerName,
@@ -636,26 +965,66 @@ func (p *DynamicPolicy) GetTopologyHints(ctx context.Context,
 		"numCPUsFloat64", reqFloat64,
 		"isDebugPod", isDebugPod)
 
+	if err = p.normalizeContainerType(req); err != nil {
+		logger.Errorf("%s", err.Error())
+		return nil, err
+	}
+
 	if req.ContainerType == pluginapi.ContainerType_INIT || isDebugPod {
-		general.Infof("there is no NUMA preference, return nil hint")
-		return util.PackResourceHintsResponse(req, string(v1.ResourceCPU),
+		logger.Infof("there is no NUMA preference, return nil hint")
+		resp, err = util.PackResourceHintsResponse(req, string(v1.ResourceCPU),
 			map[string]*pluginapi.ListOfTopologyHints{
 				string(v1.ResourceCPU): nil, // indicates that there is no numa preference
 			})
+		if err != nil {
+			return nil, err
+		}
+
+		util.MirrorResourceHints(resp, string(v1.ResourceCPU), p.numaAffinityResourceName)
+		return resp, nil
 	}
 
+	// advice of the external placement advisor involves a remote call, so it's fetched before holding the lock
+	ctx = p.withPlacementAdvice(ctx, req, qosLevel, reqInt)
+
 	p.RLock()
 	defer func() {
 		p.RUnlock()
 		if err != nil {
 			_ = p.emitter.StoreInt64(util.MetricNameGetTopologyHintsFailed, 1, metrics.MetricTypeNameRaw)
 		}
+		p.auditHints(req, qosLevel, reqFloat64, resp, err)
 	}()
 
 	if p.hintHandlers[qosLevel] == nil {
 		return nil, fmt.Errorf("katalyst QoS level: %s is not supported yet", qosLevel)
 	}
-	return p.hintHandlers[qosLevel](ctx, req)
+
+	if err = p.alignSidecarNUMABinding(ctx, req); err != nil {
+		return nil, err
+	}
+
+	resp, err = p.hintHandlers[qosLevel](ctx, req)
+	if shouldFallbackFromNUMAPinRule(ctx, resp, err) {
+		logger.Infof("no hint within NUMAs pinned by rule, fall back to all NUMAs, hints error: %v", err)
+		resp, err = p.hintHandlers[qosLevel](withoutNUMAPinRule(ctx), req)
+	}
+	if p.shouldFallbackFromSocketAffinity(ctx, req, resp, err) {
+		logger.Infof("no hint within NUMAs of socket affinity, fall back to all sockets, hints error: %v", err)
+		resp, err = p.hintHandlers[qosLevel](withoutSocketAffinity(ctx), req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// hints from different paths (e.g. fallback hints or hints from extra state files) may overlap
+	util.DedupeResourceHints(resp, string(v1.ResourceCPU))
+	if p.enableHintScores {
+		p.attachHintScores(resp, reqInt)
+	}
+
+	util.MirrorResourceHints(resp, string(v1.ResourceCPU), p.numaAffinityResourceName)
+	return resp, nil
 }
 
 // GetResourcePluginOptions returns options to be communicated with Resource Manager
@@ -675,9 +1044,147 @@ func (p *DynamicPolicy) GetResourcePluginOptions(context.Context,
 // according to resource request
 func (p *DynamicPolicy) Allocate(ctx context.Context,
 	req *pluginapi.ResourceRequest,
-) (resp *pluginapi.ResourceAllocationResponse, respErr error) {
+) (*pluginapi.ResourceAllocationResponse, error) {
+	allocationReq, resp, err := p.prepareAllocation(req)
+	if err != nil || allocationReq == nil {
+		return resp, err
+	}
+
+	if resp, ok := p.allocateAllocatedWithRLock(ctx, allocationReq); ok {
+		return resp, nil
+	}
+
+	resp, err = p.allocateWithNUMALocks(ctx, allocationReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = p.addAllocatedContainerToAdvisor(withAdmissionLogger(ctx, req), allocationReq); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// AllocateBatch admits the given requests under a single write-lock, so that replaying
+// many admissions (e.g. during agent restart) doesn't pay for locking per request;
+// machine state is still updated incrementally by each allocation, and the failure of
+// one request doesn't affect others. requests are admitted in the order of QoS priority
+// (see sortAllocationRequests), while responses and errors are returned in the order of requests.
+func (p *DynamicPolicy) AllocateBatch(ctx context.Context,
+	reqs []*pluginapi.ResourceRequest,
+) ([]*pluginapi.ResourceAllocationResponse, []error) {
+	resps := make([]*pluginapi.ResourceAllocationResponse, len(reqs))
+	errs := make([]error, len(reqs))
+
+	allocationReqs := make([]*allocationRequest, len(reqs))
+	for i, req := range reqs {
+		allocationReqs[i], resps[i], errs[i] = p.prepareAllocation(req)
+	}
+	order := p.sortAllocationRequests(ctx, allocationReqs)
+
+	p.Lock()
+	for _, i := range order {
+		if allocationReqs[i] == nil {
+			continue
+		}
+		resps[i], errs[i] = p.allocateWithLock(ctx, allocationReqs[i])
+	}
+	p.Unlock()
+
+	for _, i := range order {
+		if allocationReqs[i] == nil || errs[i] != nil {
+			continue
+		}
+
+		if err := p.addAllocatedContainerToAdvisor(withAdmissionLogger(ctx, allocationReqs[i].req), allocationReqs[i]); err != nil {
+			resps[i], errs[i] = nil, err
+		}
+	}
+
+	general.Infof("batch allocated %d requests", len(reqs))
+	return resps, errs
+}
+
+// allocationQoSPriorities decides the order of requests admitted in a batch, requests with
+// smaller value are admitted earlier, so that containers with higher QoS level can get their
+// prior NUMAs back before being taken by others; unlisted QoS levels are admitted at last.
+var allocationQoSPriorities = map[string]int{
+	consts.PodAnnotationQoSLevelDedicatedCores: 0,
+	consts.PodAnnotationQoSLevelSharedCores:    1,
+	consts.PodAnnotationQoSLevelReclaimedCores: 2,
+}
+
+// sortAllocationRequests returns indexes of requests in the order to be admitted, i.e.
+// by QoS priority and then by creation timestamp of pods; pods whose creation timestamp
+// can't be got are placed after the others of the same QoS level, and the order is stable.
+func (p *DynamicPolicy) sortAllocationRequests(ctx context.Context, allocationReqs []*allocationRequest) []int {
+	getQoSPriority := func(allocationReq *allocationRequest) int {
+		if allocationReq == nil {
+			return len(allocationQoSPriorities)
+		} else if priority, ok := allocationQoSPriorities[allocationReq.qosLevel]; ok {
+			return priority
+		}
+		return len(allocationQoSPriorities)
+	}
+
+	creationTimestamps := make(map[string]time.Time)
+	if p.metaServer != nil {
+		for _, allocationReq := range allocationReqs {
+			if allocationReq == nil {
+				continue
+			} else if _, ok := creationTimestamps[allocationReq.req.PodUid]; ok {
+				continue
+			}
+
+			pod, err := p.metaServer.GetPod(ctx, allocationReq.req.PodUid)
+			if err != nil || pod == nil {
+				general.Warningf("get pod: %s/%s failed with error: %v", allocationReq.req.PodNamespace,
+					allocationReq.req.PodName, err)
+				continue
+			}
+			creationTimestamps[allocationReq.req.PodUid] = pod.CreationTimestamp.Time
+		}
+	}
+
+	order := make([]int, len(allocationReqs))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		reqI, reqJ := allocationReqs[order[i]], allocationReqs[order[j]]
+		if priorityI, priorityJ := getQoSPriority(reqI), getQoSPriority(reqJ); priorityI != priorityJ {
+			return priorityI < priorityJ
+		} else if reqI == nil || reqJ == nil {
+			return false
+		}
+
+		timestampI, okI := creationTimestamps[reqI.req.PodUid]
+		timestampJ, okJ := creationTimestamps[reqJ.req.PodUid]
+		if okI && okJ {
+			return timestampI.Before(timestampJ)
+		}
+		return okI && !okJ
+	})
+
+	return order
+}
+
+// allocationRequest is the request to be allocated with the state lock,
+// along with the attributes parsed from it beforehand
+type allocationRequest struct {
+	req      *pluginapi.ResourceRequest
+	qosLevel string
+	reqInt   int
+}
+
+// prepareAllocation parses the request without holding the state lock; the response is returned
+// directly for requests needing no allocation (e.g. init containers or containers in debug pods),
+// otherwise allocationRequest is returned for allocateWithLock
+func (p *DynamicPolicy) prepareAllocation(req *pluginapi.ResourceRequest,
+) (*allocationRequest, *pluginapi.ResourceAllocationResponse, error) {
 	if req == nil {
-		return nil, fmt.Errorf("allocate got nil req")
+		return nil, nil, fmt.Errorf("allocate got nil req")
 	}
 
 	// identify if the pod is a debug pod,
@@ -685,18 +1192,29 @@ func (p *DynamicPolicy) Allocate(ctx context.Context,
 	// since GetKatalystQoSLevelFromResourceReq function will filter annotations,
 	// we should do it before GetKatalystQoSLevelFromResourceReq.
 	isDebugPod := util.IsDebugPod(req.Annotations, p.podDebugAnnoKeys)
+	// labels are filtered by GetKatalystQoSLevelFromResourceReq as well, so the label to spread replicas by is kept before it
+	numaSpreadLabel := p.getNUMASpreadLabel(req)
 
-	qosLevel, err := util.GetKatalystQoSLevelFromResourceReq(p.qosConfig, req)
+	qosLevel, err := p.getKatalystQoSLevelFromResourceReq(req)
 	if err != nil {
 		err = fmt.Errorf("GetKatalystQoSLevelFromResourceReq for pod: %s/%s, container: %s failed with error: %v",
 			req.PodNamespace, req.PodName, req.ContainerName, err)
 		general.Errorf("%s", err.Error())
-		return nil, err
+		return nil, nil, err
 	}
 
+	err = normalizeEnhancementAnnotations(req.Annotations)
+	if err != nil {
+		err = fmt.Errorf("normalizeEnhancementAnnotations for pod: %s/%s, container: %s failed with error: %v",
+			req.PodNamespace, req.PodName, req.ContainerName, err)
+		general.Errorf("%s", err.Error())
+		return nil, nil, err
+	}
+	keepNUMASpreadLabel(req, numaSpreadLabel)
+
 	reqInt, reqFloat64, err := util.GetQuantityFromResourceReq(req)
 	if err != nil {
-		return nil, fmt.Errorf("getReqQuantityFromResourceReq failed with error: %v", err)
+		return nil, nil, fmt.Errorf("getReqQuantityFromResourceReq failed with error: %v", err)
 	}
 
 	general.InfoS("called",
@@ -711,8 +1229,13 @@ func (p *DynamicPolicy) Allocate(ctx context.Context,
 		"numCPUsFloat64", reqFloat64,
 		"isDebugPod", isDebugPod)
 
+	if err = p.normalizeContainerType(req); err != nil {
+		general.Errorf("%s", err.Error())
+		return nil, nil, err
+	}
+
 	if req.ContainerType == pluginapi.ContainerType_INIT {
-		return &pluginapi.ResourceAllocationResponse{
+		return nil, &pluginapi.ResourceAllocationResponse{
 			PodUid:         req.PodUid,
 			PodNamespace:   req.PodNamespace,
 			PodName:        req.PodName,
@@ -726,7 +1249,7 @@ func (p *DynamicPolicy) Allocate(ctx context.Context,
 			Annotations:    general.DeepCopyMap(req.Annotations),
 		}, nil
 	} else if isDebugPod {
-		return &pluginapi.ResourceAllocationResponse{
+		return nil, &pluginapi.ResourceAllocationResponse{
 			PodUid:         req.PodUid,
 			PodNamespace:   req.PodNamespace,
 			PodName:        req.PodName,
@@ -751,76 +1274,71 @@ func (p *DynamicPolicy) Allocate(ctx context.Context,
 		}, nil
 	}
 
-	p.Lock()
+	return &allocationRequest{
+		req:      req,
+		qosLevel: qosLevel,
+		reqInt:   reqInt,
+	}, nil, nil
+}
+
+// allocateWithLock allocates for the prepared request, it must be called with the state write-lock
+func (p *DynamicPolicy) allocateWithLock(ctx context.Context,
+	allocationReq *allocationRequest,
+) (resp *pluginapi.ResourceAllocationResponse, respErr error) {
+	req, qosLevel, reqInt := allocationReq.req, allocationReq.qosLevel, allocationReq.reqInt
+	if p.isShuttingDown() {
+		return nil, errShuttingDown
+	}
+	ctx = withAdmissionLogger(ctx, req)
+	allocationInfo := p.state.GetAllocationInfo(req.PodUid, req.ContainerName)
+
+	// sys-advisor is informed of the container after the write-lock is released (see addAllocatedContainerToAdvisor)
 	defer func() {
-		// calls sys-advisor to inform the latest container
-		if p.enableCPUAdvisor && respErr == nil && req.ContainerType != pluginapi.ContainerType_INIT {
-			_, err := p.advisorClient.AddContainer(ctx, &advisorsvc.ContainerMetadata{
-				PodUid:          req.PodUid,
-				PodNamespace:    req.PodNamespace,
-				PodName:         req.PodName,
-				ContainerName:   req.ContainerName,
-				ContainerType:   req.ContainerType,
-				ContainerIndex:  req.ContainerIndex,
-				Labels:          maputil.CopySS(req.Labels),
-				Annotations:     maputil.CopySS(req.Annotations),
-				QosLevel:        qosLevel,
-				RequestQuantity: uint64(reqInt),
-			})
-			if err != nil {
-				resp = nil
-				respErr = fmt.Errorf("add container to qos aware server failed with error: %v", err)
-				_ = p.removeContainer(req.PodUid, req.ContainerName)
-			}
-		} else if respErr != nil {
+		if respErr != nil {
 			_ = p.removeContainer(req.PodUid, req.ContainerName)
 			_ = p.emitter.StoreInt64(util.MetricNameAllocateFailed, 1, metrics.MetricTypeNameRaw)
+		} else {
+			p.lastSuccessfulAdmissionTimeNano.Store(time.Now().UnixNano())
+			p.clearPreviousNUMAs(req.PodUid, req.ContainerName)
+			p.updateNUMAMigration(p.state.GetAllocationInfo(req.PodUid, req.ContainerName))
+			p.notifyAllocationChange(allocationInfo, p.state.GetAllocationInfo(req.PodUid, req.ContainerName))
+			if p.isReclaimedNUMAEvictionEnabled() {
+				p.flagReclaimedPodsToFreeNUMA(req.PodUid, req.ContainerName)
+			}
 		}
-
-		p.Unlock()
-		return
 	}()
 
-	allocationInfo := p.state.GetAllocationInfo(req.PodUid, req.ContainerName)
 	if allocationInfo != nil && allocationInfo.OriginalAllocationResult.Size() >= reqInt {
-		general.InfoS("already allocated and meet requirement",
-			"podNamespace", req.PodNamespace,
-			"podName", req.PodName,
-			"containerName", req.ContainerName,
-			"numCPUs", reqInt,
-			"originalAllocationResult", allocationInfo.OriginalAllocationResult.String(),
-			"currentResult", allocationInfo.AllocationResult.String())
-
-		return &pluginapi.ResourceAllocationResponse{
-			PodUid:         req.PodUid,
-			PodNamespace:   req.PodNamespace,
-			PodName:        req.PodName,
-			ContainerName:  req.ContainerName,
-			ContainerType:  req.ContainerType,
-			ContainerIndex: req.ContainerIndex,
-			PodRole:        req.PodRole,
-			PodType:        req.PodType,
-			ResourceName:   string(v1.ResourceCPU),
-			AllocationResult: &pluginapi.ResourceAllocation{
-				ResourceAllocation: map[string]*pluginapi.ResourceAllocationInfo{
-					string(v1.ResourceCPU): {
-						OciPropertyName:   util.OCIPropertyNameCPUSetCPUs,
-						IsNodeResource:    false,
-						IsScalarResource:  true,
-						AllocatedQuantity: float64(allocationInfo.AllocationResult.Size()),
-						AllocationResult:  allocationInfo.AllocationResult.String(),
-					},
-				},
-			},
-			Labels:      general.DeepCopyMap(req.Labels),
-			Annotations: general.DeepCopyMap(req.Annotations),
-		}, nil
+		return allocatedResponse(req, reqInt, allocationInfo), nil
 	}
 
 	if p.allocationHandlers[qosLevel] == nil {
 		return nil, fmt.Errorf("katalyst QoS level: %s is not supported yet", qosLevel)
 	}
-	return p.allocationHandlers[qosLevel](ctx, req)
+
+	if respErr = p.alignSidecarNUMABinding(ctx, req); respErr != nil {
+		return nil, respErr
+	}
+
+	// prefer policy depends on state before the container is allocated, so it's figured out in advance
+	var preferPolicy string
+	if p.enablePlacementAnnotations {
+		preferPolicy = p.getEffectivePreferPolicy(ctx, req, qosLevel, reqInt)
+	}
+
+	resp, respErr = p.allocationHandlers[qosLevel](ctx, req)
+	if respErr == nil && preferPolicy != "" {
+		p.recordPreferPolicy(req.PodUid, req.ContainerName, preferPolicy)
+	}
+	if respErr == nil && p.enableWeightedShares {
+		if numaID, ok := getSharedNUMABindingNUMA(p.state.GetAllocationInfo(req.PodUid, req.ContainerName)); ok {
+			if err := p.applyWeightedShares([]int{numaID}); err != nil {
+				general.Warningf("apply weighted cpu shares on NUMA: %d for pod: %s/%s, container: %s failed with error: %v",
+					numaID, req.PodNamespace, req.PodName, req.ContainerName, err)
+			}
+		}
+	}
+	return resp, respErr
 }
 
 // PreStartContainer is called, if indicated by resource plugin during registration phase,
@@ -856,11 +1374,23 @@ func (p *DynamicPolicy) RemovePod(ctx context.Context,
 		}
 	}
 
+	podEntry := p.state.GetPodEntries()[req.PodUid]
+	sharedNUMABindingNUMAs := getSharedNUMABindingNUMAsOfPod(podEntry)
 	err = p.removePod(req.PodUid)
 	if err != nil {
 		general.ErrorS(err, "remove pod failed with error", "podUID", req.PodUid)
 		return nil, err
 	}
+	p.clearPodPreviousNUMAs(req.PodUid)
+	p.clearNUMAMigration(req.PodUid)
+	for _, allocationInfo := range podEntry {
+		p.notifyAllocationChange(allocationInfo, nil)
+	}
+
+	// containers left on NUMAs of the removed pod take over its cpu shares
+	if wErr := p.applyWeightedShares(sharedNUMABindingNUMAs); wErr != nil {
+		general.ErrorS(wErr, "applyWeightedShares failed", "podUID", req.PodUid)
+	}
 
 	aErr := p.adjustAllocationEntries()
 	if aErr != nil {
@@ -870,6 +1400,8 @@ func (p *DynamicPolicy) RemovePod(ctx context.Context,
 	return &pluginapi.RemovePodResponse{}, nil
 }
 
+// removePod releases all containers of the pod at once, i.e. machine state is regenerated
+// and the checkpoint is written only once no matter how many containers the pod has.
 func (p *DynamicPolicy) removePod(podUID string) error {
 	podEntries := p.state.GetPodEntries()
 	if len(podEntries[podUID]) == 0 {
@@ -882,8 +1414,7 @@ func (p *DynamicPolicy) removePod(podUID string) error {
 		return fmt.Errorf("GenerateMachineStateFromPodEntries failed with error: %v", err)
 	}
 
-	p.state.SetPodEntries(podEntries)
-	p.state.SetMachineState(updatedMachineState)
+	p.setPodEntriesAndMachineState(podEntries, updatedMachineState)
 	return nil
 }
 
@@ -906,11 +1437,330 @@ func (p *DynamicPolicy) removeContainer(podUID, containerName string) error {
 		return fmt.Errorf("GenerateMachineStateFromPodEntries failed with error: %v", err)
 	}
 
+	p.setPodEntriesAndMachineState(podEntries, updatedMachineState)
+	return nil
+}
+
+// GetCandidateNUMAs returns NUMA nodes that could currently satisfy the given request of
+// a numa_binding shared_cores container (with the given annotations), along with the available
+// cpu quantity of each of them. it runs the same filtering as hints calculation without admission.
+func (p *DynamicPolicy) GetCandidateNUMAs(reqInt int, annotations map[string]string) ([]int, map[int]int, error) {
+	p.RLock()
+	defer p.RUnlock()
+
+	ctx := context.Background()
+	machineState := p.state.GetMachineState()
+	sharedNUMAPools := p.state.GetSharedNUMAPools()
+	numaNodes, _, err := p.filterNUMANodesForNUMABindingSharedCores(ctx, reqInt, p.state.GetPodEntries(), machineState,
+		sharedNUMAPools, annotations, p.getNUMAConstraint(ctx, nil, annotations), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unavailableCPUs := p.getUnavailableCPUs()
+	candidateNUMAs := make([]int, 0, len(numaNodes))
+	availableQuantities := make(map[int]int, len(numaNodes))
+	for _, nodeID := range numaNodes {
+		availableCPUQuantity := p.getNUMAAvailableCPUQuantity(sharedNUMAPools, nodeID, unavailableCPUs)
+		if availableCPUQuantity < reqInt {
+			continue
+		}
+
+		candidateNUMAs = append(candidateNUMAs, nodeID)
+		availableQuantities[nodeID] = availableCPUQuantity
+	}
+	return candidateNUMAs, availableQuantities, nil
+}
+
+// SetOfflineCPUs updates cpus that should be excluded from allocation at runtime;
+// offline cpus are trimmed from allocations of dedicated_cores containers, and pools
+// (along with shared_cores and reclaimed_cores containers in them) are re-generated off offline cpus.
+func (p *DynamicPolicy) SetOfflineCPUs(offlineCPUs machine.CPUSet) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.offlineCPUs.Equals(offlineCPUs) {
+		return nil
+	}
+
+	general.Infof("offline cpus transform from %s to %s", p.offlineCPUs.String(), offlineCPUs.String())
+	p.offlineCPUs = offlineCPUs.Clone()
+
+	err := p.trimAllocationsWithOfflineCPUs()
+	if err != nil {
+		return fmt.Errorf("trimAllocationsWithOfflineCPUs failed with error: %v", err)
+	}
+
+	err = p.adjustAllocationEntries()
+	if err != nil {
+		return fmt.Errorf("adjustAllocationEntries failed with error: %v", err)
+	}
+	return nil
+}
+
+// trimAllocationsWithOfflineCPUs trims offline cpus from allocations of dedicated_cores containers,
+// containers with all of their cpus offline are kept as they are and will be re-calculated in their next admission.
+func (p *DynamicPolicy) trimAllocationsWithOfflineCPUs() error {
+	podEntries := p.state.GetPodEntries()
+	trimmed := false
+
+	for _, containerEntries := range podEntries {
+		if containerEntries.IsPoolEntry() {
+			continue
+		}
+
+		for containerName, allocationInfo := range containerEntries {
+			if allocationInfo == nil || !state.CheckDedicated(allocationInfo) {
+				continue
+			}
+
+			cpus := allocationInfo.AllocationResult.Intersection(p.offlineCPUs)
+			if cpus.IsEmpty() {
+				continue
+			}
+
+			onlineCPUs := allocationInfo.AllocationResult.Difference(p.offlineCPUs)
+			if onlineCPUs.IsEmpty() {
+				general.Warningf("pod: %s/%s, container: %s is allocated with offline cpus: %s only, "+
+					"it will be re-calculated in the next admission",
+					allocationInfo.PodNamespace, allocationInfo.PodName, containerName, cpus.String())
+				continue
+			}
+
+			topologyAwareAssignments, err := machine.GetNumaAwareAssignments(p.machineInfo.CPUTopology, onlineCPUs)
+			if err != nil {
+				return fmt.Errorf("GetNumaAwareAssignments for pod: %s/%s, container: %s failed with error: %v",
+					allocationInfo.PodNamespace, allocationInfo.PodName, containerName, err)
+			}
+
+			originalOnlineCPUs := allocationInfo.OriginalAllocationResult.Difference(p.offlineCPUs)
+			originalTopologyAwareAssignments, err := machine.GetNumaAwareAssignments(p.machineInfo.CPUTopology, originalOnlineCPUs)
+			if err != nil {
+				return fmt.Errorf("GetNumaAwareAssignments for pod: %s/%s, container: %s failed with error: %v",
+					allocationInfo.PodNamespace, allocationInfo.PodName, containerName, err)
+			}
+
+			general.Warningf("pod: %s/%s, container: %s is allocated with offline cpus: %s, trim allocation result to: %s",
+				allocationInfo.PodNamespace, allocationInfo.PodName, containerName, cpus.String(), onlineCPUs.String())
+			allocationInfo.AllocationResult = onlineCPUs
+			allocationInfo.OriginalAllocationResult = originalOnlineCPUs
+			allocationInfo.TopologyAwareAssignments = topologyAwareAssignments
+			allocationInfo.OriginalTopologyAwareAssignments = originalTopologyAwareAssignments
+			trimmed = true
+		}
+	}
+
+	if !trimmed {
+		return nil
+	}
+
+	machineState, err := p.generateMachineStateWithRetry(podEntries)
+	if err != nil {
+		return fmt.Errorf("calculate machineState by podEntries failed with error: %v", err)
+	}
+
 	p.state.SetPodEntries(podEntries)
-	p.state.SetMachineState(updatedMachineState)
+	p.setMachineState(machineState)
+	return nil
+}
+
+// CordonNUMA marks the NUMA as unschedulable, it won't appear in hints of any qos level
+// from now on, and shared/reclaimed pools are re-generated off it; dedicated_cores
+// and numa_binding allocations on it remain until the pods are evicted.
+func (p *DynamicPolicy) CordonNUMA(numaID int) error {
+	return p.setNUMACordoned(numaID, true)
+}
+
+// UncordonNUMA marks the NUMA as schedulable again
+func (p *DynamicPolicy) UncordonNUMA(numaID int) error {
+	return p.setNUMACordoned(numaID, false)
+}
+
+// GetCordonedNUMAs returns NUMAs excluded from candidates of new allocations
+func (p *DynamicPolicy) GetCordonedNUMAs() machine.CPUSet {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.cordonedNUMAs.Clone()
+}
+
+func (p *DynamicPolicy) setNUMACordoned(numaID int, cordoned bool) error {
+	if !p.machineInfo.CPUDetails.NUMANodes().Contains(numaID) {
+		return fmt.Errorf("invalid NUMA: %d", numaID)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.cordonedNUMAs.Contains(numaID) == cordoned {
+		return nil
+	}
+
+	if cordoned {
+		p.cordonedNUMAs = p.cordonedNUMAs.Union(machine.NewCPUSet(numaID))
+	} else {
+		p.cordonedNUMAs = p.cordonedNUMAs.Difference(machine.NewCPUSet(numaID))
+	}
+	general.Infof("NUMA: %d cordoned: %v, cordoned NUMAs: %s", numaID, cordoned, p.cordonedNUMAs.String())
+
+	if err := p.adjustAllocationEntries(); err != nil {
+		return fmt.Errorf("adjustAllocationEntries failed with error: %v", err)
+	}
+	return nil
+}
+
+// excludeCordonedCPUs returns cpus excluding those in cordoned NUMAs,
+// and cpus are returned as is if nothing is left, to avoid generating empty pools
+func (p *DynamicPolicy) excludeCordonedCPUs(cpus machine.CPUSet) machine.CPUSet {
+	if p.cordonedNUMAs.IsEmpty() {
+		return cpus
+	}
+
+	uncordonedCPUs := cpus.Difference(p.machineInfo.CPUDetails.CPUsInNUMANodes(p.cordonedNUMAs.ToSliceNoSortInt()...))
+	if uncordonedCPUs.IsEmpty() {
+		general.Warningf("all cpus: %s are in cordoned NUMAs: %s", cpus.String(), p.cordonedNUMAs.String())
+		return cpus
+	}
+	return uncordonedCPUs
+}
+
+// filterCordonedNUMANodes returns NUMAs excluding the cordoned ones
+func (p *DynamicPolicy) filterCordonedNUMANodes(numaNodes []int) []int {
+	if p.cordonedNUMAs.IsEmpty() {
+		return numaNodes
+	}
+
+	filteredNUMANodes := make([]int, 0, len(numaNodes))
+	for _, numaNode := range numaNodes {
+		if p.cordonedNUMAs.Contains(numaNode) {
+			general.InfofV(4, "skip cordoned NUMA: %d", numaNode)
+			continue
+		}
+		filteredNUMANodes = append(filteredNUMANodes, numaNode)
+	}
+	return filteredNUMANodes
+}
+
+// GetOfflineCPUs returns cpus that are excluded from allocation at runtime
+func (p *DynamicPolicy) GetOfflineCPUs() machine.CPUSet {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.offlineCPUs.Clone()
+}
+
+// SetReservedCPUs updates cpus reserved for system at runtime, and reserve pool
+// is re-initialized accordingly; allocatable reported by GetTopologyAwareAllocatableResources
+// will reflect the latest reservation.
+func (p *DynamicPolicy) SetReservedCPUs(reservedCPUs machine.CPUSet) error {
+	p.Lock()
+	defer p.Unlock()
+
+	reservedCPUs, err := validateReservedCPUs(reservedCPUs, p.machineInfo.CPUDetails.CPUs(), p.trimInvalidReservedCPUs)
+	if err != nil {
+		return err
+	} else if p.reservedCPUs.Equals(reservedCPUs) {
+		return nil
+	}
+
+	if overlap := reservedCPUs.Intersection(p.getQoSReservedPoolsCPUs()); !overlap.IsEmpty() {
+		return fmt.Errorf("reserved cpus: %s overlap with reserved pools of QoS levels at: %s",
+			reservedCPUs.String(), overlap.String())
+	}
+
+	general.Infof("reserved cpus transform from %s to %s", p.reservedCPUs.String(), reservedCPUs.String())
+	p.reservedCPUs = reservedCPUs.Clone()
+
+	if err := p.initReservePool(); err != nil {
+		return fmt.Errorf("initReservePool failed with error: %v", err)
+	}
+	return nil
+}
+
+// validateReservedCPUs checks that reserved cpus are all present in the machine topology, otherwise availability
+// would be miscalculated silently; cpus absent from it are either trimmed with a warning or rejected.
+// no reserved cpu is allowed if none is configured, but a configured reservation can't be trimmed to nothing,
+// otherwise system agents would contend with workloads silently.
+func validateReservedCPUs(reservedCPUs, allCPUs machine.CPUSet, trim bool) (machine.CPUSet, error) {
+	invalidCPUs := reservedCPUs.Difference(allCPUs)
+	if invalidCPUs.IsEmpty() {
+		return reservedCPUs, nil
+	} else if !trim {
+		return reservedCPUs, fmt.Errorf("reserved cpus: %s contain cpus: %s absent from machine topology: %s",
+			reservedCPUs.String(), invalidCPUs.String(), allCPUs.String())
+	}
+
+	trimmedCPUs := reservedCPUs.Intersection(allCPUs)
+	if trimmedCPUs.IsEmpty() {
+		return reservedCPUs, fmt.Errorf("reserved cpus: %s are all absent from machine topology: %s",
+			reservedCPUs.String(), allCPUs.String())
+	}
+
+	general.Warningf("reserved cpus: %s contain cpus: %s absent from machine topology: %s, trim them to: %s",
+		reservedCPUs.String(), invalidCPUs.String(), allCPUs.String(), trimmedCPUs.String())
+	return trimmedCPUs, nil
+}
+
+// GetReservedCPUs returns cpus reserved for system
+func (p *DynamicPolicy) GetReservedCPUs() machine.CPUSet {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.reservedCPUs.Clone()
+}
+
+// SetCPUNUMAHintPreferPolicy updates the NUMA hint prefer policy at runtime, unknown policies are rejected
+// instead of falling through to spreading; hints calculated after it returns will use the new policy.
+func (p *DynamicPolicy) SetCPUNUMAHintPreferPolicy(policy string) error {
+	switch policy {
+	case cpuconsts.CPUNUMAHintPreferPolicyPacking, cpuconsts.CPUNUMAHintPreferPolicySpreading,
+		cpuconsts.CPUNUMAHintPreferPolicyDynamicPacking:
+	default:
+		return fmt.Errorf("unknown cpu NUMA hint prefer policy: %s", policy)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.cpuNUMAHintPreferPolicy != policy {
+		general.Infof("cpu NUMA hint prefer policy transforms from %s to %s", p.cpuNUMAHintPreferPolicy, policy)
+		p.cpuNUMAHintPreferPolicy = policy
+	}
 	return nil
 }
 
+// GetCPUNUMAHintPreferPolicy returns the NUMA hint prefer policy in use
+func (p *DynamicPolicy) GetCPUNUMAHintPreferPolicy() string {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.cpuNUMAHintPreferPolicy
+}
+
+// GetNUMAAllocatable returns allocatable cpus quantity (excluding reserved and offline cpus) of each NUMA,
+// so that consumers like sys-advisor and reporters share the same source of truth.
+func (p *DynamicPolicy) GetNUMAAllocatable() map[int]int {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.getNUMAAllocatable()
+}
+
+func (p *DynamicPolicy) getNUMAAllocatable() map[int]int {
+	numaNodes := p.machineInfo.CPUDetails.NUMANodes().ToSliceInt()
+	numaAllocatable := make(map[int]int, len(numaNodes))
+	for _, numaNode := range numaNodes {
+		numaAllocatable[numaNode] = p.getNUMAAllocatableCPUQuantity(numaNode)
+	}
+	return numaAllocatable
+}
+
+// getUnavailableCPUs returns cpus that can't be allocated to any container,
+// including reservedCPUs, reserved pools of QoS levels and offlineCPUs
+func (p *DynamicPolicy) getUnavailableCPUs() machine.CPUSet {
+	return p.getAllReservedCPUs().Union(p.offlineCPUs)
+}
+
 // initAdvisorClientConn initializes cpu-advisor related connections
 func (p *DynamicPolicy) initAdvisorClientConn() (err error) {
 	cpuAdvisorConn, err := process.Dial(p.cpuAdvisorSocketAbsPath, 5*time.Second)
@@ -965,7 +1815,7 @@ func (p *DynamicPolicy) cleanPools() error {
 		}
 
 		p.state.SetPodEntries(podEntries)
-		p.state.SetMachineState(machineState)
+		p.setMachineState(machineState)
 	} else {
 		general.Infof("there is no pool to delete")
 	}
@@ -1010,7 +1860,7 @@ func (p *DynamicPolicy) initReclaimPool() error {
 		noneResidentCPUs := podEntries.GetFilteredPoolsCPUSet(state.ResidentPools)
 
 		machineState := p.state.GetMachineState()
-		availableCPUs := machineState.GetFilteredAvailableCPUSet(p.reservedCPUs,
+		availableCPUs := machineState.GetFilteredAvailableCPUSet(p.getAllReservedCPUs(),
 			func(ai *state.AllocationInfo) bool {
 				return state.CheckDedicated(ai) || state.CheckNUMABinding(ai)
 			},
@@ -1031,7 +1881,7 @@ func (p *DynamicPolicy) initReclaimPool() error {
 
 		// for residual pools, we must make them exist even if cause overlap
 		// todo: noneResidentCPUs is the same as reservedCPUs, why should we do this?
-		allAvailableCPUs := p.machineInfo.CPUDetails.CPUs().Difference(p.reservedCPUs)
+		allAvailableCPUs := p.machineInfo.CPUDetails.CPUs().Difference(p.getAllReservedCPUs())
 		if reclaimedCPUSet.IsEmpty() {
 			reclaimedCPUSet, _, err = calculator.TakeByNUMABalance(p.machineInfo, allAvailableCPUs, reservedReclaimedCPUsSize)
 			if err != nil {
@@ -1091,3 +1941,22 @@ func (p *DynamicPolicy) getContainerRequestedCores(allocationInfo *state.Allocat
 	}
 	return allocationInfo.RequestQuantity
 }
+
+// getKatalystQoSLevelFromResourceReq wraps util.GetKatalystQoSLevelFromResourceReq to admit requests of pods
+// without katalyst QoS annotations according to unclassifiedPodPolicy, instead of classifying them as shared_cores.
+func (p *DynamicPolicy) getKatalystQoSLevelFromResourceReq(req *pluginapi.ResourceRequest) (string, error) {
+	if req != nil && len(p.qosConfig.FilterQoSMap(req.Annotations)) == 0 {
+		switch p.unclassifiedPodPolicy {
+		case cpuconsts.UnclassifiedPodPolicyReject:
+			return "", fmt.Errorf("pod without katalyst QoS annotations is rejected by unclassified pod policy")
+		case cpuconsts.UnclassifiedPodPolicyReclaimedCores:
+			general.Infof("pod: %s/%s, container: %s without katalyst QoS annotations is treated as %s",
+				req.PodNamespace, req.PodName, req.ContainerName, consts.PodAnnotationQoSLevelReclaimedCores)
+			if req.Annotations == nil {
+				req.Annotations = make(map[string]string)
+			}
+			req.Annotations[consts.PodAnnotationQoSLevelKey] = consts.PodAnnotationQoSLevelReclaimedCores
+		}
+	}
+	return util.GetKatalystQoSLevelFromResourceReq(p.qosConfig, req)
+}
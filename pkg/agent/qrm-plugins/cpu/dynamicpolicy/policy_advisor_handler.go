@@ -499,9 +499,9 @@ func (p *DynamicPolicy) applyBlocks(blockCPUSet advisorapi.BlockCPUSet, resp *ad
 	// if there is no block for state.PoolNameReclaim pool,
 	// we must make it existing here even if cause overlap
 	if newEntries.CheckPoolEmpty(state.PoolNameReclaim) {
-		reclaimPoolCPUSet := p.machineInfo.CPUDetails.CPUs().Difference(p.reservedCPUs).Difference(pooledUnionDedicatedCPUSet)
+		reclaimPoolCPUSet := p.machineInfo.CPUDetails.CPUs().Difference(p.getAllReservedCPUs()).Difference(pooledUnionDedicatedCPUSet)
 		if reclaimPoolCPUSet.IsEmpty() {
-			allAvailableCPUs := p.machineInfo.CPUDetails.CPUs().Difference(p.reservedCPUs)
+			allAvailableCPUs := p.machineInfo.CPUDetails.CPUs().Difference(p.getAllReservedCPUs())
 
 			var tErr error
 			reclaimPoolCPUSet, _, tErr = calculator.TakeByNUMABalance(p.machineInfo, allAvailableCPUs, reservedReclaimedCPUsSize)
@@ -541,7 +541,7 @@ func (p *DynamicPolicy) applyBlocks(blockCPUSet advisorapi.BlockCPUSet, resp *ad
 	sharedBindingNUMACPUs := p.machineInfo.CPUDetails.CPUsInNUMANodes(sharedBindingNUMAs.UnsortedList()...)
 	// rampUpCPUs include reclaim pool in NUMAs without NUMA_binding cpus
 	rampUpCPUs := p.machineInfo.CPUDetails.CPUs().
-		Difference(p.reservedCPUs).
+		Difference(p.getAllReservedCPUs()).
 		Difference(dedicatedCPUSet).
 		Difference(sharedBindingNUMACPUs)
 
@@ -655,7 +655,7 @@ func (p *DynamicPolicy) applyBlocks(blockCPUSet advisorapi.BlockCPUSet, resp *ad
 		return fmt.Errorf("calculate machineState by newPodEntries failed with error: %v", err)
 	}
 	p.state.SetPodEntries(newEntries)
-	p.state.SetMachineState(newMachineState)
+	p.setMachineState(newMachineState)
 
 	return nil
 }
@@ -67,8 +67,8 @@ func (p *DynamicPolicy) sharedCoresWithoutNUMABindingAllocationHandler(_ context
 	}
 
 	machineState := p.state.GetMachineState()
-	pooledCPUs := machineState.GetFilteredAvailableCPUSet(p.reservedCPUs,
-		state.CheckDedicated, state.CheckNUMABinding)
+	pooledCPUs := p.excludeCordonedCPUs(machineState.GetFilteredAvailableCPUSet(p.getUnavailableCPUs(),
+		state.CheckDedicated, state.CheckNUMABinding))
 
 	if pooledCPUs.IsEmpty() {
 		general.Errorf("pod: %s/%s, container: %s get empty pooledCPUs", req.PodNamespace, req.PodName, req.ContainerName)
@@ -108,9 +108,9 @@ func (p *DynamicPolicy) sharedCoresWithoutNUMABindingAllocationHandler(_ context
 			OwnerPoolName:                    state.EmptyOwnerPoolName,
 			PodRole:                          req.PodRole,
 			PodType:                          req.PodType,
-			AllocationResult:                 pooledCPUs,
+			AllocationResult:                 pooledCPUs.Clone(),
 			OriginalAllocationResult:         pooledCPUs.Clone(),
-			TopologyAwareAssignments:         pooledCPUsTopologyAwareAssignments,
+			TopologyAwareAssignments:         machine.DeepcopyCPUAssignment(pooledCPUsTopologyAwareAssignments),
 			OriginalTopologyAwareAssignments: machine.DeepcopyCPUAssignment(pooledCPUsTopologyAwareAssignments),
 			InitTimestamp:                    time.Now().Format(util.QRMTimeFormat),
 			Labels:                           general.DeepCopyMap(req.Labels),
@@ -141,9 +141,9 @@ func (p *DynamicPolicy) sharedCoresWithoutNUMABindingAllocationHandler(_ context
 		general.Infof("pod: %s/%s, container: %s is still in ramp up, allocate pooled cpus: %s",
 			req.PodNamespace, req.PodName, req.ContainerName, pooledCPUs.String())
 
-		allocationInfo.AllocationResult = pooledCPUs
+		allocationInfo.AllocationResult = pooledCPUs.Clone()
 		allocationInfo.OriginalAllocationResult = pooledCPUs.Clone()
-		allocationInfo.TopologyAwareAssignments = pooledCPUsTopologyAwareAssignments
+		allocationInfo.TopologyAwareAssignments = machine.DeepcopyCPUAssignment(pooledCPUsTopologyAwareAssignments)
 		allocationInfo.OriginalTopologyAwareAssignments = machine.DeepcopyCPUAssignment(pooledCPUsTopologyAwareAssignments)
 	} else {
 		_, err := p.doAndCheckPutAllocationInfo(allocationInfo, true)
@@ -161,13 +161,13 @@ func (p *DynamicPolicy) sharedCoresWithoutNUMABindingAllocationHandler(_ context
 		p.state.SetAllocationInfo(allocationInfo.PodUid, allocationInfo.ContainerName, allocationInfo)
 		podEntries := p.state.GetPodEntries()
 
-		updatedMachineState, err := generateMachineStateFromPodEntries(p.machineInfo.CPUTopology, podEntries)
+		updatedMachineState, err := p.generateMachineStateWithRetry(podEntries)
 		if err != nil {
 			general.Errorf("pod: %s/%s, container: %s GenerateMachineStateFromPodEntries failed with error: %v",
 				req.PodNamespace, req.PodName, req.ContainerName, err)
 			return nil, fmt.Errorf("GenerateMachineStateFromPodEntries failed with error: %v", err)
 		}
-		p.state.SetMachineState(updatedMachineState)
+		p.setMachineState(updatedMachineState)
 	}
 
 	resp, err := cpuutil.PackAllocationResponse(allocationInfo, string(v1.ResourceCPU), util.OCIPropertyNameCPUSetCPUs, false, true, req)
@@ -192,6 +192,8 @@ func (p *DynamicPolicy) reclaimedCoresAllocationHandler(_ context.Context,
 	}
 
 	allocationInfo := p.state.GetAllocationInfo(req.PodUid, req.ContainerName)
+	// NUMA hint is recorded in annotations, get it before they are overwritten by req
+	numaHint, hasNUMAHint := allocationInfo.GetReclaimedNUMAHint()
 	err = updateAllocationInfoByReq(req, allocationInfo)
 	if err != nil {
 		general.Errorf("pod: %s/%s, container: %s updateAllocationInfoByReq failed with error: %v",
@@ -237,6 +239,20 @@ func (p *DynamicPolicy) reclaimedCoresAllocationHandler(_ context.Context,
 		}
 	}
 
+	// record the NUMA that container is admitted to, so that reclaimed allocation
+	// is accounted per NUMA to bound overcommit and quota
+	if p.isReclaimedNUMAAccountingEnabled() {
+		if !hasNUMAHint && req.Hint != nil && len(req.Hint.Nodes) == 1 {
+			numaHint, hasNUMAHint = int(req.Hint.Nodes[0]), true
+		}
+
+		if hasNUMAHint {
+			allocationInfo.Annotations = general.MergeMap(allocationInfo.Annotations, map[string]string{
+				cpuconsts.CPUStateAnnotationKeyNUMAHint: fmt.Sprintf("%d", numaHint),
+			})
+		}
+	}
+
 	allocationInfo.OwnerPoolName = state.PoolNameReclaim
 	allocationInfo.AllocationResult = reclaimedAllocationInfo.AllocationResult.Clone()
 	allocationInfo.OriginalAllocationResult = reclaimedAllocationInfo.OriginalAllocationResult.Clone()
@@ -248,7 +264,7 @@ func (p *DynamicPolicy) reclaimedCoresAllocationHandler(_ context.Context,
 	p.state.SetAllocationInfo(allocationInfo.PodUid, allocationInfo.ContainerName, allocationInfo)
 	podEntries := p.state.GetPodEntries()
 
-	updatedMachineState, err := generateMachineStateFromPodEntries(p.machineInfo.CPUTopology, podEntries)
+	updatedMachineState, err := p.generateMachineStateWithRetry(podEntries)
 	if err != nil {
 		general.Errorf("pod: %s/%s, container: %s GenerateMachineStateFromPodEntries failed with error: %v",
 			req.PodNamespace, req.PodName, req.ContainerName, err)
@@ -261,7 +277,7 @@ func (p *DynamicPolicy) reclaimedCoresAllocationHandler(_ context.Context,
 			req.PodNamespace, req.PodName, req.ContainerName, err)
 		return nil, fmt.Errorf("PackResourceAllocationResponseByAllocationInfo failed with error: %v", err)
 	}
-	p.state.SetMachineState(updatedMachineState)
+	p.setMachineState(updatedMachineState)
 
 	return resp, nil
 }
@@ -304,7 +320,7 @@ func (p *DynamicPolicy) dedicatedCoresWithNUMABindingAllocationHandler(ctx conte
 		podEntries := p.state.GetPodEntries()
 
 		var err error
-		machineState, err = generateMachineStateFromPodEntries(p.machineInfo.CPUTopology, podEntries)
+		machineState, err = p.generateMachineStateWithRetry(podEntries)
 		if err != nil {
 			general.Errorf("pod: %s/%s, container: %s GenerateMachineStateFromPodEntries failed with error: %v",
 				req.PodNamespace, req.PodName, req.ContainerName, err)
@@ -317,7 +333,19 @@ func (p *DynamicPolicy) dedicatedCoresWithNUMABindingAllocationHandler(ctx conte
 		return nil, fmt.Errorf("getReqQuantityFromResourceReq failed with error: %v", err)
 	}
 
-	result, err := p.allocateNumaBindingCPUs(reqInt, req.Hint, machineState, req.Annotations)
+	if numaID, ok := p.needSharedDisplacement(reqInt, req.Hint, machineState, req.Annotations); ok {
+		if err = p.displaceSharedPods(ctx, numaID); err != nil {
+			general.Errorf("pod: %s/%s, container: %s displace numa_binding shared_cores pods out of NUMA: %d failed with error: %v",
+				req.PodNamespace, req.PodName, req.ContainerName, numaID, err)
+			return nil, fmt.Errorf("displaceSharedPods failed with error: %v", err)
+		}
+		machineState = p.state.GetMachineState()
+	}
+
+	result, planned := p.takePlannedNUMABindingCPUs(ctx, req.Hint, machineState)
+	if !planned {
+		result, err = p.allocateNumaBindingCPUs(reqInt, req.Hint, machineState, req.Annotations)
+	}
 	if err != nil {
 		general.ErrorS(err, "unable to allocate CPUs",
 			"podNamespace", req.PodNamespace,
@@ -334,6 +362,7 @@ func (p *DynamicPolicy) dedicatedCoresWithNUMABindingAllocationHandler(ctx conte
 		"containerName", req.ContainerName,
 		"numCPUsInt", reqInt,
 		"numCPUsFloat64", reqFloat64,
+		"planned", planned,
 		"result", result.String())
 
 	topologyAwareAssignments, err := machine.GetNumaAwareAssignments(p.machineInfo.CPUTopology, result)
@@ -375,13 +404,13 @@ func (p *DynamicPolicy) dedicatedCoresWithNUMABindingAllocationHandler(ctx conte
 	p.state.SetAllocationInfo(allocationInfo.PodUid, allocationInfo.ContainerName, allocationInfo)
 	podEntries := p.state.GetPodEntries()
 
-	updatedMachineState, err := generateMachineStateFromPodEntries(p.machineInfo.CPUTopology, podEntries)
+	updatedMachineState, err := p.generateMachineStateWithRetry(podEntries)
 	if err != nil {
 		general.Errorf("pod: %s/%s, container: %s GenerateMachineStateFromPodEntries failed with error: %v",
 			req.PodNamespace, req.PodName, req.ContainerName, err)
 		return nil, fmt.Errorf("GenerateMachineStateFromPodEntries failed with error: %v", err)
 	}
-	p.state.SetMachineState(updatedMachineState)
+	p.setMachineState(updatedMachineState)
 
 	err = p.adjustAllocationEntries()
 	if err != nil {
@@ -399,11 +428,12 @@ func (p *DynamicPolicy) dedicatedCoresWithNUMABindingAllocationHandler(ctx conte
 	return resp, nil
 }
 
-// allocationSidecarHandler currently we set cpuset of sidecar to the cpuset of its main container
+// allocationSidecarHandler currently we set cpuset of sidecar to the cpuset of its main container,
+// except for sidecars of dedicated_cores pods in isolated cpuset mode.
 func (p *DynamicPolicy) allocationSidecarHandler(_ context.Context,
 	req *pluginapi.ResourceRequest, qosLevel string,
 ) (*pluginapi.ResourceAllocationResponse, error) {
-	_, reqFloat64, err := util.GetQuantityFromResourceReq(req)
+	reqInt, reqFloat64, err := util.GetQuantityFromResourceReq(req)
 	if err != nil {
 		return nil, fmt.Errorf("getReqQuantityFromResourceReq failed with error: %v", err)
 	}
@@ -424,6 +454,37 @@ func (p *DynamicPolicy) allocationSidecarHandler(_ context.Context,
 		return &pluginapi.ResourceAllocationResponse{}, nil
 	}
 
+	allocationResult := mainContainerAllocationInfo.AllocationResult.Clone()
+	originalAllocationResult := mainContainerAllocationInfo.OriginalAllocationResult.Clone()
+	topologyAwareAssignments := machine.DeepcopyCPUAssignment(mainContainerAllocationInfo.TopologyAwareAssignments)
+	originalTopologyAwareAssignments := machine.DeepcopyCPUAssignment(mainContainerAllocationInfo.OriginalTopologyAwareAssignments)
+
+	isolated := qosLevel == apiconsts.PodAnnotationQoSLevelDedicatedCores &&
+		qosutil.GetSidecarCPUSetMode(req.Annotations) == cpuconsts.SidecarCPUSetModeIsolated
+	if isolated && reqInt == 0 {
+		general.Infof("pod: %s/%s, sidecar: %s in isolated cpuset mode requests no cpus, share cpus with main container",
+			req.PodNamespace, req.PodName, req.ContainerName)
+	} else if isolated {
+		allocationResult, err = p.getIsolatedSidecarCPUs(reqInt, mainContainerAllocationInfo)
+		if err != nil {
+			general.Errorf("pod: %s/%s, sidecar: %s getIsolatedSidecarCPUs failed with error: %v",
+				req.PodNamespace, req.PodName, req.ContainerName, err)
+			return nil, fmt.Errorf("getIsolatedSidecarCPUs failed with error: %v", err)
+		}
+
+		topologyAwareAssignments, err = machine.GetNumaAwareAssignments(p.machineInfo.CPUTopology, allocationResult)
+		if err != nil {
+			general.Errorf("pod: %s/%s, sidecar: %s GetNumaAwareAssignments failed with error: %v",
+				req.PodNamespace, req.PodName, req.ContainerName, err)
+			return nil, fmt.Errorf("GetNumaAwareAssignments failed with error: %v", err)
+		}
+
+		originalAllocationResult = allocationResult.Clone()
+		originalTopologyAwareAssignments = machine.DeepcopyCPUAssignment(topologyAwareAssignments)
+		general.Infof("pod: %s/%s, sidecar: %s is isolated with cpus: %s",
+			req.PodNamespace, req.PodName, req.ContainerName, allocationResult.String())
+	}
+
 	allocationInfo := &state.AllocationInfo{
 		PodUid:                           req.PodUid,
 		PodNamespace:                     req.PodNamespace,
@@ -434,10 +495,10 @@ func (p *DynamicPolicy) allocationSidecarHandler(_ context.Context,
 		PodRole:                          req.PodRole,
 		PodType:                          req.PodType,
 		OwnerPoolName:                    mainContainerAllocationInfo.OwnerPoolName,
-		AllocationResult:                 mainContainerAllocationInfo.AllocationResult.Clone(),
-		OriginalAllocationResult:         mainContainerAllocationInfo.OriginalAllocationResult.Clone(),
-		TopologyAwareAssignments:         machine.DeepcopyCPUAssignment(mainContainerAllocationInfo.TopologyAwareAssignments),
-		OriginalTopologyAwareAssignments: machine.DeepcopyCPUAssignment(mainContainerAllocationInfo.OriginalTopologyAwareAssignments),
+		AllocationResult:                 allocationResult,
+		OriginalAllocationResult:         originalAllocationResult,
+		TopologyAwareAssignments:         topologyAwareAssignments,
+		OriginalTopologyAwareAssignments: originalTopologyAwareAssignments,
 		InitTimestamp:                    time.Now().Format(util.QRMTimeFormat),
 		QoSLevel:                         qosLevel,
 		Labels:                           general.DeepCopyMap(req.Labels),
@@ -450,13 +511,13 @@ func (p *DynamicPolicy) allocationSidecarHandler(_ context.Context,
 	p.state.SetAllocationInfo(allocationInfo.PodUid, allocationInfo.ContainerName, allocationInfo)
 	podEntries = p.state.GetPodEntries()
 
-	updatedMachineState, err := generateMachineStateFromPodEntries(p.machineInfo.CPUTopology, podEntries)
+	updatedMachineState, err := p.generateMachineStateWithRetry(podEntries)
 	if err != nil {
 		general.Errorf("pod: %s/%s, container: %s GenerateMachineStateFromPodEntries failed with error: %v",
 			req.PodNamespace, req.PodName, req.ContainerName, err)
 		return nil, fmt.Errorf("GenerateMachineStateFromPodEntries failed with error: %v", err)
 	}
-	p.state.SetMachineState(updatedMachineState)
+	p.setMachineState(updatedMachineState)
 
 	resp, err := cpuutil.PackAllocationResponse(allocationInfo, string(v1.ResourceCPU), util.OCIPropertyNameCPUSetCPUs, false, true, req)
 	if err != nil {
@@ -467,6 +528,31 @@ func (p *DynamicPolicy) allocationSidecarHandler(_ context.Context,
 	return resp, nil
 }
 
+// getIsolatedSidecarCPUs takes cpus for the sidecar by topology from available cpus in NUMAs of its main container,
+// i.e. cpus that are neither allocated to numa_binding containers (including other isolated sidecars) nor reserved
+// or offline. the taken cpus are counted as allocated in machine state, so they won't be handed out again.
+func (p *DynamicPolicy) getIsolatedSidecarCPUs(reqInt int,
+	mainContainerAllocationInfo *state.AllocationInfo,
+) (machine.CPUSet, error) {
+	machineState := p.state.GetMachineState()
+	unavailableCPUs := p.getUnavailableCPUs()
+
+	availableCPUs := machine.NewCPUSet()
+	for numaID, cset := range mainContainerAllocationInfo.TopologyAwareAssignments {
+		if cset.IsEmpty() || machineState[numaID] == nil {
+			continue
+		}
+		availableCPUs = availableCPUs.Union(machineState[numaID].GetAvailableCPUSet(unavailableCPUs))
+	}
+
+	if availableCPUs.Size() < reqInt {
+		return machine.NewCPUSet(), fmt.Errorf("available cpus: %s in NUMAs: %s of main container are not enough for request: %d",
+			availableCPUs.String(), mainContainerAllocationInfo.GetAllocationResultNUMASet().String(), reqInt)
+	}
+
+	return p.takeAvoidingCoolingDownCPUs(availableCPUs, reqInt, p.takeByTopology)
+}
+
 func (p *DynamicPolicy) sharedCoresWithNUMABindingAllocationHandler(ctx context.Context,
 	req *pluginapi.ResourceRequest,
 ) (*pluginapi.ResourceAllocationResponse, error) {
@@ -474,6 +560,18 @@ func (p *DynamicPolicy) sharedCoresWithNUMABindingAllocationHandler(ctx context.
 		return p.allocationSidecarHandler(ctx, req, apiconsts.PodAnnotationQoSLevelSharedCores)
 	}
 
+	// soft numa_binding container without single-NUMA hint has degraded to be without NUMA binding
+	if qosutil.AnnotationsIndicateSoftNUMABinding(req.Annotations) && (req.Hint == nil || len(req.Hint.Nodes) != 1) {
+		general.Infof("pod: %s/%s, container: %s with soft numa_binding got hint: %v, allocate it without NUMA binding",
+			req.PodNamespace, req.PodName, req.ContainerName, req.Hint)
+
+		degradedReq := *req
+		degradedReq.Annotations = general.DeepCopyMap(req.Annotations)
+		delete(degradedReq.Annotations, apiconsts.PodAnnotationMemoryEnhancementNumaBinding)
+		delete(degradedReq.Annotations, apiconsts.PodAnnotationMemoryEnhancementNumaExclusive)
+		return p.sharedCoresWithoutNUMABindingAllocationHandler(ctx, &degradedReq)
+	}
+
 	// there is no need to delete old allocationInfo for the container if it exists,
 	// allocateSharedNumaBindingCPUs will re-calculate pool size and avoid counting same entry twice
 	allocationInfo, err := p.allocateSharedNumaBindingCPUs(req, req.Hint)
@@ -518,26 +616,48 @@ func (p *DynamicPolicy) allocateNumaBindingCPUs(numCPUs int, hint *pluginapi.Top
 
 	result := machine.NewCPUSet()
 	alignedAvailableCPUs := machine.CPUSet{}
+	unavailableCPUs := p.getUnavailableCPUs()
 	for _, numaNode := range hint.Nodes {
-		alignedAvailableCPUs = alignedAvailableCPUs.Union(machineState[int(numaNode)].GetAvailableCPUSet(p.reservedCPUs))
+		alignedAvailableCPUs = alignedAvailableCPUs.Union(machineState[int(numaNode)].GetAvailableCPUSet(unavailableCPUs))
 	}
 
 	var alignedCPUs machine.CPUSet
 
-	if qosutil.AnnotationsIndicateNUMAExclusive(reqAnnotations) {
+	if isNodeExclusive(reqAnnotations) {
 		// todo: currently we hack dedicated_cores with NUMA binding take up whole NUMA,
 		//  and we will modify strategy here if assumption above breaks.
 		alignedCPUs = alignedAvailableCPUs.Clone()
 	} else {
-		var err error
-		alignedCPUs, err = calculator.TakeByTopology(p.machineInfo, alignedAvailableCPUs, numCPUs)
+		coreClassCPUs, targetCoreClass, err := p.getCoreClassCPUs(reqAnnotations)
+		if err != nil {
+			return machine.NewCPUSet(), err
+		} else if targetCoreClass {
+			alignedAvailableCPUs = alignedAvailableCPUs.Intersection(coreClassCPUs)
+		}
+
+		var fullCores bool
+		numCPUs, fullCores = p.alignRequestToFullCores(numCPUs, reqAnnotations)
+		if fullCores {
+			alignedAvailableCPUs = p.machineInfo.CPUDetails.CPUsInCores(
+				p.machineInfo.CPUDetails.FullCoresInCPUs(alignedAvailableCPUs).ToSliceNoSortInt()...)
+		}
+
+		takeCPUs := p.takeByTopology
+		if !fullCores && qosutil.AnnotationsIndicateSpreadCores(reqAnnotations) {
+			takeCPUs = p.takeSpreadByTopology
+		}
+		if qosutil.AnnotationsIndicateL3CachePacking(reqAnnotations) {
+			takeCPUs = p.takeInFewestL3CacheDomains(takeCPUs)
+		}
+
+		alignedCPUs, err = p.takeAvoidingCoolingDownCPUs(alignedAvailableCPUs, numCPUs, takeCPUs)
 		if err != nil {
-			general.ErrorS(err, "take cpu for NUMA not exclusive binding container failed",
+			general.ErrorS(err, "take cpu for NUMA binding container not taking up whole NUMAs failed",
 				"hints", hint.Nodes,
 				"alignedAvailableCPUs", alignedAvailableCPUs.String())
 
 			return machine.NewCPUSet(),
-				fmt.Errorf("take cpu for NUMA not exclusive binding container failed with err: %v", err)
+				fmt.Errorf("take cpu for NUMA binding container not taking up whole NUMAs failed with err: %v", err)
 		}
 	}
 
@@ -715,7 +835,7 @@ func (p *DynamicPolicy) adjustAllocationEntries() error {
 func (p *DynamicPolicy) adjustPoolsAndIsolatedEntries(poolsQuantityMap map[string]map[int]int,
 	isolatedQuantityMap map[string]map[string]int, entries state.PodEntries, machineState state.NUMANodeMap,
 ) error {
-	availableCPUs := machineState.GetFilteredAvailableCPUSet(p.reservedCPUs, nil, state.CheckDedicatedNUMABinding)
+	availableCPUs := machineState.GetFilteredAvailableCPUSet(p.getUnavailableCPUs(), nil, state.CheckDedicatedNUMABinding)
 
 	poolsCPUSet, isolatedCPUSet, err := p.generatePoolsAndIsolation(poolsQuantityMap, isolatedQuantityMap, availableCPUs)
 	if err != nil {
@@ -879,10 +999,10 @@ func (p *DynamicPolicy) applyPoolsAndIsolatedInfo(poolsCPUSet map[string]machine
 
 	sharedBindingNUMACPUs := p.machineInfo.CPUDetails.CPUsInNUMANodes(sharedBindingNUMAs.UnsortedList()...)
 	// rampUpCPUs include reclaim pool in NUMAs without NUMA_binding cpus
-	rampUpCPUs := machineState.GetFilteredAvailableCPUSet(p.reservedCPUs,
+	rampUpCPUs := p.excludeCordonedCPUs(machineState.GetFilteredAvailableCPUSet(p.getUnavailableCPUs(),
 		nil, state.CheckDedicatedNUMABinding).
 		Difference(unionDedicatedIsolatedCPUSet).
-		Difference(sharedBindingNUMACPUs)
+		Difference(sharedBindingNUMACPUs))
 
 	rampUpCPUsTopologyAwareAssignments, err := machine.GetNumaAwareAssignments(p.machineInfo.CPUTopology, rampUpCPUs)
 	if err != nil {
@@ -1007,12 +1127,12 @@ func (p *DynamicPolicy) applyPoolsAndIsolatedInfo(poolsCPUSet map[string]machine
 	}
 
 	// use pod entries generated above to generate machine state info, and store in local state
-	machineState, err = generateMachineStateFromPodEntries(p.machineInfo.CPUTopology, newPodEntries)
+	machineState, err = p.generateMachineStateWithRetry(newPodEntries)
 	if err != nil {
 		return fmt.Errorf("calculate machineState by newPodEntries failed with error: %v", err)
 	}
 	p.state.SetPodEntries(newPodEntries)
-	p.state.SetMachineState(machineState)
+	p.setMachineState(machineState)
 
 	return nil
 }
@@ -1041,7 +1161,7 @@ func (p *DynamicPolicy) generateNUMABindingPoolsCPUSetInPlace(poolsCPUSet map[st
 
 	for numaID, numaPoolsToQuantityMap := range numaToPoolQuantityMap {
 		numaPoolsTotalQuantity := general.SumUpMapValues(numaPoolsToQuantityMap)
-		numaCPUs := p.machineInfo.CPUDetails.CPUsInNUMANodes(numaID).Difference(p.reservedCPUs)
+		numaCPUs := p.machineInfo.CPUDetails.CPUsInNUMANodes(numaID).Difference(p.getAllReservedCPUs())
 		numaAvailableCPUs := numaCPUs.Intersection(availableCPUs)
 		availableSize := numaAvailableCPUs.Size()
 
@@ -1141,6 +1261,8 @@ func (p *DynamicPolicy) generatePoolsAndIsolation(poolsQuantityMap map[string]ma
 		nonBindingAvailableCPUs = nonBindingAvailableCPUs.Union(p.machineInfo.CPUDetails.CPUsInNUMANodes(numaID).Intersection(availableCPUs))
 	}
 	availableCPUs = availableCPUs.Difference(nonBindingAvailableCPUs)
+	// pools without numa_binding are generated off cordoned NUMAs, and cpus in cordoned NUMAs are left unused
+	nonBindingAvailableCPUs = p.excludeCordonedCPUs(nonBindingAvailableCPUs)
 
 	nonBindingAvailableSize := nonBindingAvailableCPUs.Size()
 	nonBindingPoolsTotalQuantity := general.SumUpMapValues(nonBindingPoolsQuantityMap)
@@ -1197,10 +1319,10 @@ func (p *DynamicPolicy) generatePoolsAndIsolation(poolsQuantityMap map[string]ma
 	}
 
 	// deal with reclaim pool
-	poolsCPUSet[state.PoolNameReclaim] = poolsCPUSet[state.PoolNameReclaim].Union(availableCPUs)
+	poolsCPUSet[state.PoolNameReclaim] = poolsCPUSet[state.PoolNameReclaim].Union(p.excludeCordonedCPUs(availableCPUs))
 	if poolsCPUSet[state.PoolNameReclaim].IsEmpty() {
 		// for reclaimed pool, we must make them exist when the node isn't in hybrid mode even if cause overlap
-		allAvailableCPUs := p.machineInfo.CPUDetails.CPUs().Difference(p.reservedCPUs)
+		allAvailableCPUs := p.machineInfo.CPUDetails.CPUs().Difference(p.getAllReservedCPUs())
 		reclaimedCPUSet, _, tErr := calculator.TakeByNUMABalance(p.machineInfo, allAvailableCPUs, reservedReclaimedCPUsSize)
 		if tErr != nil {
 			err = fmt.Errorf("fallback takeByNUMABalance faild in generatePoolsAndIsolation for reclaimedCPUSet with error: %v", tErr)
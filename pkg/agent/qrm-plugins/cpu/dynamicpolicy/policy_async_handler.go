@@ -65,6 +65,11 @@ func (p *DynamicPolicy) checkCPUSet(_ *coreconfig.Configuration,
 		}
 	}()
 
+	if p.metaServer == nil || p.cpusetManager == nil {
+		err = fmt.Errorf("nil metaServer or cpusetManager")
+		return
+	}
+
 	podEntries := p.state.GetPodEntries()
 	actualCPUSets := make(map[string]map[string]machine.CPUSet)
 	for podUID, containerEntries := range podEntries {
@@ -87,8 +92,8 @@ func (p *DynamicPolicy) checkCPUSet(_ *coreconfig.Configuration,
 				"containerName": allocationInfo.ContainerName,
 			})
 			var (
-				containerId string
-				cpuSetStats *cgroupcm.CPUSetStats
+				containerId  string
+				actualCPUSet machine.CPUSet
 			)
 
 			containerId, err = p.metaServer.GetContainerID(podUID, containerName)
@@ -97,7 +102,7 @@ func (p *DynamicPolicy) checkCPUSet(_ *coreconfig.Configuration,
 				continue
 			}
 
-			cpuSetStats, err = cgroupcmutils.GetCPUSetForContainer(podUID, containerId)
+			actualCPUSet, err = p.cpusetManager.GetCPUSet(podUID, containerId)
 			if err != nil {
 				general.Errorf("GetCPUSet of pod: %s container: name(%s), id(%s) failed with error: %v",
 					podUID, containerName, containerId, err)
@@ -108,7 +113,7 @@ func (p *DynamicPolicy) checkCPUSet(_ *coreconfig.Configuration,
 			if actualCPUSets[podUID] == nil {
 				actualCPUSets[podUID] = make(map[string]machine.CPUSet)
 			}
-			actualCPUSets[podUID][containerName] = machine.MustParse(cpuSetStats.CPUs)
+			actualCPUSets[podUID][containerName] = actualCPUSet
 
 			general.Infof("pod: %s/%s, container: %s, state CPUSet: %s, actual CPUSet: %s",
 				allocationInfo.PodNamespace, allocationInfo.PodName, allocationInfo.ContainerName,
@@ -263,7 +268,7 @@ func (p *DynamicPolicy) clearResidualState(_ *coreconfig.Configuration,
 		}
 
 		p.state.SetPodEntries(podEntries)
-		p.state.SetMachineState(updatedMachineState)
+		p.setMachineState(updatedMachineState)
 
 		err = p.adjustAllocationEntries()
 		if err != nil {
@@ -296,3 +301,110 @@ func (p *DynamicPolicy) syncCPUIdle(_ *coreconfig.Configuration,
 			p.reclaimRelativeRootCgroupPath, p.enableCPUIdle, err)
 	}
 }
+
+// syncOfflineCPUs is used to load offline cpus from the file configured
+func (p *DynamicPolicy) syncOfflineCPUs(_ *coreconfig.Configuration,
+	_ interface{},
+	_ *dynamicconfig.DynamicAgentConfiguration,
+	_ metrics.MetricEmitter,
+	_ *metaserver.MetaServer,
+) {
+	general.Infof("exec syncOfflineCPUs")
+	var err error
+	defer func() {
+		_ = general.UpdateHealthzStateByError(cpuconsts.SyncOfflineCPUs, err)
+	}()
+
+	offlineCPUs, err := loadOfflineCPUs(p.offlineCPUsFileAbsPath, p.machineInfo.CPUDetails.CPUs())
+	if err != nil {
+		general.Errorf("loadOfflineCPUs from %s failed with error: %v", p.offlineCPUsFileAbsPath, err)
+		return
+	}
+
+	err = p.SetOfflineCPUs(offlineCPUs)
+	if err != nil {
+		general.Errorf("SetOfflineCPUs to %s failed with error: %v", offlineCPUs.String(), err)
+	}
+}
+
+// reconcileCPUSet detects containers whose actual cpuset drifts from the allocation
+// recorded in state, and re-applies the recorded cpuset if drift repairing is enabled
+func (p *DynamicPolicy) reconcileCPUSet(_ *coreconfig.Configuration,
+	_ interface{},
+	_ *dynamicconfig.DynamicAgentConfiguration,
+	_ metrics.MetricEmitter,
+	_ *metaserver.MetaServer,
+) {
+	general.Infof("exec reconcileCPUSet")
+	var (
+		err         error
+		driftExists = false
+	)
+
+	defer func() {
+		if err != nil {
+			_ = general.UpdateHealthzStateByError(cpuconsts.ReconcileCPUSet, err)
+		} else if driftExists && !p.enableCPUSetDriftRepair {
+			_ = general.UpdateHealthzState(cpuconsts.ReconcileCPUSet, general.HealthzCheckStateNotReady, "cpuset drift exists")
+		} else {
+			_ = general.UpdateHealthzState(cpuconsts.ReconcileCPUSet, general.HealthzCheckStateReady, "")
+		}
+	}()
+
+	if p.metaServer == nil || p.cpusetManager == nil {
+		err = fmt.Errorf("nil metaServer or cpusetManager")
+		return
+	}
+
+	podEntries := p.state.GetPodEntries()
+	for podUID, containerEntries := range podEntries {
+		if containerEntries.IsPoolEntry() {
+			continue
+		}
+
+		for containerName, allocationInfo := range containerEntries {
+			if allocationInfo == nil || !allocationInfo.CheckMainContainer() || allocationInfo.AllocationResult.IsEmpty() {
+				continue
+			} else if state.CheckShared(allocationInfo) && p.getContainerRequestedCores(allocationInfo) == 0 {
+				continue
+			}
+
+			containerID, getErr := p.metaServer.GetContainerID(podUID, containerName)
+			if getErr != nil {
+				general.Errorf("get container id of pod: %s container: %s failed with error: %v", podUID, containerName, getErr)
+				continue
+			}
+
+			actualCPUSet, getErr := p.cpusetManager.GetCPUSet(podUID, containerID)
+			if getErr != nil {
+				general.Errorf("get cpuset of pod: %s container: name(%s), id(%s) failed with error: %v",
+					podUID, containerName, containerID, getErr)
+				continue
+			} else if actualCPUSet.Equals(allocationInfo.AllocationResult) {
+				continue
+			}
+
+			driftExists = true
+			general.Warningf("pod: %s/%s, container: %s, cpuset drifts, recorded: %s, actual: %s, repair: %v",
+				allocationInfo.PodNamespace, allocationInfo.PodName, containerName,
+				allocationInfo.AllocationResult.String(), actualCPUSet.String(), p.enableCPUSetDriftRepair)
+			_ = p.emitter.StoreInt64(util.MetricNameCPUSetDrift, 1, metrics.MetricTypeNameRaw,
+				metrics.ConvertMapToTags(map[string]string{
+					"podNamespace":  allocationInfo.PodNamespace,
+					"podName":       allocationInfo.PodName,
+					"containerName": containerName,
+					"repair":        fmt.Sprintf("%v", p.enableCPUSetDriftRepair),
+				})...)
+
+			if !p.enableCPUSetDriftRepair {
+				continue
+			}
+
+			if applyErr := p.cpusetManager.ApplyCPUSet(podUID, containerID, allocationInfo.AllocationResult); applyErr != nil {
+				general.Errorf("re-apply cpuset: %s to pod: %s/%s, container: %s failed with error: %v",
+					allocationInfo.AllocationResult.String(), allocationInfo.PodNamespace, allocationInfo.PodName,
+					containerName, applyErr)
+			}
+		}
+	}
+}
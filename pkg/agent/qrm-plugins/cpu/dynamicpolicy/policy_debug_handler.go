@@ -0,0 +1,248 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+// allocationStateDebugPath is the path (under debug prefix of generic endpoint)
+// to export current allocation state of cpu plugin
+const allocationStateDebugPath = "/qrm/cpu/allocation_state"
+
+// hintsTraceDebugPath is the path (under debug prefix of generic endpoint) to run hints calculation
+// for the posted resource request (in json) and export the per-NUMA decision trace
+const hintsTraceDebugPath = "/qrm/cpu/hints_trace"
+
+// projectedHintsDebugPath is the path (under debug prefix of generic endpoint) to run hints calculation
+// for the posted resource request against the state projected by the posted operations (in json)
+const projectedHintsDebugPath = "/qrm/cpu/projected_hints"
+
+// numaExclusionDebugPath is the path (under debug prefix of generic endpoint) to explain why the NUMA
+// (by query parameter numaID) is excluded from hints of the container (by podUID and containerName)
+const numaExclusionDebugPath = "/qrm/cpu/numa_exclusion"
+
+// healthDebugPath is the path (under debug prefix of generic endpoint) to export
+// health status of cpu plugin, it responds with 503 if the policy is unhealthy
+const healthDebugPath = "/qrm/cpu/health"
+
+// numaMigrationsDebugPath is the path (under debug prefix of generic endpoint) to export
+// how many times each numa_binding pod has been moved to different NUMAs
+const numaMigrationsDebugPath = "/qrm/cpu/numa_migrations"
+
+// topologyDebugPath is the path (under debug prefix of generic endpoint) to export cpu topology
+// of the machine along with reserved and offline cpus, e.g. for scheduler extenders
+const topologyDebugPath = "/qrm/cpu/topology"
+
+// allocationStateSnapshot is the json format of allocation state exported for debugging
+type allocationStateSnapshot struct {
+	PodEntries   state.PodEntries  `json:"podEntries"`
+	MachineState state.NUMANodeMap `json:"machineState"`
+	ReservedCPUs machine.CPUSet    `json:"reservedCPUs"`
+	OfflineCPUs  machine.CPUSet    `json:"offlineCPUs"`
+	// CPUSetBreakdowns explains how cpus are accounted in each NUMA
+	CPUSetBreakdowns map[int]*state.CPUSetBreakdown `json:"cpuSetBreakdowns"`
+}
+
+// projectedHintsRequest is the json format of request posted to projectedHintsDebugPath
+type projectedHintsRequest struct {
+	Request    *pluginapi.ResourceRequest `json:"request"`
+	Operations []*ProjectedOperation      `json:"operations"`
+}
+
+// getAllocationStateSnapshot returns a consistent copy of current allocation state
+func (p *DynamicPolicy) getAllocationStateSnapshot() *allocationStateSnapshot {
+	p.RLock()
+	defer p.RUnlock()
+
+	machineState := p.state.GetMachineState()
+	return &allocationStateSnapshot{
+		PodEntries:       p.state.GetPodEntries(),
+		MachineState:     machineState,
+		ReservedCPUs:     p.reservedCPUs.Clone(),
+		OfflineCPUs:      p.offlineCPUs.Clone(),
+		CPUSetBreakdowns: p.getCPUSetBreakdowns(machineState),
+	}
+}
+
+// getCPUSetBreakdowns returns how cpus are accounted in each NUMA of the given machine state,
+// cpus of qos reserved pools are counted as reserved as well.
+func (p *DynamicPolicy) getCPUSetBreakdowns(machineState state.NUMANodeMap) map[int]*state.CPUSetBreakdown {
+	return machineState.GetCPUSetBreakdowns(p.machineInfo.CPUDetails, p.getAllReservedCPUs(), p.offlineCPUs)
+}
+
+// serveAllocationState writes current allocation state as json
+func (p *DynamicPolicy) serveAllocationState(w http.ResponseWriter, _ *http.Request) {
+	data, err := json.Marshal(p.getAllocationStateSnapshot())
+	if err != nil {
+		general.Errorf("marshal allocation state failed with error: %v", err)
+		http.Error(w, fmt.Sprintf("marshal allocation state failed with error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// serveHintsTrace calculates hints for the resource request in body and writes the trace as json
+func (p *DynamicPolicy) serveHintsTrace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("method: %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := &pluginapi.ResourceRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, fmt.Sprintf("decode resource request failed with error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	trace, err := p.GetTopologyHintsTrace(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := json.Marshal(trace)
+	if err != nil {
+		general.Errorf("marshal hints trace failed with error: %v", err)
+		http.Error(w, fmt.Sprintf("marshal hints trace failed with error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// serveProjectedHints calculates hints for the resource request against the projected state
+// described in body, and writes the hints as json
+func (p *DynamicPolicy) serveProjectedHints(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("method: %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectedReq := &projectedHintsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(projectedReq); err != nil {
+		http.Error(w, fmt.Sprintf("decode projected hints request failed with error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	hints, err := p.GetProjectedTopologyHints(projectedReq.Request, projectedReq.Operations)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := json.Marshal(hints)
+	if err != nil {
+		general.Errorf("marshal projected hints failed with error: %v", err)
+		http.Error(w, fmt.Sprintf("marshal projected hints failed with error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// serveNUMAExclusion writes the reason why the NUMA is excluded from hints of the container as plain text
+func (p *DynamicPolicy) serveNUMAExclusion(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	numaID, err := strconv.Atoi(query.Get("numaID"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("parse numaID failed with error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	reason, err := p.ExplainNUMAExclusion(query.Get("podUID"), query.Get("containerName"), numaID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(reason))
+}
+
+// serveHealth writes health status of the policy as json
+func (p *DynamicPolicy) serveHealth(w http.ResponseWriter, _ *http.Request) {
+	status := p.GetHealthStatus()
+	data, err := json.Marshal(status)
+	if err != nil {
+		general.Errorf("marshal health status failed with error: %v", err)
+		http.Error(w, fmt.Sprintf("marshal health status failed with error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if status.Healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write(data)
+}
+
+// serveNUMAMigrations writes NUMA migration records keyed by pod uid as json
+func (p *DynamicPolicy) serveNUMAMigrations(w http.ResponseWriter, _ *http.Request) {
+	data, err := json.Marshal(p.getNUMAMigrations())
+	if err != nil {
+		general.Errorf("marshal NUMA migrations failed with error: %v", err)
+		http.Error(w, fmt.Sprintf("marshal NUMA migrations failed with error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// GetTopologySnapshot returns the cpu topology of the machine along with reserved and offline cpus,
+// cpus of qos reserved pools are counted as reserved as well.
+func (p *DynamicPolicy) GetTopologySnapshot() *machine.TopologySnapshot {
+	p.RLock()
+	defer p.RUnlock()
+
+	return machine.NewTopologySnapshot(p.machineInfo.CPUTopology, p.getAllReservedCPUs(), p.offlineCPUs)
+}
+
+// serveTopology writes the topology snapshot as json
+func (p *DynamicPolicy) serveTopology(w http.ResponseWriter, _ *http.Request) {
+	data, err := json.Marshal(p.GetTopologySnapshot())
+	if err != nil {
+		general.Errorf("marshal topology snapshot failed with error: %v", err)
+		http.Error(w, fmt.Sprintf("marshal topology snapshot failed with error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+)
+
+// PolicyHealthStatus reports whether the dynamic policy is functional, it's unhealthy if
+// checkpoint can't be written, machine state can't be regenerated from pod entries,
+// or machine state is inconsistent with pod entries.
+type PolicyHealthStatus struct {
+	Healthy bool `json:"healthy"`
+	// LastSuccessfulAdmissionTime is zero if no container has been admitted since the policy started
+	LastSuccessfulAdmissionTime time.Time `json:"lastSuccessfulAdmissionTime"`
+	// CheckpointWriteStatus is nil if the state isn't backed by checkpoint
+	CheckpointWriteStatus  *state.CheckpointWriteStatus `json:"checkpointWriteStatus,omitempty"`
+	MachineStateConsistent bool                         `json:"machineStateConsistent"`
+	// Reasons explains why the policy is unhealthy
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// GetHealthStatus checks the health of the dynamic policy
+func (p *DynamicPolicy) GetHealthStatus() *PolicyHealthStatus {
+	p.RLock()
+	defer p.RUnlock()
+
+	status := &PolicyHealthStatus{}
+	if nano := p.lastSuccessfulAdmissionTimeNano.Load(); nano != 0 {
+		status.LastSuccessfulAdmissionTime = time.Unix(0, nano)
+	}
+
+	if getter, ok := p.state.(state.CheckpointWriteStatusGetter); ok {
+		writeStatus := getter.GetCheckpointWriteStatus()
+		status.CheckpointWriteStatus = &writeStatus
+		if writeStatus.LastWriteError != "" {
+			status.Reasons = append(status.Reasons,
+				fmt.Sprintf("the latest checkpoint write failed with error: %s", writeStatus.LastWriteError))
+		}
+	}
+
+	generatedMachineState, err := p.machineStateGenerator(p.machineInfo.CPUTopology, p.state.GetPodEntries())
+	if err != nil {
+		status.Reasons = append(status.Reasons,
+			fmt.Sprintf("generate machine state from pod entries failed with error: %v", err))
+	} else if err = checkMachineStateConsistency(generatedMachineState, p.state.GetMachineState()); err != nil {
+		status.Reasons = append(status.Reasons,
+			fmt.Sprintf("machine state is inconsistent with pod entries: %v", err))
+	} else {
+		status.MachineStateConsistent = true
+	}
+
+	status.Healthy = len(status.Reasons) == 0
+	return status
+}
+
+// checkMachineStateConsistency compares the machine state in use with the one generated from pod entries,
+// cpusets of NUMA nodes and allocation results of containers in each NUMA node are compared.
+func checkMachineStateConsistency(expected, actual state.NUMANodeMap) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("NUMA count: %d mismatches with expected: %d", len(actual), len(expected))
+	}
+
+	for numaID, expectedNUMAState := range expected {
+		actualNUMAState := actual[numaID]
+		if expectedNUMAState == nil || actualNUMAState == nil {
+			if expectedNUMAState != actualNUMAState {
+				return fmt.Errorf("NUMA: %d state mismatches with expected", numaID)
+			}
+			continue
+		}
+
+		if !actualNUMAState.AllocatedCPUSet.Equals(expectedNUMAState.AllocatedCPUSet) {
+			return fmt.Errorf("NUMA: %d allocated cpuset: %s mismatches with expected: %s",
+				numaID, actualNUMAState.AllocatedCPUSet.String(), expectedNUMAState.AllocatedCPUSet.String())
+		} else if !actualNUMAState.DefaultCPUSet.Equals(expectedNUMAState.DefaultCPUSet) {
+			return fmt.Errorf("NUMA: %d default cpuset: %s mismatches with expected: %s",
+				numaID, actualNUMAState.DefaultCPUSet.String(), expectedNUMAState.DefaultCPUSet.String())
+		}
+
+		for podUID, containerEntries := range expectedNUMAState.PodEntries {
+			for containerName, expectedAllocationInfo := range containerEntries {
+				actualAllocationInfo := actualNUMAState.PodEntries[podUID][containerName]
+				if actualAllocationInfo == nil {
+					return fmt.Errorf("NUMA: %d misses pod: %s, container: %s", numaID, podUID, containerName)
+				} else if expectedAllocationInfo != nil &&
+					!actualAllocationInfo.AllocationResult.Equals(expectedAllocationInfo.AllocationResult) {
+					return fmt.Errorf("NUMA: %d pod: %s, container: %s allocation result: %s mismatches with expected: %s",
+						numaID, podUID, containerName, actualAllocationInfo.AllocationResult.String(),
+						expectedAllocationInfo.AllocationResult.String())
+				}
+			}
+		}
+
+		for podUID, containerEntries := range actualNUMAState.PodEntries {
+			for containerName := range containerEntries {
+				if _, ok := expectedNUMAState.PodEntries[podUID][containerName]; !ok {
+					return fmt.Errorf("NUMA: %d has unexpected pod: %s, container: %s", numaID, podUID, containerName)
+				}
+			}
+		}
+	}
+	return nil
+}
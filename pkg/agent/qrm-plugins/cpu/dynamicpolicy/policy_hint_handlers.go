@@ -21,18 +21,22 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"strconv"
 
 	v1 "k8s.io/api/core/v1"
 	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
-	"k8s.io/kubernetes/pkg/kubelet/cm/topologymanager/bitmask"
 
 	apiconsts "github.com/kubewharf/katalyst-api/pkg/consts"
 	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
 	cpuutil "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/util"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	coreconsts "github.com/kubewharf/katalyst-core/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/bitmask"
 	"github.com/kubewharf/katalyst-core/pkg/util/general"
 	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+	"github.com/kubewharf/katalyst-core/pkg/util/native"
 	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
 )
 
@@ -56,7 +60,103 @@ func (p *DynamicPolicy) sharedCoresHintHandler(ctx context.Context,
 func (p *DynamicPolicy) reclaimedCoresHintHandler(ctx context.Context,
 	req *pluginapi.ResourceRequest,
 ) (*pluginapi.ResourceHintsResponse, error) {
-	return p.sharedCoresHintHandler(ctx, req)
+	if req == nil {
+		return nil, fmt.Errorf("got nil request")
+	}
+
+	if !p.isReclaimedNUMAAccountingEnabled() ||
+		req.ContainerType == pluginapi.ContainerType_SIDECAR ||
+		qosutil.AnnotationsIndicateNUMABinding(req.Annotations) {
+		return p.sharedCoresHintHandler(ctx, req)
+	}
+
+	_, reqFloat64, err := util.GetQuantityFromResourceReq(req)
+	if err != nil {
+		return nil, fmt.Errorf("getReqQuantityFromResourceReq failed with error: %v", err)
+	}
+
+	hintState := p.getHintState(ctx)
+	// container already admitted to a NUMA keeps its hint
+	allocationInfo := hintState.GetAllocationInfo(req.PodUid, req.ContainerName)
+	if numaHint, ok := allocationInfo.GetReclaimedNUMAHint(); ok {
+		return util.PackResourceHintsResponse(req, string(v1.ResourceCPU),
+			map[string]*pluginapi.ListOfTopologyHints{
+				string(v1.ResourceCPU): {
+					Hints: []*pluginapi.TopologyHint{
+						{
+							Nodes:     []uint64{uint64(numaHint)},
+							Preferred: true,
+						},
+					},
+				},
+			})
+	}
+
+	hints, err := p.calculateHintsForReclaimedCores(ctx, reqFloat64, hintState.GetMachineState(), hintState.GetPodEntries())
+	if err != nil {
+		return nil, fmt.Errorf("calculateHintsForReclaimedCores failed with error: %v", err)
+	}
+
+	return util.PackResourceHintsResponse(req, string(v1.ResourceCPU), hints)
+}
+
+// isReclaimedNUMAAccountingEnabled returns true if reclaimed_cores containers are admitted to a single NUMA
+// and accounted per NUMA, i.e. either the overcommit ratio or the per-NUMA quota is set.
+func (p *DynamicPolicy) isReclaimedNUMAAccountingEnabled() bool {
+	return p.reclaimedNUMAOvercommitRatio > 0 || len(p.reclaimedNUMACPUQuota) > 0
+}
+
+// calculateHintsForReclaimedCores generates single-NUMA hints for reclaimed_cores container,
+// only NUMAs with effective available quantity (allocatable * ratio - reclaimedAllocated)
+// fitting the request are candidates, to avoid overselling a single NUMA; NUMAs whose
+// reclaimed allocated quantity would exceed their quota are excluded as well.
+func (p *DynamicPolicy) calculateHintsForReclaimedCores(ctx context.Context, reqFloat64 float64,
+	machineState state.NUMANodeMap, podEntries state.PodEntries,
+) (map[string]*pluginapi.ListOfTopologyHints, error) {
+	logger := general.LoggerFromContext(ctx)
+	numaNodes := make([]int, 0, len(machineState))
+	for numaNode := range machineState {
+		numaNodes = append(numaNodes, numaNode)
+	}
+	sort.Ints(numaNodes)
+	numaNodes = p.filterCordonedNUMANodes(numaNodes)
+
+	unavailableCPUs := p.getUnavailableCPUs()
+	reclaimedAllocatedQuantity := podEntries.GetReclaimedAllocatedQuantity()
+	hints := []*pluginapi.TopologyHint{}
+	for _, numaNode := range numaNodes {
+		if p.reclaimedNUMAOvercommitRatio > 0 {
+			available := machineState[numaNode].GetReclaimedAvailableQuantity(unavailableCPUs,
+				p.reclaimedNUMAOvercommitRatio, reclaimedAllocatedQuantity[numaNode]) - float64(p.numaMinFreeCPUs)
+			if available < reqFloat64 {
+				logger.InfofV(4, "NUMA: %d reclaimed available quantity: %.2f is smaller than request: %.2f",
+					numaNode, available, reqFloat64)
+				continue
+			}
+		}
+
+		if quota, ok := p.reclaimedNUMACPUQuota[numaNode]; ok && reclaimedAllocatedQuantity[numaNode]+reqFloat64 > quota {
+			logger.InfofV(4, "NUMA: %d reclaimed allocated quantity: %.2f plus request: %.2f exceeds quota: %.2f",
+				numaNode, reclaimedAllocatedQuantity[numaNode], reqFloat64, quota)
+			continue
+		}
+
+		hints = append(hints, &pluginapi.TopologyHint{
+			Nodes:     []uint64{uint64(numaNode)},
+			Preferred: true,
+		})
+	}
+
+	if len(hints) == 0 {
+		return nil, fmt.Errorf("no NUMA has enough reclaimed quantity for request: %.2f with overcommit ratio: %.2f and quota: %v",
+			reqFloat64, p.reclaimedNUMAOvercommitRatio, p.reclaimedNUMACPUQuota)
+	}
+
+	return map[string]*pluginapi.ListOfTopologyHints{
+		string(v1.ResourceCPU): {
+			Hints: hints,
+		},
+	}, nil
 }
 
 func (p *DynamicPolicy) dedicatedCoresHintHandler(ctx context.Context,
@@ -74,9 +174,10 @@ func (p *DynamicPolicy) dedicatedCoresHintHandler(ctx context.Context,
 	}
 }
 
-func (p *DynamicPolicy) dedicatedCoresWithNUMABindingHintHandler(_ context.Context,
+func (p *DynamicPolicy) dedicatedCoresWithNUMABindingHintHandler(ctx context.Context,
 	req *pluginapi.ResourceRequest,
 ) (*pluginapi.ResourceHintsResponse, error) {
+	logger := general.LoggerFromContext(ctx)
 	// currently, we set cpuset of sidecar to the cpuset of its main container,
 	// so there is no numa preference here.
 	if req.ContainerType == pluginapi.ContainerType_SIDECAR {
@@ -91,39 +192,49 @@ func (p *DynamicPolicy) dedicatedCoresWithNUMABindingHintHandler(_ context.Conte
 		return nil, fmt.Errorf("getReqQuantityFromResourceReq failed with error: %v", err)
 	}
 
-	machineState := p.state.GetMachineState()
+	hintState := p.getHintState(ctx)
+	machineState := hintState.GetMachineState()
 	var hints map[string]*pluginapi.ListOfTopologyHints
 
-	allocationInfo := p.state.GetAllocationInfo(req.PodUid, req.ContainerName)
+	allocationInfo := hintState.GetAllocationInfo(req.PodUid, req.ContainerName)
 	if allocationInfo != nil {
-		hints = cpuutil.RegenerateHints(allocationInfo, reqInt)
+		if offlineCPUs := allocationInfo.AllocationResult.Intersection(p.offlineCPUs); !offlineCPUs.IsEmpty() {
+			// container allocated with offline cpus should be re-calculated
+			logger.Warningf("pod: %s/%s, container: %s allocated with offline cpus: %s, re-calculate hints",
+				req.PodNamespace, req.PodName, req.ContainerName, offlineCPUs.String())
+		} else {
+			hints = cpuutil.RegenerateHints(allocationInfo, reqInt)
+		}
 
 		// regenerateHints failed. need to clear container record and re-calculate.
 		if hints == nil {
-			podEntries := p.state.GetPodEntries()
-			delete(podEntries[req.PodUid], req.ContainerName)
-			if len(podEntries[req.PodUid]) == 0 {
-				delete(podEntries, req.PodUid)
-			}
-
 			var err error
-			machineState, err = generateMachineStateFromPodEntries(p.machineInfo.CPUTopology, podEntries)
+			machineState, err = p.clearContainerAndRegenerateMachineState(hintState.GetPodEntries(), allocationInfo, req)
 			if err != nil {
-				general.Errorf("pod: %s/%s, container: %s GenerateMachineStateFromPodEntries failed with error: %v",
+				logger.Errorf("pod: %s/%s, container: %s GenerateMachineStateFromPodEntries failed with error: %v",
 					req.PodNamespace, req.PodName, req.ContainerName, err)
 				return nil, fmt.Errorf("GenerateMachineStateFromPodEntries failed with error: %v", err)
 			}
 		}
 	}
 
-	// if hints exists in extra state-file, prefer to use them
-	if hints == nil {
-		availableNUMAs := machineState.GetFilteredNUMASet(state.CheckNUMABinding)
+	// if hints exists in extra state-file, prefer to use them,
+	// but they don't reflect projected state, so they're ignored when calculating projected hints.
+	_, projected := getProjectedHintState(ctx)
+	if hints == nil && !projected {
+		availableNUMAs := machineState.GetFilteredNUMASet(state.CheckNUMABinding).Difference(p.cordonedNUMAs).
+			Difference(getNamespaceExcludedNUMAsFromContext(ctx))
 
 		var extraErr error
-		hints, extraErr = util.GetHintsFromExtraStateFile(req.PodName, string(v1.ResourceCPU), p.extraStateFileAbsPath, availableNUMAs)
-		if extraErr != nil {
-			general.Infof("pod: %s/%s, container: %s GetHintsFromExtraStateFile failed with error: %v",
+		hints, extraErr = util.GetHintsFromExtraStateFilesWithTimeout(p.extraStateFileReader, p.extraStateFileReadTimeout,
+			p.emitter, req.PodName, string(v1.ResourceCPU), p.extraStateFileAbsPaths, availableNUMAs)
+		if extraErr == util.ErrExtraStateFileReadTimeout {
+			logger.Warningf("pod: %s/%s, container: %s read extra state file timeout, fallback to calculate hints",
+				req.PodNamespace, req.PodName, req.ContainerName)
+			_ = p.emitter.StoreInt64(util.MetricNameExtraStateFileReadTimeout, 1, metrics.MetricTypeNameCount,
+				metrics.MetricTag{Key: "resourceName", Val: string(v1.ResourceCPU)})
+		} else if extraErr != nil {
+			logger.Infof("pod: %s/%s, container: %s GetHintsFromExtraStateFile failed with error: %v",
 				req.PodNamespace, req.PodName, req.ContainerName, extraErr)
 		}
 	}
@@ -131,11 +242,51 @@ func (p *DynamicPolicy) dedicatedCoresWithNUMABindingHintHandler(_ context.Conte
 	// otherwise, calculate hint for container without allocated memory
 	if hints == nil {
 		var calculateErr error
+		var reclaimedOccupancy reclaimedNUMAOccupancy
+		if p.isReclaimedNUMAEvictionEnabled() {
+			reclaimedOccupancy = getReclaimedNUMAOccupancy(hintState.GetPodEntries())
+		}
+
 		// calculate hint for container without allocated cpus
-		hints, calculateErr = p.calculateHints(reqInt, machineState, req.Annotations)
+		hints, calculateErr = p.calculateHintsWithReclaimedOccupancy(ctx, reqInt, machineState, req.Annotations,
+			p.getNUMAConstraint(ctx, req.Hint, req.Annotations), reclaimedOccupancy)
 		if calculateErr != nil {
 			return nil, fmt.Errorf("calculateHints failed with error: %v", calculateErr)
 		}
+
+		if len(hints[string(v1.ResourceCPU)].Hints) == 0 && p.isReclaimedNUMAEvictionEnabled() {
+			hints, calculateErr = p.recalculateHintsByFreeingReclaimedNUMA(ctx, req, reqInt, machineState,
+				reclaimedOccupancy, hints)
+			if calculateErr != nil {
+				return nil, fmt.Errorf("recalculateHintsByFreeingReclaimedNUMA failed with error: %v", calculateErr)
+			}
+		}
+
+		if len(hints[string(v1.ResourceCPU)].Hints) == 0 && p.isSharedDisplacementEnabled() {
+			hints, calculateErr = p.recalculateHintsByDisplacingSharedPods(ctx, req, reqInt, hintState.GetPodEntries(),
+				machineState, reclaimedOccupancy, hints)
+			if calculateErr != nil {
+				return nil, fmt.Errorf("recalculateHintsByDisplacingSharedPods failed with error: %v", calculateErr)
+			}
+		}
+
+		if len(hints[string(v1.ResourceCPU)].Hints) == 0 {
+			logger.Warningf("pod: %s/%s, container: %s no NUMA fits request: %d, cpus breakdown: %v",
+				req.PodNamespace, req.PodName, req.ContainerName, reqInt, p.getCPUSetBreakdowns(machineState))
+		}
+
+		if len(hints[string(v1.ResourceCPU)].Hints) == 0 && p.isDedicatedFullPhysicalCPUsOnly(req.Annotations) {
+			alignedReqInt, _ := p.alignRequestToFullCores(reqInt, req.Annotations)
+			return nil, fmt.Errorf("no NUMA has enough free full physical cores for request: %d aligned to: %d",
+				reqInt, alignedReqInt)
+		}
+
+		p.preferNamespaceReservedNUMAs(ctx, req, hints)
+		p.spreadHintsByReplicas(ctx, req, hintState.GetPodEntries(), hints)
+		p.preferPreviousNUMAs(ctx, req, hints)
+		p.preferMemoryAllocatedNUMAs(ctx, req, hints)
+		p.demoteHintsByMemoryAvailability(ctx, req, hints)
+		p.demoteHintsByThrottledNUMAs(ctx, req, hints)
 	}
 
 	return util.PackResourceHintsResponse(req, string(v1.ResourceCPU), hints)
@@ -151,13 +302,42 @@ func (p *DynamicPolicy) dedicatedCoresWithoutNUMABindingHintHandler(_ context.Co
 // calculateHints is a helper function to calculate the topology hints
 // with the given container requests.
 func (p *DynamicPolicy) calculateHints(reqInt int, machineState state.NUMANodeMap,
-	reqAnnotations map[string]string,
+	reqAnnotations map[string]string, numaConstraint machine.CPUSet,
 ) (map[string]*pluginapi.ListOfTopologyHints, error) {
+	var reclaimedOccupancy reclaimedNUMAOccupancy
+	if p.isReclaimedNUMAEvictionEnabled() {
+		reclaimedOccupancy = getReclaimedNUMAOccupancy(p.state.GetPodEntries())
+	}
+	return p.calculateHintsWithReclaimedOccupancy(context.Background(), reqInt, machineState, reqAnnotations,
+		numaConstraint, reclaimedOccupancy)
+}
+
+// calculateHintsWithReclaimedOccupancy is the same as calculateHints, except that NUMAs are also skipped
+// if reclaimed_cores pods admitted to them would exceed the overcommit bound after the request is allocated;
+// it takes no effect for nil reclaimedOccupancy.
+func (p *DynamicPolicy) calculateHintsWithReclaimedOccupancy(ctx context.Context, reqInt int, machineState state.NUMANodeMap,
+	reqAnnotations map[string]string, numaConstraint machine.CPUSet, reclaimedOccupancy reclaimedNUMAOccupancy,
+) (map[string]*pluginapi.ListOfTopologyHints, error) {
+	logger := general.LoggerFromContext(ctx)
+	// some virtualized nodes report no NUMA at all, treat the whole machine as a single pseudo-NUMA
+	if len(machineState) == 0 {
+		return p.calculateHintsForZeroNUMA(ctx, reqInt)
+	}
+
 	numaNodes := make([]int, 0, len(machineState))
 	for numaNode := range machineState {
 		numaNodes = append(numaNodes, numaNode)
 	}
 	sort.Ints(numaNodes)
+	numaNodes = filterNUMANodesByConstraint(ctx, numaNodes, numaConstraint)
+	numaNodes = p.filterCordonedNUMANodes(numaNodes)
+	numaNodes = filterNamespaceExcludedNUMANodes(ctx, numaNodes)
+
+	reqInt, fullCores := p.alignRequestToFullCores(reqInt, reqAnnotations)
+	coreClassCPUs, targetCoreClass, err := p.getCoreClassCPUs(reqAnnotations)
+	if err != nil {
+		return nil, err
+	}
 
 	hints := map[string]*pluginapi.ListOfTopologyHints{
 		string(v1.ResourceCPU): {
@@ -165,9 +345,10 @@ func (p *DynamicPolicy) calculateHints(reqInt int, machineState state.NUMANodeMa
 		},
 	}
 
-	minNUMAsCountNeeded, _, err := util.GetNUMANodesCountToFitCPUReq(reqInt, p.machineInfo.CPUTopology)
+	minNUMAsCountNeeded, _, err := util.GetNUMANodesCountToFitCPUReqWithCapacity(reqInt, p.machineInfo.CPUTopology,
+		p.getNUMAAllocatableCPUQuantity)
 	if err != nil {
-		return nil, fmt.Errorf("GetNUMANodesCountToFitCPUReq failed with error: %v", err)
+		return nil, fmt.Errorf("GetNUMANodesCountToFitCPUReqWithCapacity failed with error: %v", err)
 	}
 
 	// because it's hard to control memory allocation accurately,
@@ -178,15 +359,42 @@ func (p *DynamicPolicy) calculateHints(reqInt int, machineState state.NUMANodeMa
 		return nil, fmt.Errorf("NUMA not exclusive binding container has request larger than 1 NUMA")
 	}
 
-	numaPerSocket, err := p.machineInfo.NUMAsPerSocket()
-	if err != nil {
-		return nil, fmt.Errorf("NUMAsPerSocket failed with error: %v", err)
+	if p.maxNUMAsPerAllocation > 0 && minNUMAsCountNeeded > p.maxNUMAsPerAllocation {
+		return nil, fmt.Errorf("request: %d needs at least %d NUMAs, exceeding maxNUMAsPerAllocation: %d",
+			reqInt, minNUMAsCountNeeded, p.maxNUMAsPerAllocation)
 	}
 
-	bitmask.IterateBitMasks(numaNodes, func(mask bitmask.BitMask) {
-		maskCount := mask.Count()
+	// NUMAs can't cross sockets on single-NUMA machines, so sockets aren't checked at all
+	singleNUMA := p.singleNUMAFastPath
+	numaPerSocket := 1
+	if !singleNUMA {
+		numaPerSocket, err = p.machineInfo.NUMAsPerSocket()
+		if err != nil {
+			return nil, fmt.Errorf("NUMAsPerSocket failed with error: %v", err)
+		}
+	}
+
+	unavailableCPUs := p.getUnavailableCPUs()
+	coolingDownCPUs := p.getCoolingDownCPUs()
+	cpusPerCore := p.machineInfo.CPUsPerCore()
+
+	// buffers and closures below are shared by all masks to avoid allocations in the loop,
+	// maskBits must be copied if it's referenced after the mask is handled
+	maskBits := make([]int, 0, len(numaNodes))
+	appendMaskBit := func(bit int) bool {
+		maskBits = append(maskBits, bit)
+		return true
+	}
+	var allAvailableCPUsInMask machine.CPUSet
+	addAvailableCPU := func(cpu int) {
+		allAvailableCPUsInMask.Add(cpu)
+	}
+	addHintIfFits := func() {
+		maskCount := len(maskBits)
 		if maskCount < minNUMAsCountNeeded {
 			return
+		} else if p.maxNUMAsPerAllocation > 0 && maskCount > p.maxNUMAsPerAllocation {
+			return
 		} else if qosutil.AnnotationsIndicateNUMABinding(reqAnnotations) &&
 			!qosutil.AnnotationsIndicateNUMAExclusive(reqAnnotations) &&
 			maskCount > 1 {
@@ -195,51 +403,173 @@ func (p *DynamicPolicy) calculateHints(reqInt int, machineState state.NUMANodeMa
 			return
 		}
 
-		maskBits := mask.GetBits()
-		numaCountNeeded := mask.Count()
+		numaCountNeeded := maskCount
 
-		allAvailableCPUsInMask := machine.NewCPUSet()
+		allAvailableCPUsInMask = machine.NewCPUSet()
 		for _, nodeID := range maskBits {
 			if machineState[nodeID] == nil {
-				general.Warningf("NUMA: %d has nil state", nodeID)
+				logger.Warningf("NUMA: %d has nil state", nodeID)
 				return
-			} else if qosutil.AnnotationsIndicateNUMAExclusive(reqAnnotations) && machineState[nodeID].AllocatedCPUSet.Size() > 0 {
-				general.Warningf("numa_exclusive container skip mask: %s with NUMA: %d allocated: %d",
-					mask.String(), nodeID, machineState[nodeID].AllocatedCPUSet.Size())
+			} else if isNodeExclusive(reqAnnotations) && machineState[nodeID].AllocatedCPUSet.Size() > 0 {
+				logger.Warningf("numa_exclusive container skip NUMAs: %v with NUMA: %d allocated: %d",
+					maskBits, nodeID, machineState[nodeID].AllocatedCPUSet.Size())
 				return
 			}
 
-			allAvailableCPUsInMask = allAvailableCPUsInMask.Union(machineState[nodeID].GetAvailableCPUSet(p.reservedCPUs))
+			machineState[nodeID].GetAvailableCPUSet(unavailableCPUs).ForEach(addAvailableCPU)
+		}
+
+		if targetCoreClass {
+			allAvailableCPUsInMask = allAvailableCPUsInMask.Intersection(coreClassCPUs)
 		}
 
 		if allAvailableCPUsInMask.Size() < reqInt {
-			general.InfofV(4, "available cpuset: %s of size: %d excluding NUMA binding pods which is smaller than request: %d",
+			logger.InfofV(4, "available cpuset: %s of size: %d excluding NUMA binding pods which is smaller than request: %d",
 				allAvailableCPUsInMask.String(), allAvailableCPUsInMask.Size(), reqInt)
 			return
 		}
 
-		crossSockets, err := machine.CheckNUMACrossSockets(maskBits, p.machineInfo.CPUTopology)
-		if err != nil {
-			general.Errorf("CheckNUMACrossSockets failed with error: %v", err)
+		// cpus kept free in each NUMA are a floating reserve, any of the available cpus can be kept
+		minFreeCPUsInMask := p.numaMinFreeCPUs * len(maskBits)
+		if allAvailableCPUsInMask.Size()-minFreeCPUsInMask < reqInt {
+			logger.InfofV(4, "available cpus: %d in NUMAs: %v can't fit request: %d with %d cpus kept free",
+				allAvailableCPUsInMask.Size(), maskBits, reqInt, minFreeCPUsInMask)
 			return
-		} else if numaCountNeeded <= numaPerSocket && crossSockets {
-			general.InfofV(4, "needed: %d; min-needed: %d; NUMAs: %v cross sockets with numaPerSocket: %d",
-				numaCountNeeded, minNUMAsCountNeeded, maskBits, numaPerSocket)
+		}
+
+		if reclaimedOccupancy != nil &&
+			!p.fitReclaimedNUMAOccupancy(allAvailableCPUsInMask.Size(), reqInt, reclaimedOccupancy.getQuantity(maskBits)) {
+			logger.InfofV(4, "reclaimed_cores pods admitted to NUMAs: %v can't fit after request: %d is allocated",
+				maskBits, reqInt)
 			return
 		}
 
+		if fullCores {
+			fullCoresInMask := p.machineInfo.CPUDetails.FullCoresInCPUs(allAvailableCPUsInMask).Size()
+			if fullCoresInMask*cpusPerCore < reqInt {
+				logger.InfofV(4, "available full cores: %d in NUMAs: %v can't fit request: %d with %d cpus per core",
+					fullCoresInMask, maskBits, reqInt, cpusPerCore)
+				return
+			}
+		}
+
+		if !singleNUMA {
+			crossSockets, err := machine.CheckNUMACrossSockets(maskBits, p.machineInfo.CPUTopology)
+			if err != nil {
+				logger.Errorf("CheckNUMACrossSockets failed with error: %v", err)
+				return
+			} else if numaCountNeeded <= numaPerSocket && crossSockets {
+				logger.InfofV(4, "needed: %d; min-needed: %d; NUMAs: %v cross sockets with numaPerSocket: %d",
+					numaCountNeeded, minNUMAsCountNeeded, maskBits, numaPerSocket)
+				return
+			}
+		}
+
+		// NUMAs that can only fit the request with cpus in cool-down are still candidates, but not preferred
+		preferred := len(maskBits) == minNUMAsCountNeeded
+		if preferred && allAvailableCPUsInMask.DifferenceSize(coolingDownCPUs)-minFreeCPUsInMask < reqInt {
+			logger.InfofV(4, "available cpus: %s in NUMAs: %v fit request: %d only with cpus in cool-down: %s",
+				allAvailableCPUsInMask.String(), maskBits, reqInt, coolingDownCPUs.String())
+			preferred = false
+		}
+
+		nodes := make([]uint64, 0, len(maskBits))
+		for _, nodeID := range maskBits {
+			nodes = append(nodes, uint64(nodeID))
+		}
 		hints[string(v1.ResourceCPU)].Hints = append(hints[string(v1.ResourceCPU)].Hints, &pluginapi.TopologyHint{
-			Nodes:     machine.MaskToUInt64Array(mask),
-			Preferred: len(maskBits) == minNUMAsCountNeeded,
+			Nodes:     nodes,
+			Preferred: preferred,
 		})
-	})
+	}
+
+	if singleNUMA {
+		// the only mask on single-NUMA machines is checked directly without iterating bit masks
+		if len(numaNodes) == 1 {
+			maskBits = append(maskBits, numaNodes[0])
+			addHintIfFits()
+		}
+	} else {
+		bitmask.IterateBitMasks(numaNodes, func(mask bitmask.BitMask) {
+			maskBits = maskBits[:0]
+			mask.ForEachBit(appendMaskBit)
+			addHintIfFits()
+		})
+	}
+
+	ensurePreferredHints(hints[string(v1.ResourceCPU)].Hints)
+	return hints, nil
+}
+
+// hasPreferredHint returns true if any of hints is preferred
+func hasPreferredHint(hints []*pluginapi.TopologyHint) bool {
+	for _, hint := range hints {
+		if hint.Preferred {
+			return true
+		}
+	}
+	return false
+}
+
+// ensurePreferredHints makes sure there is at least one preferred hint if hints are not empty.
+// minNUMAsCountNeeded is calculated by allocatable capacity, so all masks with that count
+// may be skipped for lack of available cpus, and hints with the least NUMAs left should be preferred then.
+func ensurePreferredHints(hints []*pluginapi.TopologyHint) {
+	minNUMAsCount := 0
+	for _, hint := range hints {
+		if hint.Preferred {
+			return
+		}
+
+		if minNUMAsCount == 0 || len(hint.Nodes) < minNUMAsCount {
+			minNUMAsCount = len(hint.Nodes)
+		}
+	}
+
+	for _, hint := range hints {
+		hint.Preferred = len(hint.Nodes) == minNUMAsCount
+	}
+}
+
+// calculateHintsForZeroNUMA generates hints for machines without any NUMA node,
+// all cpus in the machine are regarded as belonging to the pseudo NUMA node 0.
+func (p *DynamicPolicy) calculateHintsForZeroNUMA(ctx context.Context,
+	reqInt int,
+) (map[string]*pluginapi.ListOfTopologyHints, error) {
+	logger := general.LoggerFromContext(ctx)
+	hints := map[string]*pluginapi.ListOfTopologyHints{
+		string(v1.ResourceCPU): {
+			Hints: []*pluginapi.TopologyHint{},
+		},
+	}
+
+	allCPUs := p.machineInfo.CPUDetails.CPUs()
+	if allCPUs.IsEmpty() {
+		// cpu details may also be missing if no NUMA is reported
+		for cpu := 0; cpu < p.machineInfo.CPUTopology.NumCPUs; cpu++ {
+			allCPUs.Add(cpu)
+		}
+	}
 
+	availableCPUs := allCPUs.Difference(p.getUnavailableCPUs())
+	if availableCPUs.Size()-p.numaMinFreeCPUs < reqInt {
+		logger.Warningf("no NUMA in machine state, available cpuset: %s of size: %d with %d cpus kept free can't fit request: %d",
+			availableCPUs.String(), availableCPUs.Size(), p.numaMinFreeCPUs, reqInt)
+		return hints, nil
+	}
+
+	logger.Infof("no NUMA in machine state, regard the whole machine as pseudo NUMA: 0")
+	hints[string(v1.ResourceCPU)].Hints = append(hints[string(v1.ResourceCPU)].Hints, &pluginapi.TopologyHint{
+		Nodes:     []uint64{0},
+		Preferred: true,
+	})
 	return hints, nil
 }
 
-func (p *DynamicPolicy) sharedCoresWithNUMABindingHintHandler(_ context.Context,
+func (p *DynamicPolicy) sharedCoresWithNUMABindingHintHandler(ctx context.Context,
 	req *pluginapi.ResourceRequest,
 ) (*pluginapi.ResourceHintsResponse, error) {
+	logger := general.LoggerFromContext(ctx)
 	// currently, we set cpuset of sidecar to the cpuset of its main container,
 	// so there is no numa preference here.
 	if req.ContainerType == pluginapi.ContainerType_SIDECAR {
@@ -249,60 +579,152 @@ func (p *DynamicPolicy) sharedCoresWithNUMABindingHintHandler(_ context.Context,
 			})
 	}
 
-	reqInt, _, err := util.GetQuantityFromResourceReq(req)
+	reqInt, reqFloat64, err := util.GetQuantityFromResourceReq(req)
 	if err != nil {
 		return nil, fmt.Errorf("getReqQuantityFromResourceReq failed with error: %v", err)
 	}
 
-	machineState := p.state.GetMachineState()
-	podEntries := p.state.GetPodEntries()
+	hintState := p.getHintState(ctx)
+	machineState := hintState.GetMachineState()
+	podEntries := hintState.GetPodEntries()
+	sharedNUMAPools := hintState.GetSharedNUMAPools()
 
 	var hints map[string]*pluginapi.ListOfTopologyHints
 
-	allocationInfo := p.state.GetAllocationInfo(req.PodUid, req.ContainerName)
-	if allocationInfo != nil {
-		hints = cpuutil.RegenerateHints(allocationInfo, reqInt)
+	allocationInfo := hintState.GetAllocationInfo(req.PodUid, req.ContainerName)
+	if allocationInfo != nil && !state.CheckNUMABinding(allocationInfo) && qosutil.AnnotationsIndicateSoftNUMABinding(req.Annotations) {
+		// soft numa_binding container has already degraded to be without NUMA binding
+		return util.PackResourceHintsResponse(req, string(v1.ResourceCPU),
+			map[string]*pluginapi.ListOfTopologyHints{
+				string(v1.ResourceCPU): nil, // indicates that there is no numa preference
+			})
+	} else if allocationInfo != nil {
+		if reqFloat64 > allocationInfo.RequestQuantity {
+			hints = p.regenerateHintsForNUMABindingSharedCoresResize(ctx, allocationInfo, reqInt, sharedNUMAPools)
+		} else {
+			hints = cpuutil.RegenerateHints(allocationInfo, reqInt)
+		}
 
 		// regenerateHints failed. need to clear container record and re-calculate.
 		if hints == nil {
-			delete(podEntries[req.PodUid], req.ContainerName)
-			if len(podEntries[req.PodUid]) == 0 {
-				delete(podEntries, req.PodUid)
-			}
-
 			var err error
 			// [TODO]: generateMachineStateFromPodEntries adapts to shared_cores with numa_binding
-			machineState, err = generateMachineStateFromPodEntries(p.machineInfo.CPUTopology, podEntries)
+			machineState, err = p.clearContainerAndRegenerateMachineState(podEntries, allocationInfo, req)
 			if err != nil {
-				general.Errorf("pod: %s/%s, container: %s GenerateMachineStateFromPodEntries failed with error: %v",
+				logger.Errorf("pod: %s/%s, container: %s GenerateMachineStateFromPodEntries failed with error: %v",
 					req.PodNamespace, req.PodName, req.ContainerName, err)
 				return nil, fmt.Errorf("GenerateMachineStateFromPodEntries failed with error: %v", err)
 			}
+			// shared pools in state don't match the regenerated machine state any more
+			sharedNUMAPools = state.NewSharedNUMAPools(podEntries)
 		}
 	}
 
 	if hints == nil {
+		// the NUMA should also fit sidecars declared to be injected later, since they share its cpuset
+		hintReqInt := reqInt + getSidecarReservedCPUs(req)
+
 		var calculateErr error
-		hints, calculateErr = p.calculateHintsForNUMABindingSharedCores(reqInt, podEntries, machineState, req.Annotations)
-		if calculateErr != nil {
+		hints, calculateErr = p.calculateHintsForNUMABindingSharedCores(ctx, hintReqInt, podEntries, machineState, sharedNUMAPools,
+			req.Annotations, p.getNUMAConstraint(ctx, req.Hint, req.Annotations), nil)
+		if qosutil.AnnotationsIndicateSoftNUMABinding(req.Annotations) && (calculateErr != nil || !hasPreferredHint(hints[string(v1.ResourceCPU)].Hints)) {
+			logger.Warningf("pod: %s/%s, container: %s with soft numa_binding can't fit into any single NUMA "+
+				"(error: %v), fallback to no NUMA preference", req.PodNamespace, req.PodName, req.ContainerName, calculateErr)
+			return util.PackResourceHintsResponse(req, string(v1.ResourceCPU),
+				map[string]*pluginapi.ListOfTopologyHints{
+					string(v1.ResourceCPU): nil, // indicates that there is no numa preference
+				})
+		} else if calculateErr != nil {
 			return nil, fmt.Errorf("calculateHintsForNUMABindingSharedCores failed with error: %v", calculateErr)
 		}
+
+		p.preferNamespaceReservedNUMAs(ctx, req, hints)
+		p.spreadHintsByReplicas(ctx, req, podEntries, hints)
+		p.preferPlacementAdvisorNUMA(ctx, req, hintReqInt, machineState, sharedNUMAPools, hints)
+		p.preferPreviousNUMAs(ctx, req, hints)
+		p.preferMemoryAllocatedNUMAs(ctx, req, hints)
+		p.preferSiblingContainersNUMA(ctx, req, podEntries, hints)
+		p.demoteHintsByMemoryAvailability(ctx, req, hints)
+		p.demoteHintsByThrottledNUMAs(ctx, req, hints)
 	}
 
 	return util.PackResourceHintsResponse(req, string(v1.ResourceCPU), hints)
 }
 
-func (p *DynamicPolicy) populateHintsByPreferPolicy(numaNodes []int, preferPolicy string,
-	hints map[string]*pluginapi.ListOfTopologyHints, machineState state.NUMANodeMap, reqInt int,
+// getSidecarReservedCPUs returns cpus (rounded up) reserved by the main container for sidecars injected later
+func getSidecarReservedCPUs(req *pluginapi.ResourceRequest) int {
+	if req.ContainerType != pluginapi.ContainerType_MAIN {
+		return 0
+	}
+
+	reserved, found, err := qosutil.ParseSidecarReservedCPU(req.Annotations)
+	if err != nil {
+		general.Warningf("pod: %s/%s, container: %s ignores invalid sidecar reserved cpu: %v",
+			req.PodNamespace, req.PodName, req.ContainerName, err)
+		return 0
+	} else if !found {
+		return 0
+	}
+	return int(math.Ceil(reserved))
+}
+
+// regenerateHintsForNUMABindingSharedCoresResize regenerates hints for numa_binding shared_cores container
+// growing its request, the container keeps its NUMA only if the NUMA can fit the new request
+// without counting the prior allocation of the container itself.
+func (p *DynamicPolicy) regenerateHintsForNUMABindingSharedCoresResize(ctx context.Context,
+	allocationInfo *state.AllocationInfo,
+	reqInt int, sharedNUMAPools state.SharedNUMAPools,
+) map[string]*pluginapi.ListOfTopologyHints {
+	logger := general.LoggerFromContext(ctx)
+	numaSet := allocationInfo.GetAllocationResultNUMASet()
+	if numaSet.Size() != 1 {
+		logger.Errorf("pod: %s/%s, container: %s numa_binding shared_cores allocated with invalid NUMAs: %s",
+			allocationInfo.PodNamespace, allocationInfo.PodName, allocationInfo.ContainerName, numaSet.String())
+		return nil
+	}
+
+	nodeID := numaSet.ToSliceInt()[0]
+	availableCPUQuantity := p.getNUMAAvailableCPUQuantityExcludingContainer(sharedNUMAPools, nodeID,
+		p.getUnavailableCPUs(), allocationInfo.PodUid, allocationInfo.ContainerName)
+	if availableCPUQuantity < reqInt {
+		logger.Warningf("pod: %s/%s, container: %s resized to: %d exceeds available: %d in NUMA: %d",
+			allocationInfo.PodNamespace, allocationInfo.PodName, allocationInfo.ContainerName,
+			reqInt, availableCPUQuantity, nodeID)
+		return nil
+	}
+
+	return map[string]*pluginapi.ListOfTopologyHints{
+		string(v1.ResourceCPU): {
+			Hints: []*pluginapi.TopologyHint{
+				{
+					Nodes:     []uint64{uint64(nodeID)},
+					Preferred: true,
+				},
+			},
+		},
+	}
+}
+
+func (p *DynamicPolicy) populateHintsByPreferPolicy(ctx context.Context, numaNodes []int, preferPolicy string,
+	hints map[string]*pluginapi.ListOfTopologyHints, sharedNUMAPools state.SharedNUMAPools, reqInt int, growthFactor float64,
+	trace hintsTrace,
 ) {
+	logger := general.LoggerFromContext(ctx)
 	preferIndexes, maxLeft, minLeft := []int{}, -1, math.MaxInt
+	unavailableCPUs := p.getUnavailableCPUs()
 
+	curLefts := make(map[int]int, len(numaNodes))
+	availableCPUQuantities := make(map[int]int, len(numaNodes))
+	candidateIndexes, headroomIndexes := []int{}, []int{}
 	for _, nodeID := range numaNodes {
-		availableCPUQuantity := machineState[nodeID].GetAvailableCPUQuantity(p.reservedCPUs)
+		availableCPUQuantity := p.getNUMAAvailableCPUQuantity(sharedNUMAPools, nodeID, unavailableCPUs)
+		availableCPUQuantities[nodeID] = availableCPUQuantity
 
 		if availableCPUQuantity < reqInt {
-			general.Warningf("numa_binding shared_cores container skip NUMA: %d available: %d",
+			logger.Warningf("numa_binding shared_cores container skip NUMA: %d available: %d",
 				nodeID, availableCPUQuantity)
+			trace.record(nodeID, "insufficient cpu: available quantity %d is smaller than request %d",
+				availableCPUQuantity, reqInt)
 			continue
 		}
 
@@ -310,80 +732,242 @@ func (p *DynamicPolicy) populateHintsByPreferPolicy(numaNodes []int, preferPolic
 			Nodes: []uint64{uint64(nodeID)},
 		})
 
-		curLeft := availableCPUQuantity - reqInt
+		hintIndex := len(hints[string(v1.ResourceCPU)].Hints) - 1
+		curLefts[hintIndex] = availableCPUQuantity - reqInt
+		candidateIndexes = append(candidateIndexes, hintIndex)
 
-		general.Infof("NUMA: %d, left cpu quantity: %d", nodeID, curLeft)
+		logger.Infof("NUMA: %d, left cpu quantity: %d", nodeID, curLefts[hintIndex])
+		trace.record(nodeID, "candidate: available quantity %d, left quantity %d after allocation",
+			availableCPUQuantity, curLefts[hintIndex])
+
+		if float64(availableCPUQuantity) >= float64(reqInt)*growthFactor {
+			headroomIndexes = append(headroomIndexes, hintIndex)
+		}
+	}
+
+	if len(candidateIndexes) == 0 {
+		p.populateFallbackHints(ctx, numaNodes, availableCPUQuantities, hints, trace)
+		return
+	}
+
+	if growthFactor > 1 {
+		if len(headroomIndexes) > 0 {
+			logger.Infof("prefer among hints with headroom for growth factor: %.2f", growthFactor)
+			candidateIndexes = headroomIndexes
+		} else {
+			logger.Infof("no NUMA has headroom for growth factor: %.2f, fall back to %s policy", growthFactor, preferPolicy)
+		}
+	}
+
+	for _, hintIndex := range candidateIndexes {
+		curLeft := curLefts[hintIndex]
 
 		if preferPolicy == cpuconsts.CPUNUMAHintPreferPolicyPacking {
 			if curLeft < minLeft {
 				minLeft = curLeft
-				preferIndexes = []int{len(hints[string(v1.ResourceCPU)].Hints) - 1}
+				preferIndexes = []int{hintIndex}
 			} else if curLeft == minLeft {
-				preferIndexes = append(preferIndexes, len(hints[string(v1.ResourceCPU)].Hints)-1)
+				preferIndexes = append(preferIndexes, hintIndex)
 			}
 		} else {
 			if curLeft > maxLeft {
 				maxLeft = curLeft
-				preferIndexes = []int{len(hints[string(v1.ResourceCPU)].Hints) - 1}
+				preferIndexes = []int{hintIndex}
 			} else if curLeft == maxLeft {
-				preferIndexes = append(preferIndexes, len(hints[string(v1.ResourceCPU)].Hints)-1)
+				preferIndexes = append(preferIndexes, hintIndex)
 			}
 		}
 	}
 
+	preferIndexes = p.breakHintPreferTie(hints[string(v1.ResourceCPU)].Hints, preferIndexes)
+
 	if len(preferIndexes) >= 0 {
 		for _, preferIndex := range preferIndexes {
 			hints[string(v1.ResourceCPU)].Hints[preferIndex].Preferred = true
+			trace.record(int(hints[string(v1.ResourceCPU)].Hints[preferIndex].Nodes[0]), "preferred by %s policy", preferPolicy)
 		}
 	}
 }
 
-func (p *DynamicPolicy) filterNUMANodesByHintPreferLowThreshold(reqInt int,
-	machineState state.NUMANodeMap, numaNodes []int,
+// populateFallbackHints generates non-preferred hints for all the given NUMAs when none of them is eligible
+// for preference, ordered by cpuNUMAHintFallbackOrder so that the choice of topology manager is predictable;
+// no hint is generated if the fallback order is none (or unknown).
+func (p *DynamicPolicy) populateFallbackHints(ctx context.Context, numaNodes []int, availableCPUQuantities map[int]int,
+	hints map[string]*pluginapi.ListOfTopologyHints, trace hintsTrace,
+) {
+	logger := general.LoggerFromContext(ctx)
+	orderedNUMANodes := make([]int, len(numaNodes))
+	copy(orderedNUMANodes, numaNodes)
+
+	switch p.cpuNUMAHintFallbackOrder {
+	case cpuconsts.CPUNUMAHintFallbackOrderLowestNUMAID:
+		sort.Ints(orderedNUMANodes)
+	case cpuconsts.CPUNUMAHintFallbackOrderMostAvailable:
+		sort.SliceStable(orderedNUMANodes, func(i, j int) bool {
+			if availableCPUQuantities[orderedNUMANodes[i]] != availableCPUQuantities[orderedNUMANodes[j]] {
+				return availableCPUQuantities[orderedNUMANodes[i]] > availableCPUQuantities[orderedNUMANodes[j]]
+			}
+			return orderedNUMANodes[i] < orderedNUMANodes[j]
+		})
+	default:
+		return
+	}
+
+	logger.Infof("no NUMA is eligible for preference, fall back to %s order on NUMAs: %+v",
+		p.cpuNUMAHintFallbackOrder, orderedNUMANodes)
+	for _, nodeID := range orderedNUMANodes {
+		hints[string(v1.ResourceCPU)].Hints = append(hints[string(v1.ResourceCPU)].Hints, &pluginapi.TopologyHint{
+			Nodes: []uint64{uint64(nodeID)},
+		})
+		trace.record(nodeID, "fallback: non-preferred in %s order", p.cpuNUMAHintFallbackOrder)
+	}
+}
+
+// populateHintsByFreeMemory generates hints for numa_binding shared_cores containers requesting zero cpu,
+// they rely on bursting and only need NUMA affinity of memory, so every NUMA with any available cpu is a candidate,
+// and the ones with the most free memory are preferred; all candidates are preferred if memory metrics are missing.
+func (p *DynamicPolicy) populateHintsByFreeMemory(ctx context.Context,
+	numaNodes []int, hints map[string]*pluginapi.ListOfTopologyHints,
+	machineState state.NUMANodeMap, trace hintsTrace,
+) {
+	logger := general.LoggerFromContext(ctx)
+	unavailableCPUs := p.getUnavailableCPUs()
+	preferIndexes, maxFreeMemory := []int{}, -1.0
+	metricMissing := !p.isMetricStoreAvailable()
+
+	for _, nodeID := range numaNodes {
+		availableCPUQuantity := machineState[nodeID].GetAvailableCPUSet(unavailableCPUs).Size()
+		if availableCPUQuantity == 0 {
+			logger.Warningf("zero cpu numa_binding shared_cores container skip NUMA: %d without available cpus", nodeID)
+			trace.record(nodeID, "no capacity: NUMA has no available cpu")
+			continue
+		}
+
+		hints[string(v1.ResourceCPU)].Hints = append(hints[string(v1.ResourceCPU)].Hints, &pluginapi.TopologyHint{
+			Nodes: []uint64{uint64(nodeID)},
+		})
+		hintIndex := len(hints[string(v1.ResourceCPU)].Hints) - 1
+
+		if metricMissing {
+			continue
+		}
+
+		data, err := p.getNUMAMetric(nodeID, coreconsts.MetricMemFreeNuma)
+		if err != nil {
+			logger.Errorf("get metric: %s of NUMA: %d failed with error: %v, prefer all NUMAs",
+				coreconsts.MetricMemFreeNuma, nodeID, err)
+			metricMissing = true
+			continue
+		}
+
+		logger.Infof("NUMA: %d, free memory: %.0f", nodeID, data.Value)
+		trace.record(nodeID, "candidate: free memory %.0f", data.Value)
+		if data.Value > maxFreeMemory {
+			preferIndexes, maxFreeMemory = []int{hintIndex}, data.Value
+		} else if data.Value == maxFreeMemory {
+			preferIndexes = append(preferIndexes, hintIndex)
+		}
+	}
+
+	if metricMissing {
+		for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+			hint.Preferred = true
+		}
+		return
+	}
+
+	for _, preferIndex := range p.breakHintPreferTie(hints[string(v1.ResourceCPU)].Hints, preferIndexes) {
+		hints[string(v1.ResourceCPU)].Hints[preferIndex].Preferred = true
+	}
+}
+
+// breakHintPreferTie narrows hints with equal score to a single preferred one
+// according to cpuNUMAHintPreferTieBreak, so that the choice of topology manager is deterministic
+func (p *DynamicPolicy) breakHintPreferTie(hints []*pluginapi.TopologyHint, preferIndexes []int) []int {
+	if len(preferIndexes) <= 1 {
+		return preferIndexes
+	}
+
+	switch p.cpuNUMAHintPreferTieBreak {
+	case cpuconsts.CPUNUMAHintPreferTieBreakNone:
+		return preferIndexes
+	case cpuconsts.CPUNUMAHintPreferTieBreakHighestNUMAID:
+		chosenIndex := preferIndexes[0]
+		for _, preferIndex := range preferIndexes[1:] {
+			if hints[preferIndex].Nodes[0] > hints[chosenIndex].Nodes[0] {
+				chosenIndex = preferIndex
+			}
+		}
+		return []int{chosenIndex}
+	default:
+		chosenIndex := preferIndexes[0]
+		for _, preferIndex := range preferIndexes[1:] {
+			if hints[preferIndex].Nodes[0] < hints[chosenIndex].Nodes[0] {
+				chosenIndex = preferIndex
+			}
+		}
+		return []int{chosenIndex}
+	}
+}
+
+func (p *DynamicPolicy) filterNUMANodesByHintPreferLowThreshold(ctx context.Context, reqInt int,
+	machineState state.NUMANodeMap, sharedNUMAPools state.SharedNUMAPools, numaNodes []int, trace hintsTrace,
 ) []int {
+	logger := general.LoggerFromContext(ctx)
 	filteredNUMANodes := make([]int, 0, len(numaNodes))
+	unavailableCPUs := p.getUnavailableCPUs()
 
 	for _, nodeID := range numaNodes {
-		availableCPUQuantity := machineState[nodeID].GetAvailableCPUQuantity(p.reservedCPUs)
-		allocatableCPUQuantity := machineState[nodeID].GetFilteredDefaultCPUSet(nil, nil).Difference(p.reservedCPUs).Size()
+		availableCPUQuantity := p.getNUMAAvailableCPUQuantity(sharedNUMAPools, nodeID, unavailableCPUs)
+		allocatableCPUQuantity := machineState[nodeID].GetFilteredDefaultCPUSet(nil, nil).DifferenceSize(unavailableCPUs)
 
 		if allocatableCPUQuantity == 0 {
-			general.Warningf("numa: %d allocatable cpu quantity is zero", nodeID)
+			logger.Warningf("numa: %d allocatable cpu quantity is zero", nodeID)
+			trace.record(nodeID, "low threshold: allocatable quantity is zero")
 			continue
 		}
 
 		availableRatio := float64(availableCPUQuantity) / float64(allocatableCPUQuantity)
 
-		general.Infof("NUMA: %d, availableCPUQuantity: %d, allocatableCPUQuantity: %d, availableRatio: %.2f, cpuNUMAHintPreferLowThreshold:%.2f",
+		logger.Infof("NUMA: %d, availableCPUQuantity: %d, allocatableCPUQuantity: %d, availableRatio: %.2f, cpuNUMAHintPreferLowThreshold:%.2f",
 			nodeID, availableCPUQuantity, allocatableCPUQuantity, availableRatio, p.cpuNUMAHintPreferLowThreshold)
 
 		if availableRatio >= p.cpuNUMAHintPreferLowThreshold {
 			filteredNUMANodes = append(filteredNUMANodes, nodeID)
+		} else {
+			trace.record(nodeID, "low threshold: available ratio %.2f is lower than %.2f",
+				availableRatio, p.cpuNUMAHintPreferLowThreshold)
 		}
 	}
 
 	return filteredNUMANodes
 }
 
-func (p *DynamicPolicy) filterNUMANodesByNonBindingSharedRequestedQuantity(nonBindingSharedRequestedQuantity,
+func (p *DynamicPolicy) filterNUMANodesByNonBindingSharedRequestedQuantity(ctx context.Context,
+	nonBindingSharedRequestedQuantity,
 	nonBindingNUMAsCPUQuantity int,
 	nonBindingNUMAs machine.CPUSet,
-	machineState state.NUMANodeMap, numaNodes []int,
+	machineState state.NUMANodeMap, numaNodes []int, trace hintsTrace,
 ) []int {
+	logger := general.LoggerFromContext(ctx)
 	filteredNUMANodes := make([]int, 0, len(numaNodes))
+	unavailableCPUs := p.getUnavailableCPUs()
 
 	for _, nodeID := range numaNodes {
 		if nonBindingNUMAs.Contains(nodeID) {
-			allocatableCPUQuantity := machineState[nodeID].GetFilteredDefaultCPUSet(nil, nil).Difference(p.reservedCPUs).Size()
+			allocatableCPUQuantity := machineState[nodeID].GetFilteredDefaultCPUSet(nil, nil).DifferenceSize(unavailableCPUs)
 
 			// take this non-binding NUMA for candicate shared_cores with numa_binding,
 			// won't cause normal shared_cores in short supply
 			if nonBindingNUMAsCPUQuantity-allocatableCPUQuantity >= nonBindingSharedRequestedQuantity {
 				filteredNUMANodes = append(filteredNUMANodes, nodeID)
 			} else {
-				general.Infof("filter out NUMA: %d since taking it will cause normal shared_cores in short supply;"+
+				logger.Infof("filter out NUMA: %d since taking it will cause normal shared_cores in short supply;"+
 					" nonBindingNUMAsCPUQuantity: %d, targetNUMAAllocatableCPUQuantity: %d, nonBindingSharedRequestedQuantity: %d",
 					nodeID, nonBindingNUMAsCPUQuantity, allocatableCPUQuantity, nonBindingSharedRequestedQuantity)
+				trace.record(nodeID, "shared_cores short supply: taking allocatable quantity %d out of non-binding quantity %d "+
+					"can't satisfy non-binding shared_cores requested quantity %d",
+					allocatableCPUQuantity, nonBindingNUMAsCPUQuantity, nonBindingSharedRequestedQuantity)
 			}
 		} else {
 			filteredNUMANodes = append(filteredNUMANodes, nodeID)
@@ -393,17 +977,16 @@ func (p *DynamicPolicy) filterNUMANodesByNonBindingSharedRequestedQuantity(nonBi
 	return filteredNUMANodes
 }
 
-func (p *DynamicPolicy) calculateHintsForNUMABindingSharedCores(reqInt int, podEntries state.PodEntries,
-	machineState state.NUMANodeMap,
-	reqAnnotations map[string]string,
+func (p *DynamicPolicy) calculateHintsForNUMABindingSharedCores(ctx context.Context, reqInt int, podEntries state.PodEntries,
+	machineState state.NUMANodeMap, sharedNUMAPools state.SharedNUMAPools,
+	reqAnnotations map[string]string, numaConstraint machine.CPUSet, trace hintsTrace,
 ) (map[string]*pluginapi.ListOfTopologyHints, error) {
-	nonBindingNUMAsCPUQuantity := machineState.GetFilteredAvailableCPUSet(p.reservedCPUs, nil, state.CheckNUMABinding).Size()
-	nonBindingNUMAs := machineState.GetFilteredNUMASet(state.CheckNUMABinding)
-	nonBindingSharedRequestedQuantity := state.GetNonBindingSharedRequestedQuantityFromPodEntries(podEntries)
-
-	numaNodes := p.filterNUMANodesByNonBindingSharedRequestedQuantity(nonBindingSharedRequestedQuantity,
-		nonBindingNUMAsCPUQuantity, nonBindingNUMAs, machineState,
-		machineState.GetFilteredNUMASetWithAnnotations(state.CheckNUMABindingSharedCoresAntiAffinity, reqAnnotations).ToSliceInt())
+	logger := general.LoggerFromContext(ctx)
+	numaNodes, preferPolicy, err := p.filterNUMANodesForNUMABindingSharedCores(ctx, reqInt, podEntries, machineState,
+		sharedNUMAPools, reqAnnotations, numaConstraint, trace)
+	if err != nil {
+		return nil, err
+	}
 
 	hints := map[string]*pluginapi.ListOfTopologyHints{
 		string(v1.ResourceCPU): {
@@ -411,34 +994,395 @@ func (p *DynamicPolicy) calculateHintsForNUMABindingSharedCores(reqInt int, podE
 		},
 	}
 
-	minNUMAsCountNeeded, _, err := util.GetNUMANodesCountToFitCPUReq(reqInt, p.machineInfo.CPUTopology)
+	if reqInt == 0 {
+		logger.Infof("zero cpu request, prefer by free memory on NUMAs: %+v", numaNodes)
+		p.populateHintsByFreeMemory(ctx, numaNodes, hints, machineState, trace)
+	} else if p.singleNUMAFastPath && len(numaNodes) == 1 && trace == nil &&
+		p.getNUMAAvailableCPUQuantity(sharedNUMAPools, numaNodes[0], p.getUnavailableCPUs()) >= reqInt {
+		// prefer policy makes no difference with the only NUMA, so it's preferred directly as long as it fits
+		logger.Infof("single NUMA: %d fits request: %d, skip %s policy", numaNodes[0], reqInt, preferPolicy)
+		hints[string(v1.ResourceCPU)].Hints = append(hints[string(v1.ResourceCPU)].Hints, &pluginapi.TopologyHint{
+			Nodes:     []uint64{uint64(numaNodes[0])},
+			Preferred: true,
+		})
+	} else {
+		logger.Infof("apply %s policy on NUMAs: %+v", preferPolicy, numaNodes)
+		p.populateHintsByPreferPolicy(ctx, numaNodes, preferPolicy, hints, sharedNUMAPools, reqInt,
+			p.getGrowthFactor(ctx, reqAnnotations), trace)
+	}
+
+	if preferredNUMA, ok := p.getPreferredNUMAOverride(ctx, reqAnnotations); ok {
+		// fallback hints are generated only if no NUMA has enough capacity, so none of them can be preferred by override
+		found := false
+		if hasPreferredHint(hints[string(v1.ResourceCPU)].Hints) {
+			for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+				hint.Preferred = len(hint.Nodes) == 1 && hint.Nodes[0] == uint64(preferredNUMA)
+				found = found || hint.Preferred
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("preferred NUMA: %d specified by annotation has no enough capacity for request: %d",
+				preferredNUMA, reqInt)
+		}
+		logger.Infof("apply preferred NUMA: %d specified by annotation", preferredNUMA)
+	}
+
+	return hints, nil
+}
+
+// filterNUMANodesForNUMABindingSharedCores returns NUMA nodes that numa_binding shared_cores
+// containers can be placed on (before checking the available quantity of each NUMA),
+// and the prefer policy that should be applied on them.
+func (p *DynamicPolicy) filterNUMANodesForNUMABindingSharedCores(ctx context.Context, reqInt int, podEntries state.PodEntries,
+	machineState state.NUMANodeMap, sharedNUMAPools state.SharedNUMAPools, reqAnnotations map[string]string,
+	numaConstraint machine.CPUSet, trace hintsTrace,
+) ([]int, string, error) {
+	logger := general.LoggerFromContext(ctx)
+	minNUMAsCountNeeded, _, err := util.GetNUMANodesCountToFitCPUReqWithCapacity(reqInt, p.machineInfo.CPUTopology,
+		p.getNUMAAllocatableCPUQuantity)
 	if err != nil {
-		return nil, fmt.Errorf("GetNUMANodesCountToFitCPUReq failed with error: %v", err)
+		return nil, "", fmt.Errorf("GetNUMANodesCountToFitCPUReqWithCapacity failed with error: %v", err)
 	}
 
 	// if a numa_binding shared_cores has request larger than 1 NUMA,
 	// its performance may degrade to be like normal shared_cores
 	if minNUMAsCountNeeded > 1 {
-		return nil, fmt.Errorf("numa_binding shared_cores container has request larger than 1 NUMA")
+		return nil, "", fmt.Errorf("numa_binding shared_cores container has request larger than 1 NUMA")
 	}
+
+	nonBindingNUMAsCPUQuantity := machineState.GetFilteredAvailableCPUSet(p.getUnavailableCPUs(), nil, state.CheckNUMABinding).Size()
+	nonBindingNUMAs := machineState.GetFilteredNUMASet(state.CheckNUMABinding)
+	nonBindingSharedRequestedQuantity := state.GetNonBindingSharedRequestedQuantityFromPodEntries(podEntries)
+
+	antiAffinityFilteredNUMAs := machineState.GetFilteredNUMASetWithAnnotations(state.CheckNUMABindingSharedCoresAntiAffinity, reqAnnotations)
+	trace.recordExcluded(p.machineInfo.CPUDetails.NUMANodes(), antiAffinityFilteredNUMAs,
+		"anti-affinity: NUMA has containers anti-affine to the request")
+
+	numaNodes := p.filterNUMANodesByNonBindingSharedRequestedQuantity(ctx, nonBindingSharedRequestedQuantity,
+		nonBindingNUMAsCPUQuantity, nonBindingNUMAs, machineState, antiAffinityFilteredNUMAs.ToSliceInt(), trace)
+
+	constraintFilteredNUMAs := filterNUMANodesByConstraint(ctx, numaNodes, numaConstraint)
+	trace.recordExcluded(machine.NewCPUSet(numaNodes...), machine.NewCPUSet(constraintFilteredNUMAs...),
+		fmt.Sprintf("NUMA constraint: NUMA is out of constraint %s", numaConstraint.String()))
+
+	cordonFilteredNUMAs := p.filterCordonedNUMANodes(constraintFilteredNUMAs)
+	trace.recordExcluded(machine.NewCPUSet(constraintFilteredNUMAs...), machine.NewCPUSet(cordonFilteredNUMAs...),
+		"cordoned: NUMA is cordoned")
+
+	namespaceFilteredNUMAs := filterNamespaceExcludedNUMANodes(ctx, cordonFilteredNUMAs)
+	trace.recordExcluded(machine.NewCPUSet(cordonFilteredNUMAs...), machine.NewCPUSet(namespaceFilteredNUMAs...),
+		"namespace reservation: NUMA is reserved for other namespaces")
+
+	numaNodes = p.filterNUMANodesAtSharedPodsCap(ctx, podEntries, namespaceFilteredNUMAs)
+	trace.recordExcluded(machine.NewCPUSet(namespaceFilteredNUMAs...), machine.NewCPUSet(numaNodes...),
+		fmt.Sprintf("shared pods cap: NUMA has %d numa_binding shared_cores pods", p.maxNUMABindingSharedPods))
+
+	// zero cpu request doesn't consume cpus of any NUMA, so NUMAs aren't narrowed by cpu quantity
+	if reqInt == 0 {
+		return numaNodes, p.cpuNUMAHintPreferPolicy, nil
+	}
+
 	switch p.cpuNUMAHintPreferPolicy {
 	case cpuconsts.CPUNUMAHintPreferPolicyPacking, cpuconsts.CPUNUMAHintPreferPolicySpreading:
-		general.Infof("apply %s policy on NUMAs: %+v", p.cpuNUMAHintPreferPolicy, numaNodes)
-		p.populateHintsByPreferPolicy(numaNodes, p.cpuNUMAHintPreferPolicy, hints, machineState, reqInt)
+		return numaNodes, p.cpuNUMAHintPreferPolicy, nil
 	case cpuconsts.CPUNUMAHintPreferPolicyDynamicPacking:
-		compactNUMANodes := p.filterNUMANodesByHintPreferLowThreshold(reqInt, machineState, numaNodes)
+		compactNUMANodes := p.filterNUMANodesByHintPreferLowThreshold(ctx, reqInt, machineState, sharedNUMAPools, numaNodes, trace)
 
 		if len(compactNUMANodes) > 0 {
-			general.Infof("dynamically apply packing policy on NUMAs: %+v", compactNUMANodes)
-			p.populateHintsByPreferPolicy(compactNUMANodes, cpuconsts.CPUNUMAHintPreferPolicyPacking, hints, machineState, reqInt)
-		} else {
-			general.Infof("empty compactNUMANodes, dynamically apply spreading policy on NUMAs: %+v", numaNodes)
-			p.populateHintsByPreferPolicy(numaNodes, cpuconsts.CPUNUMAHintPreferPolicySpreading, hints, machineState, reqInt)
+			logger.Infof("dynamically apply packing policy on NUMAs: %+v", compactNUMANodes)
+			return compactNUMANodes, cpuconsts.CPUNUMAHintPreferPolicyPacking, nil
 		}
+
+		logger.Infof("empty compactNUMANodes, dynamically apply spreading policy on NUMAs: %+v", numaNodes)
+		return numaNodes, cpuconsts.CPUNUMAHintPreferPolicySpreading, nil
 	default:
-		general.Infof("unknown policy: %s, apply default spreading policy on NUMAs: %+v", p.cpuNUMAHintPreferPolicy, numaNodes)
-		p.populateHintsByPreferPolicy(numaNodes, cpuconsts.CPUNUMAHintPreferPolicySpreading, hints, machineState, reqInt)
+		logger.Infof("unknown policy: %s, apply default spreading policy on NUMAs: %+v", p.cpuNUMAHintPreferPolicy, numaNodes)
+		return numaNodes, cpuconsts.CPUNUMAHintPreferPolicySpreading, nil
+	}
+}
+
+// preferSiblingContainersNUMA makes the NUMA that sibling containers of the same pod have been
+// admitted to as the only preferred one (if it's among hints), to co-locate containers in a pod.
+// it takes no effect if the preferred NUMA is already forced by annotation.
+func (p *DynamicPolicy) preferSiblingContainersNUMA(ctx context.Context,
+	req *pluginapi.ResourceRequest, podEntries state.PodEntries,
+	hints map[string]*pluginapi.ListOfTopologyHints,
+) {
+	logger := general.LoggerFromContext(ctx)
+	if hints[string(v1.ResourceCPU)] == nil || !hasPreferredHint(hints[string(v1.ResourceCPU)].Hints) {
+		// fallback hints without preference are kept as they are, since none of the NUMAs has enough capacity
+		return
+	} else if _, ok := p.getPreferredNUMAOverride(ctx, req.Annotations); ok {
+		return
 	}
 
-	return hints, nil
+	siblingNUMA, ok := podEntries[req.PodUid].GetSharedNUMABindingNUMAHint(req.ContainerName)
+	if !ok {
+		return
+	}
+
+	found := false
+	for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+		if len(hint.Nodes) == 1 && hint.Nodes[0] == uint64(siblingNUMA) {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		logger.Warningf("pod: %s/%s, container: %s can't be co-located with sibling containers on NUMA: %d",
+			req.PodNamespace, req.PodName, req.ContainerName, siblingNUMA)
+		return
+	}
+
+	logger.Infof("pod: %s/%s, container: %s prefer NUMA: %d of sibling containers",
+		req.PodNamespace, req.PodName, req.ContainerName, siblingNUMA)
+	for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+		hint.Preferred = len(hint.Nodes) == 1 && hint.Nodes[0] == uint64(siblingNUMA)
+	}
+}
+
+// getNUMAConstraint returns NUMA nodes that the request has already been constrained to,
+// the hint carried by the request takes precedence over the one in annotations, and both
+// take precedence over the numa pin rule carried in ctx. empty result means there is no constraint.
+func (p *DynamicPolicy) getNUMAConstraint(ctx context.Context,
+	hint *pluginapi.TopologyHint, reqAnnotations map[string]string,
+) machine.CPUSet {
+	logger := general.LoggerFromContext(ctx)
+	if hint != nil && len(hint.Nodes) > 0 {
+		return machine.NewCPUSet(util.HintToIntArray(hint)...)
+	}
+
+	numaConstraint, _, err := qosutil.ParseNUMAConstraint(reqAnnotations)
+	if err != nil {
+		logger.Warningf("parse NUMA constraint failed with error: %v, ignore it", err)
+		numaConstraint = machine.NewCPUSet()
+	} else if numaConstraint.IsEmpty() {
+		if rule, ok := getNUMAPinRuleFromContext(ctx); ok {
+			numaConstraint = rule.numaNodes.Clone()
+		}
+	}
+	return p.applySocketAffinity(ctx, numaConstraint, reqAnnotations)
+}
+
+// getNUMAAllocatableCPUQuantity returns the count of cpus in the NUMA that can be allocated,
+// i.e. excluding reserved and offline cpus
+func (p *DynamicPolicy) getNUMAAllocatableCPUQuantity(numaID int) int {
+	return p.machineInfo.CPUDetails.CPUsInNUMANodes(numaID).DifferenceSize(p.getUnavailableCPUs())
+}
+
+// getNUMAAvailableCPUQuantity returns available cpu quantity of the NUMA for shared_cores with numa_binding containers,
+// it's the same as NUMANodeState.GetAvailableCPUQuantity, except that requested quantity is read from the maintained
+// shared pool rather than recomputed from all entries in the NUMA, and cpus kept free in the NUMA aren't available.
+func (p *DynamicPolicy) getNUMAAvailableCPUQuantity(sharedNUMAPools state.SharedNUMAPools, numaID int,
+	unavailableCPUs machine.CPUSet,
+) int {
+	return p.getNUMAAvailableCPUQuantityExcludingContainer(sharedNUMAPools, numaID, unavailableCPUs, "", "")
+}
+
+// getNUMAAvailableCPUQuantityExcludingContainer is the same as getNUMAAvailableCPUQuantity,
+// except that requested quantity of the given container isn't counted as allocated.
+func (p *DynamicPolicy) getNUMAAvailableCPUQuantityExcludingContainer(sharedNUMAPools state.SharedNUMAPools, numaID int,
+	unavailableCPUs machine.CPUSet, podUID, containerName string,
+) int {
+	allocatableQuantity := p.machineInfo.CPUDetails.CPUsInNUMANodes(numaID).DifferenceSize(unavailableCPUs)
+	requestedQuantity := sharedNUMAPools[numaID].GetRequestedQuantityExcludingContainer(podUID, containerName)
+	return general.Max(allocatableQuantity-requestedQuantity-p.numaMinFreeCPUs, 0)
+}
+
+// filterNUMANodesByConstraint keeps NUMA nodes within numaConstraint, it takes no effect for empty numaConstraint.
+func filterNUMANodesByConstraint(ctx context.Context, numaNodes []int, numaConstraint machine.CPUSet) []int {
+	logger := general.LoggerFromContext(ctx)
+	if numaConstraint.IsEmpty() {
+		return numaNodes
+	}
+
+	filteredNUMANodes := make([]int, 0, len(numaNodes))
+	for _, nodeID := range numaNodes {
+		if numaConstraint.Contains(nodeID) {
+			filteredNUMANodes = append(filteredNUMANodes, nodeID)
+		}
+	}
+
+	logger.Infof("filter NUMAs: %+v by constraint: %s, result: %+v", numaNodes, numaConstraint.String(), filteredNUMANodes)
+	return filteredNUMANodes
+}
+
+// getPreferredNUMAOverride parses the preferred NUMA forced by pod annotation,
+// invalid values are ignored, so that hints fall back to the prefer policy.
+func (p *DynamicPolicy) getPreferredNUMAOverride(ctx context.Context, reqAnnotations map[string]string) (int, bool) {
+	logger := general.LoggerFromContext(ctx)
+	preferredNUMA, found, err := qosutil.ParseNUMABindingPreferredNUMA(reqAnnotations)
+	if !found {
+		return 0, false
+	} else if err != nil || preferredNUMA >= p.machineInfo.CPUTopology.NumNUMANodes {
+		logger.Warningf("invalid preferred NUMA: %d (err: %v), ignore it", preferredNUMA, err)
+		return 0, false
+	}
+	return preferredNUMA, true
+}
+
+// getGrowthFactor parses the factor that the request is expected to scale up by from pod annotation,
+// invalid values or values not greater than 1 are ignored, and 1 is returned meaning no headroom is needed.
+func (p *DynamicPolicy) getGrowthFactor(ctx context.Context, reqAnnotations map[string]string) float64 {
+	logger := general.LoggerFromContext(ctx)
+	growthFactor, found, err := qosutil.ParseNUMABindingGrowthFactor(reqAnnotations)
+	if !found {
+		return 1
+	} else if err != nil || growthFactor <= 1 {
+		logger.Warningf("invalid growth factor: %.2f (err: %v), ignore it", growthFactor, err)
+		return 1
+	}
+	return growthFactor
+}
+
+// isNodeExclusive returns true if the numa_exclusive container takes up whole NUMAs
+func isNodeExclusive(reqAnnotations map[string]string) bool {
+	return qosutil.AnnotationsIndicateNUMAExclusive(reqAnnotations) && !qosutil.AnnotationsIndicateCoreExclusive(reqAnnotations)
+}
+
+// alignRequestToFullCores rounds the request up to the multiple of cpus per core if the container
+// asks for whole physical cores by annotation (or it's dedicated_cores and dedicatedFullPhysicalCPUsOnly is set),
+// and returns whether the alignment is required.
+func (p *DynamicPolicy) alignRequestToFullCores(reqInt int, reqAnnotations map[string]string) (int, bool) {
+	if !qosutil.AnnotationsIndicateNUMABinding(reqAnnotations) {
+		return reqInt, false
+	} else if !qosutil.AnnotationsIndicateFullPhysicalCores(reqAnnotations) && !qosutil.AnnotationsIndicateCoreExclusive(reqAnnotations) &&
+		!p.isDedicatedFullPhysicalCPUsOnly(reqAnnotations) {
+		return reqInt, false
+	}
+
+	cpusPerCore := p.machineInfo.CPUsPerCore()
+	if cpusPerCore <= 1 {
+		return reqInt, true
+	}
+	return (reqInt + cpusPerCore - 1) / cpusPerCore * cpusPerCore, true
+}
+
+// getCoreClassCPUs returns cpus of the core class targeted by the container and true, or false if it targets
+// no class or takes up whole NUMAs; error is returned if the machine has no cpu of the class.
+func (p *DynamicPolicy) getCoreClassCPUs(reqAnnotations map[string]string) (machine.CPUSet, bool, error) {
+	coreClass, found := qosutil.GetCoreClass(reqAnnotations)
+	if !found || isNodeExclusive(reqAnnotations) {
+		return machine.NewCPUSet(), false, nil
+	}
+
+	coreClassCPUs := p.machineInfo.CPUDetails.CPUsInCoreClasses(coreClass)
+	if coreClassCPUs.IsEmpty() {
+		return machine.NewCPUSet(), true, fmt.Errorf("machine has no cpu of core class: %s", coreClass)
+	}
+	return coreClassCPUs, true, nil
+}
+
+// isDedicatedFullPhysicalCPUsOnly returns true if the container is dedicated_cores
+// and must be allocated with whole physical cores by configuration
+func (p *DynamicPolicy) isDedicatedFullPhysicalCPUsOnly(reqAnnotations map[string]string) bool {
+	return p.dedicatedFullPhysicalCPUsOnly &&
+		reqAnnotations[apiconsts.PodAnnotationQoSLevelKey] == apiconsts.PodAnnotationQoSLevelDedicatedCores
+}
+
+// demoteHintsByMemoryAvailability marks preferred hints as non-preferred if NUMA nodes in them
+// don't have enough free memory for the candidate container, so that cpu and memory hints
+// of numa_binding pods can converge to the same NUMA nodes.
+// it takes no effect if enableCPUMemoryCoAllocation isn't set, memory metrics are missing or the metric store
+// is unavailable, i.e. hints fall back to be decided by cpus only.
+func (p *DynamicPolicy) demoteHintsByMemoryAvailability(ctx context.Context, req *pluginapi.ResourceRequest,
+	hints map[string]*pluginapi.ListOfTopologyHints,
+) {
+	logger := general.LoggerFromContext(ctx)
+	if !p.enableCPUMemoryCoAllocation ||
+		!qosutil.AnnotationsIndicateNUMABinding(req.Annotations) ||
+		hints[string(v1.ResourceCPU)] == nil || !p.isMetricStoreAvailable() {
+		return
+	}
+
+	container, err := p.metaServer.GetContainerSpec(req.PodUid, req.ContainerName)
+	if err != nil || container == nil {
+		logger.Errorf("pod: %s/%s, container: %s get container spec failed with error: %v",
+			req.PodNamespace, req.PodName, req.ContainerName, err)
+		return
+	}
+
+	memoryReq := native.MemoryQuantityGetter()(container.Resources.Requests)
+	if memoryReq.IsZero() {
+		return
+	}
+
+	for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+		if hint == nil || !hint.Preferred {
+			continue
+		}
+
+		var freeMemory float64
+		for _, nodeID := range hint.Nodes {
+			data, err := p.getNUMAMetric(int(nodeID), coreconsts.MetricMemFreeNuma)
+			if err != nil {
+				logger.Errorf("get metric: %s of NUMA: %d failed with error: %v, skip memory co-allocation",
+					coreconsts.MetricMemFreeNuma, nodeID, err)
+				return
+			}
+			freeMemory += data.Value
+		}
+
+		if freeMemory < float64(memoryReq.Value()) {
+			logger.Infof("pod: %s/%s, container: %s demote hint: %v since free memory: %.0f is smaller than request: %d",
+				req.PodNamespace, req.PodName, req.ContainerName, hint.Nodes, freeMemory, memoryReq.Value())
+			hint.Preferred = false
+		}
+	}
+}
+
+// clearContainerAndRegenerateMachineState removes the record of the given container from podEntries
+// (if it's safe to do so), and generates machine state from the remaining entries; it's used when
+// hints can't be regenerated from the existing allocation and must be re-calculated.
+func (p *DynamicPolicy) clearContainerAndRegenerateMachineState(podEntries state.PodEntries,
+	allocationInfo *state.AllocationInfo, req *pluginapi.ResourceRequest,
+) (state.NUMANodeMap, error) {
+	// cpus of a container that is still running with its allocation are actually in use,
+	// so keep them accounted in machine state to avoid handing them out to others.
+	keepAllocation := p.isContainerRunningWithAllocation(allocationInfo, req)
+
+	general.Warningf("pod: %s/%s, podUID: %s, container: %s, allocation: %s, keepAllocation: %v, "+
+		"clear container record and re-calculate hints", req.PodNamespace, req.PodName, req.PodUid,
+		req.ContainerName, allocationInfo.AllocationResult.String(), keepAllocation)
+	_ = p.emitter.StoreInt64(util.MetricNameClearContainerAndRecompute, 1, metrics.MetricTypeNameCount,
+		metrics.MetricTag{Key: "keepAllocation", Val: strconv.FormatBool(keepAllocation)})
+
+	if !keepAllocation {
+		p.recordPreviousNUMAs(allocationInfo)
+		delete(podEntries[req.PodUid], req.ContainerName)
+		if len(podEntries[req.PodUid]) == 0 {
+			delete(podEntries, req.PodUid)
+		}
+	}
+
+	return p.generateMachineStateWithRetry(podEntries)
+}
+
+// isContainerRunningWithAllocation returns true if the container still runs on
+// a non-empty allocation according to the pod status in metaServer.
+func (p *DynamicPolicy) isContainerRunningWithAllocation(allocationInfo *state.AllocationInfo,
+	req *pluginapi.ResourceRequest,
+) bool {
+	if allocationInfo == nil || allocationInfo.AllocationResult.IsEmpty() ||
+		p.metaServer == nil || p.metaServer.PodFetcher == nil {
+		return false
+	}
+
+	pod, err := p.metaServer.GetPod(context.Background(), req.PodUid)
+	if err != nil {
+		general.Warningf("get pod: %s/%s failed with error: %v", req.PodNamespace, req.PodName, err)
+		return false
+	} else if !native.PodIsActive(pod) {
+		return false
+	}
+
+	notRunning, err := native.CheckContainerNotRunning(pod, req.ContainerName)
+	if err != nil {
+		general.Warningf("check pod: %s/%s, container: %s running failed with error: %v",
+			req.PodNamespace, req.PodName, req.ContainerName, err)
+		return false
+	}
+	return !notRunning
 }
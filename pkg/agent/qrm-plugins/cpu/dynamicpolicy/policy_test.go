@@ -20,39 +20,68 @@ limitations under the License.
 package dynamicpolicy
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/klog/v2"
 	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+	"k8s.io/kubernetes/pkg/kubelet/checkpointmanager"
 	utilfs "k8s.io/kubernetes/pkg/util/filesystem"
 
 	"github.com/kubewharf/katalyst-api/pkg/consts"
+	katalystbase "github.com/kubewharf/katalyst-core/cmd/base"
+	appagent "github.com/kubewharf/katalyst-core/cmd/katalyst-agent/app/agent"
+	"github.com/kubewharf/katalyst-core/cmd/katalyst-agent/app/options"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
 	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/calculator"
 	advisorapi "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpuadvisor"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/placementadvisor"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/validator"
+	memconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/memory/consts"
+	memorystate "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/memory/dynamicpolicy/state"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/client/control"
 	"github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic"
 	"github.com/kubewharf/katalyst-core/pkg/config/generic"
+	coreconsts "github.com/kubewharf/katalyst-core/pkg/consts"
 	"github.com/kubewharf/katalyst-core/pkg/metaserver"
 	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/metric"
 	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/pod"
+	dynamicconfig "github.com/kubewharf/katalyst-core/pkg/metaserver/kcc"
 	"github.com/kubewharf/katalyst-core/pkg/metrics"
 	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
 	cgroupcm "github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
 	cgroupcmutils "github.com/kubewharf/katalyst-core/pkg/util/cgroup/manager"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
 	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+	utilmetric "github.com/kubewharf/katalyst-core/pkg/util/metric"
 )
 
 const (
@@ -101,6 +130,9 @@ func getTestDynamicPolicyWithoutInitialization(topology *machine.CPUTopology, st
 		reservedCPUs:     reservedCPUs,
 		emitter:          metrics.DummyMetrics{},
 		podDebugAnnoKeys: []string{podDebugAnnoKey},
+
+		machineStateGenerator: generateMachineStateFromPodEntries,
+		numaAdmissionMutexes:  newNUMAAdmissionMutexes(topology.CPUDetails.NUMANodes()),
 	}
 
 	state.SetContainerRequestedCores(policyImplement.getContainerRequestedCores)
@@ -128,6 +160,85 @@ func getTestDynamicPolicyWithoutInitialization(topology *machine.CPUTopology, st
 	return policyImplement, nil
 }
 
+// testRequest describes the cpu request of a main container in tests, and it's built by newTestRequest
+type testRequest struct {
+	podUID         string
+	podNamespace   string
+	podName        string
+	containerName  string
+	containerIndex uint64
+	quantity       float64
+	hint           *pluginapi.TopologyHint
+
+	// qosLevel is set to both labels and annotations of the pod, unless noQoSLabel is set
+	qosLevel          string
+	noQoSLabel        bool
+	memoryEnhancement string
+	cpuEnhancement    string
+	labels            map[string]string
+	annotations       map[string]string
+}
+
+// newTestRequest builds the cpu request described by r, a random pod uid is generated if it's not set,
+// and pod namespace, pod name and container name default to "test"
+func newTestRequest(r testRequest) *pluginapi.ResourceRequest {
+	const defaultName = "test"
+
+	podUID := r.podUID
+	if podUID == "" {
+		podUID = string(uuid.NewUUID())
+	}
+	podNamespace := r.podNamespace
+	if podNamespace == "" {
+		podNamespace = defaultName
+	}
+	podName := r.podName
+	if podName == "" {
+		podName = defaultName
+	}
+	containerName := r.containerName
+	if containerName == "" {
+		containerName = defaultName
+	}
+
+	annotations := r.annotations
+	if r.qosLevel != "" || r.memoryEnhancement != "" || r.cpuEnhancement != "" {
+		annotations = general.MergeMap(r.annotations, nil)
+		if r.qosLevel != "" {
+			annotations[consts.PodAnnotationQoSLevelKey] = r.qosLevel
+		}
+		if r.memoryEnhancement != "" {
+			annotations[consts.PodAnnotationMemoryEnhancementKey] = r.memoryEnhancement
+		}
+		if r.cpuEnhancement != "" {
+			annotations[consts.PodAnnotationCPUEnhancementKey] = r.cpuEnhancement
+		}
+	}
+
+	labels := r.labels
+	if r.qosLevel != "" && !r.noQoSLabel {
+		labels = general.MergeMap(r.labels, map[string]string{
+			consts.PodAnnotationQoSLevelKey: r.qosLevel,
+		})
+	}
+
+	return &pluginapi.ResourceRequest{
+		PodUid:         podUID,
+		PodNamespace:   podNamespace,
+		PodName:        podName,
+		ContainerName:  containerName,
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: r.containerIndex,
+		ResourceName:   string(v1.ResourceCPU),
+		Hint:           r.hint,
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): r.quantity,
+		},
+		Labels:      labels,
+		Annotations: annotations,
+	}
+}
+
 func TestInitPoolAndCalculator(t *testing.T) {
 	t.Parallel()
 
@@ -1497,7 +1608,7 @@ func TestGetTopologyHints(t *testing.T) {
 							},
 							{
 								Nodes:     []uint64{3},
-								Preferred: true,
+								Preferred: false,
 							},
 						},
 					},
@@ -2023,7 +2134,7 @@ func TestGetTopologyHints(t *testing.T) {
 							},
 							{
 								Nodes:     []uint64{3},
-								Preferred: true,
+								Preferred: false,
 							},
 						},
 					},
@@ -2302,11 +2413,11 @@ func TestGetTopologyHints(t *testing.T) {
 							},
 							{
 								Nodes:     []uint64{2},
-								Preferred: true,
+								Preferred: false,
 							},
 							{
 								Nodes:     []uint64{3},
-								Preferred: true,
+								Preferred: false,
 							},
 						},
 					},
@@ -2378,16 +2489,19 @@ func TestGetTopologyAwareAllocatableResources(t *testing.T) {
 	resp, err := dynamicPolicy.GetTopologyAwareAllocatableResources(context.Background(), &pluginapi.GetTopologyAwareAllocatableResourcesRequest{})
 	as.Nil(err)
 
+	freeNUMAAnnotations := map[string]string{
+		cpuconsts.TopologyAwareQuantityAnnotationKeyNUMAOccupancy: cpuconsts.NUMAOccupancyFree,
+	}
 	as.Equal(&pluginapi.GetTopologyAwareAllocatableResourcesResponse{
 		AllocatableResources: map[string]*pluginapi.AllocatableTopologyAwareResource{
 			string(v1.ResourceCPU): {
 				IsNodeResource:   false,
 				IsScalarResource: true,
 				TopologyAwareAllocatableQuantityList: []*pluginapi.TopologyAwareQuantity{
-					{ResourceValue: 3, Node: 0},
-					{ResourceValue: 3, Node: 1},
-					{ResourceValue: 4, Node: 2},
-					{ResourceValue: 4, Node: 3},
+					{ResourceValue: 3, Node: 0, Annotations: freeNUMAAnnotations},
+					{ResourceValue: 3, Node: 1, Annotations: freeNUMAAnnotations},
+					{ResourceValue: 4, Node: 2, Annotations: freeNUMAAnnotations},
+					{ResourceValue: 4, Node: 3, Annotations: freeNUMAAnnotations},
 				},
 				TopologyAwareCapacityQuantityList: []*pluginapi.TopologyAwareQuantity{
 					{ResourceValue: 4, Node: 0},
@@ -2402,6 +2516,64 @@ func TestGetTopologyAwareAllocatableResources(t *testing.T) {
 	}, resp)
 }
 
+func TestGetTopologyAwareAllocatableResourcesWithReservationChange(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestGetTopologyAwareAllocatableResourcesWithReservationChange")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	getAllocatable := func() *pluginapi.AllocatableTopologyAwareResource {
+		resp, err := dynamicPolicy.GetTopologyAwareAllocatableResources(context.Background(),
+			&pluginapi.GetTopologyAwareAllocatableResourcesRequest{})
+		as.Nil(err)
+		return resp.AllocatableResources[string(v1.ResourceCPU)]
+	}
+
+	as.Equal(float64(14), getAllocatable().AggregatedAllocatableQuantity)
+
+	// reserved cpus grow with the whole NUMA 2
+	reservedCPUs := dynamicPolicy.GetReservedCPUs().Union(cpuTopology.CPUDetails.CPUsInNUMANodes(2))
+	as.Nil(dynamicPolicy.SetReservedCPUs(reservedCPUs))
+
+	allocatable := getAllocatable()
+	as.Equal(float64(10), allocatable.AggregatedAllocatableQuantity)
+	as.Equal(float64(16), allocatable.AggregatedCapacityQuantity)
+	as.Equal(&pluginapi.TopologyAwareQuantity{
+		ResourceValue: 0,
+		Node:          2,
+		Annotations: map[string]string{
+			cpuconsts.TopologyAwareQuantityAnnotationKeyNUMAOccupancy: cpuconsts.NUMAOccupancyFree,
+		},
+	}, allocatable.TopologyAwareAllocatableQuantityList[2])
+
+	reserveAllocationInfo := dynamicPolicy.state.GetAllocationInfo(state.PoolNameReserve, state.FakedContainerName)
+	as.NotNil(reserveAllocationInfo)
+	as.True(reserveAllocationInfo.AllocationResult.Equals(reservedCPUs))
+
+	// offline cpus are excluded from allocatable as well
+	offlineCPU := cpuTopology.CPUDetails.CPUsInNUMANodes(3).ToSliceInt()[0]
+	as.Nil(dynamicPolicy.SetOfflineCPUs(machine.NewCPUSet(offlineCPU)))
+
+	allocatable = getAllocatable()
+	as.Equal(float64(9), allocatable.AggregatedAllocatableQuantity)
+	as.Equal(&pluginapi.TopologyAwareQuantity{
+		ResourceValue: 3,
+		Node:          3,
+		Annotations: map[string]string{
+			cpuconsts.TopologyAwareQuantityAnnotationKeyNUMAOccupancy: cpuconsts.NUMAOccupancyFree,
+		},
+	}, allocatable.TopologyAwareAllocatableQuantityList[3])
+}
+
 func TestGetTopologyAwareResources(t *testing.T) {
 	t.Parallel()
 
@@ -4463,3 +4635,8390 @@ func TestShoudSharedCoresRampUp(t *testing.T) {
 	as.Equal(false, allocationInfo.RampUp)
 	as.Equal(allocationInfo.OwnerPoolName, state.PoolNameShare)
 }
+
+func TestDemoteHintsByMemoryAvailability(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	testName := "test"
+	podUID := uuid.NewUUID()
+
+	testCases := []struct {
+		description                 string
+		enableCPUMemoryCoAllocation bool
+		expectedHints               []*pluginapi.TopologyHint
+	}{
+		{
+			description:                 "co-allocation disabled",
+			enableCPUMemoryCoAllocation: false,
+			expectedHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: false},
+				{Nodes: []uint64{1}, Preferred: false},
+				{Nodes: []uint64{2}, Preferred: true},
+				{Nodes: []uint64{3}, Preferred: true},
+			},
+		},
+		{
+			description:                 "co-allocation enabled with insufficient memory on NUMA 2",
+			enableCPUMemoryCoAllocation: true,
+			expectedHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: false},
+				{Nodes: []uint64{1}, Preferred: false},
+				{Nodes: []uint64{2}, Preferred: false},
+				{Nodes: []uint64{3}, Preferred: true},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tmpDir, err := ioutil.TempDir("", "checkpoint-TestDemoteHintsByMemoryAvailability")
+		as.Nil(err)
+
+		dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+		as.Nil(err)
+
+		dynamicPolicy.enableCPUMemoryCoAllocation = tc.enableCPUMemoryCoAllocation
+		// keep all NUMAs with the most available cpus preferred, so that demotion can be observed
+		dynamicPolicy.cpuNUMAHintPreferTieBreak = cpuconsts.CPUNUMAHintPreferTieBreakNone
+
+		metricsFetcher := metric.NewFakeMetricsFetcher(metrics.DummyMetrics{}).(*metric.FakeMetricsFetcher)
+		for numaID := 0; numaID < cpuTopology.NumNUMANodes; numaID++ {
+			freeMemory := 10 * 1024 * 1024 * 1024.
+			if numaID == 2 {
+				freeMemory = 1024 * 1024 * 1024.
+			}
+			metricsFetcher.SetNumaMetric(numaID, coreconsts.MetricMemFreeNuma, utilmetric.MetricData{Value: freeMemory})
+		}
+
+		dynamicPolicy.metaServer = &metaserver.MetaServer{
+			MetaAgent: &agent.MetaAgent{
+				PodFetcher: &pod.PodFetcherStub{
+					PodList: []*v1.Pod{
+						{
+							ObjectMeta: metav1.ObjectMeta{
+								Name:      testName,
+								Namespace: testName,
+								UID:       podUID,
+							},
+							Spec: v1.PodSpec{
+								Containers: []v1.Container{
+									{
+										Name: testName,
+										Resources: v1.ResourceRequirements{
+											Requests: v1.ResourceList{
+												v1.ResourceCPU:    resource.MustParse("1"),
+												v1.ResourceMemory: resource.MustParse("2Gi"),
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				MetricsFetcher: metricsFetcher,
+			},
+		}
+
+		req := &pluginapi.ResourceRequest{
+			PodUid:         string(podUID),
+			PodNamespace:   testName,
+			PodName:        testName,
+			ContainerName:  testName,
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): 1,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelSharedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+			},
+		}
+
+		resp, err := dynamicPolicy.GetTopologyHints(context.Background(), req)
+		as.Nilf(err, "failed in test case: %s", tc.description)
+		as.Equalf(tc.expectedHints, resp.ResourceHints[string(v1.ResourceCPU)].Hints,
+			"failed in test case: %s", tc.description)
+
+		_ = os.RemoveAll(tmpDir)
+	}
+}
+
+func TestDemoteHintsByThrottledNUMAs(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	testName := "test"
+	testCases := []struct {
+		description       string
+		throttleThreshold float64
+		tempThreshold     float64
+		throttleRatios    map[int]float64
+		temperatures      map[int]float64
+		expectedHints     []*pluginapi.TopologyHint
+	}{
+		{
+			description:    "throttle awareness disabled",
+			throttleRatios: map[int]float64{2: 0.9},
+			expectedHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: false},
+				{Nodes: []uint64{1}, Preferred: false},
+				{Nodes: []uint64{2}, Preferred: true},
+				{Nodes: []uint64{3}, Preferred: true},
+			},
+		},
+		{
+			description:       "NUMA 2 throttled by frequency",
+			throttleThreshold: 0.5,
+			throttleRatios:    map[int]float64{0: 0.9, 1: 0, 2: 0.9, 3: 0.1},
+			expectedHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: false},
+				{Nodes: []uint64{1}, Preferred: false},
+				{Nodes: []uint64{2}, Preferred: false},
+				{Nodes: []uint64{3}, Preferred: true},
+			},
+		},
+		{
+			description:   "NUMA 3 throttled by temperature with metrics of NUMA 2 missing",
+			tempThreshold: 90,
+			temperatures:  map[int]float64{3: 95},
+			expectedHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: false},
+				{Nodes: []uint64{1}, Preferred: false},
+				{Nodes: []uint64{2}, Preferred: true},
+				{Nodes: []uint64{3}, Preferred: false},
+			},
+		},
+		{
+			description:       "all preferred NUMAs throttled",
+			throttleThreshold: 0.5,
+			tempThreshold:     90,
+			throttleRatios:    map[int]float64{2: 0.9},
+			temperatures:      map[int]float64{3: 95},
+			expectedHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: false},
+				{Nodes: []uint64{1}, Preferred: false},
+				{Nodes: []uint64{2}, Preferred: true},
+				{Nodes: []uint64{3}, Preferred: true},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tmpDir, err := ioutil.TempDir("", "checkpoint-TestDemoteHintsByThrottledNUMAs")
+		as.Nil(err)
+
+		dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+		as.Nil(err)
+
+		dynamicPolicy.numaThrottleRatioThreshold = tc.throttleThreshold
+		dynamicPolicy.numaTemperatureThreshold = tc.tempThreshold
+		// keep all NUMAs with the most available cpus preferred, so that demotion can be observed
+		dynamicPolicy.cpuNUMAHintPreferTieBreak = cpuconsts.CPUNUMAHintPreferTieBreakNone
+
+		metricsFetcher := metric.NewFakeMetricsFetcher(metrics.DummyMetrics{}).(*metric.FakeMetricsFetcher)
+		for numaID, ratio := range tc.throttleRatios {
+			metricsFetcher.SetNumaMetric(numaID, coreconsts.MetricCPUThrottleRatioNuma, utilmetric.MetricData{Value: ratio})
+		}
+		for numaID, temperature := range tc.temperatures {
+			metricsFetcher.SetNumaMetric(numaID, coreconsts.MetricCPUTemperatureNuma, utilmetric.MetricData{Value: temperature})
+		}
+		dynamicPolicy.metaServer = &metaserver.MetaServer{
+			MetaAgent: &agent.MetaAgent{
+				PodFetcher:     &pod.PodFetcherStub{},
+				MetricsFetcher: metricsFetcher,
+			},
+		}
+
+		req := &pluginapi.ResourceRequest{
+			PodUid:         string(uuid.NewUUID()),
+			PodNamespace:   testName,
+			PodName:        testName,
+			ContainerName:  testName,
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): 1,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelSharedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+			},
+		}
+
+		resp, err := dynamicPolicy.GetTopologyHints(context.Background(), req)
+		as.Nilf(err, "failed in test case: %s", tc.description)
+		as.Equalf(tc.expectedHints, resp.ResourceHints[string(v1.ResourceCPU)].Hints,
+			"failed in test case: %s", tc.description)
+
+		_ = os.RemoveAll(tmpDir)
+	}
+}
+
+func TestDemoteHintsWithUnavailableMetricStore(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestDemoteHintsWithUnavailableMetricStore")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.numaThrottleRatioThreshold = 0.5
+	// keep all NUMAs with the most available cpus preferred, so that demotion can be observed
+	dynamicPolicy.cpuNUMAHintPreferTieBreak = cpuconsts.CPUNUMAHintPreferTieBreakNone
+
+	metricsFetcher := metric.NewFakeMetricsFetcher(metrics.DummyMetrics{}).(*metric.FakeMetricsFetcher)
+	metricsFetcher.SetNumaMetric(2, coreconsts.MetricCPUThrottleRatioNuma, utilmetric.MetricData{Value: 0.9})
+
+	testName := "test"
+	getHints := func() []*pluginapi.TopologyHint {
+		resp, err := dynamicPolicy.GetTopologyHints(context.Background(), &pluginapi.ResourceRequest{
+			PodUid:         string(uuid.NewUUID()),
+			PodNamespace:   testName,
+			PodName:        testName,
+			ContainerName:  testName,
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): 1,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelSharedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+			},
+		})
+		as.Nil(err)
+		return resp.ResourceHints[string(v1.ResourceCPU)].Hints
+	}
+
+	cpuOnlyHints := []*pluginapi.TopologyHint{
+		{Nodes: []uint64{0}, Preferred: false},
+		{Nodes: []uint64{1}, Preferred: false},
+		{Nodes: []uint64{2}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: true},
+	}
+
+	testCases := []struct {
+		description string
+		metaServer  *metaserver.MetaServer
+	}{
+		{
+			description: "nil metric store",
+			metaServer: &metaserver.MetaServer{
+				MetaAgent: &agent.MetaAgent{PodFetcher: &pod.PodFetcherStub{}},
+			},
+		},
+		{
+			description: "metric store not synced",
+			metaServer: &metaserver.MetaServer{
+				MetaAgent: &agent.MetaAgent{PodFetcher: &pod.PodFetcherStub{}, MetricsFetcher: metricsFetcher},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		metricsFetcher.SetSynced(false)
+		dynamicPolicy.metaServer = tc.metaServer
+
+		as.Equalf(cpuOnlyHints, getHints(), "failed in test case: %s", tc.description)
+		as.Truef(dynamicPolicy.metricStoreUnavailable.Load(), "failed in test case: %s", tc.description)
+
+		_, err = dynamicPolicy.getNUMAMetric(2, coreconsts.MetricCPUThrottleRatioNuma)
+		as.Equalf(errMetricStoreUnavailable, err, "failed in test case: %s", tc.description)
+	}
+
+	// throttled NUMA is demoted again once the metric store recovers
+	metricsFetcher.SetSynced(true)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{0}, Preferred: false},
+		{Nodes: []uint64{1}, Preferred: false},
+		{Nodes: []uint64{2}, Preferred: false},
+		{Nodes: []uint64{3}, Preferred: true},
+	}, getHints())
+	as.False(dynamicPolicy.metricStoreUnavailable.Load())
+}
+
+func TestPreferPreviousNUMAsAfterContainerRestart(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestPreferPreviousNUMAsAfterContainerRestart")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.previousNUMAStickyTTL = time.Minute
+
+	testName := "test"
+	podUID := string(uuid.NewUUID())
+	newRequest := func(quantity float64, hint *pluginapi.TopologyHint) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			podUID:            podUID,
+			qosLevel:          consts.PodAnnotationQoSLevelDedicatedCores,
+			memoryEnhancement: `{"numa_binding": "true"}`,
+			quantity:          quantity,
+			hint:              hint,
+		})
+	}
+
+	_, err = dynamicPolicy.Allocate(context.Background(), newRequest(2, &pluginapi.TopologyHint{Nodes: []uint64{3}, Preferred: true}))
+	as.Nil(err)
+
+	// the container restarts with a larger request, so its allocation can't be regenerated and is cleared,
+	// NUMAs 1-3 all fit the request but it returns to NUMA 3 within the TTL
+	resp, err := dynamicPolicy.GetTopologyHints(context.Background(), newRequest(3, nil))
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{1}, Preferred: false},
+		{Nodes: []uint64{2}, Preferred: false},
+		{Nodes: []uint64{3}, Preferred: true},
+	}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+	// the record isn't refreshed by calculating hints again, and normal policy applies after it expires
+	dynamicPolicy.previousNUMAs[podUID][testName].recordTime = time.Now().Add(-2 * time.Minute)
+	resp, err = dynamicPolicy.GetTopologyHints(context.Background(), newRequest(3, nil))
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{1}, Preferred: true},
+		{Nodes: []uint64{2}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: true},
+	}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+	// the record is dropped once the container is allocated again
+	_, err = dynamicPolicy.Allocate(context.Background(), newRequest(3, &pluginapi.TopologyHint{Nodes: []uint64{3}, Preferred: true}))
+	as.Nil(err)
+	_, ok := dynamicPolicy.getPreviousNUMAs(podUID, testName)
+	as.False(ok)
+	as.Nil(dynamicPolicy.previousNUMAs[podUID])
+}
+
+func TestWatchAllocations(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestWatchAllocations")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	events, cancel := dynamicPolicy.WatchAllocations(10)
+	// the slow watcher never consumes its events, and it mustn't block admissions
+	slowEvents, slowCancel := dynamicPolicy.WatchAllocations(1)
+
+	testName := "test"
+	podUID := string(uuid.NewUUID())
+	newRequest := func(quantity float64) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			podUID:            podUID,
+			qosLevel:          consts.PodAnnotationQoSLevelDedicatedCores,
+			memoryEnhancement: `{"numa_binding": "true"}`,
+			quantity:          quantity,
+			hint:              &pluginapi.TopologyHint{Nodes: []uint64{1}, Preferred: true},
+		})
+	}
+	nextEvent := func() *AllocationEvent {
+		select {
+		case event := <-events:
+			return event
+		default:
+			as.FailNow("no allocation event is delivered")
+			return nil
+		}
+	}
+
+	_, err = dynamicPolicy.Allocate(context.Background(), newRequest(2))
+	as.Nil(err)
+	allocated := dynamicPolicy.state.GetAllocationInfo(podUID, testName).AllocationResult.Clone()
+
+	event := nextEvent()
+	as.Equal(AllocationEventTypeAllocate, event.Type)
+	as.Equal(podUID, event.PodUID)
+	as.Equal(testName, event.ContainerName)
+	as.True(event.OldCPUSet.IsEmpty())
+	as.True(event.OldNUMAs.IsEmpty())
+	as.Equal(allocated, event.NewCPUSet)
+	as.Equal(machine.NewCPUSet(1), event.NewNUMAs)
+
+	// the container is resized within NUMA 1
+	_, err = dynamicPolicy.Allocate(context.Background(), newRequest(4))
+	as.Nil(err)
+
+	event = nextEvent()
+	as.Equal(AllocationEventTypeResize, event.Type)
+	as.Equal(allocated, event.OldCPUSet)
+	as.Equal(machine.NewCPUSet(1), event.OldNUMAs)
+	as.Equal(cpuTopology.CPUDetails.CPUsInNUMANodes(1), event.NewCPUSet)
+	as.Equal(machine.NewCPUSet(1), event.NewNUMAs)
+
+	// allocating with the same request again doesn't change the cpuset, so no event is sent
+	_, err = dynamicPolicy.Allocate(context.Background(), newRequest(4))
+	as.Nil(err)
+	as.Len(events, 0)
+
+	_, err = dynamicPolicy.RemovePod(context.Background(), &pluginapi.RemovePodRequest{PodUid: podUID})
+	as.Nil(err)
+
+	event = nextEvent()
+	as.Equal(AllocationEventTypeRelease, event.Type)
+	as.Equal(cpuTopology.CPUDetails.CPUsInNUMANodes(1), event.OldCPUSet)
+	as.True(event.NewCPUSet.IsEmpty())
+	as.True(event.NewNUMAs.IsEmpty())
+
+	// the slow watcher only gets the first event, and the others are dropped for it
+	as.Len(slowEvents, 1)
+	as.Equal(AllocationEventTypeAllocate, (<-slowEvents).Type)
+
+	cancel()
+	slowCancel()
+	_, ok := <-events
+	as.False(ok)
+	// cancelling twice is a no-op
+	cancel()
+}
+
+func TestUnclassifiedPodPolicy(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name             string
+		policy           string
+		expectedErr      bool
+		expectedQoSLevel string
+	}{
+		{
+			name:             "treated as shared_cores by default",
+			policy:           "",
+			expectedQoSLevel: consts.PodAnnotationQoSLevelSharedCores,
+		},
+		{
+			name:             "treated as shared_cores",
+			policy:           cpuconsts.UnclassifiedPodPolicySharedCores,
+			expectedQoSLevel: consts.PodAnnotationQoSLevelSharedCores,
+		},
+		{
+			name:             "treated as reclaimed_cores",
+			policy:           cpuconsts.UnclassifiedPodPolicyReclaimedCores,
+			expectedQoSLevel: consts.PodAnnotationQoSLevelReclaimedCores,
+		},
+		{
+			name:        "rejected",
+			policy:      cpuconsts.UnclassifiedPodPolicyReject,
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+			as.Nil(err)
+
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestUnclassifiedPodPolicy")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.unclassifiedPodPolicy = tc.policy
+
+			testName := "test"
+			newRequest := func(podUID string, annotations map[string]string) *pluginapi.ResourceRequest {
+				return newTestRequest(testRequest{
+					podUID:      podUID,
+					quantity:    2,
+					annotations: annotations,
+				})
+			}
+
+			podUID := string(uuid.NewUUID())
+			_, err = dynamicPolicy.GetTopologyHints(context.Background(), newRequest(podUID, nil))
+			if tc.expectedErr {
+				as.NotNil(err)
+			} else {
+				as.Nil(err)
+			}
+
+			_, err = dynamicPolicy.Allocate(context.Background(), newRequest(podUID, nil))
+			if tc.expectedErr {
+				as.NotNil(err)
+				as.Nil(dynamicPolicy.state.GetAllocationInfo(podUID, testName))
+			} else {
+				as.Nil(err)
+				allocationInfo := dynamicPolicy.state.GetAllocationInfo(podUID, testName)
+				as.NotNil(allocationInfo)
+				as.Equal(tc.expectedQoSLevel, allocationInfo.QoSLevel)
+			}
+
+			// pods with katalyst QoS annotations aren't affected by the policy
+			annotatedPodUID := string(uuid.NewUUID())
+			_, err = dynamicPolicy.Allocate(context.Background(), newRequest(annotatedPodUID, map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+			}))
+			as.Nil(err)
+			as.Equal(consts.PodAnnotationQoSLevelSharedCores,
+				dynamicPolicy.state.GetAllocationInfo(annotatedPodUID, testName).QoSLevel)
+		})
+	}
+}
+
+func TestSidecarNUMABindingMismatchPolicy(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	numaBindingAnnotations := func(qosLevel string) map[string]string {
+		return map[string]string{
+			consts.PodAnnotationQoSLevelKey:          qosLevel,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+		}
+	}
+	generateReq := func(podUID, containerName string, containerType pluginapi.ContainerType,
+		reqQuantity float64, annotations map[string]string,
+	) *pluginapi.ResourceRequest {
+		req := newTestRequest(testRequest{
+			podUID:        podUID,
+			containerName: containerName,
+			annotations:   annotations,
+			quantity:      reqQuantity,
+			hint:          &pluginapi.TopologyHint{Nodes: []uint64{0}, Preferred: true},
+		})
+		req.ContainerType = containerType
+		return req
+	}
+
+	testCases := []struct {
+		name               string
+		policy             string
+		mainAnnotations    map[string]string
+		sidecarAnnotations map[string]string
+		expectedErr        bool
+		expectedNUMABound  bool
+	}{
+		{
+			name:               "numa_binding sidecar of shared_cores main follows main by default",
+			mainAnnotations:    map[string]string{consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores},
+			sidecarAnnotations: numaBindingAnnotations(consts.PodAnnotationQoSLevelSharedCores),
+		},
+		{
+			name:               "numa_binding sidecar of shared_cores main follows main",
+			policy:             cpuconsts.SidecarNUMABindingMismatchPolicyFollowMain,
+			mainAnnotations:    map[string]string{consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores},
+			sidecarAnnotations: numaBindingAnnotations(consts.PodAnnotationQoSLevelSharedCores),
+		},
+		{
+			name:               "numa_binding sidecar of shared_cores main is rejected",
+			policy:             cpuconsts.SidecarNUMABindingMismatchPolicyReject,
+			mainAnnotations:    map[string]string{consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores},
+			sidecarAnnotations: numaBindingAnnotations(consts.PodAnnotationQoSLevelSharedCores),
+			expectedErr:        true,
+		},
+		{
+			name:               "sidecar without numa_binding of dedicated_cores main follows main",
+			policy:             cpuconsts.SidecarNUMABindingMismatchPolicyFollowMain,
+			mainAnnotations:    numaBindingAnnotations(consts.PodAnnotationQoSLevelDedicatedCores),
+			sidecarAnnotations: map[string]string{consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores},
+			expectedNUMABound:  true,
+		},
+		{
+			name:               "sidecar without numa_binding of dedicated_cores main is rejected",
+			policy:             cpuconsts.SidecarNUMABindingMismatchPolicyReject,
+			mainAnnotations:    numaBindingAnnotations(consts.PodAnnotationQoSLevelDedicatedCores),
+			sidecarAnnotations: map[string]string{consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores},
+			expectedErr:        true,
+		},
+		{
+			name:               "sidecar matched with main isn't affected",
+			policy:             cpuconsts.SidecarNUMABindingMismatchPolicyReject,
+			mainAnnotations:    numaBindingAnnotations(consts.PodAnnotationQoSLevelDedicatedCores),
+			sidecarAnnotations: numaBindingAnnotations(consts.PodAnnotationQoSLevelDedicatedCores),
+			expectedNUMABound:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestSidecarNUMABindingMismatchPolicy")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.sidecarBindingMismatchPolicy = tc.policy
+
+			podUID := string(uuid.NewUUID())
+			_, err = dynamicPolicy.Allocate(context.Background(),
+				generateReq(podUID, "main", pluginapi.ContainerType_MAIN, 2, tc.mainAnnotations))
+			as.Nil(err)
+
+			_, err = dynamicPolicy.GetTopologyHints(context.Background(),
+				generateReq(podUID, "sidecar", pluginapi.ContainerType_SIDECAR, 1, tc.sidecarAnnotations))
+			if tc.expectedErr {
+				as.NotNil(err)
+			} else {
+				as.Nil(err)
+			}
+
+			_, err = dynamicPolicy.Allocate(context.Background(),
+				generateReq(podUID, "sidecar", pluginapi.ContainerType_SIDECAR, 1, tc.sidecarAnnotations))
+			sidecarAllocationInfo := dynamicPolicy.state.GetAllocationInfo(podUID, "sidecar")
+			if tc.expectedErr {
+				as.NotNil(err)
+				as.Nil(sidecarAllocationInfo)
+				return
+			}
+			as.Nil(err)
+			as.NotNil(sidecarAllocationInfo)
+			as.Equal(tc.expectedNUMABound, state.CheckNUMABinding(sidecarAllocationInfo))
+			as.Equal(dynamicPolicy.state.GetAllocationInfo(podUID, "main").AllocationResult,
+				sidecarAllocationInfo.AllocationResult)
+		})
+	}
+}
+
+func TestUnknownContainerTypePolicy(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testName := "test"
+	unknownContainerType := pluginapi.ContainerType(len(pluginapi.ContainerType_name) + 100)
+	generateReq := func(podUID string, setContainerType bool) *pluginapi.ResourceRequest {
+		req := newTestRequest(testRequest{
+			podUID:            podUID,
+			qosLevel:          consts.PodAnnotationQoSLevelDedicatedCores,
+			noQoSLabel:        true,
+			memoryEnhancement: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+			quantity:          2,
+			hint:              &pluginapi.TopologyHint{Nodes: []uint64{0}, Preferred: true},
+		})
+		// the container type is left unset, unless setContainerType is set
+		req.ContainerType = pluginapi.ContainerType(0)
+		if setContainerType {
+			req.ContainerType = unknownContainerType
+		}
+		return req
+	}
+
+	testCases := []struct {
+		name                   string
+		policy                 string
+		setContainerType       bool
+		expectedErr            bool
+		expectedNoNUMAPrefer   bool
+		expectedAllocationInfo bool
+	}{
+		{
+			name:                 "unset container type is handled as init container",
+			policy:               cpuconsts.UnknownContainerTypePolicyReject,
+			expectedNoNUMAPrefer: true,
+		},
+		{
+			name:                   "unknown container type is handled as main container by default",
+			setContainerType:       true,
+			expectedAllocationInfo: true,
+		},
+		{
+			name:                   "unknown container type is handled as main container",
+			policy:                 cpuconsts.UnknownContainerTypePolicyMain,
+			setContainerType:       true,
+			expectedAllocationInfo: true,
+		},
+		{
+			name:             "unknown container type is rejected",
+			policy:           cpuconsts.UnknownContainerTypePolicyReject,
+			setContainerType: true,
+			expectedErr:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestUnknownContainerTypePolicy")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.unknownContainerTypePolicy = tc.policy
+
+			podUID := string(uuid.NewUUID())
+			hintsResp, err := dynamicPolicy.GetTopologyHints(context.Background(), generateReq(podUID, tc.setContainerType))
+			if tc.expectedErr {
+				as.NotNil(err)
+			} else {
+				as.Nil(err)
+				as.NotNil(hintsResp)
+				as.Equal(tc.expectedNoNUMAPrefer, hintsResp.ResourceHints[string(v1.ResourceCPU)] == nil)
+			}
+
+			_, err = dynamicPolicy.Allocate(context.Background(), generateReq(podUID, tc.setContainerType))
+			allocationInfo := dynamicPolicy.state.GetAllocationInfo(podUID, testName)
+			if tc.expectedErr {
+				as.NotNil(err)
+				as.Nil(allocationInfo)
+				return
+			}
+			as.Nil(err)
+			if !tc.expectedAllocationInfo {
+				as.Nil(allocationInfo)
+				return
+			}
+			as.NotNil(allocationInfo)
+			as.Equal(pluginapi.ContainerType_MAIN.String(), allocationInfo.ContainerType)
+			as.True(allocationInfo.CheckMainContainer())
+		})
+	}
+}
+
+func TestNUMANamespaceReservations(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	reservedNUMA := 1
+	testName := "test"
+	generateReq := func(namespace, qosLevel string) *pluginapi.ResourceRequest {
+		memoryEnhancement := `{"numa_binding": "true"}`
+		if qosLevel == consts.PodAnnotationQoSLevelDedicatedCores {
+			memoryEnhancement = `{"numa_binding": "true", "numa_exclusive": "true"}`
+		}
+		return newTestRequest(testRequest{
+			podNamespace:      namespace,
+			qosLevel:          qosLevel,
+			noQoSLabel:        true,
+			memoryEnhancement: memoryEnhancement,
+			quantity:          2,
+		})
+	}
+
+	testCases := []struct {
+		name      string
+		namespace string
+		qosLevel  string
+		owner     bool
+	}{
+		{
+			name:      "dedicated_cores of other namespace is kept off the reserved NUMA",
+			namespace: "tenant-b",
+			qosLevel:  consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+		{
+			name:      "shared_cores of other namespace is kept off the reserved NUMA",
+			namespace: "tenant-b",
+			qosLevel:  consts.PodAnnotationQoSLevelSharedCores,
+		},
+		{
+			name:      "dedicated_cores of the namespace prefers the reserved NUMA",
+			namespace: "tenant-a",
+			qosLevel:  consts.PodAnnotationQoSLevelDedicatedCores,
+			owner:     true,
+		},
+		{
+			name:      "shared_cores of the namespace prefers the reserved NUMA",
+			namespace: "tenant-a",
+			qosLevel:  consts.PodAnnotationQoSLevelSharedCores,
+			owner:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestNUMANamespaceReservations")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.numaNamespaceReservations = map[int]string{reservedNUMA: "tenant-a"}
+
+			req := generateReq(tc.namespace, tc.qosLevel)
+			resp, err := dynamicPolicy.GetTopologyHints(context.Background(), req)
+			as.Nil(err)
+
+			hints := resp.ResourceHints[string(v1.ResourceCPU)].Hints
+			as.NotEmpty(hints)
+			for _, hint := range hints {
+				if !tc.owner {
+					as.NotContains(hint.Nodes, uint64(reservedNUMA))
+				} else if hint.Preferred {
+					as.Equal([]uint64{uint64(reservedNUMA)}, hint.Nodes)
+				}
+			}
+			as.True(hasPreferredHint(hints))
+
+			req = generateReq(tc.namespace, tc.qosLevel)
+			req.Hint = hints[0]
+			for _, hint := range hints {
+				if hint.Preferred {
+					req.Hint = hint
+					break
+				}
+			}
+			_, err = dynamicPolicy.Allocate(context.Background(), req)
+			as.Nil(err)
+
+			allocationInfo := dynamicPolicy.state.GetAllocationInfo(req.PodUid, testName)
+			as.NotNil(allocationInfo)
+			as.Equal(tc.owner, allocationInfo.GetAllocationResultNUMASet().Contains(reservedNUMA))
+		})
+	}
+}
+
+func TestPreferMemoryAllocatedNUMAs(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	memoryNUMA := 1
+	testName := "test"
+	generateReq := func(podUID, qosLevel string) *pluginapi.ResourceRequest {
+		memoryEnhancement := `{"numa_binding": "true"}`
+		if qosLevel == consts.PodAnnotationQoSLevelDedicatedCores {
+			memoryEnhancement = `{"numa_binding": "true", "numa_exclusive": "true"}`
+		}
+		return newTestRequest(testRequest{
+			podUID:            podUID,
+			qosLevel:          qosLevel,
+			noQoSLabel:        true,
+			memoryEnhancement: memoryEnhancement,
+			quantity:          2,
+		})
+	}
+
+	// writeMemoryCheckpoint pre-places memory of the pod on the NUMA in checkpoint of memory plugin
+	writeMemoryCheckpoint := func(stateDir, podUID string, numaBinding bool) error {
+		checkpointManager, err := checkpointmanager.NewCheckpointManager(stateDir)
+		if err != nil {
+			return err
+		}
+
+		annotations := map[string]string{}
+		if numaBinding {
+			annotations[consts.PodAnnotationMemoryEnhancementNumaBinding] = consts.PodAnnotationMemoryEnhancementNumaBindingEnable
+		}
+
+		checkpoint := memorystate.NewMemoryPluginCheckpoint()
+		checkpoint.PolicyName = memconsts.MemoryResourcePluginPolicyNameDynamic
+		checkpoint.PodResourceEntries[v1.ResourceMemory] = memorystate.PodEntries{
+			podUID: memorystate.ContainerEntries{
+				testName: &memorystate.AllocationInfo{
+					PodUid:               podUID,
+					PodNamespace:         testName,
+					PodName:              testName,
+					ContainerName:        testName,
+					ContainerType:        pluginapi.ContainerType_MAIN.String(),
+					AggregatedQuantity:   1 << 30,
+					NumaAllocationResult: machine.NewCPUSet(memoryNUMA),
+					Annotations:          annotations,
+				},
+			},
+		}
+		return checkpointManager.CreateCheckpoint(memconsts.MemoryPluginStateFileName, checkpoint)
+	}
+
+	testCases := []struct {
+		name            string
+		qosLevel        string
+		enabled         bool
+		memoryAllocated bool
+		numaBinding     bool
+		preferMemory    bool
+	}{
+		{
+			name:            "dedicated_cores prefers the NUMA of allocated memory",
+			qosLevel:        consts.PodAnnotationQoSLevelDedicatedCores,
+			enabled:         true,
+			memoryAllocated: true,
+			numaBinding:     true,
+			preferMemory:    true,
+		},
+		{
+			name:            "shared_cores prefers the NUMA of allocated memory",
+			qosLevel:        consts.PodAnnotationQoSLevelSharedCores,
+			enabled:         true,
+			memoryAllocated: true,
+			numaBinding:     true,
+			preferMemory:    true,
+		},
+		{
+			name:            "memory isn't allocated yet",
+			qosLevel:        consts.PodAnnotationQoSLevelDedicatedCores,
+			enabled:         true,
+			memoryAllocated: false,
+		},
+		{
+			name:            "memory isn't allocated with numa_binding",
+			qosLevel:        consts.PodAnnotationQoSLevelDedicatedCores,
+			enabled:         true,
+			memoryAllocated: true,
+			numaBinding:     false,
+		},
+		{
+			name:            "disabled",
+			qosLevel:        consts.PodAnnotationQoSLevelDedicatedCores,
+			enabled:         false,
+			memoryAllocated: true,
+			numaBinding:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestPreferMemoryAllocatedNUMAs")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			// memory plugin shares the state file directory with cpu plugin
+			dynamicPolicy.memoryNUMAsGetter = &checkpointMemoryNUMAsGetter{stateFileDirectory: tmpDir}
+			dynamicPolicy.preferMemoryNUMAs = tc.enabled
+
+			podUID := string(uuid.NewUUID())
+			if tc.memoryAllocated {
+				as.Nil(writeMemoryCheckpoint(tmpDir, podUID, tc.numaBinding))
+			}
+
+			resp, err := dynamicPolicy.GetTopologyHints(context.Background(), generateReq(podUID, tc.qosLevel))
+			as.Nil(err)
+
+			hints := resp.ResourceHints[string(v1.ResourceCPU)].Hints
+			as.True(hasPreferredHint(hints))
+			preferredNUMAs := machine.NewCPUSet()
+			for _, hint := range hints {
+				if hint.Preferred {
+					preferredNUMAs = preferredNUMAs.Union(machine.NewCPUSet(util.HintToIntArray(hint)...))
+				}
+			}
+
+			if tc.preferMemory {
+				as.Equal(machine.NewCPUSet(memoryNUMA).String(), preferredNUMAs.String())
+			} else {
+				as.True(preferredNUMAs.Size() > 1, "preferred NUMAs: %s", preferredNUMAs.String())
+			}
+		})
+	}
+}
+
+func TestNUMABindingSharedPodsCap(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	crowdedNUMA := 1
+	generateReq := func(hint *pluginapi.TopologyHint) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			qosLevel:          consts.PodAnnotationQoSLevelSharedCores,
+			noQoSLabel:        true,
+			memoryEnhancement: `{"numa_binding": "true"}`,
+			quantity:          1,
+			hint:              hint,
+		})
+	}
+
+	testCases := []struct {
+		name     string
+		maxPods  int
+		excluded bool
+	}{
+		{
+			name:     "no cap",
+			maxPods:  0,
+			excluded: false,
+		},
+		{
+			name:     "NUMA at the cap is excluded",
+			maxPods:  2,
+			excluded: true,
+		},
+		{
+			name:     "NUMA below the cap is kept",
+			maxPods:  3,
+			excluded: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestNUMABindingSharedPodsCap")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.maxNUMABindingSharedPods = tc.maxPods
+
+			for i := 0; i < 2; i++ {
+				_, err = dynamicPolicy.Allocate(context.Background(),
+					generateReq(&pluginapi.TopologyHint{Nodes: []uint64{uint64(crowdedNUMA)}, Preferred: true}))
+				as.Nil(err)
+			}
+			as.Equal(2, countNUMABindingSharedPods(dynamicPolicy.state.GetPodEntries())[crowdedNUMA])
+
+			hints, err := dynamicPolicy.calculateHintsForNUMABindingSharedCores(context.Background(), 1,
+				dynamicPolicy.state.GetPodEntries(), dynamicPolicy.state.GetMachineState(),
+				dynamicPolicy.state.GetSharedNUMAPools(), map[string]string{
+					consts.PodAnnotationQoSLevelKey:                  consts.PodAnnotationQoSLevelSharedCores,
+					consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+				}, machine.NewCPUSet(), nil)
+			as.Nil(err)
+
+			numaIDs := machine.NewCPUSet()
+			for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+				numaIDs = numaIDs.Union(machine.NewCPUSet(util.HintToIntArray(hint)...))
+			}
+			as.False(numaIDs.IsEmpty())
+			as.Equal(!tc.excluded, numaIDs.Contains(crowdedNUMA), "NUMAs of hints: %s", numaIDs.String())
+		})
+	}
+}
+
+func TestDiffMachineState(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestDiffMachineState")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.enableMachineStateDiffLog = true
+
+	testName := "test"
+	podUID := string(uuid.NewUUID())
+	_, err = dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+		PodUid:         podUID,
+		PodNamespace:   testName,
+		PodName:        testName,
+		ContainerName:  testName,
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		Hint:           &pluginapi.TopologyHint{Nodes: []uint64{1}, Preferred: true},
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 2,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+		},
+	})
+	as.Nil(err)
+
+	allocationInfo := dynamicPolicy.state.GetAllocationInfo(podUID, testName)
+	as.NotNil(allocationInfo)
+	allocation := fmt.Sprintf("%s/%s: %s", podUID, testName, allocationInfo.AllocationResult.String())
+
+	withAllocation := dynamicPolicy.state.GetMachineState()
+	as.Empty(diffMachineState(withAllocation, withAllocation))
+
+	podEntries := dynamicPolicy.state.GetPodEntries()
+	delete(podEntries, podUID)
+	withoutAllocation, err := dynamicPolicy.generateMachineStateWithRetry(podEntries)
+	as.Nil(err)
+
+	// the allocation is removed
+	diffs := diffMachineState(withAllocation, withoutAllocation)
+	as.Len(diffs, 1)
+	as.Equal(1, diffs[0].NUMAID)
+	as.Equal([]string{allocation}, diffs[0].Removed)
+	as.Empty(diffs[0].Added)
+	as.NotEqual(diffs[0].AllocatedBefore, diffs[0].AllocatedAfter)
+
+	// the allocation is added
+	diffs = diffMachineState(withoutAllocation, withAllocation)
+	as.Len(diffs, 1)
+	as.Equal(1, diffs[0].NUMAID)
+	as.Equal([]string{allocation}, diffs[0].Added)
+	as.Empty(diffs[0].Removed)
+}
+
+func TestSocketAffinity(t *testing.T) {
+	t.Parallel()
+
+	// NUMA 0-1 are on socket 0, and NUMA 2-3 are on socket 1
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+	require.Equal(t, "2-3", cpuTopology.CPUDetails.NUMANodesInSockets(1).String())
+
+	generateReq := func(cpuEnhancement string, hint *pluginapi.TopologyHint) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			qosLevel:          consts.PodAnnotationQoSLevelDedicatedCores,
+			noQoSLabel:        true,
+			memoryEnhancement: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+			cpuEnhancement:    cpuEnhancement,
+			quantity:          2,
+			hint:              hint,
+		})
+	}
+	socketAffinity := fmt.Sprintf(`{"%s": "1"}`, cpuconsts.PodAnnotationCPUSocketAffinity)
+
+	testCases := []struct {
+		name          string
+		socketFull    bool
+		fallback      bool
+		wantPreferred machine.CPUSet
+	}{
+		{
+			name:          "NUMAs are constrained to the socket",
+			wantPreferred: machine.NewCPUSet(2, 3),
+		},
+		{
+			name:          "socket without capacity fails the admission",
+			socketFull:    true,
+			wantPreferred: machine.NewCPUSet(),
+		},
+		{
+			name:          "socket without capacity falls back to all sockets",
+			socketFull:    true,
+			fallback:      true,
+			wantPreferred: machine.NewCPUSet(0, 1),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestSocketAffinity")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.socketAffinityFallback = tc.fallback
+
+			if tc.socketFull {
+				for _, numaID := range []uint64{2, 3} {
+					_, err = dynamicPolicy.Allocate(context.Background(),
+						generateReq(`{}`, &pluginapi.TopologyHint{Nodes: []uint64{numaID}, Preferred: true}))
+					as.Nil(err)
+				}
+			}
+
+			resp, err := dynamicPolicy.GetTopologyHints(context.Background(), generateReq(socketAffinity, nil))
+			preferredNUMAs := machine.NewCPUSet()
+			if err == nil {
+				for _, hint := range resp.ResourceHints[string(v1.ResourceCPU)].Hints {
+					if hint.Preferred {
+						preferredNUMAs = preferredNUMAs.Union(machine.NewCPUSet(util.HintToIntArray(hint)...))
+					}
+				}
+			}
+			as.Equal(tc.wantPreferred.String(), preferredNUMAs.String())
+		})
+	}
+}
+
+func TestNUMAMigrationCounts(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestNUMAMigrationCounts")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	testName := "test"
+	newRequest := func(podUID string, numaID uint64, numaBinding bool) *pluginapi.ResourceRequest {
+		memoryEnhancement := ""
+		if numaBinding {
+			memoryEnhancement = `{"numa_binding": "true"}`
+		}
+		return newTestRequest(testRequest{
+			podUID:            podUID,
+			qosLevel:          consts.PodAnnotationQoSLevelSharedCores,
+			memoryEnhancement: memoryEnhancement,
+			quantity:          2,
+			hint:              &pluginapi.TopologyHint{Nodes: []uint64{numaID}, Preferred: true},
+		})
+	}
+	// reallocate clears the allocation of the container as if it's restarted, and allocates it to the NUMA
+	reallocate := func(podUID string, numaID uint64) {
+		dynamicPolicy.Lock()
+		as.Nil(dynamicPolicy.removeContainer(podUID, testName))
+		dynamicPolicy.Unlock()
+
+		_, err := dynamicPolicy.Allocate(context.Background(), newRequest(podUID, numaID, true))
+		as.Nil(err)
+	}
+
+	podUID := string(uuid.NewUUID())
+	_, err = dynamicPolicy.Allocate(context.Background(), newRequest(podUID, 1, true))
+	as.Nil(err)
+	as.Equal(0, dynamicPolicy.getNUMAMigrations()[podUID].Count)
+
+	// allocating on the same NUMA isn't a migration
+	_, err = dynamicPolicy.Allocate(context.Background(), newRequest(podUID, 1, true))
+	as.Nil(err)
+	reallocate(podUID, 1)
+	as.Equal(0, dynamicPolicy.getNUMAMigrations()[podUID].Count)
+
+	reallocate(podUID, 2)
+	as.Equal(1, dynamicPolicy.getNUMAMigrations()[podUID].Count)
+	as.Equal(machine.NewCPUSet(2), dynamicPolicy.getNUMAMigrations()[podUID].NUMANodes)
+
+	reallocate(podUID, 1)
+	as.Equal(2, dynamicPolicy.getNUMAMigrations()[podUID].Count)
+
+	// pods without numa_binding aren't tracked
+	nonBindingPodUID := string(uuid.NewUUID())
+	_, err = dynamicPolicy.Allocate(context.Background(), newRequest(nonBindingPodUID, 1, false))
+	as.Nil(err)
+	as.NotContains(dynamicPolicy.getNUMAMigrations(), nonBindingPodUID)
+
+	// counts are exported by the debug handler
+	w := httptest.NewRecorder()
+	dynamicPolicy.serveNUMAMigrations(w, httptest.NewRequest(http.MethodGet, "/debug"+numaMigrationsDebugPath, nil))
+	as.Equal(http.StatusOK, w.Code)
+
+	exported := map[string]*numaMigrationRecord{}
+	as.Nil(json.Unmarshal(w.Body.Bytes(), &exported))
+	as.Len(exported, 1)
+	as.Equal(2, exported[podUID].Count)
+	as.Equal(testName, exported[podUID].PodName)
+
+	// counts are reset when the pod is removed
+	_, err = dynamicPolicy.RemovePod(context.Background(), &pluginapi.RemovePodRequest{PodUid: podUID})
+	as.Nil(err)
+	as.NotContains(dynamicPolicy.getNUMAMigrations(), podUID)
+
+	_, err = dynamicPolicy.Allocate(context.Background(), newRequest(podUID, 2, true))
+	as.Nil(err)
+	as.Equal(0, dynamicPolicy.getNUMAMigrations()[podUID].Count)
+}
+
+func TestOfflineCPUs(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestOfflineCPUs")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.cpuNUMAHintPreferTieBreak = cpuconsts.CPUNUMAHintPreferTieBreakNone
+
+	podUID := string(uuid.NewUUID())
+	// annotations of the request are filtered in place, so it's regenerated for each call
+	newReq := func() *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			podUID:            podUID,
+			qosLevel:          consts.PodAnnotationQoSLevelSharedCores,
+			memoryEnhancement: `{"numa_binding": "true"}`,
+			quantity:          4,
+		})
+	}
+
+	resp, err := dynamicPolicy.GetTopologyHints(context.Background(), newReq())
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{2}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: true},
+	}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+	offlineCPU := cpuTopology.CPUDetails.CPUsInNUMANodes(2).ToSliceInt()[0]
+	as.Nil(dynamicPolicy.SetOfflineCPUs(machine.NewCPUSet(offlineCPU)))
+	as.True(dynamicPolicy.GetOfflineCPUs().Equals(machine.NewCPUSet(offlineCPU)))
+
+	resp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq())
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{3}, Preferred: true},
+	}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+	as.Nil(dynamicPolicy.SetOfflineCPUs(machine.NewCPUSet()))
+
+	resp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq())
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{2}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: true},
+	}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+}
+
+func TestOfflineCPUsTrimAllocations(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestOfflineCPUsTrimAllocations")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	newReq := func(qosLevel string, numCPUs float64, numaID uint64) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			qosLevel:          qosLevel,
+			memoryEnhancement: `{"numa_binding": "true"}`,
+			quantity:          numCPUs,
+			hint:              &pluginapi.TopologyHint{Nodes: []uint64{numaID}, Preferred: true},
+		})
+	}
+
+	dedicatedReq := newReq(consts.PodAnnotationQoSLevelDedicatedCores, 2, 2)
+	_, err = dynamicPolicy.Allocate(context.Background(), dedicatedReq)
+	as.Nil(err)
+	sharedReq := newReq(consts.PodAnnotationQoSLevelSharedCores, 1, 3)
+	_, err = dynamicPolicy.Allocate(context.Background(), sharedReq)
+	as.Nil(err)
+
+	dedicatedCPUs := dynamicPolicy.state.GetAllocationInfo(dedicatedReq.PodUid, dedicatedReq.ContainerName).AllocationResult
+	as.Equal(2, dedicatedCPUs.Size())
+	sharedCPUs := dynamicPolicy.state.GetAllocationInfo(sharedReq.PodUid, sharedReq.ContainerName).AllocationResult
+	as.False(sharedCPUs.IsEmpty())
+
+	offlineCPUs := machine.NewCPUSet(dedicatedCPUs.ToSliceInt()[0], sharedCPUs.ToSliceInt()[0])
+	as.Nil(dynamicPolicy.SetOfflineCPUs(offlineCPUs))
+
+	// offline cpu is trimmed from the dedicated_cores container
+	dedicatedAllocationInfo := dynamicPolicy.state.GetAllocationInfo(dedicatedReq.PodUid, dedicatedReq.ContainerName)
+	as.Equal(dedicatedCPUs.Difference(offlineCPUs).String(), dedicatedAllocationInfo.AllocationResult.String())
+	as.Equal(dedicatedCPUs.Difference(offlineCPUs).String(), dedicatedAllocationInfo.OriginalAllocationResult.String())
+	as.Equal(dedicatedCPUs.Difference(offlineCPUs).String(), dedicatedAllocationInfo.TopologyAwareAssignments[2].String())
+
+	// numa_binding shared_cores container is moved off offline cpu along with its pool
+	sharedAllocationInfo := dynamicPolicy.state.GetAllocationInfo(sharedReq.PodUid, sharedReq.ContainerName)
+	as.False(sharedAllocationInfo.AllocationResult.IsEmpty())
+	as.True(sharedAllocationInfo.AllocationResult.Intersection(offlineCPUs).IsEmpty())
+	as.True(sharedAllocationInfo.AllocationResult.IsSubsetOf(cpuTopology.CPUDetails.CPUsInNUMANodes(3)))
+}
+
+type recordingMetricEmitter struct {
+	metrics.DummyMetrics
+
+	mutex   sync.Mutex
+	records map[string][]metrics.MetricTag
+}
+
+func (r *recordingMetricEmitter) StoreInt64(key string, _ int64, _ metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.records == nil {
+		r.records = make(map[string][]metrics.MetricTag)
+	}
+	r.records[key] = tags
+	return nil
+}
+
+func (r *recordingMetricEmitter) getRecord(key string) ([]metrics.MetricTag, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	tags, ok := r.records[key]
+	return tags, ok
+}
+
+func TestClearContainerAndRegenerateMachineState(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testName := "test"
+	podUID := string(uuid.NewUUID())
+
+	testCases := []struct {
+		name               string
+		containerRunning   bool
+		wantKeepAllocation string
+	}{
+		{
+			name:               "container not found in metaServer",
+			containerRunning:   false,
+			wantKeepAllocation: "false",
+		},
+		{
+			name:               "container still running with its allocation",
+			containerRunning:   true,
+			wantKeepAllocation: "true",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestClearContainerAndRegenerateMachineState")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+
+			emitter := &recordingMetricEmitter{}
+			dynamicPolicy.emitter = emitter
+
+			if tc.containerRunning {
+				dynamicPolicy.metaServer = &metaserver.MetaServer{
+					MetaAgent: &agent.MetaAgent{
+						PodFetcher: &pod.PodFetcherStub{
+							PodList: []*v1.Pod{
+								{
+									ObjectMeta: metav1.ObjectMeta{
+										Name:      testName,
+										Namespace: testName,
+										UID:       types.UID(podUID),
+									},
+									Status: v1.PodStatus{
+										Phase: v1.PodRunning,
+										ContainerStatuses: []v1.ContainerStatus{
+											{
+												Name: testName,
+												State: v1.ContainerState{
+													Running: &v1.ContainerStateRunning{},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				}
+			}
+
+			// the existing allocation is smaller than the request, so RegenerateHints fails
+			allocatedCPUs := machine.NewCPUSet(cpuTopology.CPUDetails.CPUsInNUMANodes(1).ToSliceInt()[:2]...)
+			dynamicPolicy.state.SetAllocationInfo(podUID, testName, &state.AllocationInfo{
+				PodUid:                   podUID,
+				PodNamespace:             testName,
+				PodName:                  testName,
+				ContainerName:            testName,
+				ContainerType:            pluginapi.ContainerType_MAIN.String(),
+				OwnerPoolName:            state.PoolNameDedicated,
+				AllocationResult:         allocatedCPUs.Clone(),
+				OriginalAllocationResult: allocatedCPUs.Clone(),
+				TopologyAwareAssignments: map[int]machine.CPUSet{
+					1: allocatedCPUs.Clone(),
+				},
+				OriginalTopologyAwareAssignments: map[int]machine.CPUSet{
+					1: allocatedCPUs.Clone(),
+				},
+				Annotations: map[string]string{
+					consts.PodAnnotationQoSLevelKey:                  consts.PodAnnotationQoSLevelDedicatedCores,
+					consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+				},
+				QoSLevel:        consts.PodAnnotationQoSLevelDedicatedCores,
+				RequestQuantity: 2,
+			})
+
+			_, err = dynamicPolicy.GetTopologyHints(context.Background(), &pluginapi.ResourceRequest{
+				PodUid:         podUID,
+				PodNamespace:   testName,
+				PodName:        testName,
+				ContainerName:  testName,
+				ContainerType:  pluginapi.ContainerType_MAIN,
+				ContainerIndex: 0,
+				ResourceName:   string(v1.ResourceCPU),
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceCPU): 4,
+				},
+				Labels: map[string]string{
+					consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+				},
+				Annotations: map[string]string{
+					consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+					consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+				},
+			})
+			as.Nil(err)
+
+			tags, ok := emitter.getRecord(util.MetricNameClearContainerAndRecompute)
+			as.True(ok)
+			as.Equal([]metrics.MetricTag{{Key: "keepAllocation", Val: tc.wantKeepAllocation}}, tags)
+		})
+	}
+}
+
+type blockingExtraStateFileReader struct {
+	content []byte
+	release chan struct{}
+}
+
+func (r *blockingExtraStateFileReader) ReadFile(_ string) ([]byte, error) {
+	if r.release != nil {
+		<-r.release
+	}
+	return r.content, nil
+}
+
+func TestGetHintsFromExtraStateFileWithTimeout(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testName := "test"
+
+	testCases := []struct {
+		name        string
+		blocked     bool
+		wantTimeout bool
+		wantHints   []*pluginapi.TopologyHint
+	}{
+		{
+			name:        "read extra state file in time",
+			blocked:     false,
+			wantTimeout: false,
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{2}, Preferred: true},
+			},
+		},
+		{
+			name:        "read extra state file timeout",
+			blocked:     true,
+			wantTimeout: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestGetHintsFromExtraStateFileWithTimeout")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+
+			emitter := &recordingMetricEmitter{}
+			dynamicPolicy.emitter = emitter
+
+			reader := &blockingExtraStateFileReader{
+				content: []byte(fmt.Sprintf(`{"memoryEntries": {"%s-0": "2"}}`, testName)),
+			}
+			if tc.blocked {
+				reader.release = make(chan struct{})
+				defer close(reader.release)
+			}
+			dynamicPolicy.extraStateFileAbsPaths = []string{filepath.Join(tmpDir, "extra_state")}
+			dynamicPolicy.extraStateFileReader = reader
+			dynamicPolicy.extraStateFileReadTimeout = 10 * time.Millisecond
+
+			resp, err := dynamicPolicy.GetTopologyHints(context.Background(), &pluginapi.ResourceRequest{
+				PodUid:         string(uuid.NewUUID()),
+				PodNamespace:   testName,
+				PodName:        testName,
+				ContainerName:  testName,
+				ContainerType:  pluginapi.ContainerType_MAIN,
+				ContainerIndex: 0,
+				ResourceName:   string(v1.ResourceCPU),
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceCPU): 2,
+				},
+				Labels: map[string]string{
+					consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+				},
+				Annotations: map[string]string{
+					consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+					consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+				},
+			})
+			as.Nil(err)
+
+			_, timeout := emitter.getRecord(util.MetricNameExtraStateFileReadTimeout)
+			as.Equal(tc.wantTimeout, timeout)
+
+			hints := resp.ResourceHints[string(v1.ResourceCPU)].Hints
+			if tc.wantTimeout {
+				// fallback to normal calculation, which gives hints for all NUMAs
+				as.Greater(len(hints), 1)
+			} else {
+				as.Equal(tc.wantHints, hints)
+			}
+		})
+	}
+}
+
+func TestPreferredNUMAOverride(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testName := "test"
+
+	testCases := []struct {
+		name          string
+		preferredNUMA string
+		wantErr       bool
+		wantHints     []*pluginapi.TopologyHint
+	}{
+		{
+			name:          "valid override with capacity",
+			preferredNUMA: "3",
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{2}, Preferred: false},
+				{Nodes: []uint64{3}, Preferred: true},
+			},
+		},
+		{
+			name:          "valid override without capacity",
+			preferredNUMA: "0",
+			wantErr:       true,
+		},
+		{
+			name:          "invalid override",
+			preferredNUMA: "invalid",
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{2}, Preferred: true},
+				{Nodes: []uint64{3}, Preferred: false},
+			},
+		},
+		{
+			name:          "override out of range",
+			preferredNUMA: "4",
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{2}, Preferred: true},
+				{Nodes: []uint64{3}, Preferred: false},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestPreferredNUMAOverride")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+
+			resp, err := dynamicPolicy.GetTopologyHints(context.Background(), &pluginapi.ResourceRequest{
+				PodUid:         string(uuid.NewUUID()),
+				PodNamespace:   testName,
+				PodName:        testName,
+				ContainerName:  testName,
+				ContainerType:  pluginapi.ContainerType_MAIN,
+				ContainerIndex: 0,
+				ResourceName:   string(v1.ResourceCPU),
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceCPU): 4,
+				},
+				Labels: map[string]string{
+					consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+				},
+				Annotations: map[string]string{
+					consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelSharedCores,
+					consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+					consts.PodAnnotationCPUEnhancementKey: fmt.Sprintf(`{"%s": "%s"}`,
+						cpuconsts.PodAnnotationCPUNUMABindingPreferredNUMA, tc.preferredNUMA),
+				},
+			})
+			if tc.wantErr {
+				as.NotNil(err)
+				return
+			}
+			as.Nil(err)
+			as.Equal(tc.wantHints, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+		})
+	}
+}
+
+func TestGetTopologyHintsWithNUMAAffinityResource(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testName := "test"
+	numaAffinityResourceName := "numa-affinity"
+
+	testCases := []struct {
+		name                     string
+		numaAffinityResourceName string
+		containerType            pluginapi.ContainerType
+	}{
+		{
+			name:          "mirror disabled",
+			containerType: pluginapi.ContainerType_MAIN,
+		},
+		{
+			name:                     "mirror enabled",
+			numaAffinityResourceName: numaAffinityResourceName,
+			containerType:            pluginapi.ContainerType_MAIN,
+		},
+		{
+			name:                     "mirror enabled for init container",
+			numaAffinityResourceName: numaAffinityResourceName,
+			containerType:            pluginapi.ContainerType_INIT,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestGetTopologyHintsWithNUMAAffinityResource")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.numaAffinityResourceName = tc.numaAffinityResourceName
+
+			resp, err := dynamicPolicy.GetTopologyHints(context.Background(), &pluginapi.ResourceRequest{
+				PodUid:         string(uuid.NewUUID()),
+				PodNamespace:   testName,
+				PodName:        testName,
+				ContainerName:  testName,
+				ContainerType:  tc.containerType,
+				ContainerIndex: 0,
+				ResourceName:   string(v1.ResourceCPU),
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceCPU): 2,
+				},
+				Labels: map[string]string{
+					consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+				},
+				Annotations: map[string]string{
+					consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+					consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+				},
+			})
+			as.Nil(err)
+
+			cpuHints, ok := resp.ResourceHints[string(v1.ResourceCPU)]
+			as.True(ok)
+			if tc.numaAffinityResourceName == "" {
+				as.Len(resp.ResourceHints, 1)
+				return
+			}
+
+			as.Len(resp.ResourceHints, 2)
+			mirroredHints, ok := resp.ResourceHints[tc.numaAffinityResourceName]
+			as.True(ok)
+			as.Equal(cpuHints, mirroredHints)
+			if tc.containerType == pluginapi.ContainerType_INIT {
+				as.Nil(mirroredHints)
+			} else {
+				as.NotEmpty(mirroredHints.Hints)
+			}
+		})
+	}
+}
+
+func TestGenerateMachineStateWithRetry(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		failures    int
+		permanent   bool
+		wantCalls   int
+		wantErr     bool
+		allocatePod bool
+	}{
+		{
+			name:      "no failure",
+			wantCalls: 1,
+		},
+		{
+			name:      "transient failures then succeed",
+			failures:  2,
+			wantCalls: 3,
+		},
+		{
+			name:      "transient failures exhaust retries",
+			failures:  generateMachineStateBackoff.Steps,
+			wantCalls: generateMachineStateBackoff.Steps,
+			wantErr:   true,
+		},
+		{
+			name:      "permanent failure",
+			failures:  1,
+			permanent: true,
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:        "allocate with transient failures",
+			failures:    2,
+			wantCalls:   3,
+			allocatePod: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestGenerateMachineStateWithRetry")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+
+			calls := 0
+			dynamicPolicy.machineStateGenerator = func(topology *machine.CPUTopology,
+				podEntries state.PodEntries,
+			) (state.NUMANodeMap, error) {
+				calls++
+				if calls > tc.failures {
+					return generateMachineStateFromPodEntries(topology, podEntries)
+				} else if tc.permanent {
+					return nil, fmt.Errorf("bad pod entries")
+				}
+				return nil, newTransientError(fmt.Errorf("read topology failed"))
+			}
+
+			if tc.allocatePod {
+				_, err = dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+					PodUid:         string(uuid.NewUUID()),
+					PodNamespace:   "test",
+					PodName:        "test",
+					ContainerName:  "test",
+					ContainerType:  pluginapi.ContainerType_MAIN,
+					ContainerIndex: 0,
+					ResourceName:   string(v1.ResourceCPU),
+					ResourceRequests: map[string]float64{
+						string(v1.ResourceCPU): 2,
+					},
+					Hint: &pluginapi.TopologyHint{Nodes: []uint64{2}, Preferred: true},
+					Labels: map[string]string{
+						consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+					},
+					Annotations: map[string]string{
+						consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+						consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+					},
+				})
+				as.Nil(err)
+				// machine state is generated again when adjusting allocation entries after the allocation
+				as.GreaterOrEqual(calls, tc.wantCalls)
+				return
+			}
+
+			machineState, err := dynamicPolicy.generateMachineStateWithRetry(dynamicPolicy.state.GetPodEntries())
+			as.Equal(tc.wantCalls, calls)
+			if tc.wantErr {
+				as.NotNil(err)
+				as.Nil(machineState)
+				return
+			}
+			as.Nil(err)
+			as.Len(machineState, 4)
+		})
+	}
+}
+
+type fakePlacementAdvisorServer struct {
+	hasPreference bool
+	preferredNUMA uint64
+	delay         time.Duration
+
+	mutex   sync.Mutex
+	lastReq *placementadvisor.GetPreferredNUMARequest
+}
+
+func (s *fakePlacementAdvisorServer) GetPreferredNUMA(ctx context.Context,
+	req *placementadvisor.GetPreferredNUMARequest,
+) (*placementadvisor.GetPreferredNUMAResponse, error) {
+	s.mutex.Lock()
+	s.lastReq = req
+	s.mutex.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(s.delay):
+	}
+
+	return &placementadvisor.GetPreferredNUMAResponse{
+		HasPreference: s.hasPreference,
+		PreferredNuma: s.preferredNUMA,
+	}, nil
+}
+
+func TestPlacementAdvisorPreferredNUMA(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testName := "test"
+	// NUMA 0 and NUMA 1 have only 3 allocatable cpus
+	builtinHints := []*pluginapi.TopologyHint{
+		{Nodes: []uint64{2}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: true},
+	}
+
+	testCases := []struct {
+		name          string
+		server        *fakePlacementAdvisorServer
+		preferredNUMA string
+		wantHints     []*pluginapi.TopologyHint
+	}{
+		{
+			name:   "advised NUMA with capacity",
+			server: &fakePlacementAdvisorServer{hasPreference: true, preferredNUMA: 2},
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{2}, Preferred: true},
+				{Nodes: []uint64{3}, Preferred: false},
+			},
+		},
+		{
+			name:      "advised NUMA without capacity",
+			server:    &fakePlacementAdvisorServer{hasPreference: true, preferredNUMA: 0},
+			wantHints: builtinHints,
+		},
+		{
+			name:      "advised NUMA out of range",
+			server:    &fakePlacementAdvisorServer{hasPreference: true, preferredNUMA: 4},
+			wantHints: builtinHints,
+		},
+		{
+			name:      "no preference",
+			server:    &fakePlacementAdvisorServer{},
+			wantHints: builtinHints,
+		},
+		{
+			name:      "advisor timeout",
+			server:    &fakePlacementAdvisorServer{hasPreference: true, preferredNUMA: 2, delay: time.Second},
+			wantHints: builtinHints,
+		},
+		{
+			name:          "preferred NUMA overridden by annotation",
+			server:        &fakePlacementAdvisorServer{hasPreference: true, preferredNUMA: 2},
+			preferredNUMA: "3",
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{2}, Preferred: false},
+				{Nodes: []uint64{3}, Preferred: true},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestPlacementAdvisorPreferredNUMA")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			socketPath := filepath.Join(tmpDir, "placement-advisor.sock")
+			listener, err := net.Listen("unix", socketPath)
+			as.Nil(err)
+
+			server := grpc.NewServer()
+			placementadvisor.RegisterPlacementAdvisorServer(server, tc.server)
+			go func() { _ = server.Serve(listener) }()
+			defer server.Stop()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			// keep all NUMAs with enough cpus preferred, so that the advice can be observed
+			dynamicPolicy.cpuNUMAHintPreferTieBreak = cpuconsts.CPUNUMAHintPreferTieBreakNone
+
+			dynamicPolicy.placementAdvisorSocketAbsPath = socketPath
+			dynamicPolicy.placementAdvisorTimeout = 100 * time.Millisecond
+			as.Nil(dynamicPolicy.initPlacementAdvisorClientConn())
+			defer func() { _ = dynamicPolicy.placementAdvisorConn.Close() }()
+
+			annotations := map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelSharedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+			}
+			if tc.preferredNUMA != "" {
+				annotations[consts.PodAnnotationCPUEnhancementKey] = fmt.Sprintf(`{"%s": "%s"}`,
+					cpuconsts.PodAnnotationCPUNUMABindingPreferredNUMA, tc.preferredNUMA)
+			}
+
+			resp, err := dynamicPolicy.GetTopologyHints(context.Background(), &pluginapi.ResourceRequest{
+				PodUid:         string(uuid.NewUUID()),
+				PodNamespace:   testName,
+				PodName:        testName,
+				ContainerName:  testName,
+				ContainerType:  pluginapi.ContainerType_MAIN,
+				ContainerIndex: 0,
+				ResourceName:   string(v1.ResourceCPU),
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceCPU): 4,
+				},
+				Labels: map[string]string{
+					consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+				},
+				Annotations: annotations,
+			})
+			as.Nil(err)
+			as.Equal(tc.wantHints, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+			if tc.preferredNUMA == "" {
+				tc.server.mutex.Lock()
+				defer tc.server.mutex.Unlock()
+				as.NotNil(tc.server.lastReq)
+				as.Equal(uint64(4), tc.server.lastReq.RequestQuantity)
+				as.Equal(consts.PodAnnotationQoSLevelSharedCores, tc.server.lastReq.QosLevel)
+				as.Len(tc.server.lastReq.NumaStates, 4)
+				as.Equal(uint64(3), tc.server.lastReq.NumaStates[0].AllocatableQuantity)
+			}
+		})
+	}
+}
+
+func TestGetCandidateNUMAs(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testName := "test"
+
+	testCases := []struct {
+		name                    string
+		preferPolicy            string
+		reqInt                  int
+		wantNUMAs               []int
+		wantAvailableQuantities map[int]int
+		wantErr                 bool
+	}{
+		{
+			name:                    "spreading with small request",
+			preferPolicy:            cpuconsts.CPUNUMAHintPreferPolicySpreading,
+			reqInt:                  2,
+			wantNUMAs:               []int{0, 1, 2, 3},
+			wantAvailableQuantities: map[int]int{0: 3, 1: 3, 2: 4, 3: 4},
+		},
+		{
+			name:                    "packing with large request",
+			preferPolicy:            cpuconsts.CPUNUMAHintPreferPolicyPacking,
+			reqInt:                  4,
+			wantNUMAs:               []int{2, 3},
+			wantAvailableQuantities: map[int]int{2: 4, 3: 4},
+		},
+		{
+			name:         "request larger than one NUMA",
+			preferPolicy: cpuconsts.CPUNUMAHintPreferPolicySpreading,
+			reqInt:       8,
+			wantErr:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestGetCandidateNUMAs")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.cpuNUMAHintPreferPolicy = tc.preferPolicy
+
+			annotations := map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelSharedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+			}
+
+			numas, availableQuantities, err := dynamicPolicy.GetCandidateNUMAs(tc.reqInt, annotations)
+			if tc.wantErr {
+				as.NotNil(err)
+				return
+			}
+			as.Nil(err)
+			as.Equal(tc.wantNUMAs, numas)
+			as.Equal(tc.wantAvailableQuantities, availableQuantities)
+
+			// candidates should be consistent with NUMAs in calculated hints
+			resp, err := dynamicPolicy.GetTopologyHints(context.Background(), &pluginapi.ResourceRequest{
+				PodUid:         string(uuid.NewUUID()),
+				PodNamespace:   testName,
+				PodName:        testName,
+				ContainerName:  testName,
+				ContainerType:  pluginapi.ContainerType_MAIN,
+				ContainerIndex: 0,
+				ResourceName:   string(v1.ResourceCPU),
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceCPU): float64(tc.reqInt),
+				},
+				Labels: map[string]string{
+					consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+				},
+				Annotations: annotations,
+			})
+			as.Nil(err)
+
+			hintNUMAs := make([]int, 0, len(numas))
+			for _, hint := range resp.ResourceHints[string(v1.ResourceCPU)].Hints {
+				as.Len(hint.Nodes, 1)
+				hintNUMAs = append(hintNUMAs, int(hint.Nodes[0]))
+			}
+			as.Equal(numas, hintNUMAs)
+		})
+	}
+}
+
+func TestNUMAConstraint(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testName := "test"
+
+	testCases := []struct {
+		name       string
+		hint       *pluginapi.TopologyHint
+		constraint string
+		wantHints  []*pluginapi.TopologyHint
+	}{
+		{
+			name: "without constraint",
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: true},
+				{Nodes: []uint64{1}, Preferred: false},
+				{Nodes: []uint64{2}, Preferred: false},
+				{Nodes: []uint64{3}, Preferred: false},
+			},
+		},
+		{
+			name:       "constraint in annotations",
+			constraint: "1-2",
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{1}, Preferred: true},
+				{Nodes: []uint64{2}, Preferred: false},
+			},
+		},
+		{
+			name:       "constraint in request hint takes precedence",
+			hint:       &pluginapi.TopologyHint{Nodes: []uint64{3}, Preferred: true},
+			constraint: "1-2",
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{3}, Preferred: true},
+			},
+		},
+		{
+			name:       "invalid constraint in annotations",
+			constraint: "invalid",
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: true},
+				{Nodes: []uint64{1}, Preferred: false},
+				{Nodes: []uint64{2}, Preferred: false},
+				{Nodes: []uint64{3}, Preferred: false},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestNUMAConstraint")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.cpuNUMAHintPreferPolicy = cpuconsts.CPUNUMAHintPreferPolicyPacking
+
+			annotations := map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelSharedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+			}
+			if tc.constraint != "" {
+				annotations[consts.PodAnnotationCPUEnhancementKey] = fmt.Sprintf(`{"%s": "%s"}`,
+					cpuconsts.PodAnnotationCPUNUMAConstraint, tc.constraint)
+			}
+
+			resp, err := dynamicPolicy.GetTopologyHints(context.Background(), &pluginapi.ResourceRequest{
+				PodUid:         string(uuid.NewUUID()),
+				PodNamespace:   testName,
+				PodName:        testName,
+				ContainerName:  testName,
+				ContainerType:  pluginapi.ContainerType_MAIN,
+				ContainerIndex: 0,
+				ResourceName:   string(v1.ResourceCPU),
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceCPU): 2,
+				},
+				Hint: tc.hint,
+				Labels: map[string]string{
+					consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+				},
+				Annotations: annotations,
+			})
+			as.Nil(err)
+			as.Equal(tc.wantHints, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+		})
+	}
+}
+
+func TestCalculateHintsForZeroNUMA(t *testing.T) {
+	t.Parallel()
+
+	// sysfs reports no NUMA node, so neither cpu details nor machine state contain any NUMA
+	flatTopology := &machine.CPUTopology{
+		NumCPUs:    8,
+		NumCores:   8,
+		NumSockets: 1,
+	}
+	machineState := state.GetDefaultMachineState(flatTopology)
+	require.Empty(t, machineState)
+
+	dynamicPolicy := &DynamicPolicy{
+		machineInfo: &machine.KatalystMachineInfo{
+			CPUTopology: flatTopology,
+		},
+		reservedCPUs: machine.NewCPUSet(0, 1),
+		offlineCPUs:  machine.NewCPUSet(),
+		emitter:      metrics.DummyMetrics{},
+	}
+
+	testCases := []struct {
+		name      string
+		reqInt    int
+		wantHints []*pluginapi.TopologyHint
+	}{
+		{
+			name:   "capacity fits",
+			reqInt: 6,
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: true},
+			},
+		},
+		{
+			name:      "capacity doesn't fit",
+			reqInt:    7,
+			wantHints: []*pluginapi.TopologyHint{},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			hints, err := dynamicPolicy.calculateHints(tc.reqInt, machineState, map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			}, machine.NewCPUSet())
+			as.Nil(err)
+			as.Equal(tc.wantHints, hints[string(v1.ResourceCPU)].Hints)
+		})
+	}
+}
+
+func TestCalculateHintsWithFullPhysicalCores(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		reqInt    int
+		fullCores bool
+		wantHints []*pluginapi.TopologyHint
+	}{
+		{
+			name:   "logical cpus",
+			reqInt: 2,
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: true},
+				{Nodes: []uint64{1}, Preferred: true},
+				{Nodes: []uint64{2}, Preferred: true},
+				{Nodes: []uint64{3}, Preferred: true},
+			},
+		},
+		{
+			name:      "full cores",
+			reqInt:    2,
+			fullCores: true,
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: true},
+				{Nodes: []uint64{1}, Preferred: true},
+				{Nodes: []uint64{3}, Preferred: true},
+			},
+		},
+		{
+			name:      "full cores rounded up",
+			reqInt:    3,
+			fullCores: true,
+			wantHints: []*pluginapi.TopologyHint{},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestCalculateHintsWithFullPhysicalCores")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+
+			// NUMA 2 has 2 free logical cpus on different cores, and NUMA 3 has only 1 free full core
+			machineState := dynamicPolicy.state.GetMachineState()
+			machineState[2].DefaultCPUSet = machine.NewCPUSet(4, 13)
+			machineState[3].DefaultCPUSet = machine.NewCPUSet(6, 7, 14)
+			as.Equal(2, machineState[2].GetAvailableCPUSet(dynamicPolicy.getUnavailableCPUs()).Size())
+			as.Equal(0, machineState[2].GetAvailableFullCoreQuantity(dynamicPolicy.getUnavailableCPUs(),
+				cpuTopology.CPUDetails))
+			as.Equal(1, machineState[3].GetAvailableFullCoreQuantity(dynamicPolicy.getUnavailableCPUs(),
+				cpuTopology.CPUDetails))
+
+			annotations := map[string]string{
+				consts.PodAnnotationQoSLevelKey:                  consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+			}
+			if tc.fullCores {
+				annotations[cpuconsts.PodAnnotationCPUFullPhysicalCores] = "true"
+			}
+
+			hints, err := dynamicPolicy.calculateHints(tc.reqInt, machineState, annotations, machine.NewCPUSet())
+			as.Nil(err)
+			as.Equal(tc.wantHints, hints[string(v1.ResourceCPU)].Hints)
+
+			if len(tc.wantHints) == 0 {
+				return
+			}
+
+			result, err := dynamicPolicy.allocateNumaBindingCPUs(1, &pluginapi.TopologyHint{Nodes: []uint64{3}},
+				machineState, annotations)
+			as.Nil(err)
+			if tc.fullCores {
+				as.Equal(machine.NewCPUSet(6, 14).String(), result.String())
+			} else {
+				as.Equal(1, result.Size())
+			}
+		})
+	}
+}
+
+func TestDedicatedFullPhysicalCPUsOnly(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name              string
+		fullPCPUsOnly     bool
+		wantHints         []*pluginapi.TopologyHint
+		wantAllocation    machine.CPUSet
+		wantRoundedUpFail bool
+	}{
+		{
+			name:          "disabled",
+			fullPCPUsOnly: false,
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: true},
+				{Nodes: []uint64{1}, Preferred: true},
+				{Nodes: []uint64{2}, Preferred: true},
+				{Nodes: []uint64{3}, Preferred: true},
+			},
+		},
+		{
+			name:          "enabled",
+			fullPCPUsOnly: true,
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: true},
+				{Nodes: []uint64{1}, Preferred: true},
+				{Nodes: []uint64{3}, Preferred: true},
+			},
+			wantAllocation:    machine.NewCPUSet(6, 14),
+			wantRoundedUpFail: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestDedicatedFullPhysicalCPUsOnly")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.dedicatedFullPhysicalCPUsOnly = tc.fullPCPUsOnly
+
+			// NUMA 2 has 2 free logical cpus on different cores, and NUMA 3 has only 1 free full core
+			machineState := dynamicPolicy.state.GetMachineState()
+			machineState[2].DefaultCPUSet = machine.NewCPUSet(4, 13)
+			machineState[3].DefaultCPUSet = machine.NewCPUSet(6, 7, 14)
+			dynamicPolicy.state.SetMachineState(machineState)
+
+			// no full physical cores annotation is needed when enabled by configuration
+			annotations := map[string]string{
+				consts.PodAnnotationQoSLevelKey:                  consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+			}
+
+			hints, err := dynamicPolicy.calculateHints(1, machineState, annotations, machine.NewCPUSet())
+			as.Nil(err)
+			as.Equal(tc.wantHints, hints[string(v1.ResourceCPU)].Hints)
+
+			result, err := dynamicPolicy.allocateNumaBindingCPUs(1, &pluginapi.TopologyHint{Nodes: []uint64{3}},
+				machineState, annotations)
+			as.Nil(err)
+			if tc.wantAllocation.IsEmpty() {
+				as.Equal(1, result.Size())
+			} else {
+				as.Equal(tc.wantAllocation.String(), result.String())
+			}
+
+			// request of 3 cpus is rounded up to 2 full cores, which no NUMA has
+			_, err = dynamicPolicy.GetTopologyHints(context.Background(), &pluginapi.ResourceRequest{
+				PodUid:         string(uuid.NewUUID()),
+				PodNamespace:   "test",
+				PodName:        "test",
+				ContainerName:  "test",
+				ContainerType:  pluginapi.ContainerType_MAIN,
+				ContainerIndex: 0,
+				ResourceName:   string(v1.ResourceCPU),
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceCPU): 3,
+				},
+				Labels: map[string]string{
+					consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+				},
+				Annotations: map[string]string{
+					consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+					consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+				},
+			})
+			if tc.wantRoundedUpFail {
+				as.NotNil(err)
+			} else {
+				as.Nil(err)
+			}
+		})
+	}
+}
+
+func TestCalculateHintsWithMaxNUMAsPerAllocation(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	annotations := map[string]string{
+		consts.PodAnnotationQoSLevelKey:                    consts.PodAnnotationQoSLevelDedicatedCores,
+		consts.PodAnnotationMemoryEnhancementNumaBinding:   consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		consts.PodAnnotationMemoryEnhancementNumaExclusive: consts.PodAnnotationMemoryEnhancementNumaExclusiveEnable,
+	}
+
+	testCases := []struct {
+		name                  string
+		maxNUMAsPerAllocation int
+		reqInt                int
+		wantMaxNUMAsInHints   int
+		wantErr               bool
+	}{
+		{
+			name:                  "no limit",
+			maxNUMAsPerAllocation: 0,
+			reqInt:                6,
+			wantMaxNUMAsInHints:   4,
+		},
+		{
+			name:                  "limit hints to 2 NUMAs",
+			maxNUMAsPerAllocation: 2,
+			reqInt:                6,
+			wantMaxNUMAsInHints:   2,
+		},
+		{
+			name:                  "request needs more NUMAs than limit",
+			maxNUMAsPerAllocation: 2,
+			reqInt:                12,
+			wantErr:               true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestCalculateHintsWithMaxNUMAsPerAllocation")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.maxNUMAsPerAllocation = tc.maxNUMAsPerAllocation
+
+			hints, err := dynamicPolicy.calculateHints(tc.reqInt, dynamicPolicy.state.GetMachineState(),
+				annotations, machine.NewCPUSet())
+			if tc.wantErr {
+				as.NotNil(err)
+				return
+			}
+			as.Nil(err)
+			as.NotEmpty(hints[string(v1.ResourceCPU)].Hints)
+
+			maxNUMAsInHints := 0
+			for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+				maxNUMAsInHints = general.Max(maxNUMAsInHints, len(hint.Nodes))
+			}
+			as.Equal(tc.wantMaxNUMAsInHints, maxNUMAsInHints)
+		})
+	}
+}
+
+// TestCalculateHintsInvariants checks invariants of calculateHints against randomized
+// topologies, machine states and requests, the seed is fixed to make failures reproducible.
+func TestCalculateHintsInvariants(t *testing.T) {
+	t.Parallel()
+
+	topologyParams := []struct {
+		cpuNum, socketNum, numaNum int
+	}{
+		{cpuNum: 8, socketNum: 1, numaNum: 1},
+		{cpuNum: 16, socketNum: 2, numaNum: 4},
+		{cpuNum: 24, socketNum: 2, numaNum: 2},
+		{cpuNum: 48, socketNum: 2, numaNum: 6},
+		{cpuNum: 64, socketNum: 4, numaNum: 8},
+	}
+
+	annotationsCandidates := []map[string]string{
+		{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+		{
+			consts.PodAnnotationQoSLevelKey:                  consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		},
+		{
+			consts.PodAnnotationQoSLevelKey:                    consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementNumaBinding:   consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+			consts.PodAnnotationMemoryEnhancementNumaExclusive: consts.PodAnnotationMemoryEnhancementNumaExclusiveEnable,
+		},
+		{
+			consts.PodAnnotationQoSLevelKey:                  consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+			cpuconsts.PodAnnotationCPUFullPhysicalCores:      "true",
+		},
+	}
+
+	const iterationsPerTopology = 200
+	for _, param := range topologyParams {
+		param := param
+		t.Run(fmt.Sprintf("cpu-%d-socket-%d-numa-%d", param.cpuNum, param.socketNum, param.numaNum), func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			cpuTopology, err := machine.GenerateDummyCPUTopology(param.cpuNum, param.socketNum, param.numaNum)
+			as.Nil(err)
+
+			r := rand.New(rand.NewSource(int64(param.cpuNum*100 + param.numaNum)))
+			for i := 0; i < iterationsPerTopology; i++ {
+				// randomly reserve some cpus and take some cpus away as if they were allocated
+				reservedCPUs := machine.NewCPUSet()
+				machineState := state.GetDefaultMachineState(cpuTopology)
+				for _, cpu := range cpuTopology.CPUDetails.CPUs().ToSliceInt() {
+					switch r.Intn(8) {
+					case 0:
+						reservedCPUs.Add(cpu)
+					case 1, 2:
+						numaState := machineState[cpuTopology.CPUDetails[cpu].NUMANodeID]
+						numaState.DefaultCPUSet = numaState.DefaultCPUSet.Difference(machine.NewCPUSet(cpu))
+						numaState.AllocatedCPUSet = numaState.AllocatedCPUSet.Union(machine.NewCPUSet(cpu))
+					}
+				}
+
+				dynamicPolicy := &DynamicPolicy{
+					machineInfo: &machine.KatalystMachineInfo{
+						CPUTopology: cpuTopology,
+					},
+					reservedCPUs:          reservedCPUs,
+					offlineCPUs:           machine.NewCPUSet(),
+					emitter:               metrics.DummyMetrics{},
+					maxNUMAsPerAllocation: r.Intn(param.numaNum + 1),
+				}
+
+				reqInt := 1 + r.Intn(param.cpuNum)
+				annotations := annotationsCandidates[r.Intn(len(annotationsCandidates))]
+				hints, err := dynamicPolicy.calculateHints(reqInt, machineState, annotations, machine.NewCPUSet())
+				if err != nil {
+					continue
+				}
+
+				msg := fmt.Sprintf("iteration: %d, request: %d, reserved: %s, maxNUMAsPerAllocation: %d, annotations: %v",
+					i, reqInt, reservedCPUs.String(), dynamicPolicy.maxNUMAsPerAllocation, annotations)
+				cpuHints := hints[string(v1.ResourceCPU)].Hints
+				preferredFound := false
+				for _, hint := range cpuHints {
+					availableCPUs := machine.NewCPUSet()
+					for _, nodeID := range hint.Nodes {
+						availableCPUs = availableCPUs.Union(machineState[int(nodeID)].GetAvailableCPUSet(reservedCPUs))
+					}
+					as.GreaterOrEqual(availableCPUs.Size(), reqInt, "hint: %v has no enough cpus, %s", hint.Nodes, msg)
+
+					if dynamicPolicy.maxNUMAsPerAllocation > 0 {
+						as.LessOrEqual(len(hint.Nodes), dynamicPolicy.maxNUMAsPerAllocation,
+							"hint: %v exceeds NUMAs limit, %s", hint.Nodes, msg)
+					}
+					preferredFound = preferredFound || hint.Preferred
+				}
+				as.True(len(cpuHints) == 0 || preferredFound, "no preferred hint in: %v, %s", cpuHints, msg)
+			}
+		})
+	}
+}
+
+func BenchmarkCalculateHintsWithMaxNUMAsPerAllocation(b *testing.B) {
+	cpuTopology, err := machine.GenerateDummyCPUTopology(128, 2, 16)
+	require.NoError(b, err)
+
+	annotations := map[string]string{
+		consts.PodAnnotationQoSLevelKey:                    consts.PodAnnotationQoSLevelDedicatedCores,
+		consts.PodAnnotationMemoryEnhancementNumaBinding:   consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		consts.PodAnnotationMemoryEnhancementNumaExclusive: consts.PodAnnotationMemoryEnhancementNumaExclusiveEnable,
+	}
+
+	for _, maxNUMAsPerAllocation := range []int{0, 2, 4} {
+		b.Run(fmt.Sprintf("maxNUMAsPerAllocation-%d", maxNUMAsPerAllocation), func(b *testing.B) {
+			tmpDir, err := ioutil.TempDir("", "checkpoint-BenchmarkCalculateHintsWithMaxNUMAsPerAllocation")
+			require.NoError(b, err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			require.NoError(b, err)
+			dynamicPolicy.maxNUMAsPerAllocation = maxNUMAsPerAllocation
+			machineState := dynamicPolicy.state.GetMachineState()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = dynamicPolicy.calculateHints(16, machineState, annotations, machine.NewCPUSet())
+			}
+		})
+	}
+}
+
+func TestSingleNUMAFastPath(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(8, 1, 1)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestSingleNUMAFastPath")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	dedicatedAnnotationsCandidates := []map[string]string{
+		{
+			consts.PodAnnotationQoSLevelKey:                  consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		},
+		{
+			consts.PodAnnotationQoSLevelKey:                    consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementNumaBinding:   consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+			consts.PodAnnotationMemoryEnhancementNumaExclusive: consts.PodAnnotationMemoryEnhancementNumaExclusiveEnable,
+		},
+	}
+	sharedAnnotations := map[string]string{
+		consts.PodAnnotationQoSLevelKey:                  consts.PodAnnotationQoSLevelSharedCores,
+		consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+	}
+
+	// 2 of 8 cpus are reserved, so requests from 1 to 6 fit while 7 and 8 don't
+	for reqInt := 1; reqInt <= 8; reqInt++ {
+		for _, annotations := range dedicatedAnnotationsCandidates {
+			dynamicPolicy.singleNUMAFastPath = false
+			expectedHints, expectedErr := dynamicPolicy.calculateHints(reqInt, dynamicPolicy.state.GetMachineState(),
+				annotations, machine.NewCPUSet())
+			dynamicPolicy.singleNUMAFastPath = true
+			hints, err := dynamicPolicy.calculateHints(reqInt, dynamicPolicy.state.GetMachineState(),
+				annotations, machine.NewCPUSet())
+			as.Equal(expectedErr, err, "request: %d, annotations: %v", reqInt, annotations)
+			as.Equal(expectedHints, hints, "request: %d, annotations: %v", reqInt, annotations)
+		}
+
+		calculateSharedHints := func() (map[string]*pluginapi.ListOfTopologyHints, error) {
+			return dynamicPolicy.calculateHintsForNUMABindingSharedCores(context.Background(), reqInt,
+				dynamicPolicy.state.GetPodEntries(), dynamicPolicy.state.GetMachineState(),
+				dynamicPolicy.state.GetSharedNUMAPools(), sharedAnnotations, machine.NewCPUSet(), nil)
+		}
+		dynamicPolicy.singleNUMAFastPath = false
+		expectedHints, expectedErr := calculateSharedHints()
+		dynamicPolicy.singleNUMAFastPath = true
+		hints, err := calculateSharedHints()
+		as.Equal(expectedErr, err, "request: %d", reqInt)
+		as.Equal(expectedHints, hints, "request: %d", reqInt)
+	}
+
+	dynamicPolicy.singleNUMAFastPath = true
+	hints, err := dynamicPolicy.calculateHints(6, dynamicPolicy.state.GetMachineState(),
+		dedicatedAnnotationsCandidates[0], machine.NewCPUSet())
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{{Nodes: []uint64{0}, Preferred: true}}, hints[string(v1.ResourceCPU)].Hints)
+
+	hints, err = dynamicPolicy.calculateHints(7, dynamicPolicy.state.GetMachineState(),
+		dedicatedAnnotationsCandidates[0], machine.NewCPUSet())
+	as.Nil(err)
+	as.Len(hints[string(v1.ResourceCPU)].Hints, 0)
+}
+
+func BenchmarkCalculateHintsOnSingleNUMAMachine(b *testing.B) {
+	cpuTopology, err := machine.GenerateDummyCPUTopology(64, 1, 1)
+	require.NoError(b, err)
+
+	dedicatedAnnotations := map[string]string{
+		consts.PodAnnotationQoSLevelKey:                  consts.PodAnnotationQoSLevelDedicatedCores,
+		consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+	}
+	sharedAnnotations := map[string]string{
+		consts.PodAnnotationQoSLevelKey:                  consts.PodAnnotationQoSLevelSharedCores,
+		consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+	}
+
+	for _, singleNUMAFastPath := range []bool{false, true} {
+		b.Run(fmt.Sprintf("singleNUMAFastPath-%v", singleNUMAFastPath), func(b *testing.B) {
+			tmpDir, err := ioutil.TempDir("", "checkpoint-BenchmarkCalculateHintsOnSingleNUMAMachine")
+			require.NoError(b, err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			require.NoError(b, err)
+			dynamicPolicy.singleNUMAFastPath = singleNUMAFastPath
+			podEntries := dynamicPolicy.state.GetPodEntries()
+			machineState := dynamicPolicy.state.GetMachineState()
+			sharedNUMAPools := dynamicPolicy.state.GetSharedNUMAPools()
+
+			b.Run("dedicated_cores", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					_, _ = dynamicPolicy.calculateHints(8, machineState, dedicatedAnnotations, machine.NewCPUSet())
+				}
+			})
+			b.Run("shared_cores", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					_, _ = dynamicPolicy.calculateHintsForNUMABindingSharedCores(context.Background(), 8, podEntries,
+						machineState, sharedNUMAPools, sharedAnnotations, machine.NewCPUSet(), nil)
+				}
+			})
+		})
+	}
+}
+
+func TestSharedCoresWithNUMABindingSiblingContainers(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestSharedCoresWithNUMABindingSiblingContainers")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	testName := "test"
+	podUID := string(uuid.NewUUID())
+	generateReq := func(containerIndex uint64, hint *pluginapi.TopologyHint) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			podUID:            podUID,
+			containerName:     fmt.Sprintf("%s-%d", testName, containerIndex),
+			containerIndex:    containerIndex,
+			qosLevel:          consts.PodAnnotationQoSLevelSharedCores,
+			memoryEnhancement: `{"numa_binding": "true"}`,
+			quantity:          1,
+			hint:              hint,
+		})
+	}
+
+	// the first container isn't affected by any sibling
+	resp, err := dynamicPolicy.GetTopologyHints(context.Background(), generateReq(0, nil))
+	as.Nil(err)
+	for _, hint := range resp.ResourceHints[string(v1.ResourceCPU)].Hints {
+		as.Equal(hint.Nodes[0] == 2, hint.Preferred)
+	}
+
+	_, err = dynamicPolicy.Allocate(context.Background(), generateReq(0, &pluginapi.TopologyHint{
+		Nodes:     []uint64{2},
+		Preferred: true,
+	}))
+	as.Nil(err)
+
+	// sibling containers prefer the NUMA of the first container
+	for containerIndex := uint64(1); containerIndex < 3; containerIndex++ {
+		resp, err = dynamicPolicy.GetTopologyHints(context.Background(), generateReq(containerIndex, nil))
+		as.Nil(err)
+		as.Equal([]*pluginapi.TopologyHint{
+			{Nodes: []uint64{0}, Preferred: false},
+			{Nodes: []uint64{1}, Preferred: false},
+			{Nodes: []uint64{2}, Preferred: true},
+			{Nodes: []uint64{3}, Preferred: false},
+		}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+		_, err = dynamicPolicy.Allocate(context.Background(), generateReq(containerIndex, &pluginapi.TopologyHint{
+			Nodes:     []uint64{2},
+			Preferred: true,
+		}))
+		as.Nil(err)
+	}
+}
+
+func TestSpreadHintsByReplicas(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestSpreadHintsByReplicas")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	// replicas would be packed onto the same NUMA without spreading
+	dynamicPolicy.cpuNUMAHintPreferPolicy = cpuconsts.CPUNUMAHintPreferPolicyPacking
+
+	generateReq := func(podUID, app string, spread bool) *pluginapi.ResourceRequest {
+		cpuEnhancement := ""
+		if spread {
+			cpuEnhancement = fmt.Sprintf(`{"%s": "app"}`, cpuconsts.PodAnnotationCPUNUMASpreadKey)
+		}
+		return newTestRequest(testRequest{
+			podUID:            podUID,
+			podNamespace:      app,
+			podName:           podUID,
+			containerName:     app,
+			qosLevel:          consts.PodAnnotationQoSLevelSharedCores,
+			memoryEnhancement: `{"numa_binding": "true"}`,
+			cpuEnhancement:    cpuEnhancement,
+			labels:            map[string]string{"app": app},
+			quantity:          1,
+		})
+	}
+
+	// admit calculates hints of the pod and allocates it with the first preferred hint
+	admit := func(podUID, app string, spread bool) (preferredNUMAs machine.CPUSet, numaID int) {
+		resp, err := dynamicPolicy.GetTopologyHints(context.Background(), generateReq(podUID, app, spread))
+		as.Nil(err)
+
+		preferredNUMAs = machine.NewCPUSet()
+		var preferredHint *pluginapi.TopologyHint
+		for _, hint := range resp.ResourceHints[string(v1.ResourceCPU)].Hints {
+			if !hint.Preferred {
+				continue
+			} else if preferredHint == nil {
+				preferredHint = hint
+			}
+			preferredNUMAs.Add(int(hint.Nodes[0]))
+		}
+		as.NotNil(preferredHint)
+
+		req := generateReq(podUID, app, spread)
+		req.Hint = preferredHint
+		_, err = dynamicPolicy.Allocate(context.Background(), req)
+		as.Nil(err)
+		return preferredNUMAs, int(preferredHint.Nodes[0])
+	}
+
+	// replicas of a workload without spreading are packed onto the same NUMA
+	_, packedNUMA := admit("packed-0", "packed", false)
+	_, numaID := admit("packed-1", "packed", false)
+	as.Equal(packedNUMA, numaID)
+
+	// replicas of a spread workload are steered to NUMAs without its replicas
+	spreadNUMAs := machine.NewCPUSet()
+	for i := 0; i < 3; i++ {
+		podUID := fmt.Sprintf("spread-%d", i)
+		preferredNUMAs, numaID := admit(podUID, "spread", true)
+		as.True(preferredNUMAs.Intersection(spreadNUMAs).IsEmpty(),
+			"replica %d preferred NUMAs: %s, NUMAs with replicas: %s", i, preferredNUMAs.String(), spreadNUMAs.String())
+		spreadNUMAs.Add(numaID)
+
+		// the label is kept in allocation info to count replicas by
+		as.Equal("spread", dynamicPolicy.state.GetAllocationInfo(podUID, "spread").Labels["app"])
+	}
+	as.Equal(3, spreadNUMAs.Size())
+
+	// spreading never leaves the next replica without preferred hints
+	preferredNUMAs, _ := admit("spread-3", "spread", true)
+	as.False(preferredNUMAs.IsEmpty())
+
+	// labels of workloads without spreading are filtered as before
+	as.Empty(dynamicPolicy.state.GetAllocationInfo("packed-0", "packed").Labels["app"])
+}
+
+type fakeContainerCPUSetManager struct {
+	mutex   sync.Mutex
+	cpusets map[string]machine.CPUSet
+	applied map[string]machine.CPUSet
+}
+
+func (f *fakeContainerCPUSetManager) GetCPUSet(_, containerID string) (machine.CPUSet, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	cpuset, ok := f.cpusets[containerID]
+	if !ok {
+		return machine.CPUSet{}, fmt.Errorf("container: %s not found", containerID)
+	}
+	return cpuset.Clone(), nil
+}
+
+func (f *fakeContainerCPUSetManager) ApplyCPUSet(_, containerID string, cpuset machine.CPUSet) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.applied == nil {
+		f.applied = make(map[string]machine.CPUSet)
+	}
+	f.applied[containerID] = cpuset.Clone()
+	f.cpusets[containerID] = cpuset.Clone()
+	return nil
+}
+
+func TestReconcileCPUSet(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testName := "test"
+	containerID := "test-container-id"
+
+	testCases := []struct {
+		name        string
+		actual      machine.CPUSet
+		enable      bool
+		wantDrift   bool
+		wantApplied bool
+	}{
+		{
+			name:   "no drift",
+			actual: machine.NewCPUSet(4, 5, 6, 7),
+		},
+		{
+			name:      "drift detected only",
+			actual:    machine.NewCPUSet(4, 5),
+			wantDrift: true,
+		},
+		{
+			name:        "drift repaired",
+			actual:      machine.NewCPUSet(4, 5),
+			enable:      true,
+			wantDrift:   true,
+			wantApplied: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestReconcileCPUSet")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+
+			emitter := &recordingMetricEmitter{}
+			cpusetManager := &fakeContainerCPUSetManager{
+				cpusets: map[string]machine.CPUSet{containerID: tc.actual},
+			}
+			dynamicPolicy.emitter = emitter
+			dynamicPolicy.cpusetManager = cpusetManager
+			dynamicPolicy.enableCPUSetDriftRepair = tc.enable
+
+			podUID := string(uuid.NewUUID())
+			dynamicPolicy.metaServer = &metaserver.MetaServer{
+				MetaAgent: &agent.MetaAgent{
+					PodFetcher: &pod.PodFetcherStub{
+						PodList: []*v1.Pod{
+							{
+								ObjectMeta: metav1.ObjectMeta{
+									Name:      testName,
+									Namespace: testName,
+									UID:       types.UID(podUID),
+								},
+								Status: v1.PodStatus{
+									ContainerStatuses: []v1.ContainerStatus{
+										{
+											Name:        testName,
+											ContainerID: containerID,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}
+
+			recordedCPUSet := machine.NewCPUSet(4, 5, 6, 7)
+			dynamicPolicy.state.SetAllocationInfo(podUID, testName, &state.AllocationInfo{
+				PodUid:                   podUID,
+				PodNamespace:             testName,
+				PodName:                  testName,
+				ContainerName:            testName,
+				ContainerType:            pluginapi.ContainerType_MAIN.String(),
+				OwnerPoolName:            state.PoolNameDedicated,
+				AllocationResult:         recordedCPUSet.Clone(),
+				OriginalAllocationResult: recordedCPUSet.Clone(),
+				TopologyAwareAssignments: map[int]machine.CPUSet{
+					1: recordedCPUSet.Clone(),
+				},
+				OriginalTopologyAwareAssignments: map[int]machine.CPUSet{
+					1: recordedCPUSet.Clone(),
+				},
+				Annotations: map[string]string{
+					consts.PodAnnotationQoSLevelKey:                  consts.PodAnnotationQoSLevelDedicatedCores,
+					consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+				},
+				QoSLevel:        consts.PodAnnotationQoSLevelDedicatedCores,
+				RequestQuantity: 4,
+			})
+
+			dynamicPolicy.reconcileCPUSet(nil, nil, nil, nil, nil)
+
+			_, drift := emitter.getRecord(util.MetricNameCPUSetDrift)
+			as.Equal(tc.wantDrift, drift)
+
+			applied, ok := cpusetManager.applied[containerID]
+			as.Equal(tc.wantApplied, ok)
+			if tc.wantApplied {
+				as.True(applied.Equals(recordedCPUSet))
+			}
+		})
+	}
+}
+
+func TestAllocateAppliesCPUSetByManager(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestAllocateAppliesCPUSetByManager")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	testName := "test"
+	containerID := "test-container-id"
+	podUID := string(uuid.NewUUID())
+
+	// the container starts with the default cpuset of the node before the allocation is applied
+	cpusetManager := &fakeContainerCPUSetManager{
+		cpusets: map[string]machine.CPUSet{containerID: cpuTopology.CPUDetails.CPUs()},
+	}
+	dynamicPolicy.cpusetManager = cpusetManager
+	dynamicPolicy.enableCPUSetDriftRepair = true
+	dynamicPolicy.metaServer = &metaserver.MetaServer{
+		MetaAgent: &agent.MetaAgent{
+			PodFetcher: &pod.PodFetcherStub{
+				PodList: []*v1.Pod{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      testName,
+							Namespace: testName,
+							UID:       types.UID(podUID),
+						},
+						Status: v1.PodStatus{
+							ContainerStatuses: []v1.ContainerStatus{
+								{
+									Name:        testName,
+									ContainerID: containerID,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	resp, err := dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+		PodUid:         podUID,
+		PodNamespace:   testName,
+		PodName:        testName,
+		ContainerName:  testName,
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		Hint:           &pluginapi.TopologyHint{Nodes: []uint64{1}, Preferred: true},
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 2,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	})
+	as.Nil(err)
+
+	allocatedCPUSet, err := machine.Parse(resp.AllocationResult.ResourceAllocation[string(v1.ResourceCPU)].AllocationResult)
+	as.Nil(err)
+	as.Equal(2, allocatedCPUSet.Size())
+
+	dynamicPolicy.reconcileCPUSet(nil, nil, nil, nil, nil)
+
+	applied, ok := cpusetManager.applied[containerID]
+	as.True(ok)
+	as.Equal(allocatedCPUSet.String(), applied.String())
+
+	// the written cpuset is read back by the check, so it's applied only once
+	delete(cpusetManager.applied, containerID)
+	dynamicPolicy.reconcileCPUSet(nil, nil, nil, nil, nil)
+	_, ok = cpusetManager.applied[containerID]
+	as.False(ok)
+}
+
+func TestServeAllocationState(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestServeAllocationState")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.offlineCPUs = machine.NewCPUSet(15)
+
+	podUID := string(uuid.NewUUID())
+	testName := "test"
+	allocated := machine.NewCPUSet(4, 5, 6, 7)
+	dynamicPolicy.state.SetAllocationInfo(podUID, testName, &state.AllocationInfo{
+		PodUid:                   podUID,
+		PodNamespace:             testName,
+		PodName:                  testName,
+		ContainerName:            testName,
+		ContainerType:            pluginapi.ContainerType_MAIN.String(),
+		OwnerPoolName:            state.PoolNameDedicated,
+		AllocationResult:         allocated.Clone(),
+		OriginalAllocationResult: allocated.Clone(),
+		TopologyAwareAssignments: map[int]machine.CPUSet{
+			1: allocated.Clone(),
+		},
+		OriginalTopologyAwareAssignments: map[int]machine.CPUSet{
+			1: allocated.Clone(),
+		},
+		QoSLevel:        consts.PodAnnotationQoSLevelDedicatedCores,
+		RequestQuantity: 4,
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/debug"+allocationStateDebugPath, nil)
+	w := httptest.NewRecorder()
+	dynamicPolicy.serveAllocationState(w, req)
+
+	as.Equal(http.StatusOK, w.Code)
+	as.Equal("application/json", w.Header().Get("Content-Type"))
+
+	snapshot := &allocationStateSnapshot{}
+	as.Nil(json.Unmarshal(w.Body.Bytes(), snapshot))
+
+	as.True(snapshot.ReservedCPUs.Equals(dynamicPolicy.reservedCPUs))
+	as.True(snapshot.OfflineCPUs.Equals(machine.NewCPUSet(15)))
+	as.Len(snapshot.MachineState, cpuTopology.NumNUMANodes)
+
+	allocationInfo := snapshot.PodEntries[podUID][testName]
+	as.NotNil(allocationInfo)
+	as.True(allocationInfo.AllocationResult.Equals(allocated))
+	as.True(allocationInfo.TopologyAwareAssignments[1].Equals(allocated))
+
+	as.Len(snapshot.CPUSetBreakdowns, cpuTopology.NumNUMANodes)
+	for numaID, breakdown := range snapshot.CPUSetBreakdowns {
+		as.Equal(breakdown.Total.Size(), breakdown.Reserved.Size()+breakdown.Offline.Size()+
+			breakdown.Allocated.Size()+breakdown.Available.Size(), "NUMA %d: %s", numaID, breakdown)
+	}
+	as.True(snapshot.CPUSetBreakdowns[3].Offline.Equals(machine.NewCPUSet(15)))
+}
+
+func TestServeTopology(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestServeTopology")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.offlineCPUs = machine.NewCPUSet(15)
+
+	w := httptest.NewRecorder()
+	dynamicPolicy.serveTopology(w, httptest.NewRequest(http.MethodGet, "/debug"+topologyDebugPath, nil))
+	as.Equal(http.StatusOK, w.Code)
+	as.Equal("application/json", w.Header().Get("Content-Type"))
+
+	snapshot := &machine.TopologySnapshot{}
+	as.Nil(json.Unmarshal(w.Body.Bytes(), snapshot))
+	as.Equal(cpuTopology.NumCPUs, snapshot.NumCPUs)
+	as.Equal(cpuTopology.NumNUMANodes, snapshot.NumNUMANodes)
+	as.Len(snapshot.Sockets, cpuTopology.NumSockets)
+	as.True(snapshot.ReservedCPUs.Equals(dynamicPolicy.reservedCPUs))
+	as.True(snapshot.OfflineCPUs.Equals(machine.NewCPUSet(15)))
+
+	for _, socket := range snapshot.Sockets {
+		for _, numaNode := range socket.NUMANodes {
+			cpus := machine.NewCPUSet()
+			for _, core := range numaNode.Cores {
+				cpus.Add(core.Threads...)
+			}
+			as.True(cpus.Equals(cpuTopology.CPUDetails.CPUsInNUMANodes(numaNode.ID)), "NUMA %d", numaNode.ID)
+		}
+	}
+}
+
+func TestReclaimedCoresNUMAOvercommit(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestReclaimedCoresNUMAOvercommit")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.reclaimedNUMAOvercommitRatio = 2.0
+
+	newReq := func(podUID string, quantity float64) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			podUID:   podUID,
+			qosLevel: consts.PodAnnotationQoSLevelReclaimedCores,
+			quantity: quantity,
+		})
+	}
+
+	// NUMA 2 has 4 allocatable cpus, so at most 8 reclaimed cores can be admitted with ratio 2.0
+	for i := 0; i < 2; i++ {
+		req := newReq(string(uuid.NewUUID()), 4)
+
+		hintsResp, err := dynamicPolicy.GetTopologyHints(context.Background(), req)
+		as.Nil(err)
+
+		var admitted bool
+		for _, hint := range hintsResp.ResourceHints[string(v1.ResourceCPU)].Hints {
+			if len(hint.Nodes) == 1 && hint.Nodes[0] == 2 {
+				admitted = true
+			}
+		}
+		as.True(admitted)
+
+		req.Hint = &pluginapi.TopologyHint{Nodes: []uint64{2}, Preferred: true}
+		_, err = dynamicPolicy.Allocate(context.Background(), req)
+		as.Nil(err)
+
+		allocationInfo := dynamicPolicy.state.GetAllocationInfo(req.PodUid, req.ContainerName)
+		numaHint, ok := allocationInfo.GetReclaimedNUMAHint()
+		as.True(ok)
+		as.Equal(2, numaHint)
+
+		// hint is kept for container already admitted
+		hintsResp, err = dynamicPolicy.GetTopologyHints(context.Background(), req)
+		as.Nil(err)
+		as.Equal([]*pluginapi.TopologyHint{{Nodes: []uint64{2}, Preferred: true}},
+			hintsResp.ResourceHints[string(v1.ResourceCPU)].Hints)
+	}
+
+	machineState := dynamicPolicy.state.GetMachineState()
+	reclaimedAllocatedQuantity := dynamicPolicy.state.GetPodEntries().GetReclaimedAllocatedQuantity()
+	as.Equal(8.0, reclaimedAllocatedQuantity[2])
+	as.Equal(0.0, machineState[2].GetReclaimedAvailableQuantity(dynamicPolicy.getUnavailableCPUs(), 2.0, reclaimedAllocatedQuantity[2]))
+
+	// admission to NUMA 2 stops at the cap
+	hintsResp, err := dynamicPolicy.GetTopologyHints(context.Background(), newReq(string(uuid.NewUUID()), 1))
+	as.Nil(err)
+	for _, hint := range hintsResp.ResourceHints[string(v1.ResourceCPU)].Hints {
+		as.NotEqual([]uint64{2}, hint.Nodes)
+	}
+
+	// request exceeding caps of all NUMAs is rejected
+	_, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(string(uuid.NewUUID()), 9))
+	as.NotNil(err)
+}
+
+func TestReclaimedCoresNUMACPUQuota(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestReclaimedCoresNUMACPUQuota")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	// quota alone enables per-NUMA admission of reclaimed_cores without overcommit ratio
+	dynamicPolicy.reclaimedNUMACPUQuota = map[int]float64{1: 3, 2: 0.5}
+
+	newReq := func(quantity float64) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			qosLevel: consts.PodAnnotationQoSLevelReclaimedCores,
+			quantity: quantity,
+		})
+	}
+
+	// NUMA 2 can't fit the request within its quota
+	req := newReq(2)
+	hintsResp, err := dynamicPolicy.GetTopologyHints(context.Background(), req)
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{0}, Preferred: true},
+		{Nodes: []uint64{1}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: true},
+	}, hintsResp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+	req.Hint = &pluginapi.TopologyHint{Nodes: []uint64{1}, Preferred: true}
+	_, err = dynamicPolicy.Allocate(context.Background(), req)
+	as.Nil(err)
+
+	numaHint, ok := dynamicPolicy.state.GetAllocationInfo(req.PodUid, req.ContainerName).GetReclaimedNUMAHint()
+	as.True(ok)
+	as.Equal(1, numaHint)
+	as.Equal(2.0, dynamicPolicy.state.GetPodEntries().GetReclaimedAllocatedQuantity()[1])
+
+	// NUMA 1 still has room for 1 cpu within its quota, but not for 2
+	hintsResp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(1))
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{0}, Preferred: true},
+		{Nodes: []uint64{1}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: true},
+	}, hintsResp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+	hintsResp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(2))
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{0}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: true},
+	}, hintsResp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+	// request exceeding quotas of all NUMAs is rejected
+	dynamicPolicy.reclaimedNUMACPUQuota = map[int]float64{0: 1, 1: 1, 2: 1, 3: 1}
+	_, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(2))
+	as.NotNil(err)
+}
+
+func TestLoadNUMAPinRules(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestLoadNUMAPinRules")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	numaNodes := machine.NewCPUSet(0, 1, 2, 3)
+	rulesFile := filepath.Join(tmpDir, "rules")
+
+	rules, err := loadNUMAPinRules(rulesFile, numaNodes)
+	as.Nil(err)
+	as.Empty(rules)
+
+	as.Nil(ioutil.WriteFile(rulesFile, []byte(`[
+		{"name": "pin-app", "namespaces": ["default"], "selector": {"matchLabels": {"app": "test"}}, "numaNodes": "1-2", "fallback": true},
+		{"name": "pin-all", "selector": {}, "numaNodes": "3"}
+	]`), 0o644))
+	rules, err = loadNUMAPinRules(rulesFile, numaNodes)
+	as.Nil(err)
+	as.Len(rules, 2)
+	as.Equal("pin-app", rules[0].name)
+	as.True(rules[0].numaNodes.Equals(machine.NewCPUSet(1, 2)))
+	as.True(rules[0].fallback)
+	as.True(rules[0].matches(&pluginapi.ResourceRequest{PodNamespace: "default", Labels: map[string]string{"app": "test"}}))
+	as.False(rules[0].matches(&pluginapi.ResourceRequest{PodNamespace: "other", Labels: map[string]string{"app": "test"}}))
+	as.False(rules[0].matches(&pluginapi.ResourceRequest{PodNamespace: "default", Labels: map[string]string{"app": "other"}}))
+	as.True(rules[1].matches(&pluginapi.ResourceRequest{PodNamespace: "other"}))
+	as.False(rules[1].fallback)
+
+	for _, content := range []string{
+		`{"name": "not-a-list"}`,
+		`[{"selector": {}, "numaNodes": "1"}]`,
+		`[{"name": "nil-selector", "numaNodes": "1"}]`,
+		`[{"name": "empty-numas", "selector": {}, "numaNodes": ""}]`,
+		`[{"name": "unknown-numa", "selector": {}, "numaNodes": "4"}]`,
+		`[{"name": "invalid-selector", "selector": {"matchExpressions": [{"key": "app", "operator": "Unknown"}]}, "numaNodes": "1"}]`,
+	} {
+		as.Nil(ioutil.WriteFile(rulesFile, []byte(content), 0o644))
+		_, err = loadNUMAPinRules(rulesFile, numaNodes)
+		as.NotNil(err, "content: %s", content)
+	}
+}
+
+func TestNUMAPinRules(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestNUMAPinRules")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.numaPinRulesFileAbsPath = filepath.Join(tmpDir, "rules")
+
+	generateReq := func(podLabels map[string]string, reqCPUs float64) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			qosLevel:          consts.PodAnnotationQoSLevelSharedCores,
+			memoryEnhancement: `{"numa_binding": "true"}`,
+			labels:            podLabels,
+			quantity:          reqCPUs,
+		})
+	}
+	getHintNUMAs := func(req *pluginapi.ResourceRequest) (machine.CPUSet, error) {
+		resp, err := dynamicPolicy.GetTopologyHints(context.Background(), req)
+		if err != nil {
+			return machine.NewCPUSet(), err
+		}
+
+		hintNUMAs := machine.NewCPUSet()
+		for _, hint := range resp.ResourceHints[string(v1.ResourceCPU)].Hints {
+			hintNUMAs = hintNUMAs.Union(machine.NewCPUSet(util.HintToIntArray(hint)...))
+		}
+		return hintNUMAs, nil
+	}
+
+	// rules are hot-reloaded from the file, the conflicting rule matched later is ignored
+	as.Nil(ioutil.WriteFile(dynamicPolicy.numaPinRulesFileAbsPath, []byte(`[
+		{"name": "pin-app", "selector": {"matchLabels": {"app": "pinned"}}, "numaNodes": "2"},
+		{"name": "pin-app-conflict", "selector": {"matchLabels": {"app": "pinned"}}, "numaNodes": "3"},
+		{"name": "pin-large", "selector": {"matchLabels": {"app": "large"}}, "numaNodes": "0"},
+		{"name": "pin-large-fallback", "selector": {"matchLabels": {"app": "large-fallback"}}, "numaNodes": "0", "fallback": true}
+	]`), 0o644))
+	dynamicPolicy.syncNUMAPinRules(nil, nil, nil, nil, nil)
+
+	// matching: pinned to the NUMA of the first matched rule
+	hintNUMAs, err := getHintNUMAs(generateReq(map[string]string{"app": "pinned"}, 2))
+	as.Nil(err)
+	as.True(hintNUMAs.Equals(machine.NewCPUSet(2)), "hint NUMAs: %s", hintNUMAs.String())
+
+	// non-matching: all NUMAs are candidates
+	hintNUMAs, err = getHintNUMAs(generateReq(map[string]string{"app": "other"}, 2))
+	as.Nil(err)
+	as.True(hintNUMAs.Equals(machine.NewCPUSet(0, 1, 2, 3)), "hint NUMAs: %s", hintNUMAs.String())
+
+	// NUMA 0 has only 2 available cpus, so pods pinned to it can't fit without fallback
+	hintNUMAs, err = getHintNUMAs(generateReq(map[string]string{"app": "large"}, 3))
+	as.True(err != nil || hintNUMAs.IsEmpty(), "hint NUMAs: %s", hintNUMAs.String())
+
+	hintNUMAs, err = getHintNUMAs(generateReq(map[string]string{"app": "large-fallback"}, 3))
+	as.Nil(err)
+	as.True(hintNUMAs.Equals(machine.NewCPUSet(1, 2, 3)), "hint NUMAs: %s", hintNUMAs.String())
+
+	// rules in effect are kept if the file turns invalid, and they're dropped with the file
+	as.Nil(ioutil.WriteFile(dynamicPolicy.numaPinRulesFileAbsPath, []byte(`invalid`), 0o644))
+	dynamicPolicy.syncNUMAPinRules(nil, nil, nil, nil, nil)
+	hintNUMAs, err = getHintNUMAs(generateReq(map[string]string{"app": "pinned"}, 2))
+	as.Nil(err)
+	as.True(hintNUMAs.Equals(machine.NewCPUSet(2)), "hint NUMAs: %s", hintNUMAs.String())
+
+	as.Nil(os.Remove(dynamicPolicy.numaPinRulesFileAbsPath))
+	dynamicPolicy.syncNUMAPinRules(nil, nil, nil, nil, nil)
+	hintNUMAs, err = getHintNUMAs(generateReq(map[string]string{"app": "pinned"}, 2))
+	as.Nil(err)
+	as.True(hintNUMAs.Equals(machine.NewCPUSet(0, 1, 2, 3)), "hint NUMAs: %s", hintNUMAs.String())
+}
+
+func TestPrometheusMetricsEmitter(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestPrometheusMetricsEmitter")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	recordingEmitter := &recordingMetricEmitter{}
+	prometheusEmitter := newPrometheusMetricsEmitter(recordingEmitter)
+	dynamicPolicy.emitter = prometheusEmitter.WithTags("test", metrics.MetricTag{
+		Key: util.QRMPluginPolicyTagName,
+		Val: cpuconsts.CPUResourcePluginPolicyNameDynamic,
+	})
+
+	as.Nil(dynamicPolicy.emitter.StoreInt64("test_count", 1, metrics.MetricTypeNameCount, metrics.MetricTag{Key: "numa", Val: "0"}))
+	as.Nil(dynamicPolicy.emitter.StoreInt64("test_count", 2, metrics.MetricTypeNameCount, metrics.MetricTag{Key: "numa", Val: "0"}))
+	as.Nil(dynamicPolicy.emitter.StoreInt64("test_up_down_count", 3, metrics.MetricTypeNameUpDownCount))
+	as.Nil(dynamicPolicy.emitter.StoreInt64("test_up_down_count", -1, metrics.MetricTypeNameUpDownCount))
+	as.Nil(dynamicPolicy.emitter.StoreFloat64("test_raw", 1.5, metrics.MetricTypeNameRaw, metrics.MetricTag{Key: "pod.name", Val: "a"}))
+	as.Nil(dynamicPolicy.emitter.StoreFloat64("test_raw", 2.5, metrics.MetricTypeNameRaw))
+
+	// pool size gauges are emitted as shared_cores container is allocated
+	_, err = dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+		PodUid:         string(uuid.NewUUID()),
+		PodNamespace:   "test",
+		PodName:        "test",
+		ContainerName:  "test",
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 2,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+		},
+	})
+	as.Nil(err)
+
+	// values are shared with the wrapped emitter, along with the same tags
+	tags, ok := recordingEmitter.getRecord("test_count")
+	as.True(ok)
+	as.Contains(tags, metrics.MetricTag{Key: "numa", Val: "0"})
+	as.Contains(tags, metrics.MetricTag{Key: util.QRMPluginPolicyTagName, Val: cpuconsts.CPUResourcePluginPolicyNameDynamic})
+	_, ok = recordingEmitter.getRecord(util.MetricNamePoolSize)
+	as.True(ok)
+
+	server := httptest.NewServer(prometheusEmitter.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	as.Nil(err)
+	defer func() { _ = resp.Body.Close() }()
+	as.Equal(http.StatusOK, resp.StatusCode)
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	as.Nil(err)
+
+	getSeries := func(name string) map[string]float64 {
+		family, ok := families[cpuconsts.CPUPluginDynamicPolicyName+"_"+name]
+		as.True(ok, "metric: %s", name)
+
+		series := make(map[string]float64)
+		for _, metric := range family.GetMetric() {
+			labels := make([]string, 0, len(metric.GetLabel()))
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, label.GetName()+"="+label.GetValue())
+			}
+
+			value := metric.GetGauge().GetValue()
+			if metric.GetCounter() != nil {
+				value = metric.GetCounter().GetValue()
+			}
+			series[strings.Join(labels, ",")] = value
+		}
+		return series
+	}
+
+	commonLabels := "emmit_unit=test,numa=0,policy=" + cpuconsts.CPUResourcePluginPolicyNameDynamic
+	as.Equal(map[string]float64{commonLabels: 3}, getSeries("test_count"))
+	as.Equal(dto.MetricType_COUNTER, families[cpuconsts.CPUPluginDynamicPolicyName+"_test_count"].GetType())
+
+	commonLabels = "emmit_unit=test,policy=" + cpuconsts.CPUResourcePluginPolicyNameDynamic
+	as.Equal(map[string]float64{commonLabels: 2}, getSeries("test_up_down_count"))
+	as.Equal(dto.MetricType_GAUGE, families[cpuconsts.CPUPluginDynamicPolicyName+"_test_up_down_count"].GetType())
+
+	// labels are consistent among series of the same metric, and invalid characters are replaced
+	as.Equal(map[string]float64{
+		"emmit_unit=test,pod_name=a,policy=" + cpuconsts.CPUResourcePluginPolicyNameDynamic: 1.5,
+		"emmit_unit=test,pod_name=,policy=" + cpuconsts.CPUResourcePluginPolicyNameDynamic:  2.5,
+	}, getSeries("test_raw"))
+
+	for labels := range getSeries(util.MetricNamePoolSize) {
+		as.Contains(labels, "poolName=")
+		as.Contains(labels, "pool_type=")
+	}
+}
+
+func TestSharedCoresWithNUMABindingResize(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestSharedCoresWithNUMABindingResize")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	testName := "test"
+	generateReq := func(podUID string, quantity float64, hint *pluginapi.TopologyHint) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			podUID:            podUID,
+			qosLevel:          consts.PodAnnotationQoSLevelSharedCores,
+			memoryEnhancement: `{"numa_binding": "true"}`,
+			quantity:          quantity,
+			hint:              hint,
+		})
+	}
+
+	// NUMA 2 has 4 allocatable cpus, 2 of them are left after the allocation
+	podUID := string(uuid.NewUUID())
+	_, err = dynamicPolicy.Allocate(context.Background(), generateReq(podUID, 2, &pluginapi.TopologyHint{
+		Nodes:     []uint64{2},
+		Preferred: true,
+	}))
+	as.Nil(err)
+
+	unavailableCPUs := dynamicPolicy.getUnavailableCPUs()
+	numaState := dynamicPolicy.state.GetMachineState()[2]
+	as.Equal(2, numaState.GetAvailableCPUQuantity(unavailableCPUs))
+	as.Equal(4, numaState.GetAvailableCPUQuantityExcludingContainer(unavailableCPUs, podUID, testName))
+
+	// growing from 2 to 4 cpus keeps the container in NUMA 2
+	resp, err := dynamicPolicy.GetTopologyHints(context.Background(), generateReq(podUID, 4, nil))
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{2}, Preferred: true},
+	}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+	// another container takes 1 cpu in NUMA 2, so growing to 4 cpus doesn't fit NUMA 2 anymore
+	_, err = dynamicPolicy.Allocate(context.Background(), generateReq(string(uuid.NewUUID()), 1, &pluginapi.TopologyHint{
+		Nodes:     []uint64{2},
+		Preferred: true,
+	}))
+	as.Nil(err)
+
+	resp, err = dynamicPolicy.GetTopologyHints(context.Background(), generateReq(podUID, 4, nil))
+	as.Nil(err)
+	for _, hint := range resp.ResourceHints[string(v1.ResourceCPU)].Hints {
+		as.NotEqual([]uint64{2}, hint.Nodes)
+	}
+}
+
+func TestPopulateHintsByPreferPolicyTieBreak(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name              string
+		preferPolicy      string
+		tieBreak          string
+		expectedPreferred []uint64
+	}{
+		{
+			name:              "spreading with default tie-break",
+			preferPolicy:      cpuconsts.CPUNUMAHintPreferPolicySpreading,
+			expectedPreferred: []uint64{1},
+		},
+		{
+			name:              "packing with lowest_numa_id tie-break",
+			preferPolicy:      cpuconsts.CPUNUMAHintPreferPolicyPacking,
+			tieBreak:          cpuconsts.CPUNUMAHintPreferTieBreakLowestNUMAID,
+			expectedPreferred: []uint64{1},
+		},
+		{
+			name:              "spreading with highest_numa_id tie-break",
+			preferPolicy:      cpuconsts.CPUNUMAHintPreferPolicySpreading,
+			tieBreak:          cpuconsts.CPUNUMAHintPreferTieBreakHighestNUMAID,
+			expectedPreferred: []uint64{3},
+		},
+		{
+			name:              "spreading without tie-break",
+			preferPolicy:      cpuconsts.CPUNUMAHintPreferPolicySpreading,
+			tieBreak:          cpuconsts.CPUNUMAHintPreferTieBreakNone,
+			expectedPreferred: []uint64{1, 2, 3},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestPopulateHintsByPreferPolicyTieBreak")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.cpuNUMAHintPreferTieBreak = tc.tieBreak
+			// all NUMAs have the same allocatable cpus without reservation
+			dynamicPolicy.reservedCPUs = machine.NewCPUSet()
+
+			hints := map[string]*pluginapi.ListOfTopologyHints{
+				string(v1.ResourceCPU): {Hints: []*pluginapi.TopologyHint{}},
+			}
+			dynamicPolicy.populateHintsByPreferPolicy(context.Background(), []int{3, 1, 2}, tc.preferPolicy, hints,
+				dynamicPolicy.state.GetSharedNUMAPools(), 1, 1, nil)
+
+			cpuHints := hints[string(v1.ResourceCPU)].Hints
+			as.Len(cpuHints, 3)
+
+			var preferred []uint64
+			for _, hint := range cpuHints {
+				if hint.Preferred {
+					preferred = append(preferred, hint.Nodes[0])
+				}
+			}
+			as.ElementsMatch(tc.expectedPreferred, preferred)
+		})
+	}
+}
+
+func TestGrowthFactorHeadroomHints(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testName := "test"
+
+	// NUMA 0 and NUMA 1 have 3 allocatable cpus, NUMA 2 and NUMA 3 have 4
+	testCases := []struct {
+		name              string
+		growthFactor      string
+		expectedPreferred uint64
+	}{
+		{
+			name:              "without growth factor",
+			expectedPreferred: 0,
+		},
+		{
+			name:              "growth factor with NUMAs qualified",
+			growthFactor:      "2.0",
+			expectedPreferred: 2,
+		},
+		{
+			name:              "growth factor without NUMAs qualified",
+			growthFactor:      "3.0",
+			expectedPreferred: 0,
+		},
+		{
+			name:              "invalid growth factor",
+			growthFactor:      "invalid",
+			expectedPreferred: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestGrowthFactorHeadroomHints")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.cpuNUMAHintPreferPolicy = cpuconsts.CPUNUMAHintPreferPolicyPacking
+
+			annotations := map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelSharedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+			}
+			if tc.growthFactor != "" {
+				annotations[consts.PodAnnotationCPUEnhancementKey] = fmt.Sprintf(`{"%s": "%s"}`,
+					cpuconsts.PodAnnotationCPUNUMABindingGrowthFactor, tc.growthFactor)
+			}
+
+			resp, err := dynamicPolicy.GetTopologyHints(context.Background(), &pluginapi.ResourceRequest{
+				PodUid:         string(uuid.NewUUID()),
+				PodNamespace:   testName,
+				PodName:        testName,
+				ContainerName:  testName,
+				ContainerType:  pluginapi.ContainerType_MAIN,
+				ContainerIndex: 0,
+				ResourceName:   string(v1.ResourceCPU),
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceCPU): 2,
+				},
+				Labels: map[string]string{
+					consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+				},
+				Annotations: annotations,
+			})
+			as.Nil(err)
+
+			cpuHints := resp.ResourceHints[string(v1.ResourceCPU)].Hints
+			as.Len(cpuHints, 4)
+			for _, hint := range cpuHints {
+				as.Equal(hint.Nodes[0] == tc.expectedPreferred, hint.Preferred)
+			}
+		})
+	}
+}
+
+func TestSoftNUMABindingHints(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testName := "test"
+
+	// NUMA 0 and NUMA 1 have 3 allocatable cpus, NUMA 2 and NUMA 3 have 4
+	testCases := []struct {
+		name         string
+		request      float64
+		soft         bool
+		wantHintsNum int
+		wantNoPrefer bool
+		wantErr      bool
+	}{
+		{
+			name:         "fit into single NUMA",
+			request:      2,
+			soft:         true,
+			wantHintsNum: 4,
+		},
+		{
+			name:         "no fit with soft binding",
+			request:      6,
+			soft:         true,
+			wantNoPrefer: true,
+		},
+		{
+			name:    "no fit without soft binding",
+			request: 6,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestSoftNUMABindingHints")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+
+			// annotations of the request are filtered in place, so it's regenerated for each call
+			podUID := string(uuid.NewUUID())
+			newReq := func() *pluginapi.ResourceRequest {
+				cpuEnhancement := ""
+				if tc.soft {
+					cpuEnhancement = fmt.Sprintf(`{"%s": "true"}`, cpuconsts.PodAnnotationCPUNUMABindingSoft)
+				}
+				return newTestRequest(testRequest{
+					podUID:            podUID,
+					qosLevel:          consts.PodAnnotationQoSLevelSharedCores,
+					memoryEnhancement: `{"numa_binding": "true"}`,
+					cpuEnhancement:    cpuEnhancement,
+					quantity:          tc.request,
+				})
+			}
+
+			resp, err := dynamicPolicy.GetTopologyHints(context.Background(), newReq())
+			if tc.wantErr {
+				as.NotNil(err)
+				return
+			}
+			as.Nil(err)
+
+			if !tc.wantNoPrefer {
+				as.Len(resp.ResourceHints[string(v1.ResourceCPU)].Hints, tc.wantHintsNum)
+				return
+			}
+			as.Nil(resp.ResourceHints[string(v1.ResourceCPU)])
+
+			// the degraded container is allocated in the shared pool without NUMA binding
+			_, err = dynamicPolicy.Allocate(context.Background(), newReq())
+			as.Nil(err)
+
+			allocationInfo := dynamicPolicy.state.GetAllocationInfo(podUID, testName)
+			as.NotNil(allocationInfo)
+			as.False(state.CheckNUMABinding(allocationInfo))
+			as.False(allocationInfo.AllocationResult.IsEmpty())
+
+			// the degraded container keeps no NUMA preference when hints are regenerated
+			resp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq())
+			as.Nil(err)
+			as.Nil(resp.ResourceHints[string(v1.ResourceCPU)])
+		})
+	}
+}
+
+func generateBatchAllocationRequests(podNum int) []*pluginapi.ResourceRequest {
+	testName := "test"
+	reqs := make([]*pluginapi.ResourceRequest, 0, podNum)
+	for i := 0; i < podNum; i++ {
+		req := &pluginapi.ResourceRequest{
+			PodUid:         fmt.Sprintf("pod-%d", i),
+			PodNamespace:   testName,
+			PodName:        fmt.Sprintf("pod-%d", i),
+			ContainerName:  testName,
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): 1,
+			},
+			Labels:      map[string]string{},
+			Annotations: map[string]string{},
+		}
+
+		switch i % 4 {
+		case 0, 1:
+			req.Labels[consts.PodAnnotationQoSLevelKey] = consts.PodAnnotationQoSLevelSharedCores
+			req.Annotations[consts.PodAnnotationQoSLevelKey] = consts.PodAnnotationQoSLevelSharedCores
+		case 2:
+			req.Labels[consts.PodAnnotationQoSLevelKey] = consts.PodAnnotationQoSLevelReclaimedCores
+			req.Annotations[consts.PodAnnotationQoSLevelKey] = consts.PodAnnotationQoSLevelReclaimedCores
+		case 3:
+			// dedicated_cores with numa_binding fails to be allocated without hint
+			req.Labels[consts.PodAnnotationQoSLevelKey] = consts.PodAnnotationQoSLevelDedicatedCores
+			req.Annotations[consts.PodAnnotationQoSLevelKey] = consts.PodAnnotationQoSLevelDedicatedCores
+			req.Annotations[consts.PodAnnotationMemoryEnhancementKey] = `{"numa_binding": "true", "numa_exclusive": "true"}`
+		}
+		reqs = append(reqs, req)
+	}
+
+	// the first dedicated_cores pod is given a hint, so that it's allocated successfully
+	reqs[3].Hint = &pluginapi.TopologyHint{Nodes: []uint64{2}, Preferred: true}
+	return reqs
+}
+
+func TestAllocateBatch(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	sequentialTmpDir, err := ioutil.TempDir("", "checkpoint-TestAllocateBatch-sequential")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(sequentialTmpDir) }()
+
+	batchTmpDir, err := ioutil.TempDir("", "checkpoint-TestAllocateBatch-batch")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(batchTmpDir) }()
+
+	sequentialPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, sequentialTmpDir)
+	as.Nil(err)
+	batchPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, batchTmpDir)
+	as.Nil(err)
+
+	// annotations of requests are filtered in place during allocation,
+	// so each of the following steps is given requests of its own
+	generateReqs := func() []*pluginapi.ResourceRequest {
+		return append(generateBatchAllocationRequests(20), nil)
+	}
+	reqs := generateReqs()
+
+	// sequential allocations are made in the same order as batch allocation
+	allocationReqs := make([]*allocationRequest, len(reqs))
+	for i, req := range generateReqs() {
+		allocationReqs[i], _, _ = sequentialPolicy.prepareAllocation(req)
+	}
+
+	sequentialReqs := generateReqs()
+	sequentialErrs := make([]error, len(reqs))
+	for _, i := range sequentialPolicy.sortAllocationRequests(context.Background(), allocationReqs) {
+		_, sequentialErrs[i] = sequentialPolicy.Allocate(context.Background(), sequentialReqs[i])
+	}
+
+	resps, batchErrs := batchPolicy.AllocateBatch(context.Background(), reqs)
+	as.Len(resps, len(reqs))
+	as.Len(batchErrs, len(reqs))
+
+	for i, req := range reqs {
+		as.Equal(sequentialErrs[i] != nil, batchErrs[i] != nil, "request %d", i)
+		if batchErrs[i] != nil {
+			as.Nil(resps[i])
+			continue
+		}
+		as.NotNil(resps[i])
+
+		sequentialAllocationInfo := sequentialPolicy.state.GetAllocationInfo(req.PodUid, req.ContainerName)
+		batchAllocationInfo := batchPolicy.state.GetAllocationInfo(req.PodUid, req.ContainerName)
+		as.NotNil(sequentialAllocationInfo)
+		as.NotNil(batchAllocationInfo)
+		as.Equal(sequentialAllocationInfo.AllocationResult.String(), batchAllocationInfo.AllocationResult.String(),
+			"request %d", i)
+	}
+
+	// failures are isolated
+	as.Nil(batchErrs[3])
+	as.NotNil(batchErrs[7])
+	as.Nil(batchPolicy.state.GetAllocationInfo(reqs[7].PodUid, reqs[7].ContainerName))
+	as.NotNil(batchErrs[len(reqs)-1])
+}
+
+func BenchmarkAllocateBatch(b *testing.B) {
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(b, err)
+
+	reqs := generateBatchAllocationRequests(200)
+
+	for _, batch := range []bool{false, true} {
+		b.Run(fmt.Sprintf("batch-%v", batch), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				tmpDir, err := ioutil.TempDir("", "checkpoint-BenchmarkAllocateBatch")
+				require.NoError(b, err)
+				dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+				require.NoError(b, err)
+				b.StartTimer()
+
+				if batch {
+					_, _ = dynamicPolicy.AllocateBatch(context.Background(), reqs)
+				} else {
+					for _, req := range reqs {
+						_, _ = dynamicPolicy.Allocate(context.Background(), req)
+					}
+				}
+
+				b.StopTimer()
+				_ = os.RemoveAll(tmpDir)
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func TestAllocateAllocatedConcurrently(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestAllocateAllocatedConcurrently")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	// dedicated_cores pods fail to be allocated without hint, except the first one
+	allocated := func(i int) bool { return i%4 != 3 || i == 3 }
+
+	const podNum = 40
+	for i, req := range generateBatchAllocationRequests(podNum) {
+		_, err = dynamicPolicy.Allocate(context.Background(), req)
+		as.Equal(allocated(i), err == nil, "request %d", i)
+	}
+	dedicatedResult := dynamicPolicy.state.GetAllocationInfo("pod-3", "test").AllocationResult.String()
+
+	// containers already allocated are admitted again (under the read-lock) concurrently
+	// with allocations of new containers and failed ones (under the write-lock)
+	reqs := generateBatchAllocationRequests(2 * podNum)
+	errs := make([]error, len(reqs))
+	var wg sync.WaitGroup
+	for i := range reqs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = dynamicPolicy.Allocate(context.Background(), reqs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range reqs {
+		as.Equal(allocated(i), errs[i] == nil, "request %d", i)
+		allocationInfo := dynamicPolicy.state.GetAllocationInfo(reqs[i].PodUid, reqs[i].ContainerName)
+		as.Equal(allocated(i), allocationInfo != nil, "request %d", i)
+	}
+	as.Equal(dedicatedResult, dynamicPolicy.state.GetAllocationInfo("pod-3", "test").AllocationResult.String())
+
+	status := dynamicPolicy.GetHealthStatus()
+	as.True(status.MachineStateConsistent, "%v", status.Reasons)
+	as.False(status.LastSuccessfulAdmissionTime.IsZero())
+}
+
+func BenchmarkAllocateAllocated(b *testing.B) {
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(b, err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-BenchmarkAllocateAllocated")
+	require.NoError(b, err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	require.NoError(b, err)
+
+	const podNum = 40
+	for _, req := range generateBatchAllocationRequests(podNum) {
+		_, _ = dynamicPolicy.Allocate(context.Background(), req)
+	}
+
+	// write-lock admits containers in the way before they're admitted under the read-lock
+	for _, readLock := range []bool{false, true} {
+		b.Run(fmt.Sprintf("read-lock-%v", readLock), func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				// annotations of requests are filtered in place, so each goroutine is given requests of its own
+				var reqs []*pluginapi.ResourceRequest
+				for i := 0; pb.Next(); i++ {
+					if i%podNum == 0 {
+						reqs = generateBatchAllocationRequests(podNum)
+					}
+
+					req := reqs[i%podNum]
+					if readLock {
+						_, _ = dynamicPolicy.Allocate(context.Background(), req)
+						continue
+					}
+
+					allocationReq, _, err := dynamicPolicy.prepareAllocation(req)
+					if err != nil || allocationReq == nil {
+						continue
+					}
+					dynamicPolicy.Lock()
+					_, _ = dynamicPolicy.allocateWithLock(context.Background(), allocationReq)
+					dynamicPolicy.Unlock()
+				}
+			})
+		})
+	}
+}
+
+// generateNUMABindingDedicatedRequests generates requests of numa_binding dedicated_cores pods (not NUMA exclusive)
+// requesting 1 cpu each, and they're hinted to NUMAs in turn
+func generateNUMABindingDedicatedRequests(podUIDPrefix string, podNum, numaNum int) []*pluginapi.ResourceRequest {
+	reqs := make([]*pluginapi.ResourceRequest, 0, podNum)
+	for i := 0; i < podNum; i++ {
+		reqs = append(reqs, newTestRequest(testRequest{
+			podUID:            fmt.Sprintf("%s-%d", podUIDPrefix, i),
+			podName:           fmt.Sprintf("%s-%d", podUIDPrefix, i),
+			qosLevel:          consts.PodAnnotationQoSLevelDedicatedCores,
+			memoryEnhancement: `{"numa_binding": "true", "numa_exclusive": "false"}`,
+			quantity:          1,
+			hint:              &pluginapi.TopologyHint{Nodes: []uint64{uint64(i % numaNum)}, Preferred: true},
+		}))
+	}
+	return reqs
+}
+
+// fakeAdvisorClient is the cpu advisor client taking the given latency to add containers,
+// and adding containers fails if err is set
+type fakeAdvisorClient struct {
+	advisorapi.CPUAdvisorClient
+	latency time.Duration
+	err     error
+	// adding is notified of each container being added if it's set, and adding is blocked until release is closed
+	adding  chan string
+	release chan struct{}
+}
+
+func (c *fakeAdvisorClient) AddContainer(_ context.Context, in *advisorsvc.ContainerMetadata,
+	_ ...grpc.CallOption,
+) (*advisorsvc.AddContainerResponse, error) {
+	if c.adding != nil {
+		c.adding <- in.PodUid
+		<-c.release
+	}
+	time.Sleep(c.latency)
+	return &advisorsvc.AddContainerResponse{}, c.err
+}
+
+func (c *fakeAdvisorClient) RemovePod(context.Context, *advisorsvc.RemovePodRequest,
+	...grpc.CallOption,
+) (*advisorsvc.RemovePodResponse, error) {
+	return &advisorsvc.RemovePodResponse{}, nil
+}
+
+func TestAllocateNUMABindingConcurrently(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestAllocateNUMABindingConcurrently")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.enableCPUAdvisor = true
+	dynamicPolicy.advisorClient = &fakeAdvisorClient{latency: time.Millisecond}
+
+	// each NUMA has at least 3 cpus available, and 2 pods are admitted to each of them concurrently
+	numaNum := cpuTopology.CPUDetails.NUMANodes().Size()
+	reqs := generateNUMABindingDedicatedRequests("pod", 2*numaNum, numaNum)
+	errs := make([]error, len(reqs))
+	var wg sync.WaitGroup
+	for i := range reqs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = dynamicPolicy.Allocate(context.Background(), reqs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	allocatedCPUs := machine.NewCPUSet()
+	for i, req := range reqs {
+		as.Nil(errs[i], "request %d", i)
+		allocationInfo := dynamicPolicy.state.GetAllocationInfo(req.PodUid, req.ContainerName)
+		as.NotNil(allocationInfo, "request %d", i)
+		as.Equal(1, allocationInfo.AllocationResult.Size(), "request %d", i)
+		as.True(allocationInfo.AllocationResult.IsSubsetOf(cpuTopology.CPUDetails.CPUsInNUMANodes(i%numaNum)),
+			"request %d is allocated with %s", i, allocationInfo.AllocationResult.String())
+		as.Zero(allocationInfo.AllocationResult.IntersectionSize(dynamicPolicy.reservedCPUs), "request %d", i)
+
+		// no cpu is allocated to different containers
+		as.Zero(allocationInfo.AllocationResult.IntersectionSize(allocatedCPUs), "request %d", i)
+		allocatedCPUs = allocatedCPUs.Union(allocationInfo.AllocationResult)
+	}
+
+	status := dynamicPolicy.GetHealthStatus()
+	as.True(status.MachineStateConsistent, "%v", status.Reasons)
+}
+
+func TestAllocateWithStaleNUMABindingCPUsPlan(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestAllocateWithStaleNUMABindingCPUsPlan")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	// all pods are hinted to NUMA 1
+	reqs := generateNUMABindingDedicatedRequests("pod", 3, 1)
+	for _, req := range reqs {
+		req.Hint = &pluginapi.TopologyHint{Nodes: []uint64{1}, Preferred: true}
+	}
+
+	planCPUs := func(req *pluginapi.ResourceRequest) (*allocationRequest, *numaBindingCPUsPlan) {
+		allocationReq, _, err := dynamicPolicy.prepareAllocation(req)
+		as.Nil(err)
+		as.True(needNUMABindingCPUsPlan(allocationReq))
+
+		plan := dynamicPolicy.planNUMABindingCPUs(context.Background(), allocationReq)
+		as.NotNil(plan)
+		return allocationReq, plan
+	}
+	commit := func(allocationReq *allocationRequest, plan *numaBindingCPUsPlan) machine.CPUSet {
+		dynamicPolicy.Lock()
+		_, err := dynamicPolicy.allocateWithLock(withNUMABindingCPUsPlan(context.Background(), plan), allocationReq)
+		dynamicPolicy.Unlock()
+		as.Nil(err)
+		return dynamicPolicy.state.GetAllocationInfo(allocationReq.req.PodUid, allocationReq.req.ContainerName).AllocationResult
+	}
+
+	// the plan is committed if available cpus are unchanged
+	allocationReq, plan := planCPUs(reqs[0])
+	as.Equal(plan.cpus.String(), commit(allocationReq, plan).String())
+
+	// cpus of the plan are taken by another pod before it's committed, so cpus are taken again
+	allocationReq, plan = planCPUs(reqs[1])
+	_, err = dynamicPolicy.Allocate(context.Background(), reqs[2])
+	as.Nil(err)
+	takenCPUs := dynamicPolicy.state.GetAllocationInfo(reqs[2].PodUid, reqs[2].ContainerName).AllocationResult
+	as.Equal(plan.cpus.String(), takenCPUs.String())
+
+	result := commit(allocationReq, plan)
+	as.Equal(1, result.Size())
+	as.Zero(result.IntersectionSize(takenCPUs))
+	as.True(result.IsSubsetOf(cpuTopology.CPUDetails.CPUsInNUMANodes(1)))
+
+	status := dynamicPolicy.GetHealthStatus()
+	as.True(status.MachineStateConsistent, "%v", status.Reasons)
+}
+
+func TestAllocateAddsContainerToAdvisorWithoutLock(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestAllocateAddsContainerToAdvisorWithoutLock")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	advisorClient := &fakeAdvisorClient{
+		err:     fmt.Errorf("advisor unavailable"),
+		adding:  make(chan string),
+		release: make(chan struct{}),
+	}
+	dynamicPolicy.enableCPUAdvisor = true
+	dynamicPolicy.advisorClient = advisorClient
+
+	req := generateNUMABindingDedicatedRequests("pod", 1, 1)[0]
+	errCh := make(chan error)
+	go func() {
+		_, err := dynamicPolicy.Allocate(context.Background(), req)
+		errCh <- err
+	}()
+	as.Equal(req.PodUid, <-advisorClient.adding)
+
+	// the policy lock isn't held while sys-advisor is called
+	locked := make(chan struct{})
+	go func() {
+		dynamicPolicy.Lock()
+		defer dynamicPolicy.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(10 * time.Second):
+		as.Fail("policy lock is held while sys-advisor is called")
+	}
+	as.NotNil(dynamicPolicy.state.GetAllocationInfo(req.PodUid, req.ContainerName))
+
+	// the container is removed since sys-advisor fails to add it
+	close(advisorClient.release)
+	as.NotNil(<-errCh)
+	as.Nil(dynamicPolicy.state.GetAllocationInfo(req.PodUid, req.ContainerName))
+
+	status := dynamicPolicy.GetHealthStatus()
+	as.True(status.MachineStateConsistent, "%v", status.Reasons)
+}
+
+func BenchmarkAllocateNUMABindingConcurrently(b *testing.B) {
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(b, err)
+	numaNum := cpuTopology.CPUDetails.NUMANodes().Size()
+
+	// serialized admissions hold a single lock all the way (including the call to sys-advisor) as before,
+	// while concurrent ones plan cpus on different NUMAs and call sys-advisor concurrently
+	for _, serialized := range []bool{true, false} {
+		b.Run(fmt.Sprintf("serialized-%v", serialized), func(b *testing.B) {
+			tmpDir, err := ioutil.TempDir("", "checkpoint-BenchmarkAllocateNUMABindingConcurrently")
+			require.NoError(b, err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			require.NoError(b, err)
+			dynamicPolicy.enableCPUAdvisor = true
+			dynamicPolicy.advisorClient = &fakeAdvisorClient{latency: 100 * time.Microsecond}
+
+			var serializedMutex sync.Mutex
+			var podIndex, goroutineIndex int64
+			b.RunParallel(func(pb *testing.PB) {
+				numaID := uint64(atomic.AddInt64(&goroutineIndex, 1)) % uint64(numaNum)
+				for pb.Next() {
+					// each pod is removed right after it's admitted, so NUMAs never run out of cpus
+					req := generateNUMABindingDedicatedRequests(fmt.Sprintf("pod-%d", atomic.AddInt64(&podIndex, 1)), 1, 1)[0]
+					req.Hint = &pluginapi.TopologyHint{Nodes: []uint64{numaID}, Preferred: true}
+
+					if serialized {
+						serializedMutex.Lock()
+					}
+					_, _ = dynamicPolicy.Allocate(context.Background(), req)
+					_, _ = dynamicPolicy.RemovePod(context.Background(), &pluginapi.RemovePodRequest{PodUid: req.PodUid})
+					if serialized {
+						serializedMutex.Unlock()
+					}
+				}
+			})
+		})
+	}
+}
+
+// stateWriteCounter counts how many times the state is written (i.e. persisted if it's backed by checkpoint)
+type stateWriteCounter struct {
+	state.State
+	writes int
+}
+
+func (s *stateWriteCounter) SetMachineState(numaNodeMap state.NUMANodeMap) {
+	s.writes++
+	s.State.SetMachineState(numaNodeMap)
+}
+
+func (s *stateWriteCounter) SetPodEntries(podEntries state.PodEntries) {
+	s.writes++
+	s.State.SetPodEntries(podEntries)
+}
+
+func (s *stateWriteCounter) SetAllocationInfo(podUID string, containerName string, allocationInfo *state.AllocationInfo) {
+	s.writes++
+	s.State.SetAllocationInfo(podUID, containerName, allocationInfo)
+}
+
+func (s *stateWriteCounter) SetPodEntriesAndMachineState(podEntries state.PodEntries, numaNodeMap state.NUMANodeMap) {
+	s.writes++
+	s.State.SetPodEntriesAndMachineState(podEntries, numaNodeMap)
+}
+
+func (s *stateWriteCounter) Delete(podUID string, containerName string) {
+	s.writes++
+	s.State.Delete(podUID, containerName)
+}
+
+// generateMultiContainerPodRequests generates requests of a numa_binding dedicated_cores pod
+// with a main container and the given number of sidecars
+func generateMultiContainerPodRequests(podUID string, sidecars int) []*pluginapi.ResourceRequest {
+	reqs := make([]*pluginapi.ResourceRequest, 0, sidecars+1)
+	for i := 0; i <= sidecars; i++ {
+		containerName, containerType := "main", pluginapi.ContainerType_MAIN
+		if i > 0 {
+			containerName, containerType = fmt.Sprintf("sidecar-%d", i), pluginapi.ContainerType_SIDECAR
+		}
+
+		reqs = append(reqs, &pluginapi.ResourceRequest{
+			PodUid:         podUID,
+			PodNamespace:   podUID,
+			PodName:        podUID,
+			ContainerName:  containerName,
+			ContainerType:  containerType,
+			ContainerIndex: uint64(i),
+			ResourceName:   string(v1.ResourceCPU),
+			Hint:           &pluginapi.TopologyHint{Nodes: []uint64{0}, Preferred: true},
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): 2,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		})
+	}
+	return reqs
+}
+
+func TestRemovePodAtOnce(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	newPolicy := func(podUID string) *DynamicPolicy {
+		tmpDir, err := ioutil.TempDir("", "checkpoint-TestRemovePodAtOnce")
+		as.Nil(err)
+		t.Cleanup(func() { _ = os.RemoveAll(tmpDir) })
+
+		dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+		as.Nil(err)
+
+		for _, req := range generateMultiContainerPodRequests(podUID, 4) {
+			_, err = dynamicPolicy.Allocate(context.Background(), req)
+			as.Nil(err)
+		}
+		_, err = dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+			PodUid:         "shared",
+			PodNamespace:   "shared",
+			PodName:        "shared",
+			ContainerName:  "shared",
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): 2,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+			},
+		})
+		as.Nil(err)
+		as.Len(dynamicPolicy.state.GetPodEntries()[podUID], 5)
+		return dynamicPolicy
+	}
+
+	podUID := string(uuid.NewUUID())
+	perContainerPolicy := newPolicy(podUID)
+	podPolicy := newPolicy(podUID)
+
+	perContainerPolicy.Lock()
+	for containerName := range perContainerPolicy.state.GetPodEntries()[podUID] {
+		as.Nil(perContainerPolicy.removeContainer(podUID, containerName))
+	}
+	perContainerPolicy.Unlock()
+
+	writeCounter := &stateWriteCounter{State: podPolicy.state}
+	podPolicy.Lock()
+	podPolicy.state = writeCounter
+	as.Nil(podPolicy.removePod(podUID))
+	podPolicy.Unlock()
+
+	// all containers are released with only one state write
+	as.Equal(1, writeCounter.writes)
+	as.Empty(podPolicy.state.GetPodEntries()[podUID])
+	as.Equal(perContainerPolicy.state.GetMachineState().String(), podPolicy.state.GetMachineState().String())
+	as.Equal(perContainerPolicy.state.GetAllocationInfo("shared", "shared").AllocationResult.String(),
+		podPolicy.state.GetAllocationInfo("shared", "shared").AllocationResult.String())
+	as.True(podPolicy.state.GetMachineState()[0].AllocatedCPUSet.IsEmpty())
+}
+
+func BenchmarkRemovePod(b *testing.B) {
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(b, err)
+
+	for _, perContainer := range []bool{true, false} {
+		b.Run(fmt.Sprintf("per-container-%v", perContainer), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				tmpDir, err := ioutil.TempDir("", "checkpoint-BenchmarkRemovePod")
+				require.NoError(b, err)
+				dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+				require.NoError(b, err)
+
+				podUID := string(uuid.NewUUID())
+				reqs := generateMultiContainerPodRequests(podUID, 4)
+				for _, req := range reqs {
+					_, err = dynamicPolicy.Allocate(context.Background(), req)
+					require.NoError(b, err)
+				}
+				b.StartTimer()
+
+				dynamicPolicy.Lock()
+				if perContainer {
+					for _, req := range reqs {
+						_ = dynamicPolicy.removeContainer(podUID, req.ContainerName)
+					}
+				} else {
+					_ = dynamicPolicy.removePod(podUID)
+				}
+				dynamicPolicy.Unlock()
+
+				b.StopTimer()
+				_ = os.RemoveAll(tmpDir)
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func TestRecoverStateFromCorruptedCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestRecoverStateFromCorruptedCheckpoint")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	// corrupt the checkpoint
+	as.Nil(ioutil.WriteFile(filepath.Join(tmpDir, cpuPluginStateFileName), []byte("{"), 0o644))
+
+	makePod := func(name, qosLevel string, annotations map[string]string) *v1.Pod {
+		annotations = general.MergeMap(annotations, map[string]string{consts.PodAnnotationQoSLevelKey: qosLevel})
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				UID:         types.UID(name),
+				Namespace:   "test",
+				Name:        name,
+				Annotations: annotations,
+			},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{
+					{
+						Name: name,
+						Resources: v1.ResourceRequirements{
+							Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")},
+						},
+					},
+				},
+			},
+			Status: v1.PodStatus{
+				ContainerStatuses: []v1.ContainerStatus{
+					{Name: name, ContainerID: "containerd://" + name},
+				},
+			},
+		}
+	}
+
+	// numa_binding shared_cores pod with a sidecar, and the main container is specified by annotation
+	sharedNUMABindingPod := makePod("shared-numa", consts.PodAnnotationQoSLevelSharedCores, map[string]string{
+		consts.PodAnnotationMemoryEnhancementKey:  `{"numa_binding": "true"}`,
+		coreconsts.MainContainerNameAnnotationKey: "shared-numa",
+	})
+	sharedNUMABindingPod.Spec.Containers = append([]v1.Container{{Name: "sidecar"}}, sharedNUMABindingPod.Spec.Containers...)
+	sharedNUMABindingPod.Status.ContainerStatuses = append(sharedNUMABindingPod.Status.ContainerStatuses,
+		v1.ContainerStatus{Name: "sidecar", ContainerID: "containerd://sidecar"})
+
+	podFetcher := &pod.PodFetcherStub{
+		PodList: []*v1.Pod{
+			makePod("shared", consts.PodAnnotationQoSLevelSharedCores, nil),
+			makePod("reclaimed", consts.PodAnnotationQoSLevelReclaimedCores, nil),
+			makePod("dedicated", consts.PodAnnotationQoSLevelDedicatedCores, map[string]string{
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+			}),
+			sharedNUMABindingPod,
+			// numa_binding shared_cores pod with cpuset across NUMAs is refused
+			makePod("shared-numa-cross", consts.PodAnnotationQoSLevelSharedCores, map[string]string{
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+			}),
+			// pod without cpuset in cgroup is skipped
+			makePod("unknown", consts.PodAnnotationQoSLevelSharedCores, nil),
+		},
+	}
+	cpusetManager := &fakeContainerCPUSetManager{
+		cpusets: map[string]machine.CPUSet{
+			"shared":            machine.NewCPUSet(2, 3, 10, 11),
+			"reclaimed":         machine.NewCPUSet(6, 7, 14, 15),
+			"dedicated":         machine.NewCPUSet(4, 5, 12, 13),
+			"shared-numa":       machine.NewCPUSet(1, 9),
+			"sidecar":           machine.NewCPUSet(1, 9),
+			"shared-numa-cross": machine.NewCPUSet(1, 3),
+		},
+	}
+
+	_, err = state.NewCheckpointState(tmpDir, cpuPluginStateFileName,
+		cpuconsts.CPUResourcePluginPolicyNameDynamic, cpuTopology, false)
+	as.NotNil(err)
+
+	recoveredState, err := state.NewCheckpointStateWithRecoverer(tmpDir, cpuPluginStateFileName,
+		cpuconsts.CPUResourcePluginPolicyNameDynamic, cpuTopology, false,
+		newPodEntriesRecoverer(podFetcher, cpusetManager, generic.NewQoSConfiguration(), cpuTopology))
+	as.Nil(err)
+
+	for _, tc := range []struct {
+		podUID        string
+		ownerPoolName string
+		cpuset        machine.CPUSet
+	}{
+		{podUID: "shared", ownerPoolName: state.PoolNameShare, cpuset: machine.NewCPUSet(2, 3, 10, 11)},
+		{podUID: "reclaimed", ownerPoolName: state.PoolNameReclaim, cpuset: machine.NewCPUSet(6, 7, 14, 15)},
+		{podUID: "dedicated", ownerPoolName: state.PoolNameDedicated, cpuset: machine.NewCPUSet(4, 5, 12, 13)},
+		{podUID: "shared-numa", ownerPoolName: state.GetNUMAPoolName(state.PoolNameShare, 0), cpuset: machine.NewCPUSet(1, 9)},
+	} {
+		allocationInfo := recoveredState.GetAllocationInfo(tc.podUID, tc.podUID)
+		as.NotNil(allocationInfo, tc.podUID)
+		as.Equal(tc.ownerPoolName, allocationInfo.OwnerPoolName)
+		as.True(allocationInfo.AllocationResult.Equals(tc.cpuset), tc.podUID)
+		as.Equal(2.0, allocationInfo.RequestQuantity)
+		as.Equal(pluginapi.ContainerType_MAIN.String(), allocationInfo.ContainerType, tc.podUID)
+	}
+	as.True(state.CheckNUMABinding(recoveredState.GetAllocationInfo("dedicated", "dedicated")))
+	as.Nil(recoveredState.GetAllocationInfo("unknown", "unknown"))
+	as.Nil(recoveredState.GetAllocationInfo("shared-numa-cross", "shared-numa-cross"))
+
+	// NUMA hint is recorded for main container, and sidecar is in the same pool as its main container
+	as.Equal("0", recoveredState.GetAllocationInfo("shared-numa", "shared-numa").
+		Annotations[cpuconsts.CPUStateAnnotationKeyNUMAHint])
+	sidecarAllocationInfo := recoveredState.GetAllocationInfo("shared-numa", "sidecar")
+	as.NotNil(sidecarAllocationInfo)
+	as.Equal(pluginapi.ContainerType_SIDECAR.String(), sidecarAllocationInfo.ContainerType)
+	as.Equal(state.GetNUMAPoolName(state.PoolNameShare, 0), sidecarAllocationInfo.OwnerPoolName)
+	as.True(recoveredState.GetAllocationInfo(state.GetNUMAPoolName(state.PoolNameShare, 0), state.FakedContainerName).
+		AllocationResult.Equals(machine.NewCPUSet(1, 9)))
+
+	as.True(recoveredState.GetAllocationInfo(state.PoolNameShare, state.FakedContainerName).
+		AllocationResult.Equals(machine.NewCPUSet(2, 3, 10, 11)))
+	as.True(recoveredState.GetAllocationInfo(state.PoolNameReclaim, state.FakedContainerName).
+		AllocationResult.Equals(machine.NewCPUSet(6, 7, 14, 15)))
+	as.True(recoveredState.GetMachineState()[2].AllocatedCPUSet.Equals(machine.NewCPUSet(4, 5, 12, 13)))
+
+	// a fresh checkpoint is rewritten
+	restoredState, err := state.NewCheckpointState(tmpDir, cpuPluginStateFileName,
+		cpuconsts.CPUResourcePluginPolicyNameDynamic, cpuTopology, false)
+	as.Nil(err)
+	as.Equal(recoveredState.GetPodEntries(), restoredState.GetPodEntries())
+}
+
+type fakeContainerCPUQuotaApplier struct {
+	mutex   sync.Mutex
+	applied map[string]int64
+}
+
+func (f *fakeContainerCPUQuotaApplier) ApplyCPUQuota(_, containerID string, cpuQuota int64, _ uint64) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.applied == nil {
+		f.applied = make(map[string]int64)
+	}
+	f.applied[containerID] = cpuQuota
+	return nil
+}
+
+// generateTestNUMADistanceMap generates distances of NUMAs with two NUMAs per socket,
+// i.e. 10 for local, 12 for NUMAs in the same socket and 32 for NUMAs across sockets
+func generateTestNUMADistanceMap(numaNum int) map[int][]machine.NumaDistanceInfo {
+	numaDistanceMap := make(map[int][]machine.NumaDistanceInfo, numaNum)
+	for i := 0; i < numaNum; i++ {
+		for j := 0; j < numaNum; j++ {
+			distance := 32
+			if i == j {
+				distance = 10
+			} else if i/2 == j/2 {
+				distance = 12
+			}
+			numaDistanceMap[i] = append(numaDistanceMap[i], machine.NumaDistanceInfo{NumaID: j, Distance: distance})
+		}
+	}
+	return numaDistanceMap
+}
+
+func TestApplyNUMADistancePenalty(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testName := "test"
+	containerID := "test-container-id"
+	penaltyCurve := map[int]float64{12: 0.9, 32: 0.7}
+
+	testCases := []struct {
+		name          string
+		numaNodes     []int
+		curve         map[int]float64
+		cpuLimit      string
+		expectApplied bool
+		expectQuota   int64
+	}{
+		{
+			name:          "single numa",
+			numaNodes:     []int{0},
+			curve:         penaltyCurve,
+			cpuLimit:      "4",
+			expectApplied: true,
+			expectQuota:   400000,
+		},
+		{
+			name:          "numas in the same socket",
+			numaNodes:     []int{0, 1},
+			curve:         penaltyCurve,
+			cpuLimit:      "4",
+			expectApplied: true,
+			expectQuota:   360000,
+		},
+		{
+			name:          "numas across sockets",
+			numaNodes:     []int{0, 2},
+			curve:         penaltyCurve,
+			cpuLimit:      "4",
+			expectApplied: true,
+			expectQuota:   280000,
+		},
+		{
+			name:          "all numas",
+			numaNodes:     []int{0, 1, 2, 3},
+			curve:         penaltyCurve,
+			cpuLimit:      "2500m",
+			expectApplied: true,
+			expectQuota:   175000,
+		},
+		{
+			name:          "distance below all entries of curve",
+			numaNodes:     []int{2, 3},
+			curve:         map[int]float64{32: 0.7},
+			cpuLimit:      "4",
+			expectApplied: true,
+			expectQuota:   400000,
+		},
+		{
+			name:          "penalty disabled",
+			numaNodes:     []int{0, 2},
+			curve:         map[int]float64{},
+			cpuLimit:      "4",
+			expectApplied: false,
+		},
+		{
+			name:          "container without cpu limit",
+			numaNodes:     []int{0, 2},
+			curve:         penaltyCurve,
+			expectApplied: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestApplyNUMADistancePenalty")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+
+			quotaApplier := &fakeContainerCPUQuotaApplier{}
+			dynamicPolicy.cpuQuotaApplier = quotaApplier
+			dynamicPolicy.numaDistancePenaltyCurve = tc.curve
+			dynamicPolicy.machineInfo.ExtraTopologyInfo = &machine.ExtraTopologyInfo{
+				NumaDistanceMap: generateTestNUMADistanceMap(cpuTopology.NumNUMANodes),
+			}
+
+			resources := v1.ResourceRequirements{}
+			if tc.cpuLimit != "" {
+				resources.Limits = v1.ResourceList{v1.ResourceCPU: resource.MustParse(tc.cpuLimit)}
+			}
+
+			podUID := string(uuid.NewUUID())
+			dynamicPolicy.metaServer = &metaserver.MetaServer{
+				MetaAgent: &agent.MetaAgent{
+					PodFetcher: &pod.PodFetcherStub{
+						PodList: []*v1.Pod{
+							{
+								ObjectMeta: metav1.ObjectMeta{
+									Name:      testName,
+									Namespace: testName,
+									UID:       types.UID(podUID),
+								},
+								Spec: v1.PodSpec{
+									Containers: []v1.Container{
+										{
+											Name:      testName,
+											Resources: resources,
+										},
+									},
+								},
+								Status: v1.PodStatus{
+									ContainerStatuses: []v1.ContainerStatus{
+										{
+											Name:        testName,
+											ContainerID: containerID,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}
+
+			topologyAwareAssignments := make(map[int]machine.CPUSet)
+			for _, numaNode := range tc.numaNodes {
+				topologyAwareAssignments[numaNode] = cpuTopology.CPUDetails.CPUsInNUMANodes(numaNode)
+			}
+
+			err = dynamicPolicy.applyNUMADistancePenalty(&state.AllocationInfo{
+				PodUid:                   podUID,
+				PodNamespace:             testName,
+				PodName:                  testName,
+				ContainerName:            testName,
+				TopologyAwareAssignments: topologyAwareAssignments,
+			})
+			as.Nil(err)
+
+			cpuQuota, applied := quotaApplier.applied[containerID]
+			as.Equal(tc.expectApplied, applied)
+			if tc.expectApplied {
+				as.Equal(tc.expectQuota, cpuQuota)
+			}
+		})
+	}
+}
+
+func TestSyncNUMADistancePenalty(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestSyncNUMADistancePenalty")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	quotaApplier := &fakeContainerCPUQuotaApplier{}
+	dynamicPolicy.cpuQuotaApplier = quotaApplier
+	dynamicPolicy.numaDistancePenaltyCurve = map[int]float64{12: 0.9, 32: 0.7}
+	dynamicPolicy.machineInfo.ExtraTopologyInfo = &machine.ExtraTopologyInfo{
+		NumaDistanceMap: generateTestNUMADistanceMap(cpuTopology.NumNUMANodes),
+	}
+
+	testName := "test"
+	runningPodUID, pendingPodUID := string(uuid.NewUUID()), string(uuid.NewUUID())
+	dynamicPolicy.metaServer = &metaserver.MetaServer{
+		MetaAgent: &agent.MetaAgent{
+			PodFetcher: &pod.PodFetcherStub{
+				PodList: []*v1.Pod{
+					{
+						ObjectMeta: metav1.ObjectMeta{Name: testName, Namespace: testName, UID: types.UID(runningPodUID)},
+						Spec: v1.PodSpec{
+							Containers: []v1.Container{
+								{
+									Name: testName,
+									Resources: v1.ResourceRequirements{
+										Limits: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")},
+									},
+								},
+							},
+						},
+						Status: v1.PodStatus{
+							ContainerStatuses: []v1.ContainerStatus{{Name: testName, ContainerID: "running-container-id"}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// shared_cores container in share pool across NUMAs of different sockets,
+	// and container not running yet is skipped
+	shareCPUs := machine.NewCPUSet(2, 4)
+	for _, podUID := range []string{runningPodUID, pendingPodUID} {
+		topologyAwareAssignments, err := machine.GetNumaAwareAssignments(cpuTopology, shareCPUs)
+		as.Nil(err)
+		dynamicPolicy.state.SetAllocationInfo(podUID, testName, &state.AllocationInfo{
+			PodUid:                           podUID,
+			PodNamespace:                     testName,
+			PodName:                          testName,
+			ContainerName:                    testName,
+			ContainerType:                    pluginapi.ContainerType_MAIN.String(),
+			OwnerPoolName:                    state.PoolNameShare,
+			AllocationResult:                 shareCPUs.Clone(),
+			OriginalAllocationResult:         shareCPUs.Clone(),
+			TopologyAwareAssignments:         topologyAwareAssignments,
+			OriginalTopologyAwareAssignments: machine.DeepcopyCPUAssignment(topologyAwareAssignments),
+			QoSLevel:                         consts.PodAnnotationQoSLevelSharedCores,
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+			},
+			RequestQuantity: 2,
+		})
+	}
+
+	dynamicPolicy.syncNUMADistancePenalty(nil, nil, nil, nil, nil)
+	as.Equal(map[string]int64{"running-container-id": 280000}, quotaApplier.applied)
+}
+
+func TestCordonNUMA(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestCordonNUMA")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.reclaimedNUMAOvercommitRatio = 1
+
+	generateReq := func(qosLevel, memoryEnhancement string, reqCPUs float64, hint *pluginapi.TopologyHint) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			qosLevel:          qosLevel,
+			memoryEnhancement: memoryEnhancement,
+			quantity:          reqCPUs,
+			hint:              hint,
+		})
+	}
+
+	// existing dedicated_cores with numa_binding on NUMA 2 and shared_cores without numa_binding
+	dedicatedReq := generateReq(consts.PodAnnotationQoSLevelDedicatedCores,
+		`{"numa_binding": "true", "numa_exclusive": "true"}`, 2, &pluginapi.TopologyHint{Nodes: []uint64{2}, Preferred: true})
+	_, err = dynamicPolicy.Allocate(context.Background(), dedicatedReq)
+	as.Nil(err)
+	dedicatedAllocation := dynamicPolicy.state.GetAllocationInfo(dedicatedReq.PodUid, dedicatedReq.ContainerName)
+	as.NotNil(dedicatedAllocation)
+	as.True(dedicatedAllocation.AllocationResult.Equals(cpuTopology.CPUDetails.CPUsInNUMANodes(2)))
+
+	sharedReq := generateReq(consts.PodAnnotationQoSLevelSharedCores, "", 2, nil)
+	_, err = dynamicPolicy.Allocate(context.Background(), sharedReq)
+	as.Nil(err)
+
+	as.NotNil(dynamicPolicy.CordonNUMA(cpuTopology.NumNUMANodes))
+	as.Nil(dynamicPolicy.CordonNUMA(2))
+	as.Nil(dynamicPolicy.CordonNUMA(3))
+	as.True(dynamicPolicy.GetCordonedNUMAs().Equals(machine.NewCPUSet(2, 3)))
+
+	hintReqs := []*pluginapi.ResourceRequest{
+		generateReq(consts.PodAnnotationQoSLevelDedicatedCores, `{"numa_binding": "true", "numa_exclusive": "true"}`, 2, nil),
+		generateReq(consts.PodAnnotationQoSLevelSharedCores, `{"numa_binding": "true"}`, 1, nil),
+		generateReq(consts.PodAnnotationQoSLevelReclaimedCores, "", 1, nil),
+	}
+	for _, req := range hintReqs {
+		resp, err := dynamicPolicy.GetTopologyHints(context.Background(), req)
+		as.Nil(err)
+
+		hints := resp.ResourceHints[string(v1.ResourceCPU)].Hints
+		as.NotEmpty(hints)
+		for _, hint := range hints {
+			as.NotContains(hint.Nodes, uint64(2), "qos level: %s", req.Annotations[consts.PodAnnotationQoSLevelKey])
+			as.NotContains(hint.Nodes, uint64(3), "qos level: %s", req.Annotations[consts.PodAnnotationQoSLevelKey])
+		}
+	}
+
+	// dedicated_cores allocation on cordoned NUMA remains, and it keeps its hint
+	dedicatedAllocation = dynamicPolicy.state.GetAllocationInfo(dedicatedReq.PodUid, dedicatedReq.ContainerName)
+	as.NotNil(dedicatedAllocation)
+	as.True(dedicatedAllocation.AllocationResult.Equals(cpuTopology.CPUDetails.CPUsInNUMANodes(2)))
+
+	// annotations of the allocated request have been filtered in place, so it's regenerated for the same pod
+	dedicatedHintReq := generateReq(consts.PodAnnotationQoSLevelDedicatedCores,
+		`{"numa_binding": "true", "numa_exclusive": "true"}`, 2, &pluginapi.TopologyHint{Nodes: []uint64{2}, Preferred: true})
+	dedicatedHintReq.PodUid = dedicatedReq.PodUid
+	resp, err := dynamicPolicy.GetTopologyHints(context.Background(), dedicatedHintReq)
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{2}, Preferred: true},
+	}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+	// reclaimed pool is re-generated off cordoned NUMAs, and so is the shared_cores container
+	// (it's still in ramp up, so the share pool has been cleaned without any container in it)
+	cordonedCPUs := cpuTopology.CPUDetails.CPUsInNUMANodes(2, 3)
+	reclaimAllocation := dynamicPolicy.state.GetAllocationInfo(state.PoolNameReclaim, state.FakedContainerName)
+	as.NotNil(reclaimAllocation)
+	as.True(reclaimAllocation.AllocationResult.Intersection(cordonedCPUs).IsEmpty(),
+		"reclaim pool cpuset: %s", reclaimAllocation.AllocationResult.String())
+
+	sharedAllocation := dynamicPolicy.state.GetAllocationInfo(sharedReq.PodUid, sharedReq.ContainerName)
+	as.NotNil(sharedAllocation)
+	as.True(sharedAllocation.AllocationResult.Intersection(cordonedCPUs).IsEmpty(),
+		"shared_cores container cpuset: %s", sharedAllocation.AllocationResult.String())
+
+	as.Nil(dynamicPolicy.UncordonNUMA(3))
+	as.True(dynamicPolicy.GetCordonedNUMAs().Equals(machine.NewCPUSet(2)))
+
+	resp, err = dynamicPolicy.GetTopologyHints(context.Background(),
+		generateReq(consts.PodAnnotationQoSLevelReclaimedCores, "", 1, nil))
+	as.Nil(err)
+
+	foundNUMA3 := false
+	for _, hint := range resp.ResourceHints[string(v1.ResourceCPU)].Hints {
+		foundNUMA3 = foundNUMA3 || (len(hint.Nodes) == 1 && hint.Nodes[0] == 3)
+	}
+	as.True(foundNUMA3)
+}
+
+func TestGetTopologyHintsWithHeavyReservation(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestGetTopologyHintsWithHeavyReservation")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	// reserve half of cpus in each NUMA, so a request of 3 cpus can't fit into any single NUMA
+	reservedCPUs := machine.NewCPUSet()
+	for _, numaNode := range cpuTopology.CPUDetails.NUMANodes().ToSliceInt() {
+		reservedCPUs = reservedCPUs.Union(machine.NewCPUSet(cpuTopology.CPUDetails.CPUsInNUMANodes(numaNode).ToSliceInt()[:2]...))
+	}
+	as.Nil(dynamicPolicy.SetReservedCPUs(reservedCPUs))
+
+	testName := "test"
+	req := &pluginapi.ResourceRequest{
+		PodUid:         string(uuid.NewUUID()),
+		PodNamespace:   testName,
+		PodName:        testName,
+		ContainerName:  testName,
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 3,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+		},
+	}
+
+	resp, err := dynamicPolicy.GetTopologyHints(context.Background(), req)
+	as.Nil(err)
+
+	// the minimum count of NUMAs is 2 rather than 1 assuming full NUMA capacity,
+	// so hints with 2 NUMAs in the same socket are preferred
+	preferredHints := make([][]uint64, 0)
+	for _, hint := range resp.ResourceHints[string(v1.ResourceCPU)].Hints {
+		as.Greater(len(hint.Nodes), 1)
+		if hint.Preferred {
+			preferredHints = append(preferredHints, hint.Nodes)
+		}
+	}
+	as.Equal([][]uint64{{0, 1}, {2, 3}}, preferredHints)
+}
+
+func TestSortAllocationRequests(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestSortAllocationRequests")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	testName := "test"
+	baseTime := time.Now()
+	podList := make([]*v1.Pod, 0)
+	generateAllocationReq := func(podName, containerName, qosLevel string, createdAfter *time.Duration) *allocationRequest {
+		podUID := fmt.Sprintf("%s-uid", podName)
+		if createdAfter != nil {
+			podList = append(podList, &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              podName,
+					Namespace:         testName,
+					UID:               types.UID(podUID),
+					CreationTimestamp: metav1.NewTime(baseTime.Add(*createdAfter)),
+				},
+			})
+		}
+
+		return &allocationRequest{
+			req: &pluginapi.ResourceRequest{
+				PodUid:        podUID,
+				PodNamespace:  testName,
+				PodName:       podName,
+				ContainerName: containerName,
+			},
+			qosLevel: qosLevel,
+		}
+	}
+	after := func(seconds int) *time.Duration {
+		d := time.Duration(seconds) * time.Second
+		return &d
+	}
+
+	allocationReqs := []*allocationRequest{
+		generateAllocationReq("pod-a", testName, consts.PodAnnotationQoSLevelReclaimedCores, after(3)),
+		generateAllocationReq("pod-b", testName, consts.PodAnnotationQoSLevelSharedCores, after(2)),
+		generateAllocationReq("pod-c", testName, consts.PodAnnotationQoSLevelDedicatedCores, after(5)),
+		generateAllocationReq("pod-d", testName, consts.PodAnnotationQoSLevelSharedCores, after(1)),
+		generateAllocationReq("pod-e", testName, consts.PodAnnotationQoSLevelDedicatedCores, nil),
+		generateAllocationReq("pod-f", testName, consts.PodAnnotationQoSLevelDedicatedCores, after(4)),
+		nil,
+		generateAllocationReq("pod-b", "sidecar", consts.PodAnnotationQoSLevelSharedCores, nil),
+	}
+	dynamicPolicy.metaServer = &metaserver.MetaServer{
+		MetaAgent: &agent.MetaAgent{
+			PodFetcher: &pod.PodFetcherStub{PodList: podList},
+		},
+	}
+
+	// dedicated_cores > shared_cores > reclaimed_cores, and then by creation timestamp
+	as.Equal([]int{5, 2, 4, 3, 1, 7, 0, 6}, dynamicPolicy.sortAllocationRequests(context.Background(), allocationReqs))
+}
+
+func TestAllocateBatchWithQoSPriority(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestAllocateBatchWithQoSPriority")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	generateReq := func(qosLevel, memoryEnhancement string, reqCPUs float64, hintNUMA int) *pluginapi.ResourceRequest {
+		var hint *pluginapi.TopologyHint
+		if hintNUMA >= 0 {
+			hint = &pluginapi.TopologyHint{Nodes: []uint64{uint64(hintNUMA)}, Preferred: true}
+		}
+		return newTestRequest(testRequest{
+			qosLevel:          qosLevel,
+			memoryEnhancement: memoryEnhancement,
+			quantity:          reqCPUs,
+			hint:              hint,
+		})
+	}
+
+	// the numa_binding shared_cores pod is replayed before the dedicated_cores pod taking the whole NUMA 3,
+	// it would take part of NUMA 3 and make the dedicated_cores pod fail if requests are admitted in order
+	reqs := []*pluginapi.ResourceRequest{
+		generateReq(consts.PodAnnotationQoSLevelReclaimedCores, "", 2, -1),
+		generateReq(consts.PodAnnotationQoSLevelSharedCores, `{"numa_binding": "true"}`, 2, 3),
+		generateReq(consts.PodAnnotationQoSLevelSharedCores, "", 2, -1),
+		generateReq(consts.PodAnnotationQoSLevelDedicatedCores, `{"numa_binding": "true", "numa_exclusive": "true"}`, 4, 3),
+		generateReq(consts.PodAnnotationQoSLevelDedicatedCores, `{"numa_binding": "true", "numa_exclusive": "true"}`, 4, 2),
+	}
+
+	_, errs := dynamicPolicy.AllocateBatch(context.Background(), reqs)
+	as.Len(errs, len(reqs))
+
+	for i, numaNode := range map[int]int{3: 3, 4: 2} {
+		as.Nil(errs[i], "request %d", i)
+		allocationInfo := dynamicPolicy.state.GetAllocationInfo(reqs[i].PodUid, reqs[i].ContainerName)
+		as.NotNil(allocationInfo, "request %d", i)
+		as.True(allocationInfo.AllocationResult.Equals(cpuTopology.CPUDetails.CPUsInNUMANodes(numaNode)),
+			"request %d, allocation result: %s", i, allocationInfo.AllocationResult.String())
+	}
+
+	for _, i := range []int{0, 2} {
+		as.Nil(errs[i], "request %d", i)
+	}
+}
+
+func TestGetNUMAAllocatable(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestGetNUMAAllocatable")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	// one cpu is reserved in NUMA 0 and NUMA 1 respectively by default
+	as.Equal(map[int]int{0: 3, 1: 3, 2: 4, 3: 4}, dynamicPolicy.GetNUMAAllocatable())
+
+	// reserve the whole NUMA 2 and make one cpu of NUMA 3 offline
+	reservedCPUs := dynamicPolicy.GetReservedCPUs().Union(cpuTopology.CPUDetails.CPUsInNUMANodes(2))
+	as.Nil(dynamicPolicy.SetReservedCPUs(reservedCPUs))
+	dynamicPolicy.offlineCPUs = machine.NewCPUSet(6)
+
+	numaAllocatable := dynamicPolicy.GetNUMAAllocatable()
+	as.Equal(map[int]int{0: 3, 1: 3, 2: 0, 3: 3}, numaAllocatable)
+
+	resp, err := dynamicPolicy.GetTopologyAwareAllocatableResources(context.Background(),
+		&pluginapi.GetTopologyAwareAllocatableResourcesRequest{})
+	as.Nil(err)
+	for _, quantity := range resp.AllocatableResources[string(v1.ResourceCPU)].TopologyAwareAllocatableQuantityList {
+		as.Equal(float64(numaAllocatable[int(quantity.Node)]), quantity.ResourceValue)
+	}
+}
+
+func TestParseQoSReservedPools(t *testing.T) {
+	t.Parallel()
+
+	allCPUs := machine.NewCPUSet(0, 1, 2, 3, 4, 5, 6, 7)
+	testCases := []struct {
+		name    string
+		pools   map[string]string
+		want    map[string]machine.CPUSet
+		wantErr bool
+	}{
+		{
+			name: "no pool",
+			want: map[string]machine.CPUSet{},
+		},
+		{
+			name: "valid pools",
+			pools: map[string]string{
+				consts.PodAnnotationQoSLevelSystemCores: "6-7",
+				"custom_cores":                          "5",
+			},
+			want: map[string]machine.CPUSet{
+				consts.PodAnnotationQoSLevelSystemCores: machine.NewCPUSet(6, 7),
+				"custom_cores":                          machine.NewCPUSet(5),
+			},
+		},
+		{
+			name: "overlapped pools",
+			pools: map[string]string{
+				consts.PodAnnotationQoSLevelSystemCores: "6-7",
+				"custom_cores":                          "5-6",
+			},
+			wantErr: true,
+		},
+		{
+			name: "pool for QoS level admitted by dynamic policy",
+			pools: map[string]string{
+				consts.PodAnnotationQoSLevelSharedCores: "6-7",
+			},
+			wantErr: true,
+		},
+		{
+			name: "cpus out of machine",
+			pools: map[string]string{
+				consts.PodAnnotationQoSLevelSystemCores: "7-8",
+			},
+			wantErr: true,
+		},
+		{
+			name: "malformed cpuset",
+			pools: map[string]string{
+				consts.PodAnnotationQoSLevelSystemCores: "7-6",
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty pool",
+			pools: map[string]string{
+				consts.PodAnnotationQoSLevelSystemCores: "",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			pools, err := parseQoSReservedPools(tc.pools, allCPUs)
+			if tc.wantErr {
+				as.NotNil(err)
+				return
+			}
+			as.Nil(err)
+			as.Len(pools, len(tc.want))
+			for qosLevel, cpus := range tc.want {
+				as.True(cpus.Equals(pools[qosLevel]), "QoS level: %s", qosLevel)
+			}
+		})
+	}
+}
+
+func TestValidateReservedCPUs(t *testing.T) {
+	t.Parallel()
+
+	allCPUs := machine.NewCPUSet(0, 1, 2, 3, 4, 5, 6, 7)
+	testCases := []struct {
+		name         string
+		reservedCPUs machine.CPUSet
+		trim         bool
+		want         machine.CPUSet
+		wantErr      bool
+	}{
+		{
+			name:         "reserved cpus within topology",
+			reservedCPUs: machine.NewCPUSet(0, 4),
+			want:         machine.NewCPUSet(0, 4),
+		},
+		{
+			name:         "no reserved cpu",
+			reservedCPUs: machine.NewCPUSet(),
+			want:         machine.NewCPUSet(),
+		},
+		{
+			name:         "out-of-range reserved cpus are rejected",
+			reservedCPUs: machine.NewCPUSet(0, 8, 64),
+			wantErr:      true,
+		},
+		{
+			name:         "out-of-range reserved cpus are trimmed",
+			reservedCPUs: machine.NewCPUSet(0, 8, 64),
+			trim:         true,
+			want:         machine.NewCPUSet(0),
+		},
+		{
+			name:         "reserved cpus can't be trimmed to nothing",
+			reservedCPUs: machine.NewCPUSet(8, 64),
+			trim:         true,
+			wantErr:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			reservedCPUs, err := validateReservedCPUs(tc.reservedCPUs, allCPUs, tc.trim)
+			if tc.wantErr {
+				as.NotNil(err)
+				return
+			}
+			as.Nil(err)
+			as.Equal(tc.want.String(), reservedCPUs.String())
+		})
+	}
+}
+
+func TestNewDynamicPolicyWithDefaultConfig(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestNewDynamicPolicyWithDefaultConfig")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	// no cpu is reserved by default
+	conf, err := options.NewOptions().Config()
+	as.Nil(err)
+	as.Equal(0, conf.ReservedCPUCores)
+	conf.StateFileDirectory = tmpDir
+	conf.QRMPluginSocketDirs = []string{filepath.Join(tmpDir, "test.sock")}
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+	machineInfo, err := machine.GenerateDummyMachineInfo(4, 32)
+	as.Nil(err)
+
+	genericCtx, err := katalystbase.GenerateFakeGenericContext([]runtime.Object{})
+	as.Nil(err)
+	agentCtx := &appagent.GenericContext{
+		GenericContext: genericCtx,
+		MetaServer: &metaserver.MetaServer{
+			MetaAgent: &agent.MetaAgent{
+				KatalystMachineInfo: &machine.KatalystMachineInfo{
+					MachineInfo:      machineInfo,
+					CPUTopology:      cpuTopology,
+					ExtraNetworkInfo: &machine.ExtraNetworkInfo{},
+				},
+				PodFetcher: &pod.PodFetcherStub{},
+			},
+			ConfigurationManager: &dynamicconfig.DummyConfigurationManager{},
+		},
+	}
+
+	_, component, err := NewDynamicPolicy(agentCtx, conf, nil, "test_dynamic_policy")
+	as.Nil(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		component.Run(ctx)
+		wg.Done()
+	}()
+
+	cancel()
+	wg.Wait()
+}
+
+func TestSetReservedCPUsOutOfTopology(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestSetReservedCPUsOutOfTopology")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	// cpu 16 doesn't exist in the topology with 16 cpus, so the reservation is kept as it is
+	reservedCPUs := dynamicPolicy.GetReservedCPUs()
+	as.NotNil(dynamicPolicy.SetReservedCPUs(reservedCPUs.Union(machine.NewCPUSet(16))))
+	as.Equal(reservedCPUs.String(), dynamicPolicy.GetReservedCPUs().String())
+
+	// cpu 16 is trimmed, while cpu 15 within the topology is still reserved
+	dynamicPolicy.trimInvalidReservedCPUs = true
+	as.Nil(dynamicPolicy.SetReservedCPUs(reservedCPUs.Union(machine.NewCPUSet(15, 16))))
+	as.Equal(reservedCPUs.Union(machine.NewCPUSet(15)).String(), dynamicPolicy.GetReservedCPUs().String())
+}
+
+func TestQoSReservedPoolUnavailableForSharedCores(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestQoSReservedPoolUnavailableForSharedCores")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	// carve the core 7 (cpu 7 and cpu 15) in NUMA 3 out for system_cores
+	systemPool := machine.NewCPUSet(7, 15)
+	dynamicPolicy.qosReservedPools = map[string]machine.CPUSet{
+		consts.PodAnnotationQoSLevelSystemCores: systemPool,
+	}
+	as.NotNil(dynamicPolicy.SetReservedCPUs(dynamicPolicy.GetReservedCPUs().Union(machine.NewCPUSet(7))))
+	as.Equal(map[int]int{0: 3, 1: 3, 2: 4, 3: 2}, dynamicPolicy.GetNUMAAllocatable())
+
+	testName := "test"
+	newSharedCoresReq := func(request float64, annotations map[string]string) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			qosLevel:    consts.PodAnnotationQoSLevelSharedCores,
+			annotations: annotations,
+			quantity:    request,
+		})
+	}
+
+	// NUMA 3 has only 2 cpus left out of the system pool, so it can't fit numa_binding request of 3 cpus
+	resp, err := dynamicPolicy.GetTopologyHints(context.Background(), newSharedCoresReq(3, map[string]string{
+		consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+	}))
+	as.Nil(err)
+	hintNUMAs := make([]uint64, 0)
+	for _, hint := range resp.ResourceHints[string(v1.ResourceCPU)].Hints {
+		hintNUMAs = append(hintNUMAs, hint.Nodes...)
+	}
+	as.Equal([]uint64{0, 1, 2}, hintNUMAs)
+
+	// shared_cores without numa_binding is never allocated with cpus in the system pool
+	req := newSharedCoresReq(2, map[string]string{})
+	_, err = dynamicPolicy.Allocate(context.Background(), req)
+	as.Nil(err)
+
+	allocationInfo := dynamicPolicy.state.GetAllocationInfo(req.PodUid, testName)
+	as.NotNil(allocationInfo)
+	as.False(allocationInfo.AllocationResult.IsEmpty())
+	as.True(allocationInfo.AllocationResult.Intersection(systemPool).IsEmpty())
+}
+
+func TestGetTopologyHintsTrace(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestGetTopologyHintsTrace")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.cpuNUMAHintPreferPolicy = cpuconsts.CPUNUMAHintPreferPolicyDynamicPacking
+	dynamicPolicy.cpuNUMAHintPreferLowThreshold = 0.5
+	dynamicPolicy.cordonedNUMAs = machine.NewCPUSet(3)
+
+	newReq := func(qosLevel, memoryEnhancement string, request float64, hint *pluginapi.TopologyHint) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			qosLevel:          qosLevel,
+			memoryEnhancement: memoryEnhancement,
+			quantity:          request,
+			hint:              hint,
+		})
+	}
+
+	// dedicated_cores with numa_binding on NUMA 0 is anti-affine to numa_binding shared_cores
+	_, err = dynamicPolicy.Allocate(context.Background(), newReq(consts.PodAnnotationQoSLevelDedicatedCores,
+		`{"numa_binding": "true", "numa_exclusive": "true"}`, 3, &pluginapi.TopologyHint{Nodes: []uint64{0}, Preferred: true}))
+	as.Nil(err)
+
+	// numa_binding shared_cores on NUMA 1 makes its available ratio lower than the low threshold
+	_, err = dynamicPolicy.Allocate(context.Background(), newReq(consts.PodAnnotationQoSLevelSharedCores,
+		`{"numa_binding": "true"}`, 2, &pluginapi.TopologyHint{Nodes: []uint64{1}, Preferred: true}))
+	as.Nil(err)
+
+	trace, err := dynamicPolicy.GetTopologyHintsTrace(newReq(consts.PodAnnotationQoSLevelSharedCores,
+		`{"numa_binding": "true"}`, 1, nil))
+	as.Nil(err)
+	as.Empty(trace.Error)
+	as.Equal([]*pluginapi.TopologyHint{{Nodes: []uint64{2}, Preferred: true}}, trace.Hints)
+
+	expectedReasonPrefixes := map[int][]string{
+		0: {"anti-affinity"},
+		1: {"low threshold"},
+		2: {"candidate", "preferred"},
+		3: {"cordoned"},
+	}
+	as.Len(trace.NUMAReasons, len(expectedReasonPrefixes))
+	for nodeID, prefixes := range expectedReasonPrefixes {
+		reasons := trace.NUMAReasons[nodeID]
+		as.Len(reasons, len(prefixes), "NUMA: %d, reasons: %v", nodeID, reasons)
+		for i, prefix := range prefixes {
+			as.True(strings.HasPrefix(reasons[i], prefix), "NUMA: %d, reasons: %v", nodeID, reasons)
+		}
+	}
+
+	// the trace is also exported by the debug handler
+	body, err := json.Marshal(newReq(consts.PodAnnotationQoSLevelSharedCores, `{"numa_binding": "true"}`, 1, nil))
+	as.Nil(err)
+	w := httptest.NewRecorder()
+	dynamicPolicy.serveHintsTrace(w, httptest.NewRequest(http.MethodPost, "/debug"+hintsTraceDebugPath, bytes.NewReader(body)))
+	as.Equal(http.StatusOK, w.Code)
+
+	exported := &TopologyHintsTrace{}
+	as.Nil(json.Unmarshal(w.Body.Bytes(), exported))
+	as.Equal(trace.NUMAReasons, exported.NUMAReasons)
+
+	// requests other than numa_binding shared_cores are not supported
+	_, err = dynamicPolicy.GetTopologyHintsTrace(newReq(consts.PodAnnotationQoSLevelSharedCores, `{}`, 1, nil))
+	as.NotNil(err)
+}
+
+func TestExplainNUMAExclusion(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	testName := "test"
+	newReq := func(qosLevel, memoryEnhancement, cpuEnhancement string, request float64,
+		hint *pluginapi.TopologyHint,
+	) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			qosLevel:          qosLevel,
+			memoryEnhancement: memoryEnhancement,
+			cpuEnhancement:    cpuEnhancement,
+			quantity:          request,
+			hint:              hint,
+		})
+	}
+	allocate := func(dynamicPolicy *DynamicPolicy, req *pluginapi.ResourceRequest) string {
+		_, err := dynamicPolicy.Allocate(context.Background(), req)
+		as.Nil(err)
+		return req.PodUid
+	}
+	explain := func(dynamicPolicy *DynamicPolicy, podUID string, numaID int) string {
+		reason, err := dynamicPolicy.ExplainNUMAExclusion(podUID, testName, numaID)
+		as.Nil(err)
+		return reason
+	}
+	numaHint := func(numaID uint64) *pluginapi.TopologyHint {
+		return &pluginapi.TopologyHint{Nodes: []uint64{numaID}, Preferred: true}
+	}
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestExplainNUMAExclusion")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.cpuNUMAHintPreferPolicy = cpuconsts.CPUNUMAHintPreferPolicyDynamicPacking
+	dynamicPolicy.cpuNUMAHintPreferLowThreshold = 0.5
+	dynamicPolicy.cordonedNUMAs = machine.NewCPUSet(3)
+
+	// dedicated_cores with numa_binding on NUMA 0 is anti-affine to numa_binding shared_cores, and
+	// numa_binding shared_cores on NUMA 1 makes its available ratio lower than the low threshold
+	dedicatedPodUID := allocate(dynamicPolicy, newReq(consts.PodAnnotationQoSLevelDedicatedCores,
+		`{"numa_binding": "true", "numa_exclusive": "true"}`, `{}`, 3, numaHint(0)))
+	allocate(dynamicPolicy, newReq(consts.PodAnnotationQoSLevelSharedCores, `{"numa_binding": "true"}`, `{}`, 2, numaHint(1)))
+	podUID := allocate(dynamicPolicy, newReq(consts.PodAnnotationQoSLevelSharedCores, `{"numa_binding": "true"}`, `{}`, 1, numaHint(2)))
+	constrainedPodUID := allocate(dynamicPolicy, newReq(consts.PodAnnotationQoSLevelSharedCores, `{"numa_binding": "true"}`,
+		fmt.Sprintf(`{"%s": "2"}`, cpuconsts.PodAnnotationCPUNUMAConstraint), 1, numaHint(2)))
+
+	as.Contains(explain(dynamicPolicy, podUID, 0), "NUMA: 0 is excluded: anti-affinity")
+	as.Contains(explain(dynamicPolicy, podUID, 1), "NUMA: 1 is excluded: low threshold")
+	as.Contains(explain(dynamicPolicy, podUID, 2), "NUMA: 2 isn't excluded: candidate")
+	as.Contains(explain(dynamicPolicy, podUID, 3), "NUMA: 3 is excluded: cordoned")
+	as.Contains(explain(dynamicPolicy, constrainedPodUID, 1), "NUMA: 1 is excluded: NUMA constraint")
+
+	// the reason is also served by the debug handler
+	w := httptest.NewRecorder()
+	dynamicPolicy.serveNUMAExclusion(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/debug%s?podUID=%s&containerName=%s&numaID=3",
+		numaExclusionDebugPath, podUID, testName), nil))
+	as.Equal(http.StatusOK, w.Code)
+	as.Equal(explain(dynamicPolicy, podUID, 3), w.Body.String())
+
+	// invalid NUMA, unknown container and containers other than numa_binding shared_cores are not supported
+	_, err = dynamicPolicy.ExplainNUMAExclusion(podUID, testName, 4)
+	as.NotNil(err)
+	_, err = dynamicPolicy.ExplainNUMAExclusion(string(uuid.NewUUID()), testName, 0)
+	as.NotNil(err)
+	_, err = dynamicPolicy.ExplainNUMAExclusion(dedicatedPodUID, testName, 0)
+	as.NotNil(err)
+
+	tmpDir2, err := ioutil.TempDir("", "checkpoint-TestExplainNUMAExclusion")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir2) }()
+
+	dynamicPolicy, err = getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir2)
+	as.Nil(err)
+	dynamicPolicy.cpuNUMAHintPreferPolicy = cpuconsts.CPUNUMAHintPreferPolicySpreading
+
+	// without the explained pod, NUMAs 0, 1 and 3 have 10 cpus for non-binding shared_cores requesting 8 cpus,
+	// so only NUMA 0 with 2 cpus can be taken, and NUMA 2 has only 1 cpu left
+	podUID = allocate(dynamicPolicy, newReq(consts.PodAnnotationQoSLevelSharedCores, `{"numa_binding": "true"}`, `{}`, 2, numaHint(1)))
+	allocate(dynamicPolicy, newReq(consts.PodAnnotationQoSLevelSharedCores, `{"numa_binding": "true"}`, `{}`, 3, numaHint(2)))
+	allocate(dynamicPolicy, newReq(consts.PodAnnotationQoSLevelSharedCores, `{}`, `{}`, 8, nil))
+
+	as.Contains(explain(dynamicPolicy, podUID, 0), "NUMA: 0 isn't excluded: candidate")
+	as.Contains(explain(dynamicPolicy, podUID, 2), "NUMA: 2 is excluded: insufficient cpu")
+	as.Contains(explain(dynamicPolicy, podUID, 3), "NUMA: 3 is excluded: shared_cores short supply")
+}
+
+func TestHandleAllocationsWithMissingCPUs(t *testing.T) {
+	t.Parallel()
+
+	// the checkpoint is generated with 32 cpus, and the machine shrinks to 16 cpus afterwards
+	checkpointTopology, err := machine.GenerateDummyCPUTopology(32, 2, 4)
+	require.NoError(t, err)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	dedicatedPodUID := string(uuid.NewUUID())
+	sharedPodUID := string(uuid.NewUUID())
+	validPodUID := string(uuid.NewUUID())
+	allocations := map[string]machine.CPUSet{
+		dedicatedPodUID: machine.NewCPUSet(4, 5, 20, 21),
+		sharedPodUID:    machine.NewCPUSet(24, 25),
+		validPodUID:     machine.NewCPUSet(6, 7),
+	}
+
+	testCases := []struct {
+		name                      string
+		action                    string
+		expectedAllocationResults map[string]machine.CPUSet
+		expectedMissingCPUs       map[string]string
+		expectErr                 bool
+	}{
+		{
+			name:   "trim",
+			action: cpuconsts.MissingCPUsActionTrim,
+			expectedAllocationResults: map[string]machine.CPUSet{
+				dedicatedPodUID: machine.NewCPUSet(4, 5),
+				sharedPodUID:    machine.NewCPUSet(),
+				validPodUID:     machine.NewCPUSet(6, 7),
+			},
+			// pods without valid cpus left are flagged to be evicted even if the action is trim
+			expectedMissingCPUs: map[string]string{
+				sharedPodUID: "24-25",
+			},
+		},
+		{
+			name:   "evict",
+			action: cpuconsts.MissingCPUsActionEvict,
+			expectedAllocationResults: map[string]machine.CPUSet{
+				dedicatedPodUID: machine.NewCPUSet(4, 5),
+				sharedPodUID:    machine.NewCPUSet(),
+				validPodUID:     machine.NewCPUSet(6, 7),
+			},
+			expectedMissingCPUs: map[string]string{
+				dedicatedPodUID: "20-21",
+				sharedPodUID:    "24-25",
+			},
+		},
+		{
+			name:      "unsupported action",
+			action:    "unknown",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			testName := "test"
+
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestHandleAllocationsWithMissingCPUs")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			checkpointState, err := state.NewCheckpointState(tmpDir, cpuPluginStateFileName,
+				cpuconsts.CPUResourcePluginPolicyNameDynamic, checkpointTopology, false)
+			as.Nil(err)
+
+			for podUID, cpus := range allocations {
+				ownerPoolName := state.PoolNameShare
+				annotations := map[string]string{
+					consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+				}
+				if podUID == dedicatedPodUID {
+					ownerPoolName = state.PoolNameDedicated
+					annotations = map[string]string{
+						consts.PodAnnotationQoSLevelKey:                  consts.PodAnnotationQoSLevelDedicatedCores,
+						consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+					}
+				}
+
+				topologyAwareAssignments, err := machine.GetNumaAwareAssignments(checkpointTopology, cpus)
+				as.Nil(err)
+				checkpointState.SetAllocationInfo(podUID, testName, &state.AllocationInfo{
+					PodUid:                           podUID,
+					PodNamespace:                     testName,
+					PodName:                          podUID,
+					ContainerName:                    testName,
+					ContainerType:                    pluginapi.ContainerType_MAIN.String(),
+					OwnerPoolName:                    ownerPoolName,
+					AllocationResult:                 cpus.Clone(),
+					OriginalAllocationResult:         cpus.Clone(),
+					TopologyAwareAssignments:         topologyAwareAssignments,
+					OriginalTopologyAwareAssignments: machine.DeepcopyCPUAssignment(topologyAwareAssignments),
+					Annotations:                      annotations,
+					QoSLevel:                         annotations[consts.PodAnnotationQoSLevelKey],
+					RequestQuantity:                  float64(cpus.Size()),
+				})
+			}
+
+			// restore the checkpoint with the current topology
+			dynamicPolicy, err := getTestDynamicPolicyWithoutInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.missingCPUsAction = tc.action
+
+			err = dynamicPolicy.handleAllocationsWithMissingCPUs()
+			if tc.expectErr {
+				as.NotNil(err)
+				return
+			}
+			as.Nil(err)
+
+			for podUID, expectedResult := range tc.expectedAllocationResults {
+				allocationInfo := dynamicPolicy.state.GetAllocationInfo(podUID, testName)
+				as.NotNil(allocationInfo)
+				as.True(expectedResult.Equals(allocationInfo.AllocationResult), "pod: %s", podUID)
+				as.True(expectedResult.Equals(allocationInfo.OriginalAllocationResult), "pod: %s", podUID)
+				for numaID, cpus := range allocationInfo.TopologyAwareAssignments {
+					as.True(cpus.IsSubsetOf(cpuTopology.CPUDetails.CPUsInNUMANodes(numaID)), "pod: %s", podUID)
+				}
+
+				missingCPUs, ok := allocationInfo.Annotations[cpuconsts.CPUStateAnnotationKeyMissingCPUs]
+				expectedMissingCPUs, expectedOK := tc.expectedMissingCPUs[podUID]
+				as.Equal(expectedOK, ok, "pod: %s", podUID)
+				as.Equal(expectedMissingCPUs, missingCPUs, "pod: %s", podUID)
+			}
+
+			for numaID, numaState := range dynamicPolicy.state.GetMachineState() {
+				as.True(numaState.AllocatedCPUSet.IsSubsetOf(cpuTopology.CPUDetails.CPUsInNUMANodes(numaID)))
+			}
+			as.True(machine.NewCPUSet(4, 5).Equals(dynamicPolicy.state.GetMachineState()[2].AllocatedCPUSet))
+		})
+	}
+}
+
+func TestHandleAllocationsWithRenumberedNUMAs(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	testName := "test"
+
+	// the checkpoint is generated with 2 NUMAs, and cpus are re-numbered to 4 NUMAs afterwards
+	checkpointTopology, err := machine.GenerateDummyCPUTopology(16, 2, 2)
+	as.Nil(err)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestHandleAllocationsWithRenumberedNUMAs")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	checkpointState, err := state.NewCheckpointState(tmpDir, cpuPluginStateFileName,
+		cpuconsts.CPUResourcePluginPolicyNameDynamic, checkpointTopology, false)
+	as.Nil(err)
+
+	podUID := string(uuid.NewUUID())
+	cpus := machine.NewCPUSet(4, 5)
+	topologyAwareAssignments, err := machine.GetNumaAwareAssignments(checkpointTopology, cpus)
+	as.Nil(err)
+	checkpointState.SetAllocationInfo(podUID, testName, &state.AllocationInfo{
+		PodUid:                           podUID,
+		PodNamespace:                     testName,
+		PodName:                          testName,
+		ContainerName:                    testName,
+		ContainerType:                    pluginapi.ContainerType_MAIN.String(),
+		OwnerPoolName:                    state.PoolNameDedicated,
+		AllocationResult:                 cpus.Clone(),
+		OriginalAllocationResult:         cpus.Clone(),
+		TopologyAwareAssignments:         topologyAwareAssignments,
+		OriginalTopologyAwareAssignments: machine.DeepcopyCPUAssignment(topologyAwareAssignments),
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:                  consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		},
+		QoSLevel:        consts.PodAnnotationQoSLevelDedicatedCores,
+		RequestQuantity: 2,
+	})
+
+	dynamicPolicy, err := getTestDynamicPolicyWithoutInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.missingCPUsAction = cpuconsts.MissingCPUsActionTrim
+	as.Nil(dynamicPolicy.handleAllocationsWithMissingCPUs())
+
+	// refreshed NUMA assignments are persisted even if no cpu is missing
+	restoredState, err := state.NewCheckpointState(tmpDir, cpuPluginStateFileName,
+		cpuconsts.CPUResourcePluginPolicyNameDynamic, cpuTopology, false)
+	as.Nil(err)
+	allocationInfo := restoredState.GetAllocationInfo(podUID, testName)
+	as.NotNil(allocationInfo)
+	as.True(state.CPUAssignmentEquals(map[int]machine.CPUSet{2: cpus}, allocationInfo.TopologyAwareAssignments))
+	as.True(state.CPUAssignmentEquals(map[int]machine.CPUSet{2: cpus}, allocationInfo.OriginalTopologyAwareAssignments))
+	as.True(cpus.Equals(dynamicPolicy.state.GetMachineState()[2].AllocatedCPUSet))
+	as.NotContains(allocationInfo.Annotations, cpuconsts.CPUStateAnnotationKeyMissingCPUs)
+}
+
+func TestGetHealthStatus(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name             string
+		makeUnhealthy    func(t *testing.T, p *DynamicPolicy, stateDir string)
+		expectedReason   string
+		expectConsistent bool
+	}{
+		{
+			name:             "healthy",
+			expectConsistent: true,
+		},
+		{
+			name: "checkpoint write fails",
+			makeUnhealthy: func(t *testing.T, p *DynamicPolicy, stateDir string) {
+				// replace the state directory with a regular file, so that checkpoint can't be written
+				require.NoError(t, os.RemoveAll(stateDir))
+				require.NoError(t, ioutil.WriteFile(stateDir, []byte{}, 0o644))
+				p.state.SetMachineState(p.state.GetMachineState())
+			},
+			expectedReason:   "the latest checkpoint write failed",
+			expectConsistent: true,
+		},
+		{
+			name: "machine state regeneration fails",
+			makeUnhealthy: func(t *testing.T, p *DynamicPolicy, _ string) {
+				p.machineStateGenerator = func(*machine.CPUTopology, state.PodEntries) (state.NUMANodeMap, error) {
+					return nil, fmt.Errorf("failed to read topology")
+				}
+			},
+			expectedReason: "generate machine state from pod entries failed",
+		},
+		{
+			name: "machine state is inconsistent with pod entries",
+			makeUnhealthy: func(t *testing.T, p *DynamicPolicy, _ string) {
+				machineState := p.state.GetMachineState()
+				machineState[3].AllocatedCPUSet = machineState[3].AllocatedCPUSet.Union(machine.NewCPUSet(6))
+				p.state.SetMachineState(machineState)
+			},
+			expectedReason: "machine state is inconsistent with pod entries",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestGetHealthStatus")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+
+			status := dynamicPolicy.GetHealthStatus()
+			as.True(status.LastSuccessfulAdmissionTime.IsZero())
+
+			testName := "test"
+			_, err = dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+				PodUid:         string(uuid.NewUUID()),
+				PodNamespace:   testName,
+				PodName:        testName,
+				ContainerName:  testName,
+				ContainerType:  pluginapi.ContainerType_MAIN,
+				ContainerIndex: 0,
+				ResourceName:   string(v1.ResourceCPU),
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceCPU): 2,
+				},
+				Labels: map[string]string{
+					consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+				},
+				Annotations: map[string]string{
+					consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+				},
+			})
+			as.Nil(err)
+
+			if tc.makeUnhealthy != nil {
+				tc.makeUnhealthy(t, dynamicPolicy, tmpDir)
+			}
+
+			status = dynamicPolicy.GetHealthStatus()
+			as.False(status.LastSuccessfulAdmissionTime.IsZero())
+			as.NotNil(status.CheckpointWriteStatus)
+			as.False(status.CheckpointWriteStatus.LastWriteTime.IsZero())
+			as.Equal(tc.expectConsistent, status.MachineStateConsistent)
+
+			w := httptest.NewRecorder()
+			dynamicPolicy.serveHealth(w, httptest.NewRequest(http.MethodGet, "/debug"+healthDebugPath, nil))
+
+			if tc.expectedReason == "" {
+				as.True(status.Healthy)
+				as.Empty(status.Reasons)
+				as.Empty(status.CheckpointWriteStatus.LastWriteError)
+				as.Equal(http.StatusOK, w.Code)
+				return
+			}
+
+			as.False(status.Healthy)
+			as.Len(status.Reasons, 1)
+			as.Contains(status.Reasons[0], tc.expectedReason)
+			as.Equal(http.StatusServiceUnavailable, w.Code)
+
+			servedStatus := &PolicyHealthStatus{}
+			as.Nil(json.Unmarshal(w.Body.Bytes(), servedStatus))
+			as.False(servedStatus.Healthy)
+			as.Equal(status.Reasons, servedStatus.Reasons)
+		})
+	}
+}
+
+type fakeContainerCPUSharesApplier struct {
+	mutex   sync.Mutex
+	applied map[string]uint64
+}
+
+func (f *fakeContainerCPUSharesApplier) ApplyCPUShares(_, containerID string, shares uint64) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.applied == nil {
+		f.applied = make(map[string]uint64)
+	}
+	f.applied[containerID] = shares
+	return nil
+}
+
+func TestApplyWeightedShares(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestApplyWeightedShares")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	sharesApplier := &fakeContainerCPUSharesApplier{}
+	dynamicPolicy.cpuSharesApplier = sharesApplier
+	dynamicPolicy.enableWeightedShares = true
+
+	testName := "test"
+	testPods := []struct {
+		name    string
+		numa    string
+		request float64
+		weight  string
+	}{
+		{name: "small", numa: "0", request: 2},
+		{name: "large", numa: "0", request: 4},
+		{name: "weighted", numa: "0", request: 2, weight: "3"},
+		{name: "other-numa", numa: "1", request: 2, weight: "3"},
+		{name: "invalid-weight", numa: "2", request: 3, weight: "-1"},
+	}
+
+	podUIDs := make(map[string]string, len(testPods))
+	var podList []*v1.Pod
+	for _, testPod := range testPods {
+		podUID := string(uuid.NewUUID())
+		podUIDs[testPod.name] = podUID
+
+		annotations := map[string]string{
+			consts.PodAnnotationQoSLevelKey:                  consts.PodAnnotationQoSLevelSharedCores,
+			consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+			cpuconsts.CPUStateAnnotationKeyNUMAHint:          testPod.numa,
+		}
+		if testPod.weight != "" {
+			annotations[cpuconsts.PodAnnotationCPUSharesWeight] = testPod.weight
+		}
+
+		dynamicPolicy.state.SetAllocationInfo(podUID, testName, &state.AllocationInfo{
+			PodUid:          podUID,
+			PodNamespace:    testName,
+			PodName:         testPod.name,
+			ContainerName:   testName,
+			ContainerType:   pluginapi.ContainerType_MAIN.String(),
+			QoSLevel:        consts.PodAnnotationQoSLevelSharedCores,
+			RequestQuantity: testPod.request,
+			Annotations:     annotations,
+		})
+
+		podList = append(podList, &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: testPod.name, Namespace: testName, UID: types.UID(podUID)},
+			Spec:       v1.PodSpec{Containers: []v1.Container{{Name: testName}}},
+			Status: v1.PodStatus{
+				ContainerStatuses: []v1.ContainerStatus{{Name: testName, ContainerID: testPod.name}},
+			},
+		})
+	}
+	dynamicPolicy.metaServer = &metaserver.MetaServer{
+		MetaAgent: &agent.MetaAgent{
+			PodFetcher: &pod.PodFetcherStub{PodList: podList},
+		},
+	}
+
+	// 8 cpus are requested on NUMA 0, and shares of them are split by 2:4:6
+	as.Nil(dynamicPolicy.applyWeightedShares([]int{0}))
+	as.Equal(map[string]uint64{
+		"small":    1365,
+		"large":    2730,
+		"weighted": 4096,
+	}, sharesApplier.applied)
+
+	// weight of the only container on the NUMA makes no difference, and invalid weight is treated as 1
+	as.Nil(dynamicPolicy.applyWeightedShares([]int{1, 2}))
+	as.Equal(uint64(2048), sharesApplier.applied["other-numa"])
+	as.Equal(uint64(3072), sharesApplier.applied["invalid-weight"])
+
+	// shares are recomputed for containers left on the NUMA after removing the weighted pod
+	_, err = dynamicPolicy.RemovePod(context.Background(), &pluginapi.RemovePodRequest{PodUid: podUIDs["weighted"]})
+	as.Nil(err)
+	as.Equal(uint64(2048), sharesApplier.applied["small"])
+	as.Equal(uint64(4096), sharesApplier.applied["large"])
+}
+
+func TestSetCPUNUMAHintPreferPolicy(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name         string
+		policy       string
+		expectErr    bool
+		expectPolicy string
+	}{
+		{
+			name:         "switch to packing",
+			policy:       cpuconsts.CPUNUMAHintPreferPolicyPacking,
+			expectPolicy: cpuconsts.CPUNUMAHintPreferPolicyPacking,
+		},
+		{
+			name:         "switch to dynamic packing",
+			policy:       cpuconsts.CPUNUMAHintPreferPolicyDynamicPacking,
+			expectPolicy: cpuconsts.CPUNUMAHintPreferPolicyDynamicPacking,
+		},
+		{
+			name:         "keep spreading",
+			policy:       cpuconsts.CPUNUMAHintPreferPolicySpreading,
+			expectPolicy: cpuconsts.CPUNUMAHintPreferPolicySpreading,
+		},
+		{
+			name:         "unknown policy",
+			policy:       "unknown",
+			expectErr:    true,
+			expectPolicy: cpuconsts.CPUNUMAHintPreferPolicySpreading,
+		},
+		{
+			name:         "empty policy",
+			policy:       "",
+			expectErr:    true,
+			expectPolicy: cpuconsts.CPUNUMAHintPreferPolicySpreading,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestSetCPUNUMAHintPreferPolicy")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.cpuNUMAHintPreferPolicy = cpuconsts.CPUNUMAHintPreferPolicySpreading
+
+			err = dynamicPolicy.SetCPUNUMAHintPreferPolicy(tc.policy)
+			if tc.expectErr {
+				as.NotNil(err)
+			} else {
+				as.Nil(err)
+			}
+			as.Equal(tc.expectPolicy, dynamicPolicy.GetCPUNUMAHintPreferPolicy())
+		})
+	}
+}
+
+func TestGetNUMAOccupancy(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestGetNUMAOccupancy")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	getReportedOccupancy := func() map[int]string {
+		resp, err := dynamicPolicy.GetTopologyAwareAllocatableResources(context.Background(),
+			&pluginapi.GetTopologyAwareAllocatableResourcesRequest{})
+		as.Nil(err)
+
+		occupancy := make(map[int]string)
+		for _, quantity := range resp.AllocatableResources[string(v1.ResourceCPU)].TopologyAwareAllocatableQuantityList {
+			occupancy[int(quantity.Node)] = quantity.Annotations[cpuconsts.TopologyAwareQuantityAnnotationKeyNUMAOccupancy]
+		}
+		return occupancy
+	}
+
+	allFree := map[int]string{
+		0: cpuconsts.NUMAOccupancyFree,
+		1: cpuconsts.NUMAOccupancyFree,
+		2: cpuconsts.NUMAOccupancyFree,
+		3: cpuconsts.NUMAOccupancyFree,
+	}
+	as.Equal(allFree, dynamicPolicy.GetNUMAOccupancy())
+	as.Equal(allFree, getReportedOccupancy())
+
+	// admit a dedicated_cores pod with numa_binding to NUMA 1
+	testName := "test"
+	podUID := string(uuid.NewUUID())
+	_, err = dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+		PodUid:         podUID,
+		PodNamespace:   testName,
+		PodName:        testName,
+		ContainerName:  testName,
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		Hint: &pluginapi.TopologyHint{
+			Nodes:     []uint64{1},
+			Preferred: true,
+		},
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 2,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	})
+	as.Nil(err)
+
+	exclusiveOnNUMA1 := map[int]string{
+		0: cpuconsts.NUMAOccupancyFree,
+		1: cpuconsts.NUMAOccupancyExclusive,
+		2: cpuconsts.NUMAOccupancyFree,
+		3: cpuconsts.NUMAOccupancyFree,
+	}
+	as.Equal(exclusiveOnNUMA1, dynamicPolicy.GetNUMAOccupancy())
+	as.Equal(exclusiveOnNUMA1, getReportedOccupancy())
+
+	// release the pod
+	_, err = dynamicPolicy.RemovePod(context.Background(), &pluginapi.RemovePodRequest{PodUid: podUID})
+	as.Nil(err)
+
+	as.Equal(allFree, dynamicPolicy.GetNUMAOccupancy())
+	as.Equal(allFree, getReportedOccupancy())
+}
+
+func TestCPUReuseCoolDown(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	generateReq := func(podUID string, numaExclusive bool, hint *pluginapi.TopologyHint) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			podUID:            podUID,
+			qosLevel:          consts.PodAnnotationQoSLevelDedicatedCores,
+			memoryEnhancement: fmt.Sprintf(`{"numa_binding": "true", "numa_exclusive": "%v"}`, numaExclusive),
+			quantity:          2,
+			hint:              hint,
+		})
+	}
+
+	testCases := []struct {
+		name     string
+		coolDown time.Duration
+	}{
+		{
+			name: "cool-down disabled",
+		},
+		{
+			name:     "cool-down enabled",
+			coolDown: time.Hour,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestCPUReuseCoolDown")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.cpuReuseCoolDown = tc.coolDown
+
+			// returns single-NUMA hints with their preference
+			hintedNUMAs := func() map[uint64]bool {
+				resp, err := dynamicPolicy.GetTopologyHints(context.Background(),
+					generateReq(string(uuid.NewUUID()), true, nil))
+				as.Nil(err)
+
+				numaNodes := make(map[uint64]bool)
+				for _, hint := range resp.ResourceHints[string(v1.ResourceCPU)].Hints {
+					if len(hint.Nodes) == 1 {
+						numaNodes[hint.Nodes[0]] = hint.Preferred
+					}
+				}
+				return numaNodes
+			}
+
+			// admit a dedicated_cores pod with numa_binding to NUMA 2 and release it
+			podUID := string(uuid.NewUUID())
+			_, err = dynamicPolicy.Allocate(context.Background(),
+				generateReq(podUID, true, &pluginapi.TopologyHint{Nodes: []uint64{2}, Preferred: true}))
+			as.Nil(err)
+			as.NotContains(hintedNUMAs(), uint64(2))
+
+			_, err = dynamicPolicy.RemovePod(context.Background(), &pluginapi.RemovePodRequest{PodUid: podUID})
+			as.Nil(err)
+
+			numa2CPUs := cpuTopology.CPUDetails.CPUsInNUMANodes(2)
+			availableCPUs := dynamicPolicy.state.GetMachineState()[2].GetAvailableCPUSet(dynamicPolicy.getUnavailableCPUs())
+			as.True(availableCPUs.Equals(numa2CPUs))
+			as.Equal(4, dynamicPolicy.getNUMAAllocatableCPUQuantity(2))
+			if tc.coolDown <= 0 {
+				as.True(dynamicPolicy.getCoolingDownCPUs().IsEmpty())
+				as.True(hintedNUMAs()[2])
+				return
+			}
+
+			// NUMA with only recently-freed cpus left is still a candidate, but not preferred
+			as.True(dynamicPolicy.getCoolingDownCPUs().Equals(numa2CPUs))
+			hints := hintedNUMAs()
+			as.Contains(hints, uint64(2))
+			as.False(hints[2])
+			as.True(hints[3])
+
+			// cpus are available again after the cool-down elapses
+			for cpu := range dynamicPolicy.cpuReleaseTimestamps {
+				dynamicPolicy.cpuReleaseTimestamps[cpu] = time.Now().Add(-tc.coolDown)
+			}
+			as.True(dynamicPolicy.getCoolingDownCPUs().IsEmpty())
+			as.True(hintedNUMAs()[2])
+		})
+	}
+}
+
+func TestCPUReuseCoolDownAllocation(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestCPUReuseCoolDownAllocation")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.cpuReuseCoolDown = time.Hour
+
+	testName := "test"
+	allocate := func(numaExclusive bool, numaID uint64) (string, machine.CPUSet) {
+		podUID := string(uuid.NewUUID())
+		_, err := dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+			PodUid:         podUID,
+			PodNamespace:   testName,
+			PodName:        testName,
+			ContainerName:  testName,
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			Hint:           &pluginapi.TopologyHint{Nodes: []uint64{numaID}, Preferred: true},
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): 2,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: fmt.Sprintf(`{"numa_binding": "true", "numa_exclusive": "%v"}`,
+					numaExclusive),
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		})
+		as.Nil(err)
+
+		allocationInfo := dynamicPolicy.state.GetAllocationInfo(podUID, testName)
+		as.NotNil(allocationInfo)
+		return podUID, allocationInfo.AllocationResult.Clone()
+	}
+
+	// recently-freed cpus are avoided if other cpus in the NUMA fit
+	podUID, releasedCPUs := allocate(false, 3)
+	_, err = dynamicPolicy.RemovePod(context.Background(), &pluginapi.RemovePodRequest{PodUid: podUID})
+	as.Nil(err)
+	as.True(dynamicPolicy.getCoolingDownCPUs().Equals(releasedCPUs))
+
+	_, cpus := allocate(false, 3)
+	as.True(cpus.Intersection(releasedCPUs).IsEmpty(), "cpus: %s, released: %s", cpus.String(), releasedCPUs.String())
+
+	// pods are still admitted to the NUMA where only cpus in cool-down are left
+	podUID, releasedCPUs = allocate(true, 2)
+	_, err = dynamicPolicy.RemovePod(context.Background(), &pluginapi.RemovePodRequest{PodUid: podUID})
+	as.Nil(err)
+	as.True(releasedCPUs.IsSubsetOf(dynamicPolicy.getCoolingDownCPUs()))
+
+	_, cpus = allocate(true, 2)
+	as.True(cpus.Equals(cpuTopology.CPUDetails.CPUsInNUMANodes(2)))
+}
+
+func TestCPUHintScores(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	generateReq := func() *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			qosLevel:          consts.PodAnnotationQoSLevelSharedCores,
+			memoryEnhancement: `{"numa_binding": "true"}`,
+			quantity:          1,
+		})
+	}
+
+	testCases := []struct {
+		name             string
+		enableHintScores bool
+		preferPolicy     string
+		// expectedScores are scores of single-NUMA hints, NUMA 0 and 1 have 3 available cpus
+		// (cpu 0 and 2 are reserved) while NUMA 2 and 3 have 4
+		expectedScores map[string]int
+	}{
+		{
+			name:         "hint scores disabled",
+			preferPolicy: cpuconsts.CPUNUMAHintPreferPolicySpreading,
+		},
+		{
+			name:             "hint scores with spreading",
+			enableHintScores: true,
+			preferPolicy:     cpuconsts.CPUNUMAHintPreferPolicySpreading,
+			expectedScores:   map[string]int{"0": 67, "1": 67, "2": 75, "3": 75},
+		},
+		{
+			name:             "hint scores with packing",
+			enableHintScores: true,
+			preferPolicy:     cpuconsts.CPUNUMAHintPreferPolicyPacking,
+			expectedScores:   map[string]int{"0": 33, "1": 33, "2": 25, "3": 25},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestCPUHintScores")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.enableHintScores = tc.enableHintScores
+			dynamicPolicy.cpuNUMAHintPreferPolicy = tc.preferPolicy
+
+			resp, err := dynamicPolicy.GetTopologyHints(context.Background(), generateReq())
+			as.Nil(err)
+
+			scoresStr, ok := resp.Annotations[cpuconsts.ResourceHintsAnnotationKeyCPUHintScores]
+			if !tc.enableHintScores {
+				as.False(ok)
+				return
+			}
+			as.True(ok)
+
+			scores := make(map[string]int)
+			as.Nil(json.Unmarshal([]byte(scoresStr), &scores))
+			as.Equal(tc.expectedScores, scores)
+
+			// the standard preferred field is kept for normal consumers
+			for _, hint := range resp.ResourceHints[string(v1.ResourceCPU)].Hints {
+				if hint.Preferred {
+					numaSet, err := machine.NewCPUSetUint64(hint.Nodes...)
+					as.Nil(err)
+					for numaID, score := range scores {
+						as.GreaterOrEqual(scores[numaSet.String()], score, "NUMA %s is scored higher than preferred", numaID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDedicatedSidecarCPUSetMode(t *testing.T) {
+	t.Parallel()
+
+	// cpus of NUMA 0 are 0, 1, 8 and 9, and cpu 0 is reserved
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	generateReq := func(podUID, containerName string, containerType pluginapi.ContainerType,
+		reqQuantity float64, sidecarCPUSetMode string,
+	) *pluginapi.ResourceRequest {
+		cpuEnhancement := ""
+		if sidecarCPUSetMode != "" {
+			cpuEnhancement = fmt.Sprintf(`{"%s": "%s"}`, cpuconsts.PodAnnotationCPUSidecarCPUSetMode, sidecarCPUSetMode)
+		}
+		req := newTestRequest(testRequest{
+			podUID:            podUID,
+			containerName:     containerName,
+			qosLevel:          consts.PodAnnotationQoSLevelDedicatedCores,
+			memoryEnhancement: `{"numa_binding": "true"}`,
+			cpuEnhancement:    cpuEnhancement,
+			quantity:          reqQuantity,
+			hint:              &pluginapi.TopologyHint{Nodes: []uint64{0}, Preferred: true},
+		})
+		req.ContainerType = containerType
+		return req
+	}
+
+	testCases := []struct {
+		name              string
+		sidecarCPUSetMode string
+		sidecarQuantity   float64
+		expectedIsolated  bool
+	}{
+		{
+			name:            "sidecar cpuset mode not set",
+			sidecarQuantity: 1,
+		},
+		{
+			name:              "shared sidecar cpuset mode",
+			sidecarCPUSetMode: cpuconsts.SidecarCPUSetModeShared,
+			sidecarQuantity:   1,
+		},
+		{
+			name:              "isolated sidecar cpuset mode",
+			sidecarCPUSetMode: cpuconsts.SidecarCPUSetModeIsolated,
+			sidecarQuantity:   1,
+			expectedIsolated:  true,
+		},
+		{
+			name:              "isolated sidecar cpuset mode without cpu request",
+			sidecarCPUSetMode: cpuconsts.SidecarCPUSetModeIsolated,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestDedicatedSidecarCPUSetMode")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+
+			podUID := string(uuid.NewUUID())
+			_, err = dynamicPolicy.Allocate(context.Background(),
+				generateReq(podUID, "main", pluginapi.ContainerType_MAIN, 1, tc.sidecarCPUSetMode))
+			as.Nil(err)
+
+			resp, err := dynamicPolicy.Allocate(context.Background(),
+				generateReq(podUID, "sidecar", pluginapi.ContainerType_SIDECAR, tc.sidecarQuantity, tc.sidecarCPUSetMode))
+			as.Nil(err)
+
+			mainAllocationInfo := dynamicPolicy.state.GetAllocationInfo(podUID, "main")
+			as.NotNil(mainAllocationInfo)
+			sidecarAllocationInfo := dynamicPolicy.state.GetAllocationInfo(podUID, "sidecar")
+			as.NotNil(sidecarAllocationInfo)
+			as.Equal(sidecarAllocationInfo.AllocationResult.String(),
+				resp.AllocationResult.ResourceAllocation[string(v1.ResourceCPU)].AllocationResult)
+
+			allocatedCPUs := dynamicPolicy.state.GetMachineState()[0].AllocatedCPUSet
+			if !tc.expectedIsolated {
+				as.True(sidecarAllocationInfo.AllocationResult.Equals(mainAllocationInfo.AllocationResult))
+				as.True(allocatedCPUs.Equals(mainAllocationInfo.AllocationResult))
+				return
+			}
+
+			sidecarCPUs := sidecarAllocationInfo.AllocationResult
+			as.Equal(1, sidecarCPUs.Size())
+			as.True(sidecarCPUs.IsSubsetOf(cpuTopology.CPUDetails.CPUsInNUMANodes(0)))
+			as.False(sidecarCPUs.Contains(0), "reserved cpus must not be handed out to isolated sidecars")
+			as.Zero(sidecarCPUs.IntersectionSize(mainAllocationInfo.AllocationResult))
+			// isolated sidecars are counted as allocated in the NUMA
+			as.True(allocatedCPUs.Equals(mainAllocationInfo.AllocationResult.Union(sidecarCPUs)))
+		})
+	}
+}
+
+func TestIsolatedSidecarCPUsNotOverlapped(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	// cpus of NUMA 0 are 0, 1, 8 and 9, and cpu 0 is reserved
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestIsolatedSidecarCPUsNotOverlapped")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	generateReq := func(podUID, containerName string, containerType pluginapi.ContainerType) *pluginapi.ResourceRequest {
+		req := newTestRequest(testRequest{
+			podUID:            podUID,
+			podNamespace:      podUID,
+			podName:           podUID,
+			containerName:     containerName,
+			qosLevel:          consts.PodAnnotationQoSLevelDedicatedCores,
+			noQoSLabel:        true,
+			memoryEnhancement: `{"numa_binding": "true"}`,
+			cpuEnhancement: fmt.Sprintf(`{"%s": "%s"}`,
+				cpuconsts.PodAnnotationCPUSidecarCPUSetMode, cpuconsts.SidecarCPUSetModeIsolated),
+			quantity: 1,
+			hint:     &pluginapi.TopologyHint{Nodes: []uint64{0}, Preferred: true},
+		})
+		req.ContainerType = containerType
+		return req
+	}
+
+	// pod1 with an isolated sidecar and pod2 take up all the cpus of NUMA 0 except the reserved one
+	_, err = dynamicPolicy.Allocate(context.Background(), generateReq("pod1", "main", pluginapi.ContainerType_MAIN))
+	as.Nil(err)
+	_, err = dynamicPolicy.Allocate(context.Background(), generateReq("pod1", "sidecar", pluginapi.ContainerType_SIDECAR))
+	as.Nil(err)
+	_, err = dynamicPolicy.Allocate(context.Background(), generateReq("pod2", "main", pluginapi.ContainerType_MAIN))
+	as.Nil(err)
+
+	allocatedCPUs := machine.NewCPUSet()
+	for _, entry := range []struct{ podUID, containerName string }{
+		{"pod1", "main"}, {"pod1", "sidecar"}, {"pod2", "main"},
+	} {
+		allocationInfo := dynamicPolicy.state.GetAllocationInfo(entry.podUID, entry.containerName)
+		as.NotNil(allocationInfo)
+		as.Equal(1, allocationInfo.AllocationResult.Size())
+		as.Zero(allocationInfo.AllocationResult.IntersectionSize(allocatedCPUs),
+			"cpus: %s of pod: %s, container: %s overlap with cpus: %s allocated before",
+			allocationInfo.AllocationResult.String(), entry.podUID, entry.containerName, allocatedCPUs.String())
+		allocatedCPUs = allocatedCPUs.Union(allocationInfo.AllocationResult)
+	}
+	as.Equal(machine.NewCPUSet(1, 8, 9), allocatedCPUs)
+	as.Equal(allocatedCPUs, dynamicPolicy.state.GetMachineState()[0].AllocatedCPUSet)
+
+	// no cpus left for the isolated sidecar of pod2, and the reserved cpu is never handed out
+	_, err = dynamicPolicy.Allocate(context.Background(), generateReq("pod2", "sidecar", pluginapi.ContainerType_SIDECAR))
+	as.NotNil(err)
+	as.Nil(dynamicPolicy.state.GetAllocationInfo("pod2", "sidecar"))
+}
+
+func TestMalformedEnhancementAnnotations(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestMalformedEnhancementAnnotations")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	generateReq := func(memoryEnhancement string) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			qosLevel:          consts.PodAnnotationQoSLevelDedicatedCores,
+			memoryEnhancement: memoryEnhancement,
+			quantity:          2,
+			hint:              &pluginapi.TopologyHint{Nodes: []uint64{2}, Preferred: true},
+		})
+	}
+
+	// typo'd numa_binding value is rejected rather than taking the path without NUMA binding
+	_, err = dynamicPolicy.GetTopologyHints(context.Background(), generateReq(`{"numa_binding": "ture"}`))
+	as.ErrorContains(err, "invalid value")
+
+	_, err = dynamicPolicy.Allocate(context.Background(), generateReq(`{"numa_binding": "ture"}`))
+	as.ErrorContains(err, "invalid value")
+
+	// value is normalized before being used
+	_, err = dynamicPolicy.GetTopologyHints(context.Background(), generateReq(`{"numa_binding": "True", "numa_exclusive": "true"}`))
+	as.Nil(err)
+
+	_, err = dynamicPolicy.Allocate(context.Background(), generateReq(`{"numa_binding": "True", "numa_exclusive": "true"}`))
+	as.Nil(err)
+}
+
+func TestNUMAExclusiveMode(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testName := "test"
+	generateReq := func(podUID string, reqQuantity float64, numaExclusive bool, exclusiveMode string,
+		hint *pluginapi.TopologyHint,
+	) *pluginapi.ResourceRequest {
+		cpuEnhancement := ""
+		if exclusiveMode != "" {
+			cpuEnhancement = fmt.Sprintf(`{"%s": "%s"}`, cpuconsts.PodAnnotationCPUNUMAExclusiveMode, exclusiveMode)
+		}
+		return newTestRequest(testRequest{
+			podUID:            podUID,
+			qosLevel:          consts.PodAnnotationQoSLevelDedicatedCores,
+			memoryEnhancement: fmt.Sprintf(`{"numa_binding": "true", "numa_exclusive": "%v"}`, numaExclusive),
+			cpuEnhancement:    cpuEnhancement,
+			quantity:          reqQuantity,
+			hint:              hint,
+		})
+	}
+
+	testCases := []struct {
+		name          string
+		exclusiveMode string
+		reqQuantity   float64
+		// whether the partially-allocated NUMA 2 is hinted as a single NUMA
+		expectNUMA2Hinted bool
+	}{
+		{
+			name:        "node-exclusive by default",
+			reqQuantity: 1,
+		},
+		{
+			name:          "node-exclusive",
+			exclusiveMode: cpuconsts.NUMAExclusiveModeNode,
+			reqQuantity:   1,
+		},
+		{
+			name:              "core-exclusive",
+			exclusiveMode:     cpuconsts.NUMAExclusiveModeCore,
+			reqQuantity:       1,
+			expectNUMA2Hinted: true,
+		},
+		{
+			name:          "core-exclusive without enough full cores",
+			exclusiveMode: cpuconsts.NUMAExclusiveModeCore,
+			reqQuantity:   3,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestNUMAExclusiveMode")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+
+			// allocate a dedicated_cores pod with numa_binding but not numa_exclusive to NUMA 2 partially
+			tenantPodUID := string(uuid.NewUUID())
+			_, err = dynamicPolicy.Allocate(context.Background(), generateReq(tenantPodUID, 1, false, "",
+				&pluginapi.TopologyHint{Nodes: []uint64{2}, Preferred: true}))
+			as.Nil(err)
+			tenantCPUs := dynamicPolicy.state.GetAllocationInfo(tenantPodUID, testName).AllocationResult
+			as.Equal(1, tenantCPUs.Size())
+
+			podUID := string(uuid.NewUUID())
+			resp, err := dynamicPolicy.GetTopologyHints(context.Background(),
+				generateReq(podUID, tc.reqQuantity, true, tc.exclusiveMode, nil))
+			as.Nil(err)
+
+			numa2Hinted := false
+			for _, hint := range resp.ResourceHints[string(v1.ResourceCPU)].Hints {
+				if len(hint.Nodes) == 1 && hint.Nodes[0] == 2 {
+					numa2Hinted = true
+				}
+			}
+			as.Equal(tc.expectNUMA2Hinted, numa2Hinted)
+
+			if !tc.expectNUMA2Hinted {
+				return
+			}
+
+			// core-exclusive container takes up whole physical cores on NUMA 2 apart from the co-tenant
+			_, err = dynamicPolicy.Allocate(context.Background(), generateReq(podUID, tc.reqQuantity, true, tc.exclusiveMode,
+				&pluginapi.TopologyHint{Nodes: []uint64{2}, Preferred: true}))
+			as.Nil(err)
+
+			result := dynamicPolicy.state.GetAllocationInfo(podUID, testName).AllocationResult
+			as.Equal(cpuTopology.CPUsPerCore(), result.Size())
+			as.True(result.IsSubsetOf(cpuTopology.CPUDetails.CPUsInNUMANodes(2)))
+			as.True(result.Intersection(tenantCPUs).IsEmpty())
+			as.True(result.Equals(cpuTopology.CPUDetails.CPUsInCores(
+				cpuTopology.CPUDetails.FullCoresInCPUs(result).ToSliceNoSortInt()...)))
+		})
+	}
+}
+
+func TestSharedNUMAPools(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestSharedNUMAPools")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	generateReq := func(podUID string, reqQuantity float64, numaID uint64) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			podUID:            podUID,
+			qosLevel:          consts.PodAnnotationQoSLevelSharedCores,
+			memoryEnhancement: `{"numa_binding": "true"}`,
+			quantity:          reqQuantity,
+			hint:              &pluginapi.TopologyHint{Nodes: []uint64{numaID}, Preferred: true},
+		})
+	}
+
+	// checkSharedNUMAPools makes sure maintained shared pools match the ones recomputed from scratch
+	checkSharedNUMAPools := func(step string) {
+		sharedNUMAPools := dynamicPolicy.state.GetSharedNUMAPools()
+		recomputedPools := state.NewSharedNUMAPools(dynamicPolicy.state.GetPodEntries())
+		machineState := dynamicPolicy.state.GetMachineState()
+		unavailableCPUs := dynamicPolicy.getUnavailableCPUs()
+
+		for numaID := range machineState {
+			as.Equal(recomputedPools[numaID].GetMemberCount(), sharedNUMAPools[numaID].GetMemberCount(),
+				"%s: member count of NUMA %d", step, numaID)
+			as.Equal(recomputedPools[numaID].GetRequestedQuantity(), sharedNUMAPools[numaID].GetRequestedQuantity(),
+				"%s: requested quantity of NUMA %d", step, numaID)
+			as.Equal(machineState[numaID].GetAvailableCPUQuantity(unavailableCPUs),
+				dynamicPolicy.getNUMAAvailableCPUQuantity(sharedNUMAPools, numaID, unavailableCPUs),
+				"%s: available quantity of NUMA %d", step, numaID)
+		}
+	}
+
+	steps := []struct {
+		name        string
+		podIndex    int
+		reqQuantity float64
+		numaID      uint64
+		release     bool
+	}{
+		{name: "allocate pod 0 to NUMA 2", podIndex: 0, reqQuantity: 1, numaID: 2},
+		{name: "allocate pod 1 to NUMA 2", podIndex: 1, reqQuantity: 1.5, numaID: 2},
+		{name: "allocate pod 2 to NUMA 3", podIndex: 2, reqQuantity: 2, numaID: 3},
+		{name: "allocate pod 3 to NUMA 1", podIndex: 3, reqQuantity: 0.5, numaID: 1},
+		{name: "release pod 1", podIndex: 1, release: true},
+		{name: "allocate pod 4 to NUMA 2", podIndex: 4, reqQuantity: 2, numaID: 2},
+		{name: "release pod 0", podIndex: 0, release: true},
+		{name: "release pod 2", podIndex: 2, release: true},
+	}
+
+	podUIDs := make([]string, 5)
+	for i := range podUIDs {
+		podUIDs[i] = string(uuid.NewUUID())
+	}
+
+	checkSharedNUMAPools("initial")
+	for _, step := range steps {
+		if step.release {
+			_, err = dynamicPolicy.RemovePod(context.Background(), &pluginapi.RemovePodRequest{PodUid: podUIDs[step.podIndex]})
+		} else {
+			_, err = dynamicPolicy.Allocate(context.Background(),
+				generateReq(podUIDs[step.podIndex], step.reqQuantity, step.numaID))
+		}
+		as.Nil(err, step.name)
+		checkSharedNUMAPools(step.name)
+	}
+
+	// pod 3 and pod 4 are left in NUMA 1 and NUMA 2
+	sharedNUMAPools := dynamicPolicy.state.GetSharedNUMAPools()
+	as.Equal(1, sharedNUMAPools[1].GetRequestedQuantity())
+	as.Equal(2, sharedNUMAPools[2].GetRequestedQuantity())
+	as.Equal(0, sharedNUMAPools[3].GetMemberCount())
+}
+
+func TestSidecarReservedCPUForNUMABindingSharedCores(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestSidecarReservedCPUForNUMABindingSharedCores")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	testName := "test"
+	generateReq := func(podUID, containerName string, containerType pluginapi.ContainerType, reqQuantity float64,
+		hint *pluginapi.TopologyHint, sidecarReservedCPU string,
+	) *pluginapi.ResourceRequest {
+		cpuEnhancement := ""
+		if sidecarReservedCPU != "" {
+			cpuEnhancement = fmt.Sprintf(`{"%s": "%s"}`, cpuconsts.PodAnnotationCPUSidecarReservedCPU, sidecarReservedCPU)
+		}
+		req := newTestRequest(testRequest{
+			podUID:            podUID,
+			containerName:     containerName,
+			qosLevel:          consts.PodAnnotationQoSLevelSharedCores,
+			memoryEnhancement: `{"numa_binding": "true"}`,
+			cpuEnhancement:    cpuEnhancement,
+			quantity:          reqQuantity,
+			hint:              hint,
+		})
+		req.ContainerType = containerType
+		return req
+	}
+	getPreferredNUMAs := func(req *pluginapi.ResourceRequest) []uint64 {
+		resp, err := dynamicPolicy.GetTopologyHints(context.Background(), req)
+		as.Nil(err)
+
+		var numaIDs []uint64
+		for _, hint := range resp.ContainerTopologyHints[string(v1.ResourceCPU)].Hints {
+			if hint.Preferred && len(hint.Nodes) == 1 {
+				numaIDs = append(numaIDs, hint.Nodes[0])
+			}
+		}
+		return numaIDs
+	}
+
+	// leave NUMA 1 with 2 free cpus, NUMA 2 with 3 free cpus and NUMA 3 with 1 free cpu,
+	// NUMA 0 has only 2 cpus left after reserved ones, and 1 of them is taken.
+	for numaID, quantity := range map[uint64]float64{0: 1, 1: 2, 2: 1, 3: 3} {
+		_, err = dynamicPolicy.Allocate(context.Background(), generateReq(string(uuid.NewUUID()), testName,
+			pluginapi.ContainerType_MAIN, quantity, &pluginapi.TopologyHint{Nodes: []uint64{numaID}, Preferred: true}, ""))
+		as.Nil(err)
+	}
+
+	// the main container requesting 1 cpu with 2 cpus reserved for sidecars only fits NUMA 2
+	podUID := string(uuid.NewUUID())
+	mainReq := generateReq(podUID, testName, pluginapi.ContainerType_MAIN, 1, nil, "2")
+	as.Equal([]uint64{2}, getPreferredNUMAs(mainReq))
+	as.ElementsMatch([]uint64{1, 2}, getPreferredNUMAs(generateReq(podUID, testName, pluginapi.ContainerType_MAIN, 1, nil, "")))
+
+	// the reservation is counted in the NUMA once the main container is admitted
+	mainReq.Hint = &pluginapi.TopologyHint{Nodes: []uint64{2}, Preferred: true}
+	_, err = dynamicPolicy.Allocate(context.Background(), mainReq)
+	as.Nil(err)
+	as.Equal(4, dynamicPolicy.state.GetSharedNUMAPools()[2].GetRequestedQuantity())
+	as.Empty(getPreferredNUMAs(generateReq(string(uuid.NewUUID()), testName, pluginapi.ContainerType_MAIN, 1, nil, "")))
+
+	// the sidecar consumes the reservation instead of adding up to it
+	_, err = dynamicPolicy.Allocate(context.Background(),
+		generateReq(podUID, "sidecar", pluginapi.ContainerType_SIDECAR, 1.5, nil, "2"))
+	as.Nil(err)
+	as.NotNil(dynamicPolicy.state.GetAllocationInfo(podUID, "sidecar"))
+	sharedNUMAPools := dynamicPolicy.state.GetSharedNUMAPools()
+	as.Equal(2, sharedNUMAPools[2].GetMemberCount())
+	as.Equal(4, sharedNUMAPools[2].GetRequestedQuantity())
+	as.Equal(sharedNUMAPools[2].GetRequestedQuantity(),
+		state.NewSharedNUMAPools(dynamicPolicy.state.GetPodEntries())[2].GetRequestedQuantity())
+
+	// the reservation is released along with the pod
+	_, err = dynamicPolicy.RemovePod(context.Background(), &pluginapi.RemovePodRequest{PodUid: podUID})
+	as.Nil(err)
+	as.Equal(1, dynamicPolicy.state.GetSharedNUMAPools()[2].GetRequestedQuantity())
+}
+
+func TestEvictReclaimedPodsToFreeNUMA(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestEvictReclaimedPodsToFreeNUMA")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.reclaimedNUMAOvercommitRatio = 1
+	dynamicPolicy.maxReclaimedEvictionsFreeNUMA = 2
+
+	highPriority, candidatePriority := int32(1000), int32(100)
+	candidatePodUID := string(uuid.NewUUID())
+	pods := []*v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: candidatePodUID, Namespace: "test", UID: types.UID(candidatePodUID)}},
+	}
+
+	// reclaimed_cores pods occupy every NUMA, so that none of them fits 2 more cpus with overcommit ratio 1
+	reclaimedPods := []struct {
+		name         string
+		numaID       int
+		quantity     float64
+		highPriority bool
+	}{
+		// NUMA 0 can't be freed since the pod has higher priority than the candidate
+		{name: "a", numaID: 0, quantity: 3, highPriority: true},
+		// NUMA 1 needs 2 evictions
+		{name: "b1", numaID: 1, quantity: 1.5},
+		{name: "b2", numaID: 1, quantity: 1.5},
+		{name: "b3", numaID: 1, quantity: 1},
+		// NUMA 2 needs only 1 eviction
+		{name: "c1", numaID: 2, quantity: 2},
+		{name: "c2", numaID: 2, quantity: 1, highPriority: true},
+		// NUMA 3 needs 2 evictions
+		{name: "d1", numaID: 3, quantity: 1},
+		{name: "d2", numaID: 3, quantity: 1},
+		{name: "d3", numaID: 3, quantity: 1},
+		{name: "d4", numaID: 3, quantity: 1},
+	}
+	reclaimedPodUIDs := make(map[string]string, len(reclaimedPods))
+	for _, reclaimedPod := range reclaimedPods {
+		podUID := string(uuid.NewUUID())
+		reclaimedPodUIDs[reclaimedPod.name] = podUID
+
+		podObj := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: reclaimedPod.name, Namespace: "test", UID: types.UID(podUID)}}
+		if reclaimedPod.highPriority {
+			podObj.Spec.Priority = &highPriority
+		}
+		pods = append(pods, podObj)
+
+		dynamicPolicy.state.SetAllocationInfo(podUID, "c", &state.AllocationInfo{
+			PodUid:        podUID,
+			PodNamespace:  "test",
+			PodName:       reclaimedPod.name,
+			ContainerName: "c",
+			ContainerType: pluginapi.ContainerType_MAIN.String(),
+			OwnerPoolName: state.PoolNameReclaim,
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:         consts.PodAnnotationQoSLevelReclaimedCores,
+				cpuconsts.CPUStateAnnotationKeyNUMAHint: fmt.Sprintf("%d", reclaimedPod.numaID),
+			},
+			QoSLevel:        consts.PodAnnotationQoSLevelReclaimedCores,
+			RequestQuantity: reclaimedPod.quantity,
+		})
+	}
+
+	dynamicPolicy.metaServer = &metaserver.MetaServer{
+		MetaAgent: &agent.MetaAgent{
+			PodFetcher: &pod.PodFetcherStub{PodList: pods},
+		},
+	}
+
+	req := &pluginapi.ResourceRequest{
+		PodUid:         candidatePodUID,
+		PodNamespace:   "test",
+		PodName:        candidatePodUID,
+		ContainerName:  "test",
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 2,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	}
+
+	// pods with priority equal to the candidate's are never evicted
+	hintsResp, err := dynamicPolicy.GetTopologyHints(context.Background(), req)
+	as.Nil(err)
+	as.Len(hintsResp.ResourceHints[string(v1.ResourceCPU)].Hints, 0)
+	as.Len(dynamicPolicy.state.GetPodEntries().FilterByAnnotation(cpuconsts.CPUStateAnnotationKeyFreeNUMAEviction, "2"), 0)
+
+	pods[0].Spec.Priority = &candidatePriority
+	hintsResp, err = dynamicPolicy.GetTopologyHints(context.Background(), req)
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{{Nodes: []uint64{2}, Preferred: true}},
+		hintsResp.ResourceHints[string(v1.ResourceCPU)].Hints)
+	// nothing is flagged until the candidate is admitted
+	as.Len(dynamicPolicy.state.GetPodEntries().FilterByAnnotation(cpuconsts.CPUStateAnnotationKeyFreeNUMAEviction, "2"), 0)
+
+	req.Hint = hintsResp.ResourceHints[string(v1.ResourceCPU)].Hints[0]
+	_, err = dynamicPolicy.Allocate(context.Background(), req)
+	as.Nil(err)
+	as.Equal(machine.NewCPUSet(2), dynamicPolicy.state.GetAllocationInfo(candidatePodUID, "test").GetAllocationResultNUMASet())
+
+	// only the minimal eviction set is flagged
+	for name, podUID := range reclaimedPodUIDs {
+		numaID, flagged := dynamicPolicy.state.GetAllocationInfo(podUID, "c").Annotations[cpuconsts.CPUStateAnnotationKeyFreeNUMAEviction]
+		if name == "c1" {
+			as.True(flagged)
+			as.Equal("2", numaID)
+		} else {
+			as.False(flagged, name)
+		}
+	}
+
+	// nothing is evicted if no NUMA can be freed within the bound (NUMA 3 needs 4 evictions for 4 cpus)
+	dynamicPolicy.maxReclaimedEvictionsFreeNUMA = 1
+	req.ResourceRequests[string(v1.ResourceCPU)] = 4
+	req.PodUid = string(uuid.NewUUID())
+	req.Hint = nil
+	pods[0].UID = types.UID(req.PodUid)
+	hintsResp, err = dynamicPolicy.GetTopologyHints(context.Background(), req)
+	as.Nil(err)
+	as.Len(hintsResp.ResourceHints[string(v1.ResourceCPU)].Hints, 0)
+	as.Len(dynamicPolicy.state.GetPodEntries().FilterByAnnotation(cpuconsts.CPUStateAnnotationKeyFreeNUMAEviction, "2"), 1)
+}
+
+// fakeMemoryNUMAsGetter returns NUMAs of memory allocated with numa_binding keyed by pod uid
+type fakeMemoryNUMAsGetter map[string]machine.CPUSet
+
+func (g fakeMemoryNUMAsGetter) GetMemoryNUMAs(podUID string) (machine.CPUSet, bool, error) {
+	numaNodes, ok := g[podUID]
+	return numaNodes, ok, nil
+}
+
+func TestDisplaceSharedPodsToFreeNUMA(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestDisplaceSharedPodsToFreeNUMA")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	cpusetManager := &fakeContainerCPUSetManager{cpusets: make(map[string]machine.CPUSet)}
+	dynamicPolicy.cpusetManager = cpusetManager
+
+	// numa_binding shared_cores pods occupy NUMAs 1-3, and NUMA 0 has only 2 cpus left after reserved ones
+	sharedPods := []struct {
+		name     string
+		numaID   uint64
+		quantity float64
+	}{
+		{name: "a", numaID: 1, quantity: 2},
+		{name: "b", numaID: 2, quantity: 1},
+		{name: "c1", numaID: 3, quantity: 1},
+		{name: "c2", numaID: 3, quantity: 1},
+	}
+	sharedPodUIDs := make(map[string]string, len(sharedPods))
+	pods := make([]*v1.Pod, 0, len(sharedPods))
+	for _, sharedPod := range sharedPods {
+		podUID := string(uuid.NewUUID())
+		sharedPodUIDs[sharedPod.name] = podUID
+		pods = append(pods, &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: sharedPod.name, Namespace: "test", UID: types.UID(podUID)},
+			Status: v1.PodStatus{
+				ContainerStatuses: []v1.ContainerStatus{{Name: "c", ContainerID: sharedPod.name}},
+			},
+		})
+
+		_, err = dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+			PodUid:         podUID,
+			PodNamespace:   "test",
+			PodName:        sharedPod.name,
+			ContainerName:  "c",
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			Hint:           &pluginapi.TopologyHint{Nodes: []uint64{sharedPod.numaID}, Preferred: true},
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): sharedPod.quantity,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelSharedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+			},
+		})
+		as.Nil(err)
+		cpusetManager.cpusets[sharedPod.name] = dynamicPolicy.state.GetAllocationInfo(podUID, "c").AllocationResult.Clone()
+	}
+
+	dynamicPolicy.metaServer = &metaserver.MetaServer{
+		MetaAgent: &agent.MetaAgent{
+			PodFetcher: &pod.PodFetcherStub{PodList: pods},
+		},
+	}
+
+	req := &pluginapi.ResourceRequest{
+		PodUid:         string(uuid.NewUUID()),
+		PodNamespace:   "test",
+		PodName:        "test",
+		ContainerName:  "test",
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 4,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	}
+
+	// no NUMA fits the request without displacement
+	hintsResp, err := dynamicPolicy.GetTopologyHints(context.Background(), req)
+	as.Nil(err)
+	as.Len(hintsResp.ResourceHints[string(v1.ResourceCPU)].Hints, 0)
+
+	// NUMA 3 needs 2 displacements, and pods in NUMAs needing 1 displacement can't be moved
+	// since their memory is bound to the NUMAs
+	dynamicPolicy.maxSharedDisplacements = 1
+	dynamicPolicy.memoryNUMAsGetter = fakeMemoryNUMAsGetter{
+		sharedPodUIDs["a"]: machine.NewCPUSet(1),
+		sharedPodUIDs["b"]: machine.NewCPUSet(2),
+	}
+	hintsResp, err = dynamicPolicy.GetTopologyHints(context.Background(), req)
+	as.Nil(err)
+	as.Len(hintsResp.ResourceHints[string(v1.ResourceCPU)].Hints, 0)
+
+	// NUMA 3 needs 2 displacements, NUMA 1 is picked among NUMAs needing 1 displacement,
+	// since memory of pods isn't allocated with numa_binding by memory plugin
+	dynamicPolicy.memoryNUMAsGetter = fakeMemoryNUMAsGetter{}
+	hintsResp, err = dynamicPolicy.GetTopologyHints(context.Background(), req)
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{{Nodes: []uint64{1}, Preferred: true}},
+		hintsResp.ResourceHints[string(v1.ResourceCPU)].Hints)
+	// nothing is moved until the dedicated_cores container is admitted
+	as.Equal(machine.NewCPUSet(1), dynamicPolicy.state.GetAllocationInfo(sharedPodUIDs["a"], "c").GetAllocationResultNUMASet())
+
+	req.Hint = hintsResp.ResourceHints[string(v1.ResourceCPU)].Hints[0]
+	_, err = dynamicPolicy.Allocate(context.Background(), req)
+	as.Nil(err)
+	as.Equal(cpuTopology.CPUDetails.CPUsInNUMANodes(1).String(),
+		dynamicPolicy.state.GetAllocationInfo(req.PodUid, "test").AllocationResult.String())
+
+	// pod a is moved to NUMA 2 with the most available cpus, and its new cpuset is applied without restart
+	for name, podUID := range sharedPodUIDs {
+		allocationInfo := dynamicPolicy.state.GetAllocationInfo(podUID, "c")
+		as.False(allocationInfo.GetAllocationResultNUMASet().Contains(1), name)
+
+		if name != "a" {
+			_, applied := cpusetManager.applied[name]
+			as.False(applied, name)
+			continue
+		}
+
+		as.Equal(machine.NewCPUSet(2), allocationInfo.GetAllocationResultNUMASet())
+		as.Equal("2", allocationInfo.Annotations[cpuconsts.CPUStateAnnotationKeyNUMAHint])
+		as.Equal(allocationInfo.AllocationResult.String(), cpusetManager.applied[name].String())
+	}
+	as.Equal(3, dynamicPolicy.state.GetSharedNUMAPools()[2].GetRequestedQuantity())
+
+	// only pod a is counted as migrated
+	for name, podUID := range sharedPodUIDs {
+		if name == "a" {
+			as.Equal(1, dynamicPolicy.getNUMAMigrations()[podUID].Count, name)
+		} else {
+			as.Equal(0, dynamicPolicy.getNUMAMigrations()[podUID].Count, name)
+		}
+	}
+}
+
+func TestZeroCPURequestNUMABindingHints(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	testName := "test"
+	testCases := []struct {
+		description   string
+		freeMemory    map[int]float64
+		expectedHints []*pluginapi.TopologyHint
+	}{
+		{
+			description: "NUMA with the most free memory is preferred",
+			freeMemory: map[int]float64{
+				0: 4 * 1024 * 1024 * 1024.,
+				1: 8 * 1024 * 1024 * 1024.,
+				2: 16 * 1024 * 1024 * 1024.,
+				3: 2 * 1024 * 1024 * 1024.,
+			},
+			expectedHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: false},
+				{Nodes: []uint64{1}, Preferred: false},
+				{Nodes: []uint64{2}, Preferred: true},
+				{Nodes: []uint64{3}, Preferred: false},
+			},
+		},
+		{
+			description: "all NUMAs are preferred if memory metrics are missing",
+			expectedHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: true},
+				{Nodes: []uint64{1}, Preferred: true},
+				{Nodes: []uint64{2}, Preferred: true},
+				{Nodes: []uint64{3}, Preferred: true},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestZeroCPURequestNUMABindingHints")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+
+			metricsFetcher := metric.NewFakeMetricsFetcher(metrics.DummyMetrics{}).(*metric.FakeMetricsFetcher)
+			for numaID, freeMemory := range tc.freeMemory {
+				metricsFetcher.SetNumaMetric(numaID, coreconsts.MetricMemFreeNuma, utilmetric.MetricData{Value: freeMemory})
+			}
+			dynamicPolicy.metaServer = &metaserver.MetaServer{
+				MetaAgent: &agent.MetaAgent{
+					PodFetcher:     &pod.PodFetcherStub{},
+					MetricsFetcher: metricsFetcher,
+				},
+			}
+
+			req := &pluginapi.ResourceRequest{
+				PodUid:         string(uuid.NewUUID()),
+				PodNamespace:   testName,
+				PodName:        testName,
+				ContainerName:  testName,
+				ContainerType:  pluginapi.ContainerType_MAIN,
+				ContainerIndex: 0,
+				ResourceName:   string(v1.ResourceCPU),
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceCPU): 0,
+				},
+				Labels: map[string]string{
+					consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+				},
+				Annotations: map[string]string{
+					consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelSharedCores,
+					consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+				},
+			}
+
+			resp, err := dynamicPolicy.GetTopologyHints(context.Background(), req)
+			as.Nil(err)
+			as.ElementsMatch(tc.expectedHints, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+		})
+	}
+}
+
+func TestGetProjectedTopologyHints(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestGetProjectedTopologyHints")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	getPreferredHints := func(hints map[string]*pluginapi.ListOfTopologyHints) []*pluginapi.TopologyHint {
+		preferred := []*pluginapi.TopologyHint{}
+		for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+			if hint.Preferred {
+				preferred = append(preferred, hint)
+			}
+		}
+		return preferred
+	}
+
+	newReq := func(podUID string, request float64, hint *pluginapi.TopologyHint) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			podUID:            podUID,
+			podName:           podUID,
+			qosLevel:          consts.PodAnnotationQoSLevelDedicatedCores,
+			memoryEnhancement: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+			quantity:          request,
+			hint:              hint,
+		})
+	}
+
+	// numa_exclusive dedicated_cores pods occupy every NUMA
+	podUIDs := make([]string, 0, 4)
+	for numaID := 0; numaID < 4; numaID++ {
+		podUID := string(uuid.NewUUID())
+		podUIDs = append(podUIDs, podUID)
+		_, err = dynamicPolicy.Allocate(context.Background(),
+			newReq(podUID, 2, &pluginapi.TopologyHint{Nodes: []uint64{uint64(numaID)}, Preferred: true}))
+		as.Nil(err)
+	}
+
+	candidatePodUID := string(uuid.NewUUID())
+	hints, err := dynamicPolicy.GetProjectedTopologyHints(newReq(candidatePodUID, 2, nil), nil)
+	as.Nil(err)
+	as.Len(hints[string(v1.ResourceCPU)].Hints, 0)
+
+	// the request fits once the pod on NUMA 2 is projected to be moved out
+	removeOp := &ProjectedOperation{Type: ProjectedOperationRemove, PodUID: podUIDs[2]}
+	candidateReq := newReq(candidatePodUID, 2, nil)
+	hints, err = dynamicPolicy.GetProjectedTopologyHints(candidateReq, []*ProjectedOperation{removeOp})
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{{Nodes: []uint64{2}, Preferred: true}}, getPreferredHints(hints))
+	// the request of the caller isn't mutated either
+	as.Equal(newReq(candidatePodUID, 2, nil).Annotations, candidateReq.Annotations)
+
+	// real state isn't mutated by projection
+	as.NotNil(dynamicPolicy.state.GetAllocationInfo(podUIDs[2], "test"))
+	as.False(dynamicPolicy.state.GetMachineState()[2].AllocatedCPUSet.IsEmpty())
+
+	// the NUMA is occupied again if another pod is projected to be moved in
+	movedIn := dynamicPolicy.state.GetAllocationInfo(podUIDs[2], "test").Clone()
+	movedIn.PodUid = string(uuid.NewUUID())
+	addOp := &ProjectedOperation{Type: ProjectedOperationAdd, AllocationInfo: movedIn}
+	hints, err = dynamicPolicy.GetProjectedTopologyHints(newReq(candidatePodUID, 2, nil), []*ProjectedOperation{removeOp, addOp})
+	as.Nil(err)
+	as.Len(hints[string(v1.ResourceCPU)].Hints, 0)
+
+	// the same projection is served by the debug handler
+	body, err := json.Marshal(&projectedHintsRequest{
+		Request:    newReq(candidatePodUID, 2, nil),
+		Operations: []*ProjectedOperation{removeOp},
+	})
+	as.Nil(err)
+	w := httptest.NewRecorder()
+	dynamicPolicy.serveProjectedHints(w, httptest.NewRequest(http.MethodPost, "/debug"+projectedHintsDebugPath, bytes.NewReader(body)))
+	as.Equal(http.StatusOK, w.Code)
+
+	exported := map[string]*pluginapi.ListOfTopologyHints{}
+	as.Nil(json.Unmarshal(w.Body.Bytes(), &exported))
+	as.Equal(getPreferredHints(hints), getPreferredHints(exported))
+
+	// invalid operations are rejected
+	_, err = dynamicPolicy.GetProjectedTopologyHints(newReq(candidatePodUID, 2, nil),
+		[]*ProjectedOperation{{Type: ProjectedOperationRemove}})
+	as.NotNil(err)
+}
+
+func TestNUMAHintFallbackOrder(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	// NUMA 0 and 1 have 1 reserved cpu each, so available quantities are 1, 2, 1, 2 respectively
+	requested := map[int]float64{0: 2, 1: 1, 2: 3, 3: 2}
+	sharedNUMAPools := make(state.SharedNUMAPools)
+	for numaID, quantity := range requested {
+		sharedNUMAPools.Set(&state.AllocationInfo{
+			PodUid:        string(uuid.NewUUID()),
+			ContainerName: "test",
+			QoSLevel:      consts.PodAnnotationQoSLevelSharedCores,
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:                  consts.PodAnnotationQoSLevelSharedCores,
+				consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+			},
+			TopologyAwareAssignments: map[int]machine.CPUSet{numaID: cpuTopology.CPUDetails.CPUsInNUMANodes(numaID)},
+			RequestQuantity:          quantity,
+		})
+	}
+
+	testCases := []struct {
+		description   string
+		fallbackOrder string
+		expectedHints []*pluginapi.TopologyHint
+	}{
+		{
+			description:   "no hint without fallback order",
+			fallbackOrder: cpuconsts.CPUNUMAHintFallbackOrderNone,
+			expectedHints: []*pluginapi.TopologyHint{},
+		},
+		{
+			description:   "non-preferred hints ordered by NUMA id",
+			fallbackOrder: cpuconsts.CPUNUMAHintFallbackOrderLowestNUMAID,
+			expectedHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}},
+				{Nodes: []uint64{1}},
+				{Nodes: []uint64{2}},
+				{Nodes: []uint64{3}},
+			},
+		},
+		{
+			description:   "non-preferred hints ordered by available quantity",
+			fallbackOrder: cpuconsts.CPUNUMAHintFallbackOrderMostAvailable,
+			expectedHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{1}},
+				{Nodes: []uint64{3}},
+				{Nodes: []uint64{0}},
+				{Nodes: []uint64{2}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestNUMAHintFallbackOrder")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.cpuNUMAHintFallbackOrder = tc.fallbackOrder
+
+			// no NUMA is eligible for the request with either policy
+			for _, preferPolicy := range []string{cpuconsts.CPUNUMAHintPreferPolicySpreading, cpuconsts.CPUNUMAHintPreferPolicyPacking} {
+				hints := map[string]*pluginapi.ListOfTopologyHints{
+					string(v1.ResourceCPU): {Hints: []*pluginapi.TopologyHint{}},
+				}
+				dynamicPolicy.populateHintsByPreferPolicy(context.Background(), []int{0, 1, 2, 3}, preferPolicy, hints,
+					sharedNUMAPools, 3, 1, nil)
+				as.Equal(tc.expectedHints, hints[string(v1.ResourceCPU)].Hints, preferPolicy)
+			}
+		})
+	}
+}
+
+type fakePodUpdater struct {
+	control.DummyPodUpdater
+	err     error
+	patched map[string]map[string]string
+}
+
+func (f *fakePodUpdater) PatchPod(_ context.Context, _, newPod *v1.Pod) error {
+	if f.err != nil {
+		return f.err
+	}
+
+	if f.patched == nil {
+		f.patched = make(map[string]map[string]string)
+	}
+	f.patched[string(newPod.UID)] = newPod.Annotations
+	return nil
+}
+
+func TestSyncPlacementAnnotations(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestSyncPlacementAnnotations")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.enablePlacementAnnotations = true
+	dynamicPolicy.cpuNUMAHintPreferPolicy = cpuconsts.CPUNUMAHintPreferPolicySpreading
+	podUpdater := &fakePodUpdater{err: fmt.Errorf("pods is forbidden")}
+	dynamicPolicy.podUpdater = podUpdater
+
+	testName := "test"
+	sharedPodUID, dedicatedPodUID, nonBindingPodUID := string(uuid.NewUUID()), string(uuid.NewUUID()), string(uuid.NewUUID())
+	pods := make([]*v1.Pod, 0, 3)
+	for _, podUID := range []string{sharedPodUID, dedicatedPodUID, nonBindingPodUID} {
+		pods = append(pods, &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        podUID,
+				Namespace:   testName,
+				UID:         types.UID(podUID),
+				Annotations: map[string]string{"existing": "true"},
+			},
+		})
+	}
+	dynamicPolicy.metaServer = &metaserver.MetaServer{
+		MetaAgent: &agent.MetaAgent{
+			PodFetcher: &pod.PodFetcherStub{PodList: pods},
+		},
+	}
+
+	generateReq := func(podUID, qosLevel, memoryEnhancement string, hint *pluginapi.TopologyHint) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			podUID:            podUID,
+			podName:           podUID,
+			qosLevel:          qosLevel,
+			memoryEnhancement: memoryEnhancement,
+			quantity:          2,
+			hint:              hint,
+		})
+	}
+
+	_, err = dynamicPolicy.Allocate(context.Background(), generateReq(sharedPodUID, consts.PodAnnotationQoSLevelSharedCores,
+		`{"numa_binding": "true"}`, &pluginapi.TopologyHint{Nodes: []uint64{1}, Preferred: true}))
+	as.Nil(err)
+	_, err = dynamicPolicy.Allocate(context.Background(), generateReq(dedicatedPodUID, consts.PodAnnotationQoSLevelDedicatedCores,
+		`{"numa_binding": "true", "numa_exclusive": "true"}`, &pluginapi.TopologyHint{Nodes: []uint64{2}, Preferred: true}))
+	as.Nil(err)
+	_, err = dynamicPolicy.Allocate(context.Background(), generateReq(nonBindingPodUID, consts.PodAnnotationQoSLevelSharedCores,
+		"", nil))
+	as.Nil(err)
+
+	// pods that can't be written are skipped without affecting others, and retried later
+	dynamicPolicy.syncPlacementAnnotations(nil, nil, nil, nil, nil)
+	as.Len(podUpdater.patched, 0)
+	as.Len(dynamicPolicy.patchedPlacements, 0)
+
+	podUpdater.err = nil
+	dynamicPolicy.syncPlacementAnnotations(nil, nil, nil, nil, nil)
+	as.Equal(map[string]map[string]string{
+		sharedPodUID: {
+			"existing":                                      "true",
+			cpuconsts.PodAnnotationCPUPlacementNUMAs:        "1",
+			cpuconsts.PodAnnotationCPUPlacementCPUSet:       dynamicPolicy.state.GetAllocationInfo(sharedPodUID, testName).AllocationResult.String(),
+			cpuconsts.PodAnnotationCPUPlacementPreferPolicy: cpuconsts.CPUNUMAHintPreferPolicySpreading,
+		},
+		dedicatedPodUID: {
+			"existing":                                "true",
+			cpuconsts.PodAnnotationCPUPlacementNUMAs:  "2",
+			cpuconsts.PodAnnotationCPUPlacementCPUSet: "4-5,12-13",
+		},
+	}, podUpdater.patched)
+
+	// pods already patched aren't patched again
+	podUpdater.patched = nil
+	dynamicPolicy.syncPlacementAnnotations(nil, nil, nil, nil, nil)
+	as.Len(podUpdater.patched, 0)
+}
+
+func TestShutdown(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestShutdown")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	testName := "test"
+	generateReq := func(podUID string, numaID uint64) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			podUID:            podUID,
+			qosLevel:          consts.PodAnnotationQoSLevelDedicatedCores,
+			memoryEnhancement: `{"numa_binding": "true"}`,
+			quantity:          2,
+			hint:              &pluginapi.TopologyHint{Nodes: []uint64{numaID}, Preferred: true},
+		})
+	}
+
+	_, err = dynamicPolicy.Allocate(context.Background(), generateReq(string(uuid.NewUUID()), 1))
+	as.Nil(err)
+	_, err = dynamicPolicy.Allocate(context.Background(), generateReq(string(uuid.NewUUID()), 2))
+	as.Nil(err)
+
+	// the checkpoint lags the in-memory state, e.g. the last write failed
+	as.Nil(os.Remove(filepath.Join(tmpDir, cpuPluginStateFileName)))
+
+	// shutdown in the middle of admissions, and it's idempotent
+	as.Nil(dynamicPolicy.Shutdown(time.Second))
+	as.Nil(dynamicPolicy.Shutdown(time.Second))
+
+	// new admissions are refused
+	podUID := string(uuid.NewUUID())
+	_, err = dynamicPolicy.GetTopologyHints(context.Background(), generateReq(podUID, 3))
+	as.Equal(errShuttingDown, err)
+	_, err = dynamicPolicy.Allocate(context.Background(), generateReq(podUID, 3))
+	as.Equal(errShuttingDown, err)
+	as.Nil(dynamicPolicy.state.GetAllocationInfo(podUID, testName))
+
+	// the checkpoint matches the in-memory state
+	restoredState, err := state.NewCheckpointState(tmpDir, cpuPluginStateFileName,
+		cpuconsts.CPUResourcePluginPolicyNameDynamic, cpuTopology, false)
+	as.Nil(err)
+	expectedPodEntries, err := json.Marshal(dynamicPolicy.state.GetPodEntries())
+	as.Nil(err)
+	restoredPodEntries, err := json.Marshal(restoredState.GetPodEntries())
+	as.Nil(err)
+	as.JSONEq(string(expectedPodEntries), string(restoredPodEntries))
+	expectedMachineState, err := json.Marshal(dynamicPolicy.state.GetMachineState())
+	as.Nil(err)
+	restoredMachineState, err := json.Marshal(restoredState.GetMachineState())
+	as.Nil(err)
+	as.JSONEq(string(expectedMachineState), string(restoredMachineState))
+
+	// flushing is bounded by the timeout if the policy lock is held by an in-flight admission
+	blockedPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	blockedPolicy.Lock()
+	as.NotNil(blockedPolicy.Shutdown(100 * time.Millisecond))
+	_, err = blockedPolicy.GetTopologyHints(context.Background(), generateReq(podUID, 3))
+	as.Equal(errShuttingDown, err)
+	blockedPolicy.Unlock()
+}
+
+func TestAllocateSpreadCores(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(32, 2, 2)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestAllocateSpreadCores")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	testName := "test"
+	allocate := func(numaID uint64, spread bool) machine.CPUSet {
+		podUID := string(uuid.NewUUID())
+		annotations := map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+		}
+		if spread {
+			annotations[consts.PodAnnotationCPUEnhancementKey] = fmt.Sprintf(`{"%s": "%s"}`,
+				cpuconsts.PodAnnotationCPUSpreadCores, cpuconsts.PodAnnotationCPUSpreadCoresEnable)
+		}
+
+		_, err := dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+			PodUid:         podUID,
+			PodNamespace:   testName,
+			PodName:        testName,
+			ContainerName:  testName,
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			Hint:           &pluginapi.TopologyHint{Nodes: []uint64{numaID}, Preferred: true},
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): 4,
+			},
+			Annotations: annotations,
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		})
+		as.Nil(err)
+
+		allocationInfo := dynamicPolicy.state.GetAllocationInfo(podUID, testName)
+		as.NotNil(allocationInfo)
+		as.Equal(4, allocationInfo.AllocationResult.Size())
+		as.True(allocationInfo.AllocationResult.IsSubsetOf(cpuTopology.CPUDetails.CPUsInNUMANodes(int(numaID))))
+		return allocationInfo.AllocationResult
+	}
+	coresOf := func(cpus machine.CPUSet) machine.CPUSet {
+		return cpuTopology.CPUDetails.KeepOnly(cpus).Cores()
+	}
+
+	// packed allocation takes whole cores, while spread allocation takes one cpu per core
+	packed := allocate(0, false)
+	as.Equal(2, coresOf(packed).Size())
+	spread := allocate(1, true)
+	as.Equal(4, coresOf(spread).Size())
+}
+
+func TestCoreClassTargetedHints(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	// each NUMA has a performance core and an efficiency core, and the performance core of NUMA 0 is reserved
+	cpuTopology, err := machine.GenerateDummyHybridCPUTopology(16, 2, 4, 1)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestCoreClassTargetedHints")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	testName := "test"
+	newRequest := func(podUID string, quantity float64, coreClass string, hint *pluginapi.TopologyHint) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			podUID:            podUID,
+			qosLevel:          consts.PodAnnotationQoSLevelDedicatedCores,
+			memoryEnhancement: `{"numa_binding": "true"}`,
+			cpuEnhancement:    fmt.Sprintf(`{"%s": "%s"}`, cpuconsts.PodAnnotationCPUCoreClass, coreClass),
+			quantity:          quantity,
+			hint:              hint,
+		})
+	}
+	getHints := func(quantity float64, coreClass string) []*pluginapi.TopologyHint {
+		resp, err := dynamicPolicy.GetTopologyHints(context.Background(),
+			newRequest(string(uuid.NewUUID()), quantity, coreClass, nil))
+		as.Nil(err)
+		return resp.ResourceHints[string(v1.ResourceCPU)].Hints
+	}
+
+	// NUMA 0 has no available performance cpu
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{1}, Preferred: true},
+		{Nodes: []uint64{2}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: true},
+	}, getHints(2, string(machine.CoreClassPerformance)))
+
+	podUID := string(uuid.NewUUID())
+	_, err = dynamicPolicy.Allocate(context.Background(), newRequest(podUID, 2, string(machine.CoreClassPerformance),
+		&pluginapi.TopologyHint{Nodes: []uint64{1}, Preferred: true}))
+	as.Nil(err)
+	as.Equal(machine.NewCPUSet(2, 10), dynamicPolicy.state.GetAllocationInfo(podUID, testName).AllocationResult)
+
+	// performance cpus of NUMA 1 are taken up, while its efficiency cpus are still available
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{2}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: true},
+	}, getHints(2, string(machine.CoreClassPerformance)))
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{0}, Preferred: true},
+		{Nodes: []uint64{1}, Preferred: true},
+		{Nodes: []uint64{2}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: true},
+	}, getHints(2, string(machine.CoreClassEfficiency)))
+
+	// no NUMA has enough cpus of the class, though NUMAs 2 and 3 have enough cpus in total
+	as.Len(getHints(3, string(machine.CoreClassPerformance)), 0)
+
+	_, err = dynamicPolicy.GetTopologyHints(context.Background(),
+		newRequest(string(uuid.NewUUID()), 2, "turbo", nil))
+	as.NotNil(err)
+}
+
+func TestL3CachePackingAllocation(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	// each NUMA has 8 cores divided into 2 L3 cache domains, i.e. cores 0-3 share L3 cache 0 and cores 4-7
+	// share L3 cache 4 in NUMA 0, and core 0 (cpus 0 and 16) is reserved
+	cpuTopology, err := machine.GenerateDummyL3CacheCPUTopology(32, 2, 2, 2)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestL3CachePackingAllocation")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	testName := "test"
+	allocate := func(quantity float64) machine.CPUSet {
+		podUID := string(uuid.NewUUID())
+		_, err := dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+			PodUid:         podUID,
+			PodNamespace:   testName,
+			PodName:        testName,
+			ContainerName:  testName,
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			Hint:           &pluginapi.TopologyHint{Nodes: []uint64{0}, Preferred: true},
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): quantity,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+				consts.PodAnnotationCPUEnhancementKey: fmt.Sprintf(`{"%s": "%s"}`,
+					cpuconsts.PodAnnotationCPUL3CachePacking, cpuconsts.PodAnnotationCPUL3CachePackingEnable),
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		})
+		as.Nil(err)
+
+		result := dynamicPolicy.state.GetAllocationInfo(podUID, testName).AllocationResult
+		as.Equal(int(quantity), result.Size())
+		return result
+	}
+
+	// cache domain 0 with 6 available cpus is the one with the fewest cpus fitting the request
+	as.True(allocate(4).IsSubsetOf(cpuTopology.CPUDetails.CPUsInL3CacheDomains(0)))
+	// cache domain 0 has only 2 cpus left, so cpus are taken from cache domain 4 rather than both of them
+	as.True(allocate(4).IsSubsetOf(cpuTopology.CPUDetails.CPUsInL3CacheDomains(4)))
+	// the rest of cache domain 0 is taken to keep more cpus sharing cache domain 4 available
+	as.True(allocate(2).IsSubsetOf(cpuTopology.CPUDetails.CPUsInL3CacheDomains(0)))
+}
+
+type syncBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+// TestAdmissionCorrelationIDInHintLogs captures klog output, so it mustn't run in parallel
+func TestAdmissionCorrelationIDInHintLogs(t *testing.T) {
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestAdmissionCorrelationIDInHintLogs")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.cpuNUMAHintPreferPolicy = cpuconsts.CPUNUMAHintPreferPolicyDynamicPacking
+	dynamicPolicy.cpuNUMAHintPreferLowThreshold = 0.5
+
+	output := &syncBuffer{}
+	klog.LogToStderr(false)
+	klog.SetOutput(output)
+	defer func() {
+		klog.Flush()
+		klog.LogToStderr(true)
+	}()
+
+	testName := "test"
+	podUID := string(uuid.NewUUID())
+	req := &pluginapi.ResourceRequest{
+		PodUid:         podUID,
+		PodNamespace:   testName,
+		PodName:        testName,
+		ContainerName:  testName,
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 2,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelSharedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+		},
+	}
+	_, err = dynamicPolicy.GetTopologyHints(context.Background(), req)
+	as.Nil(err)
+	klog.Flush()
+
+	correlationID := getAdmissionCorrelationID(req)
+	as.Equal(fmt.Sprintf("cid=%s/%s", podUID, testName), correlationID)
+
+	var hintLines []string
+	for _, line := range strings.Split(output.String(), "\n") {
+		if strings.Contains(line, "GetTopologyHints") || strings.Contains(line, "NUMABindingSharedCores") ||
+			strings.Contains(line, "filterNUMANodes") {
+			hintLines = append(hintLines, line)
+		}
+	}
+	// logs from the entry, the NUMA filter and the hints population all carry the same correlation id
+	as.NotEmpty(hintLines)
+	for _, line := range hintLines {
+		as.Contains(line, correlationID+": ")
+	}
+	as.Contains(output.String(), "filterNUMANodesByHintPreferLowThreshold")
+	as.Contains(output.String(), "filterNUMANodesForNUMABindingSharedCores")
+	as.Contains(output.String(), "calculateHintsForNUMABindingSharedCores")
+}
+
+func TestCalculateHintsWithNUMAMinFreeCPUs(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name            string
+		qosLevel        string
+		numaMinFreeCPUs int
+		reqInt          int
+		wantNUMAs       []uint64
+	}{
+		{
+			name:            "dedicated_cores fits NUMAs exactly without min free cpus",
+			qosLevel:        consts.PodAnnotationQoSLevelDedicatedCores,
+			numaMinFreeCPUs: 0,
+			reqInt:          4,
+			wantNUMAs:       []uint64{1, 2, 3},
+		},
+		{
+			name:            "dedicated_cores blocked by min free cpus",
+			qosLevel:        consts.PodAnnotationQoSLevelDedicatedCores,
+			numaMinFreeCPUs: 1,
+			reqInt:          4,
+			wantNUMAs:       nil,
+		},
+		{
+			name:            "dedicated_cores fits NUMAs with min free cpus kept",
+			qosLevel:        consts.PodAnnotationQoSLevelDedicatedCores,
+			numaMinFreeCPUs: 1,
+			reqInt:          3,
+			wantNUMAs:       []uint64{1, 2, 3},
+		},
+		{
+			name:            "shared_cores fits NUMAs exactly without min free cpus",
+			qosLevel:        consts.PodAnnotationQoSLevelSharedCores,
+			numaMinFreeCPUs: 0,
+			reqInt:          4,
+			wantNUMAs:       []uint64{1, 2, 3},
+		},
+		{
+			name:            "shared_cores blocked by min free cpus",
+			qosLevel:        consts.PodAnnotationQoSLevelSharedCores,
+			numaMinFreeCPUs: 1,
+			reqInt:          4,
+			wantNUMAs:       nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			tmpDir, err := ioutil.TempDir("", "checkpoint-TestCalculateHintsWithNUMAMinFreeCPUs")
+			as.Nil(err)
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+			as.Nil(err)
+			dynamicPolicy.numaMinFreeCPUs = tc.numaMinFreeCPUs
+
+			annotations := map[string]string{
+				consts.PodAnnotationQoSLevelKey:                  tc.qosLevel,
+				consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+			}
+
+			var hints map[string]*pluginapi.ListOfTopologyHints
+			if tc.qosLevel == consts.PodAnnotationQoSLevelDedicatedCores {
+				hints, err = dynamicPolicy.calculateHints(tc.reqInt, dynamicPolicy.state.GetMachineState(),
+					annotations, machine.NewCPUSet())
+			} else {
+				hints, err = dynamicPolicy.calculateHintsForNUMABindingSharedCores(context.Background(), tc.reqInt,
+					dynamicPolicy.state.GetPodEntries(), dynamicPolicy.state.GetMachineState(),
+					dynamicPolicy.state.GetSharedNUMAPools(), annotations, machine.NewCPUSet(), nil)
+			}
+			as.Nil(err)
+
+			var numaIDs []uint64
+			for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+				as.Len(hint.Nodes, 1)
+				numaIDs = append(numaIDs, hint.Nodes[0])
+			}
+			as.ElementsMatch(tc.wantNUMAs, numaIDs)
+		})
+	}
+}
+
+func TestGetTopologyHintsDedupeHints(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestGetTopologyHintsDedupeHints")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	// the dedicated_cores path generates hints from the state, and then appends a fallback hint duplicating one of them
+	dedicatedHintHandler := dynamicPolicy.hintHandlers[consts.PodAnnotationQoSLevelDedicatedCores]
+	dynamicPolicy.hintHandlers[consts.PodAnnotationQoSLevelDedicatedCores] = func(ctx context.Context,
+		req *pluginapi.ResourceRequest,
+	) (*pluginapi.ResourceHintsResponse, error) {
+		resp, err := dedicatedHintHandler(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		cpuHints := resp.ResourceHints[string(v1.ResourceCPU)]
+		for _, hint := range cpuHints.Hints {
+			cpuHints.Hints = append(cpuHints.Hints, &pluginapi.TopologyHint{
+				Nodes:     append([]uint64{}, hint.Nodes...),
+				Preferred: !hint.Preferred,
+			})
+		}
+		return resp, nil
+	}
+
+	testName := "test"
+	resp, err := dynamicPolicy.GetTopologyHints(context.Background(), &pluginapi.ResourceRequest{
+		PodUid:         string(uuid.NewUUID()),
+		PodNamespace:   testName,
+		PodName:        testName,
+		ContainerName:  testName,
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 4,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	})
+	as.Nil(err)
+
+	// a single hint remains for each NUMA, and it's preferred since one of the duplicates is preferred
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{1}, Preferred: true},
+		{Nodes: []uint64{2}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: true},
+	}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+}
+
+type fakeHintsAuditSink struct {
+	mutex   sync.Mutex
+	records []*HintsAuditRecord
+}
+
+func (f *fakeHintsAuditSink) Record(record *HintsAuditRecord) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.records = append(f.records, record)
+	return nil
+}
+
+func TestGetTopologyHintsAudit(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestGetTopologyHintsAudit")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	auditSink := &fakeHintsAuditSink{}
+	dynamicPolicy.hintsAuditSink = auditSink
+
+	generateReq := func(podUID, qosLevel string, reqQuantity float64) *pluginapi.ResourceRequest {
+		return newTestRequest(testRequest{
+			podUID:            podUID,
+			qosLevel:          qosLevel,
+			memoryEnhancement: `{"numa_binding": "true"}`,
+			quantity:          reqQuantity,
+		})
+	}
+
+	hintedPodUID := string(uuid.NewUUID())
+	_, err = dynamicPolicy.GetTopologyHints(context.Background(),
+		generateReq(hintedPodUID, consts.PodAnnotationQoSLevelDedicatedCores, 4))
+	as.Nil(err)
+
+	failedPodUID := string(uuid.NewUUID())
+	_, err = dynamicPolicy.GetTopologyHints(context.Background(),
+		generateReq(failedPodUID, consts.PodAnnotationQoSLevelSharedCores, 8))
+	as.NotNil(err)
+
+	as.Len(auditSink.records, 2)
+
+	hintedRecord := auditSink.records[0]
+	as.Equal(hintedPodUID, hintedRecord.PodUID)
+	as.Equal(consts.PodAnnotationQoSLevelDedicatedCores, hintedRecord.QoSLevel)
+	as.Equal(float64(4), hintedRecord.Request)
+	as.Equal([]int{1, 2, 3}, hintedRecord.CandidateNUMAs)
+	as.Len(hintedRecord.Hints, 3)
+	as.Len(hintedRecord.PreferredHints, 3)
+	as.Equal(hintsAuditReasonHinted, hintedRecord.Reason)
+
+	failedRecord := auditSink.records[1]
+	as.Equal(failedPodUID, failedRecord.PodUID)
+	as.Empty(failedRecord.CandidateNUMAs)
+	as.Empty(failedRecord.Hints)
+	as.Equal(err.Error(), failedRecord.Reason)
+}
+
+func TestFileHintsAuditSink(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	tmpDir, err := ioutil.TempDir("", "TestFileHintsAuditSink")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	sink := newFileHintsAuditSink(filepath.Join(tmpDir, "audit.log"))
+	records := []*HintsAuditRecord{
+		{
+			PodUID:         "pod-1",
+			QoSLevel:       consts.PodAnnotationQoSLevelDedicatedCores,
+			Request:        2,
+			CandidateNUMAs: []int{0, 1},
+			Hints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: true},
+				{Nodes: []uint64{1}, Preferred: false},
+			},
+			PreferredHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: true},
+			},
+			Reason: hintsAuditReasonHinted,
+		},
+		{
+			PodUID:         "pod-2",
+			QoSLevel:       consts.PodAnnotationQoSLevelSharedCores,
+			Request:        1,
+			CandidateNUMAs: []int{},
+			Hints:          []*pluginapi.TopologyHint{},
+			PreferredHints: []*pluginapi.TopologyHint{},
+			Reason:         hintsAuditReasonNoFit,
+		},
+	}
+	for _, record := range records {
+		as.Nil(sink.Record(record))
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(tmpDir, "audit.log"))
+	as.Nil(err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	as.Len(lines, len(records))
+	for i, line := range lines {
+		record := &HintsAuditRecord{}
+		as.Nil(json.Unmarshal([]byte(line), record))
+		as.Equal(records[i], record)
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+// previousNUMARecord is the NUMAs that a container was allocated on before its allocation was cleared
+type previousNUMARecord struct {
+	numaNodes  machine.CPUSet
+	recordTime time.Time
+}
+
+func (p *DynamicPolicy) isPreviousNUMAStickyEnabled() bool {
+	return p.previousNUMAStickyTTL > 0
+}
+
+// recordPreviousNUMAs records NUMAs of the allocation to be cleared, the existing record of the container
+// is kept as it is, so that the TTL counts from the first time its allocation is cleared rather than
+// the last time its hints are calculated.
+func (p *DynamicPolicy) recordPreviousNUMAs(allocationInfo *state.AllocationInfo) {
+	if !p.isPreviousNUMAStickyEnabled() || allocationInfo == nil {
+		return
+	}
+
+	numaNodes := allocationInfo.GetAllocationResultNUMASet()
+	if numaNodes.IsEmpty() {
+		return
+	}
+
+	p.previousNUMAsMutex.Lock()
+	defer p.previousNUMAsMutex.Unlock()
+
+	if p.previousNUMAs == nil {
+		p.previousNUMAs = make(map[string]map[string]*previousNUMARecord)
+	}
+
+	if p.previousNUMAs[allocationInfo.PodUid] == nil {
+		p.previousNUMAs[allocationInfo.PodUid] = make(map[string]*previousNUMARecord)
+	} else if p.previousNUMAs[allocationInfo.PodUid][allocationInfo.ContainerName] != nil {
+		return
+	}
+
+	general.Infof("pod: %s/%s, container: %s record previous NUMAs: %s",
+		allocationInfo.PodNamespace, allocationInfo.PodName, allocationInfo.ContainerName, numaNodes.String())
+	p.previousNUMAs[allocationInfo.PodUid][allocationInfo.ContainerName] = &previousNUMARecord{
+		numaNodes:  numaNodes,
+		recordTime: time.Now(),
+	}
+}
+
+// getPreviousNUMAs returns NUMAs recorded for the container within previousNUMAStickyTTL
+func (p *DynamicPolicy) getPreviousNUMAs(podUID, containerName string) (machine.CPUSet, bool) {
+	if !p.isPreviousNUMAStickyEnabled() {
+		return machine.NewCPUSet(), false
+	}
+
+	p.previousNUMAsMutex.Lock()
+	defer p.previousNUMAsMutex.Unlock()
+
+	record := p.previousNUMAs[podUID][containerName]
+	if record == nil || time.Since(record.recordTime) >= p.previousNUMAStickyTTL {
+		return machine.NewCPUSet(), false
+	}
+	return record.numaNodes.Clone(), true
+}
+
+// clearPreviousNUMAs drops the record of the container once it's allocated again
+func (p *DynamicPolicy) clearPreviousNUMAs(podUID, containerName string) {
+	p.previousNUMAsMutex.Lock()
+	defer p.previousNUMAsMutex.Unlock()
+
+	delete(p.previousNUMAs[podUID], containerName)
+	if len(p.previousNUMAs[podUID]) == 0 {
+		delete(p.previousNUMAs, podUID)
+	}
+}
+
+// clearPodPreviousNUMAs drops records of all containers in the pod when it's removed,
+// since containers won't restart in a removed pod
+func (p *DynamicPolicy) clearPodPreviousNUMAs(podUID string) {
+	p.previousNUMAsMutex.Lock()
+	defer p.previousNUMAsMutex.Unlock()
+
+	delete(p.previousNUMAs, podUID)
+}
+
+// preferPreviousNUMAs marks the hint of NUMAs that the container was allocated on before as the only
+// preferred one to keep its cache warm, if the hint is still preferred; otherwise hints are kept as they are.
+func (p *DynamicPolicy) preferPreviousNUMAs(ctx context.Context, req *pluginapi.ResourceRequest,
+	hints map[string]*pluginapi.ListOfTopologyHints,
+) {
+	logger := general.LoggerFromContext(ctx)
+	if hints[string(v1.ResourceCPU)] == nil || !hasPreferredHint(hints[string(v1.ResourceCPU)].Hints) {
+		return
+	} else if _, ok := p.getPreferredNUMAOverride(ctx, req.Annotations); ok {
+		return
+	}
+
+	previousNUMAs, ok := p.getPreviousNUMAs(req.PodUid, req.ContainerName)
+	if !ok {
+		return
+	}
+
+	var previousHint *pluginapi.TopologyHint
+	for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+		if hint.Preferred && machine.NewCPUSet(util.HintToIntArray(hint)...).Equals(previousNUMAs) {
+			previousHint = hint
+			break
+		}
+	}
+
+	if previousHint == nil {
+		logger.Infof("pod: %s/%s, container: %s previous NUMAs: %s aren't preferred any more",
+			req.PodNamespace, req.PodName, req.ContainerName, previousNUMAs.String())
+		return
+	}
+
+	logger.Infof("pod: %s/%s, container: %s prefer previous NUMAs: %s",
+		req.PodNamespace, req.PodName, req.ContainerName, previousNUMAs.String())
+	for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+		hint.Preferred = hint == previousHint
+	}
+}
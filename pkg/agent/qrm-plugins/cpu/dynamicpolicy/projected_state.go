@@ -0,0 +1,210 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	v1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+)
+
+// ProjectedOperationType is the type of planned change on allocation state
+type ProjectedOperationType string
+
+const (
+	ProjectedOperationAdd    ProjectedOperationType = "add"
+	ProjectedOperationRemove ProjectedOperationType = "remove"
+)
+
+// ProjectedOperation is a planned change on allocation state, e.g. a pod moved in or out by descheduler
+type ProjectedOperation struct {
+	Type ProjectedOperationType `json:"type"`
+	// PodUID and ContainerName identify the container to remove,
+	// all containers of the pod are removed if ContainerName is empty
+	PodUID        string `json:"podUID,omitempty"`
+	ContainerName string `json:"containerName,omitempty"`
+	// AllocationInfo is the projected allocation of the container to add
+	AllocationInfo *state.AllocationInfo `json:"allocationInfo,omitempty"`
+}
+
+// applyProjectedOperations applies operations on podEntries in order, podEntries is
+// modified in place so callers should pass a copy rather than entries of real state.
+func applyProjectedOperations(podEntries state.PodEntries, operations []*ProjectedOperation) (state.PodEntries, error) {
+	if podEntries == nil {
+		podEntries = make(state.PodEntries)
+	}
+
+	for i, op := range operations {
+		if op == nil {
+			return nil, fmt.Errorf("projected operation: %d is nil", i)
+		}
+
+		switch op.Type {
+		case ProjectedOperationAdd:
+			ai := op.AllocationInfo
+			if ai == nil || ai.PodUid == "" || ai.ContainerName == "" {
+				return nil, fmt.Errorf("projected operation: %d adds invalid allocation: %+v", i, ai)
+			}
+
+			if podEntries[ai.PodUid] == nil {
+				podEntries[ai.PodUid] = make(state.ContainerEntries)
+			}
+			podEntries[ai.PodUid][ai.ContainerName] = ai.Clone()
+		case ProjectedOperationRemove:
+			if op.PodUID == "" {
+				return nil, fmt.Errorf("projected operation: %d removes container with empty pod uid", i)
+			}
+
+			if op.ContainerName == "" {
+				delete(podEntries, op.PodUID)
+				continue
+			}
+
+			delete(podEntries[op.PodUID], op.ContainerName)
+			if len(podEntries[op.PodUID]) == 0 {
+				delete(podEntries, op.PodUID)
+			}
+		default:
+			return nil, fmt.Errorf("projected operation: %d has unknown type: %s", i, op.Type)
+		}
+	}
+
+	return podEntries, nil
+}
+
+// projectedState is a read-only view of allocation state projected by planned operations,
+// it's injected into hint handlers by context in place of the real state.
+type projectedState struct {
+	machineState    state.NUMANodeMap
+	podEntries      state.PodEntries
+	sharedNUMAPools state.SharedNUMAPools
+}
+
+var _ state.ReadonlyState = &projectedState{}
+
+func newProjectedState(podEntries state.PodEntries, machineState state.NUMANodeMap) *projectedState {
+	return &projectedState{
+		machineState:    machineState,
+		podEntries:      podEntries,
+		sharedNUMAPools: state.NewSharedNUMAPools(podEntries),
+	}
+}
+
+func (s *projectedState) GetMachineState() state.NUMANodeMap {
+	return s.machineState.Clone()
+}
+
+func (s *projectedState) GetPodEntries() state.PodEntries {
+	return s.podEntries.Clone()
+}
+
+func (s *projectedState) GetAllocationInfo(podUID string, containerName string) *state.AllocationInfo {
+	return s.podEntries[podUID][containerName].Clone()
+}
+
+func (s *projectedState) GetSharedNUMAPools() state.SharedNUMAPools {
+	return s.sharedNUMAPools.ShallowClone()
+}
+
+type hintStateContextKey struct{}
+
+// withProjectedHintState returns a context carrying the given projected state for hint handlers
+func withProjectedHintState(ctx context.Context, projected *projectedState) context.Context {
+	return context.WithValue(ctx, hintStateContextKey{}, projected)
+}
+
+// getProjectedHintState returns the projected state carried by the context, if any
+func getProjectedHintState(ctx context.Context) (*projectedState, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+
+	projected, ok := ctx.Value(hintStateContextKey{}).(*projectedState)
+	return projected, ok && projected != nil
+}
+
+// getHintState returns the state that hint handlers should calculate hints against,
+// i.e. the projected state carried by the context if any, otherwise the real state.
+func (p *DynamicPolicy) getHintState(ctx context.Context) state.ReadonlyState {
+	if projected, ok := getProjectedHintState(ctx); ok {
+		return projected
+	}
+	return p.state
+}
+
+// GetProjectedTopologyHints runs hints calculation for the request against the machine state projected
+// by applying operations on current pod entries, without mutating real state or the request; it's used by
+// descheduler to check whether a pod fits after planned moves. Allocation of the requesting container itself
+// in current state is counted like others, callers should remove it explicitly if it's going to be moved.
+func (p *DynamicPolicy) GetProjectedTopologyHints(req *pluginapi.ResourceRequest,
+	operations []*ProjectedOperation,
+) (map[string]*pluginapi.ListOfTopologyHints, error) {
+	if req == nil {
+		return nil, fmt.Errorf("GetProjectedTopologyHints got nil req")
+	}
+
+	// the request is normalized in hints calculation, so work on a copy to keep the caller's one unchanged
+	req = proto.Clone(req).(*pluginapi.ResourceRequest)
+
+	qosLevel, err := p.getKatalystQoSLevelFromResourceReq(req)
+	if err != nil {
+		return nil, fmt.Errorf("GetKatalystQoSLevelFromResourceReq for pod: %s/%s, container: %s failed with error: %v",
+			req.PodNamespace, req.PodName, req.ContainerName, err)
+	}
+
+	err = normalizeEnhancementAnnotations(req.Annotations)
+	if err != nil {
+		return nil, fmt.Errorf("normalizeEnhancementAnnotations for pod: %s/%s, container: %s failed with error: %v",
+			req.PodNamespace, req.PodName, req.ContainerName, err)
+	}
+
+	if req.ContainerType == pluginapi.ContainerType_INIT {
+		return map[string]*pluginapi.ListOfTopologyHints{
+			string(v1.ResourceCPU): nil, // indicates that there is no numa preference
+		}, nil
+	}
+
+	p.RLock()
+	defer p.RUnlock()
+
+	if p.hintHandlers[qosLevel] == nil {
+		return nil, fmt.Errorf("katalyst QoS level: %s is not supported yet", qosLevel)
+	}
+
+	// pod entries got from state is a copy, so it's safe to apply operations on it
+	podEntries, err := applyProjectedOperations(p.state.GetPodEntries(), operations)
+	if err != nil {
+		return nil, fmt.Errorf("applyProjectedOperations failed with error: %v", err)
+	}
+
+	machineState, err := p.generateMachineStateWithRetry(podEntries)
+	if err != nil {
+		return nil, fmt.Errorf("GenerateMachineStateFromPodEntries failed with error: %v", err)
+	}
+
+	ctx := withProjectedHintState(context.Background(), newProjectedState(podEntries, machineState))
+	resp, err := p.hintHandlers[qosLevel](ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.ResourceHints, nil
+}
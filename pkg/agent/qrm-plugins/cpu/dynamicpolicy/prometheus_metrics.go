@@ -0,0 +1,238 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+)
+
+// prometheusMetricsDebugPath is the path (under debug prefix of generic endpoint)
+// to scrape metrics of cpu plugin in prometheus exposition format
+const prometheusMetricsDebugPath = "/qrm/cpu/metrics"
+
+// prometheusRawMetricsExpiration is the duration that raw metrics (which may have high dimension tags
+// like pod names) are kept after their last update, so that memory isn't leaked by series no longer emitted
+const prometheusRawMetricsExpiration = 5 * time.Minute
+
+// prometheusSeries is a single series of a metric, identified by its labels
+type prometheusSeries struct {
+	labels     map[string]string
+	value      float64
+	lastUpdate time.Time
+}
+
+// prometheusMetricFamily is all series of a metric with the same emit type
+type prometheusMetricFamily struct {
+	emitType metrics.MetricTypeName
+	series   map[string]*prometheusSeries // keyed by sorted labels
+}
+
+// prometheusMetricsEmitter forwards metrics to the wrapped emitter and keeps their values in a prometheus
+// registry as well, so that the same values can be scraped; raw metrics are exposed as gauges, count as
+// counters and up down count as gauges accumulated by the values stored.
+type prometheusMetricsEmitter struct {
+	metrics.MetricEmitter
+
+	mutex    sync.Mutex
+	families map[string]*prometheusMetricFamily // keyed by metric name
+
+	registry *prometheus.Registry
+}
+
+var _ metrics.MetricEmitter = &prometheusMetricsEmitter{}
+
+var _ prometheus.Collector = &prometheusMetricsEmitter{}
+
+func newPrometheusMetricsEmitter(emitter metrics.MetricEmitter) *prometheusMetricsEmitter {
+	p := &prometheusMetricsEmitter{
+		MetricEmitter: emitter,
+		families:      make(map[string]*prometheusMetricFamily),
+		registry:      prometheus.NewRegistry(),
+	}
+	p.registry.MustRegister(p)
+	return p
+}
+
+func (p *prometheusMetricsEmitter) StoreInt64(key string, val int64, emitType metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+	p.store(key, float64(val), emitType, tags)
+	return p.MetricEmitter.StoreInt64(key, val, emitType, tags...)
+}
+
+func (p *prometheusMetricsEmitter) StoreFloat64(key string, val float64, emitType metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+	p.store(key, val, emitType, tags)
+	return p.MetricEmitter.StoreFloat64(key, val, emitType, tags...)
+}
+
+// WithTags wraps the emitter itself rather than the underlying one, so that common tags are recorded as labels
+func (p *prometheusMetricsEmitter) WithTags(unit string, commonTags ...metrics.MetricTag) metrics.MetricEmitter {
+	newMetricTagWrapper := &metrics.MetricTagWrapper{MetricEmitter: p}
+	return newMetricTagWrapper.WithTags(unit, commonTags...)
+}
+
+func (p *prometheusMetricsEmitter) Run(ctx context.Context) {
+	p.MetricEmitter.Run(ctx)
+}
+
+// Handler returns the http handler to scrape metrics in prometheus exposition format
+func (p *prometheusMetricsEmitter) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
+
+func (p *prometheusMetricsEmitter) store(key string, val float64, emitType metrics.MetricTypeName, tags []metrics.MetricTag) {
+	switch emitType {
+	case metrics.MetricTypeNameRaw, metrics.MetricTypeNameCount, metrics.MetricTypeNameUpDownCount:
+	default:
+		return
+	}
+
+	name := sanitizePrometheusName(cpuconsts.CPUPluginDynamicPolicyName + "_" + key)
+	labels := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		labels[sanitizePrometheusName(tag.Key)] = tag.Val
+	}
+	seriesKey := getPrometheusSeriesKey(labels)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	family, ok := p.families[name]
+	if !ok {
+		family = &prometheusMetricFamily{
+			emitType: emitType,
+			series:   make(map[string]*prometheusSeries),
+		}
+		p.families[name] = family
+	} else if family.emitType != emitType {
+		// a metric name can't be exposed as both gauge and counter
+		return
+	}
+
+	series, ok := family.series[seriesKey]
+	if !ok {
+		series = &prometheusSeries{labels: labels}
+		family.series[seriesKey] = series
+	}
+
+	switch emitType {
+	case metrics.MetricTypeNameRaw:
+		series.value = val
+	case metrics.MetricTypeNameCount:
+		// counters are monotonic
+		if val > 0 {
+			series.value += val
+		}
+	case metrics.MetricTypeNameUpDownCount:
+		series.value += val
+	}
+	series.lastUpdate = time.Now()
+}
+
+// Describe sends nothing, since metrics are only known after they're stored,
+// which makes the emitter an unchecked collector.
+func (p *prometheusMetricsEmitter) Describe(chan<- *prometheus.Desc) {}
+
+// Collect sends all series stored, series of the same metric are exposed with the union of their label names
+// (missing labels are empty) to keep labels consistent; expired raw series are dropped.
+func (p *prometheusMetricsEmitter) Collect(ch chan<- prometheus.Metric) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	for name, family := range p.families {
+		labelNameSet := make(map[string]bool)
+		for seriesKey, series := range family.series {
+			if family.emitType == metrics.MetricTypeNameRaw && now.Sub(series.lastUpdate) > prometheusRawMetricsExpiration {
+				delete(family.series, seriesKey)
+				continue
+			}
+
+			for labelName := range series.labels {
+				labelNameSet[labelName] = true
+			}
+		}
+
+		if len(family.series) == 0 {
+			delete(p.families, name)
+			continue
+		}
+
+		labelNames := make([]string, 0, len(labelNameSet))
+		for labelName := range labelNameSet {
+			labelNames = append(labelNames, labelName)
+		}
+		sort.Strings(labelNames)
+
+		valueType := prometheus.GaugeValue
+		if family.emitType == metrics.MetricTypeNameCount {
+			valueType = prometheus.CounterValue
+		}
+
+		desc := prometheus.NewDesc(name, fmt.Sprintf("%s metric %s of cpu plugin", family.emitType, name), labelNames, nil)
+		for _, series := range family.series {
+			labelValues := make([]string, 0, len(labelNames))
+			for _, labelName := range labelNames {
+				labelValues = append(labelValues, series.labels[labelName])
+			}
+
+			metric, err := prometheus.NewConstMetric(desc, valueType, series.value, labelValues...)
+			if err != nil {
+				continue
+			}
+			ch <- metric
+		}
+	}
+}
+
+// getPrometheusSeriesKey returns the identity of labels regardless of their order
+func getPrometheusSeriesKey(labels map[string]string) string {
+	labelNames := make([]string, 0, len(labels))
+	for labelName := range labels {
+		labelNames = append(labelNames, labelName)
+	}
+	sort.Strings(labelNames)
+
+	var sb strings.Builder
+	for _, labelName := range labelNames {
+		sb.WriteString(labelName)
+		sb.WriteByte('=')
+		sb.WriteString(labels[labelName])
+		sb.WriteByte(0)
+	}
+	return sb.String()
+}
+
+// sanitizePrometheusName replaces characters invalid in prometheus metric and label names with underscores
+func sanitizePrometheusName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
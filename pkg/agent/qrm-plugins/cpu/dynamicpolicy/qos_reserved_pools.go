@@ -0,0 +1,89 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kubewharf/katalyst-api/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+// qosLevelsAdmittedByDynamicPolicy are QoS levels whose containers are allocated by the dynamic policy,
+// reserved pools can't be carved out for them since cpus in reserved pools are excluded from all pools
+// managed by the dynamic policy.
+var qosLevelsAdmittedByDynamicPolicy = map[string]bool{
+	consts.PodAnnotationQoSLevelSharedCores:    true,
+	consts.PodAnnotationQoSLevelDedicatedCores: true,
+	consts.PodAnnotationQoSLevelReclaimedCores: true,
+}
+
+// parseQoSReservedPools parses cpusets of reserved pools keyed by QoS level (e.g. system_cores: "0-1"),
+// and validates that pools are within machine cpus and don't overlap with each other.
+func parseQoSReservedPools(pools map[string]string, allCPUs machine.CPUSet) (map[string]machine.CPUSet, error) {
+	qosLevels := make([]string, 0, len(pools))
+	for qosLevel := range pools {
+		qosLevels = append(qosLevels, qosLevel)
+	}
+	sort.Strings(qosLevels)
+
+	qosReservedPools := make(map[string]machine.CPUSet, len(pools))
+	poolsCPUs := machine.NewCPUSet()
+	for _, qosLevel := range qosLevels {
+		if qosLevelsAdmittedByDynamicPolicy[qosLevel] {
+			return nil, fmt.Errorf("reserved pool isn't supported for QoS level: %s admitted by dynamic policy", qosLevel)
+		}
+
+		cpus, err := machine.ParseCPUSet(pools[qosLevel])
+		if err != nil {
+			return nil, fmt.Errorf("parse reserved pool of QoS level: %s failed with error: %v", qosLevel, err)
+		} else if cpus.IsEmpty() {
+			return nil, fmt.Errorf("reserved pool of QoS level: %s is empty", qosLevel)
+		} else if !cpus.IsSubsetOf(allCPUs) {
+			return nil, fmt.Errorf("reserved pool: %s of QoS level: %s contains cpus out of machine: %s",
+				cpus.String(), qosLevel, cpus.Difference(allCPUs).String())
+		} else if overlap := cpus.Intersection(poolsCPUs); !overlap.IsEmpty() {
+			return nil, fmt.Errorf("reserved pool: %s of QoS level: %s overlaps with other pools at: %s",
+				cpus.String(), qosLevel, overlap.String())
+		}
+
+		qosReservedPools[qosLevel] = cpus
+		poolsCPUs = poolsCPUs.Union(cpus)
+	}
+	return qosReservedPools, nil
+}
+
+// unionQoSReservedPools returns the union of cpus in reserved pools of all QoS levels
+func unionQoSReservedPools(qosReservedPools map[string]machine.CPUSet) machine.CPUSet {
+	poolsCPUs := machine.NewCPUSet()
+	for _, cpus := range qosReservedPools {
+		poolsCPUs = poolsCPUs.Union(cpus)
+	}
+	return poolsCPUs
+}
+
+// getQoSReservedPoolsCPUs returns the union of cpus in reserved pools of all QoS levels
+func (p *DynamicPolicy) getQoSReservedPoolsCPUs() machine.CPUSet {
+	return unionQoSReservedPools(p.qosReservedPools)
+}
+
+// getAllReservedCPUs returns the global reserved cpus and cpus in reserved pools of all QoS levels,
+// none of them can be used by containers or pools managed by the dynamic policy.
+func (p *DynamicPolicy) getAllReservedCPUs() machine.CPUSet {
+	return p.reservedCPUs.Union(p.getQoSReservedPoolsCPUs())
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// reclaimedNUMAOccupancy maps NUMA id to requested quantity of each reclaimed_cores pod admitted to it,
+// containers already flagged to be evicted aren't counted.
+type reclaimedNUMAOccupancy map[int]map[string]float64
+
+// getReclaimedNUMAOccupancy builds reclaimedNUMAOccupancy by NUMA hints of reclaimed_cores containers
+func getReclaimedNUMAOccupancy(podEntries state.PodEntries) reclaimedNUMAOccupancy {
+	occupancy := make(reclaimedNUMAOccupancy)
+	for podUID, containerEntries := range podEntries {
+		if containerEntries.IsPoolEntry() {
+			continue
+		}
+
+		for _, allocationInfo := range containerEntries {
+			numaHint, ok := allocationInfo.GetReclaimedNUMAHint()
+			if !ok {
+				continue
+			} else if _, flagged := allocationInfo.Annotations[cpuconsts.CPUStateAnnotationKeyFreeNUMAEviction]; flagged {
+				continue
+			}
+
+			if occupancy[numaHint] == nil {
+				occupancy[numaHint] = make(map[string]float64)
+			}
+			occupancy[numaHint][podUID] += allocationInfo.RequestQuantity
+		}
+	}
+	return occupancy
+}
+
+// getQuantity returns the total requested quantity of reclaimed_cores pods admitted to the given NUMAs
+func (o reclaimedNUMAOccupancy) getQuantity(numaIDs []int) float64 {
+	var quantity float64 = 0
+	for _, numaID := range numaIDs {
+		for _, podQuantity := range o[numaID] {
+			quantity += podQuantity
+		}
+	}
+	return quantity
+}
+
+func (p *DynamicPolicy) isReclaimedNUMAEvictionEnabled() bool {
+	return p.maxReclaimedEvictionsFreeNUMA > 0 && p.reclaimedNUMAOvercommitRatio > 0
+}
+
+// fitReclaimedNUMAOccupancy returns true if reclaimed_cores pods still fit the overcommit bound of NUMAs
+// after reqInt cpus are taken out of their available cpus, it's consistent with GetReclaimedAvailableQuantity.
+func (p *DynamicPolicy) fitReclaimedNUMAOccupancy(availableQuantity, reqInt int, reclaimedQuantity float64) bool {
+	return float64(availableQuantity-reqInt)*p.reclaimedNUMAOvercommitRatio >= reclaimedQuantity
+}
+
+// recalculateHintsByFreeingReclaimedNUMA is called when the dedicated_cores with numa_binding container can't fit
+// into any NUMA, it picks the single NUMA requiring the fewest evictions of reclaimed_cores pods (with lower priority
+// than the candidate pod) to fit the request, and calculates hints again without counting those pods. Nothing is
+// flagged here since hints are calculated under the read lock, pods are flagged to be evicted by
+// flagReclaimedPodsToFreeNUMA only after the container is admitted. The given hints are returned as they are
+// if no NUMA can be freed.
+func (p *DynamicPolicy) recalculateHintsByFreeingReclaimedNUMA(ctx context.Context,
+	req *pluginapi.ResourceRequest, reqInt int,
+	machineState state.NUMANodeMap, occupancy reclaimedNUMAOccupancy, hints map[string]*pluginapi.ListOfTopologyHints,
+) (map[string]*pluginapi.ListOfTopologyHints, error) {
+	logger := general.LoggerFromContext(ctx)
+	priority, ok := p.getPodPriority(req.PodUid)
+	if !ok {
+		logger.Warningf("pod: %s/%s priority is unknown, skip evicting reclaimed_cores pods to free NUMA",
+			req.PodNamespace, req.PodName)
+		return hints, nil
+	}
+
+	numaConstraint := p.getNUMAConstraint(ctx, req.Hint, req.Annotations)
+	// hints without counting reclaimed_cores pods are candidates that can be freed by evictions
+	candidateHints, err := p.calculateHintsWithReclaimedOccupancy(ctx, reqInt, machineState, req.Annotations, numaConstraint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("calculateHintsWithReclaimedOccupancy failed with error: %v", err)
+	}
+
+	candidateNUMAs := make([]int, 0, len(candidateHints[string(v1.ResourceCPU)].Hints))
+	for _, hint := range candidateHints[string(v1.ResourceCPU)].Hints {
+		// only a single NUMA is freed at a time to bound the evictions
+		if len(hint.Nodes) == 1 {
+			candidateNUMAs = append(candidateNUMAs, int(hint.Nodes[0]))
+		}
+	}
+
+	alignedReqInt, _ := p.alignRequestToFullCores(reqInt, req.Annotations)
+	numaID, victims, ok := p.getMinimalReclaimedEvictionsToFreeNUMA(candidateNUMAs, alignedReqInt, priority,
+		machineState, occupancy)
+	if !ok {
+		logger.Warningf("pod: %s/%s, container: %s can't fit into any NUMA by evicting at most %d reclaimed_cores pods",
+			req.PodNamespace, req.PodName, req.ContainerName, p.maxReclaimedEvictionsFreeNUMA)
+		return hints, nil
+	}
+
+	logger.Infof("pod: %s/%s, container: %s can fit into NUMA: %d by evicting reclaimed_cores pods: %v",
+		req.PodNamespace, req.PodName, req.ContainerName, numaID, victims)
+	// the given occupancy may be shared with the caller, so victims are removed from a copy
+	freedOccupancy := make(reclaimedNUMAOccupancy, len(occupancy))
+	for id, podQuantities := range occupancy {
+		freedOccupancy[id] = general.DeepCopyFload64Map(podQuantities)
+	}
+	for _, podUID := range victims {
+		delete(freedOccupancy[numaID], podUID)
+	}
+	return p.calculateHintsWithReclaimedOccupancy(ctx, reqInt, machineState, req.Annotations, numaConstraint, freedOccupancy)
+}
+
+// flagReclaimedPodsToFreeNUMA flags the fewest reclaimed_cores pods (with lower priority than the admitted pod)
+// to be evicted by the eviction plugin, if they exceed the overcommit bound of the single NUMA that the admitted
+// dedicated_cores with numa_binding container is allocated to. It must be called with the policy lock held.
+func (p *DynamicPolicy) flagReclaimedPodsToFreeNUMA(podUID, containerName string) {
+	allocationInfo := p.state.GetAllocationInfo(podUID, containerName)
+	if allocationInfo == nil || !state.CheckDedicatedNUMABinding(allocationInfo) {
+		return
+	}
+
+	numaSet := allocationInfo.GetAllocationResultNUMASet()
+	if numaSet.Size() != 1 {
+		return
+	}
+	numaID := numaSet.ToSliceInt()[0]
+
+	priority, ok := p.getPodPriority(podUID)
+	if !ok {
+		return
+	}
+
+	podEntries := p.state.GetPodEntries()
+	// the container is already counted in machine state, so nothing more is requested
+	_, victims, ok := p.getMinimalReclaimedEvictionsToFreeNUMA([]int{numaID}, 0, priority,
+		p.state.GetMachineState(), getReclaimedNUMAOccupancy(podEntries))
+	if !ok {
+		general.Warningf("pod: %s/%s, container: %s can't free NUMA: %d by evicting at most %d reclaimed_cores pods",
+			allocationInfo.PodNamespace, allocationInfo.PodName, containerName, numaID, p.maxReclaimedEvictionsFreeNUMA)
+		return
+	}
+
+	for _, victimPodUID := range victims {
+		for victimContainerName, victimAllocationInfo := range podEntries[victimPodUID] {
+			if victimAllocationInfo == nil {
+				continue
+			}
+
+			victimAllocationInfo.Annotations = general.MergeMap(victimAllocationInfo.Annotations, map[string]string{
+				cpuconsts.CPUStateAnnotationKeyFreeNUMAEviction: fmt.Sprintf("%d", numaID),
+			})
+			p.state.SetAllocationInfo(victimPodUID, victimContainerName, victimAllocationInfo)
+
+			general.Infof("pod: %s/%s, container: %s is flagged to be evicted to free NUMA: %d for pod: %s/%s",
+				victimAllocationInfo.PodNamespace, victimAllocationInfo.PodName, victimContainerName, numaID,
+				allocationInfo.PodNamespace, allocationInfo.PodName)
+			_ = p.emitter.StoreInt64(util.MetricNameEvictReclaimedToFreeNUMA, 1, metrics.MetricTypeNameRaw,
+				metrics.MetricTag{Key: "numaID", Val: fmt.Sprintf("%d", numaID)})
+		}
+	}
+}
+
+// getMinimalReclaimedEvictionsToFreeNUMA returns the NUMA (the lowest id among ties) requiring the fewest
+// evictions of reclaimed_cores pods for the request to fit, along with those pods; pods requesting more are
+// evicted first, and pods with priority higher than or equal to the given one are never evicted.
+func (p *DynamicPolicy) getMinimalReclaimedEvictionsToFreeNUMA(candidateNUMAs []int, reqInt int, priority int32,
+	machineState state.NUMANodeMap, occupancy reclaimedNUMAOccupancy,
+) (int, []string, bool) {
+	sort.Ints(candidateNUMAs)
+	unavailableCPUs := p.getUnavailableCPUs()
+
+	var minVictims []string
+	minNUMA, found := 0, false
+	for _, numaID := range candidateNUMAs {
+		availableQuantity := machineState[numaID].GetAvailableCPUSet(unavailableCPUs).Size()
+		reclaimedQuantity := occupancy.getQuantity([]int{numaID})
+
+		podUIDs := make([]string, 0, len(occupancy[numaID]))
+		for podUID := range occupancy[numaID] {
+			podUIDs = append(podUIDs, podUID)
+		}
+		sort.SliceStable(podUIDs, func(i, j int) bool {
+			if occupancy[numaID][podUIDs[i]] != occupancy[numaID][podUIDs[j]] {
+				return occupancy[numaID][podUIDs[i]] > occupancy[numaID][podUIDs[j]]
+			}
+			return podUIDs[i] < podUIDs[j]
+		})
+
+		var victims []string
+		for _, podUID := range podUIDs {
+			if p.fitReclaimedNUMAOccupancy(availableQuantity, reqInt, reclaimedQuantity) ||
+				len(victims) >= p.maxReclaimedEvictionsFreeNUMA {
+				break
+			}
+
+			victimPriority, ok := p.getPodPriority(podUID)
+			if !ok || victimPriority >= priority {
+				continue
+			}
+
+			victims = append(victims, podUID)
+			reclaimedQuantity -= occupancy[numaID][podUID]
+		}
+
+		if !p.fitReclaimedNUMAOccupancy(availableQuantity, reqInt, reclaimedQuantity) {
+			general.InfofV(4, "NUMA: %d can't be freed by evicting at most %d reclaimed_cores pods",
+				numaID, p.maxReclaimedEvictionsFreeNUMA)
+			continue
+		}
+
+		if !found || len(victims) < len(minVictims) {
+			minNUMA, minVictims, found = numaID, victims, true
+		}
+	}
+
+	return minNUMA, minVictims, found
+}
+
+// getPodPriority returns priority of the pod in metaServer, false is returned if the pod isn't found
+func (p *DynamicPolicy) getPodPriority(podUID string) (int32, bool) {
+	if p.metaServer == nil || p.metaServer.PodFetcher == nil {
+		return 0, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pod, err := p.metaServer.GetPod(ctx, podUID)
+	if err != nil || pod == nil {
+		general.Warningf("get pod: %s failed with error: %v", podUID, err)
+		return 0, false
+	}
+
+	if pod.Spec.Priority == nil {
+		return 0, true
+	}
+	return *pod.Spec.Priority, true
+}
@@ -0,0 +1,314 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+// sharedDisplacementPlan maps pod uid of numa_binding shared_cores pods to the NUMA they're displaced to
+type sharedDisplacementPlan map[string]int
+
+func (p *DynamicPolicy) isSharedDisplacementEnabled() bool {
+	return p.maxSharedDisplacements > 0
+}
+
+// getSharedNUMABindingPodsInNUMA returns uids (sorted) of numa_binding shared_cores pods admitted to the NUMA
+func getSharedNUMABindingPodsInNUMA(podEntries state.PodEntries, numaID int) []string {
+	var podUIDs []string
+	for podUID, containerEntries := range podEntries {
+		if containerEntries.IsPoolEntry() || !state.CheckSharedNUMABinding(containerEntries.GetMainContainerEntry()) {
+			continue
+		}
+
+		if numaHint, ok := containerEntries.GetSharedNUMABindingNUMAHint(""); ok && numaHint == numaID {
+			podUIDs = append(podUIDs, podUID)
+		}
+	}
+	sort.Strings(podUIDs)
+	return podUIDs
+}
+
+// isMemoryBoundToNUMA returns true if memory of the pod is bound to the NUMA by memory plugin. since only cpusets
+// are moved by displacement, such pods can't be displaced, or their cpus and memory would be on different NUMAs.
+// it's also true if the binding can't be told, and false if memory of the pod isn't allocated with numa_binding.
+func (p *DynamicPolicy) isMemoryBoundToNUMA(ctx context.Context, podUID string, numaID int) bool {
+	if p.memoryNUMAsGetter == nil {
+		return false
+	}
+
+	memoryNUMAs, ok, err := p.memoryNUMAsGetter.GetMemoryNUMAs(podUID)
+	if err != nil {
+		general.LoggerFromContext(ctx).Errorf("pod: %s get NUMAs of allocated memory failed with error: %v", podUID, err)
+		return true
+	}
+	return ok && memoryNUMAs.Contains(numaID)
+}
+
+// planSharedDisplacement plans to move all numa_binding shared_cores pods out of the NUMA, each of them is moved to
+// the NUMA with the most available cpus that it can be placed on, taking pods already planned into account; pods
+// requesting more are planned first. false is returned if the NUMA can't be freed by moving at most
+// maxSharedDisplacements pods, any of the pods has memory bound to the NUMA, or there is nothing to move.
+func (p *DynamicPolicy) planSharedDisplacement(ctx context.Context, numaID int,
+	podEntries state.PodEntries, machineState state.NUMANodeMap,
+) (sharedDisplacementPlan, bool) {
+	logger := general.LoggerFromContext(ctx)
+	if machineState[numaID] == nil || machineState[numaID].ExistMatchedAllocationInfo(state.CheckDedicatedNUMABinding) {
+		return nil, false
+	}
+
+	podUIDs := getSharedNUMABindingPodsInNUMA(podEntries, numaID)
+	if len(podUIDs) == 0 {
+		return nil, false
+	} else if len(podUIDs) > p.maxSharedDisplacements {
+		logger.InfofV(4, "NUMA: %d can't be freed by moving %d numa_binding shared_cores pods, exceeding: %d",
+			numaID, len(podUIDs), p.maxSharedDisplacements)
+		return nil, false
+	}
+
+	for _, podUID := range podUIDs {
+		if p.isMemoryBoundToNUMA(ctx, podUID, numaID) {
+			mainAllocationInfo := podEntries[podUID].GetMainContainerEntry()
+			logger.InfofV(4, "NUMA: %d can't be freed, memory of numa_binding shared_cores pod: %s/%s is bound to it",
+				numaID, mainAllocationInfo.PodNamespace, mainAllocationInfo.PodName)
+			return nil, false
+		}
+	}
+
+	podReqInts := make(map[string]int, len(podUIDs))
+	for _, podUID := range podUIDs {
+		podReqInts[podUID] = int(math.Ceil(podEntries[podUID].GetMainContainerEntry().RequestQuantity))
+	}
+	sort.SliceStable(podUIDs, func(i, j int) bool {
+		return podReqInts[podUIDs[i]] > podReqInts[podUIDs[j]]
+	})
+
+	sharedNUMAPools := state.NewSharedNUMAPools(podEntries)
+	unavailableCPUs := p.getUnavailableCPUs()
+	plannedQuantities := make(map[int]int)
+	plan := make(sharedDisplacementPlan, len(podUIDs))
+	for _, podUID := range podUIDs {
+		mainAllocationInfo := podEntries[podUID].GetMainContainerEntry()
+		numaNodes, _, err := p.filterNUMANodesForNUMABindingSharedCores(ctx, podReqInts[podUID], podEntries, machineState,
+			sharedNUMAPools, mainAllocationInfo.Annotations, machine.NewCPUSet(), nil)
+		if err != nil {
+			logger.Warningf("pod: %s/%s can't be moved out of NUMA: %d, filter NUMAs failed with error: %v",
+				mainAllocationInfo.PodNamespace, mainAllocationInfo.PodName, numaID, err)
+			return nil, false
+		}
+
+		targetNUMA, maxAvailable := -1, -1
+		for _, nodeID := range numaNodes {
+			if nodeID == numaID || machineState[nodeID] == nil ||
+				machineState[nodeID].ExistMatchedAllocationInfo(state.CheckDedicatedNUMABinding) {
+				continue
+			}
+
+			available := p.getNUMAAvailableCPUQuantity(sharedNUMAPools, nodeID, unavailableCPUs) - plannedQuantities[nodeID]
+			if available >= podReqInts[podUID] && available > maxAvailable {
+				targetNUMA, maxAvailable = nodeID, available
+			}
+		}
+
+		if targetNUMA < 0 {
+			logger.InfofV(4, "pod: %s/%s can't be moved out of NUMA: %d, no other NUMA fits request: %d",
+				mainAllocationInfo.PodNamespace, mainAllocationInfo.PodName, numaID, podReqInts[podUID])
+			return nil, false
+		}
+
+		plan[podUID] = targetNUMA
+		plannedQuantities[targetNUMA] += podReqInts[podUID]
+	}
+
+	return plan, true
+}
+
+// recalculateHintsByDisplacingSharedPods is called when the dedicated_cores with numa_binding container still can't
+// fit into any NUMA, it picks the single NUMA requiring the fewest numa_binding shared_cores pods to be moved out,
+// and calculates hints again as if those pods were gone. Nothing is moved here since hints are calculated under the
+// read lock, pods are moved by displaceSharedPods only when the container is allocated to the NUMA. The given hints
+// are returned as they are if no NUMA can be freed.
+func (p *DynamicPolicy) recalculateHintsByDisplacingSharedPods(ctx context.Context,
+	req *pluginapi.ResourceRequest, reqInt int, podEntries state.PodEntries,
+	machineState state.NUMANodeMap, occupancy reclaimedNUMAOccupancy, hints map[string]*pluginapi.ListOfTopologyHints,
+) (map[string]*pluginapi.ListOfTopologyHints, error) {
+	logger := general.LoggerFromContext(ctx)
+	numaConstraint := p.getNUMAConstraint(ctx, req.Hint, req.Annotations)
+
+	numaNodes := make([]int, 0, len(machineState))
+	for numaID := range machineState {
+		numaNodes = append(numaNodes, numaID)
+	}
+	sort.Ints(numaNodes)
+	numaNodes = p.filterCordonedNUMANodes(filterNUMANodesByConstraint(ctx, numaNodes, numaConstraint))
+	numaNodes = filterNamespaceExcludedNUMANodes(ctx, numaNodes)
+
+	minNUMA, minPlan := -1, sharedDisplacementPlan(nil)
+	for _, numaID := range numaNodes {
+		plan, ok := p.planSharedDisplacement(ctx, numaID, podEntries, machineState)
+		if ok && (minPlan == nil || len(plan) < len(minPlan)) {
+			minNUMA, minPlan = numaID, plan
+		}
+	}
+
+	if minPlan == nil {
+		logger.Warningf("pod: %s/%s, container: %s can't fit into any NUMA by moving at most %d numa_binding shared_cores pods",
+			req.PodNamespace, req.PodName, req.ContainerName, p.maxSharedDisplacements)
+		return hints, nil
+	}
+
+	// the given pod entries may be shared with the caller, so pods are removed from a copy
+	displacedPodEntries := podEntries.Clone()
+	for podUID := range minPlan {
+		delete(displacedPodEntries, podUID)
+	}
+	displacedMachineState, err := p.generateMachineStateWithRetry(displacedPodEntries)
+	if err != nil {
+		return nil, fmt.Errorf("GenerateMachineStateFromPodEntries failed with error: %v", err)
+	}
+
+	displacedHints, err := p.calculateHintsWithReclaimedOccupancy(ctx, reqInt, displacedMachineState, req.Annotations,
+		machine.NewCPUSet(minNUMA), occupancy)
+	if err != nil {
+		return nil, fmt.Errorf("calculateHintsWithReclaimedOccupancy failed with error: %v", err)
+	} else if len(displacedHints[string(v1.ResourceCPU)].Hints) == 0 {
+		logger.Warningf("pod: %s/%s, container: %s can't fit into NUMA: %d even if numa_binding shared_cores pods are moved out",
+			req.PodNamespace, req.PodName, req.ContainerName, minNUMA)
+		return hints, nil
+	}
+
+	logger.Infof("pod: %s/%s, container: %s can fit into NUMA: %d by moving numa_binding shared_cores pods: %v",
+		req.PodNamespace, req.PodName, req.ContainerName, minNUMA, minPlan)
+	return displacedHints, nil
+}
+
+// needSharedDisplacement returns true if the dedicated_cores with numa_binding container allocated to the single NUMA
+// can't fit into it unless numa_binding shared_cores pods in it are moved out.
+func (p *DynamicPolicy) needSharedDisplacement(reqInt int, hint *pluginapi.TopologyHint,
+	machineState state.NUMANodeMap, reqAnnotations map[string]string,
+) (int, bool) {
+	if !p.isSharedDisplacementEnabled() || hint == nil || len(hint.Nodes) != 1 {
+		return 0, false
+	}
+
+	numaID := int(hint.Nodes[0])
+	numaState := machineState[numaID]
+	if numaState == nil || !numaState.ExistMatchedAllocationInfo(state.CheckSharedNUMABinding) {
+		return 0, false
+	}
+
+	alignedReqInt, _ := p.alignRequestToFullCores(reqInt, reqAnnotations)
+	if isNodeExclusive(reqAnnotations) && numaState.AllocatedCPUSet.Size() > 0 {
+		return numaID, true
+	}
+	return numaID, numaState.GetAvailableCPUSet(p.getUnavailableCPUs()).Size() < alignedReqInt
+}
+
+// displaceSharedPods moves numa_binding shared_cores pods (without memory bound to the NUMA) out of the NUMA to free
+// it for a dedicated_cores with numa_binding container, their cpusets are reassigned to pools of the NUMAs planned and applied to the running
+// containers directly, so that they aren't restarted. It must be called with the policy lock held.
+func (p *DynamicPolicy) displaceSharedPods(ctx context.Context, numaID int) error {
+	logger := general.LoggerFromContext(ctx)
+	podEntries := p.state.GetPodEntries()
+	plan, ok := p.planSharedDisplacement(ctx, numaID, podEntries, p.state.GetMachineState())
+	if !ok {
+		return fmt.Errorf("NUMA: %d can't be freed by moving at most %d numa_binding shared_cores pods",
+			numaID, p.maxSharedDisplacements)
+	}
+
+	mainAllocationInfos := make([]*state.AllocationInfo, 0, len(plan))
+	for podUID, targetNUMA := range plan {
+		for containerName, allocationInfo := range podEntries[podUID] {
+			if allocationInfo == nil {
+				continue
+			}
+
+			// owner pool is cleared to be put into the numa_binding pool of the NUMA hint
+			allocationInfo.OwnerPoolName = state.EmptyOwnerPoolName
+			allocationInfo.Annotations = general.MergeMap(allocationInfo.Annotations, map[string]string{
+				cpuconsts.CPUStateAnnotationKeyNUMAHint: fmt.Sprintf("%d", targetNUMA),
+			})
+			p.state.SetAllocationInfo(podUID, containerName, allocationInfo)
+
+			if allocationInfo.CheckMainContainer() {
+				mainAllocationInfos = append(mainAllocationInfos, allocationInfo)
+			}
+		}
+
+		logger.Infof("pod: %s/%s is moved from NUMA: %d to NUMA: %d",
+			podEntries[podUID].GetMainContainerEntry().PodNamespace,
+			podEntries[podUID].GetMainContainerEntry().PodName, numaID, targetNUMA)
+		_ = p.emitter.StoreInt64(util.MetricNameDisplaceSharedToFreeNUMA, 1, metrics.MetricTypeNameRaw,
+			metrics.MetricTag{Key: "numaID", Val: fmt.Sprintf("%d", numaID)},
+			metrics.MetricTag{Key: "targetNUMAID", Val: fmt.Sprintf("%d", targetNUMA)})
+	}
+
+	// pools are re-calculated by counting requests of moved pods in their new NUMAs
+	if err := p.putAllocationsAndAdjustAllocationEntries(mainAllocationInfos, true); err != nil {
+		return fmt.Errorf("putAllocationsAndAdjustAllocationEntries failed with error: %v", err)
+	}
+
+	for podUID := range plan {
+		p.updateNUMAMigration(p.state.GetPodEntries()[podUID].GetMainContainerEntry())
+	}
+
+	p.applyDisplacedCPUSets(ctx, plan)
+	return nil
+}
+
+// applyDisplacedCPUSets applies cpusets of moved pods to their running containers, failures are only logged
+// since they will be repaired by reconcileCPUSet (if cpuset drift repair is enabled) or the next allocation.
+func (p *DynamicPolicy) applyDisplacedCPUSets(ctx context.Context, plan sharedDisplacementPlan) {
+	logger := general.LoggerFromContext(ctx)
+	if p.metaServer == nil || p.cpusetManager == nil {
+		logger.Warningf("nil metaServer or cpusetManager, skip applying cpusets of moved pods")
+		return
+	}
+
+	for podUID := range plan {
+		for containerName, allocationInfo := range p.state.GetPodEntries()[podUID] {
+			if allocationInfo == nil || allocationInfo.AllocationResult.IsEmpty() {
+				continue
+			}
+
+			containerID, err := p.metaServer.GetContainerID(podUID, containerName)
+			if err != nil {
+				logger.Errorf("get container id of pod: %s container: %s failed with error: %v", podUID, containerName, err)
+				continue
+			}
+
+			if err = p.cpusetManager.ApplyCPUSet(podUID, containerID, allocationInfo.AllocationResult); err != nil {
+				logger.Errorf("apply cpuset: %s to pod: %s/%s, container: %s failed with error: %v",
+					allocationInfo.AllocationResult.String(), allocationInfo.PodNamespace, allocationInfo.PodName,
+					containerName, err)
+			}
+		}
+	}
+}
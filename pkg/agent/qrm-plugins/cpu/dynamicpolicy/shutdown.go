@@ -0,0 +1,67 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// shutdownTimeout bounds how long shutdown waits for in-flight admissions before giving up flushing state
+const shutdownTimeout = 10 * time.Second
+
+var errShuttingDown = fmt.Errorf("cpu plugin is shutting down, refuse new admissions")
+
+// Shutdown refuses new admissions and flushes current state to checkpoint under the policy lock,
+// so that the checkpoint doesn't lag the in-memory state (e.g. a checkpoint write failed during
+// a burst of admissions) when the agent exits. it's called by the agent before the plugin is stopped
+// on exiting, unlike Stop, which is also called to restart the plugin. it's idempotent and only takes
+// effect in the first call, flushing is given up with an error if the lock can't be taken within the timeout.
+func (p *DynamicPolicy) Shutdown(timeout time.Duration) error {
+	p.shutdownOnce.Do(func() {
+		p.shuttingDown.Store(true)
+		general.Infof("shutting down, new admissions are refused")
+
+		flushed := make(chan error, 1)
+		go func() {
+			p.Lock()
+			defer p.Unlock()
+
+			flushed <- p.state.StoreState()
+		}()
+
+		select {
+		case err := <-flushed:
+			if err != nil {
+				p.shutdownErr = fmt.Errorf("flush state to checkpoint failed with error: %v", err)
+				return
+			}
+			general.Infof("state is flushed to checkpoint")
+		case <-time.After(timeout):
+			p.shutdownErr = fmt.Errorf("flush state to checkpoint timeout after %v", timeout)
+		}
+	})
+
+	return p.shutdownErr
+}
+
+// isShuttingDown returns true if Shutdown has been called
+func (p *DynamicPolicy) isShuttingDown() bool {
+	return p.shuttingDown.Load()
+}
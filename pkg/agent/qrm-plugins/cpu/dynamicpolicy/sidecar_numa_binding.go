@@ -0,0 +1,81 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"fmt"
+
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	apiconsts "github.com/kubewharf/katalyst-api/pkg/consts"
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
+)
+
+// alignSidecarNUMABinding handles sidecars whose numa_binding annotation mismatches their main containers,
+// since hint and allocation handlers are chosen by annotations of the sidecar itself. by default, annotations
+// of the sidecar are aligned with the main container so that it's allocated with its main container,
+// and it's rejected if sidecarBindingMismatchPolicy is reject. it does nothing if the main container isn't allocated yet.
+func (p *DynamicPolicy) alignSidecarNUMABinding(ctx context.Context, req *pluginapi.ResourceRequest) error {
+	if req.ContainerType != pluginapi.ContainerType_SIDECAR {
+		return nil
+	}
+
+	mainContainerAllocationInfo := p.state.GetPodEntries()[req.PodUid].GetMainContainerEntry()
+	if mainContainerAllocationInfo == nil {
+		return nil
+	}
+
+	mainNUMABinding := state.CheckNUMABinding(mainContainerAllocationInfo)
+	sidecarNUMABinding := qosutil.AnnotationsIndicateNUMABinding(req.Annotations)
+	if mainNUMABinding == sidecarNUMABinding {
+		return nil
+	}
+
+	_ = p.emitter.StoreInt64(util.MetricNameSidecarNUMABindingMismatch, 1, metrics.MetricTypeNameRaw,
+		metrics.MetricTag{Key: "policy", Val: p.sidecarBindingMismatchPolicy})
+
+	if p.sidecarBindingMismatchPolicy == cpuconsts.SidecarNUMABindingMismatchPolicyReject {
+		return fmt.Errorf("numa_binding of pod: %s/%s, sidecar: %s is %v, mismatched with main container: %s",
+			req.PodNamespace, req.PodName, req.ContainerName, sidecarNUMABinding, mainContainerAllocationInfo.ContainerName)
+	}
+
+	general.LoggerFromContext(ctx).Warningf("numa_binding of sidecar is %v, mismatched with main container: %s, "+
+		"align it with main container", sidecarNUMABinding, mainContainerAllocationInfo.ContainerName)
+
+	if req.Annotations == nil {
+		req.Annotations = make(map[string]string)
+	}
+
+	if mainNUMABinding {
+		req.Annotations[apiconsts.PodAnnotationMemoryEnhancementNumaBinding] = apiconsts.PodAnnotationMemoryEnhancementNumaBindingEnable
+		if numaExclusive, ok := mainContainerAllocationInfo.Annotations[apiconsts.PodAnnotationMemoryEnhancementNumaExclusive]; ok {
+			req.Annotations[apiconsts.PodAnnotationMemoryEnhancementNumaExclusive] = numaExclusive
+		} else {
+			delete(req.Annotations, apiconsts.PodAnnotationMemoryEnhancementNumaExclusive)
+		}
+	} else {
+		delete(req.Annotations, apiconsts.PodAnnotationMemoryEnhancementNumaBinding)
+		delete(req.Annotations, apiconsts.PodAnnotationMemoryEnhancementNumaExclusive)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
+)
+
+// socketAffinityDroppedContextKey is the key in request context indicating socket affinity of the request
+// is dropped, i.e. hints are re-calculated on NUMAs of all sockets
+type socketAffinityDroppedContextKey struct{}
+
+// withoutSocketAffinity drops socket affinity of the request in the returned context
+func withoutSocketAffinity(ctx context.Context) context.Context {
+	return context.WithValue(ctx, socketAffinityDroppedContextKey{}, true)
+}
+
+func isSocketAffinityDropped(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+
+	dropped, _ := ctx.Value(socketAffinityDroppedContextKey{}).(bool)
+	return dropped
+}
+
+// getSocketAffinityNUMAs returns NUMAs on the socket specified by socket_affinity enhancement (resolved via
+// topology), false is returned if it's not set, it's invalid, or it's dropped in context.
+func (p *DynamicPolicy) getSocketAffinityNUMAs(ctx context.Context, reqAnnotations map[string]string) (machine.CPUSet, bool) {
+	if isSocketAffinityDropped(ctx) {
+		return machine.NewCPUSet(), false
+	}
+
+	logger := general.LoggerFromContext(ctx)
+	socketID, found, err := qosutil.ParseSocketAffinity(reqAnnotations)
+	if err != nil {
+		logger.Warningf("parse socket affinity failed with error: %v, ignore it", err)
+		return machine.NewCPUSet(), false
+	} else if !found {
+		return machine.NewCPUSet(), false
+	}
+
+	numaNodes := p.machineInfo.CPUDetails.NUMANodesInSockets(socketID)
+	if numaNodes.IsEmpty() {
+		logger.Warningf("socket: %d of socket affinity has no NUMA in topology, ignore it", socketID)
+		return machine.NewCPUSet(), false
+	}
+	return numaNodes, true
+}
+
+// applySocketAffinity narrows the NUMA constraint (empty means all NUMAs) down to NUMAs on the socket of
+// socket affinity. the constraint is kept as it is if none of its NUMAs is on the socket, since it's
+// determined by other resources that the pod has already been constrained to.
+func (p *DynamicPolicy) applySocketAffinity(ctx context.Context, numaConstraint machine.CPUSet,
+	reqAnnotations map[string]string,
+) machine.CPUSet {
+	socketNUMAs, ok := p.getSocketAffinityNUMAs(ctx, reqAnnotations)
+	if !ok {
+		return numaConstraint
+	} else if numaConstraint.IsEmpty() {
+		return socketNUMAs
+	}
+
+	socketConstraint := numaConstraint.Intersection(socketNUMAs)
+	if socketConstraint.IsEmpty() {
+		general.LoggerFromContext(ctx).Warningf("NUMA constraint: %s has no NUMA on socket affinity NUMAs: %s, ignore socket affinity",
+			numaConstraint.String(), socketNUMAs.String())
+		return numaConstraint
+	}
+	return socketConstraint
+}
+
+// shouldFallbackFromSocketAffinity returns true if socketAffinityFallback is set but no preferred hint is found
+// within NUMAs on the socket of socket affinity, so that hints should be re-calculated on NUMAs of all sockets.
+func (p *DynamicPolicy) shouldFallbackFromSocketAffinity(ctx context.Context, req *pluginapi.ResourceRequest,
+	resp *pluginapi.ResourceHintsResponse, hintsErr error,
+) bool {
+	if !p.socketAffinityFallback {
+		return false
+	} else if _, ok := p.getSocketAffinityNUMAs(ctx, req.Annotations); !ok {
+		return false
+	} else if hintsErr != nil || resp == nil {
+		return true
+	}
+
+	hints := resp.ResourceHints[string(v1.ResourceCPU)]
+	return hints != nil && !hasPreferredHint(hints.Hints)
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"math"
+
+	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
+)
+
+// SharedNUMAPool tracks shared_cores with numa_binding containers (i.e. members) admitted to a NUMA
+// along with their total requested quantity, which is maintained when members are set or deleted,
+// so that available quantity of the NUMA needn't be recomputed by walking through all entries.
+// quantities are kept in milli-cores to avoid accumulating float errors.
+type SharedNUMAPool struct {
+	members map[string]map[string]int64
+	// sidecarReservations maps pod uid to the quantity reserved by its main container for sidecars injected later,
+	// the reservation is consumed by sidecars admitted to the pool, so a pod counts main + max(sidecars, reserved).
+	sidecarReservations    map[string]sidecarReservation
+	requestedMilliQuantity int64
+}
+
+type sidecarReservation struct {
+	mainContainerName string
+	milliQuantity     int64
+}
+
+// SharedNUMAPools maps NUMA id to the shared pool in it, pools are copied on write
+// (i.e. a pool is never modified once it's put into the map), so that views returned
+// by ShallowClone can be read safely without deep copying all pools.
+type SharedNUMAPools map[int]*SharedNUMAPool
+
+// NewSharedNUMAPools builds shared pools from scratch by the given pod entries
+func NewSharedNUMAPools(podEntries PodEntries) SharedNUMAPools {
+	pools := make(SharedNUMAPools)
+	for _, containerEntries := range podEntries {
+		if containerEntries.IsPoolEntry() {
+			continue
+		}
+
+		for _, allocationInfo := range containerEntries {
+			if allocationInfo == nil {
+				continue
+			}
+
+			// pools are built from scratch and not published yet, so they can be modified in place
+			for _, numaID := range getSharedNUMAPoolNUMAs(allocationInfo) {
+				if pools[numaID] == nil {
+					pools[numaID] = newSharedNUMAPool()
+				}
+				pools[numaID].add(allocationInfo)
+			}
+		}
+	}
+	return pools
+}
+
+func newSharedNUMAPool() *SharedNUMAPool {
+	return &SharedNUMAPool{
+		members:             make(map[string]map[string]int64),
+		sidecarReservations: make(map[string]sidecarReservation),
+	}
+}
+
+func getMilliQuantity(quantity float64) int64 {
+	return int64(math.Round(quantity * 1000))
+}
+
+// getSidecarReservedMilliQuantity returns the quantity reserved for sidecars declared by the main container
+func getSidecarReservedMilliQuantity(allocationInfo *AllocationInfo) int64 {
+	if !allocationInfo.CheckMainContainer() {
+		return 0
+	}
+
+	reserved, found, err := qosutil.ParseSidecarReservedCPU(allocationInfo.Annotations)
+	if !found || err != nil {
+		return 0
+	}
+	return getMilliQuantity(reserved)
+}
+
+// getSharedNUMAPoolNUMAs returns NUMAs that the entry is counted in, it's consistent
+// with the way that GenerateMachineStateFromPodEntries puts entries into NUMA node states.
+func getSharedNUMAPoolNUMAs(allocationInfo *AllocationInfo) []int {
+	if allocationInfo == nil || !CheckSharedNUMABinding(allocationInfo) {
+		return nil
+	}
+
+	numaSet := make(map[int]bool)
+	for numaID, cset := range allocationInfo.TopologyAwareAssignments {
+		if cset.Size() > 0 {
+			numaSet[numaID] = true
+		}
+	}
+	for numaID, cset := range allocationInfo.OriginalTopologyAwareAssignments {
+		if cset.Size() > 0 {
+			numaSet[numaID] = true
+		}
+	}
+
+	numaIDs := make([]int, 0, len(numaSet))
+	for numaID := range numaSet {
+		numaIDs = append(numaIDs, numaID)
+	}
+	return numaIDs
+}
+
+// Set puts the entry into shared pools of its NUMAs (replacing the prior one with the same name if any),
+// entries that aren't shared_cores with numa_binding are only removed from pools.
+func (sp SharedNUMAPools) Set(allocationInfo *AllocationInfo) {
+	if allocationInfo == nil {
+		return
+	}
+
+	sp.Delete(allocationInfo.PodUid, allocationInfo.ContainerName)
+
+	for _, numaID := range getSharedNUMAPoolNUMAs(allocationInfo) {
+		pool := sp[numaID].Clone()
+		if pool == nil {
+			pool = newSharedNUMAPool()
+		}
+		pool.add(allocationInfo)
+		sp[numaID] = pool
+	}
+}
+
+// Delete removes the entry from shared pools of all NUMAs
+func (sp SharedNUMAPools) Delete(podUID, containerName string) {
+	for numaID, pool := range sp {
+		if _, ok := pool.members[podUID][containerName]; !ok {
+			continue
+		}
+
+		pool = pool.Clone()
+		pool.remove(podUID, containerName)
+		sp[numaID] = pool
+	}
+}
+
+// ShallowClone returns a copy of the map sharing pools with the original one,
+// it's cheap since pools are copied on write, but pools in it must not be modified.
+func (sp SharedNUMAPools) ShallowClone() SharedNUMAPools {
+	if sp == nil {
+		return nil
+	}
+
+	clone := make(SharedNUMAPools, len(sp))
+	for numaID, pool := range sp {
+		clone[numaID] = pool
+	}
+	return clone
+}
+
+func (sp SharedNUMAPools) Clone() SharedNUMAPools {
+	if sp == nil {
+		return nil
+	}
+
+	clone := make(SharedNUMAPools, len(sp))
+	for numaID, pool := range sp {
+		clone[numaID] = pool.Clone()
+	}
+	return clone
+}
+
+func (p *SharedNUMAPool) add(allocationInfo *AllocationInfo) {
+	podUID, containerName := allocationInfo.PodUid, allocationInfo.ContainerName
+	p.requestedMilliQuantity -= p.getPodMilliQuantity(podUID)
+
+	if p.members[podUID] == nil {
+		p.members[podUID] = make(map[string]int64)
+	}
+	p.members[podUID][containerName] = getMilliQuantity(allocationInfo.RequestQuantity)
+	if reserved := getSidecarReservedMilliQuantity(allocationInfo); reserved > 0 {
+		p.sidecarReservations[podUID] = sidecarReservation{mainContainerName: containerName, milliQuantity: reserved}
+	}
+
+	p.requestedMilliQuantity += p.getPodMilliQuantity(podUID)
+}
+
+func (p *SharedNUMAPool) remove(podUID, containerName string) {
+	if p == nil {
+		return
+	}
+
+	if _, ok := p.members[podUID][containerName]; !ok {
+		return
+	}
+
+	p.requestedMilliQuantity -= p.getPodMilliQuantity(podUID)
+	delete(p.members[podUID], containerName)
+	if p.sidecarReservations[podUID].mainContainerName == containerName {
+		delete(p.sidecarReservations, podUID)
+	}
+	if len(p.members[podUID]) == 0 {
+		delete(p.members, podUID)
+	}
+	p.requestedMilliQuantity += p.getPodMilliQuantity(podUID)
+}
+
+// getPodMilliQuantity returns the quantity counted for the pod in the pool, i.e. requests of its containers,
+// plus the part of the sidecar reservation that isn't consumed by sidecars admitted yet.
+func (p *SharedNUMAPool) getPodMilliQuantity(podUID string) int64 {
+	reservation, reserved := p.sidecarReservations[podUID]
+
+	var milliQuantity, sidecarMilliQuantity int64
+	for containerName, containerMilliQuantity := range p.members[podUID] {
+		milliQuantity += containerMilliQuantity
+		if reserved && containerName != reservation.mainContainerName {
+			sidecarMilliQuantity += containerMilliQuantity
+		}
+	}
+
+	if reserved && reservation.milliQuantity > sidecarMilliQuantity {
+		milliQuantity += reservation.milliQuantity - sidecarMilliQuantity
+	}
+	return milliQuantity
+}
+
+func (p *SharedNUMAPool) Clone() *SharedNUMAPool {
+	if p == nil {
+		return nil
+	}
+
+	clone := &SharedNUMAPool{
+		members:                make(map[string]map[string]int64, len(p.members)),
+		sidecarReservations:    make(map[string]sidecarReservation, len(p.sidecarReservations)),
+		requestedMilliQuantity: p.requestedMilliQuantity,
+	}
+	for podUID, reservation := range p.sidecarReservations {
+		clone.sidecarReservations[podUID] = reservation
+	}
+	for podUID, containers := range p.members {
+		clone.members[podUID] = make(map[string]int64, len(containers))
+		for containerName, milliQuantity := range containers {
+			clone.members[podUID][containerName] = milliQuantity
+		}
+	}
+	return clone
+}
+
+// GetMemberCount returns the number of containers in the pool
+func (p *SharedNUMAPool) GetMemberCount() int {
+	if p == nil {
+		return 0
+	}
+
+	count := 0
+	for _, containers := range p.members {
+		count += len(containers)
+	}
+	return count
+}
+
+// GetRequestedQuantity returns the total requested quantity (rounded up) of containers in the pool,
+// including quantities reserved for sidecars that aren't admitted yet.
+func (p *SharedNUMAPool) GetRequestedQuantity() int {
+	return p.GetRequestedQuantityExcludingContainer("", "")
+}
+
+// GetRequestedQuantityExcludingContainer is the same as GetRequestedQuantity,
+// except that requested quantity of the given container isn't counted.
+func (p *SharedNUMAPool) GetRequestedQuantityExcludingContainer(podUID, containerName string) int {
+	if p == nil {
+		return 0
+	}
+
+	milliQuantity := p.requestedMilliQuantity - p.members[podUID][containerName]
+	return int(math.Ceil(float64(milliQuantity) / 1000))
+}
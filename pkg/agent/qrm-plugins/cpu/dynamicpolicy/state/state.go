@@ -20,6 +20,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"reflect"
+	"sort"
 
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
@@ -81,6 +83,25 @@ type NUMANodeState struct {
 
 type NUMANodeMap map[int]*NUMANodeState // keyed by numa node id
 
+// CPUSetBreakdown explains how cpus of a numa are accounted, each cpu is attributed to the first matched one
+// of reserved, offline, allocated and available, so they are disjoint and sum up to total.
+type CPUSetBreakdown struct {
+	Total     machine.CPUSet `json:"total"`
+	Reserved  machine.CPUSet `json:"reserved"`
+	Offline   machine.CPUSet `json:"offline"`
+	Allocated machine.CPUSet `json:"allocated"`
+	Available machine.CPUSet `json:"available"`
+}
+
+func (b *CPUSetBreakdown) String() string {
+	if b == nil {
+		return ""
+	}
+	return fmt.Sprintf("total: %s(%d), reserved: %s(%d), offline: %s(%d), allocated: %s(%d), available: %s(%d)",
+		b.Total.String(), b.Total.Size(), b.Reserved.String(), b.Reserved.Size(), b.Offline.String(), b.Offline.Size(),
+		b.Allocated.String(), b.Allocated.Size(), b.Available.String(), b.Available.Size())
+}
+
 func (ai *AllocationInfo) Clone() *AllocationInfo {
 	if ai == nil {
 		return nil
@@ -125,6 +146,45 @@ func (ai *AllocationInfo) Clone() *AllocationInfo {
 	return clone
 }
 
+// Equals returns true if the two allocation infos are the same, cpusets in them
+// are compared with set semantics rather than by their representations.
+func (ai *AllocationInfo) Equals(ai2 *AllocationInfo) bool {
+	if ai == nil || ai2 == nil {
+		return ai == ai2
+	}
+
+	if !ai.AllocationResult.Equals(ai2.AllocationResult) ||
+		!ai.OriginalAllocationResult.Equals(ai2.OriginalAllocationResult) ||
+		!CPUAssignmentEquals(ai.TopologyAwareAssignments, ai2.TopologyAwareAssignments) ||
+		!CPUAssignmentEquals(ai.OriginalTopologyAwareAssignments, ai2.OriginalTopologyAwareAssignments) {
+		return false
+	}
+
+	// cpusets are compared above, so only the remaining fields are left to reflect
+	a, b := *ai, *ai2
+	a.AllocationResult, b.AllocationResult = machine.CPUSet{}, machine.CPUSet{}
+	a.OriginalAllocationResult, b.OriginalAllocationResult = machine.CPUSet{}, machine.CPUSet{}
+	a.TopologyAwareAssignments, b.TopologyAwareAssignments = nil, nil
+	a.OriginalTopologyAwareAssignments, b.OriginalTopologyAwareAssignments = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+// CPUAssignmentEquals returns true if the two assignments have the same non-empty cpusets on each NUMA
+func CPUAssignmentEquals(a, b map[int]machine.CPUSet) bool {
+	for numaID, cset := range a {
+		if !cset.Equals(b[numaID]) {
+			return false
+		}
+	}
+
+	for numaID, cset := range b {
+		if !cset.Equals(a[numaID]) {
+			return false
+		}
+	}
+	return true
+}
+
 func (ai *AllocationInfo) String() string {
 	if ai == nil {
 		return ""
@@ -168,6 +228,20 @@ func (ai *AllocationInfo) GetSpecifiedPoolName() string {
 	return GetSpecifiedPoolName(ai.QoSLevel, ai.Annotations[consts.PodAnnotationCPUEnhancementCPUSet])
 }
 
+// GetReclaimedNUMAHint returns the NUMA that reclaimed_cores container is admitted to,
+// and false if the container isn't reclaimed_cores or hasn't NUMA hint recorded
+func (ai *AllocationInfo) GetReclaimedNUMAHint() (int, bool) {
+	if !CheckReclaimed(ai) {
+		return 0, false
+	}
+
+	numaSet, err := machine.Parse(ai.Annotations[cpuconsts.CPUStateAnnotationKeyNUMAHint])
+	if err != nil || numaSet.Size() != 1 {
+		return 0, false
+	}
+	return numaSet.ToSliceInt()[0], true
+}
+
 // GetSpecifiedNUMABindingPoolName get numa_binding pool name
 // for numa_binding shared_cores according to enhancements and NUMA hint
 func (ai *AllocationInfo) GetSpecifiedNUMABindingPoolName() (string, error) {
@@ -356,6 +430,31 @@ func (ce ContainerEntries) GetMainContainerPoolName() string {
 	return ce.GetMainContainerEntry().GetOwnerPoolName()
 }
 
+// GetSharedNUMABindingNUMAHint returns the NUMA node recorded by numa_binding shared_cores containers
+// in pod container entries (except for the given container), it's used to co-locate sibling containers.
+func (ce ContainerEntries) GetSharedNUMABindingNUMAHint(excludedContainerName string) (int, bool) {
+	containerNames := make([]string, 0, len(ce))
+	for containerName := range ce {
+		containerNames = append(containerNames, containerName)
+	}
+	sort.Strings(containerNames)
+
+	for _, containerName := range containerNames {
+		allocationInfo := ce[containerName]
+		if containerName == excludedContainerName || !CheckSharedNUMABinding(allocationInfo) {
+			continue
+		}
+
+		numaSet, err := machine.Parse(allocationInfo.Annotations[cpuconsts.CPUStateAnnotationKeyNUMAHint])
+		if err != nil || numaSet.Size() != 1 {
+			continue
+		}
+		return numaSet.ToSliceInt()[0], true
+	}
+
+	return 0, false
+}
+
 func (pe PodEntries) Clone() PodEntries {
 	if pe == nil {
 		return nil
@@ -375,6 +474,28 @@ func (pe PodEntries) Clone() PodEntries {
 	return clone
 }
 
+// Equals returns true if the two pod entries have the same containers with equal allocation infos
+func (pe PodEntries) Equals(pe2 PodEntries) bool {
+	if len(pe) != len(pe2) {
+		return false
+	}
+
+	for podUID, containerEntries := range pe {
+		containerEntries2, ok := pe2[podUID]
+		if !ok || len(containerEntries) != len(containerEntries2) {
+			return false
+		}
+
+		for containerName, allocationInfo := range containerEntries {
+			allocationInfo2, ok := containerEntries2[containerName]
+			if !ok || !allocationInfo.Equals(allocationInfo2) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func (pe PodEntries) String() string {
 	if pe == nil {
 		return ""
@@ -490,6 +611,40 @@ func (pe PodEntries) GetFilteredPodEntries(filter func(ai *AllocationInfo) bool)
 	return numaBindingEntries
 }
 
+// GetReclaimedAllocatedQuantity returns the sum of requested quantity of reclaimed_cores containers
+// admitted to each NUMA, it's used to bound overcommit of reclaimed_cores per NUMA
+func (pe PodEntries) GetReclaimedAllocatedQuantity() map[int]float64 {
+	reclaimedAllocatedQuantity := make(map[int]float64)
+	for _, containerEntries := range pe {
+		if containerEntries.IsPoolEntry() {
+			continue
+		}
+
+		for _, allocationInfo := range containerEntries {
+			if numaHint, ok := allocationInfo.GetReclaimedNUMAHint(); ok {
+				reclaimedAllocatedQuantity[numaHint] += allocationInfo.RequestQuantity
+			}
+		}
+	}
+	return reclaimedAllocatedQuantity
+}
+
+// FilterByQoSLevel returns deep-copied PodEntries (without pool entries) whose QoS level equals to the given one
+func (pe PodEntries) FilterByQoSLevel(qosLevel string) PodEntries {
+	return pe.GetFilteredPodEntries(func(ai *AllocationInfo) bool {
+		return ai.QoSLevel == qosLevel
+	})
+}
+
+// FilterByAnnotation returns deep-copied PodEntries (without pool entries) whose annotation value of the given key
+// equals to the given value
+func (pe PodEntries) FilterByAnnotation(key, value string) PodEntries {
+	return pe.GetFilteredPodEntries(func(ai *AllocationInfo) bool {
+		annotationValue, ok := ai.Annotations[key]
+		return ok && annotationValue == value
+	})
+}
+
 func (ns *NUMANodeState) Clone() *NUMANodeState {
 	if ns == nil {
 		return nil
@@ -501,6 +656,17 @@ func (ns *NUMANodeState) Clone() *NUMANodeState {
 	}
 }
 
+// Equals returns true if the two NUMA node states have the same cpusets and pod entries
+func (ns *NUMANodeState) Equals(ns2 *NUMANodeState) bool {
+	if ns == nil || ns2 == nil {
+		return ns == ns2
+	}
+
+	return ns.DefaultCPUSet.Equals(ns2.DefaultCPUSet) &&
+		ns.AllocatedCPUSet.Equals(ns2.AllocatedCPUSet) &&
+		ns.PodEntries.Equals(ns2.PodEntries)
+}
+
 // GetAvailableCPUSet returns available cpuset in this numa
 func (ns *NUMANodeState) GetAvailableCPUSet(reservedCPUs machine.CPUSet) machine.CPUSet {
 	if ns == nil {
@@ -509,16 +675,43 @@ func (ns *NUMANodeState) GetAvailableCPUSet(reservedCPUs machine.CPUSet) machine
 	return ns.DefaultCPUSet.Difference(reservedCPUs)
 }
 
+// GetReclaimedAvailableQuantity calculates available quantity for reclaimed_cores
+// by allocatable * overcommitRatio - reclaimedAllocated
+func (ns *NUMANodeState) GetReclaimedAvailableQuantity(reservedCPUs machine.CPUSet, overcommitRatio,
+	reclaimedAllocatedQuantity float64,
+) float64 {
+	if ns == nil {
+		return 0
+	}
+	return float64(ns.GetAvailableCPUSet(reservedCPUs).Size())*overcommitRatio - reclaimedAllocatedQuantity
+}
+
 // GetAvailableCPUQuantity calculates available quantity by allocatable - sum(requested)
 // It's used when allocating CPUs for shared_cores with numa_binding containers,
 // since pool size may be adjusted, and DefaultCPUSet & AllocatedCPUSet are calculated by pool size,
 // we should use allocationInfo.RequestQuantity to calculate available cpu quantity for candidate shared_cores with numa_binding container.
 func (ns *NUMANodeState) GetAvailableCPUQuantity(reservedCPUs machine.CPUSet) int {
+	return ns.GetAvailableCPUQuantityExcludingContainer(reservedCPUs, "", "")
+}
+
+// GetAvailableFullCoreQuantity returns the number of physical cores whose logical cpus are all available,
+// on SMT machines it can be less than GetAvailableCPUQuantity / CPUsPerCore since siblings of
+// allocated cpus are still counted as available logical cpus.
+func (ns *NUMANodeState) GetAvailableFullCoreQuantity(reservedCPUs machine.CPUSet, cpuDetails machine.CPUDetails) int {
+	return cpuDetails.FullCoresInCPUs(ns.GetAvailableCPUSet(reservedCPUs)).Size()
+}
+
+// GetAvailableCPUQuantityExcludingContainer is the same as GetAvailableCPUQuantity,
+// except that requested quantity of the given container isn't counted as allocated.
+// It's used when a container is resized, since its prior allocation will be replaced.
+func (ns *NUMANodeState) GetAvailableCPUQuantityExcludingContainer(reservedCPUs machine.CPUSet,
+	podUID, containerName string,
+) int {
 	if ns == nil {
 		return 0
 	}
 
-	allocatableQuantity := ns.GetFilteredDefaultCPUSet(nil, nil).Difference(reservedCPUs).Size()
+	allocatableQuantity := ns.GetFilteredDefaultCPUSet(nil, nil).DifferenceSize(reservedCPUs)
 	var preciseAllocatedQuantity float64 = 0
 
 	for _, containerEntries := range ns.PodEntries {
@@ -530,6 +723,8 @@ func (ns *NUMANodeState) GetAvailableCPUQuantity(reservedCPUs machine.CPUSet) in
 			if allocationInfo == nil ||
 				!CheckSharedNUMABinding(allocationInfo) {
 				continue
+			} else if allocationInfo.PodUid == podUID && allocationInfo.ContainerName == containerName {
+				continue
 			}
 
 			preciseAllocatedQuantity += allocationInfo.RequestQuantity
@@ -605,6 +800,24 @@ func (ns *NUMANodeState) SetAllocationInfo(podUID string, containerName string,
 	ns.PodEntries[podUID][containerName] = allocationInfo.Clone()
 }
 
+// GetCPUSetBreakdown returns how the given cpus of this numa are accounted
+func (ns *NUMANodeState) GetCPUSetBreakdown(numaCPUs, reservedCPUs, offlineCPUs machine.CPUSet) *CPUSetBreakdown {
+	breakdown := &CPUSetBreakdown{
+		Total:    numaCPUs.Clone(),
+		Reserved: numaCPUs.Intersection(reservedCPUs),
+	}
+	breakdown.Offline = numaCPUs.Intersection(offlineCPUs).Difference(breakdown.Reserved)
+
+	defaultCPUSet := machine.NewCPUSet()
+	if ns != nil {
+		defaultCPUSet = ns.DefaultCPUSet
+	}
+	unavailableCPUs := breakdown.Reserved.Union(breakdown.Offline)
+	breakdown.Available = numaCPUs.Intersection(defaultCPUSet).Difference(unavailableCPUs)
+	breakdown.Allocated = numaCPUs.Difference(unavailableCPUs).Difference(breakdown.Available)
+	return breakdown
+}
+
 // GetDefaultCPUSet returns default cpuset in this node
 func (nm NUMANodeMap) GetDefaultCPUSet() machine.CPUSet {
 	res := machine.NewCPUSet()
@@ -663,6 +876,19 @@ func (nm NUMANodeMap) GetFilteredNUMASetWithAnnotations(
 	return res
 }
 
+// GetCPUSetBreakdowns returns how cpus are accounted in each numa, it helps to find out why
+// available cpus are fewer than expected.
+func (nm NUMANodeMap) GetCPUSetBreakdowns(cpuDetails machine.CPUDetails,
+	reservedCPUs, offlineCPUs machine.CPUSet,
+) map[int]*CPUSetBreakdown {
+	breakdowns := make(map[int]*CPUSetBreakdown, len(nm))
+	for numaID, numaNodeState := range nm {
+		breakdowns[numaID] = numaNodeState.GetCPUSetBreakdown(cpuDetails.CPUsInNUMANodes(numaID),
+			reservedCPUs, offlineCPUs)
+	}
+	return breakdowns
+}
+
 func (nm NUMANodeMap) Clone() NUMANodeMap {
 	if nm == nil {
 		return nil
@@ -675,6 +901,21 @@ func (nm NUMANodeMap) Clone() NUMANodeMap {
 	return clone
 }
 
+// Equals returns true if the two NUMA node maps have equal states on each NUMA
+func (nm NUMANodeMap) Equals(nm2 NUMANodeMap) bool {
+	if len(nm) != len(nm2) {
+		return false
+	}
+
+	for node, ns := range nm {
+		ns2, ok := nm2[node]
+		if !ok || !ns.Equals(ns2) {
+			return false
+		}
+	}
+	return true
+}
+
 func (nm NUMANodeMap) String() string {
 	if nm == nil {
 		return ""
@@ -693,6 +934,7 @@ type reader interface {
 	GetMachineState() NUMANodeMap
 	GetPodEntries() PodEntries
 	GetAllocationInfo(podUID string, containerName string) *AllocationInfo
+	GetSharedNUMAPools() SharedNUMAPools
 }
 
 // writer is used to store information into local states,
@@ -701,9 +943,14 @@ type writer interface {
 	SetMachineState(numaNodeMap NUMANodeMap)
 	SetPodEntries(podEntries PodEntries)
 	SetAllocationInfo(podUID string, containerName string, allocationInfo *AllocationInfo)
+	// SetPodEntriesAndMachineState updates pod entries and machine state together,
+	// so that the state is persisted only once for both of them
+	SetPodEntriesAndMachineState(podEntries PodEntries, numaNodeMap NUMANodeMap)
 
 	Delete(podUID string, containerName string)
 	ClearState()
+	// StoreState persists current state if it's backed by storage (e.g. checkpoint)
+	StoreState() error
 }
 
 // State interface provides methods for tracking and setting pod assignments
@@ -19,8 +19,8 @@ package state
 import (
 	"fmt"
 	"path"
-	"reflect"
 	"sync"
+	"time"
 
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/kubelet/checkpointmanager"
@@ -41,12 +41,45 @@ type stateCheckpoint struct {
 	// when we add new properties to checkpoint,
 	// it will cause checkpoint corruption, and we should skip it
 	skipStateCorruption bool
+	// podEntriesRecoverer reconstructs pod entries from live sources if checkpoint fails to be parsed
+	podEntriesRecoverer PodEntriesRecoverer
+	// writeStatus records the result of the latest checkpoint write
+	writeStatus CheckpointWriteStatus
 }
 
-var _ State = &stateCheckpoint{}
+// CheckpointWriteStatus is the result of the latest checkpoint write
+type CheckpointWriteStatus struct {
+	LastWriteTime time.Time `json:"lastWriteTime"`
+	// LastWriteError is empty if the latest write succeeded
+	LastWriteError string `json:"lastWriteError,omitempty"`
+}
+
+// CheckpointWriteStatusGetter is implemented by states backed by checkpoint,
+// it's used to tell whether states can be persisted currently.
+type CheckpointWriteStatusGetter interface {
+	GetCheckpointWriteStatus() CheckpointWriteStatus
+}
+
+// PodEntriesRecoverer reconstructs pod entries from live sources (e.g. running pods and their cgroups),
+// it's used to recover state when checkpoint fails to be parsed
+type PodEntriesRecoverer func() (PodEntries, error)
+
+var (
+	_ State                       = &stateCheckpoint{}
+	_ CheckpointWriteStatusGetter = &stateCheckpoint{}
+)
 
 func NewCheckpointState(stateDir, checkpointName, policyName string,
 	topology *machine.CPUTopology, skipStateCorruption bool,
+) (State, error) {
+	return NewCheckpointStateWithRecoverer(stateDir, checkpointName, policyName, topology, skipStateCorruption, nil)
+}
+
+// NewCheckpointStateWithRecoverer is the same as NewCheckpointState, except that pod entries
+// are reconstructed by the given recoverer (if not nil) when checkpoint fails to be parsed,
+// and a fresh checkpoint is rewritten by them.
+func NewCheckpointStateWithRecoverer(stateDir, checkpointName, policyName string,
+	topology *machine.CPUTopology, skipStateCorruption bool, podEntriesRecoverer PodEntriesRecoverer,
 ) (State, error) {
 	checkpointManager, err := checkpointmanager.NewCheckpointManager(stateDir)
 	if err != nil {
@@ -59,6 +92,7 @@ func NewCheckpointState(stateDir, checkpointName, policyName string,
 		checkpointManager:   checkpointManager,
 		checkpointName:      checkpointName,
 		skipStateCorruption: skipStateCorruption,
+		podEntriesRecoverer: podEntriesRecoverer,
 	}
 
 	if err := sc.restoreState(topology); err != nil {
@@ -79,12 +113,29 @@ func (sc *stateCheckpoint) restoreState(topology *machine.CPUTopology) error {
 		if err == errors.ErrCheckpointNotFound {
 			return sc.storeState()
 		} else if err == errors.ErrCorruptCheckpoint {
+			// checkpoint with mismatched checksum can still be parsed (e.g. a field is added),
+			// so it's never discarded for recovery, and it's up to skipStateCorruption whether to use it
 			if !sc.skipStateCorruption {
 				return err
 			}
 
 			foundAndSkippedStateCorruption = true
 			klog.Warningf("[cpu_plugin] restore checkpoint failed with err: %s, but we skip it", err)
+		} else if sc.podEntriesRecoverer != nil {
+			klog.Errorf("[cpu_plugin] restore checkpoint failed with err: %s, "+
+				"RECOVER STATE FROM LIVE PODS AND CGROUPS, the checkpoint will be rewritten", err)
+
+			podEntries, recoverErr := sc.podEntriesRecoverer()
+			if recoverErr != nil {
+				return fmt.Errorf("recover pod entries failed with error: %v, restore checkpoint err: %v", recoverErr, err)
+			} else if podEntries == nil {
+				podEntries = make(PodEntries)
+			}
+
+			checkpoint = NewCPUPluginCheckpoint()
+			checkpoint.PolicyName = sc.policyName
+			checkpoint.PodEntries = podEntries
+			foundAndSkippedStateCorruption = true
 		} else {
 			return err
 		}
@@ -102,7 +153,7 @@ func (sc *stateCheckpoint) restoreState(topology *machine.CPUTopology) error {
 	sc.cache.SetMachineState(generatedMachineState)
 	sc.cache.SetPodEntries(checkpoint.PodEntries)
 
-	if !reflect.DeepEqual(generatedMachineState, checkpoint.MachineState) {
+	if !generatedMachineState.Equals(checkpoint.MachineState) {
 		klog.Warningf("[cpu_plugin] machine state changed: generatedMachineState: %s; checkpointMachineState: %s",
 			generatedMachineState.String(), checkpoint.MachineState.String())
 		err = sc.storeState()
@@ -130,13 +181,22 @@ func (sc *stateCheckpoint) storeState() error {
 	checkpoint.PodEntries = sc.cache.GetPodEntries()
 
 	err := sc.checkpointManager.CreateCheckpoint(sc.checkpointName, checkpoint)
+	sc.writeStatus = CheckpointWriteStatus{LastWriteTime: time.Now()}
 	if err != nil {
 		klog.ErrorS(err, "Could not save checkpoint")
+		sc.writeStatus.LastWriteError = err.Error()
 		return err
 	}
 	return nil
 }
 
+func (sc *stateCheckpoint) GetCheckpointWriteStatus() CheckpointWriteStatus {
+	sc.RLock()
+	defer sc.RUnlock()
+
+	return sc.writeStatus
+}
+
 func (sc *stateCheckpoint) GetMachineState() NUMANodeMap {
 	sc.RLock()
 	defer sc.RUnlock()
@@ -158,6 +218,13 @@ func (sc *stateCheckpoint) GetPodEntries() PodEntries {
 	return sc.cache.GetPodEntries()
 }
 
+func (sc *stateCheckpoint) GetSharedNUMAPools() SharedNUMAPools {
+	sc.RLock()
+	defer sc.RUnlock()
+
+	return sc.cache.GetSharedNUMAPools()
+}
+
 func (sc *stateCheckpoint) SetMachineState(numaNodeMap NUMANodeMap) {
 	sc.Lock()
 	defer sc.Unlock()
@@ -191,6 +258,17 @@ func (sc *stateCheckpoint) SetPodEntries(podEntries PodEntries) {
 	}
 }
 
+func (sc *stateCheckpoint) SetPodEntriesAndMachineState(podEntries PodEntries, numaNodeMap NUMANodeMap) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	sc.cache.SetPodEntriesAndMachineState(podEntries, numaNodeMap)
+	err := sc.storeState()
+	if err != nil {
+		klog.ErrorS(err, "[cpu_plugin] store pod entries and machineState to checkpoint error")
+	}
+}
+
 func (sc *stateCheckpoint) Delete(podUID string, containerName string) {
 	sc.Lock()
 	defer sc.Unlock()
@@ -212,3 +290,14 @@ func (sc *stateCheckpoint) ClearState() {
 		klog.ErrorS(err, "[cpu_plugin] store state after clear operation to checkpoint error")
 	}
 }
+
+func (sc *stateCheckpoint) StoreState() error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	err := sc.storeState()
+	if err != nil {
+		klog.ErrorS(err, "[cpu_plugin] store state to checkpoint error")
+	}
+	return err
+}
@@ -35,6 +35,9 @@ type cpuPluginState struct {
 	podEntries     PodEntries
 	machineState   NUMANodeMap
 	socketTopology map[int]string
+
+	// sharedNUMAPools is maintained along with podEntries in memory only
+	sharedNUMAPools SharedNUMAPools
 }
 
 var _ State = &cpuPluginState{}
@@ -58,10 +61,11 @@ func GetDefaultMachineState(topology *machine.CPUTopology) NUMANodeMap {
 func NewCPUPluginState(topology *machine.CPUTopology) State {
 	klog.InfoS("[cpu_plugin] initializing new cpu plugin in-memory state store")
 	return &cpuPluginState{
-		podEntries:     make(PodEntries),
-		machineState:   GetDefaultMachineState(topology),
-		socketTopology: topology.GetSocketTopology(),
-		cpuTopology:    topology,
+		podEntries:      make(PodEntries),
+		machineState:    GetDefaultMachineState(topology),
+		socketTopology:  topology.GetSocketTopology(),
+		cpuTopology:     topology,
+		sharedNUMAPools: make(SharedNUMAPools),
 	}
 }
 
@@ -89,6 +93,15 @@ func (s *cpuPluginState) GetPodEntries() PodEntries {
 	return s.podEntries.Clone()
 }
 
+// GetSharedNUMAPools returns a read-only view of shared pools, callers
+// must not modify pools in it, and should use Clone to get a modifiable copy.
+func (s *cpuPluginState) GetSharedNUMAPools() SharedNUMAPools {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.sharedNUMAPools.ShallowClone()
+}
+
 func (s *cpuPluginState) SetMachineState(numaNodeMap NUMANodeMap) {
 	s.Lock()
 	defer s.Unlock()
@@ -106,6 +119,9 @@ func (s *cpuPluginState) SetAllocationInfo(podUID string, containerName string,
 	}
 
 	s.podEntries[podUID][containerName] = allocationInfo.Clone()
+	if !s.podEntries[podUID].IsPoolEntry() {
+		s.sharedNUMAPools.Set(allocationInfo)
+	}
 	klog.InfoS("[cpu_plugin] updated cpu plugin pod entries",
 		"podUID", podUID,
 		"containerName", containerName,
@@ -117,10 +133,16 @@ func (s *cpuPluginState) SetPodEntries(podEntries PodEntries) {
 	defer s.Unlock()
 
 	s.podEntries = podEntries.Clone()
+	s.sharedNUMAPools = NewSharedNUMAPools(s.podEntries)
 	klog.InfoS("[cpu_plugin] Updated cpu plugin pod entries",
 		"podEntries", podEntries.String())
 }
 
+func (s *cpuPluginState) SetPodEntriesAndMachineState(podEntries PodEntries, numaNodeMap NUMANodeMap) {
+	s.SetPodEntries(podEntries)
+	s.SetMachineState(numaNodeMap)
+}
+
 func (s *cpuPluginState) Delete(podUID string, containerName string) {
 	s.Lock()
 	defer s.Unlock()
@@ -130,6 +152,7 @@ func (s *cpuPluginState) Delete(podUID string, containerName string) {
 	}
 
 	delete(s.podEntries[podUID], containerName)
+	s.sharedNUMAPools.Delete(podUID, containerName)
 	if len(s.podEntries[podUID]) == 0 {
 		delete(s.podEntries, podUID)
 	}
@@ -145,5 +168,11 @@ func (s *cpuPluginState) ClearState() {
 	s.machineState = GetDefaultMachineState(s.cpuTopology)
 	s.socketTopology = s.cpuTopology.GetSocketTopology()
 	s.podEntries = make(PodEntries)
+	s.sharedNUMAPools = make(SharedNUMAPools)
 	klog.V(2).InfoS("[cpu_plugin] cleared state")
 }
+
+// StoreState is a no-op since the state is kept in memory only
+func (s *cpuPluginState) StoreState() error {
+	return nil
+}
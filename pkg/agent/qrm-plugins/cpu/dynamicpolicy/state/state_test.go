@@ -1467,6 +1467,89 @@ func TestNewCheckpointState(t *testing.T) {
 	}
 }
 
+func TestNewCheckpointStateWithRecoverer(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	testingDir, err := ioutil.TempDir("", "TestNewCheckpointStateWithRecoverer")
+	as.Nil(err)
+	defer os.RemoveAll(testingDir)
+
+	cpm, err := checkpointmanager.NewCheckpointManager(testingDir)
+	as.Nil(err)
+
+	cpuset := machine.NewCPUSet(4, 5)
+	topologyAwareAssignments, err := machine.GetNumaAwareAssignments(cpuTopology, cpuset)
+	as.Nil(err)
+	recoveredPodEntries := PodEntries{
+		"pod-1": ContainerEntries{
+			"container-1": &AllocationInfo{
+				PodUid:                           "pod-1",
+				PodNamespace:                     "test",
+				PodName:                          "test",
+				ContainerName:                    "container-1",
+				ContainerType:                    pluginapi.ContainerType_MAIN.String(),
+				OwnerPoolName:                    PoolNameDedicated,
+				AllocationResult:                 cpuset,
+				OriginalAllocationResult:         cpuset.Clone(),
+				TopologyAwareAssignments:         topologyAwareAssignments,
+				OriginalTopologyAwareAssignments: machine.DeepcopyCPUAssignment(topologyAwareAssignments),
+				Annotations: map[string]string{
+					consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+				},
+				QoSLevel:        consts.PodAnnotationQoSLevelDedicatedCores,
+				RequestQuantity: 2,
+			},
+		},
+	}
+
+	// checkpoint fails to be parsed, recover it from live sources
+	as.Nil(cpm.CreateCheckpoint(cpuPluginStateFileName, &testutil.MockCheckpoint{Content: `{`}))
+
+	// fails to recover
+	_, err = NewCheckpointStateWithRecoverer(testingDir, cpuPluginStateFileName, policyName, cpuTopology, false,
+		func() (PodEntries, error) {
+			return nil, fmt.Errorf("fake error")
+		})
+	as.NotNil(err)
+
+	restoredState, err := NewCheckpointStateWithRecoverer(testingDir, cpuPluginStateFileName, policyName, cpuTopology,
+		false, func() (PodEntries, error) {
+			return recoveredPodEntries.Clone(), nil
+		})
+	as.Nil(err)
+	as.Equal(recoveredPodEntries, restoredState.GetPodEntries())
+	// cpu 4 and cpu 5 are in NUMA 2
+	as.True(restoredState.GetMachineState()[2].AllocatedCPUSet.Equals(cpuset))
+
+	// a fresh checkpoint is rewritten
+	rewrittenState, err := NewCheckpointState(testingDir, cpuPluginStateFileName, policyName, cpuTopology, false)
+	as.Nil(err)
+	assertStateEqual(t, rewrittenState, restoredState)
+
+	// checkpoint with mismatched checksum is still readable, so it's never recovered
+	corruptContent := `{"policyName":"dynamic","machineState":{},"pod_entries":{},"checksum":1}`
+	as.Nil(cpm.RemoveCheckpoint(cpuPluginStateFileName))
+	as.Nil(cpm.CreateCheckpoint(cpuPluginStateFileName, &testutil.MockCheckpoint{Content: corruptContent}))
+
+	_, err = NewCheckpointStateWithRecoverer(testingDir, cpuPluginStateFileName, policyName, cpuTopology,
+		false, func() (PodEntries, error) {
+			return recoveredPodEntries.Clone(), nil
+		})
+	as.NotNil(err)
+
+	skippedState, err := NewCheckpointStateWithRecoverer(testingDir, cpuPluginStateFileName, policyName, cpuTopology,
+		true, func() (PodEntries, error) {
+			return recoveredPodEntries.Clone(), nil
+		})
+	as.Nil(err)
+	as.Empty(skippedState.GetPodEntries())
+}
+
 func TestClearState(t *testing.T) {
 	t.Parallel()
 
@@ -2511,6 +2594,85 @@ func TestGetDefaultMachineState(t *testing.T) {
 	}
 }
 
+func TestNUMANodeMap_Equals(t *testing.T) {
+	t.Parallel()
+
+	generateMachineState := func(cpus machine.CPUSet, allocated machine.CPUSet) NUMANodeMap {
+		return NUMANodeMap{
+			0: &NUMANodeState{
+				DefaultCPUSet:   cpus,
+				AllocatedCPUSet: allocated,
+				PodEntries: PodEntries{
+					"pod": ContainerEntries{
+						"container": &AllocationInfo{
+							PodUid:                   "pod",
+							ContainerName:            "container",
+							AllocationResult:         cpus,
+							OriginalAllocationResult: cpus,
+							TopologyAwareAssignments: map[int]machine.CPUSet{
+								0: cpus,
+							},
+							OriginalTopologyAwareAssignments: map[int]machine.CPUSet{
+								0: cpus,
+								1: allocated,
+							},
+							QoSLevel: consts.PodAnnotationQoSLevelSharedCores,
+						},
+					},
+				},
+			},
+		}
+	}
+
+	testCases := []struct {
+		description string
+		a           NUMANodeMap
+		b           NUMANodeMap
+		expected    bool
+	}{
+		{
+			description: "cpusets built in different insertion orders",
+			a:           generateMachineState(machine.NewCPUSet(0, 1, 8, 9), machine.NewCPUSet()),
+			b:           generateMachineState(machine.NewCPUSet(9, 8, 1, 0), machine.NewCPUSet()),
+			expected:    true,
+		},
+		{
+			description: "uninitialized and initialized empty cpusets",
+			a:           generateMachineState(machine.NewCPUSet(0, 1), machine.CPUSet{}),
+			b:           generateMachineState(machine.NewCPUSet(1, 0), machine.NewCPUSet()),
+			expected:    true,
+		},
+		{
+			description: "different cpusets",
+			a:           generateMachineState(machine.NewCPUSet(0, 1), machine.NewCPUSet()),
+			b:           generateMachineState(machine.NewCPUSet(0, 2), machine.NewCPUSet()),
+			expected:    false,
+		},
+		{
+			description: "different NUMAs",
+			a:           generateMachineState(machine.NewCPUSet(0, 1), machine.NewCPUSet()),
+			b:           NUMANodeMap{},
+			expected:    false,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tc.expected, tc.a.Equals(tc.b))
+			require.Equal(t, tc.expected, tc.b.Equals(tc.a))
+		})
+	}
+
+	// fields other than cpusets are still compared
+	a := generateMachineState(machine.NewCPUSet(0, 1), machine.NewCPUSet())
+	b := a.Clone()
+	b[0].PodEntries["pod"]["container"].QoSLevel = consts.PodAnnotationQoSLevelDedicatedCores
+	require.False(t, a.Equals(b))
+}
+
 func TestGetSocketTopology(t *testing.T) {
 	t.Parallel()
 
@@ -3110,3 +3272,320 @@ func TestPodEntries_GetFilteredPoolsCPUSetMap(t *testing.T) {
 		})
 	}
 }
+
+func generateMixedQoSPodEntries() PodEntries {
+	generateAllocationInfo := func(podUID, qosLevel string, annotations map[string]string) *AllocationInfo {
+		allAnnotations := map[string]string{
+			consts.PodAnnotationQoSLevelKey: qosLevel,
+		}
+		for key, value := range annotations {
+			allAnnotations[key] = value
+		}
+
+		return &AllocationInfo{
+			PodUid:                   podUID,
+			PodNamespace:             podUID,
+			PodName:                  podUID,
+			ContainerName:            podUID,
+			ContainerType:            pluginapi.ContainerType_MAIN.String(),
+			AllocationResult:         machine.NewCPUSet(1),
+			OriginalAllocationResult: machine.NewCPUSet(1),
+			TopologyAwareAssignments: map[int]machine.CPUSet{
+				0: machine.NewCPUSet(1),
+			},
+			OriginalTopologyAwareAssignments: map[int]machine.CPUSet{
+				0: machine.NewCPUSet(1),
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: qosLevel,
+			},
+			Annotations:     allAnnotations,
+			QoSLevel:        qosLevel,
+			RequestQuantity: 1,
+		}
+	}
+
+	return PodEntries{
+		"shared": ContainerEntries{
+			"shared": generateAllocationInfo("shared", consts.PodAnnotationQoSLevelSharedCores, nil),
+		},
+		"shared-numa-binding": ContainerEntries{
+			"shared-numa-binding": generateAllocationInfo("shared-numa-binding", consts.PodAnnotationQoSLevelSharedCores,
+				map[string]string{
+					consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+				}),
+		},
+		"dedicated-numa-binding": ContainerEntries{
+			"dedicated-numa-binding": generateAllocationInfo("dedicated-numa-binding", consts.PodAnnotationQoSLevelDedicatedCores,
+				map[string]string{
+					consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+				}),
+		},
+		"reclaimed": ContainerEntries{
+			"reclaimed": generateAllocationInfo("reclaimed", consts.PodAnnotationQoSLevelReclaimedCores, nil),
+		},
+		PoolNameShare: ContainerEntries{
+			FakedContainerName: &AllocationInfo{
+				PodUid:                   PoolNameShare,
+				OwnerPoolName:            PoolNameShare,
+				AllocationResult:         machine.NewCPUSet(1),
+				OriginalAllocationResult: machine.NewCPUSet(1),
+			},
+		},
+	}
+}
+
+func TestPodEntries_FilterByQoSLevel(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		qosLevel string
+		wantPods sets.String
+	}{
+		{
+			name:     "filter shared_cores",
+			qosLevel: consts.PodAnnotationQoSLevelSharedCores,
+			wantPods: sets.NewString("shared", "shared-numa-binding"),
+		},
+		{
+			name:     "filter dedicated_cores",
+			qosLevel: consts.PodAnnotationQoSLevelDedicatedCores,
+			wantPods: sets.NewString("dedicated-numa-binding"),
+		},
+		{
+			name:     "filter reclaimed_cores",
+			qosLevel: consts.PodAnnotationQoSLevelReclaimedCores,
+			wantPods: sets.NewString("reclaimed"),
+		},
+		{
+			name:     "filter system_cores",
+			qosLevel: consts.PodAnnotationQoSLevelSystemCores,
+			wantPods: sets.NewString(),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			pe := generateMixedQoSPodEntries()
+			got := pe.FilterByQoSLevel(tt.qosLevel)
+
+			gotPods := sets.NewString()
+			for podUID, containerEntries := range got {
+				gotPods.Insert(podUID)
+				for containerName, allocationInfo := range containerEntries {
+					as.Equal(pe[podUID][containerName], allocationInfo)
+
+					// filtered entries must be deep-copied
+					allocationInfo.Annotations["test"] = "test"
+					allocationInfo.AllocationResult.Add(2)
+					as.NotContains(pe[podUID][containerName].Annotations, "test")
+					as.False(pe[podUID][containerName].AllocationResult.Contains(2))
+				}
+			}
+			as.Equal(tt.wantPods, gotPods)
+		})
+	}
+}
+
+func TestPodEntries_FilterByAnnotation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		key      string
+		value    string
+		wantPods sets.String
+	}{
+		{
+			name:     "filter numa_binding",
+			key:      consts.PodAnnotationMemoryEnhancementNumaBinding,
+			value:    consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+			wantPods: sets.NewString("shared-numa-binding", "dedicated-numa-binding"),
+		},
+		{
+			name:     "filter qos level",
+			key:      consts.PodAnnotationQoSLevelKey,
+			value:    consts.PodAnnotationQoSLevelReclaimedCores,
+			wantPods: sets.NewString("reclaimed"),
+		},
+		{
+			name:     "filter empty value of missing key",
+			key:      "not-exist",
+			value:    "",
+			wantPods: sets.NewString(),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			got := generateMixedQoSPodEntries().FilterByAnnotation(tt.key, tt.value)
+
+			gotPods := sets.NewString()
+			for podUID := range got {
+				gotPods.Insert(podUID)
+			}
+			as.Equal(tt.wantPods, gotPods)
+		})
+	}
+}
+
+func TestGetSharedNUMAPoolsView(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	newAllocationInfo := func(podUID string, numaID int, quantity float64) *AllocationInfo {
+		return &AllocationInfo{
+			PodUid:        podUID,
+			ContainerName: "test",
+			QoSLevel:      consts.PodAnnotationQoSLevelSharedCores,
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:                  consts.PodAnnotationQoSLevelSharedCores,
+				consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+			},
+			TopologyAwareAssignments: map[int]machine.CPUSet{numaID: cpuTopology.CPUDetails.CPUsInNUMANodes(numaID)},
+			RequestQuantity:          quantity,
+		}
+	}
+
+	st := NewCPUPluginState(cpuTopology)
+	st.SetAllocationInfo("pod1", "test", newAllocationInfo("pod1", 0, 2))
+	st.SetAllocationInfo("pod2", "test", newAllocationInfo("pod2", 1, 1))
+
+	view := st.GetSharedNUMAPools()
+	as.Equal(2, view[0].GetRequestedQuantity())
+	as.Equal(1, view[1].GetRequestedQuantity())
+
+	// views taken before are kept unchanged by later updates
+	st.SetAllocationInfo("pod3", "test", newAllocationInfo("pod3", 0, 1.5))
+	st.Delete("pod2", "test")
+	as.Equal(2, view[0].GetRequestedQuantity())
+	as.Equal(1, view[1].GetRequestedQuantity())
+	as.Nil(view[2])
+
+	latest := st.GetSharedNUMAPools()
+	as.Equal(4, latest[0].GetRequestedQuantity())
+	as.Equal(2, latest[0].GetMemberCount())
+	as.Equal(0, latest[1].GetMemberCount())
+	as.Equal(NewSharedNUMAPools(st.GetPodEntries())[0].GetRequestedQuantity(), latest[0].GetRequestedQuantity())
+}
+
+func TestReturnedCPUSetsDoNotAliasState(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	allocationResult := machine.NewCPUSet(1, 9)
+	topologyAwareAssignments := map[int]machine.CPUSet{0: allocationResult}
+	st := NewCPUPluginState(cpuTopology)
+	st.SetAllocationInfo("pod1", "test", &AllocationInfo{
+		PodUid:                           "pod1",
+		ContainerName:                    "test",
+		QoSLevel:                         consts.PodAnnotationQoSLevelDedicatedCores,
+		AllocationResult:                 allocationResult,
+		OriginalAllocationResult:         allocationResult,
+		TopologyAwareAssignments:         topologyAwareAssignments,
+		OriginalTopologyAwareAssignments: topologyAwareAssignments,
+	})
+
+	machineState, err := GenerateMachineStateFromPodEntries(cpuTopology, st.GetPodEntries(), policyName)
+	as.Nil(err)
+	st.SetMachineState(machineState)
+
+	expectedPodEntries := st.GetPodEntries().String()
+	expectedMachineState := st.GetMachineState().String()
+
+	// sets passed in aren't aliased by the state
+	allocationResult.Add(2)
+
+	// neither are sets returned by the state
+	allocationInfo := st.GetAllocationInfo("pod1", "test")
+	allocationInfo.AllocationResult.Add(3)
+	allocationInfo.OriginalAllocationResult.Add(3)
+	allocationInfo.TopologyAwareAssignments[0].Add(3)
+	allocationInfo.OriginalTopologyAwareAssignments[0].Add(3)
+	st.GetPodEntries()["pod1"]["test"].AllocationResult.Add(10)
+
+	returnedMachineState := st.GetMachineState()
+	returnedMachineState[0].AllocatedCPUSet.Add(10)
+	returnedMachineState[0].DefaultCPUSet.Add(11)
+	returnedMachineState[0].PodEntries["pod1"]["test"].AllocationResult.Add(10)
+
+	as.Equal(expectedPodEntries, st.GetPodEntries().String())
+	as.Equal(expectedMachineState, st.GetMachineState().String())
+	as.Equal([]int{1, 9}, st.GetAllocationInfo("pod1", "test").AllocationResult.ToSliceInt())
+}
+
+func TestNUMANodeMap_GetCPUSetBreakdowns(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	// cpus of NUMA 0: 0,1,8,9, cpus of NUMA 1: 2,3,10,11, cpus of NUMA 2: 4,5,12,13
+	machineState := NUMANodeMap{
+		0: {DefaultCPUSet: machine.NewCPUSet(0, 1, 8, 9), AllocatedCPUSet: machine.NewCPUSet()},
+		1: {DefaultCPUSet: machine.NewCPUSet(2, 3, 10, 11), AllocatedCPUSet: machine.NewCPUSet()},
+		2: {DefaultCPUSet: machine.NewCPUSet(12, 13), AllocatedCPUSet: machine.NewCPUSet(4, 5)},
+	}
+	// cpu 1 is both reserved and offline, it's only counted as reserved
+	reservedCPUs := machine.NewCPUSet(0, 1, 2)
+	offlineCPUs := machine.NewCPUSet(1, 9, 13)
+
+	expectedBreakdowns := map[int]*CPUSetBreakdown{
+		0: {
+			Total:     machine.NewCPUSet(0, 1, 8, 9),
+			Reserved:  machine.NewCPUSet(0, 1),
+			Offline:   machine.NewCPUSet(9),
+			Allocated: machine.NewCPUSet(),
+			Available: machine.NewCPUSet(8),
+		},
+		1: {
+			Total:     machine.NewCPUSet(2, 3, 10, 11),
+			Reserved:  machine.NewCPUSet(2),
+			Offline:   machine.NewCPUSet(),
+			Allocated: machine.NewCPUSet(),
+			Available: machine.NewCPUSet(3, 10, 11),
+		},
+		2: {
+			Total:     machine.NewCPUSet(4, 5, 12, 13),
+			Reserved:  machine.NewCPUSet(),
+			Offline:   machine.NewCPUSet(13),
+			Allocated: machine.NewCPUSet(4, 5),
+			Available: machine.NewCPUSet(12),
+		},
+	}
+
+	breakdowns := machineState.GetCPUSetBreakdowns(cpuTopology.CPUDetails, reservedCPUs, offlineCPUs)
+	as.Len(breakdowns, len(machineState))
+	for numaID, breakdown := range breakdowns {
+		expected := expectedBreakdowns[numaID]
+		as.True(expected.Total.Equals(breakdown.Total), "total of NUMA %d: %s", numaID, breakdown)
+		as.True(expected.Reserved.Equals(breakdown.Reserved), "reserved of NUMA %d: %s", numaID, breakdown)
+		as.True(expected.Offline.Equals(breakdown.Offline), "offline of NUMA %d: %s", numaID, breakdown)
+		as.True(expected.Allocated.Equals(breakdown.Allocated), "allocated of NUMA %d: %s", numaID, breakdown)
+		as.True(expected.Available.Equals(breakdown.Available), "available of NUMA %d: %s", numaID, breakdown)
+
+		// categories are disjoint and sum up to total
+		as.Equal(breakdown.Total.Size(), breakdown.Reserved.Size()+breakdown.Offline.Size()+
+			breakdown.Allocated.Size()+breakdown.Available.Size(), "NUMA %d: %s", numaID, breakdown)
+		as.True(breakdown.Total.Equals(breakdown.Reserved.Union(breakdown.Offline).
+			Union(breakdown.Allocated).Union(breakdown.Available)), "NUMA %d: %s", numaID, breakdown)
+
+		// available cpus in breakdown match the ones used by allocation
+		as.True(breakdown.Available.Equals(machineState[numaID].GetAvailableCPUSet(reservedCPUs.Union(offlineCPUs))),
+			"NUMA %d: %s", numaID, breakdown)
+	}
+}
@@ -175,13 +175,9 @@ func GetSharedQuantityMapFromPodEntries(podEntries PodEntries, ignoreAllocationI
 func GetNonBindingSharedRequestedQuantityFromPodEntries(podEntries PodEntries) int {
 	var reqFloat64 float64 = 0
 
-	for _, entries := range podEntries {
-		if entries.IsPoolEntry() {
-			continue
-		}
-
+	for _, entries := range podEntries.FilterByQoSLevel(apiconsts.PodAnnotationQoSLevelSharedCores) {
 		for _, allocationInfo := range entries {
-			if allocationInfo == nil || !CheckShared(allocationInfo) || CheckNUMABinding(allocationInfo) {
+			if CheckNUMABinding(allocationInfo) {
 				continue
 			}
 
@@ -226,13 +222,14 @@ func GenerateMachineStateFromPodEntries(topology *machine.CPUTopology, podEntrie
 					// only modify allocated and default properties in NUMA node state if the policy is dynamic and the entry indicates numa_binding.
 					// shared_cores with numa_binding also contributes to numaNodeState.AllocatedCPUSet,
 					// it's convenient that we can skip NUMA with AllocatedCPUSet > 0 when allocating CPUs for dedicated_cores with numa_binding.
+					// sidecars either share cpus with the main container or are isolated with their own cpus, so they're counted as well.
 					if CheckNUMABinding(allocationInfo) {
-						allocatedCPUsInNumaNode = allocatedCPUsInNumaNode.Union(allocationInfo.OriginalTopologyAwareAssignments[int(numaNode)])
+						allocatedCPUsInNumaNode = allocatedCPUsInNumaNode.Union(allocationInfo.OriginalTopologyAwareAssignments[int(numaNode)].Intersection(numaNodeAllCPUs))
 					}
 				case consts.CPUResourcePluginPolicyNameNative:
 					// only modify allocated and default properties in NUMA node state if the policy is native and the QoS class is Guaranteed
 					if CheckDedicatedPool(allocationInfo) {
-						allocatedCPUsInNumaNode = allocatedCPUsInNumaNode.Union(allocationInfo.OriginalTopologyAwareAssignments[int(numaNode)])
+						allocatedCPUsInNumaNode = allocatedCPUsInNumaNode.Union(allocationInfo.OriginalTopologyAwareAssignments[int(numaNode)].Intersection(numaNodeAllCPUs))
 					}
 				}
 
@@ -0,0 +1,220 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	apiconsts "github.com/kubewharf/katalyst-api/pkg/consts"
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/config/generic"
+	coreconsts "github.com/kubewharf/katalyst-core/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/pod"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+	"github.com/kubewharf/katalyst-core/pkg/util/native"
+)
+
+// newPodEntriesRecoverer returns a recoverer reconstructing pod entries by running pods and
+// the actual cpusets in their cgroups; share, reclaim and per-NUMA share pools are reconstructed
+// by the union of cpusets of containers in them, and all pools will be adjusted later by the policy as usual.
+func newPodEntriesRecoverer(podFetcher pod.PodFetcher, cpusetManager containerCPUSetManager,
+	qosConfig *generic.QoSConfiguration, topology *machine.CPUTopology,
+) state.PodEntriesRecoverer {
+	return func() (state.PodEntries, error) {
+		if podFetcher == nil || cpusetManager == nil {
+			return nil, fmt.Errorf("nil podFetcher or cpusetManager")
+		}
+
+		podList, err := podFetcher.GetPodList(context.Background(), native.PodIsActive)
+		if err != nil {
+			return nil, fmt.Errorf("get pod list failed with error: %v", err)
+		}
+
+		podEntries := make(state.PodEntries)
+		poolsCPUSet := make(map[string]machine.CPUSet)
+
+		for _, targetPod := range podList {
+			if targetPod == nil {
+				continue
+			}
+
+			qosLevel, err := qosConfig.GetQoSLevelForPod(targetPod)
+			if err != nil {
+				general.Errorf("get qos level of pod: %s/%s failed with error: %v",
+					targetPod.Namespace, targetPod.Name, err)
+				continue
+			}
+
+			podUID := string(targetPod.UID)
+			for i, container := range targetPod.Spec.Containers {
+				allocationInfo, err := recoverAllocationInfo(podFetcher, cpusetManager, qosConfig, topology,
+					targetPod, i, qosLevel)
+				if err != nil {
+					general.Errorf("recover pod: %s/%s, container: %s failed with error: %v",
+						targetPod.Namespace, targetPod.Name, container.Name, err)
+					continue
+				}
+
+				if podEntries[podUID] == nil {
+					podEntries[podUID] = make(state.ContainerEntries)
+				}
+				podEntries[podUID][container.Name] = allocationInfo
+
+				if !state.CheckDedicated(allocationInfo) &&
+					(!state.CheckNUMABinding(allocationInfo) || state.CheckSharedNUMABinding(allocationInfo)) {
+					poolsCPUSet[allocationInfo.OwnerPoolName] = allocationInfo.AllocationResult.Union(
+						poolsCPUSet[allocationInfo.OwnerPoolName])
+				}
+
+				general.Infof("recovered pod: %s/%s, container: %s, qosLevel: %s, cpuset: %s",
+					targetPod.Namespace, targetPod.Name, container.Name, qosLevel, allocationInfo.AllocationResult.String())
+			}
+		}
+
+		for poolName, cset := range poolsCPUSet {
+			if cset.IsEmpty() {
+				continue
+			}
+
+			topologyAwareAssignments, err := machine.GetNumaAwareAssignments(topology, cset)
+			if err != nil {
+				return nil, fmt.Errorf("unable to calculate topologyAwareAssignments for pool: %s, result cpuset: %s, error: %v",
+					poolName, cset.String(), err)
+			}
+
+			podEntries[poolName] = state.ContainerEntries{
+				state.FakedContainerName: &state.AllocationInfo{
+					PodUid:                           poolName,
+					OwnerPoolName:                    poolName,
+					AllocationResult:                 cset.Clone(),
+					OriginalAllocationResult:         cset.Clone(),
+					TopologyAwareAssignments:         topologyAwareAssignments,
+					OriginalTopologyAwareAssignments: machine.DeepcopyCPUAssignment(topologyAwareAssignments),
+				},
+			}
+		}
+
+		return podEntries, nil
+	}
+}
+
+// recoverAllocationInfo reconstructs allocationInfo of the container by its actual cpuset in cgroup
+func recoverAllocationInfo(podFetcher pod.PodFetcher, cpusetManager containerCPUSetManager,
+	qosConfig *generic.QoSConfiguration, topology *machine.CPUTopology, targetPod *v1.Pod,
+	containerIndex int, qosLevel string,
+) (*state.AllocationInfo, error) {
+	podUID, container := string(targetPod.UID), targetPod.Spec.Containers[containerIndex]
+
+	containerID, err := podFetcher.GetContainerID(podUID, container.Name)
+	if err != nil {
+		return nil, fmt.Errorf("get container id failed with error: %v", err)
+	}
+
+	cpuset, err := cpusetManager.GetCPUSet(podUID, containerID)
+	if err != nil {
+		return nil, fmt.Errorf("get cpuset failed with error: %v", err)
+	}
+
+	topologyAwareAssignments, err := machine.GetNumaAwareAssignments(topology, cpuset)
+	if err != nil {
+		return nil, fmt.Errorf("GetNumaAwareAssignments for cpuset: %s failed with error: %v", cpuset.String(), err)
+	}
+
+	requestQuantity := native.CPUQuantityGetter()(container.Resources.Requests)
+
+	// keep the same annotations and labels as the ones filtered in allocation
+	annotations := general.DeepCopyMap(targetPod.Annotations)
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[apiconsts.PodAnnotationQoSLevelKey] = qosLevel
+	labels := general.DeepCopyMap(targetPod.Labels)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[apiconsts.PodAnnotationQoSLevelKey] = qosLevel
+
+	allocationInfo := &state.AllocationInfo{
+		PodUid:                           podUID,
+		PodNamespace:                     targetPod.Namespace,
+		PodName:                          targetPod.Name,
+		ContainerName:                    container.Name,
+		ContainerType:                    getRecoveredContainerType(targetPod, containerIndex).String(),
+		ContainerIndex:                   uint64(containerIndex),
+		AllocationResult:                 cpuset,
+		OriginalAllocationResult:         cpuset.Clone(),
+		TopologyAwareAssignments:         topologyAwareAssignments,
+		OriginalTopologyAwareAssignments: machine.DeepcopyCPUAssignment(topologyAwareAssignments),
+		InitTimestamp:                    time.Now().Format(util.QRMTimeFormat),
+		Labels:                           qosConfig.FilterQoSMap(labels),
+		Annotations:                      qosConfig.FilterQoSAndEnhancementMap(annotations),
+		QoSLevel:                         qosLevel,
+		RequestQuantity:                  float64(requestQuantity.MilliValue()) / 1000,
+	}
+
+	switch qosLevel {
+	case apiconsts.PodAnnotationQoSLevelSharedCores, apiconsts.PodAnnotationQoSLevelReclaimedCores:
+		allocationInfo.OwnerPoolName = allocationInfo.GetSpecifiedPoolName()
+	case apiconsts.PodAnnotationQoSLevelDedicatedCores:
+		allocationInfo.OwnerPoolName = state.PoolNameDedicated
+	default:
+		return nil, fmt.Errorf("unsupported qosLevel: %s", qosLevel)
+	}
+
+	// numa_binding shared_cores containers are put into the per-NUMA pool of the NUMA they are bound to,
+	// and we refuse to guess the NUMA if the actual cpuset spans over multiple NUMAs
+	if state.CheckSharedNUMABinding(allocationInfo) {
+		numaSet := allocationInfo.GetAllocationResultNUMASet()
+		if numaSet.Size() != 1 {
+			return nil, fmt.Errorf("numa_binding shared_cores cpuset: %s isn't in a single NUMA", cpuset.String())
+		}
+		numaID := numaSet.ToSliceInt()[0]
+
+		allocationInfo.OwnerPoolName = state.GetNUMAPoolName(allocationInfo.GetSpecifiedPoolName(), uint64(numaID))
+		// only main container records NUMA hint, the same as in allocation
+		if allocationInfo.CheckMainContainer() {
+			allocationInfo.Annotations[cpuconsts.CPUStateAnnotationKeyNUMAHint] = fmt.Sprintf("%d", numaID)
+		}
+	}
+
+	return allocationInfo, nil
+}
+
+// getRecoveredContainerType returns the container type by the main container annotation
+// of the pod, and the first container is regarded as the main one if it's not specified.
+func getRecoveredContainerType(targetPod *v1.Pod, containerIndex int) pluginapi.ContainerType {
+	mainContainerName := targetPod.Annotations[coreconsts.MainContainerNameAnnotationKey]
+	if mainContainerName == "" {
+		if containerIndex == 0 {
+			return pluginapi.ContainerType_MAIN
+		}
+		return pluginapi.ContainerType_SIDECAR
+	}
+
+	if targetPod.Spec.Containers[containerIndex].Name == mainContainerName {
+		return pluginapi.ContainerType_MAIN
+	}
+	return pluginapi.ContainerType_SIDECAR
+}
@@ -17,14 +17,21 @@ limitations under the License.
 package dynamicpolicy
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"math"
+	"os"
+	"strings"
 
+	"k8s.io/apimachinery/pkg/util/sets"
 	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
 
 	apiconsts "github.com/kubewharf/katalyst-api/pkg/consts"
 	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	cgroupcm "github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	cgroupcmutils "github.com/kubewharf/katalyst-core/pkg/util/cgroup/manager"
 	"github.com/kubewharf/katalyst-core/pkg/util/general"
 	"github.com/kubewharf/katalyst-core/pkg/util/machine"
 )
@@ -37,10 +44,6 @@ func getProportionalSize(oldPoolSize, oldTotalSize, newTotalSize int, ceil bool)
 	}
 }
 
-func generateMachineStateFromPodEntries(topology *machine.CPUTopology, podEntries state.PodEntries) (state.NUMANodeMap, error) {
-	return state.GenerateMachineStateFromPodEntries(topology, podEntries, cpuconsts.CPUResourcePluginPolicyNameDynamic)
-}
-
 // updateAllocationInfoByReq updates allocationInfo by latest req when admitting active pod,
 // because qos level and annotations will change after we support customized updater of enhancements and qos level
 func updateAllocationInfoByReq(req *pluginapi.ResourceRequest, allocationInfo *state.AllocationInfo) error {
@@ -60,3 +63,122 @@ func updateAllocationInfoByReq(req *pluginapi.ResourceRequest, allocationInfo *s
 	allocationInfo.Annotations = general.DeepCopyMap(req.Annotations)
 	return nil
 }
+
+// enumeratedEnhancementValues are valid values of enhancement keys that should be enumerated,
+// keys out of it are passed through as they are.
+var enumeratedEnhancementValues = map[string]sets.String{
+	apiconsts.PodAnnotationMemoryEnhancementNumaBinding:   sets.NewString("true", "false"),
+	apiconsts.PodAnnotationMemoryEnhancementNumaExclusive: sets.NewString("true", "false"),
+	cpuconsts.PodAnnotationCPUNUMABindingSoft:             sets.NewString("true", "false"),
+	cpuconsts.PodAnnotationCPUFullPhysicalCores:           sets.NewString("true", "false"),
+	cpuconsts.PodAnnotationCPUSpreadCores:                 sets.NewString("true", "false"),
+	cpuconsts.PodAnnotationCPUL3CachePacking:              sets.NewString("true", "false"),
+	cpuconsts.PodAnnotationCPUSidecarCPUSetMode:           sets.NewString(cpuconsts.SidecarCPUSetModeShared, cpuconsts.SidecarCPUSetModeIsolated),
+	cpuconsts.PodAnnotationCPUNUMAExclusiveMode:           sets.NewString(cpuconsts.NUMAExclusiveModeNode, cpuconsts.NUMAExclusiveModeCore),
+	cpuconsts.PodAnnotationCPUCoreClass:                   sets.NewString(string(machine.CoreClassPerformance), string(machine.CoreClassEfficiency)),
+}
+
+// normalizeEnhancementAnnotations normalizes values of enumerated enhancement keys in (filtered) annotations in place,
+// i.e. values are trimmed and lower-cased, and keys with empty values are removed as if they aren't set;
+// error is returned if any value is unknown, rather than taking the default path silently.
+func normalizeEnhancementAnnotations(annotations map[string]string) error {
+	for _, key := range sets.StringKeySet(enumeratedEnhancementValues).List() {
+		value, found := annotations[key]
+		if !found {
+			continue
+		}
+
+		normalizedValue := strings.ToLower(strings.TrimSpace(value))
+		if normalizedValue == "" {
+			delete(annotations, key)
+			continue
+		} else if !enumeratedEnhancementValues[key].Has(normalizedValue) {
+			return fmt.Errorf("invalid value: %q of enhancement key: %s, valid values: %v",
+				value, key, enumeratedEnhancementValues[key].List())
+		}
+
+		annotations[key] = normalizedValue
+	}
+
+	return nil
+}
+
+// getAdmissionCorrelationID returns the id correlating all logs of a single admission of the container,
+// it's derived from the pod uid so that logs of hints and allocation can be joined together.
+func getAdmissionCorrelationID(req *pluginapi.ResourceRequest) string {
+	return fmt.Sprintf("cid=%s/%s", req.PodUid, req.ContainerName)
+}
+
+// withAdmissionLogger returns a copy of ctx carrying a logger prefixed by the correlation id of the request,
+// functions along the admission path should log by general.LoggerFromContext(ctx) instead of general.
+func withAdmissionLogger(ctx context.Context, req *pluginapi.ResourceRequest) context.Context {
+	return general.ContextWithLogger(ctx, general.LoggerWithPrefix(getAdmissionCorrelationID(req), general.LoggingPKGFull))
+}
+
+// loadOfflineCPUs parses offline cpus (in cpuset format) from the given file,
+// and returns an empty cpuset if the file doesn't exist or is empty.
+func loadOfflineCPUs(fileAbsPath string, allCPUs machine.CPUSet) (machine.CPUSet, error) {
+	content, err := ioutil.ReadFile(fileAbsPath)
+	if os.IsNotExist(err) {
+		return machine.NewCPUSet(), nil
+	} else if err != nil {
+		return machine.NewCPUSet(), fmt.Errorf("read file failed with error: %v", err)
+	}
+
+	offlineCPUs, err := machine.Parse(strings.TrimSpace(string(content)))
+	if err != nil {
+		return machine.NewCPUSet(), fmt.Errorf("parse offline cpus failed with error: %v", err)
+	} else if !offlineCPUs.IsSubsetOf(allCPUs) {
+		return machine.NewCPUSet(), fmt.Errorf("offline cpus: %s aren't subset of all cpus: %s",
+			offlineCPUs.String(), allCPUs.String())
+	}
+
+	return offlineCPUs, nil
+}
+
+// containerCPUSetManager is used to get and apply the actual cpuset of containers, all cpuset reads and writes
+// of containers by the policy go through it, so that they can be replaced by a fake one in tests
+type containerCPUSetManager interface {
+	GetCPUSet(podUID, containerID string) (machine.CPUSet, error)
+	ApplyCPUSet(podUID, containerID string, cpuset machine.CPUSet) error
+}
+
+// cgroupCPUSetManager gets and applies cpuset of containers by cgroup
+type cgroupCPUSetManager struct{}
+
+func (cgroupCPUSetManager) GetCPUSet(podUID, containerID string) (machine.CPUSet, error) {
+	cpuSetStats, err := cgroupcmutils.GetCPUSetForContainer(podUID, containerID)
+	if err != nil {
+		return machine.CPUSet{}, err
+	}
+	return machine.Parse(cpuSetStats.CPUs)
+}
+
+func (cgroupCPUSetManager) ApplyCPUSet(podUID, containerID string, cpuset machine.CPUSet) error {
+	return cgroupcmutils.ApplyCPUSetForContainer(podUID, containerID, &cgroupcm.CPUSetData{CPUs: cpuset.String()})
+}
+
+// containerCPUQuotaApplier is used to apply cpu quota of containers
+type containerCPUQuotaApplier interface {
+	ApplyCPUQuota(podUID, containerID string, cpuQuota int64, cpuPeriod uint64) error
+}
+
+// cgroupCPUQuotaApplier applies cpu quota of containers by cgroup
+type cgroupCPUQuotaApplier struct{}
+
+func (cgroupCPUQuotaApplier) ApplyCPUQuota(podUID, containerID string, cpuQuota int64, cpuPeriod uint64) error {
+	return cgroupcmutils.ApplyCPUForContainer(podUID, containerID, &cgroupcm.CPUData{CpuQuota: cpuQuota, CpuPeriod: cpuPeriod})
+}
+
+// containerCPUSharesApplier is used to apply cpu shares of containers
+type containerCPUSharesApplier interface {
+	ApplyCPUShares(podUID, containerID string, shares uint64) error
+}
+
+// cgroupCPUSharesApplier applies cpu shares of containers by cgroup,
+// shares are converted to cpu.weight in cgroup v2
+type cgroupCPUSharesApplier struct{}
+
+func (cgroupCPUSharesApplier) ApplyCPUShares(podUID, containerID string, shares uint64) error {
+	return cgroupcmutils.ApplyCPUForContainer(podUID, containerID, &cgroupcm.CPUData{Shares: shares})
+}
@@ -17,12 +17,20 @@ limitations under the License.
 package dynamicpolicy
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+	"k8s.io/utils/pointer"
 
 	apiconsts "github.com/kubewharf/katalyst-api/pkg/consts"
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
 )
 
 func Test_updateAllocationInfoByReq(t *testing.T) {
@@ -67,3 +75,150 @@ func Test_updateAllocationInfoByReq(t *testing.T) {
 		})
 	}
 }
+
+func Test_loadOfflineCPUs(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", "Test_loadOfflineCPUs")
+	if err != nil {
+		t.Fatalf("create tmp dir failed with error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	allCPUs := machine.MustParse("0-15")
+	tests := []struct {
+		name    string
+		content *string
+		want    machine.CPUSet
+		wantErr bool
+	}{
+		{
+			name: "file not exist",
+			want: machine.NewCPUSet(),
+		},
+		{
+			name:    "empty file",
+			content: pointer.String(" \n"),
+			want:    machine.NewCPUSet(),
+		},
+		{
+			name:    "valid cpus",
+			content: pointer.String("1,3-4\n"),
+			want:    machine.NewCPUSet(1, 3, 4),
+		},
+		{
+			name:    "malformed cpus",
+			content: pointer.String("1,a"),
+			want:    machine.NewCPUSet(),
+			wantErr: true,
+		},
+		{
+			name:    "cpus out of topology",
+			content: pointer.String("15-16"),
+			want:    machine.NewCPUSet(),
+			wantErr: true,
+		},
+	}
+	for i, tt := range tests {
+		fileAbsPath := filepath.Join(tmpDir, fmt.Sprintf("offline_cpus_%d", i))
+		if tt.content != nil {
+			if err := ioutil.WriteFile(fileAbsPath, []byte(*tt.content), 0o644); err != nil {
+				t.Fatalf("write file failed with error: %v", err)
+			}
+		}
+
+		got, err := loadOfflineCPUs(fileAbsPath, allCPUs)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: loadOfflineCPUs() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if !got.Equals(tt.want) {
+			t.Errorf("%s: loadOfflineCPUs() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_normalizeEnhancementAnnotations(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        map[string]string
+		wantErr     bool
+	}{
+		{
+			name: "valid values",
+			annotations: map[string]string{
+				apiconsts.PodAnnotationQoSLevelKey:                  apiconsts.PodAnnotationQoSLevelDedicatedCores,
+				apiconsts.PodAnnotationMemoryEnhancementNumaBinding: "true",
+				cpuconsts.PodAnnotationCPUSidecarCPUSetMode:         cpuconsts.SidecarCPUSetModeIsolated,
+			},
+			want: map[string]string{
+				apiconsts.PodAnnotationQoSLevelKey:                  apiconsts.PodAnnotationQoSLevelDedicatedCores,
+				apiconsts.PodAnnotationMemoryEnhancementNumaBinding: "true",
+				cpuconsts.PodAnnotationCPUSidecarCPUSetMode:         cpuconsts.SidecarCPUSetModeIsolated,
+			},
+		},
+		{
+			name: "values to be normalized",
+			annotations: map[string]string{
+				apiconsts.PodAnnotationMemoryEnhancementNumaBinding:   " True",
+				apiconsts.PodAnnotationMemoryEnhancementNumaExclusive: "FALSE ",
+			},
+			want: map[string]string{
+				apiconsts.PodAnnotationMemoryEnhancementNumaBinding:   "true",
+				apiconsts.PodAnnotationMemoryEnhancementNumaExclusive: "false",
+			},
+		},
+		{
+			name: "empty values",
+			annotations: map[string]string{
+				apiconsts.PodAnnotationMemoryEnhancementNumaBinding: "",
+				cpuconsts.PodAnnotationCPUNUMABindingSoft:           " ",
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "typo'd numa_binding value",
+			annotations: map[string]string{
+				apiconsts.PodAnnotationMemoryEnhancementNumaBinding: "ture",
+			},
+			wantErr: true,
+		},
+		{
+			name: "typo'd sidecar cpuset mode value",
+			annotations: map[string]string{
+				cpuconsts.PodAnnotationCPUSidecarCPUSetMode: "isolate",
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown keys are passed through",
+			annotations: map[string]string{
+				"unknown.key":                               " Anything",
+				cpuconsts.PodAnnotationCPUSharesWeight:      "2.0",
+				cpuconsts.PodAnnotationCPUNUMAConstraint:    "0-1",
+				cpuconsts.PodAnnotationCPUFullPhysicalCores: "true",
+			},
+			want: map[string]string{
+				"unknown.key":                               " Anything",
+				cpuconsts.PodAnnotationCPUSharesWeight:      "2.0",
+				cpuconsts.PodAnnotationCPUNUMAConstraint:    "0-1",
+				cpuconsts.PodAnnotationCPUFullPhysicalCores: "true",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := normalizeEnhancementAnnotations(tt.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("normalizeEnhancementAnnotations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(tt.annotations, tt.want) {
+				t.Errorf("normalizeEnhancementAnnotations() = %v, want %v", tt.annotations, tt.want)
+			}
+		})
+	}
+}
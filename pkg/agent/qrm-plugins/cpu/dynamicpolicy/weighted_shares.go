@@ -0,0 +1,188 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+	"sort"
+
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	coreconfig "github.com/kubewharf/katalyst-core/pkg/config"
+	dynamicconfig "github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
+)
+
+const (
+	// sharesPerCPU, minShares and maxShares are the same as kubelet uses to convert cpu requests to shares
+	sharesPerCPU uint64 = 1024
+	minShares    uint64 = 2
+	maxShares    uint64 = 262144
+)
+
+// getCPUSharesWeight returns the weight of the container parsed from its annotations,
+// 1 is returned if it's not set or invalid.
+func getCPUSharesWeight(allocationInfo *state.AllocationInfo) float64 {
+	weight, found, err := qosutil.ParseCPUSharesWeight(allocationInfo.Annotations)
+	if !found {
+		return 1
+	} else if err != nil {
+		general.Warningf("pod: %s/%s, container: %s has invalid cpu shares weight: %v, use 1 instead",
+			allocationInfo.PodNamespace, allocationInfo.PodName, allocationInfo.ContainerName, err)
+		return 1
+	}
+	return weight
+}
+
+// getSharedNUMABindingNUMA returns the NUMA that the numa_binding shared_cores container is bound to
+func getSharedNUMABindingNUMA(allocationInfo *state.AllocationInfo) (int, bool) {
+	if !state.CheckSharedNUMABinding(allocationInfo) {
+		return 0, false
+	}
+
+	numaSet, err := machine.Parse(allocationInfo.Annotations[cpuconsts.CPUStateAnnotationKeyNUMAHint])
+	if err != nil || numaSet.Size() != 1 {
+		return 0, false
+	}
+	return numaSet.ToSliceInt()[0], true
+}
+
+// calculateWeightedShares splits cpu shares of the NUMA among numa_binding shared_cores containers on it,
+// the total is the same as shares converted from the sum of their requests, and each container gets
+// the part in proportion to its request multiplied by its weight.
+func (p *DynamicPolicy) calculateWeightedShares(podEntries state.PodEntries, numaID int) map[string]map[string]uint64 {
+	weightedRequests := make(map[string]map[string]float64)
+	totalRequest, totalWeightedRequest := 0.0, 0.0
+	for podUID, containerEntries := range podEntries {
+		if containerEntries.IsPoolEntry() {
+			continue
+		}
+
+		for containerName, allocationInfo := range containerEntries {
+			if numa, ok := getSharedNUMABindingNUMA(allocationInfo); !ok || numa != numaID {
+				continue
+			}
+
+			request := p.getContainerRequestedCores(allocationInfo)
+			if weightedRequests[podUID] == nil {
+				weightedRequests[podUID] = make(map[string]float64)
+			}
+			weightedRequests[podUID][containerName] = request * getCPUSharesWeight(allocationInfo)
+			totalRequest += request
+			totalWeightedRequest += weightedRequests[podUID][containerName]
+		}
+	}
+
+	shares := make(map[string]map[string]uint64, len(weightedRequests))
+	for podUID, containerRequests := range weightedRequests {
+		shares[podUID] = make(map[string]uint64, len(containerRequests))
+		for containerName, weightedRequest := range containerRequests {
+			containerShares := minShares
+			if totalWeightedRequest > 0 {
+				containerShares = uint64(totalRequest * float64(sharesPerCPU) * weightedRequest / totalWeightedRequest)
+			}
+			shares[podUID][containerName] = general.MinUInt64(general.MaxUInt64(containerShares, minShares), maxShares)
+		}
+	}
+	return shares
+}
+
+// applyWeightedShares recomputes and applies cpu shares of numa_binding shared_cores containers on the given NUMAs,
+// it's best-effort and containers not running yet are skipped (they will be handled by syncWeightedShares).
+func (p *DynamicPolicy) applyWeightedShares(numaIDs []int) error {
+	if !p.enableWeightedShares || len(numaIDs) == 0 {
+		return nil
+	} else if p.metaServer == nil {
+		return fmt.Errorf("nil metaServer")
+	}
+
+	podEntries := p.state.GetPodEntries()
+	var errList []error
+	for _, numaID := range numaIDs {
+		shares := p.calculateWeightedShares(podEntries, numaID)
+
+		podUIDs := make([]string, 0, len(shares))
+		for podUID := range shares {
+			podUIDs = append(podUIDs, podUID)
+		}
+		sort.Strings(podUIDs)
+
+		for _, podUID := range podUIDs {
+			for containerName, containerShares := range shares[podUID] {
+				containerID, err := p.metaServer.GetContainerID(podUID, containerName)
+				if err != nil {
+					general.Warningf("get container id of pod: %s, container: %s failed with error: %v, skip applying cpu shares",
+						podUID, containerName, err)
+					continue
+				}
+
+				err = p.cpuSharesApplier.ApplyCPUShares(podUID, containerID, containerShares)
+				if err != nil {
+					errList = append(errList, fmt.Errorf("apply cpu shares: %d for pod: %s, container: %s failed with error: %v",
+						containerShares, podUID, containerName, err))
+					continue
+				}
+
+				general.Infof("pod: %s, container: %s on NUMA: %d applied weighted cpu shares: %d",
+					podUID, containerName, numaID, containerShares)
+			}
+		}
+	}
+
+	if len(errList) > 0 {
+		return fmt.Errorf("apply weighted cpu shares failed with errors: %v", errList)
+	}
+	return nil
+}
+
+// getSharedNUMABindingNUMAsOfPod returns NUMAs that numa_binding shared_cores containers of the pod are bound to
+func getSharedNUMABindingNUMAsOfPod(containerEntries state.ContainerEntries) []int {
+	numaSet := machine.NewCPUSet()
+	for _, allocationInfo := range containerEntries {
+		if numa, ok := getSharedNUMABindingNUMA(allocationInfo); ok {
+			numaSet.Add(numa)
+		}
+	}
+	return numaSet.ToSliceInt()
+}
+
+// syncWeightedShares periodically applies weighted cpu shares to numa_binding shared_cores containers
+// on all NUMAs, so that containers not running during admission are covered too.
+func (p *DynamicPolicy) syncWeightedShares(_ *coreconfig.Configuration,
+	_ interface{},
+	_ *dynamicconfig.DynamicAgentConfiguration,
+	_ metrics.MetricEmitter,
+	_ *metaserver.MetaServer,
+) {
+	general.Infof("exec syncWeightedShares")
+	var err error
+	defer func() {
+		_ = general.UpdateHealthzStateByError(cpuconsts.SyncWeightedShares, err)
+	}()
+
+	p.RLock()
+	defer p.RUnlock()
+
+	err = p.applyWeightedShares(p.machineInfo.CPUDetails.NUMANodes().ToSliceInt())
+	if err != nil {
+		general.Errorf("applyWeightedShares failed with error: %v", err)
+	}
+}
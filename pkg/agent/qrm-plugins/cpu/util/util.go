@@ -63,7 +63,14 @@ func GetCoresReservedForSystem(conf *config.Configuration, metaServer *metaserve
 		general.Infof("get reservedQuantityInt: %d from ReservedCPUCores configuration", reservedQuantityInt)
 	}
 
-	reservedCPUs, _, reserveErr := calculator.TakeHTByNUMABalance(machineInfo, allCPUs, reservedQuantityInt)
+	var reservedCPUs machine.CPUSet
+	var reserveErr error
+	if conf.ReserveCPUsByFullCores {
+		// reserved cpus are taken by whole cores, so that workloads never share a core with them
+		reservedCPUs, _, reserveErr = calculator.TakeFullCoresByNUMABalance(machineInfo, allCPUs, reservedQuantityInt)
+	} else {
+		reservedCPUs, _, reserveErr = calculator.TakeHTByNUMABalance(machineInfo, allCPUs, reservedQuantityInt)
+	}
 	if reserveErr != nil {
 		return reservedCPUs, fmt.Errorf("takeByNUMABalance for reservedCPUsNum: %d failed with error: %v",
 			reservedQuantityInt, reserveErr)
@@ -114,6 +114,31 @@ func TestGetCoresReservedForSystem(t *testing.T) {
 			want:    machine.NewCPUSet(0, 2, 4, 6),
 			wantErr: false,
 		},
+		{
+			name: "GetCoresReservedForSystem rounded up to full cores",
+			args: args{
+				allCPUs: topology.CPUDetails.CPUs(),
+				conf: &config.Configuration{
+					AgentConfiguration: &agent.AgentConfiguration{
+						GenericAgentConfiguration: &agent.GenericAgentConfiguration{
+							GenericQRMPluginConfiguration: &qrm.GenericQRMPluginConfiguration{},
+						},
+						StaticAgentConfiguration: &agent.StaticAgentConfiguration{
+							QRMPluginsConfiguration: &qrm.QRMPluginsConfiguration{
+								CPUQRMPluginConfig: &qrm.CPUQRMPluginConfig{
+									ReservedCPUCores:       5,
+									ReserveCPUsByFullCores: true,
+								},
+							},
+						},
+					},
+				},
+				metaServer:  &metaserver.MetaServer{},
+				machineInfo: machineInfo,
+			},
+			want:    machine.NewCPUSet(0, 2, 4, 8, 10, 12),
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -132,6 +157,57 @@ func TestGetCoresReservedForSystem(t *testing.T) {
 	}
 }
 
+func TestGetCoresReservedForSystemByFullCores(t *testing.T) {
+	t.Parallel()
+
+	topology, err := machine.GenerateDummyCPUTopology(32, 2, 4)
+	assert.Nil(t, err)
+	machineInfo := &machine.KatalystMachineInfo{
+		CPUTopology: topology,
+	}
+
+	for reservedCPUCores := 1; reservedCPUCores <= topology.NumCPUs; reservedCPUCores++ {
+		conf := &config.Configuration{
+			AgentConfiguration: &agent.AgentConfiguration{
+				GenericAgentConfiguration: &agent.GenericAgentConfiguration{
+					GenericQRMPluginConfiguration: &qrm.GenericQRMPluginConfiguration{},
+				},
+				StaticAgentConfiguration: &agent.StaticAgentConfiguration{
+					QRMPluginsConfiguration: &qrm.QRMPluginsConfiguration{
+						CPUQRMPluginConfig: &qrm.CPUQRMPluginConfig{
+							ReservedCPUCores:       reservedCPUCores,
+							ReserveCPUsByFullCores: true,
+						},
+					},
+				},
+			},
+		}
+
+		reservedCPUs, err := GetCoresReservedForSystem(conf, &metaserver.MetaServer{}, machineInfo,
+			topology.CPUDetails.CPUs())
+		assert.Nil(t, err)
+
+		// reserved cpus consist of whole cores
+		fullCores := topology.CPUDetails.FullCoresInCPUs(reservedCPUs)
+		assert.True(t, topology.CPUDetails.CPUsInCores(fullCores.ToSliceInt()...).Equals(reservedCPUs),
+			"reserved: %d, cpus: %s", reservedCPUCores, reservedCPUs.String())
+		assert.Equal(t, (reservedCPUCores+1)/2*2, reservedCPUs.Size())
+
+		// reserved cores are balanced among NUMAs
+		minCores, maxCores := topology.NumCores, 0
+		for _, numaID := range topology.CPUDetails.NUMANodes().ToSliceInt() {
+			cores := topology.CPUDetails.CoresInNUMANodes(numaID).Intersection(fullCores).Size()
+			if cores < minCores {
+				minCores = cores
+			}
+			if cores > maxCores {
+				maxCores = cores
+			}
+		}
+		assert.LessOrEqual(t, maxCores-minCores, 1, "reserved: %d, cpus: %s", reservedCPUCores, reservedCPUs.String())
+	}
+}
+
 func TestRegenerateHints(t *testing.T) {
 	t.Parallel()
 
@@ -23,6 +23,10 @@ import (
 const (
 	MemoryResourcePluginPolicyNameDynamic = string(apiconsts.ResourcePluginPolicyNameDynamic)
 
+	// MemoryPluginStateFileName is the checkpoint name of memory plugin, other plugins sharing
+	// the state file directory may read allocations of memory plugin from it
+	MemoryPluginStateFileName = "memory_plugin_state"
+
 	MemoryPluginDynamicPolicyName = "qrm_memory_plugin_" + MemoryResourcePluginPolicyNameDynamic
 	ClearResidualState            = MemoryPluginDynamicPolicyName + "_clear_residual_state"
 	CheckMemSet                   = MemoryPluginDynamicPolicyName + "_check_mem_set"
@@ -33,3 +37,16 @@ const (
 	CommunicateWithAdvisor        = MemoryPluginDynamicPolicyName + "_communicate_with_advisor"
 	DropCache                     = MemoryPluginDynamicPolicyName + "_drop_cache"
 )
+
+const (
+	// PodAnnotationMemoryEnhancementNumaInterleave is the memory enhancement key (eg. "true") for numa_binding
+	// containers to interleave memory across all NUMA nodes in the hint (the NUMA mask aligned with cpu
+	// allocation by topology manager) rather than to fill them one by one.
+	PodAnnotationMemoryEnhancementNumaInterleave       = "numa_interleave"
+	PodAnnotationMemoryEnhancementNumaInterleaveEnable = "true"
+
+	// ResourceAllocationAnnotationKeyNUMAInterleaveNodes is the key of memory allocation annotations
+	// to indicate NUMA nodes (eg. "0-1") that the runtime should set interleave mempolicy across
+	// for the container.
+	ResourceAllocationAnnotationKeyNUMAInterleaveNodes = "katalyst.kubewharf.io/numa_interleave_nodes"
+)
@@ -0,0 +1,116 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	memconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/memory/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/memory/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
+)
+
+// annotationsIndicateNUMAInterleave returns true if the numa_binding container
+// asks for memory interleaved across NUMA nodes in its hint
+func annotationsIndicateNUMAInterleave(annotations map[string]string) bool {
+	return qosutil.AnnotationsIndicateNUMABinding(annotations) &&
+		annotations[memconsts.PodAnnotationMemoryEnhancementNumaInterleave] ==
+			memconsts.PodAnnotationMemoryEnhancementNumaInterleaveEnable
+}
+
+// getInterleavedQuantities splits the request evenly into the given NUMA nodes,
+// and the remainder is assigned to the leading NUMA nodes byte by byte.
+func getInterleavedQuantities(numaNodes []int, reqQuantity uint64) map[int]uint64 {
+	quantities := make(map[int]uint64, len(numaNodes))
+	if len(numaNodes) == 0 {
+		return quantities
+	}
+
+	share := reqQuantity / uint64(len(numaNodes))
+	remainder := reqQuantity % uint64(len(numaNodes))
+	for i, numaNode := range numaNodes {
+		quantities[numaNode] = share
+		if uint64(i) < remainder {
+			quantities[numaNode]++
+		}
+	}
+	return quantities
+}
+
+// calculateInterleavedMemoryInNumaNodes allocates the request evenly in all NUMA nodes of the list,
+// so that all of them are in the allocation result of the container and its memory can be interleaved
+// across them; machineState is updated in-place only if every NUMA node can hold its share.
+func calculateInterleavedMemoryInNumaNodes(req *pluginapi.ResourceRequest,
+	machineState state.NUMANodeMap, numaNodes []int,
+	reqQuantity uint64, qosLevel string,
+) (leftQuantity uint64, err error) {
+	quantities := getInterleavedQuantities(numaNodes, reqQuantity)
+	for _, numaNode := range numaNodes {
+		numaNodeState := machineState[numaNode]
+		if numaNodeState == nil {
+			return reqQuantity, fmt.Errorf("NUMA: %d has nil state", numaNode)
+		} else if numaNodeState.Free < quantities[numaNode] {
+			general.Warningf("NUMA: %d free: %d bytes can't hold interleaved share: %d bytes",
+				numaNode, numaNodeState.Free, quantities[numaNode])
+			return reqQuantity, nil
+		}
+	}
+
+	for _, numaNode := range numaNodes {
+		curNumaNodeAllocated := quantities[numaNode]
+		if curNumaNodeAllocated == 0 {
+			continue
+		}
+
+		numaNodeState := machineState[numaNode]
+		numaNodeState.Free -= curNumaNodeAllocated
+		numaNodeState.Allocated += curNumaNodeAllocated
+
+		if numaNodeState.PodEntries == nil {
+			numaNodeState.PodEntries = make(state.PodEntries)
+		}
+
+		if numaNodeState.PodEntries[req.PodUid] == nil {
+			numaNodeState.PodEntries[req.PodUid] = make(state.ContainerEntries)
+		}
+
+		numaNodeState.PodEntries[req.PodUid][req.ContainerName] = &state.AllocationInfo{
+			PodUid:               req.PodUid,
+			PodNamespace:         req.PodNamespace,
+			PodName:              req.PodName,
+			ContainerName:        req.ContainerName,
+			ContainerType:        req.ContainerType.String(),
+			ContainerIndex:       req.ContainerIndex,
+			PodRole:              req.PodRole,
+			PodType:              req.PodType,
+			AggregatedQuantity:   curNumaNodeAllocated,
+			NumaAllocationResult: machine.NewCPUSet(numaNode),
+			TopologyAwareAllocations: map[int]uint64{
+				numaNode: curNumaNodeAllocated,
+			},
+			Labels:      general.DeepCopyMap(req.Labels),
+			Annotations: general.DeepCopyMap(req.Annotations),
+			QoSLevel:    qosLevel,
+		}
+	}
+
+	return 0, nil
+}
@@ -58,7 +58,7 @@ import (
 const (
 	MemoryResourcePluginPolicyNameDynamic = string(apiconsts.ResourcePluginPolicyNameDynamic)
 
-	memoryPluginStateFileName                    = "memory_plugin_state"
+	memoryPluginStateFileName                    = memconsts.MemoryPluginStateFileName
 	memoryPluginAsyncWorkersName                 = "qrm_memory_plugin_async_workers"
 	memoryPluginAsyncWorkTopicDropCache          = "qrm_memory_plugin_drop_cache"
 	memoryPluginAsyncWorkTopicSetExtraCGMemLimit = "qrm_memory_plugin_set_extra_mem_limit"
@@ -131,8 +131,10 @@ type DynamicPolicy struct {
 	hintHandlers        map[string]util.HintHandler
 	enhancementHandlers util.ResourceEnhancementHandlerMap
 
-	extraStateFileAbsPath string
-	name                  string
+	extraStateFileAbsPaths    []string
+	extraStateFileReader      util.ExtraStateFileReader
+	extraStateFileReadTimeout time.Duration
+	name                      string
 
 	podDebugAnnoKeys []string
 
@@ -200,7 +202,9 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 		migratingMemory:            make(map[string]map[string]bool),
 		residualHitMap:             make(map[string]int64),
 		enhancementHandlers:        make(util.ResourceEnhancementHandlerMap),
-		extraStateFileAbsPath:      conf.ExtraStateFileAbsPath,
+		extraStateFileAbsPaths:     conf.ExtraStateFileAbsPaths,
+		extraStateFileReader:       util.DefaultExtraStateFileReader,
+		extraStateFileReadTimeout:  conf.ExtraStateFileReadTimeout,
 		name:                       fmt.Sprintf("%s_%s", agentName, memconsts.MemoryResourcePluginPolicyNameDynamic),
 		podDebugAnnoKeys:           conf.PodDebugAnnoKeys,
 		asyncWorkers:               asyncworker.NewAsyncWorkers(memoryPluginAsyncWorkersName, wrappedEmitter),
@@ -852,6 +856,7 @@ func (p *DynamicPolicy) Allocate(ctx context.Context,
 						IsScalarResource:  true,
 						AllocatedQuantity: float64(allocationInfo.AggregatedQuantity),
 						AllocationResult:  allocationInfo.NumaAllocationResult.String(),
+						Annotations:       allocationInfo.GetNUMAInterleaveAnnotations(),
 					},
 				},
 			},
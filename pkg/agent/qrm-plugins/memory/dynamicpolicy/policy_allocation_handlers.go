@@ -513,6 +513,7 @@ func (p *DynamicPolicy) calculateMemoryAllocation(req *pluginapi.ResourceRequest
 		return fmt.Errorf("hint is empty")
 	} else if qosutil.AnnotationsIndicateNUMABinding(req.Annotations) &&
 		!qosutil.AnnotationsIndicateNUMAExclusive(req.Annotations) &&
+		!annotationsIndicateNUMAInterleave(req.Annotations) &&
 		len(req.Hint.Nodes) > 1 {
 		return fmt.Errorf("NUMA not exclusive binding container has request larger than 1 NUMA")
 	}
@@ -537,6 +538,11 @@ func (p *DynamicPolicy) calculateMemoryAllocation(req *pluginapi.ResourceRequest
 		if err != nil {
 			return fmt.Errorf("calculateExclusiveMemory failed with error: %v", err)
 		}
+	} else if annotationsIndicateNUMAInterleave(req.Annotations) {
+		leftQuantity, err = calculateInterleavedMemoryInNumaNodes(req, machineState, hintNumaNodes.ToSliceInt(), uint64(memoryReq), qosLevel)
+		if err != nil {
+			return fmt.Errorf("calculateInterleavedMemoryInNumaNodes failed with error: %v", err)
+		}
 	} else {
 		leftQuantity, err = calculateMemoryInNumaNodes(req, machineState, hintNumaNodes.ToSliceInt(), uint64(memoryReq), qosLevel)
 		if err != nil {
@@ -702,6 +708,7 @@ func packAllocationResponse(allocationInfo *state.AllocationInfo, req *pluginapi
 					IsScalarResource:  true,
 					AllocatedQuantity: float64(allocationInfo.AggregatedQuantity),
 					AllocationResult:  allocationInfo.NumaAllocationResult.String(),
+					Annotations:       allocationInfo.GetNUMAInterleaveAnnotations(),
 					ResourceHints: &pluginapi.ListOfTopologyHints{
 						Hints: []*pluginapi.TopologyHint{
 							req.Hint,
@@ -28,6 +28,7 @@ import (
 	apiconsts "github.com/kubewharf/katalyst-api/pkg/consts"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/memory/dynamicpolicy/state"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
 	"github.com/kubewharf/katalyst-core/pkg/util/general"
 	"github.com/kubewharf/katalyst-core/pkg/util/machine"
 	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
@@ -120,9 +121,14 @@ func (p *DynamicPolicy) numaBindingHintHandler(_ context.Context,
 		availableNUMAs := resourcesMachineState[v1.ResourceMemory].GetNUMANodesWithoutNUMABindingPods()
 
 		var extraErr error
-		hints, extraErr = util.GetHintsFromExtraStateFile(req.PodName, string(v1.ResourceMemory),
-			p.extraStateFileAbsPath, availableNUMAs)
-		if extraErr != nil {
+		hints, extraErr = util.GetHintsFromExtraStateFilesWithTimeout(p.extraStateFileReader, p.extraStateFileReadTimeout,
+			p.emitter, req.PodName, string(v1.ResourceMemory), p.extraStateFileAbsPaths, availableNUMAs)
+		if extraErr == util.ErrExtraStateFileReadTimeout {
+			general.Warningf("pod: %s/%s, container: %s read extra state file timeout, fallback to calculate hints",
+				req.PodNamespace, req.PodName, req.ContainerName)
+			_ = p.emitter.StoreInt64(util.MetricNameExtraStateFileReadTimeout, 1, metrics.MetricTypeNameCount,
+				metrics.MetricTag{Key: "resourceName", Val: string(v1.ResourceMemory)})
+		} else if extraErr != nil {
 			general.Infof("pod: %s/%s, container: %s GetHintsFromExtraStateFile failed with error: %v",
 				req.PodNamespace, req.PodName, req.ContainerName, extraErr)
 		}
@@ -182,10 +188,12 @@ func (p *DynamicPolicy) calculateHints(reqInt uint64, resourcesMachineState stat
 	}
 
 	// because it's hard to control memory allocation accurately,
-	// we only support numa_binding but not exclusive container with request smaller than 1 NUMA
+	// we only support numa_binding but not exclusive container with request smaller than 1 NUMA,
+	// unless its memory is interleaved across NUMA nodes evenly.
+	interleave := annotationsIndicateNUMAInterleave(reqAnnotations)
 	if qosutil.AnnotationsIndicateNUMABinding(reqAnnotations) &&
 		!qosutil.AnnotationsIndicateNUMAExclusive(reqAnnotations) &&
-		minNUMAsCountNeeded > 1 {
+		!interleave && minNUMAsCountNeeded > 1 {
 		return nil, fmt.Errorf("NUMA not exclusive binding container has request larger than 1 NUMA")
 	}
 
@@ -200,7 +208,7 @@ func (p *DynamicPolicy) calculateHints(reqInt uint64, resourcesMachineState stat
 			return
 		} else if qosutil.AnnotationsIndicateNUMABinding(reqAnnotations) &&
 			!qosutil.AnnotationsIndicateNUMAExclusive(reqAnnotations) &&
-			maskCount > 1 {
+			!interleave && maskCount > 1 {
 			// because it's hard to control memory allocation accurately,
 			// we only support numa_binding but not exclusive container with request smaller than 1 NUMA
 			return
@@ -209,6 +217,11 @@ func (p *DynamicPolicy) calculateHints(reqInt uint64, resourcesMachineState stat
 		maskBits := mask.GetBits()
 		numaCountNeeded := mask.Count()
 
+		var interleavedQuantities map[int]uint64
+		if interleave && !qosutil.AnnotationsIndicateNUMAExclusive(reqAnnotations) {
+			interleavedQuantities = getInterleavedQuantities(maskBits, reqInt)
+		}
+
 		var freeBytesInMask uint64 = 0
 		for _, nodeID := range maskBits {
 			if machineState[nodeID] == nil {
@@ -218,6 +231,10 @@ func (p *DynamicPolicy) calculateHints(reqInt uint64, resourcesMachineState stat
 				general.Warningf("numa_exclusive container skip mask: %s with NUMA: %d allocated: %d",
 					mask.String(), nodeID, machineState[nodeID].Allocated)
 				return
+			} else if interleavedQuantities != nil && machineState[nodeID].Free < interleavedQuantities[nodeID] {
+				general.InfofV(4, "interleaved container skip mask: %s with NUMA: %d free: %d smaller than share: %d",
+					mask.String(), nodeID, machineState[nodeID].Free, interleavedQuantities[nodeID])
+				return
 			}
 
 			freeBytesInMask += machineState[nodeID].Free
@@ -3570,3 +3570,88 @@ func TestDynamicPolicy_adjustAllocationEntries(t *testing.T) {
 		})
 	}
 }
+
+func TestNUMAInterleaveAllocation(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	machineInfo, err := machine.GenerateDummyMachineInfo(4, 32)
+	as.Nil(err)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestNUMAInterleaveAllocation")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, machineInfo, tmpDir)
+	as.Nil(err)
+
+	// the cpu allocation of the container spans NUMA 0 and NUMA 1, and topology manager
+	// passes the aligned NUMA mask as the hint of memory allocation
+	cpuNUMAs := cpuTopology.CPUDetails.KeepOnly(machine.NewCPUSet(0, 1, 2, 3)).NUMANodes()
+	as.Equal(machine.NewCPUSet(0, 1), cpuNUMAs)
+
+	testName := "test"
+	var memoryReq float64 = 10 * 1024 * 1024 * 1024
+	newReq := func() *pluginapi.ResourceRequest {
+		return &pluginapi.ResourceRequest{
+			PodUid:         string(uuid.NewUUID()),
+			PodNamespace:   testName,
+			PodName:        testName,
+			ContainerName:  testName,
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceMemory),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceMemory): memoryReq,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_interleave": "true"}`,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		}
+	}
+
+	// the request is larger than 1 NUMA, multi-NUMA hints are given since memory is interleaved evenly
+	hintsResp, err := dynamicPolicy.GetTopologyHints(context.Background(), newReq())
+	as.Nil(err)
+	found := false
+	for _, hint := range hintsResp.ResourceHints[string(v1.ResourceMemory)].Hints {
+		as.Greater(len(hint.Nodes), 1)
+		if machine.NewCPUSet(util.HintToIntArray(hint)...).Equals(cpuNUMAs) {
+			found = true
+		}
+	}
+	as.True(found)
+
+	req := newReq()
+	req.Hint = &pluginapi.TopologyHint{Preferred: true}
+	for _, numaID := range cpuNUMAs.ToSliceInt() {
+		req.Hint.Nodes = append(req.Hint.Nodes, uint64(numaID))
+	}
+	resp, err := dynamicPolicy.Allocate(context.Background(), req)
+	as.Nil(err)
+
+	allocationInfo := resp.AllocationResult.ResourceAllocation[string(v1.ResourceMemory)]
+	as.NotNil(allocationInfo)
+	as.Equal(cpuNUMAs.String(), allocationInfo.AllocationResult)
+	as.Equal(map[string]string{
+		memconsts.ResourceAllocationAnnotationKeyNUMAInterleaveNodes: cpuNUMAs.String(),
+	}, allocationInfo.Annotations)
+
+	// memory is split evenly across NUMA nodes in the mask
+	stateAllocationInfo := dynamicPolicy.state.GetAllocationInfo(v1.ResourceMemory, req.PodUid, req.ContainerName)
+	as.NotNil(stateAllocationInfo)
+	as.Equal(map[int]uint64{0: uint64(memoryReq) / 2, 1: uint64(memoryReq) / 2}, stateAllocationInfo.TopologyAwareAllocations)
+
+	// the interleave annotation is kept when kubelet gets allocation from the plugin
+	allocationResp, err := dynamicPolicy.GetResourcesAllocation(context.Background(), &pluginapi.GetResourcesAllocationRequest{})
+	as.Nil(err)
+	as.Equal(cpuNUMAs.String(), allocationResp.PodResources[req.PodUid].ContainerResources[req.ContainerName].
+		ResourceAllocation[string(v1.ResourceMemory)].Annotations[memconsts.ResourceAllocationAnnotationKeyNUMAInterleaveNodes])
+}
@@ -27,6 +27,7 @@ import (
 
 	"github.com/kubewharf/katalyst-api/pkg/consts"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/commonstate"
+	memconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/memory/consts"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
 	"github.com/kubewharf/katalyst-core/pkg/util/general"
 	"github.com/kubewharf/katalyst-core/pkg/util/machine"
@@ -136,6 +137,26 @@ func (ai *AllocationInfo) CheckNumaBinding() bool {
 		consts.PodAnnotationMemoryEnhancementNumaBindingEnable
 }
 
+// CheckNUMAInterleave returns true if the AllocationInfo is for numa_binding container
+// whose memory is interleaved across its allocated NUMA nodes
+func (ai *AllocationInfo) CheckNUMAInterleave() bool {
+	return ai.CheckNumaBinding() &&
+		ai.Annotations[memconsts.PodAnnotationMemoryEnhancementNumaInterleave] ==
+			memconsts.PodAnnotationMemoryEnhancementNumaInterleaveEnable
+}
+
+// GetNUMAInterleaveAnnotations returns annotations of the memory allocation to indicate the runtime
+// to interleave memory across allocated NUMA nodes, nil is returned if the container isn't interleaved.
+func (ai *AllocationInfo) GetNUMAInterleaveAnnotations() map[string]string {
+	if ai == nil || !ai.CheckNUMAInterleave() || ai.NumaAllocationResult.IsEmpty() {
+		return nil
+	}
+
+	return map[string]string{
+		memconsts.ResourceAllocationAnnotationKeyNUMAInterleaveNodes: ai.NumaAllocationResult.String(),
+	}
+}
+
 // CheckMainContainer returns true if the AllocationInfo is for main container
 func (ai *AllocationInfo) CheckMainContainer() bool {
 	return ai.ContainerType == pluginapi.ContainerType_MAIN.String()
@@ -161,6 +182,7 @@ func (ai *AllocationInfo) GetResourceAllocation() (*pluginapi.ResourceAllocation
 				IsScalarResource:  true,
 				AllocatedQuantity: float64(ai.AggregatedQuantity),
 				AllocationResult:  ai.NumaAllocationResult.String(),
+				Annotations:       ai.GetNUMAInterleaveAnnotations(),
 			},
 		},
 	}
@@ -27,12 +27,29 @@ const (
 	MetricNameHandleAdvisorRespFailed = "handle_advisor_resp_failed"
 	MetricNameAdvisorUnhealthy        = "advisor_unhealthy"
 
+	MetricNameExtraStateFileReadTimeout = "extra_state_file_read_timeout"
+	MetricNameExtraStateFileMalformed   = "extra_state_file_malformed"
+	MetricNameExtraStateFileHintHit     = "extra_state_file_hint_hit"
+	MetricNameExtraStateFileHintMiss    = "extra_state_file_hint_miss"
+
 	// metrics for cpu plugin
 	MetricNamePoolSize         = "pool_size"
 	MetricNameRealStateInvalid = "real_state_invalid"
 	MetricNameCPUSetInvalid    = "cpuset_invalid"
 	MetricNameCPUSetOverlap    = "cpuset_overlap"
 	MetricNameOrphanContainer  = "orphan_container"
+	MetricNameCPUSetDrift      = "cpuset_drift"
+
+	MetricNameClearContainerAndRecompute = "clear_container_and_recompute"
+	MetricNameGenerateMachineStateRetry  = "generate_machine_state_retry"
+	MetricNameAllocationWithMissingCPUs  = "allocation_with_missing_cpus"
+	MetricNameEvictReclaimedToFreeNUMA   = "evict_reclaimed_to_free_numa"
+	MetricNameDisplaceSharedToFreeNUMA   = "displace_shared_to_free_numa"
+	MetricNamePatchPlacementFailed       = "patch_placement_annotations_failed"
+	MetricNameAllocationEventDropped     = "allocation_event_dropped"
+	MetricNamePodNUMAMigration           = "pod_numa_migration"
+	MetricNameSidecarNUMABindingMismatch = "sidecar_numa_binding_mismatch"
+	MetricNameUnknownContainerType       = "unknown_container_type"
 
 	// metrics for memory plugin
 	MetricNameMemSetInvalid                           = "memset_invalid"
@@ -18,6 +18,8 @@ package util
 
 import (
 	"context"
+	"errors"
+	"io/ioutil"
 
 	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
 
@@ -57,3 +59,21 @@ func (r ResourceEnhancementHandlerMap) Register(
 
 	r[phase][enhancementKey] = handler
 }
+
+// ErrExtraStateFileReadTimeout is returned if reading extra state file doesn't finish in time
+var ErrExtraStateFileReadTimeout = errors.New("read extra state file timeout")
+
+// ExtraStateFileReader is used to read the content of extra state file,
+// it's abstracted as an interface to make reading behaviors injectable
+type ExtraStateFileReader interface {
+	ReadFile(fileAbsPath string) ([]byte, error)
+}
+
+type osExtraStateFileReader struct{}
+
+func (osExtraStateFileReader) ReadFile(fileAbsPath string) ([]byte, error) {
+	return ioutil.ReadFile(fileAbsPath)
+}
+
+// DefaultExtraStateFileReader reads extra state file from the local filesystem
+var DefaultExtraStateFileReader ExtraStateFileReader = osExtraStateFileReader{}
@@ -18,27 +18,78 @@ package util
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"math"
+	"os"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
 	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
 
 	apiconsts "github.com/kubewharf/katalyst-api/pkg/consts"
 	"github.com/kubewharf/katalyst-core/pkg/config/generic"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
 	"github.com/kubewharf/katalyst-core/pkg/util/asyncworker"
 	"github.com/kubewharf/katalyst-core/pkg/util/general"
 	"github.com/kubewharf/katalyst-core/pkg/util/machine"
 )
 
+var (
+	cpuResourceNameAliasesLock sync.RWMutex
+	cpuResourceNameAliases     = sets.NewString()
+)
+
+// GetCPUResourceNameAliases returns extended resource names treated as cpu
+func GetCPUResourceNameAliases() sets.String {
+	cpuResourceNameAliasesLock.RLock()
+	defer cpuResourceNameAliasesLock.RUnlock()
+	return sets.NewString(cpuResourceNameAliases.UnsortedList()...)
+}
+
+// SetCPUResourceNameAliases sets extended resource names treated as cpu
+func SetCPUResourceNameAliases(aliases []string) {
+	cpuResourceNameAliasesLock.Lock()
+	defer cpuResourceNameAliasesLock.Unlock()
+	cpuResourceNameAliases = sets.NewString(aliases...)
+}
+
 // GetQuantityFromResourceReq parses resources quantity into value,
 // since pods with reclaimed_cores and un-reclaimed_cores have different
 // representations, we may to adapt to both cases.
 func GetQuantityFromResourceReq(req *pluginapi.ResourceRequest) (int, float64, error) {
+	return GetQuantityFromResourceReqWithAliases(req, GetCPUResourceNameAliases())
+}
+
+// GetQuantityFromResourceReqWithAliases is the same as GetQuantityFromResourceReq,
+// except that quantities of resources in cpuAliases are treated as cpu and summed up;
+// it's conflicting if cpu aliases co-exist with other resources in the request.
+func GetQuantityFromResourceReqWithAliases(req *pluginapi.ResourceRequest, cpuAliases sets.String) (int, float64, error) {
+	var aliasQuantity float64
+	aliasNames, otherNames := make([]string, 0), make([]string, 0)
+	for key, quantity := range req.ResourceRequests {
+		if cpuAliases.Has(key) {
+			aliasQuantity += quantity
+			aliasNames = append(aliasNames, key)
+		} else {
+			otherNames = append(otherNames, key)
+		}
+	}
+
+	if len(aliasNames) > 0 {
+		if len(otherNames) > 0 {
+			sort.Strings(aliasNames)
+			sort.Strings(otherNames)
+			return 0, 0, fmt.Errorf("conflicting cpu resources: %v with cpu aliases: %v", otherNames, aliasNames)
+		}
+		return general.Max(int(math.Ceil(aliasQuantity)), 0), aliasQuantity, nil
+	}
+
 	if len(req.ResourceRequests) != 1 {
 		return 0, 0, fmt.Errorf("invalid req.ResourceRequests length: %d", len(req.ResourceRequests))
 	}
@@ -188,6 +239,68 @@ func PackResourceHintsResponse(req *pluginapi.ResourceRequest, resourceName stri
 	}, nil
 }
 
+// MirrorResourceHints copies hints of resourceName in the response to mirrorResourceName,
+// so that plugins aligning to a pseudo resource (eg. numa-affinity) can converge with them.
+// it takes no effect if mirrorResourceName is empty or equals to resourceName.
+func MirrorResourceHints(resp *pluginapi.ResourceHintsResponse, resourceName, mirrorResourceName string) {
+	if resp == nil || resp.ResourceHints == nil || mirrorResourceName == "" || mirrorResourceName == resourceName {
+		return
+	}
+
+	hints, ok := resp.ResourceHints[resourceName]
+	if !ok {
+		return
+	} else if hints == nil {
+		// nil hints indicates that there is no numa preference
+		resp.ResourceHints[mirrorResourceName] = nil
+		return
+	}
+
+	mirroredHints := &pluginapi.ListOfTopologyHints{
+		Hints: make([]*pluginapi.TopologyHint, 0, len(hints.Hints)),
+	}
+	for _, hint := range hints.Hints {
+		if hint == nil {
+			continue
+		}
+
+		mirroredHints.Hints = append(mirroredHints.Hints, &pluginapi.TopologyHint{
+			Nodes:     append([]uint64{}, hint.Nodes...),
+			Preferred: hint.Preferred,
+		})
+	}
+	resp.ResourceHints[mirrorResourceName] = mirroredHints
+}
+
+// DedupeResourceHints collapses hints of resourceName in the response with identical NUMA node sets
+// into the first one of them, which is preferred if any of the duplicates is preferred.
+func DedupeResourceHints(resp *pluginapi.ResourceHintsResponse, resourceName string) {
+	if resp == nil || resp.ResourceHints[resourceName] == nil {
+		return
+	}
+
+	hints := resp.ResourceHints[resourceName].Hints
+	dedupedHints := make([]*pluginapi.TopologyHint, 0, len(hints))
+	hintIndexes := make(map[string]int, len(hints))
+	for _, hint := range hints {
+		if hint == nil {
+			continue
+		}
+
+		nodes := append([]uint64{}, hint.Nodes...)
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+		key := fmt.Sprint(nodes)
+		if index, ok := hintIndexes[key]; ok {
+			dedupedHints[index].Preferred = dedupedHints[index].Preferred || hint.Preferred
+			continue
+		}
+
+		hintIndexes[key] = len(dedupedHints)
+		dedupedHints = append(dedupedHints, hint)
+	}
+	resp.ResourceHints[resourceName].Hints = dedupedHints
+}
+
 // GetNUMANodesCountToFitCPUReq is used to calculate the amount of numa nodes
 // we need if we try to allocate cpu cores among them, assuming that all numa nodes
 // contain the same cpu capacity
@@ -217,6 +330,50 @@ func GetNUMANodesCountToFitCPUReq(cpuReq int, cpuTopology *machine.CPUTopology)
 	return numaCountNeeded, cpusCountNeededPerNUMA, nil
 }
 
+// GetNUMANodesCountToFitCPUReqWithCapacity is used to calculate the amount of numa nodes
+// we need if we try to allocate cpu cores among them, taking the effective capacity of
+// each numa node (e.g. excluding reserved cpus) into account; numa nodes with larger
+// capacity are assumed to be chosen first, so the result is the realistic minimum.
+func GetNUMANodesCountToFitCPUReqWithCapacity(cpuReq int, cpuTopology *machine.CPUTopology,
+	numaCapacityGetter func(numaID int) int,
+) (int, int, error) {
+	if numaCapacityGetter == nil {
+		return GetNUMANodesCountToFitCPUReq(cpuReq, cpuTopology)
+	} else if cpuTopology == nil {
+		return 0, 0, fmt.Errorf("GetNUMANodesCountToFitCPUReqWithCapacity got nil cpuTopology")
+	}
+
+	numaNodes := cpuTopology.CPUDetails.NUMANodes().ToSliceInt()
+	if len(numaNodes) == 0 {
+		return 0, 0, fmt.Errorf("there is no NUMA in cpuTopology")
+	} else if cpuReq <= 0 {
+		return 0, 0, fmt.Errorf("zero numaCountNeeded")
+	}
+
+	capacities := make([]int, 0, len(numaNodes))
+	for _, numaID := range numaNodes {
+		capacities = append(capacities, general.Max(numaCapacityGetter(numaID), 0))
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(capacities)))
+
+	numaCountNeeded, capacitySum := 0, 0
+	for _, capacity := range capacities {
+		if capacitySum >= cpuReq {
+			break
+		}
+		capacitySum += capacity
+		numaCountNeeded++
+	}
+
+	if capacitySum < cpuReq {
+		return 0, 0, fmt.Errorf("invalid cpu req: %d in topology with NUMAs count: %d and total capacity: %d",
+			cpuReq, len(numaNodes), capacitySum)
+	}
+
+	cpusCountNeededPerNUMA := int(math.Ceil(float64(cpuReq) / float64(numaCountNeeded)))
+	return numaCountNeeded, cpusCountNeededPerNUMA, nil
+}
+
 // GetNUMANodesCountToFitMemoryReq is used to calculate the amount of numa nodes
 // we need if we try to allocate memory among them, assuming that all numa nodes
 // contain the same memory capacity
@@ -252,14 +409,132 @@ func GetNUMANodesCountToFitMemoryReq(memoryReq, bytesPerNUMA uint64, numaCount i
 */
 func GetHintsFromExtraStateFile(podName, resourceName, extraHintsStateFileAbsPath string,
 	availableNUMAs machine.CPUSet,
+) (map[string]*pluginapi.ListOfTopologyHints, error) {
+	return GetHintsFromExtraStateFileWithTimeout(DefaultExtraStateFileReader, 0,
+		podName, resourceName, extraHintsStateFileAbsPath, availableNUMAs)
+}
+
+// GetHintsFromExtraStateFileWithTimeout is the same as GetHintsFromExtraStateFile, except that
+// the file is read by the given reader, and ErrExtraStateFileReadTimeout is returned if reading
+// doesn't finish within timeout (non-positive timeout means waiting until reading finishes).
+func GetHintsFromExtraStateFileWithTimeout(reader ExtraStateFileReader, timeout time.Duration,
+	podName, resourceName, extraHintsStateFileAbsPath string, availableNUMAs machine.CPUSet,
 ) (map[string]*pluginapi.ListOfTopologyHints, error) {
 	if extraHintsStateFileAbsPath == "" {
 		return nil, nil
 	}
 
-	fileBytes, err := ioutil.ReadFile(extraHintsStateFileAbsPath)
+	if reader == nil {
+		reader = DefaultExtraStateFileReader
+	}
+
+	memoryEntries, err := loadExtraStateFileMemoryEntries(reader, timeout, extraHintsStateFileAbsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	extraPodName := fmt.Sprintf("%s-0", podName)
+	if memoryEntries[extraPodName] == nil {
+		return nil, fmt.Errorf("extra state file hasn't memory entry for pod: %s", extraPodName)
+	}
+
+	numaSet, err := parseExtraStateFileMemoryEntry(memoryEntries[extraPodName])
+	if err != nil {
+		return nil, err
+	}
+	return packExtraStateFileHints(podName, resourceName, numaSet, availableNUMAs)
+}
+
+// GetHintsFromExtraStateFilesWithTimeout is the same as GetHintsFromExtraStateFileWithTimeout, except that
+// entries of multiple extra state files are merged by precedence, i.e. if a pod is specified in several files,
+// the entry in the earlier file wins. malformed files (or entries) are skipped and counted by the emitter,
+// while files that don't exist are skipped silently. hints found in files are counted as hits by the emitter,
+// and misses are counted with the reason, i.e. the pod has no entry, or its entry isn't within available NUMAs.
+func GetHintsFromExtraStateFilesWithTimeout(reader ExtraStateFileReader, timeout time.Duration,
+	emitter metrics.MetricEmitter, podName, resourceName string, extraHintsStateFileAbsPaths []string,
+	availableNUMAs machine.CPUSet,
+) (map[string]*pluginapi.ListOfTopologyHints, error) {
+	if len(extraHintsStateFileAbsPaths) == 0 {
+		return nil, nil
+	}
+
+	if reader == nil {
+		reader = DefaultExtraStateFileReader
+	}
+
+	emitMalformed := func(fileAbsPath string, err error) {
+		klog.Warningf("[GetHintsFromExtraStateFiles] skip malformed extra state file: %s, error: %v", fileAbsPath, err)
+		if emitter != nil {
+			_ = emitter.StoreInt64(MetricNameExtraStateFileMalformed, 1, metrics.MetricTypeNameCount,
+				metrics.MetricTag{Key: "resourceName", Val: resourceName},
+				metrics.MetricTag{Key: "file", Val: fileAbsPath})
+		}
+	}
+
+	emitHint := func(key string, tags ...metrics.MetricTag) {
+		if emitter != nil {
+			_ = emitter.StoreInt64(key, 1, metrics.MetricTypeNameCount,
+				append([]metrics.MetricTag{{Key: "resourceName", Val: resourceName}}, tags...)...)
+		}
+	}
+
+	extraPodName := fmt.Sprintf("%s-0", podName)
+	var numaSet *machine.CPUSet
+	for _, fileAbsPath := range extraHintsStateFileAbsPaths {
+		memoryEntries, err := loadExtraStateFileMemoryEntries(reader, timeout, fileAbsPath)
+		if err == ErrExtraStateFileReadTimeout {
+			// give up all files, since lower-precedence files mustn't override the one timed out
+			return nil, err
+		} else if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			emitMalformed(fileAbsPath, err)
+			continue
+		} else if memoryEntries[extraPodName] == nil {
+			continue
+		}
+
+		fileNUMASet, err := parseExtraStateFileMemoryEntry(memoryEntries[extraPodName])
+		if err != nil {
+			emitMalformed(fileAbsPath, err)
+			continue
+		}
+
+		if numaSet == nil {
+			numaSet = &fileNUMASet
+		} else if !numaSet.Equals(fileNUMASet) {
+			klog.InfoS("[GetHintsFromExtraStateFiles] ignore conflicting entry in extra state file of lower precedence",
+				"podName", podName,
+				"file", fileAbsPath,
+				"entry", fileNUMASet.String(),
+				"effectiveEntry", numaSet.String())
+		}
+	}
+
+	if numaSet == nil {
+		emitHint(MetricNameExtraStateFileHintMiss, metrics.MetricTag{Key: "reason", Val: "no_entry"})
+		return nil, fmt.Errorf("extra state files haven't memory entry for pod: %s", extraPodName)
+	}
+
+	hints, err := packExtraStateFileHints(podName, resourceName, *numaSet, availableNUMAs)
 	if err != nil {
-		return nil, fmt.Errorf("read extra hints state file failed with error: %v", err)
+		emitHint(MetricNameExtraStateFileHintMiss, metrics.MetricTag{Key: "reason", Val: "unavailable_numas"})
+		return nil, err
+	}
+
+	emitHint(MetricNameExtraStateFileHintHit)
+	return hints, nil
+}
+
+// loadExtraStateFileMemoryEntries reads the extra state file and returns its memory entries
+func loadExtraStateFileMemoryEntries(reader ExtraStateFileReader, timeout time.Duration,
+	fileAbsPath string,
+) (map[string]interface{}, error) {
+	fileBytes, err := readExtraStateFile(reader, timeout, fileAbsPath)
+	if err == ErrExtraStateFileReadTimeout {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("read extra hints state file failed with error: %w", err)
 	}
 
 	extraState := make(map[string]interface{})
@@ -272,22 +547,26 @@ func GetHintsFromExtraStateFile(podName, resourceName, extraHintsStateFileAbsPat
 	if !typeOk {
 		return nil, fmt.Errorf("memory entries with invalid type: %T", extraState["memoryEntries"])
 	}
+	return memoryEntries, nil
+}
 
-	extraPodName := fmt.Sprintf("%s-0", podName)
-	if memoryEntries[extraPodName] == nil {
-		return nil, fmt.Errorf("extra state file hasn't memory entry for pod: %s", extraPodName)
-	}
-
-	memoryEntry, typeOk := memoryEntries[extraPodName].(string)
+// parseExtraStateFileMemoryEntry parses NUMAs in the memory entry of extra state file
+func parseExtraStateFileMemoryEntry(entry interface{}) (machine.CPUSet, error) {
+	memoryEntry, typeOk := entry.(string)
 	if !typeOk {
-		return nil, fmt.Errorf("memory entry with invalid type: %T", memoryEntries[extraPodName])
+		return machine.CPUSet{}, fmt.Errorf("memory entry with invalid type: %T", entry)
 	}
 
 	numaSet, err := machine.Parse(memoryEntry)
 	if err != nil {
-		return nil, fmt.Errorf("parse memory entry: %s failed with error: %v", memoryEntry, err)
+		return machine.CPUSet{}, fmt.Errorf("parse memory entry: %s failed with error: %v", memoryEntry, err)
 	}
+	return numaSet, nil
+}
 
+// packExtraStateFileHints returns the preferred hint of the NUMAs specified by extra state file
+func packExtraStateFileHints(podName, resourceName string, numaSet, availableNUMAs machine.CPUSet,
+) (map[string]*pluginapi.ListOfTopologyHints, error) {
 	if !numaSet.IsSubsetOf(availableNUMAs) {
 		return nil, fmt.Errorf("NUMAs: %s in extra state file isn't subset of available NUMAs: %s", numaSet.String(), availableNUMAs.String())
 	}
@@ -322,3 +601,33 @@ func GetCgroupAsyncWorkName(cgroup, topic string) string {
 func GetAsyncWorkNameByPrefix(prefix, topic string) string {
 	return strings.Join([]string{prefix, topic}, asyncworker.WorkNameSeperator)
 }
+
+// readExtraStateFile reads extra state file with the given timeout. the reading goroutine
+// sends its result to a buffered channel, so it can always exit once reading finishes,
+// even if the caller has already given up waiting.
+func readExtraStateFile(reader ExtraStateFileReader, timeout time.Duration, fileAbsPath string) ([]byte, error) {
+	if timeout <= 0 {
+		return reader.ReadFile(fileAbsPath)
+	}
+
+	type readResult struct {
+		content []byte
+		err     error
+	}
+
+	resultCh := make(chan readResult, 1)
+	go func() {
+		content, err := reader.ReadFile(fileAbsPath)
+		resultCh <- readResult{content: content, err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case result := <-resultCh:
+		return result.content, result.err
+	case <-timer.C:
+		return nil, ErrExtraStateFileReadTimeout
+	}
+}
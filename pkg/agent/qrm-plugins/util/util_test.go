@@ -18,14 +18,19 @@ package util
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
 	"k8s.io/kubernetes/pkg/kubelet/cm/topologymanager/bitmask"
 
 	"github.com/kubewharf/katalyst-api/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
 	"github.com/kubewharf/katalyst-core/pkg/util/machine"
 )
 
@@ -91,6 +96,89 @@ func TestGetQuantityFromResourceReq(t *testing.T) {
 	}
 }
 
+func TestGetQuantityFromResourceReqWithAliases(t *testing.T) {
+	t.Parallel()
+
+	cpuAliases := sets.NewString("vendor.com/vcpu", "vendor.com/shared-vcpu")
+
+	testCases := []struct {
+		name        string
+		req         *pluginapi.ResourceRequest
+		resultInt   int
+		resultFloat float64
+		wantErr     bool
+	}{
+		{
+			name: "standard cpu",
+			req: &pluginapi.ResourceRequest{
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceCPU): 2.5,
+				},
+			},
+			resultInt:   3,
+			resultFloat: 2.5,
+		},
+		{
+			name: "single alias",
+			req: &pluginapi.ResourceRequest{
+				ResourceRequests: map[string]float64{
+					"vendor.com/vcpu": 4,
+				},
+			},
+			resultInt:   4,
+			resultFloat: 4,
+		},
+		{
+			name: "multiple aliases are summed up",
+			req: &pluginapi.ResourceRequest{
+				ResourceRequests: map[string]float64{
+					"vendor.com/vcpu":        2,
+					"vendor.com/shared-vcpu": 1.5,
+				},
+			},
+			resultInt:   4,
+			resultFloat: 3.5,
+		},
+		{
+			name: "alias conflicts with standard cpu",
+			req: &pluginapi.ResourceRequest{
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceCPU): 2,
+					"vendor.com/vcpu":      2,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown resource",
+			req: &pluginapi.ResourceRequest{
+				ResourceRequests: map[string]float64{
+					"vendor.com/gpu": 2,
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			resInt, resFloat, err := GetQuantityFromResourceReqWithAliases(tc.req, cpuAliases)
+			if tc.wantErr {
+				as.NotNil(err)
+				return
+			}
+
+			as.Nil(err)
+			as.Equal(tc.resultInt, resInt)
+			as.Equal(tc.resultFloat, resFloat)
+		})
+	}
+}
+
 func TestDeepCopyTopologyAwareAssignments(t *testing.T) {
 	t.Parallel()
 
@@ -250,3 +338,366 @@ func TestTransformTopologyAwareQuantity(t *testing.T) {
 		as.Equalf(tc.expectedQuantityList, actualQuantityList, "failed in test case: %s", tc.description)
 	}
 }
+
+func TestGetNUMANodesCountToFitCPUReqWithCapacity(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	capacityGetter := func(capacities ...int) func(int) int {
+		return func(numaID int) int {
+			return capacities[numaID]
+		}
+	}
+
+	testCases := []struct {
+		name                   string
+		cpuReq                 int
+		capacityGetter         func(int) int
+		expectNUMACount        int
+		expectCPUsCountPerNUMA int
+		expectErr              bool
+	}{
+		{
+			name:                   "nil capacity getter",
+			cpuReq:                 6,
+			expectNUMACount:        2,
+			expectCPUsCountPerNUMA: 3,
+		},
+		{
+			name:                   "full capacity",
+			cpuReq:                 8,
+			capacityGetter:         capacityGetter(4, 4, 4, 4),
+			expectNUMACount:        2,
+			expectCPUsCountPerNUMA: 4,
+		},
+		{
+			name:                   "reservations in all NUMAs",
+			cpuReq:                 5,
+			capacityGetter:         capacityGetter(2, 2, 2, 2),
+			expectNUMACount:        3,
+			expectCPUsCountPerNUMA: 2,
+		},
+		{
+			name:                   "NUMAs with larger capacity are chosen first",
+			cpuReq:                 6,
+			capacityGetter:         capacityGetter(1, 4, 1, 1),
+			expectNUMACount:        3,
+			expectCPUsCountPerNUMA: 2,
+		},
+		{
+			name:                   "single NUMA with enough capacity",
+			cpuReq:                 3,
+			capacityGetter:         capacityGetter(0, 1, 3, 2),
+			expectNUMACount:        1,
+			expectCPUsCountPerNUMA: 3,
+		},
+		{
+			name:           "total capacity is insufficient",
+			cpuReq:         5,
+			capacityGetter: capacityGetter(1, 1, 1, 1),
+			expectErr:      true,
+		},
+		{
+			name:           "zero request",
+			cpuReq:         0,
+			capacityGetter: capacityGetter(4, 4, 4, 4),
+			expectErr:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			numaCount, cpusCountPerNUMA, err := GetNUMANodesCountToFitCPUReqWithCapacity(tc.cpuReq, cpuTopology, tc.capacityGetter)
+			if tc.expectErr {
+				as.NotNil(err)
+				return
+			}
+
+			as.Nil(err)
+			as.Equal(tc.expectNUMACount, numaCount)
+			as.Equal(tc.expectCPUsCountPerNUMA, cpusCountPerNUMA)
+		})
+	}
+}
+
+func TestMirrorResourceHints(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	cpuHints := &pluginapi.ListOfTopologyHints{
+		Hints: []*pluginapi.TopologyHint{
+			{Nodes: []uint64{0}, Preferred: true},
+			{Nodes: []uint64{0, 1}, Preferred: false},
+		},
+	}
+
+	testCases := []struct {
+		description        string
+		resourceHints      map[string]*pluginapi.ListOfTopologyHints
+		mirrorResourceName string
+		expectedHints      map[string]*pluginapi.ListOfTopologyHints
+	}{
+		{
+			description:        "mirror disabled",
+			resourceHints:      map[string]*pluginapi.ListOfTopologyHints{string(v1.ResourceCPU): cpuHints},
+			mirrorResourceName: "",
+			expectedHints:      map[string]*pluginapi.ListOfTopologyHints{string(v1.ResourceCPU): cpuHints},
+		},
+		{
+			description:        "mirror to the same resource",
+			resourceHints:      map[string]*pluginapi.ListOfTopologyHints{string(v1.ResourceCPU): cpuHints},
+			mirrorResourceName: string(v1.ResourceCPU),
+			expectedHints:      map[string]*pluginapi.ListOfTopologyHints{string(v1.ResourceCPU): cpuHints},
+		},
+		{
+			description:        "mirror hints",
+			resourceHints:      map[string]*pluginapi.ListOfTopologyHints{string(v1.ResourceCPU): cpuHints},
+			mirrorResourceName: "numa-affinity",
+			expectedHints: map[string]*pluginapi.ListOfTopologyHints{
+				string(v1.ResourceCPU): cpuHints,
+				"numa-affinity":        cpuHints,
+			},
+		},
+		{
+			description:        "mirror nil hints",
+			resourceHints:      map[string]*pluginapi.ListOfTopologyHints{string(v1.ResourceCPU): nil},
+			mirrorResourceName: "numa-affinity",
+			expectedHints: map[string]*pluginapi.ListOfTopologyHints{
+				string(v1.ResourceCPU): nil,
+				"numa-affinity":        nil,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		resp := &pluginapi.ResourceHintsResponse{ResourceHints: tc.resourceHints}
+		MirrorResourceHints(resp, string(v1.ResourceCPU), tc.mirrorResourceName)
+		as.Equalf(tc.expectedHints, resp.ResourceHints, "failed in test case: %s", tc.description)
+	}
+
+	// mirrored hints shouldn't share memory with the original ones
+	resp := &pluginapi.ResourceHintsResponse{
+		ResourceHints: map[string]*pluginapi.ListOfTopologyHints{string(v1.ResourceCPU): cpuHints},
+	}
+	MirrorResourceHints(resp, string(v1.ResourceCPU), "numa-affinity")
+	resp.ResourceHints["numa-affinity"].Hints[0].Preferred = false
+	as.True(cpuHints.Hints[0].Preferred)
+}
+
+func TestDedupeResourceHints(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	testCases := []struct {
+		description   string
+		resourceHints map[string]*pluginapi.ListOfTopologyHints
+		expectedHints map[string]*pluginapi.ListOfTopologyHints
+	}{
+		{
+			description:   "nil hints",
+			resourceHints: map[string]*pluginapi.ListOfTopologyHints{string(v1.ResourceCPU): nil},
+			expectedHints: map[string]*pluginapi.ListOfTopologyHints{string(v1.ResourceCPU): nil},
+		},
+		{
+			description: "no duplicates",
+			resourceHints: map[string]*pluginapi.ListOfTopologyHints{string(v1.ResourceCPU): {
+				Hints: []*pluginapi.TopologyHint{
+					{Nodes: []uint64{0}, Preferred: true},
+					{Nodes: []uint64{0, 1}, Preferred: false},
+				},
+			}},
+			expectedHints: map[string]*pluginapi.ListOfTopologyHints{string(v1.ResourceCPU): {
+				Hints: []*pluginapi.TopologyHint{
+					{Nodes: []uint64{0}, Preferred: true},
+					{Nodes: []uint64{0, 1}, Preferred: false},
+				},
+			}},
+		},
+		{
+			description: "duplicates disagreeing on preference",
+			resourceHints: map[string]*pluginapi.ListOfTopologyHints{string(v1.ResourceCPU): {
+				Hints: []*pluginapi.TopologyHint{
+					{Nodes: []uint64{1}, Preferred: false},
+					{Nodes: []uint64{0, 1}, Preferred: false},
+					{Nodes: []uint64{1}, Preferred: true},
+					{Nodes: []uint64{1, 0}, Preferred: false},
+					nil,
+				},
+			}},
+			expectedHints: map[string]*pluginapi.ListOfTopologyHints{string(v1.ResourceCPU): {
+				Hints: []*pluginapi.TopologyHint{
+					{Nodes: []uint64{1}, Preferred: true},
+					{Nodes: []uint64{0, 1}, Preferred: false},
+				},
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		resp := &pluginapi.ResourceHintsResponse{ResourceHints: tc.resourceHints}
+		DedupeResourceHints(resp, string(v1.ResourceCPU))
+		as.Equalf(tc.expectedHints, resp.ResourceHints, "failed in test case: %s", tc.description)
+	}
+}
+
+type malformedFilesRecorder struct {
+	metrics.DummyMetrics
+
+	files []string
+}
+
+func (r *malformedFilesRecorder) StoreInt64(key string, _ int64, _ metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+	if key != MetricNameExtraStateFileMalformed {
+		return nil
+	}
+
+	for _, tag := range tags {
+		if tag.Key == "file" {
+			r.files = append(r.files, tag.Val)
+		}
+	}
+	return nil
+}
+
+func TestGetHintsFromExtraStateFilesWithTimeout(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	tmpDir, err := ioutil.TempDir("", "TestGetHintsFromExtraStateFilesWithTimeout")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	writeFile := func(name, content string) string {
+		path := filepath.Join(tmpDir, name)
+		as.Nil(ioutil.WriteFile(path, []byte(content), 0o644))
+		return path
+	}
+
+	overrideFile := writeFile("override", `{"memoryEntries": {"pod-a-0": "1", "pod-c-0": "invalid"}}`)
+	baseFile := writeFile("base", `{"memoryEntries": {"pod-a-0": "2", "pod-b-0": "3", "pod-c-0": "0"}}`)
+	malformedFile := writeFile("malformed", `{"memoryEntries": `)
+	missingFile := filepath.Join(tmpDir, "missing")
+	files := []string{missingFile, malformedFile, overrideFile, baseFile}
+	availableNUMAs := machine.NewCPUSet(0, 1, 2, 3)
+
+	testCases := []struct {
+		name          string
+		podName       string
+		files         []string
+		wantNUMA      uint64
+		wantErr       bool
+		wantMalformed []string
+	}{
+		{
+			name:          "earlier file wins on conflict",
+			podName:       "pod-a",
+			files:         files,
+			wantNUMA:      1,
+			wantMalformed: []string{malformedFile},
+		},
+		{
+			name:     "precedence follows the order of files",
+			podName:  "pod-a",
+			files:    []string{baseFile, overrideFile},
+			wantNUMA: 2,
+		},
+		{
+			name:          "entry only in later file is used",
+			podName:       "pod-b",
+			files:         files,
+			wantNUMA:      3,
+			wantMalformed: []string{malformedFile},
+		},
+		{
+			name:          "malformed entry is skipped in favor of later file",
+			podName:       "pod-c",
+			files:         files,
+			wantNUMA:      0,
+			wantMalformed: []string{malformedFile, overrideFile},
+		},
+		{
+			name:          "no entry in any file",
+			podName:       "pod-d",
+			files:         files,
+			wantErr:       true,
+			wantMalformed: []string{malformedFile},
+		},
+	}
+
+	for _, tc := range testCases {
+		emitter := &malformedFilesRecorder{}
+		hints, err := GetHintsFromExtraStateFilesWithTimeout(nil, 0, emitter, tc.podName,
+			string(v1.ResourceCPU), tc.files, availableNUMAs)
+		as.Equal(tc.wantMalformed, emitter.files, tc.name)
+		if tc.wantErr {
+			as.NotNil(err, tc.name)
+			continue
+		}
+
+		as.Nil(err, tc.name)
+		as.Equal([]*pluginapi.TopologyHint{{Nodes: []uint64{tc.wantNUMA}, Preferred: true}},
+			hints[string(v1.ResourceCPU)].Hints, tc.name)
+	}
+}
+
+// fakeExtraStateFileReader serves extra state files from memory, files absent from it don't exist
+type fakeExtraStateFileReader map[string]string
+
+func (r fakeExtraStateFileReader) ReadFile(fileAbsPath string) ([]byte, error) {
+	content, ok := r[fileAbsPath]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(content), nil
+}
+
+// extraStateFileHintCounter counts metrics of extra state file hints by name (and reason if any)
+type extraStateFileHintCounter struct {
+	metrics.DummyMetrics
+
+	counts map[string]int
+}
+
+func (c *extraStateFileHintCounter) StoreInt64(key string, _ int64, _ metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+	for _, tag := range tags {
+		if tag.Key == "reason" {
+			key = key + "/" + tag.Val
+		}
+	}
+	c.counts[key]++
+	return nil
+}
+
+func TestExtraStateFileHintMetrics(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	reader := fakeExtraStateFileReader{
+		"/malformed": `{"memoryEntries": `,
+		"/extra":     `{"memoryEntries": {"pod-a-0": "1", "pod-b-0": "5", "pod-c-0": 1}}`,
+	}
+	files := []string{"/missing", "/malformed", "/extra"}
+	availableNUMAs := machine.NewCPUSet(0, 1, 2, 3)
+
+	emitter := &extraStateFileHintCounter{counts: make(map[string]int)}
+	for _, podName := range []string{"pod-a", "pod-a", "pod-b", "pod-c", "pod-d"} {
+		_, _ = GetHintsFromExtraStateFilesWithTimeout(reader, 0, emitter, podName,
+			string(v1.ResourceCPU), files, availableNUMAs)
+	}
+
+	as.Equal(map[string]int{
+		MetricNameExtraStateFileHintHit:                         2,
+		MetricNameExtraStateFileHintMiss + "/unavailable_numas": 1,
+		MetricNameExtraStateFileHintMiss + "/no_entry":          2,
+		// the malformed file is counted for each pod, and the malformed entry is counted for pod-c
+		MetricNameExtraStateFileMalformed: 6,
+	}, emitter.counts)
+}
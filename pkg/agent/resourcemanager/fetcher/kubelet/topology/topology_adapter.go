@@ -584,7 +584,8 @@ func (p *topologyAdapterImpl) reviseSharedCoresPodAllocated(pod *v1.Pod, podAllo
 }
 
 // getZoneAttributes gets a map of zone node to zone attributes, which is generated from the annotation of
-// topology aware quantity and socket and numa zone are not support attribute here
+// topology aware quantity; socket zone is not support attribute here, and numa zone only supports
+// attributes reported by quantities without type (e.g. occupancy status of the numa)
 func (p *topologyAdapterImpl) getZoneAttributes(allocatableResources *podresv1.AllocatableResourcesResponse) (map[util.ZoneNode]util.ZoneAttributes, error) {
 	if allocatableResources == nil {
 		return nil, fmt.Errorf("allocatable Resources is nil")
@@ -598,9 +599,12 @@ func (p *topologyAdapterImpl) getZoneAttributes(allocatableResources *podresv1.A
 		}
 
 		for _, quantity := range resources.TopologyAwareAllocatableQuantityList {
-			// only quantity with type need report attributes, and others such as Socket and Numa
-			// no need report that
-			if quantity == nil || len(quantity.Type) == 0 {
+			// only quantity with type or quantity of Numa with annotations need report attributes,
+			// and others such as Socket no need report that
+			if quantity == nil {
+				continue
+			} else if len(quantity.Type) == 0 &&
+				(quantity.TopologyLevel != podresv1.TopologyLevel_NUMA || len(quantity.Annotations) == 0) {
 				continue
 			}
 
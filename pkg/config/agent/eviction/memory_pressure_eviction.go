@@ -16,13 +16,30 @@ limitations under the License.
 
 package eviction
 
-import "k8s.io/apimachinery/pkg/labels"
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
 
 // MemoryPressureEvictionConfiguration is the config of MemoryPressureEviction
 type MemoryPressureEvictionConfiguration struct {
 	RSSOveruseEvictionFilter     labels.Set
 	SystemPressureSyncPeriod     int
 	SystemPressureCoolDownPeriod int
+
+	// EnableMemoryBandwidthEviction is whether to evict reclaimed_cores pods on NUMAs
+	// suffering memory bandwidth contention
+	EnableMemoryBandwidthEviction bool
+	// MemoryBandwidthEvictionThreshold is the ratio of NUMA aggregate memory bandwidth
+	// to its theoretical bandwidth, above which the NUMA is considered under contention
+	MemoryBandwidthEvictionThreshold float64
+	// MemoryBandwidthEvictionSustainedDuration is the duration that memory bandwidth contention
+	// must last before eviction is triggered
+	MemoryBandwidthEvictionSustainedDuration time.Duration
+	// MemoryBandwidthEvictionMetricMaxAge is the max age of memory bandwidth metrics, older metrics are
+	// regarded as unknown and the NUMA is skipped; non-positive value means staleness detection is disabled
+	MemoryBandwidthEvictionMetricMaxAge time.Duration
 }
 
 // NewMemoryPressureEvictionPluginConfiguration returns a new MemoryPressureEvictionConfiguration
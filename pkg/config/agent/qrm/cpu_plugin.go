@@ -16,11 +16,59 @@ limitations under the License.
 
 package qrm
 
+import (
+	"fmt"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+)
+
+var (
+	validCPUNUMAHintPreferPolicies = sets.NewString(
+		cpuconsts.CPUNUMAHintPreferPolicyPacking,
+		cpuconsts.CPUNUMAHintPreferPolicySpreading,
+		cpuconsts.CPUNUMAHintPreferPolicyDynamicPacking,
+	)
+	validCPUNUMAHintPreferTieBreaks = sets.NewString(
+		cpuconsts.CPUNUMAHintPreferTieBreakLowestNUMAID,
+		cpuconsts.CPUNUMAHintPreferTieBreakHighestNUMAID,
+		cpuconsts.CPUNUMAHintPreferTieBreakNone,
+	)
+	validCPUNUMAHintFallbackOrders = sets.NewString(
+		cpuconsts.CPUNUMAHintFallbackOrderNone,
+		cpuconsts.CPUNUMAHintFallbackOrderLowestNUMAID,
+		cpuconsts.CPUNUMAHintFallbackOrderMostAvailable,
+	)
+	validCPUMissingCPUsActions = sets.NewString(
+		cpuconsts.MissingCPUsActionTrim,
+		cpuconsts.MissingCPUsActionEvict,
+	)
+	validCPUUnclassifiedPodPolicies = sets.NewString(
+		cpuconsts.UnclassifiedPodPolicySharedCores,
+		cpuconsts.UnclassifiedPodPolicyReclaimedCores,
+		cpuconsts.UnclassifiedPodPolicyReject,
+	)
+	validCPUSidecarNUMABindingMismatchPolicies = sets.NewString(
+		cpuconsts.SidecarNUMABindingMismatchPolicyFollowMain,
+		cpuconsts.SidecarNUMABindingMismatchPolicyReject,
+	)
+	validCPUUnknownContainerTypePolicies = sets.NewString(
+		cpuconsts.UnknownContainerTypePolicyMain,
+		cpuconsts.UnknownContainerTypePolicyReject,
+	)
+)
+
 type CPUQRMPluginConfig struct {
 	// PolicyName is used to switch between several strategies
 	PolicyName string
 	// ReservedCPUCores indicates reserved cpus number for system agents
 	ReservedCPUCores int
+	// ReserveCPUsByFullCores indicates whether reserved cpus are taken by whole physical cores,
+	// so that workloads never share a core with system agents
+	ReserveCPUsByFullCores bool
 	// SkipCPUStateCorruption is set to skip cpu state corruption, and it will be used after updating state properties
 	SkipCPUStateCorruption bool
 
@@ -45,6 +93,129 @@ type CPUDynamicPolicyConfig struct {
 	// CPUNUMAHintPreferPolicy indicates threshold to apply CPUNUMAHintPreferPolicy dynamically,
 	// and it's working when CPUNUMAHintPreferPolicy is set to dynamic_packing
 	CPUNUMAHintPreferLowThreshold float64
+	// EnableCPUMemoryCoAllocation indicates whether to consult per-NUMA memory availability
+	// before marking a cpu hint as preferred for numa_binding pods
+	EnableCPUMemoryCoAllocation bool
+	// OfflineCPUsFileAbsPath is the file storing cpus (in cpuset format) that should be
+	// excluded from allocation at runtime, e.g. cpus being quarantined or hot-unplugged
+	OfflineCPUsFileAbsPath string
+	// MaxNUMAsPerAllocation limits the count of NUMA nodes a single allocation can span,
+	// hints with more NUMA nodes won't be generated; non-positive value means no limit
+	MaxNUMAsPerAllocation int
+	// EnableCPUSetDriftRepair indicates whether to re-apply the recorded cpuset to containers
+	// whose actual cpuset drifts from it; otherwise the drift is only detected and reported
+	EnableCPUSetDriftRepair bool
+	// ReclaimedNUMAOvercommitRatio bounds overcommit of reclaimed_cores per NUMA,
+	// reclaimed_cores containers can't be admitted to a NUMA whose requested quantity exceeds allocatable * ratio;
+	// non-positive value means no bound and reclaimed_cores containers have no NUMA preference
+	ReclaimedNUMAOvercommitRatio float64
+	// CPUResourceNameAliases are extended resource names treated as cpu when parsing requests
+	CPUResourceNameAliases []string
+	// CPUNUMAHintPreferTieBreak decides which NUMA is preferred among NUMAs with equal score,
+	// it's lowest_numa_id by default; none means all of them are preferred
+	CPUNUMAHintPreferTieBreak string
+	// CPUNUMAHintFallbackOrder decides the order of hints returned when no NUMA is eligible for preference,
+	// so that the choice of topology manager is predictable; it's none by default and no hint is returned then
+	CPUNUMAHintFallbackOrder string
+	// EnableCPUStateRecovery indicates whether to reconstruct state by running pods and their cgroup cpusets
+	// when the checkpoint fails to be parsed, instead of failing to start the plugin
+	EnableCPUStateRecovery bool
+	// CPUNUMADistancePenaltyCurve maps NUMA distance to the ratio applied to cpu quota of shared_cores containers,
+	// the max distance among NUMAs of the allocation picks the entry with the largest distance not exceeding it;
+	// empty curve means the penalty is disabled
+	CPUNUMADistancePenaltyCurve map[int]float64
+	// CPUPlacementAdvisorSocketAbsPath is the socket of the external advisor consulted for the preferred NUMA
+	// of shared_cores with numa_binding; it's disabled if empty
+	CPUPlacementAdvisorSocketAbsPath string
+	// CPUPlacementAdvisorTimeout is the timeout of each request to the external placement advisor
+	CPUPlacementAdvisorTimeout time.Duration
+	// CPUNUMAAffinityResourceName is an additional resource name (eg. numa-affinity) that cpu hints are mirrored to,
+	// so that device plugins aligning to it can converge with cpu; it's disabled if empty
+	CPUNUMAAffinityResourceName string
+	// CPUQoSReservedPools maps QoS levels (currently only those not admitted by dynamic policy, e.g. system_cores)
+	// to cpus (in cpuset format, eg. "0-1") reserved for them, cpus in these pools are separate from
+	// the global reserved cpus and are excluded from all pools managed by dynamic policy.
+	CPUQoSReservedPools map[string]string
+	// CPUMissingCPUsAction is the action taken on allocations referencing cpus missing from
+	// the current topology (e.g. after cpu hot-unplug), it's one of trim and evict
+	CPUMissingCPUsAction string
+	// EnableCPUWeightedShares indicates whether to split cpu shares of each NUMA among numa_binding
+	// shared_cores containers on it in proportion to their requests multiplied by their weights
+	EnableCPUWeightedShares bool
+	// CPUReuseCoolDown is the duration that cpus freed from numa_binding allocations are avoided by
+	// new dedicated_cores allocations, so that threads of the terminated container can drain before the cpus
+	// are reused; they are still used if no other cpu fits. non-positive value means the cool-down is disabled
+	CPUReuseCoolDown time.Duration
+	// EnableCPUHintScores indicates whether to attach scores of cpu hints to annotations of hints response,
+	// so that consumers other than topology manager can rank hints beyond the boolean preferred
+	EnableCPUHintScores bool
+	// MaxReclaimedEvictionsToFreeNUMA is the max count of reclaimed_cores pods that can be evicted to free a NUMA
+	// for a dedicated_cores with numa_binding pod that can't fit into any NUMA otherwise, only pods with lower
+	// priority are evicted; it requires ReclaimedNUMAOvercommitRatio to be positive, and non-positive value means disabled
+	MaxReclaimedEvictionsToFreeNUMA int
+	// EnableDedicatedFullPhysicalCPUsOnly indicates whether dedicated_cores with numa_binding containers are always
+	// allocated with whole physical cores (as if they have the full physical cores annotation), to avoid SMT contention
+	EnableDedicatedFullPhysicalCPUsOnly bool
+	// EnableCPUPlacementAnnotations indicates whether to write back the NUMAs, cpuset and effective prefer policy
+	// of numa_binding containers into annotations of their pods, so that placement can be told without node state
+	EnableCPUPlacementAnnotations bool
+	// CPUNUMAMinFreeCPUs is the count of cpus kept free in each NUMA, NUMAs are hinted only if they still have
+	// this many available cpus after the request is allocated; unlike reserved cpus, it's a floating reserve
+	// not bound to specific cpus. non-positive value means disabled
+	CPUNUMAMinFreeCPUs int
+	// CPUHintsAuditFileAbsPath is the file that a record of hints calculation result is appended to
+	// for each admission, in json lines; it's disabled if empty
+	CPUHintsAuditFileAbsPath string
+	// ReclaimedNUMACPUQuota maps NUMA id to the max quantity requested by reclaimed_cores containers admitted to it,
+	// reclaimed_cores containers are steered away from NUMAs at their quota; NUMAs absent from the map have no quota
+	ReclaimedNUMACPUQuota map[int]float64
+	// CPUNUMAPinRulesFileAbsPath is the file (usually mounted from a ConfigMap) of rules pinning numa_binding containers
+	// of pods matching label selectors to specific NUMAs, it's reloaded periodically; it's disabled if empty
+	CPUNUMAPinRulesFileAbsPath string
+	// EnableCPUPrometheusMetrics indicates whether to keep metrics emitted by the policy in a prometheus registry
+	// as well, and expose them in prometheus exposition format on the debug endpoint of the agent
+	EnableCPUPrometheusMetrics bool
+	// MaxSharedDisplacementsToFreeNUMA is the max count of numa_binding shared_cores pods whose cpusets can be
+	// reassigned to other NUMAs (without restarting them) to free a NUMA for a dedicated_cores with numa_binding
+	// container that can't fit into any NUMA otherwise; non-positive value means disabled
+	MaxSharedDisplacementsToFreeNUMA int
+	// TrimInvalidReservedCPUs indicates whether reserved cpus absent from the machine topology are trimmed
+	// with a warning, otherwise the plugin fails to start (or rejects the update) with such reserved cpus
+	TrimInvalidReservedCPUs bool
+	// CPUNUMAThrottleRatioThreshold is the ratio of time that cpus of a NUMA run at throttled frequency,
+	// above which hints containing the NUMA are demoted to non-preferred; non-positive value means disabled
+	CPUNUMAThrottleRatioThreshold float64
+	// CPUNUMATemperatureThreshold is the temperature (in celsius) of a NUMA, above which hints containing
+	// the NUMA are demoted to non-preferred; non-positive value means disabled
+	CPUNUMATemperatureThreshold float64
+	// CPUPreviousNUMAStickyTTL is the duration that NUMAs of a container whose allocation is cleared are
+	// remembered, so that the container returns to them (if still eligible) when its hints are calculated
+	// again within the duration, e.g. after it restarts; non-positive value means disabled
+	CPUPreviousNUMAStickyTTL time.Duration
+	// CPUUnclassifiedPodPolicy is how pods without katalyst QoS annotations are admitted,
+	// i.e. as shared_cores (by default), as reclaimed_cores, or rejected
+	CPUUnclassifiedPodPolicy string
+	// CPUSidecarNUMABindingMismatchPolicy is how sidecars with numa_binding annotations mismatching their
+	// main containers are admitted, i.e. aligned with main containers (by default), or rejected
+	CPUSidecarNUMABindingMismatchPolicy string
+	// CPUUnknownContainerTypePolicy is how containers of types unknown to the policy are admitted,
+	// i.e. as main containers (by default), or rejected
+	CPUUnknownContainerTypePolicy string
+	// CPUNUMANamespaceReservations maps NUMA id to the namespace it's reserved for, numa_binding containers
+	// of other namespaces are kept off the NUMA, and the ones of the namespace prefer it
+	CPUNUMANamespaceReservations map[int]string
+	// CPUPreferMemoryAllocatedNUMAs indicates whether numa_binding containers prefer NUMAs that memory of
+	// their pods is already allocated on by the memory plugin, it's read from checkpoint of the memory plugin
+	CPUPreferMemoryAllocatedNUMAs bool
+	// MaxNUMABindingSharedPodsPerNUMA is the max count of numa_binding shared_cores pods on each NUMA, NUMAs at
+	// the cap are excluded from candidates of new ones to avoid contention among them; non-positive value means no cap
+	MaxNUMABindingSharedPodsPerNUMA int
+	// EnableCPUMachineStateDiffLog indicates whether to log the diff between machine states before and after
+	// it's regenerated from pod entries, it's for debugging since the state in use is cloned for each regeneration
+	EnableCPUMachineStateDiffLog bool
+	// CPUSocketAffinityFallback indicates whether containers with socket_affinity enhancement fall back to
+	// NUMAs of all sockets if none of the NUMAs on the socket fits, otherwise their admission fails
+	CPUSocketAffinityFallback bool
 }
 
 type CPUNativePolicyConfig struct {
@@ -61,3 +232,59 @@ func NewCPUQRMPluginConfig() *CPUQRMPluginConfig {
 		CPUNativePolicyConfig:  CPUNativePolicyConfig{},
 	}
 }
+
+// Validate checks ranges and enum membership of knobs of cpu plugin, so that bad configs fail fast at startup
+// rather than manifesting as unexpected placement later; all invalid knobs are reported at once.
+func (c *CPUQRMPluginConfig) Validate() error {
+	var errs []error
+	if c.ReservedCPUCores < 0 {
+		errs = append(errs, fmt.Errorf("reserved cpu cores: %d is negative", c.ReservedCPUCores))
+	}
+
+	if !validCPUNUMAHintPreferPolicies.Has(c.CPUNUMAHintPreferPolicy) {
+		errs = append(errs, fmt.Errorf("unknown cpu NUMA hint prefer policy: %q, valid values: %v",
+			c.CPUNUMAHintPreferPolicy, validCPUNUMAHintPreferPolicies.List()))
+	}
+
+	if c.CPUNUMAHintPreferLowThreshold < 0 || c.CPUNUMAHintPreferLowThreshold > 1 {
+		errs = append(errs, fmt.Errorf("cpu NUMA hint prefer low threshold: %v is out of range [0, 1]",
+			c.CPUNUMAHintPreferLowThreshold))
+	}
+
+	for _, knob := range []struct {
+		name  string
+		value string
+		valid sets.String
+	}{
+		{"cpu NUMA hint prefer tie break", c.CPUNUMAHintPreferTieBreak, validCPUNUMAHintPreferTieBreaks},
+		{"cpu NUMA hint fallback order", c.CPUNUMAHintFallbackOrder, validCPUNUMAHintFallbackOrders},
+		{"cpu missing cpus action", c.CPUMissingCPUsAction, validCPUMissingCPUsActions},
+		{"cpu unclassified pod policy", c.CPUUnclassifiedPodPolicy, validCPUUnclassifiedPodPolicies},
+		{"cpu sidecar numa binding mismatch policy", c.CPUSidecarNUMABindingMismatchPolicy, validCPUSidecarNUMABindingMismatchPolicies},
+		{"cpu unknown container type policy", c.CPUUnknownContainerTypePolicy, validCPUUnknownContainerTypePolicies},
+	} {
+		if !knob.valid.Has(knob.value) {
+			errs = append(errs, fmt.Errorf("unknown %s: %q, valid values: %v", knob.name, knob.value, knob.valid.List()))
+		}
+	}
+
+	for distance, ratio := range c.CPUNUMADistancePenaltyCurve {
+		if ratio <= 0 || ratio > 1 {
+			errs = append(errs, fmt.Errorf("penalty ratio: %v of numa distance: %d is out of range (0, 1]", ratio, distance))
+		}
+	}
+
+	for numaID, quota := range c.ReclaimedNUMACPUQuota {
+		if quota < 0 {
+			errs = append(errs, fmt.Errorf("reclaimed cpu quota: %v of numa: %d is negative", quota, numaID))
+		}
+	}
+
+	for numaID, namespace := range c.CPUNUMANamespaceReservations {
+		if namespace == "" {
+			errs = append(errs, fmt.Errorf("numa: %d is reserved for empty namespace", numaID))
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package qrm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+)
+
+func TestCPUQRMPluginConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	newValidConfig := func() *CPUQRMPluginConfig {
+		conf := NewCPUQRMPluginConfig()
+		conf.ReservedCPUCores = 2
+		conf.CPUNUMAHintPreferPolicy = cpuconsts.CPUNUMAHintPreferPolicyDynamicPacking
+		conf.CPUNUMAHintPreferLowThreshold = 0.5
+		conf.CPUNUMAHintPreferTieBreak = cpuconsts.CPUNUMAHintPreferTieBreakLowestNUMAID
+		conf.CPUNUMAHintFallbackOrder = cpuconsts.CPUNUMAHintFallbackOrderNone
+		conf.CPUMissingCPUsAction = cpuconsts.MissingCPUsActionTrim
+		conf.CPUUnclassifiedPodPolicy = cpuconsts.UnclassifiedPodPolicySharedCores
+		conf.CPUSidecarNUMABindingMismatchPolicy = cpuconsts.SidecarNUMABindingMismatchPolicyFollowMain
+		conf.CPUUnknownContainerTypePolicy = cpuconsts.UnknownContainerTypePolicyMain
+		conf.CPUNUMADistancePenaltyCurve = map[int]float64{20: 0.8}
+		conf.ReclaimedNUMACPUQuota = map[int]float64{0: 4}
+		conf.CPUNUMANamespaceReservations = map[int]string{1: "batch"}
+		return conf
+	}
+
+	testCases := []struct {
+		name    string
+		mutate  func(conf *CPUQRMPluginConfig)
+		wantErr bool
+	}{
+		{
+			name:   "valid config",
+			mutate: func(conf *CPUQRMPluginConfig) {},
+		},
+		{
+			name:    "negative reserved cpu cores",
+			mutate:  func(conf *CPUQRMPluginConfig) { conf.ReservedCPUCores = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "unknown prefer policy",
+			mutate:  func(conf *CPUQRMPluginConfig) { conf.CPUNUMAHintPreferPolicy = "balanced" },
+			wantErr: true,
+		},
+		{
+			name:    "empty prefer policy",
+			mutate:  func(conf *CPUQRMPluginConfig) { conf.CPUNUMAHintPreferPolicy = "" },
+			wantErr: true,
+		},
+		{
+			name:    "prefer low threshold greater than 1",
+			mutate:  func(conf *CPUQRMPluginConfig) { conf.CPUNUMAHintPreferLowThreshold = 1.5 },
+			wantErr: true,
+		},
+		{
+			name:    "negative prefer low threshold",
+			mutate:  func(conf *CPUQRMPluginConfig) { conf.CPUNUMAHintPreferLowThreshold = -0.1 },
+			wantErr: true,
+		},
+		{
+			name:   "prefer low threshold at bounds",
+			mutate: func(conf *CPUQRMPluginConfig) { conf.CPUNUMAHintPreferLowThreshold = 1 },
+		},
+		{
+			name:   "no reserved cpu cores",
+			mutate: func(conf *CPUQRMPluginConfig) { conf.ReservedCPUCores = 0 },
+		},
+		{
+			name:    "unknown prefer tie break",
+			mutate:  func(conf *CPUQRMPluginConfig) { conf.CPUNUMAHintPreferTieBreak = "random" },
+			wantErr: true,
+		},
+		{
+			name:    "unknown fallback order",
+			mutate:  func(conf *CPUQRMPluginConfig) { conf.CPUNUMAHintFallbackOrder = "random" },
+			wantErr: true,
+		},
+		{
+			name:    "unknown missing cpus action",
+			mutate:  func(conf *CPUQRMPluginConfig) { conf.CPUMissingCPUsAction = "ignore" },
+			wantErr: true,
+		},
+		{
+			name:    "unknown unclassified pod policy",
+			mutate:  func(conf *CPUQRMPluginConfig) { conf.CPUUnclassifiedPodPolicy = "dedicated_cores" },
+			wantErr: true,
+		},
+		{
+			name:    "unknown sidecar numa binding mismatch policy",
+			mutate:  func(conf *CPUQRMPluginConfig) { conf.CPUSidecarNUMABindingMismatchPolicy = "ignore" },
+			wantErr: true,
+		},
+		{
+			name:    "unknown unknown container type policy",
+			mutate:  func(conf *CPUQRMPluginConfig) { conf.CPUUnknownContainerTypePolicy = "sidecar" },
+			wantErr: true,
+		},
+		{
+			name:    "zero penalty ratio",
+			mutate:  func(conf *CPUQRMPluginConfig) { conf.CPUNUMADistancePenaltyCurve[20] = 0 },
+			wantErr: true,
+		},
+		{
+			name:    "penalty ratio greater than 1",
+			mutate:  func(conf *CPUQRMPluginConfig) { conf.CPUNUMADistancePenaltyCurve[20] = 1.2 },
+			wantErr: true,
+		},
+		{
+			name:    "negative reclaimed cpu quota",
+			mutate:  func(conf *CPUQRMPluginConfig) { conf.ReclaimedNUMACPUQuota[0] = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "numa reserved for empty namespace",
+			mutate:  func(conf *CPUQRMPluginConfig) { conf.CPUNUMANamespaceReservations[1] = "" },
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			conf := newValidConfig()
+			tc.mutate(conf)
+			err := conf.Validate()
+			if tc.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	// all invalid knobs are reported at once
+	conf := newValidConfig()
+	conf.ReservedCPUCores = -1
+	conf.CPUNUMAHintPreferPolicy = "balanced"
+	conf.CPUNUMAHintPreferLowThreshold = 2
+	conf.CPUMissingCPUsAction = "ignore"
+	conf.ReclaimedNUMACPUQuota[0] = -1
+	err := conf.Validate()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "reserved cpu cores")
+	require.Contains(t, err.Error(), "prefer policy")
+	require.Contains(t, err.Error(), "low threshold")
+	require.Contains(t, err.Error(), "missing cpus action")
+	require.Contains(t, err.Error(), "reclaimed cpu quota")
+}
@@ -16,12 +16,19 @@ limitations under the License.
 
 package qrm
 
+import "time"
+
 type GenericQRMPluginConfiguration struct {
-	StateFileDirectory       string
-	QRMPluginSocketDirs      []string
-	ExtraStateFileAbsPath    string
-	PodDebugAnnoKeys         []string
-	UseKubeletReservedConfig bool
+	StateFileDirectory        string
+	QRMPluginSocketDirs       []string
+	ExtraStateFileAbsPath     string
+	ExtraStateFileReadTimeout time.Duration
+	PodDebugAnnoKeys          []string
+	UseKubeletReservedConfig  bool
+
+	// ExtraStateFileAbsPaths are extra state files in precedence order (earlier files win on conflict),
+	// ExtraStateFileAbsPath is the first one if it's set.
+	ExtraStateFileAbsPaths []string
 }
 
 type QRMPluginsConfiguration struct {
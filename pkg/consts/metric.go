@@ -145,6 +145,9 @@ const (
 	MetricMemLatencyReadNuma      = "mem.latency.read.numa"
 	MetricMemLatencyWriteNuma     = "mem.latency.write.numa"
 	MetricMemAMDL3MissLatencyNuma = "mem.latency.amd.l3.miss"
+
+	MetricCPUThrottleRatioNuma = "cpu.throttle.ratio.numa"
+	MetricCPUTemperatureNuma   = "cpu.temperature.numa"
 )
 
 // System cpu compute metrics
@@ -19,6 +19,7 @@ package helper
 import (
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/kubewharf/katalyst-core/pkg/consts"
 	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/metric/types"
@@ -99,3 +100,27 @@ func GetNumaMetric(metricsFetcher types.MetricsFetcher, emitter metrics.MetricEm
 	}
 	return metricWithTime.Value, err
 }
+
+// GetNumaMetricWithStaleness is the same as GetNumaMetricWithTime, except that it also returns whether
+// the metric is stale, i.e. collected more than maxAge ago, so that callers can regard it as unknown
+// instead of making decisions on it when the collector stalls.
+func GetNumaMetricWithStaleness(metricsFetcher types.MetricsFetcher, emitter metrics.MetricEmitter, metricName string,
+	numaID int, maxAge time.Duration,
+) (metricutil.MetricData, bool, error) {
+	metricData, err := GetNumaMetricWithTime(metricsFetcher, emitter, metricName, numaID)
+	if err != nil {
+		return metricutil.MetricData{}, false, err
+	}
+	return metricData, IsMetricDataStale(metricData, maxAge, time.Now()), nil
+}
+
+// IsMetricDataStale returns true if the metric is collected more than maxAge before now;
+// non-positive maxAge means staleness detection is disabled, and metric without timestamp
+// is never regarded as stale since its age can't be judged. It works as a per-consumer
+// bound tighter than the insurance period that metrics fetcher applies to all metrics.
+func IsMetricDataStale(metricData metricutil.MetricData, maxAge time.Duration, now time.Time) bool {
+	if maxAge <= 0 || metricData.Time == nil || metricData.Time.IsZero() {
+		return false
+	}
+	return now.Sub(*metricData.Time) > maxAge
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubewharf/katalyst-core/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/metric"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	metricutil "github.com/kubewharf/katalyst-core/pkg/util/metric"
+)
+
+func TestIsMetricDataStale(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	fresh := now.Add(-5 * time.Second)
+	old := now.Add(-30 * time.Second)
+
+	assert.False(t, IsMetricDataStale(metricutil.MetricData{Time: &fresh}, 10*time.Second, now))
+	assert.True(t, IsMetricDataStale(metricutil.MetricData{Time: &old}, 10*time.Second, now))
+	assert.False(t, IsMetricDataStale(metricutil.MetricData{Time: &old}, 0, now))
+	assert.False(t, IsMetricDataStale(metricutil.MetricData{}, 10*time.Second, now))
+}
+
+func TestGetNumaMetricWithStaleness(t *testing.T) {
+	t.Parallel()
+
+	fetcher := metric.NewFakeMetricsFetcher(metrics.DummyMetrics{}).(*metric.FakeMetricsFetcher)
+
+	_, _, err := GetNumaMetricWithStaleness(fetcher, metrics.DummyMetrics{}, consts.MetricMemBandwidthNuma, 0, 10*time.Second)
+	assert.NotNil(t, err)
+
+	fresh := time.Now()
+	old := fresh.Add(-30 * time.Second)
+	fetcher.SetNumaMetric(0, consts.MetricMemBandwidthNuma, metricutil.MetricData{Value: 10, Time: &fresh})
+	fetcher.SetNumaMetric(1, consts.MetricMemBandwidthNuma, metricutil.MetricData{Value: 20, Time: &old})
+
+	data, stale, err := GetNumaMetricWithStaleness(fetcher, metrics.DummyMetrics{}, consts.MetricMemBandwidthNuma, 0, 10*time.Second)
+	assert.NoError(t, err)
+	assert.False(t, stale)
+	assert.Equal(t, 10.0, data.Value)
+
+	data, stale, err = GetNumaMetricWithStaleness(fetcher, metrics.DummyMetrics{}, consts.MetricMemBandwidthNuma, 1, 10*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, stale)
+	assert.Equal(t, 20.0, data.Value)
+}
@@ -38,6 +38,7 @@ type BitMask interface {
 	String() string
 	Count() int
 	GetBits() []int
+	ForEachBit(callback func(bit int) bool)
 }
 
 type bitMask uint64
@@ -177,6 +178,16 @@ func (s *bitMask) GetBits() []int {
 	return bits
 }
 
+// ForEachBit calls callback with each bit number set to one in ascending order
+// until it returns false, unlike GetBits it doesn't allocate a slice for the bits.
+func (s *bitMask) ForEachBit(callback func(bit int) bool) {
+	for m := uint64(*s); m != 0; m &= m - 1 {
+		if !callback(bits.TrailingZeros64(m)) {
+			return
+		}
+	}
+}
+
 // And is a package level implementation of 'and' between first and masks
 func And(first BitMask, masks ...BitMask) BitMask {
 	s := *first.(*bitMask)
@@ -566,6 +566,56 @@ func TestGetBits(t *testing.T) {
 	}
 }
 
+func TestForEachBit(t *testing.T) {
+	t.Parallel()
+
+	tcases := []struct {
+		name         string
+		bits         []int
+		stopAt       int
+		expectedBits []int
+	}{
+		{
+			name:         "For each bit of mask 00",
+			bits:         nil,
+			stopAt:       -1,
+			expectedBits: nil,
+		},
+		{
+			name:         "For each bit of mask 101",
+			bits:         []int{0, 2},
+			stopAt:       -1,
+			expectedBits: []int{0, 2},
+		},
+		{
+			name:         "For each bit of mask with the highest bit",
+			bits:         []int{1, 5, 63},
+			stopAt:       -1,
+			expectedBits: []int{1, 5, 63},
+		},
+		{
+			name:         "Stop iteration of mask 1111 at bit 2",
+			bits:         []int{0, 1, 2, 3},
+			stopAt:       2,
+			expectedBits: []int{0, 1, 2},
+		},
+	}
+	for _, tc := range tcases {
+		mask, _ := NewBitMask(tc.bits...)
+		var bits []int
+		mask.ForEachBit(func(bit int) bool {
+			bits = append(bits, bit)
+			return bit != tc.stopAt
+		})
+		if !reflect.DeepEqual(bits, tc.expectedBits) {
+			t.Errorf("%v: expected value to be %v, got %v", tc.name, tc.expectedBits, bits)
+		}
+		if tc.stopAt < 0 && !reflect.DeepEqual(bits, mask.GetBits()) {
+			t.Errorf("%v: expected value to be the same as GetBits %v, got %v", tc.name, mask.GetBits(), bits)
+		}
+	}
+}
+
 func TestIsNarrowerThan(t *testing.T) {
 	t.Parallel()
 
@@ -662,3 +712,30 @@ func TestIterateBitMasks(t *testing.T) {
 		}
 	}
 }
+
+func BenchmarkBitMaskIteration(b *testing.B) {
+	mask, _ := NewBitMask(0, 1, 2, 3, 4, 5, 6, 7)
+
+	b.Run("GetBits", func(b *testing.B) {
+		b.ReportAllocs()
+		sum := 0
+		for i := 0; i < b.N; i++ {
+			for _, bit := range mask.GetBits() {
+				sum += bit
+			}
+		}
+		_ = sum
+	})
+	b.Run("ForEachBit", func(b *testing.B) {
+		b.ReportAllocs()
+		sum := 0
+		sumBit := func(bit int) bool {
+			sum += bit
+			return true
+		}
+		for i := 0; i < b.N; i++ {
+			mask.ForEachBit(sumBit)
+		}
+		_ = sum
+	})
+}
@@ -52,6 +52,27 @@ func ApplyCPUWithRelativePath(relCgroupPath string, data *common.CPUData) error
 	return GetManager().ApplyCPU(absCgroupPath, data)
 }
 
+func ApplyCPUWithAbsolutePath(absCgroupPath string, data *common.CPUData) error {
+	if data == nil {
+		return fmt.Errorf("ApplyCPUWithAbsolutePath with nil cgroup data")
+	}
+
+	return GetManager().ApplyCPU(absCgroupPath, data)
+}
+
+func ApplyCPUForContainer(podUID, containerId string, data *common.CPUData) error {
+	if data == nil {
+		return fmt.Errorf("ApplyCPUForContainer with nil cgroup data")
+	}
+
+	cpuAbsCGPath, err := common.GetContainerAbsCgroupPath(common.CgroupSubsysCPU, podUID, containerId)
+	if err != nil {
+		return fmt.Errorf("GetContainerAbsCgroupPath failed with error: %v", err)
+	}
+
+	return ApplyCPUWithAbsolutePath(cpuAbsCGPath, data)
+}
+
 func ApplyCPUSetWithRelativePath(relCgroupPath string, data *common.CPUSetData) error {
 	if data == nil {
 		return fmt.Errorf("ApplyCPUSetForContainer with nil cgroup data")
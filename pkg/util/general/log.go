@@ -17,6 +17,7 @@ limitations under the License.
 package general
 
 import (
+	"context"
 	"fmt"
 	"runtime"
 	"strconv"
@@ -180,6 +181,24 @@ func LoggerWithPrefix(prefix string, pkg LoggingPKG) Logger {
 	return Logger{pkg: pkg, prefix: prefix}
 }
 
+type loggerContextKey struct{}
+
+// ContextWithLogger returns a copy of ctx carrying the logger, so that functions along a call chain
+// can log with the same prefix (e.g. a correlation id of a request) by LoggerFromContext.
+func ContextWithLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// LoggerFromContext returns the logger carried by ctx, or a logger without prefix if there is none.
+func LoggerFromContext(ctx context.Context) Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(loggerContextKey{}).(Logger); ok {
+			return logger
+		}
+	}
+	return LoggerWithPrefix("", getDefaultLoggingPackage())
+}
+
 func (l Logger) logging(message string, params ...interface{}) string {
 	return "[" + l.prefix + loggingWithDepth(l.pkg) + "] " + fmt.Sprintf(message, params...)
 }
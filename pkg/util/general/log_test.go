@@ -17,6 +17,7 @@ limitations under the License.
 package general
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
@@ -99,3 +100,14 @@ func TestLogging(t *testing.T) {
 
 	time.Sleep(time.Millisecond)
 }
+
+func TestLoggerFromContext(t *testing.T) {
+	t.Parallel()
+
+	loggerWithoutPrefix := testLogger{klog: LoggerFromContext(context.Background())}.log("extra %v %v", 1, "test")
+	require.Equal(t, "[katalyst-core/pkg/util/general.TestLoggerFromContext] extra 1 test", loggerWithoutPrefix)
+
+	ctx := ContextWithLogger(context.Background(), LoggerWithPrefix("p-test", LoggingPKGShort))
+	loggerWithPrefix := testLogger{klog: LoggerFromContext(ctx)}.log("extra %v %v", 1, "test")
+	require.Equal(t, "[p-test: general.TestLoggerFromContext] extra 1 test", loggerWithPrefix)
+}
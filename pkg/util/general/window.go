@@ -250,7 +250,7 @@ func (w *percentileWithTTLSmoothWindow) GetWindowedResources(value resource.Quan
 		return nil
 	}
 
-	v := w.getValueByPercentile(validSamples, w.percentile)
+	v := GetQuantityByPercentile(validSamples, w.percentile)
 
 	if w.usedMillValue {
 		return resource.NewMilliQuantity(v.MilliValue(), value.Format)
@@ -259,7 +259,9 @@ func (w *percentileWithTTLSmoothWindow) GetWindowedResources(value resource.Quan
 	return resource.NewQuantity(v.Value(), value.Format)
 }
 
-func (w *percentileWithTTLSmoothWindow) getValueByPercentile(values []resource.Quantity, percentile float64) resource.Quantity {
+// GetQuantityByPercentile returns the quantity at the given percentile (in [0, 100]) of values,
+// values must not be empty and they're sorted in place.
+func GetQuantityByPercentile(values []resource.Quantity, percentile float64) resource.Quantity {
 	sort.Slice(values, func(i, j int) bool {
 		return values[i].Cmp(values[j]) < 0
 	})
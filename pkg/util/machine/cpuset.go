@@ -19,7 +19,6 @@ package machine
 import (
 	"bytes"
 	"fmt"
-	"reflect"
 	"sort"
 	"strconv"
 	"strings"
@@ -41,6 +40,12 @@ func NewCPUSet(cpus ...int) CPUSet {
 	return cs
 }
 
+// newCPUSetWithCapacity returns an empty CPU set with room for capacity elements,
+// to avoid rehashing when the count of elements is known in advance.
+func newCPUSetWithCapacity(capacity int) CPUSet {
+	return CPUSet{true, make(map[int]struct{}, capacity)}
+}
+
 func NewCPUSetUint64(cpus ...uint64) (CPUSet, error) {
 	cs := CPUSet{true, make(map[int]struct{})}
 	err := cs.AddUint64(cpus...)
@@ -52,7 +57,7 @@ func NewCPUSetUint64(cpus ...uint64) (CPUSet, error) {
 }
 
 func (s CPUSet) Clone() CPUSet {
-	s2 := NewCPUSet()
+	s2 := newCPUSetWithCapacity(s.Size())
 	for elem := range s.elems {
 		s2.Add(elem)
 	}
@@ -109,7 +114,8 @@ func (s CPUSet) AddUint64(elems ...uint64) error {
 	return nil
 }
 
-// Size returns the number of elements in this set.
+// Size returns the number of elements in this set, it's O(1) since
+// the count of elements is maintained by the underlying map.
 func (s CPUSet) Size() int {
 	return len(s.elems)
 }
@@ -126,9 +132,10 @@ func (s CPUSet) Contains(cpu int) bool {
 }
 
 // Equals returns true if the supplied set contains exactly the same elements
-// as this set (s IsSubsetOf s2 and s2 IsSubsetOf s).
+// as this set (s IsSubsetOf s2 and s2 IsSubsetOf s), regardless of the order
+// elements are added and whether the sets are initialed.
 func (s CPUSet) Equals(s2 CPUSet) bool {
-	return reflect.DeepEqual(s.elems, s2.elems)
+	return s.Size() == s2.Size() && s.IsSubsetOf(s2)
 }
 
 // Filter returns a new CPU set that contains all elements from this
@@ -155,22 +162,25 @@ func (s CPUSet) FilterNot(predicate func(int) bool) CPUSet {
 	return s2
 }
 
-// IsSubsetOf returns true if the supplied set contains all the elements
+// IsSubsetOf returns true if the supplied set contains all the elements of this set,
+// and an empty set is subset of any set.
 func (s CPUSet) IsSubsetOf(s2 CPUSet) bool {
-	result := true
+	if s.Size() > s2.Size() {
+		return false
+	}
+
 	for cpu := range s.elems {
 		if !s2.Contains(cpu) {
-			result = false
-			break
+			return false
 		}
 	}
-	return result
+	return true
 }
 
 // Union returns a new CPU set that contains all elements from this
 // set and all elements from the supplied set, without mutating either source set.
 func (s CPUSet) Union(s2 CPUSet) CPUSet {
-	s3 := NewCPUSet()
+	s3 := newCPUSetWithCapacity(s.Size() + s2.Size())
 	for cpu := range s.elems {
 		s3.Add(cpu)
 	}
@@ -202,6 +212,23 @@ func (s CPUSet) Intersection(s2 CPUSet) CPUSet {
 	return s.Filter(func(cpu int) bool { return s2.Contains(cpu) })
 }
 
+// IntersectionSize returns the number of elements that are present in both
+// this set and the supplied set, it's the same as Intersection(s2).Size()
+// but doesn't allocate a new set.
+func (s CPUSet) IntersectionSize(s2 CPUSet) int {
+	if s.Size() > s2.Size() {
+		s, s2 = s2, s
+	}
+
+	count := 0
+	for cpu := range s.elems {
+		if s2.Contains(cpu) {
+			count++
+		}
+	}
+	return count
+}
+
 // Difference returns a new CPU set that contains all of the elements that
 // are present in this set and not the supplied set, without mutating either
 // source set.
@@ -209,6 +236,21 @@ func (s CPUSet) Difference(s2 CPUSet) CPUSet {
 	return s.FilterNot(func(cpu int) bool { return s2.Contains(cpu) })
 }
 
+// DifferenceSize returns the number of elements that are present in this set
+// and not the supplied set, it's the same as Difference(s2).Size() but doesn't
+// allocate a new set.
+func (s CPUSet) DifferenceSize(s2 CPUSet) int {
+	return s.Size() - s.IntersectionSize(s2)
+}
+
+// ForEach calls f with each element of this set in unspecified order,
+// unlike ToSliceNoSortInt it doesn't allocate a slice for the elements.
+func (s CPUSet) ForEach(f func(cpu int)) {
+	for cpu := range s.elems {
+		f(cpu)
+	}
+}
+
 // ToSliceInt returns an ordered slice of int that contains
 // all elements from this set
 func (s CPUSet) ToSliceInt() []int {
@@ -273,7 +315,8 @@ func (s CPUSet) ToSliceNoSortUInt64() []uint64 {
 }
 
 // String returns a new string representation of the elements in this CPU set
-// in canonical linux CPU list format.
+// in canonical linux CPU list format, which is guaranteed to be parsed back by ParseCPUSet
+// to an equal CPU set.
 //
 // See: http://man7.org/linux/man-pages/man7/cpuset.7.html#FORMATS
 func (s CPUSet) String() string {
@@ -367,3 +410,80 @@ func Parse(s string) (CPUSet, error) {
 	}
 	return s2, nil
 }
+
+// ParseCPUSet constructs a new CPU set from a Linux CPU list formatted string (e.g. "0-3,8,10-11")
+// exchanged with external tools, it's stricter than Parse and rejects malformed input, including
+// empty elements, negative or non-numeric cpus and reversed ranges.
+// leading and trailing whitespaces of the string and of each element are ignored, and
+// empty string (or only whitespaces) is parsed as an empty CPU set.
+// duplicated cpus (e.g. overlapping ranges in existing checkpoints or cgroups) are accepted
+// with a warning, use ParseCPUSetStrict to reject them.
+func ParseCPUSet(s string) (CPUSet, error) {
+	return parseCPUSet(s, true)
+}
+
+// ParseCPUSetStrict is the same as ParseCPUSet, except that duplicated cpus are rejected.
+func ParseCPUSetStrict(s string) (CPUSet, error) {
+	return parseCPUSet(s, false)
+}
+
+func parseCPUSet(s string, allowDuplicates bool) (CPUSet, error) {
+	result := NewCPUSet()
+	duplicates := NewCPUSet()
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return result, nil
+	}
+
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			return NewCPUSet(), fmt.Errorf("empty element in cpu list: %q", s)
+		}
+
+		boundaries := strings.Split(r, "-")
+		if len(boundaries) > 2 {
+			return NewCPUSet(), fmt.Errorf("invalid range: %q in cpu list: %q", r, s)
+		}
+
+		start, err := parseCPUID(boundaries[0])
+		if err != nil {
+			return NewCPUSet(), fmt.Errorf("invalid element: %q in cpu list: %q: %v", r, s, err)
+		}
+
+		end := start
+		if len(boundaries) == 2 {
+			end, err = parseCPUID(boundaries[1])
+			if err != nil {
+				return NewCPUSet(), fmt.Errorf("invalid element: %q in cpu list: %q: %v", r, s, err)
+			} else if end < start {
+				return NewCPUSet(), fmt.Errorf("reversed range: %q in cpu list: %q", r, s)
+			}
+		}
+
+		for cpu := start; cpu <= end; cpu++ {
+			if result.Contains(cpu) {
+				if !allowDuplicates {
+					return NewCPUSet(), fmt.Errorf("duplicated cpu: %d in cpu list: %q", cpu, s)
+				}
+				duplicates.Add(cpu)
+			}
+			result.Add(cpu)
+		}
+	}
+
+	if !duplicates.IsEmpty() {
+		klog.Warningf("duplicated cpus: %s in cpu list: %q", duplicates.String(), s)
+	}
+	return result, nil
+}
+
+// parseCPUID parses a single non-negative cpu id, signs are not allowed
+func parseCPUID(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+		return 0, fmt.Errorf("non-numeric cpu: %q", s)
+	}
+	return strconv.Atoi(s)
+}
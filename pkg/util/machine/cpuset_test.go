@@ -0,0 +1,470 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCPUSetEquals(t *testing.T) {
+	t.Parallel()
+
+	addInOrder := func(cpus ...int) CPUSet {
+		s := NewCPUSet()
+		for _, cpu := range cpus {
+			s.Add(cpu)
+		}
+		return s
+	}
+
+	testCases := []struct {
+		name   string
+		s1     CPUSet
+		s2     CPUSet
+		equals bool
+	}{
+		{
+			name:   "different insertion orders",
+			s1:     addInOrder(3, 1, 2, 8),
+			s2:     addInOrder(8, 2, 3, 1),
+			equals: true,
+		},
+		{
+			name:   "built by union in different orders",
+			s1:     NewCPUSet(4, 5).Union(NewCPUSet(0, 1)),
+			s2:     NewCPUSet(1).Union(NewCPUSet(5, 0, 4)),
+			equals: true,
+		},
+		{
+			name:   "built by parsing and adding",
+			s1:     MustParse("0-3,8"),
+			s2:     addInOrder(8, 3, 2, 1, 0),
+			equals: true,
+		},
+		{
+			name:   "uninitialed and empty sets",
+			s1:     CPUSet{},
+			s2:     NewCPUSet(),
+			equals: true,
+		},
+		{
+			name:   "empty set after difference",
+			s1:     NewCPUSet(1, 2).Difference(NewCPUSet(2, 1)),
+			s2:     CPUSet{},
+			equals: true,
+		},
+		{
+			name:   "same size with different elements",
+			s1:     NewCPUSet(1, 2),
+			s2:     NewCPUSet(1, 3),
+			equals: false,
+		},
+		{
+			name:   "superset",
+			s1:     NewCPUSet(1, 2),
+			s2:     NewCPUSet(1, 2, 3),
+			equals: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			as.Equal(tc.equals, tc.s1.Equals(tc.s2))
+			as.Equal(tc.equals, tc.s2.Equals(tc.s1))
+			as.Equal(tc.equals, tc.s1.IsSubsetOf(tc.s2) && tc.s2.IsSubsetOf(tc.s1))
+		})
+	}
+}
+
+func TestCPUSetIsSubsetOf(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		s1       CPUSet
+		s2       CPUSet
+		isSubset bool
+	}{
+		{
+			name:     "proper subset",
+			s1:       NewCPUSet(2, 1),
+			s2:       NewCPUSet(1, 2, 3),
+			isSubset: true,
+		},
+		{
+			name:     "equal sets in different insertion orders",
+			s1:       NewCPUSet(3, 2, 1),
+			s2:       NewCPUSet(1, 2, 3),
+			isSubset: true,
+		},
+		{
+			name:     "uninitialed set is subset of any set",
+			s1:       CPUSet{},
+			s2:       NewCPUSet(1),
+			isSubset: true,
+		},
+		{
+			name:     "superset",
+			s1:       NewCPUSet(1, 2, 3),
+			s2:       NewCPUSet(1, 2),
+			isSubset: false,
+		},
+		{
+			name:     "disjoint sets",
+			s1:       NewCPUSet(4),
+			s2:       NewCPUSet(1, 2),
+			isSubset: false,
+		},
+		{
+			name:     "non-empty set isn't subset of uninitialed set",
+			s1:       NewCPUSet(1),
+			s2:       CPUSet{},
+			isSubset: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tc.isSubset, tc.s1.IsSubsetOf(tc.s2))
+		})
+	}
+}
+
+func TestCPUSetEqualsAfterJSONRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	for _, s := range []CPUSet{NewCPUSet(7, 0, 3, 1), NewCPUSet(), {}} {
+		data, err := json.Marshal(s)
+		as.Nil(err)
+
+		restored := CPUSet{}
+		as.Nil(json.Unmarshal(data, &restored))
+		as.True(s.Equals(restored), "cpuset: %s, restored: %s", s.String(), restored.String())
+	}
+}
+
+func TestParseCPUSet(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		input   string
+		want    CPUSet
+		wantErr bool
+		// wantStrictErr is whether ParseCPUSetStrict fails, it's the same as wantErr if not set
+		wantStrictErr bool
+	}{
+		{
+			name:  "ranges and single cpus",
+			input: "0-3,8,10-11",
+			want:  NewCPUSet(0, 1, 2, 3, 8, 10, 11),
+		},
+		{
+			name:  "unordered elements",
+			input: "8,0-1",
+			want:  NewCPUSet(0, 1, 8),
+		},
+		{
+			name:  "single-element range",
+			input: "5-5",
+			want:  NewCPUSet(5),
+		},
+		{
+			name:  "empty",
+			input: "",
+			want:  NewCPUSet(),
+		},
+		{
+			name:  "only whitespaces",
+			input: " \n",
+			want:  NewCPUSet(),
+		},
+		{
+			name:  "whitespaces around elements",
+			input: " 0 - 1 , 4\n",
+			want:  NewCPUSet(0, 1, 4),
+		},
+		{
+			name:    "whitespaces inside cpu",
+			input:   "1 2",
+			wantErr: true,
+		},
+		{
+			name:    "reversed range",
+			input:   "3-1",
+			wantErr: true,
+		},
+		{
+			name:    "non-numeric",
+			input:   "0-a",
+			wantErr: true,
+		},
+		{
+			name:    "negative cpu",
+			input:   "-1",
+			wantErr: true,
+		},
+		{
+			name:    "signed cpu",
+			input:   "+1",
+			wantErr: true,
+		},
+		{
+			name:    "too many boundaries",
+			input:   "0-1-2",
+			wantErr: true,
+		},
+		{
+			name:    "empty element",
+			input:   "0,,1",
+			wantErr: true,
+		},
+		{
+			name:    "trailing comma",
+			input:   "0,1,",
+			wantErr: true,
+		},
+		{
+			name:          "duplicated cpus",
+			input:         "0-3,2",
+			want:          NewCPUSet(0, 1, 2, 3),
+			wantStrictErr: true,
+		},
+		{
+			name:          "overlapping ranges",
+			input:         "0-3,2-5",
+			want:          NewCPUSet(0, 1, 2, 3, 4, 5),
+			wantStrictErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			as := require.New(t)
+			got, err := ParseCPUSet(tc.input)
+			if tc.wantErr {
+				as.NotNil(err)
+			} else {
+				as.Nil(err)
+				as.True(tc.want.Equals(got), "want: %s, got: %s", tc.want.String(), got.String())
+			}
+
+			got, err = ParseCPUSetStrict(tc.input)
+			if tc.wantErr || tc.wantStrictErr {
+				as.NotNil(err)
+			} else {
+				as.Nil(err)
+				as.True(tc.want.Equals(got), "want: %s, got: %s", tc.want.String(), got.String())
+			}
+		})
+	}
+}
+
+func TestParseCPUSetRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	for _, s := range []CPUSet{NewCPUSet(), NewCPUSet(0), NewCPUSet(0, 1, 2, 3, 8, 10, 11), NewCPUSet(63, 1, 5, 6, 7, 32)} {
+		restored, err := ParseCPUSetStrict(s.String())
+		as.Nil(err)
+		as.True(s.Equals(restored), "cpuset: %s, restored: %s", s.String(), restored.String())
+		as.Equal(s.String(), restored.String())
+	}
+}
+
+// referenceCPUSetOperation computes the result of a set operation on sorted slices of cpus,
+// it's independent of the representation of CPUSet and used to verify its semantics.
+func referenceCPUSetOperation(cpus1, cpus2 []int, keep func(in1, in2 bool) bool) []int {
+	in1, in2 := make(map[int]bool), make(map[int]bool)
+	for _, cpu := range cpus1 {
+		in1[cpu] = true
+	}
+	for _, cpu := range cpus2 {
+		in2[cpu] = true
+	}
+
+	result := []int{}
+	for cpu := 0; cpu < 256; cpu++ {
+		if keep(in1[cpu], in2[cpu]) {
+			result = append(result, cpu)
+		}
+	}
+	return result
+}
+
+func generateRandomCPUs(r *rand.Rand, numCPUs int) []int {
+	cpus := []int{}
+	for cpu := 0; cpu < numCPUs; cpu++ {
+		if r.Intn(2) == 0 {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus
+}
+
+func TestCPUSetOperationsAgainstReference(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		cpus1, cpus2 := generateRandomCPUs(r, 256), generateRandomCPUs(r, 256)
+		if i%10 == 0 {
+			cpus2 = []int{}
+		}
+		s1, s2 := NewCPUSet(cpus1...), NewCPUSet(cpus2...)
+
+		union := referenceCPUSetOperation(cpus1, cpus2, func(in1, in2 bool) bool { return in1 || in2 })
+		intersection := referenceCPUSetOperation(cpus1, cpus2, func(in1, in2 bool) bool { return in1 && in2 })
+		difference := referenceCPUSetOperation(cpus1, cpus2, func(in1, in2 bool) bool { return in1 && !in2 })
+
+		as.Equal(len(cpus1), s1.Size())
+		as.Equal(union, s1.Union(s2).ToSliceInt())
+		as.Equal(len(union), s1.Union(s2).Size())
+		as.Equal(union, s1.UnionAll([]CPUSet{s2}).ToSliceInt())
+		as.Equal(intersection, s1.Intersection(s2).ToSliceInt())
+		as.Equal(len(intersection), s1.Intersection(s2).Size())
+		as.Equal(len(intersection), s1.IntersectionSize(s2))
+		as.Equal(len(intersection), s2.IntersectionSize(s1))
+		as.Equal(difference, s1.Difference(s2).ToSliceInt())
+		as.Equal(len(difference), s1.Difference(s2).Size())
+		as.Equal(len(difference), s1.DifferenceSize(s2))
+		as.Equal(cpus1, s1.Clone().ToSliceInt())
+
+		// source sets aren't mutated by the operations
+		as.Equal(cpus1, s1.ToSliceInt())
+		as.Equal(cpus2, s2.ToSliceInt())
+	}
+
+	// uninitialed sets behave as empty sets
+	as.Equal(0, CPUSet{}.IntersectionSize(NewCPUSet(1, 2)))
+	as.Equal(2, NewCPUSet(1, 2).DifferenceSize(CPUSet{}))
+	as.Equal(0, CPUSet{}.DifferenceSize(CPUSet{}))
+}
+
+func TestCPUSetForEach(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		cpus := generateRandomCPUs(r, 256)
+		visited := make(map[int]int)
+		NewCPUSet(cpus...).ForEach(func(cpu int) {
+			visited[cpu]++
+		})
+
+		as.Equal(len(cpus), len(visited))
+		for _, cpu := range cpus {
+			as.Equal(1, visited[cpu])
+		}
+	}
+
+	// uninitialed sets behave as empty sets
+	CPUSet{}.ForEach(func(cpu int) {
+		as.Fail("unexpected cpu in empty set", "cpu: %d", cpu)
+	})
+}
+
+func TestCPUSetClone(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	s1 := NewCPUSet(1, 2, 3)
+	s2 := s1.Clone()
+	as.True(s1.Equals(s2))
+
+	// sets share no elements with their clones
+	s2.Add(4)
+	s1.Add(0)
+	as.Equal([]int{0, 1, 2, 3}, s1.ToSliceInt())
+	as.Equal([]int{1, 2, 3, 4}, s2.ToSliceInt())
+
+	// uninitialed sets are cloned as empty sets which can be added to
+	s3 := CPUSet{}.Clone()
+	s3.Add(1)
+	as.Equal([]int{1}, s3.ToSliceInt())
+}
+
+func BenchmarkCPUSetSize(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	allCPUs := NewCPUSet(referenceCPUSetOperation(nil, nil, func(_, _ bool) bool { return true })...)
+	unavailableCPUs := NewCPUSet(generateRandomCPUs(r, 256)...)
+
+	b.Run("Size", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = allCPUs.Size()
+		}
+	})
+	b.Run("Difference().Size()", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = allCPUs.Difference(unavailableCPUs).Size()
+		}
+	})
+	b.Run("DifferenceSize", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = allCPUs.DifferenceSize(unavailableCPUs)
+		}
+	})
+	b.Run("Union", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = allCPUs.Union(unavailableCPUs)
+		}
+	})
+}
+
+func BenchmarkCPUSetIteration(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	cpus := NewCPUSet(generateRandomCPUs(r, 256)...)
+
+	b.Run("ToSliceNoSortInt", func(b *testing.B) {
+		b.ReportAllocs()
+		sum := 0
+		for i := 0; i < b.N; i++ {
+			for _, cpu := range cpus.ToSliceNoSortInt() {
+				sum += cpu
+			}
+		}
+		_ = sum
+	})
+	b.Run("ForEach", func(b *testing.B) {
+		b.ReportAllocs()
+		sum := 0
+		sumCPU := func(cpu int) {
+			sum += cpu
+		}
+		for i := 0; i < b.N; i++ {
+			cpus.ForEach(sumCPU)
+		}
+		_ = sum
+	})
+}
@@ -43,6 +43,16 @@ func GetKatalystMachineInfo(conf *global.MachineInfoConfiguration) (*KatalystMac
 		return nil, err
 	}
 
+	err = discoverCoreClasses(cpuTopology, sysDevicesDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	err = discoverL3CacheDomains(cpuTopology, sysCPUDirectory)
+	if err != nil {
+		return nil, err
+	}
+
 	extraCPUInfo, err := GetExtraCPUInfo()
 	if err != nil {
 		return nil, err
@@ -19,6 +19,7 @@ package machine
 import (
 	"fmt"
 	"math"
+	"sort"
 
 	info "github.com/google/cadvisor/info/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -47,6 +48,43 @@ type CPUTopology struct {
 	NumSockets   int
 	NumNUMANodes int
 	CPUDetails   CPUDetails
+
+	// numaSocketMap is the precomputed sockets of each NUMA node, since topology is static;
+	// it may be nil if CPUTopology isn't constructed by Discover or GenerateDummyCPUTopology.
+	numaSocketMap map[int]sets.Int
+}
+
+// initNUMASocketMap precomputes the sockets of each NUMA node by CPUDetails
+func (topo *CPUTopology) initNUMASocketMap() {
+	topo.numaSocketMap = make(map[int]sets.Int, topo.NumNUMANodes)
+	for _, info := range topo.CPUDetails {
+		if topo.numaSocketMap[info.NUMANodeID] == nil {
+			topo.numaSocketMap[info.NUMANodeID] = sets.NewInt()
+		}
+		topo.numaSocketMap[info.NUMANodeID].Insert(info.SocketID)
+	}
+}
+
+// MaskCrossesSockets judges whether the given NUMA nodes are located in different sockets,
+// it consults the precomputed socket membership if possible.
+func (topo *CPUTopology) MaskCrossesSockets(bits []int) bool {
+	if topo == nil || len(bits) <= 1 {
+		return false
+	} else if topo.numaSocketMap == nil {
+		return topo.CPUDetails.SocketsInNUMANodes(bits...).Size() > 1
+	}
+
+	firstSocket := -1
+	for _, numaID := range bits {
+		for socketID := range topo.numaSocketMap[numaID] {
+			if firstSocket == -1 {
+				firstSocket = socketID
+			} else if socketID != firstSocket {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 type MemoryDetails map[int]uint64
@@ -216,6 +254,55 @@ func GenerateDummyCPUTopology(cpuNum, socketNum, numaNum int) (*CPUTopology, err
 		}
 	}
 
+	cpuTopology.initNUMASocketMap()
+
+	return cpuTopology, nil
+}
+
+// GenerateDummyHybridCPUTopology generates the same topology as GenerateDummyCPUTopology, except that
+// the last efficiencyCoresPerNUMA cores of each NUMA are efficiency cores and the others are performance cores.
+func GenerateDummyHybridCPUTopology(cpuNum, socketNum, numaNum, efficiencyCoresPerNUMA int) (*CPUTopology, error) {
+	cpuTopology, err := GenerateDummyCPUTopology(cpuNum, socketNum, numaNum)
+	if err != nil {
+		return nil, err
+	}
+
+	coresPerNUMA := cpuNum / numaNum / 2
+	if efficiencyCoresPerNUMA < 0 || efficiencyCoresPerNUMA > coresPerNUMA {
+		return nil, fmt.Errorf("invalid efficiency cores per NUMA: %d with cores per NUMA: %d",
+			efficiencyCoresPerNUMA, coresPerNUMA)
+	}
+
+	for cpu, cpuInfo := range cpuTopology.CPUDetails {
+		cpuInfo.CoreClass = CoreClassPerformance
+		if cpuInfo.CoreID%coresPerNUMA >= coresPerNUMA-efficiencyCoresPerNUMA {
+			cpuInfo.CoreClass = CoreClassEfficiency
+		}
+		cpuTopology.CPUDetails[cpu] = cpuInfo
+	}
+	return cpuTopology, nil
+}
+
+// GenerateDummyL3CacheCPUTopology generates the same topology as GenerateDummyCPUTopology, except that
+// cores of each NUMA are divided evenly into l3CachesPerNUMA L3 cache domains in the order of core ids.
+func GenerateDummyL3CacheCPUTopology(cpuNum, socketNum, numaNum, l3CachesPerNUMA int) (*CPUTopology, error) {
+	cpuTopology, err := GenerateDummyCPUTopology(cpuNum, socketNum, numaNum)
+	if err != nil {
+		return nil, err
+	}
+
+	coresPerNUMA := cpuNum / numaNum / 2
+	if l3CachesPerNUMA <= 0 || coresPerNUMA%l3CachesPerNUMA != 0 {
+		return nil, fmt.Errorf("invalid L3 caches per NUMA: %d with cores per NUMA: %d",
+			l3CachesPerNUMA, coresPerNUMA)
+	}
+
+	coresPerL3Cache := coresPerNUMA / l3CachesPerNUMA
+	for cpu, cpuInfo := range cpuTopology.CPUDetails {
+		// the lowest cpu of cores in a cache domain is the first core id in it
+		cpuInfo.L3CacheID = cpuInfo.CoreID / coresPerL3Cache * coresPerL3Cache
+		cpuTopology.CPUDetails[cpu] = cpuInfo
+	}
 	return cpuTopology, nil
 }
 
@@ -267,11 +354,24 @@ func GenerateDummyExtraTopology(numaNum int) (*ExtraTopologyInfo, error) {
 	return extraTopology, nil
 }
 
+// CoreClass is the capability class of the physical core on hybrid machines (e.g. with P+E cores),
+// it's empty for cores of machines with uniform cores.
+type CoreClass string
+
+const (
+	CoreClassPerformance CoreClass = "performance"
+	CoreClassEfficiency  CoreClass = "efficiency"
+)
+
 // CPUInfo contains the NUMA, socket, and core IDs associated with a CPU.
 type CPUInfo struct {
 	NUMANodeID int
 	SocketID   int
 	CoreID     int
+	CoreClass  CoreClass
+	// L3CacheID is the lowest cpu sharing the same L3 cache with the cpu, so it's unique across the machine;
+	// it's 0 for all cpus if L3 caches aren't discovered, i.e. all cpus are regarded in a single cache domain.
+	L3CacheID int
 }
 
 // KeepOnly returns a new CPUDetails object with only the supplied cpus.
@@ -419,6 +519,66 @@ func (d CPUDetails) CPUsInCores(ids ...int) CPUSet {
 	return b
 }
 
+// FullCoresInCPUs returns all core IDs in this CPUDetails whose logical
+// CPUs are all within the given cpus.
+func (d CPUDetails) FullCoresInCPUs(cpus CPUSet) CPUSet {
+	cores, partialCores := NewCPUSet(), NewCPUSet()
+	for cpu, info := range d {
+		if cpus.Contains(cpu) {
+			cores.Add(info.CoreID)
+		} else {
+			partialCores.Add(info.CoreID)
+		}
+	}
+	return cores.Difference(partialCores)
+}
+
+// CPUsInCoreClasses returns all of the logical CPU IDs associated with the
+// given core classes in this CPUDetails.
+func (d CPUDetails) CPUsInCoreClasses(classes ...CoreClass) CPUSet {
+	b := NewCPUSet()
+	for _, class := range classes {
+		for cpu, info := range d {
+			if info.CoreClass == class {
+				b.Add(cpu)
+			}
+		}
+	}
+	return b
+}
+
+// IsHybrid returns true if cores of the topology are of more than one class
+func (topo *CPUTopology) IsHybrid() bool {
+	classes := make(map[CoreClass]struct{})
+	for _, info := range topo.CPUDetails {
+		classes[info.CoreClass] = struct{}{}
+	}
+	return len(classes) > 1
+}
+
+// L3CacheDomains returns all L3 cache IDs associated with the CPUs in this CPUDetails.
+func (d CPUDetails) L3CacheDomains() CPUSet {
+	b := NewCPUSet()
+	for _, info := range d {
+		b.Add(info.L3CacheID)
+	}
+	return b
+}
+
+// CPUsInL3CacheDomains returns all of the logical CPU IDs associated with the
+// given L3 cache IDs in this CPUDetails.
+func (d CPUDetails) CPUsInL3CacheDomains(ids ...int) CPUSet {
+	b := NewCPUSet()
+	for _, id := range ids {
+		for cpu, info := range d {
+			if info.L3CacheID == id {
+				b.Add(cpu)
+			}
+		}
+	}
+	return b
+}
+
 // Discover returns CPUTopology based on cadvisor node info
 func Discover(machineInfo *info.MachineInfo) (*CPUTopology, *MemoryTopology, error) {
 	if machineInfo.NumCores == 0 {
@@ -451,13 +611,16 @@ func Discover(machineInfo *info.MachineInfo) (*CPUTopology, *MemoryTopology, err
 		}
 	}
 
-	return &CPUTopology{
+	cpuTopology := &CPUTopology{
 		NumCPUs:      machineInfo.NumCores,
 		NumSockets:   machineInfo.NumSockets,
 		NumCores:     numPhysicalCores,
 		NumNUMANodes: CPUDetails.NUMANodes().Size(),
 		CPUDetails:   CPUDetails,
-	}, &memoryTopology, nil
+	}
+	cpuTopology.initNUMASocketMap()
+
+	return cpuTopology, &memoryTopology, nil
 }
 
 // getUniqueCoreID computes coreId as the lowest cpuID
@@ -482,6 +645,115 @@ func getUniqueCoreID(threads []int) (coreID int, err error) {
 	return min, nil
 }
 
+// TakeSpreadByTopology takes n cpus from the set spreading them across cores as much as possible, i.e. a core
+// gets its second cpu only after all cores in the set get one. ties are broken by preferring the socket and
+// NUMA with fewer cpus taken, and then the smaller cpu id, so the result is stable. it's the opposite of packing
+// cpus into as few cores as possible, and reduces correlated failures of a single core.
+func (s CPUSet) TakeSpreadByTopology(n int, topology *CPUTopology) (CPUSet, error) {
+	if topology == nil {
+		return NewCPUSet(), fmt.Errorf("nil topology")
+	} else if n > s.Size() {
+		return NewCPUSet(), fmt.Errorf("not enough cpus: %s to take %d", s.String(), n)
+	}
+
+	candidates := s.ToSliceInt()
+	for _, cpu := range candidates {
+		if _, ok := topology.CPUDetails[cpu]; !ok {
+			return NewCPUSet(), fmt.Errorf("cpu: %d isn't found in topology", cpu)
+		}
+	}
+
+	takenInCores, takenInSockets, takenInNUMAs := make(map[int]int), make(map[int]int), make(map[int]int)
+	spreadKey := func(cpu int) [4]int {
+		info := topology.CPUDetails[cpu]
+		return [4]int{takenInCores[info.CoreID], takenInSockets[info.SocketID], takenInNUMAs[info.NUMANodeID], cpu}
+	}
+	less := func(a, b [4]int) bool {
+		for i := range a {
+			if a[i] != b[i] {
+				return a[i] < b[i]
+			}
+		}
+		return false
+	}
+
+	result := NewCPUSet()
+	for result.Size() < n {
+		best := -1
+		for _, cpu := range candidates {
+			if result.Contains(cpu) {
+				continue
+			} else if best < 0 || less(spreadKey(cpu), spreadKey(best)) {
+				best = cpu
+			}
+		}
+
+		info := topology.CPUDetails[best]
+		takenInCores[info.CoreID]++
+		takenInSockets[info.SocketID]++
+		takenInNUMAs[info.NUMANodeID]++
+		result.Add(best)
+	}
+	return result, nil
+}
+
+// FewestL3CacheDomainsFor returns cpus of the set in the fewest L3 cache domains that have at least n cpus
+// in the set altogether, so that cpus taken from them share L3 caches as much as possible. domains with more
+// cpus are picked first, and the last one is the domain with the fewest cpus that covers what's left, to keep
+// larger domains for later requests; ties are broken by the smaller cache id, so the result is stable.
+func (s CPUSet) FewestL3CacheDomainsFor(n int, topology *CPUTopology) (CPUSet, error) {
+	if topology == nil {
+		return NewCPUSet(), fmt.Errorf("nil topology")
+	} else if n > s.Size() {
+		return NewCPUSet(), fmt.Errorf("not enough cpus: %s to take %d", s.String(), n)
+	}
+
+	cpusInDomains := make(map[int]CPUSet)
+	for _, cpu := range s.ToSliceNoSortInt() {
+		info, ok := topology.CPUDetails[cpu]
+		if !ok {
+			return NewCPUSet(), fmt.Errorf("cpu: %d isn't found in topology", cpu)
+		}
+
+		if _, ok := cpusInDomains[info.L3CacheID]; !ok {
+			cpusInDomains[info.L3CacheID] = NewCPUSet()
+		}
+		cpusInDomains[info.L3CacheID].Add(cpu)
+	}
+
+	domains := make([]int, 0, len(cpusInDomains))
+	for id := range cpusInDomains {
+		domains = append(domains, id)
+	}
+	sort.Slice(domains, func(i, j int) bool {
+		if cpusInDomains[domains[i]].Size() != cpusInDomains[domains[j]].Size() {
+			return cpusInDomains[domains[i]].Size() > cpusInDomains[domains[j]].Size()
+		}
+		return domains[i] < domains[j]
+	})
+
+	result := NewCPUSet()
+	for i, id := range domains {
+		left := n - result.Size()
+		if left <= 0 {
+			break
+		} else if cpusInDomains[id].Size() < left {
+			result = result.Union(cpusInDomains[id])
+			continue
+		}
+
+		// the domain with the fewest cpus covering what's left is the last one picked
+		last := id
+		for _, candidate := range domains[i+1:] {
+			if cpusInDomains[candidate].Size() >= left && cpusInDomains[candidate].Size() < cpusInDomains[last].Size() {
+				last = candidate
+			}
+		}
+		result = result.Union(cpusInDomains[last])
+	}
+	return result, nil
+}
+
 // GetNumaAwareAssignments returns a mapping from NUMA id to cpu core
 func GetNumaAwareAssignments(topology *CPUTopology, cset CPUSet) (map[int]CPUSet, error) {
 	if topology == nil {
@@ -507,10 +779,7 @@ func CheckNUMACrossSockets(numaNodes []int, cpuTopology *CPUTopology) (bool, err
 		return false, fmt.Errorf("CheckNUMACrossSockets got nil cpuTopology")
 	}
 
-	if len(numaNodes) <= 1 {
-		return false, nil
-	}
-	return cpuTopology.CPUDetails.SocketsInNUMANodes(numaNodes...).Size() > 1, nil
+	return cpuTopology.MaskCrossesSockets(numaNodes), nil
 }
 
 func GetSiblingNumaInfo(conf *global.MachineInfoConfiguration,
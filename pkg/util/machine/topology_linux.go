@@ -21,6 +21,7 @@ package machine
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -30,9 +31,18 @@ import (
 )
 
 const (
-	sysNodeDirectory = "/sys/devices/system/node"
+	sysNodeDirectory    = "/sys/devices/system/node"
+	sysCPUDirectory     = "/sys/devices/system/cpu"
+	sysDevicesDirectory = "/sys/devices"
 )
 
+// hybridCorePMUs are directories (under /sys/devices) of core PMUs on hybrid machines,
+// each of them lists cpus of a core class in cpuset format.
+var hybridCorePMUs = map[CoreClass]string{
+	CoreClassPerformance: "cpu_core",
+	CoreClassEfficiency:  "cpu_atom",
+}
+
 func GetExtraTopologyInfo(conf *global.MachineInfoConfiguration) (*ExtraTopologyInfo, error) {
 	fInfos, err := ioutil.ReadDir(sysNodeDirectory)
 	if err != nil {
@@ -79,3 +89,71 @@ func GetExtraTopologyInfo(conf *global.MachineInfoConfiguration) (*ExtraTopology
 		SiblingNumaInfo: GetSiblingNumaInfo(conf, numaDistanceArray),
 	}, nil
 }
+
+// discoverCoreClasses sets core classes of cpus in the topology by cpus listed by core PMUs in sysDevicesDir,
+// cpus are left unclassified on machines with uniform cores, since there is no such PMU.
+func discoverCoreClasses(cpuTopology *CPUTopology, sysDevicesDir string) error {
+	for class, pmu := range hybridCorePMUs {
+		b, err := ioutil.ReadFile(filepath.Join(sysDevicesDir, pmu, "cpus"))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("read cpus of core class: %s failed with error: %v", class, err)
+		}
+
+		cpus, err := Parse(strings.TrimSpace(string(b)))
+		if err != nil {
+			return fmt.Errorf("parse cpus of core class: %s failed with error: %v", class, err)
+		}
+
+		for _, cpu := range cpus.ToSliceInt() {
+			cpuInfo, ok := cpuTopology.CPUDetails[cpu]
+			if !ok {
+				general.Warningf("cpu: %d of core class: %s isn't in topology, skip it", cpu, class)
+				continue
+			}
+
+			cpuInfo.CoreClass = class
+			cpuTopology.CPUDetails[cpu] = cpuInfo
+		}
+	}
+	return nil
+}
+
+// discoverL3CacheDomains sets L3 cache ids of cpus in the topology by caches listed for each cpu in sysCPUDir,
+// i.e. <sysCPUDir>/cpu<N>/cache/index<M>, cpus are left in a single cache domain if there is no L3 cache info.
+func discoverL3CacheDomains(cpuTopology *CPUTopology, sysCPUDir string) error {
+	for cpu, cpuInfo := range cpuTopology.CPUDetails {
+		cacheDirs, err := filepath.Glob(filepath.Join(sysCPUDir, fmt.Sprintf("cpu%d", cpu), "cache", "index*"))
+		if err != nil {
+			return fmt.Errorf("list caches of cpu: %d failed with error: %v", cpu, err)
+		}
+
+		for _, cacheDir := range cacheDirs {
+			b, err := ioutil.ReadFile(filepath.Join(cacheDir, "level"))
+			if err != nil {
+				return fmt.Errorf("read level of cache: %s failed with error: %v", cacheDir, err)
+			} else if strings.TrimSpace(string(b)) != "3" {
+				continue
+			}
+
+			b, err = ioutil.ReadFile(filepath.Join(cacheDir, "shared_cpu_list"))
+			if err != nil {
+				return fmt.Errorf("read shared cpus of cache: %s failed with error: %v", cacheDir, err)
+			}
+
+			sharedCPUs, err := Parse(strings.TrimSpace(string(b)))
+			if err != nil {
+				return fmt.Errorf("parse shared cpus of cache: %s failed with error: %v", cacheDir, err)
+			} else if sharedCPUs.IsEmpty() {
+				general.Warningf("cache: %s is shared by no cpu, skip it", cacheDir)
+				continue
+			}
+
+			cpuInfo.L3CacheID = sharedCPUs.ToSliceInt()[0]
+			cpuTopology.CPUDetails[cpu] = cpuInfo
+			break
+		}
+	}
+	return nil
+}
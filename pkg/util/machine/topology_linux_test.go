@@ -0,0 +1,98 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscoverCoreClasses(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	sysDevicesDir, err := ioutil.TempDir("", "TestDiscoverCoreClasses")
+	as.NoError(err)
+	defer func() { _ = os.RemoveAll(sysDevicesDir) }()
+
+	// machines with uniform cores have no core PMU, and cpus are left unclassified
+	cpuTopology, err := GenerateDummyCPUTopology(16, 2, 4)
+	as.NoError(err)
+	as.NoError(discoverCoreClasses(cpuTopology, sysDevicesDir))
+	as.False(cpuTopology.IsHybrid())
+
+	for pmu, cpus := range map[string]string{"cpu_core": "0-7\n", "cpu_atom": "8-15,16\n"} {
+		as.NoError(os.MkdirAll(filepath.Join(sysDevicesDir, pmu), 0o755))
+		as.NoError(ioutil.WriteFile(filepath.Join(sysDevicesDir, pmu, "cpus"), []byte(cpus), 0o644))
+	}
+
+	// cpu 16 absent from the topology is skipped
+	as.NoError(discoverCoreClasses(cpuTopology, sysDevicesDir))
+	as.True(cpuTopology.IsHybrid())
+	as.Equal(NewCPUSet(0, 1, 2, 3, 4, 5, 6, 7), cpuTopology.CPUDetails.CPUsInCoreClasses(CoreClassPerformance))
+	as.Equal(NewCPUSet(8, 9, 10, 11, 12, 13, 14, 15), cpuTopology.CPUDetails.CPUsInCoreClasses(CoreClassEfficiency))
+
+	as.NoError(ioutil.WriteFile(filepath.Join(sysDevicesDir, "cpu_atom", "cpus"), []byte("invalid"), 0o644))
+	as.Error(discoverCoreClasses(cpuTopology, sysDevicesDir))
+}
+
+func TestDiscoverL3CacheDomains(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	sysCPUDir, err := ioutil.TempDir("", "TestDiscoverL3CacheDomains")
+	as.NoError(err)
+	defer func() { _ = os.RemoveAll(sysCPUDir) }()
+
+	// cpus are left in a single cache domain without cache info
+	cpuTopology, err := GenerateDummyCPUTopology(16, 2, 4)
+	as.NoError(err)
+	as.NoError(discoverL3CacheDomains(cpuTopology, sysCPUDir))
+	as.Equal(NewCPUSet(0), cpuTopology.CPUDetails.L3CacheDomains())
+
+	// each socket has an L3 cache, and every cpu has its own L2 cache
+	writeCache := func(cpu, index int, level, sharedCPUs string) {
+		cacheDir := filepath.Join(sysCPUDir, fmt.Sprintf("cpu%d", cpu), "cache", fmt.Sprintf("index%d", index))
+		as.NoError(os.MkdirAll(cacheDir, 0o755))
+		as.NoError(ioutil.WriteFile(filepath.Join(cacheDir, "level"), []byte(level+"\n"), 0o644))
+		as.NoError(ioutil.WriteFile(filepath.Join(cacheDir, "shared_cpu_list"), []byte(sharedCPUs+"\n"), 0o644))
+	}
+	for cpu := 0; cpu < 16; cpu++ {
+		writeCache(cpu, 2, "2", fmt.Sprintf("%d", cpu))
+		if cpuTopology.CPUDetails[cpu].SocketID == 0 {
+			writeCache(cpu, 3, "3", "0-3,8-11")
+		} else {
+			writeCache(cpu, 3, "3", "4-7,12-15")
+		}
+	}
+
+	as.NoError(discoverL3CacheDomains(cpuTopology, sysCPUDir))
+	as.Equal(NewCPUSet(0, 4), cpuTopology.CPUDetails.L3CacheDomains())
+	as.Equal(NewCPUSet(0, 1, 2, 3, 8, 9, 10, 11), cpuTopology.CPUDetails.CPUsInL3CacheDomains(0))
+	as.Equal(NewCPUSet(4, 5, 6, 7, 12, 13, 14, 15), cpuTopology.CPUDetails.CPUsInL3CacheDomains(4))
+
+	writeCache(0, 3, "3", "invalid")
+	as.Error(discoverL3CacheDomains(cpuTopology, sysCPUDir))
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+// TopologySnapshot is a serializable view of the cpu topology of the machine along with cpus reserved for
+// system and cpus offline, for consumers outside the agent (e.g. scheduler extenders). sockets, NUMA nodes,
+// cores and threads are all ordered by id.
+type TopologySnapshot struct {
+	NumCPUs      int              `json:"numCPUs"`
+	NumCores     int              `json:"numCores"`
+	NumSockets   int              `json:"numSockets"`
+	NumNUMANodes int              `json:"numNUMANodes"`
+	Sockets      []SocketSnapshot `json:"sockets"`
+	ReservedCPUs CPUSet           `json:"reservedCPUs"`
+	OfflineCPUs  CPUSet           `json:"offlineCPUs"`
+}
+
+// SocketSnapshot is a socket and NUMA nodes in it
+type SocketSnapshot struct {
+	ID        int                `json:"id"`
+	NUMANodes []NUMANodeSnapshot `json:"numaNodes"`
+}
+
+// NUMANodeSnapshot is a NUMA node and physical cores in it
+type NUMANodeSnapshot struct {
+	ID    int            `json:"id"`
+	Cores []CoreSnapshot `json:"cores"`
+}
+
+// CoreSnapshot is a physical core and its threads (i.e. logical cpus),
+// CoreClass is empty if core classes aren't discovered
+type CoreSnapshot struct {
+	ID        int       `json:"id"`
+	CoreClass CoreClass `json:"coreClass,omitempty"`
+	Threads   []int     `json:"threads"`
+}
+
+// NewTopologySnapshot builds the snapshot of the topology with the given reserved and offline cpus
+func NewTopologySnapshot(topology *CPUTopology, reservedCPUs, offlineCPUs CPUSet) *TopologySnapshot {
+	snapshot := &TopologySnapshot{
+		NumCPUs:      topology.NumCPUs,
+		NumCores:     topology.NumCores,
+		NumSockets:   topology.NumSockets,
+		NumNUMANodes: topology.NumNUMANodes,
+		Sockets:      []SocketSnapshot{},
+		ReservedCPUs: reservedCPUs.Clone(),
+		OfflineCPUs:  offlineCPUs.Clone(),
+	}
+
+	details := topology.CPUDetails
+	for _, socketID := range details.Sockets().ToSliceInt() {
+		socket := SocketSnapshot{ID: socketID, NUMANodes: []NUMANodeSnapshot{}}
+		for _, numaID := range details.NUMANodesInSockets(socketID).ToSliceInt() {
+			numaNode := NUMANodeSnapshot{ID: numaID, Cores: []CoreSnapshot{}}
+			numaCPUs := details.CPUsInNUMANodes(numaID)
+			for _, coreID := range details.CoresInNUMANodes(numaID).ToSliceInt() {
+				threads := details.CPUsInCores(coreID).Intersection(numaCPUs).ToSliceInt()
+				numaNode.Cores = append(numaNode.Cores, CoreSnapshot{
+					ID:        coreID,
+					CoreClass: details[threads[0]].CoreClass,
+					Threads:   threads,
+				})
+			}
+			socket.NUMANodes = append(socket.NUMANodes, numaNode)
+		}
+		snapshot.Sockets = append(snapshot.Sockets, socket)
+	}
+	return snapshot
+}
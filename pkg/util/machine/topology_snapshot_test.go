@@ -0,0 +1,80 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTopologySnapshot(t *testing.T) {
+	t.Parallel()
+
+	topology, err := GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+	for _, cpu := range []int{6, 14} {
+		info := topology.CPUDetails[cpu]
+		info.CoreClass = CoreClassEfficiency
+		topology.CPUDetails[cpu] = info
+	}
+
+	reservedCPUs := NewCPUSet(0, 8)
+	snapshot := NewTopologySnapshot(topology, reservedCPUs, NewCPUSet(15))
+	expected := &TopologySnapshot{
+		NumCPUs:      16,
+		NumCores:     8,
+		NumSockets:   2,
+		NumNUMANodes: 4,
+		Sockets: []SocketSnapshot{
+			{
+				ID: 0,
+				NUMANodes: []NUMANodeSnapshot{
+					{ID: 0, Cores: []CoreSnapshot{{ID: 0, Threads: []int{0, 8}}, {ID: 1, Threads: []int{1, 9}}}},
+					{ID: 1, Cores: []CoreSnapshot{{ID: 2, Threads: []int{2, 10}}, {ID: 3, Threads: []int{3, 11}}}},
+				},
+			},
+			{
+				ID: 1,
+				NUMANodes: []NUMANodeSnapshot{
+					{ID: 2, Cores: []CoreSnapshot{{ID: 4, Threads: []int{4, 12}}, {ID: 5, Threads: []int{5, 13}}}},
+					{ID: 3, Cores: []CoreSnapshot{
+						{ID: 6, CoreClass: CoreClassEfficiency, Threads: []int{6, 14}},
+						{ID: 7, Threads: []int{7, 15}},
+					}},
+				},
+			},
+		},
+		ReservedCPUs: NewCPUSet(0, 8),
+		OfflineCPUs:  NewCPUSet(15),
+	}
+	require.Equal(t, expected, snapshot)
+
+	// the snapshot is kept as it is through serialization
+	data, err := json.Marshal(snapshot)
+	require.NoError(t, err)
+	decoded := &TopologySnapshot{}
+	require.NoError(t, json.Unmarshal(data, decoded))
+	require.Equal(t, snapshot.Sockets, decoded.Sockets)
+	require.True(t, decoded.ReservedCPUs.Equals(NewCPUSet(0, 8)))
+	require.True(t, decoded.OfflineCPUs.Equals(NewCPUSet(15)))
+
+	// reserved cpus are copied into the snapshot
+	snapshot.ReservedCPUs.Add(1)
+	require.True(t, reservedCPUs.Equals(NewCPUSet(0, 8)))
+}
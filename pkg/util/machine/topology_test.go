@@ -755,3 +755,267 @@ func TestGetSiblingNumaInfo(t *testing.T) {
 		})
 	}
 }
+
+// generateNUMAMasks returns all the non-empty combinations of the given NUMA count
+func generateNUMAMasks(numaNum int) [][]int {
+	masks := make([][]int, 0, 1<<numaNum)
+	for mask := 1; mask < 1<<numaNum; mask++ {
+		bits := make([]int, 0, numaNum)
+		for numaID := 0; numaID < numaNum; numaID++ {
+			if mask&(1<<numaID) != 0 {
+				bits = append(bits, numaID)
+			}
+		}
+		masks = append(masks, bits)
+	}
+	return masks
+}
+
+func TestMaskCrossesSockets(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := GenerateDummyCPUTopology(64, 4, 8)
+	assert.NoError(t, err)
+	assert.NotNil(t, cpuTopology.numaSocketMap)
+
+	// topology constructed without precomputed socket membership
+	uncachedTopology := &CPUTopology{
+		NumCPUs:      cpuTopology.NumCPUs,
+		NumCores:     cpuTopology.NumCores,
+		NumSockets:   cpuTopology.NumSockets,
+		NumNUMANodes: cpuTopology.NumNUMANodes,
+		CPUDetails:   cpuTopology.CPUDetails,
+	}
+
+	for _, bits := range generateNUMAMasks(cpuTopology.NumNUMANodes) {
+		expected := len(bits) > 1 && cpuTopology.CPUDetails.SocketsInNUMANodes(bits...).Size() > 1
+
+		assert.Equal(t, expected, cpuTopology.MaskCrossesSockets(bits), "bits: %v", bits)
+		assert.Equal(t, expected, uncachedTopology.MaskCrossesSockets(bits), "bits: %v", bits)
+
+		crossSockets, err := CheckNUMACrossSockets(bits, cpuTopology)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, crossSockets, "bits: %v", bits)
+	}
+
+	assert.False(t, cpuTopology.MaskCrossesSockets([]int{0, 1}))
+	assert.True(t, cpuTopology.MaskCrossesSockets([]int{1, 2}))
+	assert.True(t, cpuTopology.MaskCrossesSockets([]int{0, 7}))
+
+	_, err = CheckNUMACrossSockets([]int{0, 1}, nil)
+	assert.Error(t, err)
+}
+
+func TestFullCoresInCPUs(t *testing.T) {
+	t.Parallel()
+
+	// core k consists of cpu k and cpu k+8
+	cpuTopology, err := GenerateDummyCPUTopology(16, 2, 4)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name string
+		cpus CPUSet
+		want CPUSet
+	}{
+		{
+			name: "empty cpus",
+			cpus: NewCPUSet(),
+			want: NewCPUSet(),
+		},
+		{
+			name: "siblings on different cores",
+			cpus: NewCPUSet(4, 13),
+			want: NewCPUSet(),
+		},
+		{
+			name: "full and partial cores",
+			cpus: NewCPUSet(6, 7, 14),
+			want: NewCPUSet(6),
+		},
+		{
+			name: "all cpus",
+			cpus: cpuTopology.CPUDetails.CPUs(),
+			want: cpuTopology.CPUDetails.Cores(),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.True(t, tt.want.Equals(cpuTopology.CPUDetails.FullCoresInCPUs(tt.cpus)))
+		})
+	}
+}
+
+func BenchmarkMaskCrossesSockets(b *testing.B) {
+	cpuTopology, err := GenerateDummyCPUTopology(64, 4, 8)
+	assert.NoError(b, err)
+
+	uncachedTopology := &CPUTopology{
+		NumCPUs:      cpuTopology.NumCPUs,
+		NumCores:     cpuTopology.NumCores,
+		NumSockets:   cpuTopology.NumSockets,
+		NumNUMANodes: cpuTopology.NumNUMANodes,
+		CPUDetails:   cpuTopology.CPUDetails,
+	}
+
+	masks := generateNUMAMasks(cpuTopology.NumNUMANodes)
+	for name, topology := range map[string]*CPUTopology{
+		"cached":   cpuTopology,
+		"uncached": uncachedTopology,
+	} {
+		topology := topology
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, bits := range masks {
+					_ = topology.MaskCrossesSockets(bits)
+				}
+			}
+		})
+	}
+}
+
+func TestCoreClasses(t *testing.T) {
+	t.Parallel()
+
+	uniformTopology, err := GenerateDummyCPUTopology(16, 2, 4)
+	assert.NoError(t, err)
+	assert.False(t, uniformTopology.IsHybrid())
+	assert.True(t, uniformTopology.CPUDetails.CPUsInCoreClasses(CoreClassPerformance).IsEmpty())
+
+	// each NUMA has 2 cores, and the second one is an efficiency core
+	hybridTopology, err := GenerateDummyHybridCPUTopology(16, 2, 4, 1)
+	assert.NoError(t, err)
+	assert.True(t, hybridTopology.IsHybrid())
+	assert.Equal(t, NewCPUSet(0, 2, 4, 6, 8, 10, 12, 14),
+		hybridTopology.CPUDetails.CPUsInCoreClasses(CoreClassPerformance))
+	assert.Equal(t, NewCPUSet(1, 3, 5, 7, 9, 11, 13, 15),
+		hybridTopology.CPUDetails.CPUsInCoreClasses(CoreClassEfficiency))
+	assert.Equal(t, hybridTopology.CPUDetails.CPUs(),
+		hybridTopology.CPUDetails.CPUsInCoreClasses(CoreClassPerformance, CoreClassEfficiency))
+
+	_, err = GenerateDummyHybridCPUTopology(16, 2, 4, 3)
+	assert.Error(t, err)
+}
+
+func TestL3CacheDomains(t *testing.T) {
+	t.Parallel()
+
+	// each NUMA has 8 cores divided into 2 cache domains, e.g. cores 0-3 (cpus 0-3,16-19) share L3 cache 0
+	topology, err := GenerateDummyL3CacheCPUTopology(32, 2, 2, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, NewCPUSet(0, 4, 8, 12), topology.CPUDetails.L3CacheDomains())
+	assert.Equal(t, NewCPUSet(4, 5, 6, 7, 20, 21, 22, 23), topology.CPUDetails.CPUsInL3CacheDomains(4))
+
+	_, err = GenerateDummyL3CacheCPUTopology(32, 2, 2, 3)
+	assert.Error(t, err)
+
+	tests := []struct {
+		name    string
+		cpus    CPUSet
+		n       int
+		want    CPUSet
+		wantErr bool
+	}{
+		{
+			name: "single domain with the smaller id among ties",
+			cpus: topology.CPUDetails.CPUsInNUMANodes(0),
+			n:    3,
+			want: NewCPUSet(0, 1, 2, 3, 16, 17, 18, 19),
+		},
+		{
+			name: "single domain with the fewest cpus covering the request",
+			cpus: NewCPUSet(0, 1, 16, 4, 5, 6, 7, 20, 21),
+			n:    3,
+			want: NewCPUSet(0, 1, 16),
+		},
+		{
+			name: "larger domain first and the fewest cpus covering what's left",
+			cpus: NewCPUSet(0, 1, 16, 4, 5, 20, 8, 24),
+			n:    5,
+			want: NewCPUSet(0, 1, 16, 8, 24),
+		},
+		{
+			name:    "not enough cpus",
+			cpus:    NewCPUSet(0, 16),
+			n:       3,
+			wantErr: true,
+		},
+		{
+			name:    "cpu out of topology",
+			cpus:    NewCPUSet(0, 64),
+			n:       1,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.cpus.FewestL3CacheDomainsFor(tt.n, topology)
+		if tt.wantErr {
+			assert.Error(t, err, tt.name)
+			continue
+		}
+
+		assert.NoError(t, err, tt.name)
+		assert.True(t, tt.want.Equals(got), "%s: want %s, got %s", tt.name, tt.want.String(), got.String())
+	}
+}
+
+func TestTakeSpreadByTopology(t *testing.T) {
+	t.Parallel()
+
+	// cpu k and k+16 are siblings of core k, cores 0-7 are in NUMA 0 of socket 0
+	topology, err := GenerateDummyCPUTopology(32, 2, 2)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		cpus    CPUSet
+		n       int
+		want    CPUSet
+		wantErr bool
+	}{
+		{
+			name: "one cpu per core while possible",
+			cpus: topology.CPUDetails.CPUsInNUMANodes(0),
+			n:    4,
+			want: NewCPUSet(0, 1, 2, 3),
+		},
+		{
+			name: "siblings are taken after all cores get one",
+			cpus: NewCPUSet(0, 1, 2, 16, 17, 18),
+			n:    4,
+			want: NewCPUSet(0, 1, 2, 16),
+		},
+		{
+			name: "spread across sockets",
+			cpus: NewCPUSet(0, 1, 8, 9),
+			n:    2,
+			want: NewCPUSet(0, 8),
+		},
+		{
+			name:    "not enough cpus",
+			cpus:    NewCPUSet(0, 16),
+			n:       3,
+			wantErr: true,
+		},
+		{
+			name:    "cpu out of topology",
+			cpus:    NewCPUSet(0, 64),
+			n:       1,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.cpus.TakeSpreadByTopology(tt.n, topology)
+		if tt.wantErr {
+			assert.Error(t, err, tt.name)
+			continue
+		}
+
+		assert.NoError(t, err, tt.name)
+		assert.True(t, tt.want.Equals(got), "%s: want %s, got %s", tt.name, tt.want.String(), got.String())
+	}
+}
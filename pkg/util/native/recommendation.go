@@ -0,0 +1,100 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package native
+
+import (
+	"math"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// ResourceRecommendOptions configures how requests and limits are recommended from usage history
+type ResourceRecommendOptions struct {
+	// RequestPercentile is the percentile (in [0, 100]) of usage samples taken as the floor of recommended
+	// requests, i.e. requests are the larger of the average usage and the usage at this percentile
+	RequestPercentile float64
+	// LimitPercentile is the percentile (in [0, 100]) of usage samples taken as recommended limits,
+	// non-positive value means limits aren't recommended
+	LimitPercentile float64
+	// HeadroomFactor scales recommended requests and limits to leave headroom above the usage,
+	// values less than 1 are treated as 1
+	HeadroomFactor float64
+}
+
+// RecommendResourceRequirements recommends requests (and limits) of each resource from its window of usage samples,
+// limits are no less than requests; nil is returned if there is no usage sample of any resource.
+func RecommendResourceRequirements(history map[v1.ResourceName][]resource.Quantity,
+	options ResourceRecommendOptions,
+) *v1.ResourceRequirements {
+	headroomFactor := math.Max(options.HeadroomFactor, 1)
+	requests, limits := v1.ResourceList{}, v1.ResourceList{}
+	for resourceName, samples := range history {
+		if len(samples) == 0 {
+			continue
+		}
+
+		// samples are copied since they're sorted in place by percentile calculation
+		sortedSamples := make([]resource.Quantity, len(samples))
+		copy(sortedSamples, samples)
+
+		request := aggregateAvgResourceQuantities(resourceName, sortedSamples)
+		if floor := general.GetQuantityByPercentile(sortedSamples, options.RequestPercentile); floor.Cmp(request) > 0 {
+			request = floor
+		}
+		request = MultiplyResourceQuantity(resourceName, request, headroomFactor)
+		requests[resourceName] = request
+
+		if options.LimitPercentile <= 0 {
+			continue
+		}
+
+		limit := MultiplyResourceQuantity(resourceName,
+			general.GetQuantityByPercentile(sortedSamples, options.LimitPercentile), headroomFactor)
+		if limit.Cmp(request) < 0 {
+			limit = request.DeepCopy()
+		}
+		limits[resourceName] = limit
+	}
+
+	if len(requests) == 0 {
+		return nil
+	}
+
+	resourceRequirements := &v1.ResourceRequirements{Requests: requests}
+	if len(limits) > 0 {
+		resourceRequirements.Limits = limits
+	}
+	return resourceRequirements
+}
+
+// aggregateAvgResourceQuantities gets the average of non-empty quantities according to the resource name,
+// average of cpu is calculated with milli-value to avoid being rounded to integral cores.
+func aggregateAvgResourceQuantities(resourceName v1.ResourceName, quantities []resource.Quantity) resource.Quantity {
+	if resourceName != v1.ResourceCPU {
+		return *AggregateAvgQuantities(quantities)
+	}
+
+	milliQuantities := make([]resource.Quantity, 0, len(quantities))
+	for _, quantity := range quantities {
+		milliQuantities = append(milliQuantities, *resource.NewQuantity(quantity.MilliValue(), quantity.Format))
+	}
+	avg := AggregateAvgQuantities(milliQuantities)
+	return *resource.NewMilliQuantity(avg.Value(), avg.Format)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package native
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func parseQuantities(values ...string) []resource.Quantity {
+	quantities := make([]resource.Quantity, 0, len(values))
+	for _, value := range values {
+		quantities = append(quantities, resource.MustParse(value))
+	}
+	return quantities
+}
+
+func TestRecommendResourceRequirements(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		history  map[v1.ResourceName][]resource.Quantity
+		options  ResourceRecommendOptions
+		requests map[v1.ResourceName]string
+		limits   map[v1.ResourceName]string
+	}{
+		{
+			name:    "nil history",
+			history: nil,
+			options: ResourceRecommendOptions{RequestPercentile: 50, LimitPercentile: 100, HeadroomFactor: 1.2},
+		},
+		{
+			name: "empty windows",
+			history: map[v1.ResourceName][]resource.Quantity{
+				v1.ResourceCPU:    {},
+				v1.ResourceMemory: nil,
+			},
+			options: ResourceRecommendOptions{RequestPercentile: 50, LimitPercentile: 100, HeadroomFactor: 1.2},
+		},
+		{
+			name: "average above percentile floor with headroom",
+			history: map[v1.ResourceName][]resource.Quantity{
+				v1.ResourceCPU:    parseQuantities("500m", "2", "1", "1500m"),
+				v1.ResourceMemory: parseQuantities("1Gi", "10Gi", "2Gi", "3Gi"),
+			},
+			options: ResourceRecommendOptions{RequestPercentile: 50, LimitPercentile: 100, HeadroomFactor: 1.5},
+			requests: map[v1.ResourceName]string{
+				v1.ResourceCPU:    "1875m",
+				v1.ResourceMemory: "6Gi",
+			},
+			limits: map[v1.ResourceName]string{
+				v1.ResourceCPU:    "3",
+				v1.ResourceMemory: "15Gi",
+			},
+		},
+		{
+			name: "percentile floor above average of spiky usage without limits",
+			history: map[v1.ResourceName][]resource.Quantity{
+				v1.ResourceCPU: parseQuantities("100m", "100m", "100m", "100m", "100m",
+					"100m", "100m", "100m", "100m", "2"),
+			},
+			options: ResourceRecommendOptions{RequestPercentile: 95},
+			requests: map[v1.ResourceName]string{
+				v1.ResourceCPU: "2",
+			},
+		},
+		{
+			name: "limits are no less than requests",
+			history: map[v1.ResourceName][]resource.Quantity{
+				v1.ResourceMemory: parseQuantities("1Gi", "1Gi", "1Gi", "5Gi"),
+			},
+			options: ResourceRecommendOptions{RequestPercentile: 10, LimitPercentile: 50, HeadroomFactor: 0.5},
+			requests: map[v1.ResourceName]string{
+				v1.ResourceMemory: "2Gi",
+			},
+			limits: map[v1.ResourceName]string{
+				v1.ResourceMemory: "2Gi",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// samples are kept in their original order
+			var originalHistory map[v1.ResourceName][]resource.Quantity
+			if tt.history != nil {
+				originalHistory = make(map[v1.ResourceName][]resource.Quantity, len(tt.history))
+				for resourceName, samples := range tt.history {
+					if samples != nil {
+						originalHistory[resourceName] = append(make([]resource.Quantity, 0, len(samples)), samples...)
+					} else {
+						originalHistory[resourceName] = nil
+					}
+				}
+			}
+
+			got := RecommendResourceRequirements(tt.history, tt.options)
+			assert.Equal(t, originalHistory, tt.history)
+			if len(tt.requests) == 0 {
+				assert.Nil(t, got)
+				return
+			}
+
+			assert.NotNil(t, got)
+			assert.Len(t, got.Requests, len(tt.requests))
+			for resourceName, expected := range tt.requests {
+				actual := got.Requests[resourceName]
+				assert.Zerof(t, actual.Cmp(resource.MustParse(expected)), "request of %v: expected %v, got %v",
+					resourceName, expected, actual.String())
+			}
+
+			assert.Len(t, got.Limits, len(tt.limits))
+			for resourceName, expected := range tt.limits {
+				actual := got.Limits[resourceName]
+				assert.Zerof(t, actual.Cmp(resource.MustParse(expected)), "limit of %v: expected %v, got %v",
+					resourceName, expected, actual.String())
+			}
+		})
+	}
+}
@@ -24,7 +24,9 @@ import (
 	v1 "k8s.io/api/core/v1"
 
 	"github.com/kubewharf/katalyst-api/pkg/consts"
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
 	"github.com/kubewharf/katalyst-core/pkg/config/generic"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
 )
 
 // GetPodCPUSuppressionToleranceRate parses cpu suppression tolerance rate for the given pod,
@@ -48,3 +50,124 @@ func GetPodCPUSuppressionToleranceRate(qosConf *generic.QoSConfiguration, pod *v
 
 	return math.MaxFloat64, nil
 }
+
+// AnnotationsIndicateSoftNUMABinding checks whether the numa_binding container is allowed to
+// degrade to be without NUMA binding if it can't fit into any single NUMA.
+func AnnotationsIndicateSoftNUMABinding(annotations map[string]string) bool {
+	return annotations[cpuconsts.PodAnnotationCPUNUMABindingSoft] == cpuconsts.PodAnnotationCPUNUMABindingSoftEnable
+}
+
+// AnnotationsIndicateFullPhysicalCores checks whether the container asks for whole physical cores
+func AnnotationsIndicateFullPhysicalCores(annotations map[string]string) bool {
+	return annotations[cpuconsts.PodAnnotationCPUFullPhysicalCores] == cpuconsts.PodAnnotationCPUFullPhysicalCoresEnable
+}
+
+// AnnotationsIndicateSpreadCores checks whether the container asks for cpus spread across physical cores
+func AnnotationsIndicateSpreadCores(annotations map[string]string) bool {
+	return annotations[cpuconsts.PodAnnotationCPUSpreadCores] == cpuconsts.PodAnnotationCPUSpreadCoresEnable
+}
+
+// AnnotationsIndicateL3CachePacking checks whether the container asks for cpus packed within the fewest L3 cache domains
+func AnnotationsIndicateL3CachePacking(annotations map[string]string) bool {
+	return annotations[cpuconsts.PodAnnotationCPUL3CachePacking] == cpuconsts.PodAnnotationCPUL3CachePackingEnable
+}
+
+// AnnotationsIndicateCoreExclusive checks whether the numa_exclusive container only takes up
+// whole physical cores exclusively rather than whole NUMAs.
+func AnnotationsIndicateCoreExclusive(annotations map[string]string) bool {
+	return AnnotationsIndicateNUMAExclusive(annotations) &&
+		annotations[cpuconsts.PodAnnotationCPUNUMAExclusiveMode] == cpuconsts.NUMAExclusiveModeCore
+}
+
+// GetSidecarCPUSetMode returns the cpuset mode of sidecars in the annotations,
+// and SidecarCPUSetModeShared is returned if it's not set.
+func GetSidecarCPUSetMode(annotations map[string]string) string {
+	if mode, ok := annotations[cpuconsts.PodAnnotationCPUSidecarCPUSetMode]; ok {
+		return mode
+	}
+	return cpuconsts.SidecarCPUSetModeShared
+}
+
+// GetCoreClass returns the core class targeted in the annotations, found is false if it's not set.
+func GetCoreClass(annotations map[string]string) (coreClass machine.CoreClass, found bool) {
+	value, found := annotations[cpuconsts.PodAnnotationCPUCoreClass]
+	return machine.CoreClass(value), found
+}
+
+// GetNUMASpreadKey returns the label key identifying replicas of the workload to be spread across NUMAs,
+// found is false if it's not set.
+func GetNUMASpreadKey(annotations map[string]string) (labelKey string, found bool) {
+	labelKey = annotations[cpuconsts.PodAnnotationCPUNUMASpreadKey]
+	return labelKey, labelKey != ""
+}
+
+// ParseNUMABindingPreferredNUMA parses the preferred NUMA in the annotations, found is false if it's not set.
+func ParseNUMABindingPreferredNUMA(annotations map[string]string) (numaID int, found bool, err error) {
+	value, found := annotations[cpuconsts.PodAnnotationCPUNUMABindingPreferredNUMA]
+	if !found {
+		return 0, false, nil
+	}
+
+	numaID, err = strconv.Atoi(value)
+	if err != nil || numaID < 0 {
+		return 0, true, fmt.Errorf("invalid %s: %s", cpuconsts.PodAnnotationCPUNUMABindingPreferredNUMA, value)
+	}
+	return numaID, true, nil
+}
+
+// ParseSocketAffinity parses the socket id in the annotations, found is false if it's not set.
+func ParseSocketAffinity(annotations map[string]string) (socketID int, found bool, err error) {
+	value, found := annotations[cpuconsts.PodAnnotationCPUSocketAffinity]
+	if !found {
+		return 0, false, nil
+	}
+
+	socketID, err = strconv.Atoi(value)
+	if err != nil || socketID < 0 {
+		return 0, true, fmt.Errorf("invalid %s: %s", cpuconsts.PodAnnotationCPUSocketAffinity, value)
+	}
+	return socketID, true, nil
+}
+
+// ParseNUMAConstraint parses NUMA nodes (in cpuset format) in the annotations, found is false if it's not set.
+func ParseNUMAConstraint(annotations map[string]string) (numaConstraint machine.CPUSet, found bool, err error) {
+	value, found := annotations[cpuconsts.PodAnnotationCPUNUMAConstraint]
+	if !found {
+		return machine.NewCPUSet(), false, nil
+	}
+
+	numaConstraint, err = machine.Parse(value)
+	if err != nil {
+		return machine.NewCPUSet(), true, fmt.Errorf("invalid %s: %s", cpuconsts.PodAnnotationCPUNUMAConstraint, value)
+	}
+	return numaConstraint, true, nil
+}
+
+// ParseNUMABindingGrowthFactor parses the growth factor in the annotations, found is false if it's not set.
+func ParseNUMABindingGrowthFactor(annotations map[string]string) (growthFactor float64, found bool, err error) {
+	return parsePositiveFloatEnhancement(annotations, cpuconsts.PodAnnotationCPUNUMABindingGrowthFactor)
+}
+
+// ParseCPUSharesWeight parses the cpu shares weight in the annotations, found is false if it's not set.
+func ParseCPUSharesWeight(annotations map[string]string) (weight float64, found bool, err error) {
+	return parsePositiveFloatEnhancement(annotations, cpuconsts.PodAnnotationCPUSharesWeight)
+}
+
+// ParseSidecarReservedCPU parses the cpu quantity reserved for sidecars to be injected later
+// in the annotations, found is false if it's not set.
+func ParseSidecarReservedCPU(annotations map[string]string) (reserved float64, found bool, err error) {
+	return parsePositiveFloatEnhancement(annotations, cpuconsts.PodAnnotationCPUSidecarReservedCPU)
+}
+
+func parsePositiveFloatEnhancement(annotations map[string]string, key string) (float64, bool, error) {
+	value, found := annotations[key]
+	if !found {
+		return 0, false, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f <= 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, true, fmt.Errorf("invalid %s: %s", key, value)
+	}
+	return f, true, nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package qos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubewharf/katalyst-api/pkg/consts"
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+func TestParseCPUEnhancements(t *testing.T) {
+	t.Parallel()
+
+	annotations := map[string]string{
+		cpuconsts.PodAnnotationCPUNUMABindingPreferredNUMA: "1",
+		cpuconsts.PodAnnotationCPUNUMAConstraint:           "0-1",
+		cpuconsts.PodAnnotationCPUNUMABindingGrowthFactor:  "1.5",
+		cpuconsts.PodAnnotationCPUSharesWeight:             "2",
+		cpuconsts.PodAnnotationCPUNUMABindingSoft:          "true",
+		cpuconsts.PodAnnotationCPUFullPhysicalCores:        "true",
+		cpuconsts.PodAnnotationCPUSpreadCores:              "true",
+		cpuconsts.PodAnnotationCPUSidecarCPUSetMode:        cpuconsts.SidecarCPUSetModeIsolated,
+		cpuconsts.PodAnnotationCPUSidecarReservedCPU:       "0.5",
+		cpuconsts.PodAnnotationCPUCoreClass:                "efficiency",
+		cpuconsts.PodAnnotationCPUL3CachePacking:           "true",
+		cpuconsts.PodAnnotationCPUNUMASpreadKey:            "app",
+		cpuconsts.PodAnnotationCPUSocketAffinity:           "1",
+	}
+
+	numaID, found, err := ParseNUMABindingPreferredNUMA(annotations)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 1, numaID)
+
+	socketID, found, err := ParseSocketAffinity(annotations)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 1, socketID)
+
+	numaConstraint, found, err := ParseNUMAConstraint(annotations)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "0-1", numaConstraint.String())
+
+	growthFactor, found, err := ParseNUMABindingGrowthFactor(annotations)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 1.5, growthFactor)
+
+	weight, found, err := ParseCPUSharesWeight(annotations)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, float64(2), weight)
+
+	reserved, found, err := ParseSidecarReservedCPU(annotations)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 0.5, reserved)
+
+	assert.True(t, AnnotationsIndicateSoftNUMABinding(annotations))
+	assert.True(t, AnnotationsIndicateFullPhysicalCores(annotations))
+	assert.True(t, AnnotationsIndicateSpreadCores(annotations))
+	assert.True(t, AnnotationsIndicateL3CachePacking(annotations))
+	assert.Equal(t, cpuconsts.SidecarCPUSetModeIsolated, GetSidecarCPUSetMode(annotations))
+	assert.False(t, AnnotationsIndicateCoreExclusive(annotations))
+	coreClass, found := GetCoreClass(annotations)
+	assert.True(t, found)
+	assert.Equal(t, machine.CoreClassEfficiency, coreClass)
+	spreadKey, found := GetNUMASpreadKey(annotations)
+	assert.True(t, found)
+	assert.Equal(t, "app", spreadKey)
+
+	// keys not set
+	_, found, err = ParseNUMABindingPreferredNUMA(nil)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	_, found, err = ParseSocketAffinity(nil)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	_, found, err = ParseCPUSharesWeight(nil)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, cpuconsts.SidecarCPUSetModeShared, GetSidecarCPUSetMode(nil))
+	_, found = GetCoreClass(nil)
+	assert.False(t, found)
+	_, found = GetNUMASpreadKey(nil)
+	assert.False(t, found)
+
+	// invalid values
+	invalidAnnotations := map[string]string{
+		cpuconsts.PodAnnotationCPUNUMABindingPreferredNUMA: "-1",
+		cpuconsts.PodAnnotationCPUNUMAConstraint:           "a-b",
+		cpuconsts.PodAnnotationCPUNUMABindingGrowthFactor:  "NaN",
+		cpuconsts.PodAnnotationCPUSharesWeight:             "0",
+		cpuconsts.PodAnnotationCPUSidecarReservedCPU:       "-1",
+		cpuconsts.PodAnnotationCPUSocketAffinity:           "-1",
+	}
+	_, found, err = ParseNUMABindingPreferredNUMA(invalidAnnotations)
+	assert.Error(t, err)
+	assert.True(t, found)
+	_, _, err = ParseSocketAffinity(invalidAnnotations)
+	assert.Error(t, err)
+	_, _, err = ParseNUMAConstraint(invalidAnnotations)
+	assert.Error(t, err)
+	_, _, err = ParseNUMABindingGrowthFactor(invalidAnnotations)
+	assert.Error(t, err)
+	_, _, err = ParseCPUSharesWeight(invalidAnnotations)
+	assert.Error(t, err)
+	_, _, err = ParseSidecarReservedCPU(invalidAnnotations)
+	assert.Error(t, err)
+
+	// core exclusive only takes effect with numa_exclusive
+	assert.True(t, AnnotationsIndicateCoreExclusive(map[string]string{
+		consts.PodAnnotationMemoryEnhancementNumaBinding:   consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		consts.PodAnnotationMemoryEnhancementNumaExclusive: consts.PodAnnotationMemoryEnhancementNumaExclusiveEnable,
+		cpuconsts.PodAnnotationCPUNUMAExclusiveMode:        cpuconsts.NUMAExclusiveModeCore,
+	}))
+	assert.False(t, AnnotationsIndicateCoreExclusive(map[string]string{
+		consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		cpuconsts.PodAnnotationCPUNUMAExclusiveMode:      cpuconsts.NUMAExclusiveModeCore,
+	}))
+}